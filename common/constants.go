@@ -131,6 +131,8 @@ var CohereSafetySetting string
 
 const (
 	RequestIdKey = "X-Oneapi-Request-Id"
+	// RequestIdHeader 与 RequestIdKey 相同的请求 ID，以通用的响应头名称返回，便于用户反馈问题时提供
+	RequestIdHeader = "X-Request-Id"
 )
 
 const (
@@ -198,6 +200,7 @@ const (
 	ChannelStatusEnabled          = 1 // don't use 0, 0 is the default value!
 	ChannelStatusManuallyDisabled = 2 // also don't use 0
 	ChannelStatusAutoDisabled     = 3
+	ChannelStatusPaused           = 4 // 暂停（如维护），不参与渠道选择，也不会被自动禁用或启用
 )
 
 const (
@@ -0,0 +1,95 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// MemoryLeakyBucket 内存版本的漏桶，语义与 lua/leaky_bucket.lua 相同，用于未启用 Redis 的情况
+type MemoryLeakyBucket struct {
+	mutex     sync.Mutex
+	buckets   map[string]*leakyState
+	sweepOnce sync.Once
+}
+
+type leakyState struct {
+	level    float64
+	lastTime time.Time
+	rate     int64 // 最近一次使用的漏出速率，清理时据此判断是否已漏空
+}
+
+func NewMemoryLeakyBucket() *MemoryLeakyBucket {
+	return &MemoryLeakyBucket{buckets: make(map[string]*leakyState)}
+}
+
+// Init 与 InMemoryRateLimiter.Init 相同，首次调用时启动定期清理，每隔 expirationDuration 删除已漏空的桶
+func (b *MemoryLeakyBucket) Init(expirationDuration time.Duration) {
+	if expirationDuration <= 0 {
+		return
+	}
+	b.sweepOnce.Do(func() {
+		go b.clearDrainedBuckets(expirationDuration)
+	})
+}
+
+func (b *MemoryLeakyBucket) clearDrainedBuckets(expirationDuration time.Duration) {
+	for {
+		time.Sleep(expirationDuration)
+		b.evictDrained(time.Now())
+	}
+}
+
+// evictDrained 删除到 now 时已漏空的桶，漏空的桶与不存在的桶等价
+func (b *MemoryLeakyBucket) evictDrained(now time.Time) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	for key, state := range b.buckets {
+		if state.level <= now.Sub(state.lastTime).Seconds()*float64(state.rate) {
+			delete(b.buckets, key)
+		}
+	}
+}
+
+// leak 按经过的时间漏出水量，返回当前水位，调用方需持有锁
+func (b *MemoryLeakyBucket) leak(key string, rate int64, now time.Time) *leakyState {
+	state, ok := b.buckets[key]
+	if !ok {
+		state = &leakyState{lastTime: now}
+		b.buckets[key] = state
+	}
+	elapsed := now.Sub(state.lastTime).Seconds()
+	if elapsed > 0 {
+		state.level = math.Max(0, state.level-elapsed*float64(rate))
+	}
+	state.lastTime = now
+	state.rate = rate
+	return state
+}
+
+// Check 检查漏桶是否还能注入 Requested 的水量，不改变水位
+func (b *MemoryLeakyBucket) Check(key string, opts ...Option) (bool, time.Duration) {
+	config := newConfig(opts...)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	state := b.leak(key, config.Rate, time.Now())
+	overflow := state.level + float64(config.Requested) - float64(config.Capacity)
+	if overflow <= 0 {
+		return true, 0
+	}
+	if config.Rate <= 0 {
+		return false, -1
+	}
+	return false, time.Duration(math.Ceil(overflow*1000/float64(config.Rate))) * time.Millisecond
+}
+
+// Add 向漏桶注入 Requested 的水量
+func (b *MemoryLeakyBucket) Add(key string, opts ...Option) {
+	config := newConfig(opts...)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	state := b.leak(key, config.Rate, time.Now())
+	state.level += float64(config.Requested)
+}
@@ -5,6 +5,7 @@ import (
 	_ "embed"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/go-redis/redis/v8"
@@ -13,9 +14,33 @@ import (
 //go:embed lua/rate_limit.lua
 var rateLimitScript string
 
+//go:embed lua/rate_limit_reserve.lua
+var rateLimitReserveScript string
+
+//go:embed lua/rate_limit_peek.lua
+var rateLimitPeekScript string
+
+//go:embed lua/leaky_bucket.lua
+var leakyBucketScript string
+
+//go:embed lua/rate_limit_refund.lua
+var rateLimitRefundScript string
+
+//go:embed lua/rate_limit_charge.lua
+var rateLimitChargeScript string
+
+// 所有限流脚本统一使用 redis.Script 执行：优先 EVALSHA，脚本未加载（如 Redis 重启或使用只读副本）时自动回退为 EVAL
+var (
+	limitScript   = redis.NewScript(rateLimitScript)
+	reserveScript = redis.NewScript(rateLimitReserveScript)
+	peekScript    = redis.NewScript(rateLimitPeekScript)
+	leakyScript   = redis.NewScript(leakyBucketScript)
+	refundScript  = redis.NewScript(rateLimitRefundScript)
+	chargeScript  = redis.NewScript(rateLimitChargeScript)
+)
+
 type RedisLimiter struct {
-	client         *redis.Client
-	limitScriptSHA string
+	client *redis.Client
 }
 
 var (
@@ -26,36 +51,32 @@ var (
 func New(ctx context.Context, r *redis.Client) *RedisLimiter {
 	once.Do(func() {
 		// 预加载脚本
-		limitSHA, err := r.ScriptLoad(ctx, rateLimitScript).Result()
-		if err != nil {
-			common.SysLog(fmt.Sprintf("Failed to load rate limit script: %v", err))
-		}
-		instance = &RedisLimiter{
-			client:         r,
-			limitScriptSHA: limitSHA,
+		for name, script := range map[string]*redis.Script{
+			"rate limit":         limitScript,
+			"rate limit reserve": reserveScript,
+			"rate limit peek":    peekScript,
+			"leaky bucket":       leakyScript,
+			"rate limit refund":  refundScript,
+			"rate limit charge":  chargeScript,
+			"sliding window":     slidingWindowScript,
+		} {
+			if err := script.Load(ctx, r).Err(); err != nil {
+				common.SysLog(fmt.Sprintf("Failed to load %s script: %v", name, err))
+			}
 		}
+		instance = &RedisLimiter{client: r}
 	})
 
 	return instance
 }
 
 func (rl *RedisLimiter) Allow(ctx context.Context, key string, opts ...Option) (bool, error) {
-	// 默认配置
-	config := &Config{
-		Capacity:  10,
-		Rate:      1,
-		Requested: 1,
-	}
-
-	// 应用选项模式
-	for _, opt := range opts {
-		opt(config)
-	}
+	config := newConfig(opts...)
 
 	// 执行限流
-	result, err := rl.client.EvalSha(
+	result, err := limitScript.Run(
 		ctx,
-		rl.limitScriptSHA,
+		rl.client,
 		[]string{key},
 		config.Requested,
 		config.Rate,
@@ -68,6 +89,179 @@ func (rl *RedisLimiter) Allow(ctx context.Context, key string, opts ...Option) (
 	return result == 1, nil
 }
 
+// Reserve 与 Allow 相同，但在请求被拒绝时额外返回距离令牌补足还需等待的时长，
+// 便于设置精确的 Retry-After 或在等待后继续处理。
+// 当速率为 0（永远不会补充令牌）时返回的等待时长为 -1。
+func (rl *RedisLimiter) Reserve(ctx context.Context, key string, opts ...Option) (bool, time.Duration, error) {
+	config := newConfig(opts...)
+
+	result, err := reserveScript.Run(
+		ctx,
+		rl.client,
+		[]string{key},
+		config.Requested,
+		config.Rate,
+		config.Capacity,
+	).Int64Slice()
+
+	if err != nil {
+		return false, 0, fmt.Errorf("rate limit reserve failed: %w", err)
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("rate limit reserve returned unexpected result: %v", result)
+	}
+	if result[0] == 1 {
+		return true, 0, nil
+	}
+	if result[1] < 0 {
+		return false, -1, nil
+	}
+	return false, time.Duration(result[1]) * time.Millisecond, nil
+}
+
+// Peek 查询令牌桶当前可用的令牌数以及距离令牌补足还需等待的时长，不消耗令牌
+func (rl *RedisLimiter) Peek(ctx context.Context, key string, opts ...Option) (int64, time.Duration, error) {
+	config := newConfig(opts...)
+
+	result, err := peekScript.Run(
+		ctx,
+		rl.client,
+		[]string{key},
+		config.Requested,
+		config.Rate,
+		config.Capacity,
+	).Int64Slice()
+	return parsePeekResult(result, err)
+}
+
+// Refund 退还一次已放行请求消耗的令牌，参数需与放行时一致；桶已过期时无需退还
+func (rl *RedisLimiter) Refund(ctx context.Context, key string, opts ...Option) error {
+	config := newConfig(opts...)
+
+	err := refundScript.Run(
+		ctx,
+		rl.client,
+		[]string{key},
+		config.Requested,
+		config.Rate,
+		config.Capacity,
+	).Err()
+	if err != nil {
+		return fmt.Errorf("rate limit refund failed: %w", err)
+	}
+	return nil
+}
+
+// Charge 从令牌桶中额外扣除令牌而不做放行判断，令牌不足时扣至 0 为止
+func (rl *RedisLimiter) Charge(ctx context.Context, key string, opts ...Option) error {
+	config := newConfig(opts...)
+
+	err := chargeScript.Run(
+		ctx,
+		rl.client,
+		[]string{key},
+		config.Requested,
+		config.Rate,
+		config.Capacity,
+	).Err()
+	if err != nil {
+		return fmt.Errorf("rate limit charge failed: %w", err)
+	}
+	return nil
+}
+
+// PeekWithClient 与 Peek 相同，但使用指定的 Redis 客户端查询（如只读副本）
+func PeekWithClient(ctx context.Context, client *redis.Client, key string, opts ...Option) (int64, time.Duration, error) {
+	config := newConfig(opts...)
+
+	result, err := peekScript.Run(
+		ctx,
+		client,
+		[]string{key},
+		config.Requested,
+		config.Rate,
+		config.Capacity,
+	).Int64Slice()
+	return parsePeekResult(result, err)
+}
+
+func parsePeekResult(result []int64, err error) (int64, time.Duration, error) {
+	if err != nil {
+		return 0, 0, fmt.Errorf("rate limit peek failed: %w", err)
+	}
+	if len(result) != 2 {
+		return 0, 0, fmt.Errorf("rate limit peek returned unexpected result: %v", result)
+	}
+	if result[1] < 0 {
+		return result[0], -1, nil
+	}
+	return result[0], time.Duration(result[1]) * time.Millisecond, nil
+}
+
+// LeakyCheck 检查漏桶是否还能注入 Requested 的水量，不改变桶状态。
+// 漏桶的 Capacity 为允许的突发量，Rate 为每秒漏出的水量。
+func (rl *RedisLimiter) LeakyCheck(ctx context.Context, key string, opts ...Option) (bool, time.Duration, error) {
+	return rl.leaky(ctx, key, false, opts...)
+}
+
+// LeakyAdd 向漏桶注入 Requested 的水量，即使超过容量也会注入
+func (rl *RedisLimiter) LeakyAdd(ctx context.Context, key string, opts ...Option) error {
+	_, _, err := rl.leaky(ctx, key, true, opts...)
+	return err
+}
+
+func (rl *RedisLimiter) leaky(ctx context.Context, key string, add bool, opts ...Option) (bool, time.Duration, error) {
+	config := newConfig(opts...)
+	addFlag := 0
+	if add {
+		addFlag = 1
+	}
+
+	result, err := leakyScript.Run(
+		ctx,
+		rl.client,
+		[]string{key},
+		config.Requested,
+		config.Rate,
+		config.Capacity,
+		addFlag,
+	).Int64Slice()
+
+	if err != nil {
+		return false, 0, fmt.Errorf("leaky bucket failed: %w", err)
+	}
+	if len(result) != 2 {
+		return false, 0, fmt.Errorf("leaky bucket returned unexpected result: %v", result)
+	}
+	if result[0] == 1 {
+		return true, 0, nil
+	}
+	if result[1] < 0 {
+		return false, -1, nil
+	}
+	return false, time.Duration(result[1]) * time.Millisecond, nil
+}
+
+// ResolveConfig 返回按 opts 解析后的参数
+func ResolveConfig(opts ...Option) Config {
+	return *newConfig(opts...)
+}
+
+func newConfig(opts ...Option) *Config {
+	// 默认配置
+	config := &Config{
+		Capacity:  10,
+		Rate:      1,
+		Requested: 1,
+	}
+
+	// 应用选项模式
+	for _, opt := range opts {
+		opt(config)
+	}
+	return config
+}
+
 // Config 配置选项模式
 type Config struct {
 	Capacity  int64
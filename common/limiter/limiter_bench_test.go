@@ -0,0 +1,204 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 限流算法的吞吐量压测与并发正确性测试，默认只测试内存版本。
+// 设置 LIMITER_TEST_REDIS 为 Redis 地址时同时测试 Redis 版本，用于获取真实的判定延迟：
+//
+//	LIMITER_TEST_REDIS=localhost:6379 go test ./common/limiter -bench Reserve -run ReserveNeverExceeds
+const (
+	benchTokenBucket   = "token_bucket"
+	benchLeakyBucket   = "leaky_bucket"
+	benchSlidingWindow = "sliding_window"
+)
+
+var benchAlgorithms = []string{benchTokenBucket, benchLeakyBucket, benchSlidingWindow}
+
+// 滑动窗口记录的时间格式，定长以便按字典序比较
+const benchTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+type benchConfig struct {
+	algorithm string
+	rdb       *redis.Client // 为 nil 时使用内存版本
+	limit     int64         // 每秒允许的请求数
+	burst     int64         // 令牌桶与漏桶的容量
+}
+
+// maxAllowed 运行 elapsed 后理论上最多放行的请求数。令牌桶按整秒补充，滑动窗口按一秒的窗口判断，均按向上取整的秒数计算
+func (config benchConfig) maxAllowed(elapsed time.Duration) int64 {
+	seconds := int64(math.Ceil(elapsed.Seconds()))
+	if config.algorithm == benchSlidingWindow {
+		return config.limit * (seconds + 1)
+	}
+	return config.burst + config.limit*seconds
+}
+
+// benchBackends 返回要测试的存储，rdb 为 nil 表示内存
+func benchBackends(tb testing.TB) map[string]*redis.Client {
+	backends := map[string]*redis.Client{"memory": nil}
+	if os.Getenv("LIMITER_TEST_REDIS") != "" {
+		backends["redis"] = testRedis(tb)
+	}
+	return backends
+}
+
+// benchAllowFunc 返回按 config 判定一次请求的函数，与线上用法一致，检查与记录需是原子的
+func benchAllowFunc(tb testing.TB, config benchConfig) func() (bool, error) {
+	ctx := context.Background()
+	opts := []Option{WithCapacity(config.burst), WithRate(config.limit), WithRequested(1)}
+	if config.rdb != nil {
+		rl := New(ctx, config.rdb)
+		key := fmt.Sprintf("rateLimit:benchmark:%s:%d", config.algorithm, time.Now().UnixNano())
+		tb.Cleanup(func() { config.rdb.Del(context.Background(), key) })
+		switch config.algorithm {
+		case benchLeakyBucket:
+			var mutex sync.Mutex
+			return func() (bool, error) {
+				mutex.Lock()
+				defer mutex.Unlock()
+				allowed, _, err := rl.LeakyCheck(ctx, key, opts...)
+				if err != nil || !allowed {
+					return false, err
+				}
+				return true, rl.LeakyAdd(ctx, key, opts...)
+			}
+		case benchSlidingWindow:
+			return func() (bool, error) {
+				now := time.Now().UTC()
+				return rl.SlidingWindowReserve(ctx, key, int(config.limit), now.Format(benchTimeFormat), now.Add(-time.Second).Format(benchTimeFormat), 2*time.Second)
+			}
+		default:
+			return func() (bool, error) {
+				allowed, _, err := rl.Reserve(ctx, key, opts...)
+				return allowed, err
+			}
+		}
+	}
+
+	switch config.algorithm {
+	case benchLeakyBucket:
+		var mutex sync.Mutex
+		bucket := NewMemoryLeakyBucket()
+		return func() (bool, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			if allowed, _ := bucket.Check("benchmark", opts...); !allowed {
+				return false, nil
+			}
+			bucket.Add("benchmark", opts...)
+			return true, nil
+		}
+	case benchSlidingWindow:
+		var mutex sync.Mutex
+		window := &successWindow{}
+		return func() (bool, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			now := time.Now()
+			if !window.allow(now, int(config.limit), 1) {
+				return false, nil
+			}
+			window.record(now, int(config.limit))
+			return true, nil
+		}
+	default:
+		bucket := NewMemoryTokenBucket()
+		return func() (bool, error) {
+			allowed, _ := bucket.Reserve("benchmark", opts...)
+			return allowed, nil
+		}
+	}
+}
+
+func TestReserveNeverExceedsMaxAllowed(t *testing.T) {
+	for backend, rdb := range benchBackends(t) {
+		for _, algorithm := range benchAlgorithms {
+			for _, concurrency := range []int{1, 16, 64} {
+				config := benchConfig{algorithm: algorithm, rdb: rdb, limit: 100, burst: 50}
+				t.Run(fmt.Sprintf("%s/%s/c%d", backend, algorithm, concurrency), func(t *testing.T) {
+					allow := benchAllowFunc(t, config)
+					var allowed, errCount int64
+					var wg sync.WaitGroup
+					start := time.Now()
+					deadline := start.Add(300 * time.Millisecond)
+					for i := 0; i < concurrency; i++ {
+						wg.Add(1)
+						go func() {
+							defer wg.Done()
+							for time.Now().Before(deadline) {
+								ok, err := allow()
+								if err != nil {
+									atomic.AddInt64(&errCount, 1)
+								} else if ok {
+									atomic.AddInt64(&allowed, 1)
+								}
+							}
+						}()
+					}
+					wg.Wait()
+					if errCount > 0 {
+						t.Fatalf("%d errors", errCount)
+					}
+					if maxAllowed := config.maxAllowed(time.Since(start)); allowed > maxAllowed {
+						t.Fatalf("allowed %d requests, max %d", allowed, maxAllowed)
+					}
+					if allowed == 0 {
+						t.Fatal("no request allowed")
+					}
+				})
+			}
+		}
+	}
+}
+
+func benchmarkReserve(b *testing.B, algorithm string) {
+	for backend, rdb := range benchBackends(b) {
+		for _, parallelism := range []int{1, 8, 64} {
+			b.Run(fmt.Sprintf("%s/p%d", backend, parallelism), func(b *testing.B) {
+				config := benchConfig{algorithm: algorithm, rdb: rdb, limit: 1000, burst: 1000}
+				allow := benchAllowFunc(b, config)
+				var allowed int64
+				b.SetParallelism(parallelism)
+				b.ResetTimer()
+				start := time.Now()
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						if ok, err := allow(); err != nil {
+							b.Error(err)
+							return
+						} else if ok {
+							atomic.AddInt64(&allowed, 1)
+						}
+					}
+				})
+				if maxAllowed := config.maxAllowed(time.Since(start)); allowed > maxAllowed {
+					b.Fatalf("allowed %d requests, max %d", allowed, maxAllowed)
+				}
+				b.ReportMetric(float64(allowed)/float64(b.N), "allowed/op")
+			})
+		}
+	}
+}
+
+func BenchmarkReserveTokenBucket(b *testing.B) {
+	benchmarkReserve(b, benchTokenBucket)
+}
+
+func BenchmarkReserveLeakyBucket(b *testing.B) {
+	benchmarkReserve(b, benchLeakyBucket)
+}
+
+func BenchmarkReserveSlidingWindow(b *testing.B) {
+	benchmarkReserve(b, benchSlidingWindow)
+}
@@ -0,0 +1,198 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// testRedis 返回 LIMITER_TEST_REDIS 指定的 Redis 客户端，未设置时跳过依赖 Redis 的测试
+func testRedis(tb testing.TB) *redis.Client {
+	tb.Helper()
+	addr := os.Getenv("LIMITER_TEST_REDIS")
+	if addr == "" {
+		tb.Skip("LIMITER_TEST_REDIS not set")
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	if err := rdb.Ping(context.Background()).Err(); err != nil {
+		tb.Fatalf("LIMITER_TEST_REDIS=%s unreachable: %v", addr, err)
+	}
+	tb.Cleanup(func() { _ = rdb.Close() })
+	return rdb
+}
+
+// testKey 返回测试专用的 key，测试结束后删除
+func testKey(tb testing.TB, rdb *redis.Client, name string) string {
+	key := fmt.Sprintf("rateLimit:test:%s:%d", name, time.Now().UnixNano())
+	tb.Cleanup(func() { rdb.Del(context.Background(), key) })
+	return key
+}
+
+// alignToSecond 等到下一秒开始，令牌桶按整秒补充，避免测试中途跨秒补充令牌
+func alignToSecond() {
+	now := time.Now()
+	time.Sleep(now.Truncate(time.Second).Add(time.Second + 10*time.Millisecond).Sub(now))
+}
+
+// reserveFunc 为令牌桶的一次 Reserve，Redis 与内存版本语义相同
+type reserveFunc func(key string, opts ...Option) (bool, time.Duration)
+
+func testReserveWait(t *testing.T, key string, reserve reserveFunc) {
+	opts := []Option{WithCapacity(4), WithRate(2), WithRequested(1)}
+	alignToSecond()
+	for i := 0; i < 4; i++ {
+		if allowed, _ := reserve(key, opts...); !allowed {
+			t.Fatalf("request %d within capacity rejected", i)
+		}
+	}
+	// 每秒补充 2 个令牌，缺 1 个令牌需等待 500ms，缺 3 个需等待 1500ms
+	if allowed, wait := reserve(key, opts...); allowed || wait != 500*time.Millisecond {
+		t.Fatalf("reserve 1 = (%v, %v), want (false, 500ms)", allowed, wait)
+	}
+	if allowed, wait := reserve(key, WithCapacity(4), WithRate(2), WithRequested(3)); allowed || wait != 1500*time.Millisecond {
+		t.Fatalf("reserve 3 = (%v, %v), want (false, 1.5s)", allowed, wait)
+	}
+	// 速率为 0 时永远不会补充，返回 -1
+	if allowed, wait := reserve(key, WithCapacity(4), WithRate(0), WithRequested(1)); allowed || wait != -1 {
+		t.Fatalf("reserve without refill = (%v, %v), want (false, -1)", allowed, wait)
+	}
+}
+
+func TestMemoryTokenBucketReserveWait(t *testing.T) {
+	testReserveWait(t, "reserve", NewMemoryTokenBucket().Reserve)
+}
+
+func TestRedisReserveWait(t *testing.T) {
+	rdb := testRedis(t)
+	ctx := context.Background()
+	rl := New(ctx, rdb)
+	testReserveWait(t, testKey(t, rdb, "reserve"), func(key string, opts ...Option) (bool, time.Duration) {
+		allowed, wait, err := rl.Reserve(ctx, key, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return allowed, wait
+	})
+}
+
+// leakyFuncs 为漏桶的 Check 与 Add，Redis 与内存版本语义相同
+type leakyFuncs struct {
+	check func(key string, opts ...Option) (bool, time.Duration)
+	add   func(key string, opts ...Option)
+}
+
+func testLeakyBucket(t *testing.T, key string, leaky leakyFuncs) {
+	// 容量 2，每秒漏出 10
+	opts := []Option{WithCapacity(2), WithRate(10), WithRequested(1)}
+	for i := 0; i < 2; i++ {
+		if allowed, _ := leaky.check(key, opts...); !allowed {
+			t.Fatalf("check %d within capacity rejected", i)
+		}
+		leaky.add(key, opts...)
+	}
+	allowed, wait := leaky.check(key, opts...)
+	if allowed || wait <= 0 || wait > 100*time.Millisecond {
+		t.Fatalf("check when full = (%v, %v), want (false, <=100ms)", allowed, wait)
+	}
+	// 漏出后又可以注入
+	time.Sleep(150 * time.Millisecond)
+	if allowed, _ := leaky.check(key, opts...); !allowed {
+		t.Fatal("check after leaking rejected")
+	}
+	if allowed, wait := leaky.check(key, WithCapacity(2), WithRate(0), WithRequested(3)); allowed || wait != -1 {
+		t.Fatalf("check without leaking = (%v, %v), want (false, -1)", allowed, wait)
+	}
+}
+
+func TestMemoryLeakyBucket(t *testing.T) {
+	b := NewMemoryLeakyBucket()
+	testLeakyBucket(t, "leaky", leakyFuncs{check: b.Check, add: b.Add})
+}
+
+func TestMemoryLeakyBucketEvictsDrained(t *testing.T) {
+	b := NewMemoryLeakyBucket()
+	b.Add("drained", WithCapacity(10), WithRate(10), WithRequested(5))
+	b.Add("filling", WithCapacity(100), WithRate(1), WithRequested(50))
+	b.Add("stuck", WithCapacity(10), WithRate(0), WithRequested(1))
+
+	// 1 秒后 drained 已漏空，filling 与不会漏出的 stuck 仍有水量
+	b.evictDrained(time.Now().Add(time.Second))
+	b.mutex.Lock()
+	_, drained := b.buckets["drained"]
+	_, filling := b.buckets["filling"]
+	_, stuck := b.buckets["stuck"]
+	b.mutex.Unlock()
+	if drained || !filling || !stuck {
+		t.Fatalf("after sweep drained=%v filling=%v stuck=%v, want only drained evicted", drained, filling, stuck)
+	}
+}
+
+func TestRedisLeakyBucket(t *testing.T) {
+	rdb := testRedis(t)
+	ctx := context.Background()
+	rl := New(ctx, rdb)
+	testLeakyBucket(t, testKey(t, rdb, "leaky"), leakyFuncs{
+		check: func(key string, opts ...Option) (bool, time.Duration) {
+			allowed, wait, err := rl.LeakyCheck(ctx, key, opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return allowed, wait
+		},
+		add: func(key string, opts ...Option) {
+			if err := rl.LeakyAdd(ctx, key, opts...); err != nil {
+				t.Fatal(err)
+			}
+		},
+	})
+}
+
+func TestRedisTokenBucketExpiresWhenRefilled(t *testing.T) {
+	rdb := testRedis(t)
+	ctx := context.Background()
+	rl := New(ctx, rdb)
+	key := testKey(t, rdb, "expire")
+
+	// 容量 10，每秒补充 2 个，消耗 6 个后 3 秒补满
+	if _, _, err := rl.Reserve(ctx, key, WithCapacity(10), WithRate(2), WithRequested(6)); err != nil {
+		t.Fatal(err)
+	}
+	ttl, err := rdb.TTL(ctx, key).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ttl <= 0 || ttl > 4*time.Second {
+		t.Fatalf("TTL = %v, want the refill time plus 1s", ttl)
+	}
+}
+
+func TestRedisTokenBucketCharge(t *testing.T) {
+	rdb := testRedis(t)
+	ctx := context.Background()
+	rl := New(ctx, rdb)
+	key := testKey(t, rdb, "charge")
+	alignToSecond()
+
+	// 对齐到整秒后在同一秒内完成，期间不会补充令牌
+	opts := []Option{WithCapacity(10), WithRate(1)}
+	if allowed, err := rl.Allow(ctx, key, append(opts, WithRequested(1))...); err != nil || !allowed {
+		t.Fatalf("first request allowed = %v, %v", allowed, err)
+	}
+	if err := rl.Charge(ctx, key, append(opts, WithRequested(4))...); err != nil {
+		t.Fatal(err)
+	}
+	if tokens, _, err := rl.Peek(ctx, key, opts...); err != nil || tokens != 5 {
+		t.Fatalf("tokens = %d (%v), want 5 after charging 4 more", tokens, err)
+	}
+	// 令牌不足时扣至 0 为止
+	if err := rl.Charge(ctx, key, append(opts, WithRequested(20))...); err != nil {
+		t.Fatal(err)
+	}
+	if allowed, err := rl.Allow(ctx, key, append(opts, WithRequested(1))...); err != nil || allowed {
+		t.Fatalf("request after the bucket was drained allowed = %v, %v", allowed, err)
+	}
+}
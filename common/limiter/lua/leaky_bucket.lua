@@ -0,0 +1,44 @@
+-- 漏桶限流器（计量模式）：每次成功请求向桶中注入水量，桶按固定速率漏出
+-- KEYS[1]: 限流器唯一标识
+-- ARGV[1]: 每次注入的水量
+-- ARGV[2]: 漏出速率 (每秒)
+-- ARGV[3]: 桶容量
+-- ARGV[4]: 1 表示注入水量，0 表示只检查是否还能注入
+-- 返回: {是否允许, 距离可以注入还需等待的毫秒数}
+
+local key = KEYS[1]
+local requested = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+local add = tonumber(ARGV[4])
+
+local now = redis.call('TIME')
+local nowInMs = tonumber(now[1]) * 1000 + math.floor(tonumber(now[2]) / 1000)
+
+local bucket = redis.call('HMGET', key, 'level', 'last_time')
+local level = tonumber(bucket[1]) or 0
+local last_time = tonumber(bucket[2]) or nowInMs
+
+-- 计算漏出的水量
+local elapsed = math.max(0, nowInMs - last_time)
+level = math.max(0, level - elapsed * rate / 1000)
+
+local allowed = level + requested <= capacity
+local wait_ms = 0
+if not allowed then
+    if rate > 0 then
+        wait_ms = math.ceil((level + requested - capacity) * 1000 / rate)
+    else
+        wait_ms = -1
+    end
+end
+
+if add == 1 then
+    level = level + requested
+    redis.call('HSET', key, 'level', level, 'last_time', nowInMs)
+    if rate > 0 then
+        redis.call('EXPIRE', key, math.ceil(level / rate) + 60)
+    end
+end
+
+return {allowed and 1 or 0, wait_ms}
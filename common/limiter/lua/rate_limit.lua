@@ -39,6 +39,9 @@ end
 
 ---- 更新桶状态并设置过期时间
 redis.call('HMSET', key, 'tokens', tokens, 'last_time', last_time)
---redis.call('EXPIRE', key, math.ceil(capacity / rate) + 60) -- 适当延长过期时间
+-- 令牌补满后的桶与不存在的 key 等价，过期时间取补满所需的时长，闲置的 key 会被尽快清理
+if rate > 0 then
+    redis.call('EXPIRE', key, math.ceil((capacity - tokens) / rate) + 1)
+end
 
 return allowed and 1 or 0
\ No newline at end of file
@@ -0,0 +1,34 @@
+-- 令牌桶额外扣除（对已放行的请求追加消耗，如上游 429 的惩罚）
+-- KEYS[1]: 限流器唯一标识
+-- ARGV[1]: 额外扣除的令牌数
+-- ARGV[2]: 令牌生成速率 (每秒)
+-- ARGV[3]: 桶容量
+-- 返回: 实际扣除的令牌数，桶中令牌不足时扣至 0 为止
+
+local key = KEYS[1]
+local requested = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+
+local now = redis.call('TIME')
+local nowInSeconds = tonumber(now[1])
+
+local bucket = redis.call('HMGET', key, 'tokens', 'last_time')
+local tokens = tonumber(bucket[1])
+local last_time = tonumber(bucket[2])
+
+if not tokens or not last_time then
+    tokens = capacity
+else
+    tokens = math.min(capacity, tokens + (nowInSeconds - last_time) * rate)
+end
+
+local charged = math.min(tokens, requested)
+tokens = tokens - charged
+
+redis.call('HMSET', key, 'tokens', tokens, 'last_time', nowInSeconds)
+if rate > 0 then
+    redis.call('EXPIRE', key, math.ceil((capacity - tokens) / rate) + 1)
+end
+
+return charged
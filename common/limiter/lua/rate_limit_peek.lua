@@ -0,0 +1,36 @@
+-- 令牌桶状态查询（只读，不消耗令牌）
+-- KEYS[1]: 限流器唯一标识
+-- ARGV[1]: 请求令牌数 (通常为1)
+-- ARGV[2]: 令牌生成速率 (每秒)
+-- ARGV[3]: 桶容量
+-- 返回: {当前可用令牌数, 距离可获取足够令牌还需等待的毫秒数}
+
+local key = KEYS[1]
+local requested = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+
+local now = redis.call('TIME')
+local nowInSeconds = tonumber(now[1])
+
+local bucket = redis.call('HMGET', key, 'tokens', 'last_time')
+local tokens = tonumber(bucket[1])
+local last_time = tonumber(bucket[2])
+
+if not tokens or not last_time then
+    tokens = capacity
+else
+    local elapsed = nowInSeconds - last_time
+    tokens = math.min(capacity, tokens + elapsed * rate)
+end
+
+local wait_ms = 0
+if tokens < requested then
+    if rate > 0 then
+        wait_ms = math.ceil((requested - tokens) * 1000 / rate)
+    else
+        wait_ms = -1
+    end
+end
+
+return {math.floor(tokens), wait_ms}
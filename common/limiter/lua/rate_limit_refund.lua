@@ -0,0 +1,32 @@
+-- 令牌桶退还（撤销一次已放行的请求）
+-- KEYS[1]: 限流器唯一标识
+-- ARGV[1]: 退还令牌数，与放行时消耗的令牌数一致
+-- ARGV[2]: 令牌生成速率 (每秒)
+-- ARGV[3]: 桶容量
+-- 返回: 1 表示已退还，0 表示桶已过期（等同于已补满，无需退还）
+
+local key = KEYS[1]
+local requested = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+
+local now = redis.call('TIME')
+local nowInSeconds = tonumber(now[1])
+
+local bucket = redis.call('HMGET', key, 'tokens', 'last_time')
+local tokens = tonumber(bucket[1])
+local last_time = tonumber(bucket[2])
+
+if not tokens or not last_time then
+    return 0
+end
+
+-- 先按经过的时间补充令牌，再退还本次消耗的令牌
+tokens = math.min(capacity, tokens + (nowInSeconds - last_time) * rate + requested)
+
+redis.call('HMSET', key, 'tokens', tokens, 'last_time', nowInSeconds)
+if rate > 0 then
+    redis.call('EXPIRE', key, math.ceil((capacity - tokens) / rate) + 1)
+end
+
+return 1
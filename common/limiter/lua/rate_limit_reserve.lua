@@ -0,0 +1,52 @@
+-- 令牌桶限流器（返回等待时长）
+-- KEYS[1]: 限流器唯一标识
+-- ARGV[1]: 请求令牌数 (通常为1)
+-- ARGV[2]: 令牌生成速率 (每秒)
+-- ARGV[3]: 桶容量
+-- 返回: {是否允许(1/0), 距离可获取足够令牌还需等待的毫秒数}
+
+local key = KEYS[1]
+local requested = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local capacity = tonumber(ARGV[3])
+
+-- 获取当前时间（Redis服务器时间）
+local now = redis.call('TIME')
+local nowInSeconds = tonumber(now[1])
+
+-- 获取桶状态
+local bucket = redis.call('HMGET', key, 'tokens', 'last_time')
+local tokens = tonumber(bucket[1])
+local last_time = tonumber(bucket[2])
+
+-- 初始化桶（首次请求或过期）
+if not tokens or not last_time then
+    tokens = capacity
+    last_time = nowInSeconds
+else
+    -- 计算新增令牌
+    local elapsed = nowInSeconds - last_time
+    local add_tokens = elapsed * rate
+    tokens = math.min(capacity, tokens + add_tokens)
+    last_time = nowInSeconds
+end
+
+-- 判断是否允许请求，不允许时计算补足令牌所需的时间
+local allowed = 0
+local wait_ms = 0
+if tokens >= requested then
+    tokens = tokens - requested
+    allowed = 1
+elseif rate > 0 then
+    wait_ms = math.ceil((requested - tokens) * 1000 / rate)
+else
+    wait_ms = -1
+end
+
+redis.call('HMSET', key, 'tokens', tokens, 'last_time', last_time)
+-- 令牌补满后的桶与不存在的 key 等价，过期时间取补满所需的时长，闲置的 key 会被尽快清理
+if rate > 0 then
+    redis.call('EXPIRE', key, math.ceil((capacity - tokens) / rate) + 1)
+end
+
+return {allowed, wait_ms}
@@ -0,0 +1,26 @@
+-- 滑动窗口原子检查并记录
+-- KEYS[1]: 记录请求时间的列表，最新的记录在表头
+-- ARGV[1]: 窗口内允许的最大请求数
+-- ARGV[2]: 本次请求的时间，与列表元素格式相同且可按字典序比较
+-- ARGV[3]: 窗口起点时间，不晚于该时间的记录已滑出窗口
+-- ARGV[4]: 列表过期时间（秒）
+-- 返回: 1 表示允许并已记录本次请求，0 表示拒绝
+
+local key = KEYS[1]
+local max = tonumber(ARGV[1])
+local now = ARGV[2]
+local windowStart = ARGV[3]
+local ttl = tonumber(ARGV[4])
+
+if redis.call('LLEN', key) >= max then
+    local oldest = redis.call('LINDEX', key, -1)
+    if oldest and oldest > windowStart then
+        redis.call('EXPIRE', key, ttl)
+        return 0
+    end
+end
+
+redis.call('LPUSH', key, now)
+redis.call('LTRIM', key, 0, max - 1)
+redis.call('EXPIRE', key, ttl)
+return 1
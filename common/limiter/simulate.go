@@ -0,0 +1,85 @@
+package limiter
+
+import "time"
+
+// SimulationConfig 模拟限流使用的配置，与 per-user 模型请求限流的参数含义一致
+type SimulationConfig struct {
+	DurationSeconds int64 `json:"duration_seconds"`  // 时间窗口，单位秒
+	TotalMaxCount   int   `json:"total_max_count"`   // 窗口内总请求数限制（0表示不限制）
+	SuccessMaxCount int   `json:"success_max_count"` // 窗口内成功请求数限制（0表示不限制）
+}
+
+// SimulationEvent 一次模拟请求
+type SimulationEvent struct {
+	Time    int64 `json:"time"`    // 请求时间，Unix 毫秒时间戳，需按时间先后排列
+	Success bool  `json:"success"` // 请求被放行后是否成功，成功的请求会计入成功请求数
+}
+
+// SimulationResult 单次模拟请求的判定结果
+type SimulationResult struct {
+	Time    int64  `json:"time"`
+	Allowed bool   `json:"allowed"`
+	Reason  string `json:"reason,omitempty"` // 被拒绝的原因：success_limit 或 total_limit
+}
+
+const (
+	SimulationRejectSuccessLimit = "success_limit"
+	SimulationRejectTotalLimit   = "total_limit"
+)
+
+// successWindow 与成功请求数限制相同的计数方式：保留最近 maxCount 次成功请求的时间，
+// 最早一次仍在窗口内时拒绝
+type successWindow struct {
+	times []time.Time // 最新的在前
+}
+
+func (w *successWindow) allow(now time.Time, maxCount int, duration int64) bool {
+	if maxCount == 0 || len(w.times) < maxCount {
+		return true
+	}
+	return int64(now.Sub(w.times[len(w.times)-1]).Seconds()) >= duration
+}
+
+func (w *successWindow) record(now time.Time, maxCount int) {
+	if maxCount == 0 {
+		return
+	}
+	w.times = append([]time.Time{now}, w.times...)
+	if len(w.times) > maxCount {
+		w.times = w.times[:maxCount]
+	}
+}
+
+// SimulateRateLimit 按给定配置依次判定每个请求是否会被放行，只使用内存中的临时状态，不影响线上计数。
+// 判定顺序与线上一致：先检查成功请求数，再从令牌桶中扣除总请求数。
+func SimulateRateLimit(config SimulationConfig, events []SimulationEvent) []SimulationResult {
+	results := make([]SimulationResult, 0, len(events))
+	// 总请求数使用线上的内存令牌桶，时钟替换为模拟请求的时间
+	var now time.Time
+	bucket := newMemoryTokenBucketWithClock(func() time.Time { return now })
+	window := &successWindow{}
+	duration := config.DurationSeconds
+	for _, event := range events {
+		now = time.UnixMilli(event.Time)
+		result := SimulationResult{Time: event.Time, Allowed: true}
+		if !window.allow(now, config.SuccessMaxCount, duration) {
+			result.Allowed = false
+			result.Reason = SimulationRejectSuccessLimit
+		} else if config.TotalMaxCount > 0 {
+			allowed, _ := bucket.Reserve("simulate",
+				WithCapacity(int64(config.TotalMaxCount)*duration),
+				WithRate(int64(config.TotalMaxCount)),
+				WithRequested(duration),
+			)
+			if !allowed {
+				result.Allowed = false
+				result.Reason = SimulationRejectTotalLimit
+			}
+		}
+		if result.Allowed && event.Success {
+			window.record(now, config.SuccessMaxCount)
+		}
+		results = append(results, result)
+	}
+	return results
+}
@@ -0,0 +1,69 @@
+package limiter
+
+import "testing"
+
+func TestSimulateRateLimitTotalLimit(t *testing.T) {
+	config := SimulationConfig{DurationSeconds: 60, TotalMaxCount: 2}
+	// 每个请求消耗 60 个令牌，容量 120，每秒补充 2 个
+	events := []SimulationEvent{
+		{Time: 0}, {Time: 100}, {Time: 200},
+		{Time: 29_000},
+		{Time: 30_000},
+		{Time: 200_000}, {Time: 200_001}, {Time: 200_002},
+	}
+	want := []bool{true, true, false, false, true, true, true, false}
+	results := SimulateRateLimit(config, events)
+	if len(results) != len(events) {
+		t.Fatalf("got %d results, want %d", len(results), len(events))
+	}
+	for i, result := range results {
+		if result.Allowed != want[i] {
+			t.Errorf("event %d at %dms: allowed = %v, want %v", i, events[i].Time, result.Allowed, want[i])
+		}
+		if !result.Allowed && result.Reason != SimulationRejectTotalLimit {
+			t.Errorf("event %d: reason = %q, want %q", i, result.Reason, SimulationRejectTotalLimit)
+		}
+	}
+}
+
+func TestSimulateRateLimitMatchesMemoryTokenBucket(t *testing.T) {
+	config := SimulationConfig{DurationSeconds: 10, TotalMaxCount: 3}
+	var events []SimulationEvent
+	for _, ms := range []int64{0, 0, 0, 0, 3_000, 3_500, 4_000, 9_000, 9_000, 9_000, 30_000, 30_000, 30_000, 30_000} {
+		events = append(events, SimulationEvent{Time: ms})
+	}
+	results := SimulateRateLimit(config, events)
+
+	// 线上限流使用的内存令牌桶，参数与 ModelRequestRateLimit 一致，按相同的时间点判定
+	bucket := NewMemoryTokenBucket()
+	opts := newConfig(WithCapacity(30), WithRate(3), WithRequested(10))
+	for i, event := range events {
+		state := bucket.refill("sim", opts, event.Time/1000)
+		allowed := state.tokens >= opts.Requested
+		if allowed {
+			state.tokens -= opts.Requested
+		}
+		if results[i].Allowed != allowed {
+			t.Errorf("event %d at %dms: simulated %v, memory token bucket %v", i, event.Time, results[i].Allowed, allowed)
+		}
+	}
+}
+
+func TestSimulateRateLimitSuccessLimit(t *testing.T) {
+	config := SimulationConfig{DurationSeconds: 60, SuccessMaxCount: 1}
+	events := []SimulationEvent{
+		{Time: 0, Success: false},
+		{Time: 1_000, Success: true},
+		{Time: 10_000, Success: true},
+		{Time: 61_000, Success: true},
+	}
+	want := []bool{true, true, false, true}
+	for i, result := range SimulateRateLimit(config, events) {
+		if result.Allowed != want[i] {
+			t.Errorf("event %d: allowed = %v, want %v", i, result.Allowed, want[i])
+		}
+		if !result.Allowed && result.Reason != SimulationRejectSuccessLimit {
+			t.Errorf("event %d: reason = %q, want %q", i, result.Reason, SimulationRejectSuccessLimit)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package limiter
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+//go:embed lua/sliding_window_reserve.lua
+var slidingWindowReserveScript string
+
+var slidingWindowScript = redis.NewScript(slidingWindowReserveScript)
+
+// SlidingWindowReserve 原子地检查列表中窗口内的记录数并记录本次请求，避免并发请求同时通过检查后一起记录导致超出限制。
+// now 与 windowStart 需与列表元素格式相同且可按字典序比较，now 即本次写入的元素，可用于 SlidingWindowRelease 撤销
+func (rl *RedisLimiter) SlidingWindowReserve(ctx context.Context, key string, maxCount int, now string, windowStart string, ttl time.Duration) (bool, error) {
+	result, err := slidingWindowScript.Run(
+		ctx,
+		rl.client,
+		[]string{key},
+		maxCount,
+		now,
+		windowStart,
+		int64(ttl.Seconds()),
+	).Int()
+	if err != nil {
+		return false, fmt.Errorf("sliding window reserve failed: %w", err)
+	}
+	return result == 1, nil
+}
+
+// SlidingWindowRelease 撤销 SlidingWindowReserve 记录的一次请求
+func (rl *RedisLimiter) SlidingWindowRelease(ctx context.Context, key string, member string) error {
+	if err := rl.client.LRem(ctx, key, 1, member).Err(); err != nil {
+		return fmt.Errorf("sliding window release failed: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package limiter
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slidingWindowMember 返回与限流列表元素格式相同、可按字典序比较的时间
+func slidingWindowMember(second, n int) string {
+	return fmt.Sprintf("2026-10-16 12:00:%02d.%04d", second, n)
+}
+
+func TestRedisSlidingWindowReserveConcurrent(t *testing.T) {
+	rdb := testRedis(t)
+	ctx := context.Background()
+	rl := New(ctx, rdb)
+	key := testKey(t, rdb, "sliding")
+
+	// 并发请求同时到达限制边界，原子检查并记录保证放行数恰好等于上限
+	const maxCount = 10
+	var allowedCount atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			allowed, err := rl.SlidingWindowReserve(ctx, key, maxCount, slidingWindowMember(30, i), slidingWindowMember(0, 0), time.Minute)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if allowed {
+				allowedCount.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := allowedCount.Load(); got != maxCount {
+		t.Fatalf("allowed %d concurrent reservations, want exactly %d", got, maxCount)
+	}
+	if length, err := rdb.LLen(ctx, key).Result(); err != nil || length != maxCount {
+		t.Fatalf("list length = %d (%v), want %d", length, err, maxCount)
+	}
+}
+
+func TestRedisSlidingWindowRelease(t *testing.T) {
+	rdb := testRedis(t)
+	ctx := context.Background()
+	rl := New(ctx, rdb)
+	key := testKey(t, rdb, "sliding-release")
+	windowStart := slidingWindowMember(0, 0)
+
+	reserve := func(member string) bool {
+		allowed, err := rl.SlidingWindowReserve(ctx, key, 2, member, windowStart, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return allowed
+	}
+	if !reserve(slidingWindowMember(10, 1)) || !reserve(slidingWindowMember(10, 2)) {
+		t.Fatal("reservation within the limit rejected")
+	}
+	if reserve(slidingWindowMember(10, 3)) {
+		t.Fatal("reservation over the limit allowed")
+	}
+	if err := rl.SlidingWindowRelease(ctx, key, slidingWindowMember(10, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if !reserve(slidingWindowMember(10, 4)) {
+		t.Fatal("reservation after a release rejected")
+	}
+
+	// 最早的记录滑出窗口后可以继续预占
+	allowed, err := rl.SlidingWindowReserve(ctx, key, 2, slidingWindowMember(20, 0), slidingWindowMember(10, 1), time.Minute)
+	if err != nil || !allowed {
+		t.Fatalf("reservation after the oldest entry left the window: allowed=%v err=%v", allowed, err)
+	}
+}
@@ -0,0 +1,83 @@
+package limiter
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// MemoryTokenBucket 内存版本的令牌桶，语义与 lua/rate_limit_reserve.lua 相同（时间精度为秒），用于未启用 Redis 的情况
+type MemoryTokenBucket struct {
+	mutex   sync.Mutex
+	buckets map[string]*tokenBucketState
+	now     func() time.Time // 当前时间，模拟限流时替换为模拟的请求时间
+}
+
+// tokenBucketState 记录单个桶的令牌数与最近一次补充的时间，并保存该桶的容量与速率，
+// 同一实例中不同 key 可使用不同的配置，清理时按各自的配置判断是否已补满
+type tokenBucketState struct {
+	tokens   int64
+	lastTime int64
+	capacity int64
+	rate     int64
+}
+
+func NewMemoryTokenBucket() *MemoryTokenBucket {
+	return newMemoryTokenBucketWithClock(time.Now)
+}
+
+func newMemoryTokenBucketWithClock(now func() time.Time) *MemoryTokenBucket {
+	return &MemoryTokenBucket{buckets: make(map[string]*tokenBucketState), now: now}
+}
+
+// refill 按经过的时间补充令牌，返回当前状态，调用方需持有锁
+func (b *MemoryTokenBucket) refill(key string, config *Config, now int64) *tokenBucketState {
+	state, ok := b.buckets[key]
+	if !ok {
+		// 记录较多时顺带清理已补满的桶，补满的桶与不存在的桶等价
+		if len(b.buckets) >= 1024 {
+			for k, s := range b.buckets {
+				if s.rate > 0 && s.tokens+(now-s.lastTime)*s.rate >= s.capacity {
+					delete(b.buckets, k)
+				}
+			}
+		}
+		state = &tokenBucketState{tokens: config.Capacity, lastTime: now, capacity: config.Capacity, rate: config.Rate}
+		b.buckets[key] = state
+		return state
+	}
+	state.tokens = min(config.Capacity, state.tokens+(now-state.lastTime)*config.Rate)
+	state.lastTime = now
+	state.capacity, state.rate = config.Capacity, config.Rate
+	return state
+}
+
+// Reserve 令牌足够时消耗 Requested 个令牌并返回 true，否则返回距离令牌补足还需等待的时长，速率为 0 时为 -1
+func (b *MemoryTokenBucket) Reserve(key string, opts ...Option) (bool, time.Duration) {
+	config := newConfig(opts...)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	state := b.refill(key, config, b.now().Unix())
+	if state.tokens >= config.Requested {
+		state.tokens -= config.Requested
+		return true, 0
+	}
+	if config.Rate <= 0 {
+		return false, -1
+	}
+	return false, time.Duration(math.Ceil(float64(config.Requested-state.tokens)*1000/float64(config.Rate))) * time.Millisecond
+}
+
+// Refund 退还一次已放行请求消耗的令牌，参数需与放行时一致
+func (b *MemoryTokenBucket) Refund(key string, opts ...Option) {
+	config := newConfig(opts...)
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, ok := b.buckets[key]; !ok {
+		return
+	}
+	state := b.refill(key, config, b.now().Unix())
+	state.tokens = min(config.Capacity, state.tokens+config.Requested)
+}
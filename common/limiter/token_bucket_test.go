@@ -0,0 +1,72 @@
+package limiter
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMemoryTokenBucketReserveAndRefund(t *testing.T) {
+	b := NewMemoryTokenBucket()
+	opts := []Option{WithCapacity(3), WithRate(1), WithRequested(1)}
+
+	for i := 0; i < 3; i++ {
+		if allowed, _ := b.Reserve("reserve", opts...); !allowed {
+			t.Fatalf("request %d rejected within capacity", i+1)
+		}
+	}
+	allowed, wait := b.Reserve("reserve", opts...)
+	if allowed || wait <= 0 || wait > time.Second {
+		t.Fatalf("over capacity: allowed=%v wait=%v, want rejected with wait up to 1s", allowed, wait)
+	}
+
+	// 退还后可再放行一次
+	b.Refund("reserve", opts...)
+	if allowed, _ := b.Reserve("reserve", opts...); !allowed {
+		t.Fatal("request after refund rejected")
+	}
+
+	// 速率为 0 时不会补充，等待时长为 -1
+	zero := []Option{WithCapacity(1), WithRate(0), WithRequested(1)}
+	b.Reserve("zero", zero...)
+	if allowed, wait := b.Reserve("zero", zero...); allowed || wait != -1 {
+		t.Fatalf("zero rate: allowed=%v wait=%v, want rejected with -1", allowed, wait)
+	}
+}
+
+func TestMemoryTokenBucketRefill(t *testing.T) {
+	b := NewMemoryTokenBucket()
+	config := newConfig(WithCapacity(10), WithRate(2), WithRequested(1))
+
+	state := b.refill("refill", config, 100)
+	if state.tokens != 10 {
+		t.Fatalf("new bucket tokens = %d, want full capacity 10", state.tokens)
+	}
+	state.tokens = 0
+	if state = b.refill("refill", config, 103); state.tokens != 6 {
+		t.Fatalf("tokens after 3s = %d, want 6", state.tokens)
+	}
+	// 补充不超过容量
+	if state = b.refill("refill", config, 200); state.tokens != 10 {
+		t.Fatalf("tokens after long idle = %d, want capacity 10", state.tokens)
+	}
+}
+
+func TestMemoryTokenBucketEvictionUsesOwnConfig(t *testing.T) {
+	b := NewMemoryTokenBucket()
+	slow := newConfig(WithCapacity(100), WithRate(1), WithRequested(100))
+	fast := newConfig(WithCapacity(10), WithRate(10), WithRequested(1))
+
+	// 慢速桶已用完，1 秒后远未补满；其它 key 使用补充更快的配置
+	b.refill("slow", slow, 100).tokens = 0
+	for i := 0; i < 1100; i++ {
+		b.refill(fmt.Sprintf("fast-%d", i), fast, 101)
+	}
+	state, ok := b.buckets["slow"]
+	if !ok {
+		t.Fatal("bucket that is not yet refilled was evicted by another key's config")
+	}
+	if state = b.refill("slow", slow, 101); state.tokens != 1 {
+		t.Fatalf("slow bucket tokens = %d, want 1", state.tokens)
+	}
+}
@@ -68,3 +68,96 @@ func (l *InMemoryRateLimiter) Request(key string, maxRequestNum int, duration in
 	}
 	return true
 }
+
+// Peek 返回 key 在时间窗口内已记录的请求数以及其中最早一次请求的时间戳，不记录新的请求
+func (l *InMemoryRateLimiter) Peek(key string, duration int64) (count int, oldest int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	queue, ok := l.store[key]
+	if !ok {
+		return 0, 0
+	}
+	now := time.Now().Unix()
+	for _, t := range *queue {
+		if now-t < duration {
+			if count == 0 {
+				oldest = t
+			}
+			count++
+		}
+	}
+	return count, oldest
+}
+
+// Refund 撤销 key 最近一次记录的请求
+func (l *InMemoryRateLimiter) Refund(key string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	queue, ok := l.store[key]
+	if !ok || len(*queue) == 0 {
+		return
+	}
+	*queue = (*queue)[:len(*queue)-1]
+}
+
+// Charge 为 key 额外记录 n 次请求而不做放行判断，记录数最多保留 maxRequestNum 条
+func (l *InMemoryRateLimiter) Charge(key string, n int, maxRequestNum int) {
+	if n <= 0 || maxRequestNum <= 0 {
+		return
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	queue, ok := l.store[key]
+	if !ok {
+		s := make([]int64, 0, maxRequestNum)
+		queue = &s
+		l.store[key] = queue
+	}
+	now := time.Now().Unix()
+	for i := 0; i < n; i++ {
+		*queue = append(*queue, now)
+	}
+	if len(*queue) > maxRequestNum {
+		*queue = (*queue)[len(*queue)-maxRequestNum:]
+	}
+}
+
+// Import 为尚无记录的 key 写入已有的请求时间戳（按时间从旧到新），最多保留最近的 maxRequestNum 条，
+// key 已有记录时不覆盖并返回 false
+func (l *InMemoryRateLimiter) Import(key string, timestamps []int64, maxRequestNum int) bool {
+	if len(timestamps) == 0 {
+		return false
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if queue, ok := l.store[key]; ok && len(*queue) > 0 {
+		return false
+	}
+	if maxRequestNum > 0 && len(timestamps) > maxRequestNum {
+		timestamps = timestamps[len(timestamps)-maxRequestNum:]
+	}
+	s := append(make([]int64, 0, max(maxRequestNum, len(timestamps))), timestamps...)
+	l.store[key] = &s
+	return true
+}
+
+// Len 返回当前仍保留在内存中的 key 数量
+func (l *InMemoryRateLimiter) Len() int {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return len(l.store)
+}
+
+// Export 返回所有 key 已记录的请求时间戳副本（按时间从旧到新），用于切换到 Redis 时迁移计数
+func (l *InMemoryRateLimiter) Export() map[string][]int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	snapshot := make(map[string][]int64, len(l.store))
+	for key, queue := range l.store {
+		if len(*queue) == 0 {
+			continue
+		}
+		snapshot[key] = append([]int64(nil), *queue...)
+	}
+	return snapshot
+}
@@ -7,14 +7,77 @@ import (
 	"os"
 	"reflect"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"gorm.io/gorm"
 )
 
-var RDB *redis.Client
-var RedisEnabled = true
+// Redis 客户端与启用状态可能在运行时由重连协程切换，读写均通过下面的原子访问函数
+var (
+	rdbClient    atomic.Pointer[redis.Client]
+	redisEnabled atomic.Bool
+)
+
+func init() {
+	redisEnabled.Store(true)
+}
+
+// RDB 返回当前的 Redis 客户端，未启用 Redis 时可能为 nil
+func RDB() *redis.Client {
+	return rdbClient.Load()
+}
+
+func SetRDB(rdb *redis.Client) {
+	rdbClient.Store(rdb)
+}
+
+// RedisEnabled 返回当前是否使用 Redis
+func RedisEnabled() bool {
+	return redisEnabled.Load()
+}
+
+func SetRedisEnabled(enabled bool) {
+	redisEnabled.Store(enabled)
+}
+
+// redisSwitchHooks 在运行时启用 Redis 之前依次调用，用于把内存中的状态迁移到 Redis
+var redisSwitchHooks []func(rdb *redis.Client)
+
+// RegisterRedisSwitchHook 注册运行时启用 Redis 时的迁移回调
+func RegisterRedisSwitchHook(hook func(rdb *redis.Client)) {
+	redisSwitchHooks = append(redisSwitchHooks, hook)
+}
+
+// EnableRedis 在运行时切换到 Redis：先执行迁移回调，再把 RDB 与 RedisEnabled 指向新的客户端，
+// 迁移期间的请求仍由内存限流器处理，保证限流不中断
+func EnableRedis(rdb *redis.Client) {
+	if RedisEnabled() {
+		return
+	}
+	for _, hook := range redisSwitchHooks {
+		hook(rdb)
+	}
+	SetRDB(rdb)
+	SetRedisEnabled(true)
+	SysLog("Redis is enabled at runtime")
+}
+
+// reconnectRedis 定期探测启动时不可用的 Redis，连通后通过 EnableRedis 切换，内存中的限流计数由迁移回调写入 Redis
+func reconnectRedis(rdb *redis.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := rdb.Ping(ctx).Err()
+		cancel()
+		if err == nil {
+			EnableRedis(rdb)
+			return
+		}
+	}
+}
 
 func RedisKeyCacheSeconds() int {
 	return SyncFrequency
@@ -23,7 +86,7 @@ func RedisKeyCacheSeconds() int {
 // InitRedisClient This function is called after init()
 func InitRedisClient() (err error) {
 	if os.Getenv("REDIS_CONN_STRING") == "" {
-		RedisEnabled = false
+		SetRedisEnabled(false)
 		SysLog("REDIS_CONN_STRING not set, Redis is not enabled")
 		return nil
 	}
@@ -37,15 +100,24 @@ func InitRedisClient() (err error) {
 		FatalLog("failed to parse Redis connection string: " + err.Error())
 	}
 	opt.PoolSize = GetEnvOrDefault("REDIS_POOL_SIZE", 10)
-	RDB = redis.NewClient(opt)
+	rdb := redis.NewClient(opt)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err = RDB.Ping(ctx).Result()
+	_, err = rdb.Ping(ctx).Result()
 	if err != nil {
-		FatalLog("Redis ping test failed: " + err.Error())
+		// 设置了重连间隔时先使用内存模式启动，Redis 可用后再切换
+		interval := GetEnvOrDefault("REDIS_RECONNECT_INTERVAL_SECONDS", 0)
+		if interval <= 0 {
+			FatalLog("Redis ping test failed: " + err.Error())
+		}
+		SysError(fmt.Sprintf("Redis ping test failed, running in memory mode and retrying every %ds: %s", interval, err.Error()))
+		SetRedisEnabled(false)
+		go reconnectRedis(rdb, time.Duration(interval)*time.Second)
+		return nil
 	}
+	SetRDB(rdb)
 	if DebugEnabled {
 		SysLog(fmt.Sprintf("Redis connected to %s", opt.Addr))
 		SysLog(fmt.Sprintf("Redis database: %d", opt.DB))
@@ -66,7 +138,7 @@ func RedisSet(key string, value string, expiration time.Duration) error {
 		SysLog(fmt.Sprintf("Redis SET: key=%s, value=%s, expiration=%v", key, value, expiration))
 	}
 	ctx := context.Background()
-	return RDB.Set(ctx, key, value, expiration).Err()
+	return RDB().Set(ctx, key, value, expiration).Err()
 }
 
 func RedisGet(key string) (string, error) {
@@ -74,7 +146,7 @@ func RedisGet(key string) (string, error) {
 		SysLog(fmt.Sprintf("Redis GET: key=%s", key))
 	}
 	ctx := context.Background()
-	val, err := RDB.Get(ctx, key).Result()
+	val, err := RDB().Get(ctx, key).Result()
 	return val, err
 }
 
@@ -93,7 +165,7 @@ func RedisDel(key string) error {
 		SysLog(fmt.Sprintf("Redis DEL: key=%s", key))
 	}
 	ctx := context.Background()
-	return RDB.Del(ctx, key).Err()
+	return RDB().Del(ctx, key).Err()
 }
 
 func RedisDelKey(key string) error {
@@ -101,7 +173,7 @@ func RedisDelKey(key string) error {
 		SysLog(fmt.Sprintf("Redis DEL Key: key=%s", key))
 	}
 	ctx := context.Background()
-	return RDB.Del(ctx, key).Err()
+	return RDB().Del(ctx, key).Err()
 }
 
 func RedisHSetObj(key string, obj interface{}, expiration time.Duration) error {
@@ -143,7 +215,7 @@ func RedisHSetObj(key string, obj interface{}, expiration time.Duration) error {
 		data[field.Name] = fmt.Sprintf("%v", value.Interface())
 	}
 
-	txn := RDB.TxPipeline()
+	txn := RDB().TxPipeline()
 	txn.HSet(ctx, key, data)
 
 	// 只有在 expiration 大于 0 时才设置过期时间
@@ -164,7 +236,7 @@ func RedisHGetObj(key string, obj interface{}) error {
 	}
 	ctx := context.Background()
 
-	result, err := RDB.HGetAll(ctx, key).Result()
+	result, err := RDB().HGetAll(ctx, key).Result()
 	if err != nil {
 		return fmt.Errorf("failed to load hash from Redis: %w", err)
 	}
@@ -244,7 +316,7 @@ func RedisIncr(key string, delta int64) error {
 		SysLog(fmt.Sprintf("Redis INCR: key=%s, delta=%d", key, delta))
 	}
 	// 检查键的剩余生存时间
-	ttlCmd := RDB.TTL(context.Background(), key)
+	ttlCmd := RDB().TTL(context.Background(), key)
 	ttl, err := ttlCmd.Result()
 	if err != nil && !errors.Is(err, redis.Nil) {
 		return fmt.Errorf("failed to get TTL: %w", err)
@@ -254,7 +326,7 @@ func RedisIncr(key string, delta int64) error {
 	if ttl > 0 {
 		ctx := context.Background()
 		// 开始一个Redis事务
-		txn := RDB.TxPipeline()
+		txn := RDB().TxPipeline()
 
 		// 减少余额
 		decrCmd := txn.IncrBy(ctx, key, delta)
@@ -276,7 +348,7 @@ func RedisHIncrBy(key, field string, delta int64) error {
 	if DebugEnabled {
 		SysLog(fmt.Sprintf("Redis HINCRBY: key=%s, field=%s, delta=%d", key, field, delta))
 	}
-	ttlCmd := RDB.TTL(context.Background(), key)
+	ttlCmd := RDB().TTL(context.Background(), key)
 	ttl, err := ttlCmd.Result()
 	if err != nil && !errors.Is(err, redis.Nil) {
 		return fmt.Errorf("failed to get TTL: %w", err)
@@ -284,7 +356,7 @@ func RedisHIncrBy(key, field string, delta int64) error {
 
 	if ttl > 0 {
 		ctx := context.Background()
-		txn := RDB.TxPipeline()
+		txn := RDB().TxPipeline()
 
 		incrCmd := txn.HIncrBy(ctx, key, field, delta)
 		if err := incrCmd.Err(); err != nil {
@@ -303,7 +375,7 @@ func RedisHSetField(key, field string, value interface{}) error {
 	if DebugEnabled {
 		SysLog(fmt.Sprintf("Redis HSET field: key=%s, field=%s, value=%v", key, field, value))
 	}
-	ttlCmd := RDB.TTL(context.Background(), key)
+	ttlCmd := RDB().TTL(context.Background(), key)
 	ttl, err := ttlCmd.Result()
 	if err != nil && !errors.Is(err, redis.Nil) {
 		return fmt.Errorf("failed to get TTL: %w", err)
@@ -311,7 +383,7 @@ func RedisHSetField(key, field string, value interface{}) error {
 
 	if ttl > 0 {
 		ctx := context.Background()
-		txn := RDB.TxPipeline()
+		txn := RDB().TxPipeline()
 
 		hsetCmd := txn.HSet(ctx, key, field, value)
 		if err := hsetCmd.Err(); err != nil {
@@ -11,18 +11,23 @@ const (
 	ContextKeyRequestStartTime ContextKey = "request_start_time"
 
 	/* token related keys */
-	ContextKeyTokenUnlimited         ContextKey = "token_unlimited_quota"
-	ContextKeyTokenKey               ContextKey = "token_key"
-	ContextKeyTokenId                ContextKey = "token_id"
-	ContextKeyTokenGroup             ContextKey = "token_group"
-	ContextKeyTokenSpecificChannelId ContextKey = "specific_channel_id"
-	ContextKeyTokenModelLimitEnabled ContextKey = "token_model_limit_enabled"
-	ContextKeyTokenModelLimit        ContextKey = "token_model_limit"
-	ContextKeyTokenCrossGroupRetry   ContextKey = "token_cross_group_retry"
+	ContextKeyTokenUnlimited          ContextKey = "token_unlimited_quota"
+	ContextKeyTokenKey                ContextKey = "token_key"
+	ContextKeyTokenId                 ContextKey = "token_id"
+	ContextKeyTokenGroup              ContextKey = "token_group"
+	ContextKeyTokenSpecificChannelId  ContextKey = "specific_channel_id"
+	ContextKeyTokenModelLimitEnabled  ContextKey = "token_model_limit_enabled"
+	ContextKeyTokenModelLimit         ContextKey = "token_model_limit"
+	ContextKeyTokenCrossGroupRetry    ContextKey = "token_cross_group_retry"
+	ContextKeyTokenOrgId              ContextKey = "token_org_id"
+	ContextKeyTokenRateLimitAlgorithm ContextKey = "token_rate_limit_algorithm"
+	ContextKeyTokenCustomerIds        ContextKey = "token_customer_ids"
+	ContextKeyTokenBillingAnchorDay   ContextKey = "token_billing_anchor_day"
 
 	/* channel related keys */
 	ContextKeyChannelId                ContextKey = "channel_id"
 	ContextKeyChannelName              ContextKey = "channel_name"
+	ContextKeyChannelTag               ContextKey = "channel_tag"
 	ContextKeyChannelCreateTime        ContextKey = "channel_create_time"
 	ContextKeyChannelBaseUrl           ContextKey = "base_url"
 	ContextKeyChannelType              ContextKey = "channel_type"
@@ -51,8 +56,30 @@ const (
 	ContextKeyUserGroup   ContextKey = "user_group"
 	ContextKeyUsingGroup  ContextKey = "group"
 	ContextKeyUserName    ContextKey = "username"
+	ContextKeyUserRole    ContextKey = "role"
 
 	ContextKeyLocalCountTokens ContextKey = "local_count_tokens"
 
+	// 上游返回 2xx 但响应体中包含错误（如内容被过滤、流式响应中途出错）
+	ContextKeyResponseBodyError ContextKey = "response_body_error"
+
+	// 本次请求内各类渠道错误最先出现的渠道，用于重试时的失败归因
+	ContextKeyChannelFailureAttribution ContextKey = "channel_failure_attribution"
+
+	// 已收到上游响应，用于区分客户端在上游响应前取消的请求
+	ContextKeyUpstreamResponded ContextKey = "upstream_responded"
+
+	// 转发过程中有上游返回 429，用于对该请求追加限流惩罚
+	ContextKeyUpstreamRateLimited ContextKey = "upstream_rate_limited"
+
+	// 上游成功响应但没有任何输出 token，用于按连续空回复次数自动禁用渠道
+	ContextKeyEmptyResponse ContextKey = "empty_response"
+
+	// 请求来自操练场（网页登录会话），使用独立的操练场限流
+	ContextKeyPlayground ContextKey = "playground"
+
 	ContextKeySystemPromptOverride ContextKey = "system_prompt_override"
+
+	// 流式响应过程中已计入按 token 数计数的组合限流规则的 token 数，请求结束记账时扣除，避免重复计入
+	ContextKeyRateLimitStreamTokens ContextKey = "rate_limit_stream_tokens"
 )
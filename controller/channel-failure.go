@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetChannelFailureCounters 查看渠道在本节点上的失败计数、失败率及冷却结束时间
+func GetChannelFailureCounters(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"channel_id": id,
+		"counters":   service.GetChannelFailureCounters(id),
+	})
+}
+
+// ResetChannelFailureCounters 清空渠道在本节点上的失败计数与冷却，不改变渠道的启用状态
+func ResetChannelFailureCounters(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	service.ResetChannelFailureCounters(id)
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("重置渠道失败计数 (渠道ID: %d)", id))
+	common.ApiSuccess(c, gin.H{
+		"channel_id": id,
+		"counters":   service.GetChannelFailureCounters(id),
+	})
+}
+
+// GetModelHealth 查看各模型在本节点上所有渠道的整体成功率，低于 ModelMinSuccessRate 的模型标记为 degraded
+func GetModelHealth(c *gin.Context) {
+	common.ApiSuccess(c, service.GetModelHealth())
+}
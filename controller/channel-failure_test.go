@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func serveChannelFailureCounters(t *testing.T, method string, path string) service.ChannelFailureCounters {
+	t.Helper()
+	r := gin.New()
+	r.GET("/api/channel/:id/failures", GetChannelFailureCounters)
+	r.DELETE("/api/channel/:id/failures", ResetChannelFailureCounters)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+	var resp struct {
+		Success bool `json:"success"`
+		Data    struct {
+			ChannelId int                            `json:"channel_id"`
+			Counters  service.ChannelFailureCounters `json:"counters"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || !resp.Success {
+		t.Fatalf("%s %s failed: %s", method, path, w.Body.String())
+	}
+	return resp.Data.Counters
+}
+
+func TestChannelFailureCountersViewAndReset(t *testing.T) {
+	setupRateLimitConfigDB(t, nil)
+	oldEmpty := setting.ChannelDisableOnEmptyAfterN
+	setting.ChannelDisableOnEmptyAfterN = 10
+	t.Cleanup(func() {
+		setting.ChannelDisableOnEmptyAfterN = oldEmpty
+		service.ResetChannelFailureCounters(1703)
+	})
+
+	model.RecordChannelOutcome(1703, true)
+	model.RecordChannelOutcome(1703, false)
+	model.SetChannelBackoffUntil(1703, common.GetTimestamp()+60)
+	service.ShouldDisableChannelOnEmpty(1703, true)
+	service.ShouldDisableChannelOnEmpty(1703, true)
+	service.NewModelTimeoutError(1703, "gpt-4o", 30)
+
+	counters := serveChannelFailureCounters(t, http.MethodGet, "/api/channel/1703/failures")
+	if counters.SuccessCount != 1 || counters.FailureCount != 1 || counters.ErrorRate != 0.5 {
+		t.Fatalf("outcomes = %d/%d (%v), want 1/1 (0.5)", counters.SuccessCount, counters.FailureCount, counters.ErrorRate)
+	}
+	if counters.BackoffUntil == 0 {
+		t.Fatal("backoff not reported")
+	}
+	if counters.ConsecutiveEmpty != 2 || counters.ConsecutiveTimeouts["gpt-4o"] != 1 {
+		t.Fatalf("consecutive empty = %d, timeouts = %v", counters.ConsecutiveEmpty, counters.ConsecutiveTimeouts)
+	}
+
+	counters = serveChannelFailureCounters(t, http.MethodDelete, "/api/channel/1703/failures")
+	if counters.FailureCount != 0 || counters.BackoffUntil != 0 || counters.ConsecutiveEmpty != 0 || len(counters.ConsecutiveTimeouts) != 0 {
+		t.Fatalf("counters after reset = %+v, want zero", counters)
+	}
+	var logs []model.Log
+	if err := model.LOG_DB.Where("type = ?", model.LogTypeManage).Find(&logs).Error; err != nil || len(logs) != 1 {
+		t.Fatalf("manage logs = %d, %v, want the reset recorded", len(logs), err)
+	}
+}
@@ -707,6 +707,23 @@ type ChannelTag struct {
 	Groups         *string `json:"groups"`
 	ParamOverride  *string `json:"param_override"`
 	HeaderOverride *string `json:"header_override"`
+	Reason         string  `json:"reason"`
+}
+
+func respondChannelTagStatusResult(c *gin.Context, result *service.ChannelTagStatusResult) {
+	if len(result.Failed) > 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("%d 个渠道更新成功，%d 个渠道更新失败", len(result.Updated), len(result.Failed)),
+			"data":    result,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    result,
+	})
 }
 
 func DisableTagChannels(c *gin.Context) {
@@ -719,17 +736,17 @@ func DisableTagChannels(c *gin.Context) {
 		})
 		return
 	}
-	err = model.DisableChannelByTag(channelTag.Tag)
+	reason := channelTag.Reason
+	if reason == "" {
+		reason = fmt.Sprintf("disabled by tag %s", channelTag.Tag)
+	}
+	result, err := service.DisableChannelsByTag(channelTag.Tag, reason)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
 	model.InitChannelCache()
-	c.JSON(http.StatusOK, gin.H{
-		"success": true,
-		"message": "",
-	})
-	return
+	respondChannelTagStatusResult(c, result)
 }
 
 func EnableTagChannels(c *gin.Context) {
@@ -742,17 +759,83 @@ func EnableTagChannels(c *gin.Context) {
 		})
 		return
 	}
-	err = model.EnableChannelByTag(channelTag.Tag)
+	result, err := service.EnableChannelsByTag(channelTag.Tag)
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
 	model.InitChannelCache()
+	respondChannelTagStatusResult(c, result)
+}
+
+// EnableChannel 手动恢复单个被禁用的渠道
+func EnableChannel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err = service.EnableChannelById(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.InitChannelCache()
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("手动启用渠道 (渠道ID: %d)", id))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+type PauseChannelRequest struct {
+	Reason   string `json:"reason"`
+	ResumeAt int64  `json:"resume_at"` // 自动恢复时间（Unix 秒），0 表示需手动恢复
+}
+
+func PauseChannel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	req := PauseChannelRequest{}
+	if c.Request.ContentLength > 0 {
+		if err = c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "参数错误",
+			})
+			return
+		}
+	}
+	if err = service.PauseChannelById(id, req.Reason, req.ResumeAt); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.InitChannelCache()
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("暂停渠道 (渠道ID: %d, 原因: %s)", id, req.Reason))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func ResumeChannel(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err = service.ResumeChannelById(id); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.InitChannelCache()
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("恢复暂停的渠道 (渠道ID: %d)", id))
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
 	})
-	return
 }
 
 func EditTagChannels(c *gin.Context) {
@@ -30,9 +30,10 @@ func TestStatus(c *gin.Context) {
 	// 获取HTTP统计信息
 	httpStats := middleware.GetStats()
 	c.JSON(http.StatusOK, gin.H{
-		"success":    true,
-		"message":    "Server is running",
-		"http_stats": httpStats,
+		"success":          true,
+		"message":          "Server is running",
+		"http_stats":       httpStats,
+		"rate_limit_stats": middleware.GetRateLimitStats(),
 	})
 	return
 }
@@ -191,6 +191,267 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "UserDailyRateLimitGroup":
+		err = setting.CheckUserDailyRateLimitGroup(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "RateLimitRejectStatusCode":
+		err = setting.CheckRateLimitRejectStatusCode(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ChannelMaxConcurrency":
+		err = setting.CheckChannelMaxConcurrency(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ModelAliasGroups":
+		err = setting.CheckModelAliasGroups(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ChannelFallbackChains":
+		err = setting.CheckChannelFallbackChains(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ModelRequestTimeout":
+		err = setting.CheckModelRequestTimeout(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ModelMaxTokensCap":
+		err = setting.CheckModelMaxTokensCap(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "RateLimitLowPriorityReservePercent":
+		err = setting.CheckRateLimitLowPriorityReservePercent(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "RateLimitTotalRejectStatusCode", "RateLimitSuccessRejectStatusCode":
+		err = setting.CheckRateLimitKindRejectStatusCode(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "RateLimitStrictGroup":
+		err = setting.CheckRateLimitStrictGroup(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ErrorFormat":
+		err = setting.CheckErrorFormat(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "RateLimitSandboxDurationSeconds":
+		err = setting.CheckRateLimitSandboxDurationSeconds(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "RateLimitBackpressureThresholdPercent":
+		err = setting.CheckRateLimitBackpressureThresholdPercent(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "RateLimitBackpressureMaxDelayMs":
+		err = setting.CheckRateLimitBackpressureMaxDelayMs(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "SoftDisableWeightFactor":
+		err = setting.CheckSoftDisableWeightFactor(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "TokenQuotaSchedule":
+		err = setting.CheckTokenQuotaSchedule(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ModelGlobalRateLimit":
+		err = setting.CheckModelGlobalRateLimit(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "TokenRateLimitWindowMode":
+		err = setting.CheckRateLimitWindowMode(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ChannelProviderThrottlePercent":
+		err = setting.CheckChannelProviderThrottlePercent(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "RateLimitRules":
+		err = setting.CheckRateLimitRules(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ChannelDisableRegexByType":
+		err = setting.CheckChannelDisableRegexByType(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ModelDailyCap":
+		err = setting.CheckModelDailyCap(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "GroupForbiddenParams":
+		err = setting.CheckGroupForbiddenParams(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "RateLimitResetHeaderFormat":
+		err = setting.CheckRateLimitResetHeaderFormat(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "RateLimitBlockMaxMs":
+		err = setting.CheckRateLimitBlockMaxMs(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "SuccessLimiterAlgorithm":
+		err = setting.CheckSuccessLimiterAlgorithm(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ModelMinSuccessRate":
+		err = setting.CheckModelMinSuccessRate(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ChannelMinSuccessRate":
+		err = setting.CheckChannelMinSuccessRate(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	case "ShadowRateLimitAlgorithm":
+		err = setting.CheckShadowRateLimitAlgorithm(option.Value.(string))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
 	case "console_setting.api_info":
 		err = console_setting.ValidateConsoleSettings(option.Value.(string), "ApiInfo")
 		if err != nil {
@@ -228,11 +489,13 @@ func UpdateOption(c *gin.Context) {
 			return
 		}
 	}
+	oldValue := currentOptionValue(option.Key)
 	err = model.UpdateOption(option.Key, option.Value.(string))
 	if err != nil {
 		common.ApiError(c, err)
 		return
 	}
+	recordRateLimitAudit(c, model.RateLimitAuditSourceOption, option.Key, oldValue, option.Value.(string))
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
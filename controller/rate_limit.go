@@ -0,0 +1,523 @@
+package controller
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SimulateRateLimitRequest struct {
+	// 为空时使用当前的模型请求限流配置
+	Config *limiter.SimulationConfig `json:"config"`
+	Events []limiter.SimulationEvent `json:"events"`
+}
+
+// SimulateRateLimit 按给定配置模拟一组请求的限流判定结果，用于调整限流参数，不影响线上计数
+func SimulateRateLimit(c *gin.Context) {
+	var req SimulateRateLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	config := limiter.SimulationConfig{
+		DurationSeconds: int64(setting.ModelRequestRateLimitDurationMinutes * 60),
+		TotalMaxCount:   setting.ModelRequestRateLimitCount,
+		SuccessMaxCount: setting.ModelRequestRateLimitSuccessCount,
+	}
+	if req.Config != nil {
+		config = *req.Config
+	}
+	if config.DurationSeconds <= 0 || config.TotalMaxCount < 0 || config.SuccessMaxCount < 0 {
+		common.ApiErrorMsg(c, "限流配置无效")
+		return
+	}
+	common.ApiSuccess(c, limiter.SimulateRateLimit(config, req.Events))
+}
+
+// RateLimitHealth 供负载均衡使用的限流就绪检查：Redis 模式下 PING 限流使用的 Redis 客户端，
+// 不可达时返回 503（错误详情只写入日志，避免在无需鉴权的接口中暴露 Redis 地址）；最近有 Redis 错误被放行掩盖时返回 degraded，并返回累计放行次数 ratelimit_fail_open_total。
+func RateLimitHealth(c *gin.Context) {
+	data := gin.H{
+		"mode":                      "memory",
+		"status":                    "ok",
+		"ratelimit_fail_open_total": middleware.GetRateLimitFailOpenTotal(),
+	}
+	if !common.RedisEnabled() {
+		c.JSON(http.StatusOK, data)
+		return
+	}
+	data["mode"] = "redis"
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Second)
+	defer cancel()
+	if err := common.RDB().Ping(ctx).Err(); err != nil {
+		common.SysError("rate limit health check: redis ping failed: " + err.Error())
+		data["status"] = "unavailable"
+		c.JSON(http.StatusServiceUnavailable, data)
+		return
+	}
+	if middleware.IsRateLimitFailOpenActive(time.Minute) {
+		data["status"] = "degraded"
+	}
+	c.JSON(http.StatusOK, data)
+}
+
+// GetTokenRateLimitBreakdown 查询某个令牌当前所有生效限流维度的计数与上限，用于排查请求被限流的原因
+func GetTokenRateLimitBreakdown(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	token, err := model.GetTokenById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	userCache, err := model.GetUserCache(token.UserId)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	limits, err := middleware.GetTokenRateLimitBreakdown(token.Id, token.Group, token.UserId, userCache.Group)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, gin.H{
+		"token_id":    token.Id,
+		"token_group": token.Group,
+		"user_id":     token.UserId,
+		"user_group":  userCache.Group,
+		"limits":      limits,
+	})
+}
+
+type PreviewGroupRateLimitRequest struct {
+	Group        string `json:"group"`
+	Daily        bool   `json:"daily"`
+	TotalCount   int    `json:"total_count"`
+	SuccessCount int    `json:"success_count"`
+}
+
+// PreviewGroupRateLimit 预览修改分组令牌限流后的影响：按当前计数统计分组内有多少启用中的令牌会立即被新上限限流
+func PreviewGroupRateLimit(c *gin.Context) {
+	var req PreviewGroupRateLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Group == "" || req.TotalCount < 0 || req.SuccessCount < 0 {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	tokenIds, err := model.GetEnabledTokenIdsByGroup(req.Group)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	preview, err := middleware.PreviewTokenGroupRateLimit(tokenIds, req.Group, req.Daily, req.TotalCount, req.SuccessCount)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	common.ApiSuccess(c, preview)
+}
+
+type UpdateGroupRateLimitRequest struct {
+	Group        string `json:"group"`
+	Daily        bool   `json:"daily"`
+	TotalCount   int    `json:"total_count"`
+	SuccessCount int    `json:"success_count"`
+}
+
+// UpdateGroupRateLimit 批量设置分组内所有令牌的限流：修改 TokenRateLimitGroup（daily 为 true 时修改 TokenDailyRateLimitGroup）
+// 中该分组的条目并保存，返回分组内启用中的令牌数量
+func UpdateGroupRateLimit(c *gin.Context) {
+	var req UpdateGroupRateLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Group == "" {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	key, current := "TokenRateLimitGroup", setting.TokenRateLimitGroup2JSONString()
+	if req.Daily {
+		key, current = "TokenDailyRateLimitGroup", setting.TokenDailyRateLimitGroup2JSONString()
+	}
+	groups := make(map[string][2]int)
+	if err := common.UnmarshalJsonStr(current, &groups); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	groups[req.Group] = [2]int{req.TotalCount, req.SuccessCount}
+	jsonBytes, err := common.Marshal(groups)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	value := string(jsonBytes)
+	if err = setting.CheckRateLimitConfigOption(key, value); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	oldValue := currentOptionValue(key)
+	if err = model.UpdateOption(key, value); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	recordRateLimitAudit(c, model.RateLimitAuditSourceOption, key, oldValue, value)
+	tokenIds, err := model.GetEnabledTokenIdsByGroup(req.Group)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("设置分组令牌限流 (分组: %s, 每日: %t, 总请求数: %d, 成功请求数: %d)", req.Group, req.Daily, req.TotalCount, req.SuccessCount))
+	common.ApiSuccess(c, gin.H{
+		"group":         req.Group,
+		"daily":         req.Daily,
+		"active_tokens": len(tokenIds),
+	})
+}
+
+type UpdateTokenOrgRequest struct {
+	OrgId int `json:"org_id"`
+}
+
+// UpdateTokenOrg 设置令牌所属的组织/团队，同一组织的令牌共享组织级限流额度
+func UpdateTokenOrg(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req UpdateTokenOrgRequest
+	if err = c.ShouldBindJSON(&req); err != nil || req.OrgId < 0 {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	token, err := model.GetTokenById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err = token.UpdateOrgId(req.OrgId); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("设置令牌所属组织 (令牌ID: %d, 组织ID: %d)", id, req.OrgId))
+	common.ApiSuccess(c, gin.H{
+		"token_id": token.Id,
+		"org_id":   token.OrgId,
+	})
+}
+
+type UpdateTokenRateLimitAlgorithmRequest struct {
+	Algorithm string `json:"algorithm"`
+}
+
+// UpdateTokenRateLimitAlgorithm 设置令牌的成功请求数限制算法，覆盖全局的 SuccessLimiterAlgorithm，传空字符串恢复跟随全局设置
+func UpdateTokenRateLimitAlgorithm(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req UpdateTokenRateLimitAlgorithmRequest
+	if err = c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	if req.Algorithm != "" {
+		if err = setting.CheckSuccessLimiterAlgorithm(req.Algorithm); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	token, err := model.GetTokenById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err = token.UpdateRateLimitAlgorithm(req.Algorithm); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("设置令牌限流算法 (令牌ID: %d, 算法: %s)", id, req.Algorithm))
+	common.ApiSuccess(c, gin.H{
+		"token_id":             token.Id,
+		"rate_limit_algorithm": token.RateLimitAlgorithm,
+	})
+}
+
+type UpdateTokenCustomerIdsRequest struct {
+	CustomerIds string `json:"customer_ids"`
+}
+
+// UpdateTokenCustomerIds 设置令牌允许通过 X-Customer-Id 传入的终端客户 ID，逗号或换行分隔，传空字符串表示不限制
+func UpdateTokenCustomerIds(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req UpdateTokenCustomerIdsRequest
+	if err = c.ShouldBindJSON(&req); err != nil {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	token, err := model.GetTokenById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err = token.UpdateCustomerIds(strings.TrimSpace(req.CustomerIds)); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("设置令牌终端客户列表 (令牌ID: %d, 客户数: %d)", id, len(model.ParseTokenCustomerIds(token.CustomerIds))))
+	common.ApiSuccess(c, gin.H{
+		"token_id":     token.Id,
+		"customer_ids": token.CustomerIds,
+	})
+}
+
+type UpdateTokenBillingAnchorRequest struct {
+	Day int `json:"day"`
+}
+
+// UpdateTokenBillingAnchor 设置令牌的账单日（1~31），传 0 表示取令牌创建当天的日期
+func UpdateTokenBillingAnchor(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	var req UpdateTokenBillingAnchorRequest
+	if err = c.ShouldBindJSON(&req); err != nil || req.Day < 0 || req.Day > 31 {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	token, err := model.GetTokenById(id)
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if err = token.UpdateBillingAnchorDay(req.Day); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("设置令牌账单日 (令牌ID: %d, 账单日: %d)", id, req.Day))
+	window := setting.BillingPeriodWindow(token.GetBillingAnchorDay(), time.Now())
+	common.ApiSuccess(c, gin.H{
+		"token_id":           token.Id,
+		"billing_anchor_day": token.GetBillingAnchorDay(),
+		"period_start":       window.Start.Unix(),
+		"period_reset":       window.Reset.Unix(),
+	})
+}
+
+// QuotaLimitStatus 按额度限制的当前使用情况
+type QuotaLimitStatus struct {
+	Name      string `json:"name"`
+	Limit     int    `json:"limit"`
+	Used      int    `json:"used"`
+	Remaining int    `json:"remaining"`
+	ResetAt   int64  `json:"reset_at"` // 计数清零的时间戳
+}
+
+func newQuotaLimitStatus(name string, limit int, used int, resetAt time.Time) QuotaLimitStatus {
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return QuotaLimitStatus{Name: name, Limit: limit, Used: used, Remaining: remaining, ResetAt: resetAt.Unix()}
+}
+
+// GetCallerRateLimits 返回调用方令牌当前生效的全部限制及用量，只读，不消耗任何额度。响应格式：
+//
+//	{
+//	  "object": "rate_limits",
+//	  "token_id": 1,
+//	  "limits": [{"name": "token_minute_total", "limit": 60, "remaining": 59, "window": 60, "reset": 0}],
+//	  "quotas": [{"name": "token_daily_quota", "limit": 500000, "used": 1200, "remaining": 498800, "reset_at": 1700000000}],
+//	  "token_quota": {"unlimited": false, "remaining": 100000}
+//	}
+//
+// limits 为按请求数的限制（分钟级、每日、per-user），reset 为释放出一个名额还需的秒数；
+// quotas 为按额度的限制（每日额度、账单周期额度）
+func GetCallerRateLimits(c *gin.Context) {
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	userId := c.GetInt("id")
+	limits, err := middleware.GetRateLimitStatuses(
+		tokenId,
+		common.GetContextKeyString(c, constant.ContextKeyTokenGroup),
+		userId,
+		common.GetContextKeyString(c, constant.ContextKeyUserGroup),
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": gin.H{
+				"message": "failed to get rate limits: " + err.Error(),
+				"type":    "new_api_error",
+			},
+		})
+		return
+	}
+
+	// 浏览器端可读取响应头中的剩余额度，查询本身不消耗任何额度
+	middleware.SetRateLimitHeaders(c, limits)
+
+	now := time.Now()
+	quotas := make([]QuotaLimitStatus, 0, 2)
+	if limit := setting.TokenDailyQuotaCredits; limit > 0 {
+		if used, err := model.GetTokenDailyQuotaUsed(tokenId); err == nil {
+			tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+			quotas = append(quotas, newQuotaLimitStatus("token_daily_quota", limit, used, tomorrow))
+		}
+	}
+	if limit := setting.TokenBillingPeriodQuotaCredits; limit > 0 {
+		if used, window, err := model.GetTokenPeriodQuotaUsed(tokenId, common.GetContextKeyInt(c, constant.ContextKeyTokenBillingAnchorDay)); err == nil {
+			quotas = append(quotas, newQuotaLimitStatus("token_billing_period_quota", limit, used, window.Reset))
+		}
+	}
+
+	unlimited := c.GetBool("token_unlimited_quota")
+	tokenQuota := gin.H{"unlimited": unlimited}
+	if !unlimited {
+		tokenQuota["remaining"] = c.GetInt("token_quota")
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":      "rate_limits",
+		"token_id":    tokenId,
+		"limits":      limits,
+		"quotas":      quotas,
+		"token_quota": tokenQuota,
+	})
+}
+
+// ExportTokenUsage 导出所有令牌当前的分钟级与每日请求计数，用于账单核对。format=csv 时返回 CSV 文件，否则返回 JSON
+func ExportTokenUsage(c *gin.Context) {
+	capturedAt := time.Now()
+	snapshots, err := middleware.ExportTokenUsage(c.Request.Context())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	if c.Query("format") != "csv" {
+		common.ApiSuccess(c, gin.H{
+			"captured_at": capturedAt.Unix(),
+			"tokens":      snapshots,
+		})
+		return
+	}
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=token_usage_%s.csv", capturedAt.Format("20060102150405")))
+	writer := csv.NewWriter(c.Writer)
+	_ = writer.Write(middleware.TokenUsageCSVHeader)
+	for _, snapshot := range snapshots {
+		_ = writer.Write(snapshot.CSVRecord(capturedAt))
+	}
+	writer.Flush()
+}
+
+// RateLimitConfig 限流配置的导出文档，可导入到其它实例
+type RateLimitConfig struct {
+	Version int               `json:"version"`
+	Options map[string]string `json:"options"`
+}
+
+// ExportRateLimitConfig 导出全部限流配置（含分组限流表）为单个 JSON 文档
+func ExportRateLimitConfig(c *gin.Context) {
+	config := RateLimitConfig{
+		Version: setting.RateLimitConfigVersion,
+		Options: make(map[string]string),
+	}
+	common.OptionMapRWMutex.RLock()
+	for _, key := range setting.RateLimitConfigKeys() {
+		if value, ok := common.OptionMap[key]; ok {
+			config.Options[key] = value
+		}
+	}
+	common.OptionMapRWMutex.RUnlock()
+	common.ApiSuccess(c, config)
+}
+
+// ImportRateLimitConfig 导入限流配置文档：先用已有的校验逐项检查，全部通过后在一个事务中写入，
+// 任一项无效或写入失败时不修改任何配置
+func ImportRateLimitConfig(c *gin.Context) {
+	var config RateLimitConfig
+	if err := c.ShouldBindJSON(&config); err != nil || len(config.Options) == 0 {
+		common.ApiErrorMsg(c, "无效的参数")
+		return
+	}
+	if config.Version <= 0 || config.Version > setting.RateLimitConfigVersion {
+		common.ApiErrorMsg(c, fmt.Sprintf("不支持的限流配置版本: %d", config.Version))
+		return
+	}
+	for key, value := range config.Options {
+		if err := setting.CheckRateLimitConfigOption(key, value); err != nil {
+			common.ApiError(c, err)
+			return
+		}
+	}
+	oldValues := make(map[string]string, len(config.Options))
+	for key := range config.Options {
+		oldValues[key] = currentOptionValue(key)
+	}
+	if err := model.UpdateOptions(config.Options); err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	for key, value := range config.Options {
+		recordRateLimitAudit(c, model.RateLimitAuditSourceImport, key, oldValues[key], value)
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("导入限流配置 (版本: %d, 配置项: %d)", config.Version, len(config.Options)))
+	common.ApiSuccess(c, gin.H{
+		"imported": len(config.Options),
+	})
+}
+
+// currentOptionValue 返回配置项当前的值，用于记录变更前的值
+func currentOptionValue(key string) string {
+	common.OptionMapRWMutex.RLock()
+	defer common.OptionMapRWMutex.RUnlock()
+	return common.OptionMap[key]
+}
+
+// recordRateLimitAudit 限流配置项的值发生变化时记录审计日志，非限流配置项或值未变化时不记录
+func recordRateLimitAudit(c *gin.Context, source string, key string, oldValue string, newValue string) {
+	if !setting.IsRateLimitConfigOption(key) || oldValue == newValue {
+		return
+	}
+	err := model.RecordRateLimitAudit(&model.RateLimitAudit{
+		ActorId:   c.GetInt("id"),
+		ActorName: c.GetString("username"),
+		Source:    source,
+		OptionKey: key,
+		OldValue:  oldValue,
+		NewValue:  newValue,
+	})
+	if err != nil {
+		common.SysLog(fmt.Sprintf("failed to record rate limit audit for %s: %s", key, err.Error()))
+	}
+}
+
+// GetRateLimitAudits 分页查询限流配置的变更记录，可按配置项过滤
+func GetRateLimitAudits(c *gin.Context) {
+	pageInfo := common.GetPageQuery(c)
+	audits, total, err := model.GetRateLimitAudits(c.Query("key"), pageInfo.GetStartIdx(), pageInfo.GetPageSize())
+	if err != nil {
+		common.ApiError(c, err)
+		return
+	}
+	pageInfo.SetTotal(int(total))
+	pageInfo.SetItems(audits)
+	common.ApiSuccess(c, pageInfo)
+}
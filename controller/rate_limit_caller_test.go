@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+type callerRateLimits struct {
+	Object     string                       `json:"object"`
+	TokenId    int                          `json:"token_id"`
+	Limits     []middleware.RateLimitStatus `json:"limits"`
+	Quotas     []QuotaLimitStatus           `json:"quotas"`
+	TokenQuota struct {
+		Unlimited bool `json:"unlimited"`
+		Remaining int  `json:"remaining"`
+	} `json:"token_quota"`
+}
+
+// newCallerRateLimitsRouter 模拟 TokenAuth 写入的上下文，/v1/chat/completions 经过模型请求限流，/v1/rate_limits 只读
+func newCallerRateLimitsRouter(tokenId int) *gin.Engine {
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyTokenId, tokenId)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "default")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		c.Set("token_quota", 1000)
+		c.Next()
+	})
+	r.POST("/v1/chat/completions", middleware.ModelRequestRateLimit(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.GET("/v1/rate_limits", GetCallerRateLimits)
+	return r
+}
+
+func getCallerRateLimits(t *testing.T, r *gin.Engine) callerRateLimits {
+	t.Helper()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/rate_limits", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body.String())
+	}
+	var resp callerRateLimits
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestCallerRateLimitsReflectUsage(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	common.SetRedisEnabled(false)
+	constant.MaxRequestBodyMB = 8
+	setting.TokenRateLimitEnabled = true
+	setting.TokenRateLimitCount = 5
+	setting.TokenRateLimitSuccessCount = 0
+	setting.TokenDailyQuotaCredits = 1000
+	t.Cleanup(func() {
+		setting.TokenRateLimitEnabled = false
+		setting.TokenRateLimitCount = 0
+		setting.TokenDailyQuotaCredits = 0
+	})
+
+	r := newCallerRateLimitsRouter(1711)
+	for i := 0; i < 2; i++ {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, w.Code)
+		}
+	}
+	model.IncreaseTokenDailyQuotaUsed(1711, 300)
+
+	// 多次查询不消耗限流名额
+	for i := 0; i < 3; i++ {
+		resp := getCallerRateLimits(t, r)
+		if resp.Object != "rate_limits" || resp.TokenId != 1711 {
+			t.Fatalf("object = %q, token_id = %d", resp.Object, resp.TokenId)
+		}
+		if len(resp.Limits) != 1 || resp.Limits[0].Name != "token_minute_total" || resp.Limits[0].Limit != 5 || resp.Limits[0].Remaining != 3 {
+			t.Fatalf("query %d: limits = %+v, want token_minute_total 3/5 remaining", i+1, resp.Limits)
+		}
+		if len(resp.Quotas) != 1 || resp.Quotas[0].Name != "token_daily_quota" || resp.Quotas[0].Used != 300 || resp.Quotas[0].Remaining != 700 {
+			t.Fatalf("quotas = %+v, want token_daily_quota 300 used of 1000", resp.Quotas)
+		}
+		if resp.TokenQuota.Unlimited || resp.TokenQuota.Remaining != 1000 {
+			t.Fatalf("token_quota = %+v", resp.TokenQuota)
+		}
+	}
+}
+
+func TestCallerRateLimitsEmptyWhenUnlimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	common.SetRedisEnabled(false)
+	setting.TokenRateLimitEnabled = false
+	setting.TokenDailyQuotaCredits = 0
+
+	resp := getCallerRateLimits(t, newCallerRateLimitsRouter(1712))
+	if len(resp.Limits) != 0 || len(resp.Quotas) != 0 {
+		t.Fatalf("limits = %+v, quotas = %+v, want none configured", resp.Limits, resp.Quotas)
+	}
+}
@@ -0,0 +1,520 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/middleware"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"github.com/go-redis/redis/v8"
+	"gorm.io/gorm"
+)
+
+// startPongRedis 启动一个对任何命令都回复 PONG 的 Redis 服务端
+func startPongRedis(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					// 只处理 PING 命令
+					if line == "PING\r\n" || line == "ping\r\n" {
+						io.WriteString(conn, "+PONG\r\n")
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// unreachableRedisAddr 返回一个没有服务监听的地址
+func unreachableRedisAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	_ = ln.Close()
+	return addr
+}
+
+func serveRateLimitHealth(t *testing.T, addr string) (int, map[string]any) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	oldRedisEnabled, oldRDB := common.RedisEnabled(), common.RDB()
+	t.Cleanup(func() {
+		common.SetRedisEnabled(oldRedisEnabled)
+		common.SetRDB(oldRDB)
+	})
+	common.SetRedisEnabled(addr != "")
+	if addr != "" {
+		rdb := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1})
+		t.Cleanup(func() { _ = rdb.Close() })
+		common.SetRDB(rdb)
+	}
+
+	r := gin.New()
+	r.GET("/healthz/ratelimit", RateLimitHealth)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/healthz/ratelimit", nil))
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid body %q: %v", w.Body.String(), err)
+	}
+	return w.Code, body
+}
+
+func TestRateLimitHealthMemoryMode(t *testing.T) {
+	code, body := serveRateLimitHealth(t, "")
+	if code != http.StatusOK || body["mode"] != "memory" || body["status"] != "ok" {
+		t.Fatalf("got %d %v, want 200 memory ok", code, body)
+	}
+}
+
+func TestRateLimitHealthReportsFailOpenTotal(t *testing.T) {
+	_, body := serveRateLimitHealth(t, "")
+	total, ok := body["ratelimit_fail_open_total"].(float64)
+	if !ok || int64(total) != middleware.GetRateLimitFailOpenTotal() {
+		t.Fatalf("ratelimit_fail_open_total = %v, want %d", body["ratelimit_fail_open_total"], middleware.GetRateLimitFailOpenTotal())
+	}
+}
+
+func TestRateLimitHealthRedisReachable(t *testing.T) {
+	code, body := serveRateLimitHealth(t, startPongRedis(t))
+	if code != http.StatusOK || body["mode"] != "redis" || body["status"] != "ok" {
+		t.Fatalf("got %d %v, want 200 redis ok", code, body)
+	}
+}
+
+func TestRateLimitHealthRedisUnreachable(t *testing.T) {
+	addr := unreachableRedisAddr(t)
+	code, body := serveRateLimitHealth(t, addr)
+	if code != http.StatusServiceUnavailable || body["mode"] != "redis" || body["status"] != "unavailable" {
+		t.Fatalf("got %d %v, want 503 redis unavailable", code, body)
+	}
+	// 接口无需鉴权，不返回可能包含 Redis 地址的错误详情
+	if _, ok := body["error"]; ok {
+		t.Fatalf("health response exposes error detail: %v", body)
+	}
+	if data, _ := json.Marshal(body); strings.Contains(string(data), addr) {
+		t.Fatalf("health response contains the redis address: %s", data)
+	}
+}
+
+// setupRateLimitConfigDB 使用内存 SQLite 保存配置项，并以给定的限流配置初始化 OptionMap
+// initModelColumns 以内存 SQLite 执行一次 model.InitDB，初始化 model 中按数据库类型引用的列名（如 group、key）
+func initModelColumns(t *testing.T) {
+	t.Helper()
+	t.Setenv("SQL_DSN", "")
+	oldDB, oldPath, oldMaster, oldSQLite := model.DB, common.SQLitePath, common.IsMasterNode, common.UsingSQLite
+	common.SQLitePath = "file:init_model_columns?mode=memory"
+	common.IsMasterNode = false
+	err := model.InitDB()
+	if err == nil {
+		if sqlDB, dbErr := model.DB.DB(); dbErr == nil {
+			_ = sqlDB.Close()
+		}
+	}
+	model.DB, common.SQLitePath, common.IsMasterNode, common.UsingSQLite = oldDB, oldPath, oldMaster, oldSQLite
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setupRateLimitConfigDB(t *testing.T, options map[string]string) {
+	t.Helper()
+	initModelColumns(t)
+	gin.SetMode(gin.TestMode)
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", name)), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err = db.AutoMigrate(&model.Option{}, &model.User{}, &model.Log{}, &model.RateLimitAudit{}, &model.Token{}); err != nil {
+		t.Fatal(err)
+	}
+	oldDB, oldLogDB, oldRedis, oldOptionMap := model.DB, model.LOG_DB, common.RedisEnabled(), common.OptionMap
+	oldEnabled, oldCount, oldMode := setting.TokenRateLimitEnabled, setting.TokenRateLimitCount, setting.TokenRateLimitWindowMode
+	model.DB, model.LOG_DB = db, db
+	common.SetRedisEnabled(false)
+	common.OptionMap = make(map[string]string)
+	t.Cleanup(func() {
+		model.DB, model.LOG_DB, common.OptionMap = oldDB, oldLogDB, oldOptionMap
+		common.SetRedisEnabled(oldRedis)
+		setting.TokenRateLimitEnabled, setting.TokenRateLimitCount, setting.TokenRateLimitWindowMode = oldEnabled, oldCount, oldMode
+		_ = sqlDB.Close()
+	})
+	for key, value := range options {
+		if err = model.UpdateOption(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func exportRateLimitConfig(t *testing.T) RateLimitConfig {
+	t.Helper()
+	r := gin.New()
+	r.GET("/api/option/rate_limit/export", ExportRateLimitConfig)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/option/rate_limit/export", nil))
+	var resp struct {
+		Success bool            `json:"success"`
+		Data    RateLimitConfig `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || !resp.Success {
+		t.Fatalf("export failed: %s", w.Body.String())
+	}
+	return resp.Data
+}
+
+func importRateLimitConfig(t *testing.T, config RateLimitConfig) bool {
+	t.Helper()
+	body, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := gin.New()
+	r.POST("/api/option/rate_limit/import", ImportRateLimitConfig)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/option/rate_limit/import", bytes.NewReader(body)))
+	var resp struct {
+		Success bool `json:"success"`
+	}
+	if err = json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("invalid body %q: %v", w.Body.String(), err)
+	}
+	return resp.Success
+}
+
+func TestRateLimitConfigRoundTrip(t *testing.T) {
+	setupRateLimitConfigDB(t, map[string]string{
+		"TokenRateLimitEnabled":    "true",
+		"TokenRateLimitCount":      "42",
+		"TokenRateLimitWindowMode": setting.RateLimitWindowFixed,
+		"Notice":                   "not a rate limit option",
+	})
+
+	config := exportRateLimitConfig(t)
+	if config.Version != setting.RateLimitConfigVersion {
+		t.Fatalf("exported version %d, want %d", config.Version, setting.RateLimitConfigVersion)
+	}
+	if config.Options["TokenRateLimitCount"] != "42" || config.Options["TokenRateLimitWindowMode"] != setting.RateLimitWindowFixed {
+		t.Fatalf("exported options %v missing configured values", config.Options)
+	}
+	if _, ok := config.Options["Notice"]; ok {
+		t.Fatal("export included a non rate-limit option")
+	}
+
+	// 修改配置后导入之前导出的文档，应恢复原值
+	for key, value := range map[string]string{"TokenRateLimitEnabled": "false", "TokenRateLimitCount": "7"} {
+		if err := model.UpdateOption(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !importRateLimitConfig(t, config) {
+		t.Fatal("importing an exported config failed")
+	}
+	if !setting.TokenRateLimitEnabled || setting.TokenRateLimitCount != 42 || setting.TokenRateLimitWindowMode != setting.RateLimitWindowFixed {
+		t.Fatalf("after import got enabled=%v count=%d mode=%q, want true 42 fixed",
+			setting.TokenRateLimitEnabled, setting.TokenRateLimitCount, setting.TokenRateLimitWindowMode)
+	}
+	var option model.Option
+	if err := model.DB.Where("key = ?", "TokenRateLimitCount").First(&option).Error; err != nil || option.Value != "42" {
+		t.Fatalf("persisted TokenRateLimitCount = %q (%v), want 42", option.Value, err)
+	}
+}
+
+func TestImportRateLimitConfigRejectsInvalid(t *testing.T) {
+	setupRateLimitConfigDB(t, map[string]string{"TokenRateLimitCount": "10"})
+
+	invalid := []RateLimitConfig{
+		// 任一项无效时整份文档都不生效
+		{Version: setting.RateLimitConfigVersion, Options: map[string]string{"TokenRateLimitCount": "20", "TokenRateLimitWindowMode": "bogus"}},
+		{Version: setting.RateLimitConfigVersion, Options: map[string]string{"TokenRateLimitCount": "twenty"}},
+		{Version: setting.RateLimitConfigVersion, Options: map[string]string{"TokenRateLimitCount": "20", "Notice": "hi"}},
+		{Version: setting.RateLimitConfigVersion + 1, Options: map[string]string{"TokenRateLimitCount": "20"}},
+		{Version: 0, Options: map[string]string{"TokenRateLimitCount": "20"}},
+		{Version: setting.RateLimitConfigVersion},
+	}
+	for i, config := range invalid {
+		if importRateLimitConfig(t, config) {
+			t.Fatalf("case %d: import of %+v succeeded, want rejected", i, config)
+		}
+		if setting.TokenRateLimitCount != 10 || common.OptionMap["TokenRateLimitCount"] != "10" {
+			t.Fatalf("case %d: rejected import changed TokenRateLimitCount to %d", i, setting.TokenRateLimitCount)
+		}
+	}
+}
+
+func TestRateLimitConfigRoundTripModelAliasGroups(t *testing.T) {
+	oldAliases := setting.ModelAliasGroups2JSONString()
+	t.Cleanup(func() { _ = setting.UpdateModelAliasGroupsByJSONString(oldAliases) })
+	setupRateLimitConfigDB(t, map[string]string{"ModelAliasGroups": `{"gpt-4o-153":["gpt4o-153"]}`})
+
+	config := exportRateLimitConfig(t)
+	if config.Options["ModelAliasGroups"] != `{"gpt-4o-153":["gpt4o-153"]}` {
+		t.Fatalf("exported ModelAliasGroups = %q", config.Options["ModelAliasGroups"])
+	}
+	if err := model.UpdateOption("ModelAliasGroups", "{}"); err != nil {
+		t.Fatal(err)
+	}
+	if !importRateLimitConfig(t, config) {
+		t.Fatal("importing an exported config failed")
+	}
+	if got := setting.CanonicalModelName("gpt4o-153"); got != "gpt-4o-153" {
+		t.Fatalf("alias after import resolves to %q, want gpt-4o-153", got)
+	}
+}
+
+func TestImportRateLimitConfigAppliesNothingOnWriteError(t *testing.T) {
+	setupRateLimitConfigDB(t, map[string]string{"TokenRateLimitEnabled": "true", "TokenRateLimitCount": "10"})
+	// 配置表不可写时整份文档都不生效
+	if err := model.DB.Migrator().DropTable(&model.Option{}); err != nil {
+		t.Fatal(err)
+	}
+	config := RateLimitConfig{Version: setting.RateLimitConfigVersion, Options: map[string]string{
+		"TokenRateLimitEnabled": "false",
+		"TokenRateLimitCount":   "30",
+	}}
+	if importRateLimitConfig(t, config) {
+		t.Fatal("import succeeded without an options table")
+	}
+	if !setting.TokenRateLimitEnabled || setting.TokenRateLimitCount != 10 || common.OptionMap["TokenRateLimitCount"] != "10" {
+		t.Fatalf("failed import changed the config: enabled=%v count=%d", setting.TokenRateLimitEnabled, setting.TokenRateLimitCount)
+	}
+}
+
+// newRateLimitAuditRouter 以 id 为 1 的 root 管理员身份访问配置接口
+func newRateLimitAuditRouter() *gin.Engine {
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Set("id", 1)
+		c.Set("username", "root")
+		c.Next()
+	})
+	r.PUT("/api/option/", UpdateOption)
+	r.POST("/api/option/rate_limit/import", ImportRateLimitConfig)
+	r.GET("/api/option/rate_limit/audit", GetRateLimitAudits)
+	return r
+}
+
+func updateOptionAs(t *testing.T, r *gin.Engine, key string, value string) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]string{"key": key, "value": value})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/option/", bytes.NewReader(body)))
+	if !strings.Contains(w.Body.String(), `"success":true`) {
+		t.Fatalf("update %s failed: %s", key, w.Body.String())
+	}
+}
+
+func rateLimitAudits(t *testing.T) []model.RateLimitAudit {
+	t.Helper()
+	var audits []model.RateLimitAudit
+	if err := model.DB.Order("id asc").Find(&audits).Error; err != nil {
+		t.Fatal(err)
+	}
+	return audits
+}
+
+func TestRateLimitAuditOnOptionUpdate(t *testing.T) {
+	setupRateLimitConfigDB(t, map[string]string{"TokenRateLimitCount": "10", "Notice": "hello"})
+	r := newRateLimitAuditRouter()
+
+	updateOptionAs(t, r, "TokenRateLimitCount", "20")
+	// 值未变化与非限流配置项不记录
+	updateOptionAs(t, r, "TokenRateLimitCount", "20")
+	updateOptionAs(t, r, "Notice", "changed")
+	updateOptionAs(t, r, "TokenRateLimitCount", "5")
+
+	audits := rateLimitAudits(t)
+	if len(audits) != 2 {
+		t.Fatalf("got %d audit records, want 2: %+v", len(audits), audits)
+	}
+	first := audits[0]
+	if first.ActorId != 1 || first.ActorName != "root" || first.Source != model.RateLimitAuditSourceOption ||
+		first.OptionKey != "TokenRateLimitCount" || first.OldValue != "10" || first.NewValue != "20" || first.CreatedAt == 0 {
+		t.Fatalf("first audit = %+v, want root changing TokenRateLimitCount 10 -> 20", first)
+	}
+	if audits[1].OldValue != "20" || audits[1].NewValue != "5" {
+		t.Fatalf("second audit = %+v, want 20 -> 5", audits[1])
+	}
+
+	// 按配置项查询变更记录，最新的在前
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/option/rate_limit/audit?key=TokenRateLimitCount", nil))
+	var resp struct {
+		Success bool `json:"success"`
+		Data    struct {
+			Total int                    `json:"total"`
+			Items []model.RateLimitAudit `json:"items"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || !resp.Success {
+		t.Fatalf("audit query failed: %s", w.Body.String())
+	}
+	if resp.Data.Total != 2 || len(resp.Data.Items) != 2 || resp.Data.Items[0].NewValue != "5" {
+		t.Fatalf("audit query = %+v, want 2 records newest first", resp.Data)
+	}
+}
+
+func TestRateLimitAuditOnImport(t *testing.T) {
+	setupRateLimitConfigDB(t, map[string]string{"TokenRateLimitEnabled": "true", "TokenRateLimitCount": "10"})
+	r := newRateLimitAuditRouter()
+
+	body, _ := json.Marshal(RateLimitConfig{Version: setting.RateLimitConfigVersion, Options: map[string]string{
+		"TokenRateLimitEnabled": "true",
+		"TokenRateLimitCount":   "30",
+	}})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/api/option/rate_limit/import", bytes.NewReader(body)))
+	if !strings.Contains(w.Body.String(), `"success":true`) {
+		t.Fatalf("import failed: %s", w.Body.String())
+	}
+
+	// 只记录值发生变化的配置项
+	audits := rateLimitAudits(t)
+	if len(audits) != 1 {
+		t.Fatalf("got %d audit records, want 1: %+v", len(audits), audits)
+	}
+	if audits[0].Source != model.RateLimitAuditSourceImport || audits[0].ActorId != 1 ||
+		audits[0].OptionKey != "TokenRateLimitCount" || audits[0].OldValue != "10" || audits[0].NewValue != "30" {
+		t.Fatalf("import audit = %+v, want root importing TokenRateLimitCount 10 -> 30", audits[0])
+	}
+}
+
+func TestUpdateGroupRateLimitAppliesToGroupTokens(t *testing.T) {
+	setupRateLimitConfigDB(t, map[string]string{"TokenRateLimitEnabled": "true", "TokenRateLimitCount": "100"})
+	oldGroup, oldDailyGroup := setting.TokenRateLimitGroup2JSONString(), setting.TokenDailyRateLimitGroup2JSONString()
+	t.Cleanup(func() {
+		_ = setting.UpdateTokenRateLimitGroupByJSONString(oldGroup)
+		_ = setting.UpdateTokenDailyRateLimitGroupByJSONString(oldDailyGroup)
+	})
+	constant.MaxRequestBodyMB = 8
+	for _, token := range []*model.Token{
+		{Id: 1901, Key: "key1901", Group: "vip-190", Status: common.TokenStatusEnabled},
+		{Id: 1902, Key: "key1902", Group: "vip-190", Status: common.TokenStatusEnabled},
+		{Id: 1903, Key: "key1903", Group: "vip-190", Status: common.TokenStatusDisabled},
+		{Id: 1904, Key: "key1904", Group: "default", Status: common.TokenStatusEnabled},
+	} {
+		if err := model.DB.Create(token).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := newRateLimitAuditRouter()
+	r.PUT("/api/option/rate_limit/group", UpdateGroupRateLimit)
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyTokenId, 1901)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "vip-190")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "vip-190")
+		c.Next()
+	}, middleware.ModelRequestRateLimit(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	relay := func() int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+		req.Header.Set("Content-Type", "application/json")
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+	updateGroup := func(body string) map[string]any {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/option/rate_limit/group", strings.NewReader(body)))
+		var resp struct {
+			Success bool           `json:"success"`
+			Message string         `json:"message"`
+			Data    map[string]any `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("invalid body %q: %v", w.Body.String(), err)
+		}
+		if !resp.Success {
+			return nil
+		}
+		return resp.Data
+	}
+
+	if code := relay(); code != http.StatusOK {
+		t.Fatalf("request before the group update: status %d", code)
+	}
+
+	data := updateGroup(`{"group":"vip-190","total_count":2,"success_count":0}`)
+	if data == nil {
+		t.Fatal("group update failed")
+	}
+	// 只统计分组内启用中的令牌
+	if data["active_tokens"] != float64(2) {
+		t.Fatalf("active_tokens = %v, want 2", data["active_tokens"])
+	}
+	if setting.TokenRateLimitGroup["vip-190"] != [2]int{2, 0} || !strings.Contains(common.OptionMap["TokenRateLimitGroup"], `"vip-190":[2,0]`) {
+		t.Fatalf("TokenRateLimitGroup = %v, option = %q", setting.TokenRateLimitGroup, common.OptionMap["TokenRateLimitGroup"])
+	}
+
+	// 之后的请求按分组的新限制计数
+	if code := relay(); code != http.StatusOK {
+		t.Fatalf("second request: status %d", code)
+	}
+	if code := relay(); code != http.StatusTooManyRequests {
+		t.Fatalf("request over the new group limit: status %d, want %d", code, http.StatusTooManyRequests)
+	}
+
+	// 每日限制写入 TokenDailyRateLimitGroup
+	if data = updateGroup(`{"group":"vip-190","daily":true,"total_count":1000,"success_count":500}`); data == nil || data["daily"] != true {
+		t.Fatalf("daily group update = %v", data)
+	}
+	if setting.TokenDailyRateLimitGroup["vip-190"] != [2]int{1000, 500} {
+		t.Fatalf("TokenDailyRateLimitGroup = %v", setting.TokenDailyRateLimitGroup)
+	}
+
+	// 校验失败或缺少分组时不修改配置
+	for _, body := range []string{`{"group":"vip-190","total_count":-1}`, `{"total_count":1}`, `{`} {
+		if updateGroup(body) != nil {
+			t.Fatalf("invalid update %s succeeded", body)
+		}
+	}
+	if setting.TokenRateLimitGroup["vip-190"] != [2]int{2, 0} {
+		t.Fatalf("rejected update changed TokenRateLimitGroup to %v", setting.TokenRateLimitGroup)
+	}
+	if audits := rateLimitAudits(t); len(audits) != 2 {
+		t.Fatalf("got %d audit records, want one per successful update", len(audits))
+	}
+}
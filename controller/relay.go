@@ -2,12 +2,14 @@ package controller
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
@@ -62,10 +64,32 @@ func geminiRelayHandler(c *gin.Context, info *relaycommon.RelayInfo) *types.NewA
 	return err
 }
 
+// relayToChannelWithConcurrency 占用渠道并发名额后再转发，无论请求成功、失败还是客户端断开都会释放名额
+func relayToChannelWithConcurrency(c *gin.Context, relayInfo *relaycommon.RelayInfo, channel *model.Channel) *types.NewAPIError {
+	release, err := model.WaitChannelConcurrency(c.Request.Context(), channel.Id)
+	if err != nil {
+		return types.NewErrorWithStatusCode(fmt.Errorf("渠道 #%d 并发请求数已达上限: %w", channel.Id, err), types.ErrorCodeChannelConcurrencyExceeded, http.StatusTooManyRequests, types.ErrOptionWithNoRecordErrorLog())
+	}
+	defer release()
+	return relayToChannel(c, relayInfo, channel)
+}
+
 func relayToChannel(c *gin.Context, relayInfo *relaycommon.RelayInfo, channel *model.Channel) *types.NewAPIError {
 	requestBody, _ := common.GetRequestBody(c)
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 
+	// 按模型配置的上游请求超时，超时后的错误单独标记，由 ShouldDisableChannel 决定是否计入自动禁用
+	timeout := setting.GetModelRequestTimeout(relayInfo.OriginModelName)
+	if timeout > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
+		relayInfo.UpstreamCtx = ctx
+		defer func() {
+			cancel()
+			relayInfo.UpstreamCtx = nil
+		}()
+	}
+
+	common.SetContextKey(c, constant.ContextKeyEmptyResponse, false)
 	var newAPIError *types.NewAPIError
 	switch relayInfo.RelayFormat {
 	case types.RelayFormatOpenAIRealtime:
@@ -78,12 +102,108 @@ func relayToChannel(c *gin.Context, relayInfo *relaycommon.RelayInfo, channel *m
 		newAPIError = relayHandler(c, relayInfo)
 	}
 
+	if timeout > 0 {
+		if newAPIError == nil {
+			service.ResetModelTimeoutCount(channel.Id, relayInfo.OriginModelName)
+		} else if errors.Is(relayInfo.UpstreamCtx.Err(), context.DeadlineExceeded) {
+			newAPIError = service.NewModelTimeoutError(channel.Id, relayInfo.OriginModelName, timeout)
+		}
+	}
+
+	channelError := *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.ShouldAutoDisable())
+	// 先记录结果，使 DisableChannel 判断宽限期时已计入本次失败
+	if newAPIError == nil || attributeChannelFailure(c, channel.Id, newAPIError) {
+		recordChannelOutcome(channelError, relayInfo.OriginModelName, newAPIError)
+	}
 	if newAPIError != nil {
-		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+		processChannelError(c, channelError, newAPIError)
 	}
+	recordChannelEmptyResponse(c, channelError, newAPIError)
 	return newAPIError
 }
 
+// attributeChannelFailure 判断本次失败是否应计入该渠道。开启 ChannelRetryAttributionEnabled 后，
+// 同一请求内相同的错误只归因于最先出现该错误的渠道，重试到的其它渠道不再因此计入失败或被禁用。
+// 鉴权失败、额度用完等渠道自身的错误始终计入出错的渠道
+func attributeChannelFailure(c *gin.Context, channelId int, err *types.NewAPIError) bool {
+	if !setting.ChannelRetryAttributionEnabled || isChannelCausedError(err) {
+		return true
+	}
+	signature := channelFailureSignature(err)
+	attribution, _ := common.GetContextKeyType[map[string]int](c, constant.ContextKeyChannelFailureAttribution)
+	if attribution == nil {
+		attribution = make(map[string]int)
+		common.SetContextKey(c, constant.ContextKeyChannelFailureAttribution, attribution)
+	}
+	firstChannelId, ok := attribution[signature]
+	if !ok {
+		attribution[signature] = channelId
+		return true
+	}
+	return firstChannelId == channelId
+}
+
+// isChannelCausedError 错误是否由渠道自身（密钥无效、无权限、额度用完等）引起，与请求内容无关
+func isChannelCausedError(err *types.NewAPIError) bool {
+	if err.StatusCode == http.StatusUnauthorized || err.StatusCode == http.StatusForbidden || err.GetErrorType() == "insufficient_quota" {
+		return true
+	}
+	for _, code := range err.UpstreamErrorCodes() {
+		if code == "insufficient_quota" || setting.IsChannelDisableErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// channelFailureSignature 由错误码、状态码、上游错误类型、上游错误码与错误信息组成，只有完全相同的错误才视为同一个问题
+func channelFailureSignature(err *types.NewAPIError) string {
+	return fmt.Sprintf("%s:%d:%s:%s:%s", err.GetErrorCode(), err.StatusCode, err.GetErrorType(), strings.Join(err.UpstreamErrorCodes(), ","), err.Error())
+}
+
+// recordChannelOutcome 统计渠道与模型的成功率，失败后渠道成功率低于 ChannelMinSuccessRate 时禁用渠道，
+// 模型整体成功率低于 ModelMinSuccessRate 时告警
+func recordChannelOutcome(channelError types.ChannelError, modelName string, err *types.NewAPIError) {
+	if err != nil && !service.IsChannelFailure(err) {
+		return
+	}
+	model.RecordChannelOutcome(channelError.ChannelId, err == nil)
+	model.RecordModelOutcome(modelName, err == nil)
+	if err != nil {
+		model.RecordChannelQuarantineError(channelError.ChannelId)
+		service.CheckModelSuccessRate(modelName)
+	}
+	if err == nil || !channelError.AutoBan {
+		return
+	}
+	if disable, reason := service.ShouldDisableChannelBySuccessRate(channelError.ChannelId); disable {
+		gopool.Go(func() {
+			service.DisableChannel(channelError, err, reason)
+		})
+	}
+}
+
+// recordChannelEmptyResponse 统计渠道连续返回空回复的次数，达到 ChannelDisableOnEmptyAfterN 时禁用渠道。
+// 其它错误既不计入也不清零
+func recordChannelEmptyResponse(c *gin.Context, channelError types.ChannelError, err *types.NewAPIError) {
+	var empty bool
+	switch {
+	case err == nil:
+		empty = common.GetContextKeyBool(c, constant.ContextKeyEmptyResponse)
+	case service.IsEmptyResponseError(err):
+		empty = true
+	default:
+		return
+	}
+	disable, reason := service.ShouldDisableChannelOnEmpty(channelError.ChannelId, empty)
+	if !disable || !channelError.AutoBan {
+		return
+	}
+	gopool.Go(func() {
+		service.DisableChannel(channelError, err, reason)
+	})
+}
+
 func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 	requestId := c.GetString(common.RequestIdKey)
 	//group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
@@ -106,20 +226,15 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 
 	defer func() {
 		if newAPIError != nil {
+			// 响应头可能已经以 2xx 发出（如流式响应中途出错），标记出来避免被计为成功请求
+			common.SetContextKey(c, constant.ContextKeyResponseBodyError, true)
 			logger.LogError(c, fmt.Sprintf("relay error: %s", newAPIError.Error()))
 			newAPIError.SetMessage(common.MessageWithRequestId(newAPIError.Error(), requestId))
 			switch relayFormat {
 			case types.RelayFormatOpenAIRealtime:
 				helper.WssError(c, ws, newAPIError.ToOpenAIError())
-			case types.RelayFormatClaude:
-				c.JSON(newAPIError.StatusCode, gin.H{
-					"type":  "error",
-					"error": newAPIError.ToClaudeError(),
-				})
 			default:
-				c.JSON(newAPIError.StatusCode, gin.H{
-					"error": newAPIError.ToOpenAIError(),
-				})
+				service.WriteErrorResponse(c, newAPIError, relayFormat)
 			}
 		}
 	}()
@@ -220,13 +335,14 @@ func Relay(c *gin.Context, relayFormat types.RelayFormat) {
 		}
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 
-		newAPIError = relayToChannel(c, relayInfo, channel)
+		newAPIError = relayToChannelWithConcurrency(c, relayInfo, channel)
 
 		if newAPIError == nil {
 			return
 		}
 
-		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.GetAutoBan()), newAPIError)
+		processChannelError(c, *types.NewChannelError(channel.Id, channel.Type, channel.Name, channel.ChannelInfo.IsMultiKey, common.GetContextKeyString(c, constant.ContextKeyChannelKey), channel.ShouldAutoDisable()), newAPIError)
+		retryParam.FailedChannelId = channel.Id
 
 		if !shouldRetry(c, newAPIError, common.RetryTimes-retryParam.GetRetry()) {
 			break
@@ -305,6 +421,10 @@ func getChannel(c *gin.Context, info *relaycommon.RelayInfo, retryParam *service
 		return nil, types.NewError(fmt.Errorf("分组 %s 下模型 %s 的可用渠道不存在（retry）", selectGroup, info.OriginModelName), types.ErrorCodeGetChannelFailed, types.ErrOptionWithSkipRetry())
 	}
 
+	if err := service.ValidateChannelModelSupport(channel, info.OriginModelName); err != nil {
+		return nil, types.NewErrorWithStatusCode(err, types.ErrorCodeChannelModelNotSupported, http.StatusBadRequest)
+	}
+
 	newAPIError := middleware.SetupContextForSelectedChannel(c, channel, info.OriginModelName)
 	if newAPIError != nil {
 		return nil, newAPIError
@@ -377,13 +497,15 @@ func processChannelError(c *gin.Context, channelError types.ChannelError, err *t
 	logger.LogError(c, fmt.Sprintf("channel error (channel #%d, status code: %d): %s", channelError.ChannelId, err.StatusCode, err.Error()))
 	// 不要使用context获取渠道信息，异步处理时可能会出现渠道信息不一致的情况
 	// do not use context to get channel info, there may be inconsistent channel info when processing asynchronously
-	if service.ShouldDisableChannel(channelError.ChannelType, err) && channelError.AutoBan {
+	if !attributeChannelFailure(c, channelError.ChannelId, err) {
+		logger.LogInfo(c, fmt.Sprintf("channel #%d failed with the same error as an earlier channel in this request, not counted against it", channelError.ChannelId))
+	} else if service.ShouldDisableChannel(channelError.ChannelType, err) && channelError.AutoBan {
 		gopool.Go(func() {
 			reason := err.Error()
 			if err.GetErrorType() == "insufficient_quota" {
 				reason = "insufficient_quota: " + reason
 			}
-			service.DisableChannel(channelError, reason)
+			service.DisableChannel(channelError, err, reason)
 		})
 	}
 
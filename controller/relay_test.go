@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func upstreamError(status int, errType, code, message string) *types.NewAPIError {
+	return types.WithOpenAIError(types.OpenAIError{Message: message, Type: errType, Code: code}, status)
+}
+
+func TestAttributeChannelFailureAcrossRetries(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setting.ChannelRetryAttributionEnabled = true
+	t.Cleanup(func() { setting.ChannelRetryAttributionEnabled = false })
+
+	cases := []struct {
+		name       string
+		first      *types.NewAPIError
+		second     *types.NewAPIError
+		wantSecond bool
+	}{
+		{
+			name:       "same request error is attributed to the first channel only",
+			first:      upstreamError(http.StatusBadRequest, "invalid_request_error", "context_length_exceeded", "too many tokens"),
+			second:     upstreamError(http.StatusBadRequest, "invalid_request_error", "context_length_exceeded", "too many tokens"),
+			wantSecond: false,
+		},
+		{
+			name:       "different upstream code counts against each channel",
+			first:      upstreamError(http.StatusBadRequest, "invalid_request_error", "context_length_exceeded", "too many tokens"),
+			second:     upstreamError(http.StatusBadRequest, "invalid_request_error", "model_not_found", "too many tokens"),
+			wantSecond: true,
+		},
+		{
+			name:       "different message counts against each channel",
+			first:      types.NewErrorWithStatusCode(errors.New("bad gateway from a"), types.ErrorCodeBadResponseStatusCode, http.StatusBadGateway),
+			second:     types.NewErrorWithStatusCode(errors.New("bad gateway from b"), types.ErrorCodeBadResponseStatusCode, http.StatusBadGateway),
+			wantSecond: true,
+		},
+		{
+			name:       "invalid key is always the channel's fault",
+			first:      upstreamError(http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "Incorrect API key provided"),
+			second:     upstreamError(http.StatusUnauthorized, "invalid_request_error", "invalid_api_key", "Incorrect API key provided"),
+			wantSecond: true,
+		},
+		{
+			name:       "insufficient quota is always the channel's fault",
+			first:      upstreamError(http.StatusTooManyRequests, "insufficient_quota", "insufficient_quota", "You exceeded your current quota"),
+			second:     upstreamError(http.StatusTooManyRequests, "insufficient_quota", "insufficient_quota", "You exceeded your current quota"),
+			wantSecond: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			if !attributeChannelFailure(c, 1, tc.first) {
+				t.Fatal("first failure not attributed to its channel")
+			}
+			if got := attributeChannelFailure(c, 2, tc.second); got != tc.wantSecond {
+				t.Fatalf("second channel attributed = %v, want %v", got, tc.wantSecond)
+			}
+			// 同一渠道再次出现相同错误仍计入该渠道
+			if !attributeChannelFailure(c, 1, tc.first) {
+				t.Fatal("repeat failure on the first channel not attributed")
+			}
+		})
+	}
+}
+
+func TestRecordChannelEmptyResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	old := setting.ChannelDisableOnEmptyAfterN
+	setting.ChannelDisableOnEmptyAfterN = 10
+	t.Cleanup(func() {
+		setting.ChannelDisableOnEmptyAfterN = old
+		service.ResetChannelEmptyCount(1574)
+	})
+	// 不开启自动禁用，只观察计数
+	channelError := *types.NewChannelError(1574, 0, "empty", false, "", false)
+	relay := func(empty bool, err *types.NewAPIError) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		common.SetContextKey(c, constant.ContextKeyEmptyResponse, empty)
+		recordChannelEmptyResponse(c, channelError, err)
+	}
+
+	relay(true, nil)
+	relay(false, types.NewError(errors.New("empty"), types.ErrorCodeEmptyResponse))
+	if got := service.GetChannelEmptyCount(1574); got != 2 {
+		t.Fatalf("empty count = %d, want 2", got)
+	}
+	// 其它错误既不计入也不清零
+	relay(false, upstreamError(http.StatusBadGateway, "upstream_error", "", "bad gateway"))
+	if got := service.GetChannelEmptyCount(1574); got != 2 {
+		t.Fatalf("empty count after an unrelated error = %d, want 2", got)
+	}
+	relay(false, nil)
+	if got := service.GetChannelEmptyCount(1574); got != 0 {
+		t.Fatalf("empty count after a non-empty response = %d, want 0", got)
+	}
+}
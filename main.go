@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"embed"
 	"fmt"
 	"log"
@@ -19,6 +20,7 @@ import (
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/router"
 	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 
 	"github.com/bytedance/gopkg/util/gopool"
@@ -60,7 +62,7 @@ func main() {
 		}
 	}()
 
-	if common.RedisEnabled {
+	if common.RedisEnabled() {
 		// for compatibility with old versions
 		common.MemoryCacheEnabled = true
 	}
@@ -89,6 +91,9 @@ func main() {
 	// 热更新配置
 	go model.SyncOptions(common.SyncFrequency)
 
+	// 按渠道数缩放的限流依赖已启用渠道数
+	go model.SyncActiveChannelCount(common.SyncFrequency)
+
 	// 数据看板
 	go model.UpdateQuotaData()
 
@@ -102,6 +107,23 @@ func main() {
 
 	go controller.AutomaticallyTestChannels()
 
+	if common.IsMasterNode {
+		go service.AutomaticallyResumePausedChannels()
+	}
+
+	if common.RedisEnabled() && common.IsMasterNode {
+		go middleware.StartRateLimitKeySweeper()
+	}
+
+	// 内存限流时每个节点都需要恢复自己的计数，Redis 限流时由主节点恢复
+	if setting.RateLimitWarmupEnabled && (!common.RedisEnabled() || common.IsMasterNode) {
+		warmed, err := middleware.WarmDailyRateLimits(context.Background())
+		if err != nil {
+			common.SysError("failed to warm daily rate limits: " + err.Error())
+		}
+		common.SysLog(fmt.Sprintf("warmed %d daily rate limit keys from usage logs", warmed))
+	}
+
 	if common.IsMasterNode && constant.UpdateTask {
 		gopool.Go(func() {
 			controller.UpdateMidjourneyTaskBulk()
@@ -178,58 +178,78 @@ func WssAuth(c *gin.Context) {
 
 }
 
-func TokenAuth() func(c *gin.Context) {
-	return func(c *gin.Context) {
-		// 先检测是否为ws
-		if c.Request.Header.Get("Sec-WebSocket-Protocol") != "" {
-			// Sec-WebSocket-Protocol: realtime, openai-insecure-api-key.sk-xxx, openai-beta.realtime-v1
-			// read sk from Sec-WebSocket-Protocol
-			key := c.Request.Header.Get("Sec-WebSocket-Protocol")
-			parts := strings.Split(key, ",")
-			for _, part := range parts {
-				part = strings.TrimSpace(part)
-				if strings.HasPrefix(part, "openai-insecure-api-key") {
-					key = strings.TrimPrefix(part, "openai-insecure-api-key.")
-					break
-				}
+// Authorization 之外可以携带令牌的请求头，按顺序取第一个非空的值
+var tokenKeyHeaders = []string{"api-key", "x-api-key"}
+
+// getTokenKeyFromRequest 从请求中解析令牌 key，兼容 ws / Claude / Gemini / Midjourney / Azure 等多种传递方式，
+// 返回去掉 sk- 前缀后的 key 以及按 "-" 分割的各部分（第二部分为指定渠道 ID）
+func getTokenKeyFromRequest(c *gin.Context) (string, []string) {
+	// 先检测是否为ws
+	if c.Request.Header.Get("Sec-WebSocket-Protocol") != "" {
+		// Sec-WebSocket-Protocol: realtime, openai-insecure-api-key.sk-xxx, openai-beta.realtime-v1
+		// read sk from Sec-WebSocket-Protocol
+		key := c.Request.Header.Get("Sec-WebSocket-Protocol")
+		parts := strings.Split(key, ",")
+		for _, part := range parts {
+			part = strings.TrimSpace(part)
+			if strings.HasPrefix(part, "openai-insecure-api-key") {
+				key = strings.TrimPrefix(part, "openai-insecure-api-key.")
+				break
 			}
-			c.Request.Header.Set("Authorization", "Bearer "+key)
 		}
-		// 检查path包含/v1/messages
-		if strings.Contains(c.Request.URL.Path, "/v1/messages") {
-			anthropicKey := c.Request.Header.Get("x-api-key")
-			if anthropicKey != "" {
-				c.Request.Header.Set("Authorization", "Bearer "+anthropicKey)
-			}
+		c.Request.Header.Set("Authorization", "Bearer "+key)
+	}
+	// 检查path包含/v1/messages
+	if strings.Contains(c.Request.URL.Path, "/v1/messages") {
+		anthropicKey := c.Request.Header.Get("x-api-key")
+		if anthropicKey != "" {
+			c.Request.Header.Set("Authorization", "Bearer "+anthropicKey)
 		}
-		// gemini api 从query中获取key
-		if strings.HasPrefix(c.Request.URL.Path, "/v1beta/models") ||
-			strings.HasPrefix(c.Request.URL.Path, "/v1beta/openai/models") ||
-			strings.HasPrefix(c.Request.URL.Path, "/v1/models/") {
-			skKey := c.Query("key")
-			if skKey != "" {
-				c.Request.Header.Set("Authorization", "Bearer "+skKey)
-			}
-			// 从x-goog-api-key header中获取key
-			xGoogKey := c.Request.Header.Get("x-goog-api-key")
-			if xGoogKey != "" {
-				c.Request.Header.Set("Authorization", "Bearer "+xGoogKey)
+	}
+	// gemini api 从query中获取key
+	if strings.HasPrefix(c.Request.URL.Path, "/v1beta/models") ||
+		strings.HasPrefix(c.Request.URL.Path, "/v1beta/openai/models") ||
+		strings.HasPrefix(c.Request.URL.Path, "/v1/models/") {
+		skKey := c.Query("key")
+		if skKey != "" {
+			c.Request.Header.Set("Authorization", "Bearer "+skKey)
+		}
+		// 从x-goog-api-key header中获取key
+		xGoogKey := c.Request.Header.Get("x-goog-api-key")
+		if xGoogKey != "" {
+			c.Request.Header.Set("Authorization", "Bearer "+xGoogKey)
+		}
+	}
+	// 未携带 Authorization 时兼容 Azure 风格的 api-key 与 Anthropic 风格的 x-api-key 请求头，
+	// 同一令牌无论通过哪种方式传入都解析为同一令牌，按令牌的限流共享同一计数
+	if c.Request.Header.Get("Authorization") == "" {
+		for _, header := range tokenKeyHeaders {
+			if headerKey := strings.TrimSpace(c.Request.Header.Get(header)); headerKey != "" {
+				c.Request.Header.Set("Authorization", "Bearer "+headerKey)
+				break
 			}
 		}
-		key := c.Request.Header.Get("Authorization")
-		parts := make([]string, 0)
+	}
+	key := c.Request.Header.Get("Authorization")
+	parts := make([]string, 0)
+	key = strings.TrimPrefix(key, "Bearer ")
+	if key == "" || key == "midjourney-proxy" {
+		key = c.Request.Header.Get("mj-api-secret")
 		key = strings.TrimPrefix(key, "Bearer ")
-		if key == "" || key == "midjourney-proxy" {
-			key = c.Request.Header.Get("mj-api-secret")
-			key = strings.TrimPrefix(key, "Bearer ")
-			key = strings.TrimPrefix(key, "sk-")
-			parts = strings.Split(key, "-")
-			key = parts[0]
-		} else {
-			key = strings.TrimPrefix(key, "sk-")
-			parts = strings.Split(key, "-")
-			key = parts[0]
-		}
+		key = strings.TrimPrefix(key, "sk-")
+		parts = strings.Split(key, "-")
+		key = parts[0]
+	} else {
+		key = strings.TrimPrefix(key, "sk-")
+		parts = strings.Split(key, "-")
+		key = parts[0]
+	}
+	return key, parts
+}
+
+func TokenAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		key, parts := getTokenKeyFromRequest(c)
 		token, err := model.ValidateUserToken(key)
 		if token != nil {
 			id := c.GetInt("id")
@@ -318,6 +338,16 @@ func SetupContextForToken(c *gin.Context, token *model.Token, parts ...string) e
 	}
 	common.SetContextKey(c, constant.ContextKeyTokenGroup, token.Group)
 	common.SetContextKey(c, constant.ContextKeyTokenCrossGroupRetry, token.CrossGroupRetry)
+	if token.OrgId > 0 {
+		common.SetContextKey(c, constant.ContextKeyTokenOrgId, token.OrgId)
+	}
+	if token.RateLimitAlgorithm != "" {
+		common.SetContextKey(c, constant.ContextKeyTokenRateLimitAlgorithm, token.RateLimitAlgorithm)
+	}
+	common.SetContextKey(c, constant.ContextKeyTokenBillingAnchorDay, token.GetBillingAnchorDay())
+	if token.CustomerIds != "" {
+		common.SetContextKey(c, constant.ContextKeyTokenCustomerIds, token.CustomerIds)
+	}
 	if len(parts) > 1 {
 		if model.IsAdmin(token.UserId) {
 			c.Set("specific_channel_id", parts[1])
@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetTokenKeyFromRequestHeaders(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		want    string
+	}{
+		{"bearer", "/v1/chat/completions", map[string]string{"Authorization": "Bearer sk-abc123"}, "abc123"},
+		{"azure api-key", "/v1/chat/completions", map[string]string{"api-key": "sk-abc123"}, "abc123"},
+		{"anthropic x-api-key", "/v1/chat/completions", map[string]string{"x-api-key": "sk-abc123"}, "abc123"},
+		{"x-api-key on messages", "/v1/messages", map[string]string{"x-api-key": "sk-abc123"}, "abc123"},
+		{"api-key without prefix", "/v1/chat/completions", map[string]string{"api-key": " abc123 "}, "abc123"},
+		{"authorization wins", "/v1/chat/completions", map[string]string{"Authorization": "Bearer sk-abc123", "api-key": "sk-other"}, "abc123"},
+		{"api-key before x-api-key", "/v1/chat/completions", map[string]string{"api-key": "sk-abc123", "x-api-key": "sk-other"}, "abc123"},
+		{"none", "/v1/chat/completions", nil, ""},
+	}
+	for _, tc := range cases {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, tc.path, nil)
+		for k, v := range tc.headers {
+			c.Request.Header.Set(k, v)
+		}
+		if key, _ := getTokenKeyFromRequest(c); key != tc.want {
+			t.Errorf("%s: key = %q, want %q", tc.name, key, tc.want)
+		}
+	}
+}
+
+func TestTokenHeaderSchemesShareRateLimit(t *testing.T) {
+	setupMemoryRateLimit(t, 3)
+	token := &model.Token{Id: 1891, UserId: 1891, Key: "key1891", UnlimitedQuota: true}
+
+	r := gin.New()
+	// 按请求头解析令牌后写入上下文，与 TokenAuth 一致，只是省去数据库查询
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		key, parts := getTokenKeyFromRequest(c)
+		if key != token.Key {
+			abortWithOpenAiMessage(c, http.StatusUnauthorized, "无效的令牌")
+			return
+		}
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		if err := SetupContextForToken(c, token, parts...); err != nil {
+			return
+		}
+		c.Next()
+	}, ModelRequestRateLimit(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	serve := func(header string, value string) int {
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(header, value)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 三种方式传入同一令牌，共享令牌的总请求数限制
+	if code := serve("Authorization", "Bearer sk-key1891"); code != http.StatusOK {
+		t.Fatalf("bearer request: status %d", code)
+	}
+	if code := serve("api-key", "sk-key1891"); code != http.StatusOK {
+		t.Fatalf("api-key request: status %d", code)
+	}
+	if code := serve("x-api-key", "sk-key1891"); code != http.StatusOK {
+		t.Fatalf("x-api-key request: status %d", code)
+	}
+	if got := tokenTotalCount(1891); got != 3 {
+		t.Fatalf("token total count = %d, want 3 shared across header schemes", got)
+	}
+	for _, header := range []string{"Authorization", "api-key", "x-api-key"} {
+		value := "sk-key1891"
+		if header == "Authorization" {
+			value = "Bearer " + value
+		}
+		if code := serve(header, value); code != http.StatusTooManyRequests {
+			t.Fatalf("%s request over the shared limit: status %d, want %d", header, code, http.StatusTooManyRequests)
+		}
+	}
+}
@@ -11,5 +11,6 @@ func CORS() gin.HandlerFunc {
 	config.AllowCredentials = true
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
 	config.AllowHeaders = []string{"*"}
+	config.ExposeHeaders = []string{"Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "X-NewAPI-Backpressure", "X-NewAPI-Max-Tokens-Capped", "X-Request-Id", "X-RateLimit-Rule"}
 	return cors.New(config)
 }
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// 同一令牌下按终端客户细分的限流：客户端通过 X-Customer-Id 传入终端客户 ID，每个客户单独计数
+const (
+	CustomerIdHeader = "X-Customer-Id"
+
+	TokenCustomerRateLimitCountMark = "TCRL"
+	// 令牌在时间窗口内出现过的客户 ID 集合（有序集合，分数为最近一次请求的时间戳）
+	TokenCustomerSetMark = "TCRLC"
+)
+
+var customerIdPattern = regexp.MustCompile(`^[A-Za-z0-9._:@-]{1,64}$`)
+
+var (
+	tokenCustomersMutex sync.Mutex
+	tokenCustomers      = map[int]map[string]time.Time{} // 令牌 ID -> 客户 ID -> 最近一次请求时间
+)
+
+// checkCustomerRateLimit 检查同一令牌下单个终端客户的总请求数。未配置 PerCustomerRateLimit 或未携带
+// X-Customer-Id 时跳过；客户 ID 不在令牌的允许列表中、或令牌在窗口内的不同客户数已达上限时拒绝请求
+func checkCustomerRateLimit(c *gin.Context) bool {
+	maxCount := setting.PerCustomerRateLimit
+	if maxCount <= 0 {
+		return true
+	}
+	customerId := c.GetHeader(CustomerIdHeader)
+	if customerId == "" {
+		return true
+	}
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	if tokenId == 0 {
+		return true
+	}
+	if !customerIdPattern.MatchString(customerId) {
+		abortWithOpenAiMessage(c, http.StatusBadRequest, "invalid X-Customer-Id")
+		return false
+	}
+	if allowed := model.ParseTokenCustomerIds(common.GetContextKeyString(c, constant.ContextKeyTokenCustomerIds)); len(allowed) > 0 {
+		if _, ok := allowed[customerId]; !ok {
+			abortWithOpenAiMessage(c, http.StatusForbidden, "X-Customer-Id is not allowed for this token")
+			return false
+		}
+	}
+
+	rateLimitKey := strconv.Itoa(tokenId)
+	duration := int64(setting.TokenRateLimitDurationMinutes * 60)
+	message := fmt.Sprintf("当前客户已达到该密钥的请求数限制：%d分钟内最多请求%d次（包括失败请求）", setting.TokenRateLimitDurationMinutes, maxCount)
+
+	if !common.RedisEnabled() {
+		if !admitMemoryCustomer(tokenId, customerId, duration) {
+			abortWithRateLimitMessage(c, rateLimitRejectTotal, duration, fmt.Sprintf("该密钥在%d分钟内的客户数已达上限%d", setting.TokenRateLimitDurationMinutes, setting.PerCustomerMaxCustomers))
+			return false
+		}
+		inMemoryRateLimiter.Init(time.Duration(setting.TokenRateLimitDurationMinutes) * time.Minute)
+		if !memoryReserve(c, TokenCustomerRateLimitCountMark+rateLimitKey+":"+customerId, maxCount, duration) {
+			abortWithRateLimitMessage(c, rateLimitRejectTotal, duration, message)
+			return false
+		}
+		return true
+	}
+
+	ctx := context.Background()
+	admitted, err := admitRedisCustomer(ctx, rateLimitKey, customerId, duration)
+	if err != nil {
+		fmt.Println("检查密钥客户数限制失败:", err.Error())
+		if !rateLimitFailOpen(err) {
+			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+			return false
+		}
+		admitted = true
+	}
+	if !admitted {
+		abortWithRateLimitMessage(c, rateLimitRejectTotal, duration, fmt.Sprintf("该密钥在%d分钟内的客户数已达上限%d", setting.TokenRateLimitDurationMinutes, setting.PerCustomerMaxCustomers))
+		return false
+	}
+
+	key := fmt.Sprintf("rateLimit:%s:%s:%s", TokenCustomerRateLimitCountMark, rateLimitKey, customerId)
+	tb := limiter.New(ctx, common.RDB())
+	spanCtx, span := startRateLimitSpan(c, "token_customer_total", key)
+	allowed, wait, err := reserveWithBlocking(spanCtx, c, tb,
+		key,
+		limiter.WithCapacity(int64(maxCount)*duration),
+		limiter.WithRate(int64(maxCount)),
+		limiter.WithRequested(duration),
+	)
+	endRateLimitSpan(span, "token_customer_total", maxCount, allowed, err)
+	if err != nil {
+		fmt.Println("检查密钥客户请求数限制失败:", err.Error())
+		if !rateLimitFailOpen(err) {
+			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+			return false
+		}
+		allowed = true
+	}
+	if !allowed {
+		abortWithRateLimitMessage(c, rateLimitRejectTotal, retryAfterFromWait(wait, duration), message)
+		return false
+	}
+	return true
+}
+
+// admitRedisCustomer 记录令牌在窗口内出现的客户 ID，新客户会使不同客户数超过 PerCustomerMaxCustomers 时返回 false。
+// 并发请求同时加入新客户时可能多拒绝，但不会放行超过上限的客户
+func admitRedisCustomer(ctx context.Context, rateLimitKey string, customerId string, duration int64) (bool, error) {
+	rdb := common.RDB()
+	key := fmt.Sprintf("rateLimit:%s:%s", TokenCustomerSetMark, rateLimitKey)
+	now := time.Now()
+	pipe := rdb.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Unix()-duration, 10))
+	added := pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.Unix()), Member: customerId})
+	card := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, time.Duration(duration)*time.Second)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	maxCustomers := setting.PerCustomerMaxCustomers
+	if added.Val() == 0 || maxCustomers <= 0 || card.Val() <= int64(maxCustomers) {
+		return true, nil
+	}
+	if err := rdb.ZRem(ctx, key, customerId).Err(); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// admitMemoryCustomer 内存版本的 admitRedisCustomer
+func admitMemoryCustomer(tokenId int, customerId string, duration int64) bool {
+	now := time.Now()
+	expireBefore := now.Add(-time.Duration(duration) * time.Second)
+	tokenCustomersMutex.Lock()
+	defer tokenCustomersMutex.Unlock()
+	customers, ok := tokenCustomers[tokenId]
+	if !ok {
+		customers = map[string]time.Time{}
+		tokenCustomers[tokenId] = customers
+	}
+	if _, ok := customers[customerId]; !ok {
+		for id, lastSeen := range customers {
+			if lastSeen.Before(expireBefore) {
+				delete(customers, id)
+			}
+		}
+		if maxCustomers := setting.PerCustomerMaxCustomers; maxCustomers > 0 && len(customers) >= maxCustomers {
+			return false
+		}
+	}
+	customers[customerId] = now
+	return true
+}
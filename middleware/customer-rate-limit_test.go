@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupCustomerRateLimit(t *testing.T, perCustomer, maxCustomers int) {
+	t.Helper()
+	setupMemoryRateLimit(t, 0)
+	oldMaxCustomers := setting.PerCustomerMaxCustomers
+	setting.PerCustomerRateLimit = perCustomer
+	setting.PerCustomerMaxCustomers = maxCustomers
+	t.Cleanup(func() {
+		setting.PerCustomerRateLimit = 0
+		setting.PerCustomerMaxCustomers = oldMaxCustomers
+	})
+}
+
+func serveCustomerRequest(tokenId int, customerId string) int {
+	headers := map[string]string{}
+	if customerId != "" {
+		headers[CustomerIdHeader] = customerId
+	}
+	return serveModelRequest(tokenId, `{"model":"gpt-4o"}`, http.StatusOK, headers).Code
+}
+
+func TestPerCustomerRateLimitIsolation(t *testing.T) {
+	setupCustomerRateLimit(t, 2, 0)
+
+	for i := 0; i < 2; i++ {
+		if code := serveCustomerRequest(1671, "acme"); code != http.StatusOK {
+			t.Fatalf("acme request %d: status %d", i+1, code)
+		}
+	}
+	if code := serveCustomerRequest(1671, "acme"); code != http.StatusTooManyRequests {
+		t.Fatalf("3rd acme request: status %d, want 429", code)
+	}
+	// 同一令牌下的其他客户单独计数
+	if code := serveCustomerRequest(1671, "globex"); code != http.StatusOK {
+		t.Fatalf("globex request: status %d, want its own limit", code)
+	}
+	// 同一客户 ID 在其他令牌下也单独计数
+	if code := serveCustomerRequest(1672, "acme"); code != http.StatusOK {
+		t.Fatalf("acme on another token: status %d", code)
+	}
+	// 未携带请求头时不按客户限流
+	for i := 0; i < 3; i++ {
+		if code := serveCustomerRequest(1671, ""); code != http.StatusOK {
+			t.Fatalf("request without %s: status %d", CustomerIdHeader, code)
+		}
+	}
+}
+
+func TestPerCustomerCardinalityCap(t *testing.T) {
+	setupCustomerRateLimit(t, 10, 2)
+
+	for _, customer := range []string{"c1", "c2", "c1"} {
+		if code := serveCustomerRequest(1673, customer); code != http.StatusOK {
+			t.Fatalf("customer %s: status %d", customer, code)
+		}
+	}
+	if code := serveCustomerRequest(1673, "c3"); code != http.StatusTooManyRequests {
+		t.Fatalf("third distinct customer: status %d, want 429", code)
+	}
+	// 已出现过的客户不受影响，上限按令牌计算
+	if code := serveCustomerRequest(1673, "c2"); code != http.StatusOK {
+		t.Fatalf("known customer after the cap: status %d", code)
+	}
+	if code := serveCustomerRequest(1674, "c3"); code != http.StatusOK {
+		t.Fatalf("new customer on another token: status %d", code)
+	}
+}
+
+func TestPerCustomerRateLimitRejectsInvalidId(t *testing.T) {
+	setupCustomerRateLimit(t, 10, 0)
+	for _, customer := range []string{"has space", "semi;colon", strings.Repeat("a", 65)} {
+		if code := serveCustomerRequest(1675, customer); code != http.StatusBadRequest {
+			t.Errorf("customer id %q: status %d, want 400", customer, code)
+		}
+	}
+}
+
+func TestPerCustomerAllowlist(t *testing.T) {
+	setupCustomerRateLimit(t, 10, 0)
+	serve := func(customerId string) int {
+		r := gin.New()
+		r.POST("/v1/chat/completions", func(c *gin.Context) {
+			common.SetContextKey(c, constant.ContextKeyTokenId, 1676)
+			common.SetContextKey(c, constant.ContextKeyTokenGroup, "default")
+			common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+			common.SetContextKey(c, constant.ContextKeyTokenCustomerIds, "acme, globex\ninitech")
+			c.Next()
+		}, ModelRequestRateLimit(), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(CustomerIdHeader, customerId)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for _, customer := range []string{"acme", "globex", "initech"} {
+		if code := serve(customer); code != http.StatusOK {
+			t.Errorf("allowed customer %s: status %d", customer, code)
+		}
+	}
+	if code := serve("umbrella"); code != http.StatusForbidden {
+		t.Fatalf("customer outside the allowlist: status %d, want 403", code)
+	}
+}
@@ -3,10 +3,10 @@ package middleware
 import (
 	"context"
 	"fmt"
-	"net/http"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,7 +19,7 @@ const (
 
 func redisEmailVerificationRateLimiter(c *gin.Context) {
 	ctx := context.Background()
-	rdb := common.RDB
+	rdb := common.RDB()
 	key := "emailVerification:" + EmailVerificationRateLimitMark + ":" + c.ClientIP()
 
 	count, err := rdb.Incr(ctx, key).Result()
@@ -47,7 +47,8 @@ func redisEmailVerificationRateLimiter(c *gin.Context) {
 		waitSeconds = int64(ttl.Seconds())
 	}
 
-	c.JSON(http.StatusTooManyRequests, gin.H{
+	setRetryAfter(c, waitSeconds)
+	c.JSON(setting.RateLimitRejectStatusCode, gin.H{
 		"success": false,
 		"message": fmt.Sprintf("发送过于频繁，请等待 %d 秒后再试", waitSeconds),
 	})
@@ -58,7 +59,8 @@ func memoryEmailVerificationRateLimiter(c *gin.Context) {
 	key := EmailVerificationRateLimitMark + ":" + c.ClientIP()
 
 	if !inMemoryRateLimiter.Request(key, EmailVerificationMaxRequests, EmailVerificationDuration) {
-		c.JSON(http.StatusTooManyRequests, gin.H{
+		setRetryAfter(c, EmailVerificationDuration)
+		c.JSON(setting.RateLimitRejectStatusCode, gin.H{
 			"success": false,
 			"message": "发送过于频繁，请稍后再试",
 		})
@@ -71,7 +73,7 @@ func memoryEmailVerificationRateLimiter(c *gin.Context) {
 
 func EmailVerificationRateLimit() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if common.RedisEnabled {
+		if common.RedisEnabled() {
 			redisEmailVerificationRateLimiter(c)
 		} else {
 			inMemoryRateLimiter.Init(common.RateLimitKeyExpirationDuration)
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EmergencyRelayGate 开启 EmergencyDisableAllRelays 时拒绝非管理员的全部模型请求，需放在鉴权之后、限流之前
+func EmergencyRelayGate() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if !setting.EmergencyDisableAllRelays || common.GetContextKeyInt(c, constant.ContextKeyUserRole) >= common.RoleAdminUser {
+			c.Next()
+			return
+		}
+		abortWithOpenAiMessage(c, http.StatusServiceUnavailable, "[emergency] "+setting.EmergencyDisableMessage, "relay_emergency_disabled")
+	}
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveEmergencyRequest 以 role 身份发送一次经过紧急开关与模型限流的请求
+func serveEmergencyRequest(tokenId int, role int) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyUserRole, role)
+		common.SetContextKey(c, constant.ContextKeyTokenId, tokenId)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "default")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		c.Next()
+	}, EmergencyRelayGate(), ModelRequestRateLimit(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestEmergencyDisableAllRelays(t *testing.T) {
+	setupMemoryRateLimit(t, 5)
+	oldMessage := setting.EmergencyDisableMessage
+	setting.EmergencyDisableAllRelays = true
+	setting.EmergencyDisableMessage = "incident in progress"
+	oldExempt := setting.ExemptAdminFromRateLimit
+	setting.ExemptAdminFromRateLimit = false
+	t.Cleanup(func() {
+		setting.EmergencyDisableAllRelays = false
+		setting.EmergencyDisableMessage = oldMessage
+		setting.ExemptAdminFromRateLimit = oldExempt
+	})
+
+	for i := 0; i < 3; i++ {
+		w := serveEmergencyRequest(1681, common.RoleCommonUser)
+		if w.Code != http.StatusServiceUnavailable {
+			t.Fatalf("user request %d: status %d, want 503", i+1, w.Code)
+		}
+		if body := w.Body.String(); !strings.Contains(body, "incident in progress") || !strings.Contains(body, "relay_emergency_disabled") {
+			t.Fatalf("body = %s, want the configured message and code", body)
+		}
+	}
+	// 在任何限流之前拒绝，不消耗限流名额
+	if got := tokenTotalCount(1681); got != 0 {
+		t.Fatalf("blocked requests counted %d times, want 0", got)
+	}
+
+	for _, role := range []int{common.RoleAdminUser, common.RoleRootUser} {
+		if w := serveEmergencyRequest(1682, role); w.Code != http.StatusOK {
+			t.Fatalf("role %d: status %d, want admins let through", role, w.Code)
+		}
+	}
+
+	setting.EmergencyDisableAllRelays = false
+	if w := serveEmergencyRequest(1681, common.RoleCommonUser); w.Code != http.StatusOK {
+		t.Fatalf("user request after the switch is off: status %d", w.Code)
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// findForbiddenParam 返回请求体中第一个被使用的禁止参数，值为 null 或 false 时视为未使用
+func findForbiddenParam(body []byte, params []string) string {
+	for _, param := range params {
+		value := gjson.GetBytes(body, param)
+		if !value.Exists() || value.Type == gjson.Null || value.Type == gjson.False {
+			continue
+		}
+		return param
+	}
+	return ""
+}
+
+// GroupForbiddenParams 按 GroupForbiddenParams 拒绝使用了分组禁止参数的请求，需在 Distribute 之后使用，
+// 分组取本次请求实际使用的分组（auto 分组为选中的分组）
+func GroupForbiddenParams() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+			c.Next()
+			return
+		}
+		group := common.GetContextKeyString(c, constant.ContextKeyUsingGroup)
+		if group == "" {
+			group = common.GetContextKeyString(c, constant.ContextKeyUserGroup)
+		}
+		params := setting.GetGroupForbiddenParams(group)
+		if len(params) == 0 {
+			c.Next()
+			return
+		}
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			c.Next()
+			return
+		}
+		if param := findForbiddenParam(body, params); param != "" {
+			abortWithOpenAiMessage(c, http.StatusBadRequest, fmt.Sprintf("当前分组 %s 不允许使用参数 %s", group, param), "forbidden_parameter")
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFindForbiddenParam(t *testing.T) {
+	params := []string{"logprobs", "n", "stream_options.include_usage"}
+	cases := map[string]string{
+		`{"model":"gpt-4o"}`:                                  "",
+		`{"logprobs":true}`:                                   "logprobs",
+		`{"logprobs":false}`:                                  "",
+		`{"logprobs":null,"n":4}`:                             "n",
+		`{"stream_options":{"include_usage":true}}`:           "stream_options.include_usage",
+		`{"stream_options":{}}`:                               "",
+		`{"messages":[{"role":"user","content":"logprobs"}]}`: "",
+	}
+	for body, want := range cases {
+		if got := findForbiddenParam([]byte(body), params); got != want {
+			t.Errorf("findForbiddenParam(%s) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+// serveForbiddenParamsRequest 以分组 group 发送一次经过 GroupForbiddenParams 的请求
+func serveForbiddenParamsRequest(group string, body string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyUsingGroup, group)
+		c.Next()
+	}, GroupForbiddenParams(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestGroupForbiddenParamsPerGroup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	oldBodyMB, oldParams := constant.MaxRequestBodyMB, setting.GroupForbiddenParams2JSONString()
+	constant.MaxRequestBodyMB = 8
+	if err := setting.UpdateGroupForbiddenParamsByJSONString(`{"default":["logprobs","n"]}`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		constant.MaxRequestBodyMB = oldBodyMB
+		_ = setting.UpdateGroupForbiddenParamsByJSONString(oldParams)
+	})
+
+	w := serveForbiddenParamsRequest("default", `{"model":"gpt-4o","logprobs":true}`)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("forbidden param: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if code := rejectCode(t, w); code != "forbidden_parameter" {
+		t.Fatalf("error code = %q, want forbidden_parameter", code)
+	}
+	if !strings.Contains(w.Body.String(), "logprobs") {
+		t.Fatalf("error should name the parameter: %s", w.Body.String())
+	}
+	if w := serveForbiddenParamsRequest("default", `{"model":"gpt-4o","n":4}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("forbidden n: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	// 未使用禁止参数或参数值为 false 时放行
+	for _, body := range []string{`{"model":"gpt-4o"}`, `{"model":"gpt-4o","logprobs":false}`} {
+		if w := serveForbiddenParamsRequest("default", body); w.Code != http.StatusOK {
+			t.Fatalf("allowed request %s: status %d", body, w.Code)
+		}
+	}
+	// 其它分组不受限制
+	if w := serveForbiddenParamsRequest("vip", `{"model":"gpt-4o","logprobs":true,"n":4}`); w.Code != http.StatusOK {
+		t.Fatalf("unrestricted group: status %d", w.Code)
+	}
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func modelDailyCapMessage(modelName string, maxCount int) string {
+	return fmt.Sprintf("您已达到模型 %s 的每日请求数上限：每天最多请求%d次（包括失败请求）", modelName, maxCount)
+}
+
+// checkModelDailyCap 检查令牌对单个模型的每日请求数上限（ModelDailyCap），与令牌的每日限流是否开启无关。
+// 计数与令牌每日限流使用相同的周期：配置了 TokenQuotaSchedule 时按固定周期，否则按滚动的 24 小时窗口
+func checkModelDailyCap(c *gin.Context) bool {
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	if tokenId == 0 || isTotalCountForgiven(c) {
+		return true
+	}
+	modelName := rateLimitModelName(c)
+	if modelName == "" {
+		return true
+	}
+	maxCount := setting.GetModelDailyCap(modelName)
+	if maxCount <= 0 {
+		return true
+	}
+
+	rateLimitKey := strconv.Itoa(tokenId) + ":" + modelName
+	duration := int64(86400)
+
+	if window, ok := setting.GetTokenQuotaWindow(time.Now()); ok {
+		allowed, err := reservePeriodCount(context.Background(), fmt.Sprintf("rateLimit:%s:%s", TokenDailyRateLimitCountMark, rateLimitKey), maxCount, window)
+		if err != nil {
+			common.SysLog("检查模型每日请求数上限失败: " + err.Error())
+			if !rateLimitFailOpen(err) {
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return false
+			}
+			allowed = true
+		}
+		if !allowed {
+			abortWithRateLimitMessage(c, rateLimitRejectTotal, int64(time.Until(window.Reset).Seconds())+1, modelDailyCapMessage(modelName, maxCount))
+			return false
+		}
+		return true
+	}
+
+	if !common.RedisEnabled() {
+		inMemoryRateLimiter.Init(24 * time.Hour)
+		if !memoryReserve(c, TokenDailyRateLimitCountMark+rateLimitKey, maxCount, duration) {
+			abortWithRateLimitMessage(c, rateLimitRejectTotal, duration, modelDailyCapMessage(modelName, maxCount))
+			return false
+		}
+		return true
+	}
+
+	ctx := context.Background()
+	key := fmt.Sprintf("rateLimit:%s:%s", TokenDailyRateLimitCountMark, rateLimitKey)
+	tb := limiter.New(ctx, common.RDB())
+	spanCtx, span := startRateLimitSpan(c, "model_daily_cap", key)
+	allowed, wait, err := reserveWithBlocking(spanCtx, c, tb,
+		key,
+		limiter.WithCapacity(int64(maxCount)*duration),
+		limiter.WithRate(int64(maxCount)),
+		limiter.WithRequested(duration),
+	)
+	endRateLimitSpan(span, "model_daily_cap", maxCount, allowed, err)
+	if err != nil {
+		fmt.Println("检查模型每日请求数上限失败:", err.Error())
+		if !rateLimitFailOpen(err) {
+			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+			return false
+		}
+		allowed = true
+	}
+	if !allowed {
+		abortWithRateLimitMessage(c, rateLimitRejectTotal, retryAfterFromWait(wait, duration), modelDailyCapMessage(modelName, maxCount))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func setModelDailyCap(t *testing.T, caps string) {
+	t.Helper()
+	old := setting.ModelDailyCap2JSONString()
+	if err := setting.UpdateModelDailyCapByJSONString(caps); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = setting.UpdateModelDailyCapByJSONString(old) })
+}
+
+func TestModelDailyCapBlocksOnlyCappedModel(t *testing.T) {
+	setupMemoryRateLimit(t, 100)
+	setModelDailyCap(t, `{"o1-196":2}`)
+
+	for i := 0; i < 2; i++ {
+		if w := serveModelRequest(1961, `{"model":"o1-196"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("capped model request %d: status %d", i+1, w.Code)
+		}
+	}
+	w := serveModelRequest(1961, `{"model":"o1-196"}`, http.StatusOK, nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the model daily cap: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if code := rejectCode(t, w); code != "total_rate_limit_exceeded" {
+		t.Fatalf("reject code = %q, want total_rate_limit_exceeded", code)
+	}
+	if !strings.Contains(w.Body.String(), "o1-196") || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("rejection should name the model and carry Retry-After: %s", w.Body.String())
+	}
+
+	// 其它模型与其它令牌不受影响
+	for i := 0; i < 3; i++ {
+		if w := serveModelRequest(1961, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("uncapped model request %d: status %d", i+1, w.Code)
+		}
+	}
+	if w := serveModelRequest(1962, `{"model":"o1-196"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("other token: status %d", w.Code)
+	}
+}
+
+func TestModelDailyCapCheckedBeforeTokenDailyLimit(t *testing.T) {
+	setupMemoryRateLimit(t, 100)
+	setupTokenUsageLimits(t, 100, 0, 3, 0)
+	setModelDailyCap(t, `{"o1-1963":1}`)
+
+	if w := serveModelRequest(1963, `{"model":"o1-1963"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("first capped model request: status %d", w.Code)
+	}
+	// 被模型上限拒绝的请求不计入令牌的每日限流
+	if w := serveModelRequest(1963, `{"model":"o1-1963"}`, http.StatusOK, nil); w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "o1-1963") {
+		t.Fatalf("capped request: status %d, body %s", w.Code, w.Body.String())
+	}
+	for i := 0; i < 2; i++ {
+		if w := serveModelRequest(1963, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d within the token daily limit: status %d", i+1, w.Code)
+		}
+	}
+	if w := serveModelRequest(1963, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the token daily limit: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+}
+
+func TestModelDailyCapFixedPeriod(t *testing.T) {
+	setupMemoryRateLimit(t, 100)
+	setModelDailyCap(t, `{"o1-1964":1}`)
+	if err := setting.UpdateTokenQuotaSchedule("monthly@1"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = setting.UpdateTokenQuotaSchedule("") })
+
+	if w := serveModelRequest(1964, `{"model":"o1-1964"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	if w := serveModelRequest(1964, `{"model":"o1-1964"}`, http.StatusOK, nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request in the period: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	// 计数记录在 rateLimit:TDRL:<tokenId>:<model> 的本周期 key 中
+	window, _ := setting.GetTokenQuotaWindow(time.Now())
+	if count, _ := peekPeriodCount(context.Background(), "rateLimit:"+TokenDailyRateLimitCountMark+":1964:o1-1964", window); count != 1 {
+		t.Fatalf("period count = %d, want 1", count)
+	}
+}
@@ -0,0 +1,176 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// Model global rate limit constants
+const (
+	ModelGlobalRateLimitCountMark = "MGRL"
+	ModelFairShareCountMark       = "MFSRL"
+	// 模型在时间窗口内的活跃用户集合（有序集合，分数为最近一次请求的时间戳）
+	ModelFairShareUserSetMark = "MFSU"
+)
+
+// 限流阶段解析出的请求模型，避免多个限流维度重复解析请求体
+const rateLimitModelContextKey = "rate_limit_model"
+
+var (
+	modelActiveUsersMutex sync.Mutex
+	modelActiveUsers      = map[string]map[int]time.Time{} // 标准模型名 -> 用户 ID -> 最近一次请求时间
+)
+
+// rateLimitModelName 返回请求的标准模型名（别名按 ModelAliasGroups 归一），与 Distribute 使用相同的解析方式，无法解析时返回空字符串
+func rateLimitModelName(c *gin.Context) string {
+	if v, ok := c.Get(rateLimitModelContextKey); ok {
+		return v.(string)
+	}
+	var modelName string
+	if req, _, err := getModelRequest(c); err == nil && req != nil && req.Model != "" {
+		modelName = setting.CanonicalModelName(req.Model)
+	}
+	c.Set(rateLimitModelContextKey, modelName)
+	return modelName
+}
+
+// fairShare 将模型的全局限制在活跃用户之间平均分配，向上取整且至少为 1
+func fairShare(limit int, activeUsers int) int {
+	if activeUsers <= 1 {
+		return limit
+	}
+	return int(math.Ceil(float64(limit) / float64(activeUsers)))
+}
+
+// checkModelGlobalRateLimit 检查模型的全局总请求数限制。开启 ModelFairShareEnabled 时，
+// 每个用户先按窗口内活跃用户数分得的份额计数，再计入模型的全局限制
+func checkModelGlobalRateLimit(c *gin.Context) bool {
+	modelName := rateLimitModelName(c)
+	if modelName == "" {
+		return true
+	}
+	maxCount := setting.GetModelGlobalRateLimit(modelName)
+	if maxCount <= 0 {
+		return true
+	}
+	userId := common.GetContextKeyInt(c, constant.ContextKeyUserId)
+	duration := int64(setting.ModelRequestRateLimitDurationMinutes * 60)
+
+	if !common.RedisEnabled() {
+		inMemoryRateLimiter.Init(time.Duration(setting.ModelRequestRateLimitDurationMinutes) * time.Minute)
+		if setting.ModelFairShareEnabled && userId > 0 {
+			share := fairShare(maxCount, touchMemoryModelUser(modelName, userId, duration))
+			if !memoryReserve(c, ModelFairShareCountMark+modelName+":"+strconv.Itoa(userId), share, duration) {
+				abortWithRateLimitMessage(c, rateLimitRejectTotal, duration, fairShareMessage(modelName, share))
+				return false
+			}
+		}
+		if !memoryReserve(c, ModelGlobalRateLimitCountMark+modelName, maxCount, duration) {
+			abortWithRateLimitMessage(c, rateLimitRejectGlobal, duration, globalModelMessage(modelName, maxCount))
+			return false
+		}
+		return true
+	}
+
+	ctx := context.Background()
+	tb := limiter.New(ctx, common.RDB())
+	if setting.ModelFairShareEnabled && userId > 0 {
+		activeUsers, err := touchRedisModelUser(ctx, modelName, userId, duration)
+		if err != nil {
+			fmt.Println("记录模型活跃用户失败:", err.Error())
+			if !rateLimitFailOpen(err) {
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return false
+			}
+			activeUsers = 1
+		}
+		share := fairShare(maxCount, activeUsers)
+		key := fmt.Sprintf("rateLimit:%s:%s:%d", ModelFairShareCountMark, modelName, userId)
+		if !reserveModelLimit(c, tb, "model_fair_share", rateLimitRejectTotal, key, share, duration, fairShareMessage(modelName, share)) {
+			return false
+		}
+	}
+	key := fmt.Sprintf("rateLimit:%s:%s", ModelGlobalRateLimitCountMark, modelName)
+	return reserveModelLimit(c, tb, "model_global_total", rateLimitRejectGlobal, key, maxCount, duration, globalModelMessage(modelName, maxCount))
+}
+
+// reserveModelLimit 在令牌桶 key 中消耗一次请求，被拒绝或检查失败时以 reject 类型中断请求并返回 false
+func reserveModelLimit(c *gin.Context, tb *limiter.RedisLimiter, dimension string, reject string, key string, maxCount int, duration int64, message string) bool {
+	spanCtx, span := startRateLimitSpan(c, dimension, key)
+	allowed, wait, err := reserveWithBlocking(spanCtx, c, tb,
+		key,
+		limiter.WithCapacity(int64(maxCount)*duration),
+		limiter.WithRate(int64(maxCount)),
+		limiter.WithRequested(duration),
+	)
+	endRateLimitSpan(span, dimension, maxCount, allowed, err)
+	if err != nil {
+		fmt.Println("检查模型全局请求数限制失败:", err.Error())
+		if !rateLimitFailOpen(err) {
+			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+			return false
+		}
+		allowed = true
+	}
+	if !allowed {
+		abortWithRateLimitMessage(c, reject, retryAfterFromWait(wait, duration), message)
+		return false
+	}
+	return true
+}
+
+func fairShareMessage(modelName string, share int) string {
+	return fmt.Sprintf("模型 %s 当前请求较多，容量在活跃用户之间平均分配：您在%d分钟内最多请求%d次（包括失败请求）", modelName, setting.ModelRequestRateLimitDurationMinutes, share)
+}
+
+func globalModelMessage(modelName string, maxCount int) string {
+	return fmt.Sprintf("模型 %s 当前繁忙，已达到所有用户共享的全局请求数限制（并非您的额度已用完）：%d分钟内最多请求%d次，请稍后再试", modelName, setting.ModelRequestRateLimitDurationMinutes, maxCount)
+}
+
+// touchRedisModelUser 记录用户在窗口内请求过该模型，返回窗口内的活跃用户数（包括当前用户）
+func touchRedisModelUser(ctx context.Context, modelName string, userId int, duration int64) (int, error) {
+	key := fmt.Sprintf("rateLimit:%s:%s", ModelFairShareUserSetMark, modelName)
+	now := time.Now().Unix()
+	pipe := common.RDB().TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now-duration, 10))
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now), Member: userId})
+	card := pipe.ZCard(ctx, key)
+	pipe.Expire(ctx, key, time.Duration(duration)*time.Second)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return int(card.Val()), nil
+}
+
+// touchMemoryModelUser 内存版本的 touchRedisModelUser
+func touchMemoryModelUser(modelName string, userId int, duration int64) int {
+	now := time.Now()
+	expireBefore := now.Add(-time.Duration(duration) * time.Second)
+	modelActiveUsersMutex.Lock()
+	defer modelActiveUsersMutex.Unlock()
+	users, ok := modelActiveUsers[modelName]
+	if !ok {
+		users = map[int]time.Time{}
+		modelActiveUsers[modelName] = users
+	}
+	for id, lastSeen := range users {
+		if lastSeen.Before(expireBefore) {
+			delete(users, id)
+		}
+	}
+	users[userId] = now
+	return len(users)
+}
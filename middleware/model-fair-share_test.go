@@ -0,0 +1,132 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupModelGlobalRateLimit(t *testing.T, limits string, fairShare bool) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	common.SetRedisEnabled(false)
+	constant.MaxRequestBodyMB = 8
+	oldLimits, oldFairShare := setting.ModelGlobalRateLimit2JSONString(), setting.ModelFairShareEnabled
+	if err := setting.UpdateModelGlobalRateLimitByJSONString(limits); err != nil {
+		t.Fatal(err)
+	}
+	setting.ModelFairShareEnabled = fairShare
+	t.Cleanup(func() {
+		_ = setting.UpdateModelGlobalRateLimitByJSONString(oldLimits)
+		setting.ModelFairShareEnabled = oldFairShare
+	})
+}
+
+// serveUserModelNameRequest 以用户 userId 请求模型 modelName
+func serveUserModelNameRequest(userId int, modelName string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyUserId, userId)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "default")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		c.Next()
+	}, ModelRequestRateLimit(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(fmt.Sprintf(`{"model":%q}`, modelName)))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// allowedUntilRejected 连续发送请求直到被拒绝，返回放行的次数及拒绝时的响应
+func allowedUntilRejected(t *testing.T, userId int, modelName string) (int, *httptest.ResponseRecorder) {
+	t.Helper()
+	for allowed := 0; allowed < 100; allowed++ {
+		if w := serveUserModelNameRequest(userId, modelName); w.Code != http.StatusOK {
+			return allowed, w
+		}
+	}
+	t.Fatalf("user %d was never rejected", userId)
+	return 0, nil
+}
+
+func TestFairShare(t *testing.T) {
+	cases := []struct{ limit, users, want int }{
+		{12, 0, 12},
+		{12, 1, 12},
+		{12, 3, 4},
+		{10, 3, 4},
+		{2, 5, 1},
+	}
+	for _, tc := range cases {
+		if got := fairShare(tc.limit, tc.users); got != tc.want {
+			t.Errorf("fairShare(%d, %d) = %d, want %d", tc.limit, tc.users, got, tc.want)
+		}
+	}
+}
+
+func TestModelGlobalLimitWithoutFairShare(t *testing.T) {
+	setupModelGlobalRateLimit(t, `{"test-177-a":6}`, false)
+
+	// 先到的用户可以占满模型的全局限制，其他用户被拒绝
+	allowed, w := allowedUntilRejected(t, 1771, "test-177-a")
+	if allowed != 6 {
+		t.Fatalf("first user allowed %d requests, want the whole limit of 6", allowed)
+	}
+	if code := rejectCode(t, w); code != "global_rate_limit_exceeded" {
+		t.Fatalf("code = %q, want global_rate_limit_exceeded", code)
+	}
+	if w := serveUserModelNameRequest(1772, "test-177-a"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second user: status %d, want starved by the first", w.Code)
+	}
+}
+
+func TestModelFairShareUnderContention(t *testing.T) {
+	setupModelGlobalRateLimit(t, `{"test-177-b":12}`, true)
+	users := []int{1773, 1774, 1775}
+
+	// 三个用户都请求过模型后，每人最多使用全局限制的三分之一
+	for _, userId := range users {
+		if w := serveUserModelNameRequest(userId, "test-177-b"); w.Code != http.StatusOK {
+			t.Fatalf("user %d first request: status %d", userId, w.Code)
+		}
+	}
+	for _, userId := range users {
+		allowed, w := allowedUntilRejected(t, userId, "test-177-b")
+		if allowed+1 != 4 {
+			t.Fatalf("user %d got %d requests, want an equal share of 4", userId, allowed+1)
+		}
+		if code := rejectCode(t, w); code != "total_rate_limit_exceeded" {
+			t.Fatalf("user %d code = %q, want the per-user share rejection", userId, code)
+		}
+	}
+}
+
+func TestModelGlobalLimitSharedByAliases(t *testing.T) {
+	setupModelGlobalRateLimit(t, `{"test-177-c":3}`, false)
+	if err := setting.UpdateModelAliasGroupsByJSONString(`{"test-177-c":["test-177-c-0613"]}`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = setting.UpdateModelAliasGroupsByJSONString("{}") })
+
+	// 别名与标准模型名共享同一个全局限制
+	for i, modelName := range []string{"test-177-c", "test-177-c-0613", "test-177-c"} {
+		if w := serveUserModelNameRequest(1776, modelName); w.Code != http.StatusOK {
+			t.Fatalf("request %d for %s: status %d", i+1, modelName, w.Code)
+		}
+	}
+	w := serveUserModelNameRequest(1776, "test-177-c-0613")
+	if w.Code != http.StatusTooManyRequests || rejectCode(t, w) != "global_rate_limit_exceeded" {
+		t.Fatalf("alias request over the shared limit: status %d, body %s", w.Code, w.Body.String())
+	}
+}
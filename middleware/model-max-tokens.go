@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+const maxTokensCappedHeader = "X-NewAPI-Max-Tokens-Capped"
+
+// maxTokensFields 返回接口使用的最大输出 token 字段，第一个为请求中未指定时补充的字段
+func maxTokensFields(path string) []string {
+	switch {
+	case strings.HasPrefix(path, "/v1/responses"):
+		return []string{"max_output_tokens"}
+	case strings.HasPrefix(path, "/v1/messages"):
+		return []string{"max_tokens"}
+	case strings.HasPrefix(path, "/v1/chat/completions"), strings.HasPrefix(path, "/v1/completions"):
+		return []string{"max_tokens", "max_completion_tokens"}
+	}
+	return nil
+}
+
+// capMaxTokens 将请求体中超过上限的最大输出 token 数截断为上限，均未指定时补充上限。返回新的请求体及是否做了修改
+func capMaxTokens(body []byte, fields []string, limit int) ([]byte, bool, error) {
+	var err error
+	changed := false
+	specified := false
+	for _, field := range fields {
+		value := gjson.GetBytes(body, field)
+		if !value.Exists() || value.Type == gjson.Null {
+			continue
+		}
+		specified = true
+		if value.Int() > int64(limit) {
+			if body, err = sjson.SetBytes(body, field, limit); err != nil {
+				return nil, false, err
+			}
+			changed = true
+		}
+	}
+	if !specified {
+		if body, err = sjson.SetBytes(body, fields[0], limit); err != nil {
+			return nil, false, err
+		}
+		changed = true
+	}
+	return body, changed, nil
+}
+
+// ModelMaxTokensCap 按 ModelMaxTokensCap 限制转发给上游的最大输出 token 数，需在 Distribute 之后使用
+func ModelMaxTokensCap() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		fields := maxTokensFields(c.Request.URL.Path)
+		if fields == nil || !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+			c.Next()
+			return
+		}
+		limit := setting.GetModelMaxTokensCap(c.GetString("original_model"))
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			c.Next()
+			return
+		}
+		capped, changed, err := capMaxTokens(body, fields, limit)
+		if err != nil {
+			logger.LogWarn(c, "failed to cap max tokens: "+err.Error())
+			c.Next()
+			return
+		}
+		if changed {
+			c.Set(common.KeyRequestBody, capped)
+			if setting.ModelMaxTokensCapHeaderEnabled {
+				c.Header(maxTokensCappedHeader, strconv.Itoa(limit))
+			}
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+func TestCapMaxTokens(t *testing.T) {
+	chatFields := []string{"max_tokens", "max_completion_tokens"}
+	cases := []struct {
+		name        string
+		body        string
+		fields      []string
+		wantChanged bool
+		wantTokens  map[string]int64
+	}{
+		{"above the cap is clamped", `{"max_tokens":500}`, chatFields, true, map[string]int64{"max_tokens": 100}},
+		{"below the cap is kept", `{"max_tokens":50}`, chatFields, false, map[string]int64{"max_tokens": 50}},
+		{"missing is injected", `{"model":"gpt-4o"}`, chatFields, true, map[string]int64{"max_tokens": 100}},
+		{"null is injected", `{"max_tokens":null}`, chatFields, true, map[string]int64{"max_tokens": 100}},
+		{"only the alternate field is clamped", `{"max_completion_tokens":500}`, chatFields, true, map[string]int64{"max_completion_tokens": 100}},
+		{"responses field", `{"max_output_tokens":500}`, []string{"max_output_tokens"}, true, map[string]int64{"max_output_tokens": 100}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			body, changed, err := capMaxTokens([]byte(tc.body), tc.fields, 100)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tc.wantChanged {
+				t.Fatalf("changed = %v, want %v", changed, tc.wantChanged)
+			}
+			for field, want := range tc.wantTokens {
+				if got := gjson.GetBytes(body, field).Int(); got != want {
+					t.Fatalf("%s = %d, want %d (body %s)", field, got, want, body)
+				}
+			}
+			if !tc.wantChanged && string(body) != tc.body {
+				t.Fatalf("unchanged body rewritten to %s", body)
+			}
+		})
+	}
+}
+
+// serveMaxTokensRequest 发送一次经过 ModelMaxTokensCap 的请求，返回响应及转发给后续处理的请求体
+func serveMaxTokensRequest(t *testing.T, path string, modelName string, body string) (*httptest.ResponseRecorder, string) {
+	t.Helper()
+	var forwarded string
+	r := gin.New()
+	r.POST(path, func(c *gin.Context) {
+		c.Set("original_model", modelName)
+		c.Next()
+	}, ModelMaxTokensCap(), func(c *gin.Context) {
+		body, err := common.GetRequestBody(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		forwarded = string(body)
+		c.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w, forwarded
+}
+
+func TestModelMaxTokensCapMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	oldBodyMB := constant.MaxRequestBodyMB
+	constant.MaxRequestBodyMB = 8
+	if err := setting.UpdateModelMaxTokensCapByJSONString(`{"gpt-4o":100}`); err != nil {
+		t.Fatal(err)
+	}
+	setting.ModelMaxTokensCapHeaderEnabled = true
+	t.Cleanup(func() {
+		constant.MaxRequestBodyMB = oldBodyMB
+		_ = setting.UpdateModelMaxTokensCapByJSONString("{}")
+		setting.ModelMaxTokensCapHeaderEnabled = false
+	})
+
+	w, body := serveMaxTokensRequest(t, "/v1/chat/completions", "gpt-4o", `{"model":"gpt-4o","max_tokens":500}`)
+	if got := gjson.Get(body, "max_tokens").Int(); got != 100 {
+		t.Fatalf("forwarded max_tokens = %d, want 100", got)
+	}
+	if got := w.Header().Get(maxTokensCappedHeader); got != "100" {
+		t.Fatalf("%s = %q, want 100", maxTokensCappedHeader, got)
+	}
+
+	w, body = serveMaxTokensRequest(t, "/v1/messages", "gpt-4o", `{"model":"gpt-4o"}`)
+	if got := gjson.Get(body, "max_tokens").Int(); got != 100 {
+		t.Fatalf("injected max_tokens = %d, want 100", got)
+	}
+	if w.Header().Get(maxTokensCappedHeader) == "" {
+		t.Fatal("missing header after injecting the cap")
+	}
+
+	w, body = serveMaxTokensRequest(t, "/v1/chat/completions", "gpt-4o", `{"model":"gpt-4o","max_tokens":50}`)
+	if got := gjson.Get(body, "max_tokens").Int(); got != 50 || w.Header().Get(maxTokensCappedHeader) != "" {
+		t.Fatalf("request under the cap: max_tokens %d header %q, want 50 and no header", got, w.Header().Get(maxTokensCappedHeader))
+	}
+
+	// 未配置上限的模型不做修改
+	_, body = serveMaxTokensRequest(t, "/v1/chat/completions", "gpt-4o-mini", `{"model":"gpt-4o-mini","max_tokens":500}`)
+	if got := gjson.Get(body, "max_tokens").Int(); got != 500 {
+		t.Fatalf("uncapped model forwarded max_tokens = %d, want 500", got)
+	}
+
+	setting.ModelMaxTokensCapHeaderEnabled = false
+	w, body = serveMaxTokensRequest(t, "/v1/chat/completions", "gpt-4o", `{"model":"gpt-4o","max_tokens":500}`)
+	if got := gjson.Get(body, "max_tokens").Int(); got != 100 || w.Header().Get(maxTokensCappedHeader) != "" {
+		t.Fatalf("header disabled: max_tokens %d header %q, want 100 and no header", got, w.Header().Get(maxTokensCappedHeader))
+	}
+}
@@ -3,13 +3,16 @@ package middleware
 import (
 	"context"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/common/limiter"
 	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/setting"
 
 	"github.com/gin-gonic/gin"
@@ -21,6 +24,20 @@ const (
 	ModelRequestRateLimitSuccessCountMark = "MRRLS"
 )
 
+// 限流列表的长度超过上限的该倍数时视为异常（如 LTrim 失败），需要裁剪修复
+const rateLimitListRepairFactor = 2
+
+// repairOversizedRateLimitList 将异常增长的限流列表裁剪到上限，只保留最近的记录。
+// 否则每次检查都要面对超长列表，且表尾的过期记录会让检查错误地放行
+func repairOversizedRateLimitList(ctx context.Context, rdb *redis.Client, key string, length int64, maxCount int) {
+	common.SysLog(fmt.Sprintf("rate limit list %s has %d entries, exceeding limit %d, trimming", key, length, maxCount))
+	if err := rdb.LTrim(ctx, key, 0, int64(maxCount-1)).Err(); err != nil {
+		common.SysLog(fmt.Sprintf("failed to trim rate limit list %s, deleting: %s", key, err.Error()))
+		rdb.Del(ctx, key)
+	}
+	atomic.AddInt64(&rateLimitStats.repairedLists, 1)
+}
+
 // 检查Redis中的请求限制
 func checkRedisRateLimit(ctx context.Context, rdb *redis.Client, key string, maxCount int, duration int64) (bool, error) {
 	// 如果maxCount为0，表示不限制
@@ -33,6 +50,9 @@ func checkRedisRateLimit(ctx context.Context, rdb *redis.Client, key string, max
 	if err != nil {
 		return false, err
 	}
+	if length > int64(maxCount)*rateLimitListRepairFactor {
+		repairOversizedRateLimitList(ctx, rdb, key, length, maxCount)
+	}
 
 	// 如果未达到限制，允许请求
 	if length < int64(maxCount) {
@@ -61,17 +81,105 @@ func checkRedisRateLimit(ctx context.Context, rdb *redis.Client, key string, max
 	return true, nil
 }
 
-// 记录Redis请求
-func recordRedisRequest(ctx context.Context, rdb *redis.Client, key string, maxCount int) {
+// isRateLimitSuccess 判断请求是否应计入成功请求数
+func isRateLimitSuccess(c *gin.Context) bool {
+	if c.Writer.Status() >= 400 {
+		return false
+	}
+	// 上游响应前被客户端取消的请求没有完成任何工作
+	if isCancelledBeforeUpstream(c) {
+		return false
+	}
+	if setting.RateLimitSuccessExcludeBodyErrors && common.GetContextKeyBool(c, constant.ContextKeyResponseBodyError) {
+		return false
+	}
+	return true
+}
+
+// 标记请求已经过模型请求限流，同一请求的内部重试不再重复计数
+const rateLimitCountedContextKey = "rate_limit_counted"
+
+// markRateLimitPassed 请求通过全部限流检查、开始处理前调用
+func markRateLimitPassed(c *gin.Context) {
+	setRateLimitHeadersForRequest(c)
+	recordDedupRequest(c)
+	recordIdempotencyAllowed(c)
+	armRetryGrace(c)
+	wrapRateLimitHeaderWriter(c)
+}
+
+// retryAfterFromWait 将令牌桶返回的等待时长换算为 Retry-After 秒数，无法计算时退回到整个时间窗口
+func retryAfterFromWait(wait time.Duration, fallback int64) int64 {
+	if wait < 0 {
+		return fallback
+	}
+	seconds := int64(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return seconds
+}
+
+// tokenBucketRefillGranularity 令牌桶按 Redis 服务器时间的整秒补充令牌，不足一秒的等待不会补充任何令牌
+const tokenBucketRefillGranularity = time.Second
+
+// roundUpToBucketRefill 将令牌桶返回的等待时长向上取整到补充令牌的粒度，避免等待结束时令牌仍未补充而被拒绝
+func roundUpToBucketRefill(wait time.Duration) time.Duration {
+	if wait <= 0 {
+		return wait
+	}
+	return (wait + tokenBucketRefillGranularity - 1) / tokenBucketRefillGranularity * tokenBucketRefillGranularity
+}
+
+// reserveWithBlocking 从令牌桶获取令牌；当配置了 RateLimitBlockMaxMs 且需要等待的时长在预算内时，
+// 阻塞等待后重试而不是直接拒绝。客户端断开时立即停止等待。
+func reserveWithBlocking(ctx context.Context, c *gin.Context, tb *limiter.RedisLimiter, key string, opts ...limiter.Option) (bool, time.Duration, error) {
+	if isTotalCountForgiven(c) {
+		return true, 0, nil
+	}
+	budget := time.Duration(setting.RateLimitBlockMaxMs) * time.Millisecond
+	for {
+		allowed, wait, err := reserveWithTimeout(ctx, tb, key, opts...)
+		if isRedisSlow(err) {
+			config := limiter.ResolveConfig(opts...)
+			noteRedisFallback(key, err)
+			return memoryReserve(c, key, int(config.Rate), config.Requested), 0, nil
+		}
+		if allowed {
+			trackRateLimitConsumed(c, rateLimitConsumption{key: key, opts: opts})
+		}
+		if err != nil || allowed || wait < 0 {
+			return allowed, wait, err
+		}
+		wait = roundUpToBucketRefill(wait)
+		if wait > budget {
+			return allowed, wait, err
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-c.Request.Context().Done():
+			timer.Stop()
+			return false, wait, nil
+		case <-timer.C:
+		}
+		budget -= wait
+	}
+}
+
+// 记录Redis请求，返回写入列表时的错误
+func recordRedisRequest(ctx context.Context, rdb *redis.Client, key string, maxCount int) error {
 	// 如果maxCount为0，不记录请求
 	if maxCount == 0 {
-		return
+		return nil
 	}
 
 	now := time.Now().Format(timeFormat)
-	rdb.LPush(ctx, key, now)
+	if err := rdb.LPush(ctx, key, now).Err(); err != nil {
+		return err
+	}
 	rdb.LTrim(ctx, key, 0, int64(maxCount-1))
 	rdb.Expire(ctx, key, time.Duration(setting.ModelRequestRateLimitDurationMinutes)*time.Minute)
+	return nil
 }
 
 // Redis限流处理器 (per-user 限流，使用 user ID)
@@ -81,18 +189,23 @@ func redisRateLimitHandler(duration int64, totalMaxCount, successMaxCount int) g
 		userId := c.GetInt("id")
 		rateLimitKey := strconv.Itoa(userId)
 		ctx := context.Background()
-		rdb := common.RDB
+		rdb := common.RDB()
 
 		// 1. 检查成功请求数限制
 		successKey := fmt.Sprintf("rateLimit:%s:%s", ModelRequestRateLimitSuccessCountMark, rateLimitKey)
-		allowed, err := checkRedisRateLimit(ctx, rdb, successKey, successMaxCount, duration)
+		spanCtx, span := startRateLimitSpan(c, "user_success", successKey)
+		allowed, err := checkRedisSuccessLimit(spanCtx, rdb, successLimiterAlgorithm(c), successKey, successMaxCount, duration)
+		endRateLimitSpan(span, "user_success", successMaxCount, allowed, err)
 		if err != nil {
 			fmt.Println("检查成功请求数限制失败:", err.Error())
-			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
-			return
+			if !rateLimitFailOpen(err) {
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return
+			}
+			allowed = true
 		}
 		if !allowed {
-			abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("您已达到请求数限制：%d分钟内最多请求%d次", setting.ModelRequestRateLimitDurationMinutes, successMaxCount))
+			abortWithRateLimitMessage(c, rateLimitRejectSuccess, duration, fmt.Sprintf("您已达到请求数限制：%d分钟内最多请求%d次", setting.ModelRequestRateLimitDurationMinutes, successMaxCount))
 			return
 		}
 
@@ -101,31 +214,41 @@ func redisRateLimitHandler(duration int64, totalMaxCount, successMaxCount int) g
 			totalKey := fmt.Sprintf("rateLimit:%s", rateLimitKey)
 			// 初始化
 			tb := limiter.New(ctx, rdb)
-			allowed, err = tb.Allow(
-				ctx,
+			var wait time.Duration
+			spanCtx, span := startRateLimitSpan(c, "user_total", totalKey)
+			allowed, wait, err = reserveWithBlocking(spanCtx, c, tb,
 				totalKey,
 				limiter.WithCapacity(int64(totalMaxCount)*duration),
 				limiter.WithRate(int64(totalMaxCount)),
 				limiter.WithRequested(duration),
 			)
+			endRateLimitSpan(span, "user_total", totalMaxCount, allowed, err)
 
 			if err != nil {
 				fmt.Println("检查总请求数限制失败:", err.Error())
-				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
-				return
+				if !rateLimitFailOpen(err) {
+					abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+					return
+				}
+				allowed = true
 			}
+			evaluateShadowRateLimit(c, totalKey, totalMaxCount, duration, allowed)
 
 			if !allowed {
-				abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("您已达到总请求数限制：%d分钟内最多请求%d次，包括失败次数，请检查您的请求是否正确", setting.ModelRequestRateLimitDurationMinutes, totalMaxCount))
+				abortWithRateLimitMessage(c, rateLimitRejectTotal, retryAfterFromWait(wait, duration), fmt.Sprintf("您已达到总请求数限制：%d分钟内最多请求%d次，包括失败次数，请检查您的请求是否正确", setting.ModelRequestRateLimitDurationMinutes, totalMaxCount))
+				return
 			}
 		}
 
 		// 4. 处理请求
+		markRateLimitPassed(c)
 		c.Next()
 
 		// 5. 如果请求成功，记录成功请求
-		if c.Writer.Status() < 400 {
-			recordRedisRequest(ctx, rdb, successKey, successMaxCount)
+		if isRateLimitSuccess(c) {
+			recordRedisSuccess(ctx, rdb, successLimiterAlgorithm(c), successKey, successMaxCount, duration)
+		} else if totalMaxCount > 0 && upstream429PenaltyApplies(c) {
+			chargeRedisUpstream429Penalty(ctx, fmt.Sprintf("rateLimit:%s", rateLimitKey), totalMaxCount, duration)
 		}
 	}
 }
@@ -142,35 +265,34 @@ func memoryRateLimitHandler(duration int64, totalMaxCount, successMaxCount int)
 		successKey := ModelRequestRateLimitSuccessCountMark + rateLimitKey
 
 		// 1. 检查总请求数限制（当totalMaxCount为0时跳过）
-		if totalMaxCount > 0 && !inMemoryRateLimiter.Request(totalKey, totalMaxCount, duration) {
-			c.Status(http.StatusTooManyRequests)
-			c.Abort()
+		if totalMaxCount > 0 && !memoryReserve(c, totalKey, totalMaxCount, duration) {
+			abortWithRateLimitStatus(c, rateLimitRejectTotal, duration)
 			return
 		}
 
-		// 2. 检查成功请求数限制
-		// 使用一个临时key来检查限制，这样可以避免实际记录
-		checkKey := successKey + "_check"
-		if !inMemoryRateLimiter.Request(checkKey, successMaxCount, duration) {
-			c.Status(http.StatusTooManyRequests)
-			c.Abort()
+		// 2. 检查成功请求数限制（当successMaxCount为0时跳过）
+		if !checkMemorySuccessLimit(successLimiterAlgorithm(c), successKey, successMaxCount, duration) {
+			abortWithRateLimitStatus(c, rateLimitRejectSuccess, duration)
 			return
 		}
 
 		// 3. 处理请求
+		markRateLimitPassed(c)
 		c.Next()
 
 		// 4. 如果请求成功，记录到实际的成功请求计数中
-		if c.Writer.Status() < 400 {
-			inMemoryRateLimiter.Request(successKey, successMaxCount, duration)
+		if isRateLimitSuccess(c) {
+			recordMemorySuccess(successLimiterAlgorithm(c), successKey, successMaxCount, duration)
+		} else if totalMaxCount > 0 && upstream429PenaltyApplies(c) {
+			inMemoryRateLimiter.Charge(totalKey, setting.Upstream429Penalty, totalMaxCount)
 		}
 	}
 }
 
 // Token rate limit constants
 const (
-	TokenRateLimitCountMark        = "TRL"
-	TokenRateLimitSuccessCountMark = "TRLS"
+	TokenRateLimitCountMark             = "TRL"
+	TokenRateLimitSuccessCountMark      = "TRLS"
 	TokenDailyRateLimitCountMark        = "TDRL"
 	TokenDailyRateLimitSuccessCountMark = "TDRLS"
 )
@@ -199,37 +321,100 @@ func checkTokenRateLimit(c *gin.Context) bool {
 		successMaxCount = groupSuccessCount
 	}
 
+	rateLimitKey := strconv.Itoa(tokenId)
+	duration := int64(setting.TokenRateLimitDurationMinutes * 60)
+
 	// 如果两个限制都为0，表示不限制
-	if totalMaxCount == 0 && successMaxCount == 0 {
+	if totalMaxCount > 0 || successMaxCount > 0 {
+		var allowed bool
+		if setting.TokenRateLimitWindowMode == setting.RateLimitWindowFixed {
+			// 固定窗口按时钟对齐计数，窗口结束时 key 过期，计数一次性清零
+			allowed = checkPeriodRateLimit(c,
+				fmt.Sprintf("rateLimit:%s:%s", TokenRateLimitCountMark, rateLimitKey),
+				fmt.Sprintf("rateLimit:%s:%s", TokenRateLimitSuccessCountMark, rateLimitKey),
+				totalMaxCount, successMaxCount, setting.FixedWindow(time.Now(), time.Duration(duration)*time.Second), nil)
+		} else if common.RedisEnabled() {
+			allowed = checkTokenRateLimitRedis(c, rateLimitKey, totalMaxCount, successMaxCount, duration)
+		} else {
+			allowed = checkTokenRateLimitMemory(c, rateLimitKey, totalMaxCount, successMaxCount, duration)
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	return checkTokenPerIPRateLimit(c, rateLimitKey, duration)
+}
+
+// checkTokenPerIPRateLimit 在密钥整体限流之上，限制同一密钥下单个客户端 IP 的总请求数，
+// 避免密钥被多个 IP 共享时某个 IP 耗尽整个密钥的额度
+func checkTokenPerIPRateLimit(c *gin.Context, rateLimitKey string, duration int64) bool {
+	maxCount := setting.TokenPerIPRateLimit
+	if maxCount <= 0 {
+		return true
+	}
+	ip := c.ClientIP()
+	if ip == "" {
 		return true
 	}
+	message := fmt.Sprintf("当前 IP 已达到该密钥的请求数限制：%d分钟内最多请求%d次（包括失败请求）", setting.TokenRateLimitDurationMinutes, maxCount)
 
-	rateLimitKey := strconv.Itoa(tokenId)
-	duration := int64(setting.TokenRateLimitDurationMinutes * 60)
+	if !common.RedisEnabled() {
+		inMemoryRateLimiter.Init(time.Duration(setting.TokenRateLimitDurationMinutes) * time.Minute)
+		if !memoryReserve(c, TokenRateLimitCountMark+rateLimitKey+":"+ip, maxCount, duration) {
+			abortWithRateLimitMessage(c, rateLimitRejectTotal, duration, message)
+			return false
+		}
+		return true
+	}
 
-	if common.RedisEnabled {
-		return checkTokenRateLimitRedis(c, rateLimitKey, totalMaxCount, successMaxCount, duration)
-	} else {
-		return checkTokenRateLimitMemory(c, rateLimitKey, totalMaxCount, successMaxCount, duration)
+	ctx := context.Background()
+	key := fmt.Sprintf("rateLimit:%s:%s:%s", TokenRateLimitCountMark, rateLimitKey, ip)
+	tb := limiter.New(ctx, common.RDB())
+	spanCtx, span := startRateLimitSpan(c, "token_ip_total", key)
+	allowed, wait, err := reserveWithBlocking(spanCtx, c, tb,
+		key,
+		limiter.WithCapacity(int64(maxCount)*duration),
+		limiter.WithRate(int64(maxCount)),
+		limiter.WithRequested(duration),
+	)
+	endRateLimitSpan(span, "token_ip_total", maxCount, allowed, err)
+	if err != nil {
+		fmt.Println("检查密钥单 IP 请求数限制失败:", err.Error())
+		if !rateLimitFailOpen(err) {
+			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+			return false
+		}
+		allowed = true
 	}
+	if !allowed {
+		abortWithRateLimitMessage(c, rateLimitRejectTotal, retryAfterFromWait(wait, duration), message)
+		return false
+	}
+	return true
 }
 
 // checkTokenRateLimitRedis Redis版本的分钟级限流检查
 func checkTokenRateLimitRedis(c *gin.Context, rateLimitKey string, totalMaxCount, successMaxCount int, duration int64) bool {
 	ctx := context.Background()
-	rdb := common.RDB
+	rdb := common.RDB()
 
 	// 1. 检查成功请求数限制
 	if successMaxCount > 0 {
 		successKey := fmt.Sprintf("rateLimit:%s:%s", TokenRateLimitSuccessCountMark, rateLimitKey)
-		allowed, err := checkRedisRateLimit(ctx, rdb, successKey, successMaxCount, duration)
+		spanCtx, span := startRateLimitSpan(c, "token_success", successKey)
+		allowed, err := checkRedisSuccessLimit(spanCtx, rdb, successLimiterAlgorithm(c), successKey, successMaxCount, duration)
+		endRateLimitSpan(span, "token_success", successMaxCount, allowed, err)
 		if err != nil {
 			fmt.Println("检查密钥成功请求数限制失败:", err.Error())
-			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
-			return false
+			if !rateLimitFailOpen(err) {
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return false
+			}
+			allowed = true
 		}
 		if !allowed {
-			abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("您已达到密钥请求数限制：%d分钟内最多请求%d次", setting.TokenRateLimitDurationMinutes, successMaxCount))
+			abortWithRateLimitMessage(c, rateLimitRejectSuccess, duration, fmt.Sprintf("您已达到密钥请求数限制：%d分钟内最多请求%d次", setting.TokenRateLimitDurationMinutes, successMaxCount))
 			return false
 		}
 	}
@@ -238,22 +423,27 @@ func checkTokenRateLimitRedis(c *gin.Context, rateLimitKey string, totalMaxCount
 	if totalMaxCount > 0 {
 		totalKey := fmt.Sprintf("rateLimit:%s:%s", TokenRateLimitCountMark, rateLimitKey)
 		tb := limiter.New(ctx, rdb)
-		allowed, err := tb.Allow(
-			ctx,
+		spanCtx, span := startRateLimitSpan(c, "token_total", totalKey)
+		allowed, wait, err := reserveWithBlocking(spanCtx, c, tb,
 			totalKey,
 			limiter.WithCapacity(int64(totalMaxCount)*duration),
 			limiter.WithRate(int64(totalMaxCount)),
 			limiter.WithRequested(duration),
 		)
+		endRateLimitSpan(span, "token_total", totalMaxCount, allowed, err)
 
 		if err != nil {
 			fmt.Println("检查密钥总请求数限制失败:", err.Error())
-			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
-			return false
+			if !rateLimitFailOpen(err) {
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return false
+			}
+			allowed = true
 		}
+		evaluateShadowRateLimit(c, totalKey, totalMaxCount, duration, allowed)
 
 		if !allowed {
-			abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("您已达到密钥总请求数限制：%d分钟内最多请求%d次（包括失败请求）", setting.TokenRateLimitDurationMinutes, totalMaxCount))
+			abortWithRateLimitMessage(c, rateLimitRejectTotal, retryAfterFromWait(wait, duration), fmt.Sprintf("您已达到密钥总请求数限制：%d分钟内最多请求%d次（包括失败请求）", setting.TokenRateLimitDurationMinutes, totalMaxCount))
 			return false
 		}
 	}
@@ -286,16 +476,21 @@ func recordTokenRateLimitSuccess(c *gin.Context) {
 	}
 
 	rateLimitKey := strconv.Itoa(tokenId)
+	duration := int64(setting.TokenRateLimitDurationMinutes * 60)
 
-	if common.RedisEnabled {
+	// 固定窗口模式已在检查时预占
+	if hasSuccessReservation(c, fmt.Sprintf("rateLimit:%s:%s", TokenRateLimitSuccessCountMark, rateLimitKey)) {
+		return
+	}
+
+	if common.RedisEnabled() {
 		ctx := context.Background()
-		rdb := common.RDB
+		rdb := common.RDB()
 		successKey := fmt.Sprintf("rateLimit:%s:%s", TokenRateLimitSuccessCountMark, rateLimitKey)
-		recordRedisRequest(ctx, rdb, successKey, successMaxCount)
+		recordRedisSuccess(ctx, rdb, successLimiterAlgorithm(c), successKey, successMaxCount, duration)
 	} else {
-		duration := int64(setting.TokenRateLimitDurationMinutes * 60)
 		successKey := TokenRateLimitSuccessCountMark + rateLimitKey
-		inMemoryRateLimiter.Request(successKey, successMaxCount, duration)
+		recordMemorySuccess(successLimiterAlgorithm(c), successKey, successMaxCount, duration)
 	}
 }
 
@@ -307,16 +502,15 @@ func checkTokenRateLimitMemory(c *gin.Context, rateLimitKey string, totalMaxCoun
 	successKey := TokenRateLimitSuccessCountMark + rateLimitKey
 
 	// 1. 检查总请求数限制
-	if totalMaxCount > 0 && !inMemoryRateLimiter.Request(totalKey, totalMaxCount, duration) {
-		abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("您已达到密钥总请求数限制：%d分钟内最多请求%d次（包括失败请求）", setting.TokenRateLimitDurationMinutes, totalMaxCount))
+	if totalMaxCount > 0 && !memoryReserve(c, totalKey, totalMaxCount, duration) {
+		abortWithRateLimitMessage(c, rateLimitRejectTotal, duration, fmt.Sprintf("您已达到密钥总请求数限制：%d分钟内最多请求%d次（包括失败请求）", setting.TokenRateLimitDurationMinutes, totalMaxCount))
 		return false
 	}
 
 	// 2. 检查成功请求数限制（使用临时key检查）
 	if successMaxCount > 0 {
-		checkKey := successKey + "_check"
-		if !inMemoryRateLimiter.Request(checkKey, successMaxCount, duration) {
-			abortWithOpenAiMessage(c, http.StatusTooManyRequests, fmt.Sprintf("您已达到密钥请求数限制：%d分钟内最多请求%d次", setting.TokenRateLimitDurationMinutes, successMaxCount))
+		if !checkMemorySuccessLimit(successLimiterAlgorithm(c), successKey, successMaxCount, duration) {
+			abortWithRateLimitMessage(c, rateLimitRejectSuccess, duration, fmt.Sprintf("您已达到密钥请求数限制：%d分钟内最多请求%d次", setting.TokenRateLimitDurationMinutes, successMaxCount))
 			return false
 		}
 	}
@@ -356,53 +550,81 @@ func checkTokenDailyRateLimit(c *gin.Context) bool {
 	rateLimitKey := strconv.Itoa(tokenId)
 	duration := int64(86400) // 24小时 = 86400秒
 
-	if common.RedisEnabled {
-		return checkTokenDailyRateLimitRedis(c, rateLimitKey, totalMaxCount, successMaxCount, duration)
+	// 配置了重置周期时按固定周期计数，否则按滚动的 24 小时窗口
+	if window, ok := setting.GetTokenQuotaWindow(time.Now()); ok {
+		// 临近重置时允许从下一周期借用少量额度
+		next, _ := setting.GetTokenQuotaWindow(window.Reset)
+		return checkPeriodRateLimit(c,
+			fmt.Sprintf("rateLimit:%s:%s", TokenDailyRateLimitCountMark, rateLimitKey),
+			fmt.Sprintf("rateLimit:%s:%s", TokenDailyRateLimitSuccessCountMark, rateLimitKey),
+			totalMaxCount, successMaxCount, window, &next)
+	}
+
+	if common.RedisEnabled() {
+		return checkDailyRateLimitRedis(c, TokenDailyRateLimitCountMark, TokenDailyRateLimitSuccessCountMark, rateLimitKey, totalMaxCount, successMaxCount, duration)
 	} else {
-		return checkTokenDailyRateLimitMemory(c, rateLimitKey, totalMaxCount, successMaxCount, duration)
+		return checkDailyRateLimitMemory(c, TokenDailyRateLimitCountMark, TokenDailyRateLimitSuccessCountMark, rateLimitKey, totalMaxCount, successMaxCount, duration)
 	}
 }
 
-// checkTokenDailyRateLimitRedis Redis版本的每日限流检查
-func checkTokenDailyRateLimitRedis(c *gin.Context, rateLimitKey string, totalMaxCount, successMaxCount int, duration int64) bool {
+// checkDailyRateLimitRedis Redis版本的每日限流检查，totalMark/successMark 区分 per-key 与 per-user 维度
+func checkDailyRateLimitRedis(c *gin.Context, totalMark, successMark string, rateLimitKey string, totalMaxCount, successMaxCount int, duration int64) bool {
 	ctx := context.Background()
-	rdb := common.RDB
+	rdb := common.RDB()
 
 	// 1. 检查成功请求数限制
 	if successMaxCount > 0 {
-		successKey := fmt.Sprintf("rateLimit:%s:%s", TokenDailyRateLimitSuccessCountMark, rateLimitKey)
-		allowed, err := checkRedisRateLimit(ctx, rdb, successKey, successMaxCount, duration)
+		successKey := fmt.Sprintf("rateLimit:%s:%s", successMark, rateLimitKey)
+		algorithm := successLimiterAlgorithm(c)
+		spanCtx, span := startRateLimitSpan(c, successMark, successKey)
+		var allowed bool
+		var err error
+		if useLeakySuccessLimiter(algorithm, successMaxCount) {
+			allowed, err = checkRedisSuccessLimit(spanCtx, rdb, algorithm, successKey, successMaxCount, duration)
+		} else {
+			// 滑动窗口在检查时原子地预占名额，请求成功后不再重复记录
+			allowed, err = reserveRedisSuccess(spanCtx, c, successKey, successMaxCount, duration)
+		}
+		endRateLimitSpan(span, successMark, successMaxCount, allowed, err)
 		if err != nil {
 			fmt.Println("检查每日成功请求数限制失败:", err.Error())
-			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
-			return false
+			if !rateLimitFailOpen(err) {
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return false
+			}
+			allowed = true
 		}
 		if !allowed {
-			abortWithOpenAiMessage(c, http.StatusTooManyRequests, "您已达到每日请求数限制")
+			abortWithRateLimitMessage(c, rateLimitRejectSuccess, duration, "您已达到每日请求数限制")
 			return false
 		}
 	}
 
 	// 2. 检查总请求数限制
 	if totalMaxCount > 0 {
-		totalKey := fmt.Sprintf("rateLimit:%s:%s", TokenDailyRateLimitCountMark, rateLimitKey)
+		totalKey := fmt.Sprintf("rateLimit:%s:%s", totalMark, rateLimitKey)
 		tb := limiter.New(ctx, rdb)
-		allowed, err := tb.Allow(
-			ctx,
+		spanCtx, span := startRateLimitSpan(c, totalMark, totalKey)
+		allowed, wait, err := reserveWithBlocking(spanCtx, c, tb,
 			totalKey,
 			limiter.WithCapacity(int64(totalMaxCount)*duration),
 			limiter.WithRate(int64(totalMaxCount)),
 			limiter.WithRequested(duration),
 		)
+		endRateLimitSpan(span, totalMark, totalMaxCount, allowed, err)
 
 		if err != nil {
 			fmt.Println("检查每日总请求数限制失败:", err.Error())
-			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
-			return false
+			if !rateLimitFailOpen(err) {
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return false
+			}
+			allowed = true
 		}
+		evaluateShadowRateLimit(c, totalKey, totalMaxCount, duration, allowed)
 
 		if !allowed {
-			abortWithOpenAiMessage(c, http.StatusTooManyRequests, "您已达到每日总请求数限制（包括失败请求）")
+			abortWithRateLimitMessage(c, rateLimitRejectTotal, retryAfterFromWait(wait, duration), "您已达到每日总请求数限制（包括失败请求）")
 			return false
 		}
 	}
@@ -435,37 +657,41 @@ func recordTokenDailySuccess(c *gin.Context) {
 	}
 
 	rateLimitKey := strconv.Itoa(tokenId)
+	duration := int64(86400)
+
+	// 按固定周期计数时已在检查时预占
+	if hasSuccessReservation(c, fmt.Sprintf("rateLimit:%s:%s", TokenDailyRateLimitSuccessCountMark, rateLimitKey)) {
+		return
+	}
 
-	if common.RedisEnabled {
+	if common.RedisEnabled() {
 		ctx := context.Background()
-		rdb := common.RDB
+		rdb := common.RDB()
 		successKey := fmt.Sprintf("rateLimit:%s:%s", TokenDailyRateLimitSuccessCountMark, rateLimitKey)
-		recordRedisRequest(ctx, rdb, successKey, successMaxCount)
+		recordRedisSuccess(ctx, rdb, successLimiterAlgorithm(c), successKey, successMaxCount, duration)
 	} else {
-		duration := int64(86400)
 		successKey := TokenDailyRateLimitSuccessCountMark + rateLimitKey
-		inMemoryRateLimiter.Request(successKey, successMaxCount, duration)
+		recordMemorySuccess(successLimiterAlgorithm(c), successKey, successMaxCount, duration)
 	}
 }
 
-// checkTokenDailyRateLimitMemory 内存版本的每日限流检查
-func checkTokenDailyRateLimitMemory(c *gin.Context, rateLimitKey string, totalMaxCount, successMaxCount int, duration int64) bool {
+// checkDailyRateLimitMemory 内存版本的每日限流检查
+func checkDailyRateLimitMemory(c *gin.Context, totalMark, successMark string, rateLimitKey string, totalMaxCount, successMaxCount int, duration int64) bool {
 	inMemoryRateLimiter.Init(24 * time.Hour)
 
-	totalKey := TokenDailyRateLimitCountMark + rateLimitKey
-	successKey := TokenDailyRateLimitSuccessCountMark + rateLimitKey
+	totalKey := totalMark + rateLimitKey
+	successKey := successMark + rateLimitKey
 
 	// 1. 检查总请求数限制
-	if totalMaxCount > 0 && !inMemoryRateLimiter.Request(totalKey, totalMaxCount, duration) {
-		abortWithOpenAiMessage(c, http.StatusTooManyRequests, "您已达到每日总请求数限制（包括失败请求）")
+	if totalMaxCount > 0 && !memoryReserve(c, totalKey, totalMaxCount, duration) {
+		abortWithRateLimitMessage(c, rateLimitRejectTotal, duration, "您已达到每日总请求数限制（包括失败请求）")
 		return false
 	}
 
 	// 2. 检查成功请求数限制（使用临时key检查）
 	if successMaxCount > 0 {
-		checkKey := successKey + "_check"
-		if !inMemoryRateLimiter.Request(checkKey, successMaxCount, duration) {
-			abortWithOpenAiMessage(c, http.StatusTooManyRequests, "您已达到每日请求数限制")
+		if !checkMemorySuccessLimit(successLimiterAlgorithm(c), successKey, successMaxCount, duration) {
+			abortWithRateLimitMessage(c, rateLimitRejectSuccess, duration, "您已达到每日请求数限制")
 			return false
 		}
 	}
@@ -473,26 +699,151 @@ func checkTokenDailyRateLimitMemory(c *gin.Context, rateLimitKey string, totalMa
 	return true
 }
 
+// checkRateLimitGroup 在 RateLimitStrictGroup 模式下检查上下文中是否设置了用户分组与令牌分组。
+// 令牌分组可以为空（表示跟随用户分组），但鉴权时必须写入上下文；用户分组不能为空。
+func checkRateLimitGroup(c *gin.Context) bool {
+	if setting.RateLimitStrictGroup == setting.RateLimitStrictGroupOff {
+		return true
+	}
+	_, tokenGroupSet := common.GetContextKey(c, constant.ContextKeyTokenGroup)
+	userGroup := common.GetContextKeyString(c, constant.ContextKeyUserGroup)
+	if tokenGroupSet && userGroup != "" {
+		return true
+	}
+	atomic.AddInt64(&rateLimitStats.missingGroupTotal, 1)
+	common.SysLog(fmt.Sprintf("rate limit group missing in context: path=%s, user_id=%d, token_id=%d, token_group_set=%t, user_group=%q",
+		c.Request.URL.Path, c.GetInt("id"), common.GetContextKeyInt(c, constant.ContextKeyTokenId), tokenGroupSet, userGroup))
+	if setting.RateLimitStrictGroup == setting.RateLimitStrictGroupReject {
+		abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_group_missing")
+		return false
+	}
+	return true
+}
+
 // ModelRequestRateLimit 模型请求限流中间件
 func ModelRequestRateLimit() func(c *gin.Context) {
 	return func(c *gin.Context) {
+		// 不计费的请求（如 OPTIONS、HEAD）不消耗限流额度
+		if !setting.IsRateLimitCountMethod(c.Request.Method) {
+			c.Next()
+			return
+		}
+
+		// 渠道重试在 Relay 内部完成，不会再次经过本中间件；若请求被再次分发（如重新进入路由），
+		// 同一个用户请求也只计一次，避免内部重试重复消耗限流额度
+		if c.GetBool(rateLimitCountedContextKey) {
+			c.Next()
+			return
+		}
+		c.Set(rateLimitCountedContextKey, true)
+
+		// 沙盒请求只在沙盒令牌桶中计数，不经过正式限流也不转发到上游
+		if isRateLimitSandboxRequest(c) {
+			serveRateLimitSandbox(c)
+			return
+		}
+
+		if !checkRequestParseable(c) {
+			return
+		}
+
+		defer refundCancelledRequest(c)
+		defer releaseSuccessReservations(c)
+
+		// 管理员排查线上问题时不受限流影响
+		if setting.ExemptAdminFromRateLimit && common.GetContextKeyInt(c, constant.ContextKeyUserRole) >= common.RoleAdminUser {
+			c.Next()
+			return
+		}
+
+		if !checkRateLimitGroup(c) {
+			return
+		}
+
+		// 使用相同 Idempotency-Key 重试的请求沿用第一次的判定，不再重复计数
+		if replayIdempotencyDecision(c) {
+			return
+		}
+
+		// 去重窗口内重复发送的相同请求已经计入过总请求数，不再重复计数，其他限流检查照常进行
+		if hash := requestDedupHash(c); hash != "" {
+			duplicate, forgiven := forgiveDuplicateRequest(hash)
+			if forgiven {
+				forgiveTotalCount(c)
+			}
+			if !duplicate {
+				c.Set(rateLimitDedupContextKey, hash)
+			}
+		}
+
+		// 失败后的重试在宽限次数内不再计入总请求数，其他限流检查照常进行
+		if !isTotalCountForgiven(c) && forgiveRetry(c) {
+			forgiveTotalCount(c)
+		}
+		defer recordFailedAttempt(c)
+
+		// 0. 接近上限时优先拒绝低优先级请求
+		if !checkLowPriorityHeadroom(c) {
+			return
+		}
+
 		// 1. 先检查 per-key 分钟级限流（新功能）
 		if !checkTokenRateLimit(c) {
 			return
 		}
 
+		// 1.1 检查同一 key 下单个终端客户的限流
+		if !checkCustomerRateLimit(c) {
+			return
+		}
+
+		// 1.2 检查令牌对单个模型的每日请求数上限，先于令牌的每日限流
+		if !checkModelDailyCap(c) {
+			return
+		}
+
+		// 1.3 检查 per-key 两段式（突发后持续）限流
+		if !checkTokenBurstRateLimit(c) {
+			return
+		}
+
 		// 2. 检查 per-key 每日限流（新功能）
+		dailyConsumed := rateLimitConsumedCount(c)
 		if !checkTokenDailyRateLimit(c) {
 			return
 		}
 
+		// 2.1 检查 per-user 每日限流，同一用户的多个令牌共享。被用户每日限制拒绝的请求不计入令牌的每日总请求数
+		if !checkUserDailyRateLimit(c) {
+			refundRateLimitConsumedSince(c, dailyConsumed)
+			return
+		}
+
+		// 2.2 检查 per-org 限流，同一组织的多个用户共享
+		if !checkOrgRateLimit(c) {
+			return
+		}
+
+		// 2.3 检查模型的全局限流，开启公平分配时每个用户只能使用其中一份
+		if !checkModelGlobalRateLimit(c) {
+			return
+		}
+
+		// 2.4 按顺序检查组合限流规则
+		if !checkRateLimitRules(c) {
+			return
+		}
+
 		// 3. 再检查原有的 per-user 限流（保持兼容性）
 		if !setting.ModelRequestRateLimitEnabled {
+			markRateLimitPassed(c)
 			c.Next()
 			// 请求成功后记录 per-key 成功请求
-			if c.Writer.Status() < 400 {
+			if isRateLimitSuccess(c) {
 				recordTokenRateLimitSuccess(c)
 				recordTokenDailySuccess(c)
+				recordUserDailySuccess(c)
+				recordOrgRateLimitSuccess(c)
 			}
 			return
 		}
@@ -511,18 +862,22 @@ func ModelRequestRateLimit() func(c *gin.Context) {
 			totalMaxCount = groupTotalCount
 			successMaxCount = groupSuccessCount
 		}
+		// 部分渠道不可用时按已启用渠道数收紧总请求数限制，保护剩余的上游
+		totalMaxCount = setting.ScaleRateLimitByChannels(totalMaxCount, model.GetActiveChannelCount())
 
 		// 根据存储类型选择并执行限流处理器
-		if common.RedisEnabled {
+		if common.RedisEnabled() {
 			redisRateLimitHandler(duration, totalMaxCount, successMaxCount)(c)
 		} else {
 			memoryRateLimitHandler(duration, totalMaxCount, successMaxCount)(c)
 		}
 
 		// 请求成功后记录 per-key 成功请求
-		if c.Writer.Status() < 400 {
+		if isRateLimitSuccess(c) {
 			recordTokenRateLimitSuccess(c)
 			recordTokenDailySuccess(c)
+			recordUserDailySuccess(c)
+			recordOrgRateLimitSuccess(c)
 		}
 	}
 }
@@ -0,0 +1,561 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupMemoryRateLimit 使用内存限流，并开启令牌分钟级总请求数限制
+func setupMemoryRateLimit(t *testing.T, totalCount int) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	common.SetRedisEnabled(false)
+	constant.MaxRequestBodyMB = 8
+	setting.TokenRateLimitEnabled = true
+	setting.TokenRateLimitCount = totalCount
+	setting.TokenRateLimitSuccessCount = 0
+	inMemoryRateLimiter.Init(time.Minute)
+	t.Cleanup(func() {
+		setting.TokenRateLimitEnabled = false
+		setting.TokenRateLimitCount = 0
+	})
+}
+
+// serveModelRequest 以令牌 tokenId 发送一次经过 ModelRequestRateLimit 的请求，上游处理函数返回 status
+func serveModelRequest(tokenId int, body string, status int, headers map[string]string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyTokenId, tokenId)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "default")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		c.Next()
+	}, ModelRequestRateLimit(), func(c *gin.Context) {
+		c.Status(status)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRoundUpToBucketRefill(t *testing.T) {
+	cases := []struct {
+		wait time.Duration
+		want time.Duration
+	}{
+		{-1, -1},
+		{0, 0},
+		{time.Millisecond, time.Second},
+		{400 * time.Millisecond, time.Second},
+		{time.Second, time.Second},
+		{1001 * time.Millisecond, 2 * time.Second},
+	}
+	for _, tc := range cases {
+		if got := roundUpToBucketRefill(tc.wait); got != tc.want {
+			t.Errorf("roundUpToBucketRefill(%v) = %v, want %v", tc.wait, got, tc.want)
+		}
+	}
+}
+
+func TestRateLimitRejectStatusCode(t *testing.T) {
+	setupMemoryRateLimit(t, 1)
+	setting.RateLimitRejectStatusCode = http.StatusServiceUnavailable
+	t.Cleanup(func() { setting.RateLimitRejectStatusCode = http.StatusTooManyRequests })
+
+	if w := serveModelRequest(1011, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	w := serveModelRequest(1011, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("rejected request: status %d, want 503", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("missing Retry-After")
+	}
+}
+
+func TestRetryAfterFromWait(t *testing.T) {
+	cases := []struct {
+		wait time.Duration
+		want int64
+	}{
+		{-1, 60},
+		{0, 1},
+		{200 * time.Millisecond, 1},
+		{1500 * time.Millisecond, 2},
+		{3 * time.Second, 3},
+	}
+	for _, tc := range cases {
+		if got := retryAfterFromWait(tc.wait, 60); got != tc.want {
+			t.Errorf("retryAfterFromWait(%v) = %d, want %d", tc.wait, got, tc.want)
+		}
+	}
+}
+
+// serveBodyErrorRequest 发送一次上游返回 200 但响应体中带有错误的请求
+func serveBodyErrorRequest(tokenId int) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyTokenId, tokenId)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "default")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		c.Next()
+	}, ModelRequestRateLimit(), func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyResponseBodyError, true)
+		c.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestBodyErrorNotCountedAsSuccess(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	setting.TokenRateLimitSuccessCount = 1
+	setting.RateLimitSuccessExcludeBodyErrors = true
+	t.Cleanup(func() {
+		setting.TokenRateLimitSuccessCount = 0
+		setting.RateLimitSuccessExcludeBodyErrors = false
+	})
+
+	for i := 0; i < 2; i++ {
+		if w := serveBodyErrorRequest(1071); w.Code != http.StatusOK {
+			t.Fatalf("body error request %d: status %d", i, w.Code)
+		}
+	}
+	if w := serveModelRequest(1071, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("successful request: status %d, body errors were counted as success", w.Code)
+	}
+	if w := serveModelRequest(1071, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request after success limit: status %d, want 429", w.Code)
+	}
+}
+
+func TestBodyErrorCountedWhenExclusionDisabled(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	setting.TokenRateLimitSuccessCount = 1
+	t.Cleanup(func() { setting.TokenRateLimitSuccessCount = 0 })
+
+	if w := serveBodyErrorRequest(1072); w.Code != http.StatusOK {
+		t.Fatalf("body error request: status %d", w.Code)
+	}
+	if w := serveModelRequest(1072, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request after counted body error: status %d, want 429", w.Code)
+	}
+}
+
+// serveModelRequestAsRole 以指定角色的用户发送一次请求，用户信息与鉴权中间件一样通过 UserBase 写入上下文
+func serveModelRequestAsRole(tokenId int, role int) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		user := &model.User{Id: tokenId, Role: role, Group: "default", Status: common.UserStatusEnabled}
+		user.ToBaseUser().WriteContext(c)
+		common.SetContextKey(c, constant.ContextKeyTokenId, tokenId)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "default")
+		c.Next()
+	}, ModelRequestRateLimit(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAdminExemptFromRateLimit(t *testing.T) {
+	setupMemoryRateLimit(t, 1)
+
+	for i := 0; i < 3; i++ {
+		if w := serveModelRequestAsRole(1131, common.RoleAdminUser); w.Code != http.StatusOK {
+			t.Fatalf("admin request %d: status %d", i, w.Code)
+		}
+	}
+	if w := serveModelRequestAsRole(1132, common.RoleRootUser); w.Code != http.StatusOK {
+		t.Fatalf("root request: status %d", w.Code)
+	}
+
+	if w := serveModelRequestAsRole(1133, common.RoleCommonUser); w.Code != http.StatusOK {
+		t.Fatalf("first user request: status %d", w.Code)
+	}
+	if w := serveModelRequestAsRole(1133, common.RoleCommonUser); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second user request: status %d, want 429", w.Code)
+	}
+}
+
+func TestAdminThrottledWhenExemptionDisabled(t *testing.T) {
+	setupMemoryRateLimit(t, 1)
+	setting.ExemptAdminFromRateLimit = false
+	t.Cleanup(func() { setting.ExemptAdminFromRateLimit = true })
+
+	if w := serveModelRequestAsRole(1134, common.RoleAdminUser); w.Code != http.StatusOK {
+		t.Fatalf("first admin request: status %d", w.Code)
+	}
+	if w := serveModelRequestAsRole(1134, common.RoleAdminUser); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second admin request: status %d, want 429", w.Code)
+	}
+}
+
+// rejectCode 返回限流拒绝响应体中的错误码
+func rejectCode(t *testing.T, w *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("invalid body %q: %v", w.Body.String(), err)
+	}
+	return body.Error.Code
+}
+
+func TestTotalAndSuccessRejectionsDiffer(t *testing.T) {
+	setupMemoryRateLimit(t, 1)
+	setting.RateLimitTotalRejectStatusCode = http.StatusServiceUnavailable
+	setting.RateLimitSuccessRejectStatusCode = http.StatusTooManyRequests
+	setting.RateLimitTotalMinRetryAfterSeconds = 300
+	t.Cleanup(func() {
+		setting.RateLimitTotalRejectStatusCode = 0
+		setting.RateLimitSuccessRejectStatusCode = 0
+		setting.RateLimitTotalMinRetryAfterSeconds = 0
+		setting.TokenRateLimitSuccessCount = 0
+	})
+
+	// 总请求数限流：失败请求也会计入
+	serveModelRequest(1151, `{"model":"gpt-4o"}`, http.StatusBadRequest, nil)
+	w := serveModelRequest(1151, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	if w.Code != http.StatusServiceUnavailable || rejectCode(t, w) != "total_rate_limit_exceeded" {
+		t.Fatalf("total limit: status %d code %q, want 503 total_rate_limit_exceeded", w.Code, rejectCode(t, w))
+	}
+	if got := w.Header().Get("Retry-After"); got != "300" {
+		t.Fatalf("total limit Retry-After = %q, want 300", got)
+	}
+
+	// 成功请求数限流
+	setting.TokenRateLimitCount = 10
+	setting.TokenRateLimitSuccessCount = 1
+	serveModelRequest(1152, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	w = serveModelRequest(1152, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	if w.Code != http.StatusTooManyRequests || rejectCode(t, w) != "success_rate_limit_exceeded" {
+		t.Fatalf("success limit: status %d code %q, want 429 success_rate_limit_exceeded", w.Code, rejectCode(t, w))
+	}
+	if got := w.Header().Get("Retry-After"); got == "" || got == "300" {
+		t.Fatalf("success limit Retry-After = %q, want the window length", got)
+	}
+}
+
+func TestKindRejectStatusCodeFallsBack(t *testing.T) {
+	setting.RateLimitRejectStatusCode = http.StatusServiceUnavailable
+	t.Cleanup(func() { setting.RateLimitRejectStatusCode = http.StatusTooManyRequests })
+	for _, reject := range []string{rateLimitRejectTotal, rateLimitRejectSuccess, ""} {
+		if got := rateLimitRejectStatusCode(reject); got != http.StatusServiceUnavailable {
+			t.Errorf("rateLimitRejectStatusCode(%q) = %d, want 503", reject, got)
+		}
+	}
+	if got := rateLimitRejectRetryAfter(rateLimitRejectSuccess, 5); got != 5 {
+		t.Errorf("success Retry-After = %d, want 5", got)
+	}
+}
+
+func TestTokenPerIPRateLimit(t *testing.T) {
+	setupMemoryRateLimit(t, 5)
+	setting.TokenPerIPRateLimit = 2
+	t.Cleanup(func() { setting.TokenPerIPRateLimit = 0 })
+	fromIP := func(ip string) map[string]string { return map[string]string{"X-Forwarded-For": ip} }
+
+	// 每个 IP 最多使用 2 次
+	for i := 0; i < 2; i++ {
+		if w := serveModelRequest(1311, `{"model":"gpt-4o"}`, http.StatusOK, fromIP("10.0.0.1")); w.Code != http.StatusOK {
+			t.Fatalf("ip 1 request %d: status %d", i+1, w.Code)
+		}
+	}
+	w := serveModelRequest(1311, `{"model":"gpt-4o"}`, http.StatusOK, fromIP("10.0.0.1"))
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "当前 IP") {
+		t.Fatalf("ip 1 over its share: status %d, body %s", w.Code, w.Body.String())
+	}
+	for i := 0; i < 2; i++ {
+		if w = serveModelRequest(1311, `{"model":"gpt-4o"}`, http.StatusOK, fromIP("10.0.0.2")); w.Code != http.StatusOK {
+			t.Fatalf("ip 2 request %d: status %d", i+1, w.Code)
+		}
+	}
+
+	// 密钥整体的 5 次已用完，新的 IP 也被拒绝
+	w = serveModelRequest(1311, `{"model":"gpt-4o"}`, http.StatusOK, fromIP("10.0.0.3"))
+	if w.Code != http.StatusTooManyRequests || strings.Contains(w.Body.String(), "当前 IP") {
+		t.Fatalf("token total exhausted: status %d, body %s", w.Code, w.Body.String())
+	}
+
+	// 其它密钥不受影响
+	if w = serveModelRequest(1312, `{"model":"gpt-4o"}`, http.StatusOK, fromIP("10.0.0.1")); w.Code != http.StatusOK {
+		t.Fatalf("other token: status %d", w.Code)
+	}
+}
+
+// serveRequestWithoutGroup 发送一次鉴权时未写入令牌分组与用户分组的请求
+func serveRequestWithoutGroup(tokenId int) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyTokenId, tokenId)
+		c.Next()
+	}, ModelRequestRateLimit(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`)))
+	return w
+}
+
+func TestStrictGroupDetectsMissingGroup(t *testing.T) {
+	setupMemoryRateLimit(t, 100)
+	t.Cleanup(func() { setting.RateLimitStrictGroup = setting.RateLimitStrictGroupOff })
+
+	// 默认不检查
+	before := GetRateLimitStats().MissingGroupTotal
+	if w := serveRequestWithoutGroup(1331); w.Code != http.StatusOK {
+		t.Fatalf("strict mode off: status %d", w.Code)
+	}
+	if got := GetRateLimitStats().MissingGroupTotal; got != before {
+		t.Fatalf("missing group counted with strict mode off: %d -> %d", before, got)
+	}
+
+	setting.RateLimitStrictGroup = setting.RateLimitStrictGroupLog
+	if w := serveRequestWithoutGroup(1331); w.Code != http.StatusOK {
+		t.Fatalf("log mode: status %d", w.Code)
+	}
+	if got := GetRateLimitStats().MissingGroupTotal; got != before+1 {
+		t.Fatalf("log mode: missing group total = %d, want %d", got, before+1)
+	}
+	// 设置了分组的请求不计入
+	if w := serveModelRequest(1331, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("request with groups: status %d", w.Code)
+	}
+	if got := GetRateLimitStats().MissingGroupTotal; got != before+1 {
+		t.Fatalf("request with groups counted as missing: %d", got)
+	}
+
+	setting.RateLimitStrictGroup = setting.RateLimitStrictGroupReject
+	w := serveRequestWithoutGroup(1331)
+	if w.Code != http.StatusInternalServerError || !strings.Contains(w.Body.String(), "rate_limit_group_missing") {
+		t.Fatalf("reject mode: status %d, body %s", w.Code, w.Body.String())
+	}
+	if got := GetRateLimitStats().MissingGroupTotal; got != before+2 {
+		t.Fatalf("reject mode: missing group total = %d, want %d", got, before+2)
+	}
+}
+
+// rateLimitedResponse 在 ErrorFormat 为 format 时触发一次限流拒绝
+func rateLimitedResponse(t *testing.T, tokenId int, format string) *httptest.ResponseRecorder {
+	t.Helper()
+	setupMemoryRateLimit(t, 1)
+	setting.ErrorFormat = format
+	t.Cleanup(func() { setting.ErrorFormat = setting.ErrorFormatOpenAI })
+	serveModelRequest(tokenId, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	w := serveModelRequest(tokenId, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", w.Code)
+	}
+	return w
+}
+
+func TestRateLimitErrorFormatOpenAI(t *testing.T) {
+	w := rateLimitedResponse(t, 1351, setting.ErrorFormatOpenAI)
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+			Code    string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Error.Message == "" || body.Error.Code != "total_rate_limit_exceeded" {
+		t.Fatalf("openai body = %s", w.Body.String())
+	}
+}
+
+func TestRateLimitErrorFormatAnthropic(t *testing.T) {
+	w := rateLimitedResponse(t, 1352, setting.ErrorFormatAnthropic)
+	var body struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Type != "error" || body.Error.Type == "" || body.Error.Message == "" {
+		t.Fatalf("anthropic body = %s", w.Body.String())
+	}
+}
+
+func TestRateLimitErrorFormatProblemJSON(t *testing.T) {
+	w := rateLimitedResponse(t, 1353, setting.ErrorFormatProblemJSON)
+	if got := w.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Fatalf("Content-Type = %q, want application/problem+json", got)
+	}
+	var body map[string]any
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body["type"] != "urn:new-api:error:total_rate_limit_exceeded" || body["title"] != "Too Many Requests" || body["status"] != float64(http.StatusTooManyRequests) {
+		t.Fatalf("problem+json body = %s", w.Body.String())
+	}
+	if detail, _ := body["detail"].(string); detail == "" {
+		t.Fatalf("problem+json detail missing: %s", w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("missing Retry-After")
+	}
+}
+
+// serveMethodRequest 以 HTTP 方法 method 发送一次经过 ModelRequestRateLimit 的请求
+func serveMethodRequest(tokenId int, method string) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.Handle(method, "/v1/chat/completions", func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyTokenId, tokenId)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "default")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		c.Next()
+	}, ModelRequestRateLimit(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`)))
+	return w
+}
+
+func TestOnlyCountMethodsConsumeRateLimit(t *testing.T) {
+	setupMemoryRateLimit(t, 2)
+
+	for _, method := range []string{http.MethodOptions, http.MethodHead, http.MethodGet, http.MethodOptions} {
+		if w := serveMethodRequest(1411, method); w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", method, w.Code)
+		}
+	}
+	if got := tokenTotalCount(1411); got != 0 {
+		t.Fatalf("non-counted methods consumed %d requests, want 0", got)
+	}
+	if w := serveMethodRequest(1411, http.MethodPost); w.Code != http.StatusOK {
+		t.Fatalf("POST: status %d", w.Code)
+	}
+	if got := tokenTotalCount(1411); got != 1 {
+		t.Fatalf("POST consumed %d requests, want 1", got)
+	}
+}
+
+func TestRateLimitCountMethodsConfigurable(t *testing.T) {
+	setupMemoryRateLimit(t, 1)
+	setting.RateLimitCountMethodsFromString("post, get")
+	t.Cleanup(func() { setting.RateLimitCountMethodsFromString(http.MethodPost) })
+
+	if w := serveMethodRequest(1412, http.MethodGet); w.Code != http.StatusOK {
+		t.Fatalf("first GET: status %d", w.Code)
+	}
+	if w := serveMethodRequest(1412, http.MethodGet); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second GET: status %d, want 429 once GET counts", w.Code)
+	}
+	if w := serveMethodRequest(1412, http.MethodOptions); w.Code != http.StatusOK {
+		t.Fatalf("OPTIONS: status %d, want it never limited", w.Code)
+	}
+}
+
+func TestRedispatchedRequestCountsOnce(t *testing.T) {
+	setupMemoryRateLimit(t, 1)
+
+	// 同一请求两次经过限流中间件，模拟请求被重新分发
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyTokenId, 1521)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "default")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		c.Next()
+	}, ModelRequestRateLimit(), ModelRequestRateLimit(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d, want the second pass not to consume the only slot", w.Code)
+	}
+	if got := tokenTotalCount(1521); got != 1 {
+		t.Fatalf("one user request consumed %d units, want 1", got)
+	}
+}
+
+// seedRateLimitList 写入 recent 条当前时间的记录，表尾再追加 stale 条已滑出窗口的记录
+func seedRateLimitList(f *fakeRedis, key string, recent, stale int) {
+	now := time.Now().Format(timeFormat)
+	old := time.Now().Add(-time.Hour).Format(timeFormat)
+	list := make([]string, 0, recent+stale)
+	for i := 0; i < recent; i++ {
+		list = append(list, now)
+	}
+	for i := 0; i < stale; i++ {
+		list = append(list, old)
+	}
+	f.mu.Lock()
+	f.lists[key] = list
+	f.mu.Unlock()
+}
+
+func TestOversizedRateLimitListRepaired(t *testing.T) {
+	f, rdb := startFakeRedis(t)
+	ctx := context.Background()
+	repaired := GetRateLimitStats().RepairedLists
+
+	// 最近 5 条已达上限，但表尾残留大量过期记录，不修复时会按表尾错误地放行
+	seedRateLimitList(f, "rateLimit:oversized", 5, 45)
+	allowed, err := checkRedisRateLimit(ctx, rdb, "rateLimit:oversized", 5, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if allowed {
+		t.Fatal("request allowed by stale entries of an oversized list")
+	}
+	f.mu.Lock()
+	length := len(f.lists["rateLimit:oversized"])
+	f.mu.Unlock()
+	if length != 5 {
+		t.Fatalf("list length after repair = %d, want 5", length)
+	}
+	if got := GetRateLimitStats().RepairedLists - repaired; got != 1 {
+		t.Fatalf("repaired lists increased by %d, want 1", got)
+	}
+
+	// 未超过上限的 rateLimitListRepairFactor 倍时不处理
+	seedRateLimitList(f, "rateLimit:normal", 5, 5)
+	if _, err = checkRedisRateLimit(ctx, rdb, "rateLimit:normal", 5, 60); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	length = len(f.lists["rateLimit:normal"])
+	f.mu.Unlock()
+	if length != 10 {
+		t.Fatalf("list within the repair factor trimmed to %d, want 10 kept", length)
+	}
+	if got := GetRateLimitStats().RepairedLists - repaired; got != 1 {
+		t.Fatalf("repaired lists increased by %d, want 1", got)
+	}
+}
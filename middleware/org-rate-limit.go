@@ -0,0 +1,155 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Org rate limit constants
+const (
+	OrgRateLimitCountMark        = "ORL"
+	OrgRateLimitSuccessCountMark = "ORLS"
+)
+
+// checkOrgRateLimit 检查 per-org 限流，组织 ID 取自令牌所属的组织，同一组织的所有令牌共享额度
+func checkOrgRateLimit(c *gin.Context) bool {
+	if !setting.OrgRateLimitEnabled {
+		return true
+	}
+
+	orgId := common.GetContextKeyInt(c, constant.ContextKeyTokenOrgId)
+	if orgId == 0 {
+		// 令牌不属于任何组织，跳过 per-org 限流
+		return true
+	}
+
+	totalMaxCount := setting.OrgRateLimitCount
+	successMaxCount := setting.OrgRateLimitSuccessCount
+
+	// 如果两个限制都为0，表示不限制
+	if totalMaxCount == 0 && successMaxCount == 0 {
+		return true
+	}
+
+	rateLimitKey := strconv.Itoa(orgId)
+	duration := int64(setting.OrgRateLimitDurationMinutes * 60)
+
+	if common.RedisEnabled() {
+		return checkOrgRateLimitRedis(c, rateLimitKey, totalMaxCount, successMaxCount, duration)
+	}
+	return checkOrgRateLimitMemory(c, rateLimitKey, totalMaxCount, successMaxCount, duration)
+}
+
+// checkOrgRateLimitRedis Redis版本的 per-org 限流检查
+func checkOrgRateLimitRedis(c *gin.Context, rateLimitKey string, totalMaxCount, successMaxCount int, duration int64) bool {
+	ctx := context.Background()
+	rdb := common.RDB()
+
+	// 1. 检查成功请求数限制
+	if successMaxCount > 0 {
+		successKey := fmt.Sprintf("rateLimit:%s:%s", OrgRateLimitSuccessCountMark, rateLimitKey)
+		spanCtx, span := startRateLimitSpan(c, "org_success", successKey)
+		allowed, err := checkRedisSuccessLimit(spanCtx, rdb, successLimiterAlgorithm(c), successKey, successMaxCount, duration)
+		endRateLimitSpan(span, "org_success", successMaxCount, allowed, err)
+		if err != nil {
+			fmt.Println("检查组织成功请求数限制失败:", err.Error())
+			if !rateLimitFailOpen(err) {
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return false
+			}
+			allowed = true
+		}
+		if !allowed {
+			abortWithRateLimitMessage(c, rateLimitRejectSuccess, duration, fmt.Sprintf("您所在的组织已达到请求数限制：%d分钟内最多请求%d次", setting.OrgRateLimitDurationMinutes, successMaxCount))
+			return false
+		}
+	}
+
+	// 2. 检查总请求数限制
+	if totalMaxCount > 0 {
+		totalKey := fmt.Sprintf("rateLimit:%s:%s", OrgRateLimitCountMark, rateLimitKey)
+		tb := limiter.New(ctx, rdb)
+		spanCtx, span := startRateLimitSpan(c, "org_total", totalKey)
+		allowed, wait, err := reserveWithBlocking(spanCtx, c, tb,
+			totalKey,
+			limiter.WithCapacity(int64(totalMaxCount)*duration),
+			limiter.WithRate(int64(totalMaxCount)),
+			limiter.WithRequested(duration),
+		)
+		endRateLimitSpan(span, "org_total", totalMaxCount, allowed, err)
+
+		if err != nil {
+			fmt.Println("检查组织总请求数限制失败:", err.Error())
+			if !rateLimitFailOpen(err) {
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return false
+			}
+			allowed = true
+		}
+		evaluateShadowRateLimit(c, totalKey, totalMaxCount, duration, allowed)
+
+		if !allowed {
+			abortWithRateLimitMessage(c, rateLimitRejectTotal, retryAfterFromWait(wait, duration), fmt.Sprintf("您所在的组织已达到总请求数限制：%d分钟内最多请求%d次（包括失败请求）", setting.OrgRateLimitDurationMinutes, totalMaxCount))
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkOrgRateLimitMemory 内存版本的 per-org 限流检查
+func checkOrgRateLimitMemory(c *gin.Context, rateLimitKey string, totalMaxCount, successMaxCount int, duration int64) bool {
+	inMemoryRateLimiter.Init(time.Duration(setting.OrgRateLimitDurationMinutes) * time.Minute)
+
+	totalKey := OrgRateLimitCountMark + rateLimitKey
+	successKey := OrgRateLimitSuccessCountMark + rateLimitKey
+
+	// 1. 检查总请求数限制
+	if totalMaxCount > 0 && !memoryReserve(c, totalKey, totalMaxCount, duration) {
+		abortWithRateLimitMessage(c, rateLimitRejectTotal, duration, fmt.Sprintf("您所在的组织已达到总请求数限制：%d分钟内最多请求%d次（包括失败请求）", setting.OrgRateLimitDurationMinutes, totalMaxCount))
+		return false
+	}
+
+	// 2. 检查成功请求数限制（使用临时key检查）
+	if successMaxCount > 0 {
+		if !checkMemorySuccessLimit(successLimiterAlgorithm(c), successKey, successMaxCount, duration) {
+			abortWithRateLimitMessage(c, rateLimitRejectSuccess, duration, fmt.Sprintf("您所在的组织已达到请求数限制：%d分钟内最多请求%d次", setting.OrgRateLimitDurationMinutes, successMaxCount))
+			return false
+		}
+	}
+
+	return true
+}
+
+// recordOrgRateLimitSuccess 记录 per-org 成功请求
+func recordOrgRateLimitSuccess(c *gin.Context) {
+	if !setting.OrgRateLimitEnabled || setting.OrgRateLimitSuccessCount == 0 {
+		return
+	}
+
+	orgId := common.GetContextKeyInt(c, constant.ContextKeyTokenOrgId)
+	if orgId == 0 {
+		return
+	}
+
+	successMaxCount := setting.OrgRateLimitSuccessCount
+	rateLimitKey := strconv.Itoa(orgId)
+	duration := int64(setting.OrgRateLimitDurationMinutes * 60)
+
+	if common.RedisEnabled() {
+		successKey := fmt.Sprintf("rateLimit:%s:%s", OrgRateLimitSuccessCountMark, rateLimitKey)
+		recordRedisSuccess(context.Background(), common.RDB(), successLimiterAlgorithm(c), successKey, successMaxCount, duration)
+	} else {
+		recordMemorySuccess(successLimiterAlgorithm(c), OrgRateLimitSuccessCountMark+rateLimitKey, successMaxCount, duration)
+	}
+}
@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupOrgRateLimit 使用内存限流，只开启按组织的限制
+func setupOrgRateLimit(t *testing.T, totalCount, successCount int) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	common.SetRedisEnabled(false)
+	constant.MaxRequestBodyMB = 8
+	inMemoryRateLimiter.Init(time.Minute)
+	setting.OrgRateLimitEnabled = true
+	setting.OrgRateLimitCount = totalCount
+	setting.OrgRateLimitSuccessCount = successCount
+	t.Cleanup(func() {
+		setting.OrgRateLimitEnabled = false
+		setting.OrgRateLimitCount = 0
+		setting.OrgRateLimitSuccessCount = 0
+	})
+}
+
+// serveOrgModelRequest 以组织 orgId 下用户 userId 的令牌 tokenId 发送一次请求
+func serveOrgModelRequest(userId, tokenId, orgId int, status int) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Set("id", userId)
+		common.SetContextKey(c, constant.ContextKeyTokenId, tokenId)
+		common.SetContextKey(c, constant.ContextKeyTokenOrgId, orgId)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		c.Next()
+	}, ModelRequestRateLimit(), func(c *gin.Context) {
+		c.Status(status)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestOrgLimitSharedAcrossUsers(t *testing.T) {
+	setupOrgRateLimit(t, 3, 0)
+
+	// 同一组织的三个用户共享 3 次额度
+	for i, userId := range []int{13701, 13702, 13703} {
+		if w := serveOrgModelRequest(userId, userId*10, 1370, http.StatusOK); w.Code != http.StatusOK {
+			t.Fatalf("request %d from user %d: status %d", i, userId, w.Code)
+		}
+	}
+	w := serveOrgModelRequest(13704, 137040, 1370, http.StatusOK)
+	if w.Code != http.StatusTooManyRequests || !strings.Contains(w.Body.String(), "组织") {
+		t.Fatalf("fourth user in the org: status %d, body %s", w.Code, w.Body.String())
+	}
+
+	// 其它组织及不属于组织的令牌不受影响
+	if w = serveOrgModelRequest(13705, 137050, 1371, http.StatusOK); w.Code != http.StatusOK {
+		t.Fatalf("other org: status %d", w.Code)
+	}
+	for i := 0; i < 5; i++ {
+		if w = serveOrgModelRequest(13706, 137060, 0, http.StatusOK); w.Code != http.StatusOK {
+			t.Fatalf("token without org request %d: status %d", i, w.Code)
+		}
+	}
+}
+
+func TestOrgSuccessLimitSharedAcrossUsers(t *testing.T) {
+	setupOrgRateLimit(t, 0, 2)
+
+	// 失败请求不计入成功请求数
+	if w := serveOrgModelRequest(13711, 137110, 1372, http.StatusBadGateway); w.Code != http.StatusBadGateway {
+		t.Fatalf("failed request: status %d", w.Code)
+	}
+	for i, userId := range []int{13711, 13712} {
+		if w := serveOrgModelRequest(userId, userId*10, 1372, http.StatusOK); w.Code != http.StatusOK {
+			t.Fatalf("success %d from user %d: status %d", i, userId, w.Code)
+		}
+	}
+	if w := serveOrgModelRequest(13713, 137130, 1372, http.StatusOK); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("third user after the org success limit: status %d, want 429", w.Code)
+	}
+}
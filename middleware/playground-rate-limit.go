@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Playground rate limit constants
+const (
+	PlaygroundRateLimitCountMark        = "PGRL"
+	PlaygroundRateLimitSuccessCountMark = "PGRLS"
+)
+
+// PlaygroundRateLimit 操练场（网页登录会话发起、使用临时令牌）的 per-user 限流，与 API 令牌的限流相互独立，
+// 通常配置得更严格，避免操练场占满共享的容量。请求会被标记为操练场流量
+func PlaygroundRateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyPlayground, true)
+		if !setting.PlaygroundRateLimitEnabled {
+			c.Next()
+			return
+		}
+
+		userId := c.GetInt("id")
+		totalMaxCount := setting.PlaygroundRateLimitCount
+		successMaxCount := setting.PlaygroundRateLimitSuccessCount
+		if userId == 0 || (totalMaxCount == 0 && successMaxCount == 0) {
+			c.Next()
+			return
+		}
+
+		rateLimitKey := strconv.Itoa(userId)
+		duration := int64(setting.PlaygroundRateLimitDurationMinutes * 60)
+
+		var allowed bool
+		if common.RedisEnabled() {
+			allowed = checkPlaygroundRateLimitRedis(c, rateLimitKey, totalMaxCount, successMaxCount, duration)
+		} else {
+			allowed = checkPlaygroundRateLimitMemory(c, rateLimitKey, totalMaxCount, successMaxCount, duration)
+		}
+		if !allowed {
+			return
+		}
+
+		c.Next()
+
+		if successMaxCount > 0 && isRateLimitSuccess(c) {
+			if common.RedisEnabled() {
+				successKey := fmt.Sprintf("rateLimit:%s:%s", PlaygroundRateLimitSuccessCountMark, rateLimitKey)
+				recordRedisSuccess(context.Background(), common.RDB(), setting.SuccessLimiterAlgorithm, successKey, successMaxCount, duration)
+			} else {
+				recordMemorySuccess(setting.SuccessLimiterAlgorithm, PlaygroundRateLimitSuccessCountMark+rateLimitKey, successMaxCount, duration)
+			}
+		}
+	}
+}
+
+// checkPlaygroundRateLimitRedis Redis版本的操练场限流检查
+func checkPlaygroundRateLimitRedis(c *gin.Context, rateLimitKey string, totalMaxCount, successMaxCount int, duration int64) bool {
+	ctx := context.Background()
+	rdb := common.RDB()
+
+	// 1. 检查成功请求数限制
+	if successMaxCount > 0 {
+		successKey := fmt.Sprintf("rateLimit:%s:%s", PlaygroundRateLimitSuccessCountMark, rateLimitKey)
+		spanCtx, span := startRateLimitSpan(c, "playground_success", successKey)
+		allowed, err := checkRedisSuccessLimit(spanCtx, rdb, setting.SuccessLimiterAlgorithm, successKey, successMaxCount, duration)
+		endRateLimitSpan(span, "playground_success", successMaxCount, allowed, err)
+		if err != nil {
+			fmt.Println("检查操练场成功请求数限制失败:", err.Error())
+			if !rateLimitFailOpen(err) {
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return false
+			}
+			allowed = true
+		}
+		if !allowed {
+			abortWithRateLimitMessage(c, rateLimitRejectSuccess, duration, fmt.Sprintf("操练场请求过于频繁：%d分钟内最多请求%d次", setting.PlaygroundRateLimitDurationMinutes, successMaxCount))
+			return false
+		}
+	}
+
+	// 2. 检查总请求数限制
+	if totalMaxCount > 0 {
+		totalKey := fmt.Sprintf("rateLimit:%s:%s", PlaygroundRateLimitCountMark, rateLimitKey)
+		spanCtx, span := startRateLimitSpan(c, "playground_total", totalKey)
+		allowed, wait, err := limiter.New(ctx, rdb).Reserve(spanCtx,
+			totalKey,
+			limiter.WithCapacity(int64(totalMaxCount)*duration),
+			limiter.WithRate(int64(totalMaxCount)),
+			limiter.WithRequested(duration),
+		)
+		endRateLimitSpan(span, "playground_total", totalMaxCount, allowed, err)
+		if err != nil {
+			fmt.Println("检查操练场总请求数限制失败:", err.Error())
+			if !rateLimitFailOpen(err) {
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return false
+			}
+			allowed = true
+		}
+		if !allowed {
+			abortWithRateLimitMessage(c, rateLimitRejectTotal, retryAfterFromWait(wait, duration), fmt.Sprintf("操练场请求过于频繁：%d分钟内最多请求%d次（包括失败请求）", setting.PlaygroundRateLimitDurationMinutes, totalMaxCount))
+			return false
+		}
+	}
+
+	return true
+}
+
+// checkPlaygroundRateLimitMemory 内存版本的操练场限流检查
+func checkPlaygroundRateLimitMemory(c *gin.Context, rateLimitKey string, totalMaxCount, successMaxCount int, duration int64) bool {
+	inMemoryRateLimiter.Init(time.Duration(setting.PlaygroundRateLimitDurationMinutes) * time.Minute)
+
+	// 1. 检查总请求数限制
+	if totalMaxCount > 0 && !inMemoryRateLimiter.Request(PlaygroundRateLimitCountMark+rateLimitKey, totalMaxCount, duration) {
+		abortWithRateLimitMessage(c, rateLimitRejectTotal, duration, fmt.Sprintf("操练场请求过于频繁：%d分钟内最多请求%d次（包括失败请求）", setting.PlaygroundRateLimitDurationMinutes, totalMaxCount))
+		return false
+	}
+
+	// 2. 检查成功请求数限制
+	if !checkMemorySuccessLimit(setting.SuccessLimiterAlgorithm, PlaygroundRateLimitSuccessCountMark+rateLimitKey, successMaxCount, duration) {
+		abortWithRateLimitMessage(c, rateLimitRejectSuccess, duration, fmt.Sprintf("操练场请求过于频繁：%d分钟内最多请求%d次", setting.PlaygroundRateLimitDurationMinutes, successMaxCount))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupPlaygroundRateLimit 使用内存限流，开启操练场限流
+func setupPlaygroundRateLimit(t *testing.T, totalCount, successCount int) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	common.SetRedisEnabled(false)
+	constant.MaxRequestBodyMB = 8
+	oldCount, oldSuccessCount := setting.PlaygroundRateLimitCount, setting.PlaygroundRateLimitSuccessCount
+	setting.PlaygroundRateLimitEnabled = true
+	setting.PlaygroundRateLimitCount = totalCount
+	setting.PlaygroundRateLimitSuccessCount = successCount
+	t.Cleanup(func() {
+		setting.PlaygroundRateLimitEnabled = false
+		setting.PlaygroundRateLimitCount, setting.PlaygroundRateLimitSuccessCount = oldCount, oldSuccessCount
+	})
+}
+
+// servePlaygroundRequest 以用户 userId 发送一次操练场请求，返回响应及请求是否被标记为操练场流量
+func servePlaygroundRequest(userId int, status int) (*httptest.ResponseRecorder, bool) {
+	playground := false
+	r := gin.New()
+	r.POST("/pg/chat/completions", func(c *gin.Context) {
+		c.Set("id", userId)
+		c.Next()
+	}, PlaygroundRateLimit(), func(c *gin.Context) {
+		playground = common.GetContextKeyBool(c, constant.ContextKeyPlayground)
+		c.Status(status)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/pg/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w, playground
+}
+
+func TestPlaygroundTrafficTagged(t *testing.T) {
+	setupPlaygroundRateLimit(t, 0, 0)
+	setting.PlaygroundRateLimitEnabled = false
+
+	if _, playground := servePlaygroundRequest(1641, http.StatusOK); !playground {
+		t.Fatal("playground request not tagged")
+	}
+	// API 请求不经过操练场限流，不带标记
+	r := gin.New()
+	tagged := true
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		tagged = common.GetContextKeyBool(c, constant.ContextKeyPlayground)
+	})
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+	if tagged {
+		t.Fatal("API request tagged as playground traffic")
+	}
+}
+
+func TestPlaygroundTotalLimit(t *testing.T) {
+	setupPlaygroundRateLimit(t, 2, 0)
+
+	for i := 0; i < 2; i++ {
+		if w, _ := servePlaygroundRequest(1642, http.StatusInternalServerError); w.Code != http.StatusInternalServerError {
+			t.Fatalf("request %d: status %d", i+1, w.Code)
+		}
+	}
+	if w, _ := servePlaygroundRequest(1642, http.StatusOK); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("3rd request: status %d, want 429", w.Code)
+	}
+	// 限流按用户计数
+	if w, _ := servePlaygroundRequest(1643, http.StatusOK); w.Code != http.StatusOK {
+		t.Fatalf("another user: status %d", w.Code)
+	}
+}
+
+func TestPlaygroundSuccessLimit(t *testing.T) {
+	setupPlaygroundRateLimit(t, 0, 2)
+
+	for i := 0; i < 3; i++ {
+		if w, _ := servePlaygroundRequest(1644, http.StatusBadGateway); w.Code != http.StatusBadGateway {
+			t.Fatalf("failed request %d: status %d, want failures not to count", i+1, w.Code)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if w, _ := servePlaygroundRequest(1644, http.StatusOK); w.Code != http.StatusOK {
+			t.Fatalf("success %d: status %d", i+1, w.Code)
+		}
+	}
+	if w, _ := servePlaygroundRequest(1644, http.StatusOK); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("3rd success: status %d, want 429", w.Code)
+	}
+}
+
+func TestPlaygroundLimitSeparateFromAPILimits(t *testing.T) {
+	setupPlaygroundRateLimit(t, 1, 0)
+	setupUserDailyRateLimit(t, 2, 0)
+
+	// 操练场名额用完不影响同一用户的 API 请求
+	if w, _ := servePlaygroundRequest(1645, http.StatusOK); w.Code != http.StatusOK {
+		t.Fatalf("playground request: status %d", w.Code)
+	}
+	if w, _ := servePlaygroundRequest(1645, http.StatusOK); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("2nd playground request: status %d, want 429", w.Code)
+	}
+	for i := 0; i < 2; i++ {
+		if w := serveUserModelRequest(1645, 16451, "default", http.StatusOK); w.Code != http.StatusOK {
+			t.Fatalf("API request %d after the playground limit: status %d", i+1, w.Code)
+		}
+	}
+
+	// API 限流用完不影响操练场
+	if w := serveUserModelRequest(1646, 16461, "default", http.StatusOK); w.Code != http.StatusOK {
+		t.Fatalf("API request: status %d", w.Code)
+	}
+	serveUserModelRequest(1646, 16461, "default", http.StatusOK)
+	if w := serveUserModelRequest(1646, 16461, "default", http.StatusOK); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("3rd API request: status %d, want 429", w.Code)
+	}
+	if w, _ := servePlaygroundRequest(1646, http.StatusOK); w.Code != http.StatusOK {
+		t.Fatalf("playground request after the API limit: status %d", w.Code)
+	}
+}
+
+func TestPlaygroundRateLimitDisabled(t *testing.T) {
+	setupPlaygroundRateLimit(t, 1, 1)
+	setting.PlaygroundRateLimitEnabled = false
+	for i := 0; i < 3; i++ {
+		if w, _ := servePlaygroundRequest(1647, http.StatusOK); w.Code != http.StatusOK {
+			t.Fatalf("request %d with the playground limit disabled: status %d", i+1, w.Code)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"strconv"
+
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 全局 API 限流使用的标识，只有该限流桶的使用率会通过背压响应头告知客户端
+const globalAPIRateLimitMark = "GA"
+
+// backpressureHeader 建议客户端在发送下一个请求前等待的毫秒数
+const backpressureHeader = "X-NewAPI-Backpressure"
+
+// backpressureDelayMs 根据限流桶使用率（0~1）计算建议等待的毫秒数，未达到阈值或未开启时返回 0。
+// 使用率从阈值到 100% 时，建议等待时间从 0 线性增长到 RateLimitBackpressureMaxDelayMs
+func backpressureDelayMs(utilization float64) int {
+	threshold := float64(setting.RateLimitBackpressureThresholdPercent) / 100
+	if threshold <= 0 || utilization < threshold {
+		return 0
+	}
+	if utilization > 1 {
+		utilization = 1
+	}
+	delay := int(float64(setting.RateLimitBackpressureMaxDelayMs) * (utilization - threshold) / (1 - threshold))
+	if delay < 1 {
+		delay = 1
+	}
+	return delay
+}
+
+// setBackpressureHeader 请求通过全局限流后，按当前使用率设置背压响应头，
+// 让客户端在触发硬性 429 之前主动降速
+func setBackpressureHeader(c *gin.Context, mark string, utilization float64) {
+	if mark != globalAPIRateLimitMark {
+		return
+	}
+	if delay := backpressureDelayMs(utilization); delay > 0 {
+		c.Header(backpressureHeader, strconv.Itoa(delay))
+	}
+}
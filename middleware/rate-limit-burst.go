@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	TokenBurstRateLimitMark     = "TBRL"
+	TokenSustainedRateLimitMark = "TSRL"
+)
+
+// 两个令牌桶中每次请求消耗的令牌数，速率按每分钟请求数配置，令牌按每秒补充
+const burstRequestTokens = 60
+
+var burstMemoryBuckets = limiter.NewMemoryTokenBucket()
+
+// burstRateLimitBuckets 返回两段式限流的两个令牌桶：
+// 额度桶容量为突发阶段可发出的请求数，按持续速率补充，用完后请求速率降为持续速率；
+// 速率桶容量为 1 秒的请求量，按突发速率补充，限制突发阶段的最快速率。请求需同时通过两个桶
+func burstRateLimitBuckets() (budget []limiter.Option, rate []limiter.Option) {
+	budget = []limiter.Option{
+		limiter.WithCapacity(int64(max(setting.TokenBurstRateLimitCount*setting.TokenBurstDurationSeconds, burstRequestTokens))),
+		limiter.WithRate(int64(setting.TokenSustainedRateLimitCount)),
+		limiter.WithRequested(burstRequestTokens),
+	}
+	rate = []limiter.Option{
+		limiter.WithCapacity(int64(max(setting.TokenBurstRateLimitCount, burstRequestTokens))),
+		limiter.WithRate(int64(setting.TokenBurstRateLimitCount)),
+		limiter.WithRequested(burstRequestTokens),
+	}
+	return budget, rate
+}
+
+func burstRateLimitMessage() string {
+	return fmt.Sprintf("您已达到密钥请求速率限制：突发阶段每分钟最多请求%d次，之后每分钟最多请求%d次（包括失败请求）",
+		setting.TokenBurstRateLimitCount, setting.TokenSustainedRateLimitCount)
+}
+
+// checkTokenBurstRateLimit 检查 per-key 两段式（突发后持续）限流，先检查额度桶，速率桶拒绝时退还额度桶已消耗的令牌
+func checkTokenBurstRateLimit(c *gin.Context) bool {
+	if setting.TokenBurstRateLimitCount <= 0 || setting.TokenSustainedRateLimitCount <= 0 || isTotalCountForgiven(c) {
+		return true
+	}
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	if tokenId == 0 {
+		return true
+	}
+	rateLimitKey := strconv.Itoa(tokenId)
+	budget, rate := burstRateLimitBuckets()
+
+	if !common.RedisEnabled() {
+		budgetKey := TokenSustainedRateLimitMark + rateLimitKey
+		allowed, wait := burstMemoryBuckets.Reserve(budgetKey, budget...)
+		if allowed {
+			allowed, wait = burstMemoryBuckets.Reserve(TokenBurstRateLimitMark+rateLimitKey, rate...)
+			if !allowed {
+				burstMemoryBuckets.Refund(budgetKey, budget...)
+			}
+		}
+		if !allowed {
+			abortWithRateLimitMessage(c, rateLimitRejectTotal, retryAfterFromWait(wait, 60), burstRateLimitMessage())
+			return false
+		}
+		return true
+	}
+
+	ctx := context.Background()
+	tb := limiter.New(ctx, common.RDB())
+	budgetKey := fmt.Sprintf("rateLimit:%s:%s", TokenSustainedRateLimitMark, rateLimitKey)
+	rateKey := fmt.Sprintf("rateLimit:%s:%s", TokenBurstRateLimitMark, rateLimitKey)
+
+	spanCtx, span := startRateLimitSpan(c, "token_sustained", budgetKey)
+	allowed, wait, err := tb.Reserve(spanCtx, budgetKey, budget...)
+	endRateLimitSpan(span, "token_sustained", setting.TokenSustainedRateLimitCount, allowed, err)
+	if err == nil && allowed {
+		spanCtx, span = startRateLimitSpan(c, "token_burst", rateKey)
+		allowed, wait, err = tb.Reserve(spanCtx, rateKey, rate...)
+		endRateLimitSpan(span, "token_burst", setting.TokenBurstRateLimitCount, allowed, err)
+		// 速率桶拒绝或检查失败时退还额度桶已消耗的令牌
+		if err != nil || !allowed {
+			if refundErr := tb.Refund(ctx, budgetKey, budget...); refundErr != nil {
+				common.SysLog("退还两段式限流额度失败: " + refundErr.Error())
+			}
+		}
+	}
+	if err != nil {
+		fmt.Println("检查两段式限流失败:", err.Error())
+		if !rateLimitFailOpen(err) {
+			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+			return false
+		}
+		return true
+	}
+	if !allowed {
+		abortWithRateLimitMessage(c, rateLimitRejectTotal, retryAfterFromWait(wait, 60), burstRateLimitMessage())
+		return false
+	}
+	return true
+}
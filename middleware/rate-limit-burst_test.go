@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func setupTokenBurstRateLimit(t *testing.T, burst, durationSeconds, sustained int) {
+	t.Helper()
+	setupMemoryRateLimit(t, 1000)
+	oldBurst, oldDuration, oldSustained := setting.TokenBurstRateLimitCount, setting.TokenBurstDurationSeconds, setting.TokenSustainedRateLimitCount
+	setting.TokenBurstRateLimitCount = burst
+	setting.TokenBurstDurationSeconds = durationSeconds
+	setting.TokenSustainedRateLimitCount = sustained
+	t.Cleanup(func() {
+		setting.TokenBurstRateLimitCount = oldBurst
+		setting.TokenBurstDurationSeconds = oldDuration
+		setting.TokenSustainedRateLimitCount = oldSustained
+	})
+}
+
+// waitForNextSecond 等到下一秒开始，令牌桶按秒补充，保证随后的请求落在同一秒内
+func waitForNextSecond() {
+	now := time.Now()
+	time.Sleep(now.Truncate(time.Second).Add(time.Second + 10*time.Millisecond).Sub(now))
+}
+
+func TestTokenBurstThenSustainedThrottle(t *testing.T) {
+	// 突发阶段每分钟 600 次（每秒 10 次）持续 1 秒，即 10 次突发额度；之后每分钟 60 次（每秒 1 次）
+	setupTokenBurstRateLimit(t, 600, 1, 60)
+	waitForNextSecond()
+
+	for i := 1; i <= 10; i++ {
+		if w := serveModelRequest(2001, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("burst request %d: status %d", i, w.Code)
+		}
+	}
+	w := serveModelRequest(2001, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request after burst budget: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("throttled response should carry Retry-After")
+	}
+
+	// 持续阶段每秒只补充一次请求的额度
+	waitForNextSecond()
+	if w := serveModelRequest(2001, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("sustained request: status %d", w.Code)
+	}
+	if w := serveModelRequest(2001, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second sustained request in the same second: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	// 其它令牌不受影响
+	if w := serveModelRequest(2002, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("other token: status %d", w.Code)
+	}
+}
+
+func TestTokenBurstRateCapsBurstPhase(t *testing.T) {
+	// 突发额度充足（每分钟 120 次持续 60 秒），但突发速率限制每秒最多 2 次
+	setupTokenBurstRateLimit(t, 120, 60, 60)
+	waitForNextSecond()
+
+	for i := 1; i <= 2; i++ {
+		if w := serveModelRequest(2003, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+	}
+	if w := serveModelRequest(2003, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over burst rate: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	// 被速率桶拒绝的请求退还额度桶的令牌，下一秒恢复突发速率
+	waitForNextSecond()
+	for i := 1; i <= 2; i++ {
+		if w := serveModelRequest(2003, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("next second request %d: status %d", i, w.Code)
+		}
+	}
+}
+
+func TestTokenBurstRateLimitDisabled(t *testing.T) {
+	setupTokenBurstRateLimit(t, 0, 10, 60)
+	for i := 1; i <= 20; i++ {
+		if w := serveModelRequest(2004, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+	}
+}
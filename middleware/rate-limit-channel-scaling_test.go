@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupActiveChannels 使用内存 SQLite 保存 n 个已启用的渠道并统计已启用渠道数
+func setupActiveChannels(t *testing.T, n int) []*model.Channel {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", name)), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err = db.AutoMigrate(&model.Channel{}); err != nil {
+		t.Fatal(err)
+	}
+	channels := make([]*model.Channel, 0, n)
+	for i := 0; i < n; i++ {
+		channel := &model.Channel{Name: fmt.Sprintf("scaling-%d", i), Key: "sk-test", Status: common.ChannelStatusEnabled}
+		if err = db.Create(channel).Error; err != nil {
+			t.Fatal(err)
+		}
+		channels = append(channels, channel)
+	}
+	oldDB := model.DB
+	model.DB = db
+	t.Cleanup(func() {
+		model.DB = oldDB
+		_ = sqlDB.Close()
+	})
+	model.RefreshActiveChannelCount()
+	return channels
+}
+
+// allowedUserRequests 以用户 userId 连续发送请求，返回被拒绝前放行的次数
+func allowedUserRequests(userId int, max int) int {
+	for i := 0; i < max; i++ {
+		if serveUpstreamRateLimitedRequest(userId, false, http.StatusOK) != http.StatusOK {
+			return i
+		}
+	}
+	return max
+}
+
+func TestTotalLimitTightensAsChannelsDisabled(t *testing.T) {
+	setupUpstream429Penalty(t, 8, 0)
+	oldBaseline, oldMax := setting.RateLimitChannelScalingBaseline, setting.RateLimitChannelScalingMaxPercent
+	setting.RateLimitChannelScalingBaseline, setting.RateLimitChannelScalingMaxPercent = 4, 100
+	t.Cleanup(func() {
+		setting.RateLimitChannelScalingBaseline, setting.RateLimitChannelScalingMaxPercent = oldBaseline, oldMax
+	})
+	channels := setupActiveChannels(t, 4)
+
+	// 4 个渠道全部可用时为配置的限制
+	if model.GetActiveChannelCount() != 4 {
+		t.Fatalf("active channels = %d, want 4", model.GetActiveChannelCount())
+	}
+	if got := allowedUserRequests(1951, 20); got != 8 {
+		t.Fatalf("allowed %d requests with 4 channels, want 8", got)
+	}
+
+	// 逐个禁用渠道后限制随之收紧
+	cases := []struct {
+		userId int
+		active int
+		want   int
+	}{
+		{1952, 2, 4},
+		{1953, 1, 2},
+	}
+	for _, tc := range cases {
+		for _, channel := range channels[tc.active:] {
+			if err := model.DB.Model(channel).Update("status", common.ChannelStatusManuallyDisabled).Error; err != nil {
+				t.Fatal(err)
+			}
+		}
+		model.RefreshActiveChannelCount()
+		if got := model.GetActiveChannelCount(); got != tc.active {
+			t.Fatalf("active channels = %d, want %d", got, tc.active)
+		}
+		if got := allowedUserRequests(tc.userId, 20); got != tc.want {
+			t.Fatalf("allowed %d requests with %d channels, want %d", got, tc.active, tc.want)
+		}
+	}
+}
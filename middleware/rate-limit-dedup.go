@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// 同一令牌在 RateLimitDedupWindowMs 内重复发送完全相同的请求时，只有第一次计入总请求数限制，
+// 重复请求照常经过其他限流检查，每个请求最多宽限 RateLimitDedupMaxForgiven 次重复
+const (
+	rateLimitDedupKeyPrefix   = "rateLimit:dedup:"
+	rateLimitDedupContextKey  = "rate_limit_dedup_hash"
+	rateLimitTotalForgivenKey = "rate_limit_total_forgiven"
+)
+
+// rateLimitDedupEntry 已计入限流的请求，forgiven 为窗口内已宽限的重复次数
+type rateLimitDedupEntry struct {
+	expireAt time.Time
+	forgiven int
+}
+
+var (
+	rateLimitDedupMutex  sync.Mutex
+	rateLimitDedupMemory = map[string]*rateLimitDedupEntry{} // 请求哈希 -> 记录
+)
+
+// 请求已记录时累加宽限次数并返回累加后的值，未记录时返回 -1
+var forgiveDedupScript = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 0 then
+	return -1
+end
+return redis.call('INCR', KEYS[1])
+`)
+
+// forgiveTotalCount 本次请求不再计入总请求数（包括失败请求）限制，其他检查照常进行
+func forgiveTotalCount(c *gin.Context) {
+	c.Set(rateLimitTotalForgivenKey, true)
+}
+
+// isTotalCountForgiven 本次请求是否不再计入总请求数限制
+func isTotalCountForgiven(c *gin.Context) bool {
+	return c.GetBool(rateLimitTotalForgivenKey)
+}
+
+// requestDedupHash 计算令牌、请求路径与请求体的哈希，未开启去重或无法读取请求体时返回空字符串
+func requestDedupHash(c *gin.Context) string {
+	if setting.RateLimitDedupWindowMs <= 0 {
+		return ""
+	}
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	if tokenId == 0 {
+		return ""
+	}
+	return requestBodyHash(c, tokenId)
+}
+
+// requestBodyHash 计算令牌、请求路径与请求体的哈希，无法读取请求体时返回空字符串
+func requestBodyHash(c *gin.Context, tokenId int) string {
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return ""
+	}
+	h := sha256.New()
+	h.Write([]byte(strconv.Itoa(tokenId)))
+	h.Write([]byte{0})
+	h.Write([]byte(c.Request.Method + " " + c.Request.URL.Path))
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// forgiveDuplicateRequest 判断相同的请求是否在去重窗口内已经计入过限流（duplicate），
+// 是重复请求且宽限次数未用完时累加宽限次数并返回 forgiven 为 true
+func forgiveDuplicateRequest(hash string) (duplicate bool, forgiven bool) {
+	if common.RedisEnabled() {
+		count, err := forgiveDedupScript.Run(context.Background(), common.RDB(), []string{rateLimitDedupKeyPrefix + hash}).Int()
+		if err != nil {
+			common.SysLog("failed to check duplicate request: " + err.Error())
+			return false, false
+		}
+		if count < 0 {
+			return false, false
+		}
+		return true, count <= setting.RateLimitDedupMaxForgiven
+	}
+	rateLimitDedupMutex.Lock()
+	defer rateLimitDedupMutex.Unlock()
+	entry, ok := rateLimitDedupMemory[hash]
+	if !ok {
+		return false, false
+	}
+	if time.Now().After(entry.expireAt) {
+		delete(rateLimitDedupMemory, hash)
+		return false, false
+	}
+	if entry.forgiven >= setting.RateLimitDedupMaxForgiven {
+		return true, false
+	}
+	entry.forgiven++
+	return true, true
+}
+
+// recordDedupRequest 记录请求哈希，窗口内的重复请求将不再计入限流
+func recordDedupRequest(c *gin.Context) {
+	hash := c.GetString(rateLimitDedupContextKey)
+	if hash == "" {
+		return
+	}
+	window := time.Duration(setting.RateLimitDedupWindowMs) * time.Millisecond
+	if common.RedisEnabled() {
+		if err := common.RDB().Set(context.Background(), rateLimitDedupKeyPrefix+hash, 0, window).Err(); err != nil {
+			common.SysLog("failed to record request for dedup: " + err.Error())
+		}
+		return
+	}
+	now := time.Now()
+	rateLimitDedupMutex.Lock()
+	defer rateLimitDedupMutex.Unlock()
+	// 记录较多时顺带清理已过期的记录，避免内存无限增长
+	if len(rateLimitDedupMemory) >= 1024 {
+		for key, entry := range rateLimitDedupMemory {
+			if now.After(entry.expireAt) {
+				delete(rateLimitDedupMemory, key)
+			}
+		}
+	}
+	rateLimitDedupMemory[hash] = &rateLimitDedupEntry{expireAt: now.Add(window)}
+}
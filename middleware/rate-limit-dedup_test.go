@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func setupDedup(t *testing.T, windowMs, maxForgiven int) {
+	t.Helper()
+	setting.RateLimitDedupWindowMs = windowMs
+	setting.RateLimitDedupMaxForgiven = maxForgiven
+	t.Cleanup(func() {
+		setting.RateLimitDedupWindowMs = 0
+		setting.RateLimitDedupMaxForgiven = 3
+		rateLimitDedupMemory = map[string]*rateLimitDedupEntry{}
+	})
+}
+
+func tokenTotalCount(tokenId int) int {
+	count, _ := inMemoryRateLimiter.Peek(TokenRateLimitCountMark+strconv.Itoa(tokenId), 60)
+	return count
+}
+
+func TestDuplicateRequestsCountOnce(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	setupDedup(t, 60000, 3)
+
+	for i := 0; i < 3; i++ {
+		if w := serveModelRequest(1241, `{"model":"gpt-4o","n":1}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+	}
+	if got := tokenTotalCount(1241); got != 1 {
+		t.Fatalf("duplicates counted %d times, want 1", got)
+	}
+
+	serveModelRequest(1241, `{"model":"gpt-4o","n":2}`, http.StatusOK, nil)
+	if got := tokenTotalCount(1241); got != 2 {
+		t.Fatalf("distinct payload count = %d, want 2", got)
+	}
+}
+
+func TestDuplicateForgivenessIsCapped(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	setupDedup(t, 60000, 2)
+
+	for i := 0; i < 5; i++ {
+		serveModelRequest(1242, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	}
+	// 第一次计入，两次重复被宽限，其余两次照常计数
+	if got := tokenTotalCount(1242); got != 3 {
+		t.Fatalf("count = %d, want 3", got)
+	}
+}
+
+func TestDuplicateRequestStillChecksOtherLimits(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	setupDedup(t, 60000, 3)
+	setting.TokenRateLimitSuccessCount = 1
+	t.Cleanup(func() { setting.TokenRateLimitSuccessCount = 0 })
+
+	if w := serveModelRequest(1243, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	// 重复请求不计入总请求数，但成功请求数已用完，仍应被拒绝
+	if w := serveModelRequest(1243, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("duplicate over success limit: status %d, want 429", w.Code)
+	}
+}
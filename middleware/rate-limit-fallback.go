@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// Redis 响应变慢时，超过 RateLimitRedisTimeoutMs 的限流检查改用本节点的内存限流，各节点分别计数，只能提供部分保护
+
+// withRedisTimeout 按 RateLimitRedisTimeoutMs 为限流检查设置超时，未配置时原样返回
+func withRedisTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if setting.RateLimitRedisTimeoutMs <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, time.Duration(setting.RateLimitRedisTimeoutMs)*time.Millisecond)
+}
+
+// reserveWithTimeout 带超时的令牌桶检查
+func reserveWithTimeout(ctx context.Context, tb *limiter.RedisLimiter, key string, opts ...limiter.Option) (bool, time.Duration, error) {
+	ctx, cancel := withRedisTimeout(ctx)
+	defer cancel()
+	return tb.Reserve(ctx, key, opts...)
+}
+
+// isRedisSlow 判断错误是否为开启 RateLimitRedisTimeoutMs 后 Redis 响应超时
+func isRedisSlow(err error) bool {
+	if err == nil || setting.RateLimitRedisTimeoutMs <= 0 {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// noteRedisFallback 记录一次改用内存限流的检查，日志按 rateLimitFailOpenLogInterval 间隔输出
+func noteRedisFallback(key string, err error) {
+	total := atomic.AddInt64(&rateLimitStats.memoryFallbacks, 1)
+	inMemoryRateLimiter.Init(time.Duration(setting.ModelRequestRateLimitDurationMinutes) * time.Minute)
+	now := time.Now().Unix()
+	lastLog := atomic.LoadInt64(&rateLimitStats.lastFailOpenLogAt)
+	if now-lastLog >= rateLimitFailOpenLogInterval && atomic.CompareAndSwapInt64(&rateLimitStats.lastFailOpenLogAt, lastLog, now) {
+		common.SysLog(fmt.Sprintf("rate limit redis check on %s exceeded %dms, falling back to memory limiter (%d fallbacks total): %s", key, setting.RateLimitRedisTimeoutMs, total, err.Error()))
+	}
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// startSlowRedis 启动一个接受连接但从不回复的 Redis 服务端，模拟响应极慢的 Redis
+func startSlowRedis(t *testing.T, opts *redis.Options) *redis.Client {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.Copy(io.Discard, conn)
+			}()
+		}
+	}()
+	opts.Addr = ln.Addr().String()
+	opts.MaxRetries = -1
+	rdb := redis.NewClient(opts)
+	oldRDB, oldWriter := common.RDB(), gin.DefaultWriter
+	common.SetRDB(rdb)
+	common.SetRedisEnabled(true)
+	gin.DefaultWriter = io.Discard
+	t.Cleanup(func() {
+		common.SetRDB(oldRDB)
+		common.SetRedisEnabled(false)
+		gin.DefaultWriter = oldWriter
+		_ = rdb.Close()
+		_ = ln.Close()
+	})
+	return rdb
+}
+
+func TestSlowRedisFallsBackToMemoryLimit(t *testing.T) {
+	setupMemoryRateLimit(t, 0)
+	setting.TokenRateLimitSuccessCount = 2
+	setting.RateLimitRedisTimeoutMs = 50
+	t.Cleanup(func() {
+		setting.TokenRateLimitSuccessCount = 0
+		setting.RateLimitRedisTimeoutMs = 0
+	})
+	startSlowRedis(t, &redis.Options{})
+
+	fallbacks := atomic.LoadInt64(&rateLimitStats.memoryFallbacks)
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		if w := serveModelRequest(1741, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, w.Code)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Fatalf("request %d took %v, want the check to give up after the timeout", i+1, elapsed)
+		}
+	}
+	// Redis 超时期间成功请求记录在内存中，达到上限后仍然拒绝而不是放行
+	if w := serveModelRequest(1741, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the limit during the Redis slowdown: status %d, want 429", w.Code)
+	}
+	// 每个请求的检查与成功记录各超时一次，第三个请求在检查时被拒绝
+	if got := atomic.LoadInt64(&rateLimitStats.memoryFallbacks) - fallbacks; got != 5 {
+		t.Fatalf("memory fallbacks increased by %d, want 5", got)
+	}
+}
+
+func TestSlowRedisWithoutTimeoutFailsOpen(t *testing.T) {
+	setupMemoryRateLimit(t, 0)
+	setting.TokenRateLimitSuccessCount = 2
+	setting.RateLimitFailOpenEnabled = true
+	t.Cleanup(func() {
+		setting.TokenRateLimitSuccessCount = 0
+		setting.RateLimitFailOpenEnabled = false
+	})
+	// 未配置 RateLimitRedisTimeoutMs 时，由客户端读超时报错并按原有逻辑放行
+	startSlowRedis(t, &redis.Options{ReadTimeout: 50 * time.Millisecond})
+
+	fallbacks := atomic.LoadInt64(&rateLimitStats.memoryFallbacks)
+	for i := 0; i < 3; i++ {
+		if w := serveModelRequest(1742, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d, want it let through", i+1, w.Code)
+		}
+	}
+	if got := atomic.LoadInt64(&rateLimitStats.memoryFallbacks) - fallbacks; got != 0 {
+		t.Fatalf("memory fallbacks increased by %d without a timeout configured", got)
+	}
+}
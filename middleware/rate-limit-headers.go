@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitHeaderWriter 在响应头发出前查询调用方当前的限流状态并写入 X-RateLimit-* 响应头，
+// 此时本次请求已计入总请求数，客户端拿到的剩余额度与下一次请求时一致
+type rateLimitHeaderWriter struct {
+	gin.ResponseWriter
+	c    *gin.Context
+	once sync.Once
+}
+
+func (w *rateLimitHeaderWriter) setHeaders() {
+	w.once.Do(func() {
+		if w.ResponseWriter.Written() {
+			return
+		}
+		statuses, err := GetRateLimitStatuses(
+			common.GetContextKeyInt(w.c, constant.ContextKeyTokenId),
+			common.GetContextKeyString(w.c, constant.ContextKeyTokenGroup),
+			w.c.GetInt("id"),
+			common.GetContextKeyString(w.c, constant.ContextKeyUserGroup),
+		)
+		if err != nil {
+			common.SysLog("failed to peek rate limit status: " + err.Error())
+			return
+		}
+		setRateLimitHeaders(w.c, statuses)
+		if setting.RateLimitPolicyHeadersEnabled {
+			setRateLimitPolicyHeaders(w.c, statuses)
+		}
+	})
+}
+
+func (w *rateLimitHeaderWriter) WriteHeaderNow() {
+	w.setHeaders()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *rateLimitHeaderWriter) Write(data []byte) (int, error) {
+	w.setHeaders()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *rateLimitHeaderWriter) WriteString(s string) (int, error) {
+	w.setHeaders()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *rateLimitHeaderWriter) Flush() {
+	w.setHeaders()
+	w.ResponseWriter.Flush()
+}
+
+// wrapRateLimitHeaderWriter 开启 AlwaysSendRateLimitHeaders 时，让通过限流的请求在响应（包括成功响应与流式响应）中
+// 也带有 X-RateLimit-* 响应头，便于客户端主动跟踪剩余额度
+func wrapRateLimitHeaderWriter(c *gin.Context) {
+	if !setting.AlwaysSendRateLimitHeaders {
+		return
+	}
+	if _, ok := c.Writer.(*rateLimitHeaderWriter); ok {
+		return
+	}
+	c.Writer = &rateLimitHeaderWriter{ResponseWriter: c.Writer, c: c}
+}
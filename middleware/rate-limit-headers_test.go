@@ -0,0 +1,96 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setAlwaysSendRateLimitHeaders(t *testing.T, enabled bool) {
+	t.Helper()
+	old := setting.AlwaysSendRateLimitHeaders
+	setting.AlwaysSendRateLimitHeaders = enabled
+	t.Cleanup(func() { setting.AlwaysSendRateLimitHeaders = old })
+}
+
+// serveRelayResponse 以令牌 tokenId 发送一次经过 ModelRequestRateLimit 的请求，上游处理函数以流式或普通方式写出 200 响应体
+func serveRelayResponse(tokenId int, stream bool) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyTokenId, tokenId)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "default")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		c.Next()
+	}, ModelRequestRateLimit(), func(c *gin.Context) {
+		if stream {
+			c.Header("Content-Type", "text/event-stream")
+			c.Writer.Flush()
+			_, _ = c.Writer.WriteString("data: [DONE]\n\n")
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"object": "chat.completion"})
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestAlwaysSendRateLimitHeadersOnSuccess(t *testing.T) {
+	setupMemoryRateLimit(t, 5)
+	setAlwaysSendRateLimitHeaders(t, true)
+
+	for i := 1; i <= 3; i++ {
+		w := serveRelayResponse(1831, false)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "5" {
+			t.Fatalf("request %d: X-RateLimit-Limit = %q, want 5", i, got)
+		}
+		// 本次请求已计入总请求数
+		if got, want := w.Header().Get("X-RateLimit-Remaining"), strconv.Itoa(5-i); got != want {
+			t.Fatalf("request %d: X-RateLimit-Remaining = %q, want %s", i, got, want)
+		}
+		if reset, err := strconv.Atoi(w.Header().Get("X-RateLimit-Reset")); err != nil || reset <= 0 || reset > 60 {
+			t.Fatalf("request %d: X-RateLimit-Reset = %q, want seconds within the window", i, w.Header().Get("X-RateLimit-Reset"))
+		}
+	}
+}
+
+func TestAlwaysSendRateLimitHeadersOnStream(t *testing.T) {
+	setupMemoryRateLimit(t, 5)
+	setAlwaysSendRateLimitHeaders(t, true)
+
+	w := serveRelayResponse(1832, true)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "[DONE]") {
+		t.Fatalf("status %d, body %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-RateLimit-Limit") != "5" || w.Header().Get("X-RateLimit-Remaining") != "4" {
+		t.Fatalf("stream headers = %q/%q, want 4/5", w.Header().Get("X-RateLimit-Remaining"), w.Header().Get("X-RateLimit-Limit"))
+	}
+}
+
+func TestAlwaysSendRateLimitHeadersDisabled(t *testing.T) {
+	setupMemoryRateLimit(t, 5)
+	setAlwaysSendRateLimitHeaders(t, false)
+
+	w := serveRelayResponse(1833, false)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	for _, header := range []string{"X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset"} {
+		if got := w.Header().Get(header); got != "" {
+			t.Errorf("%s = %q on a 200 response with AlwaysSendRateLimitHeaders off", header, got)
+		}
+	}
+}
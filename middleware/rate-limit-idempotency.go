@@ -0,0 +1,184 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 客户端使用相同的 Idempotency-Key 重试同一操作时，RateLimitIdempotencyWindowSeconds 内只有第一次计入限流，
+// 之后的请求直接沿用第一次的判定：第一次放行则继续放行且不消耗额度，第一次被限流则在 Retry-After 到期前返回相同的拒绝。
+// 相同的 Idempotency-Key 携带不同的请求体时返回 422，不沿用第一次的判定
+const (
+	IdempotencyKeyHeader = "Idempotency-Key"
+
+	rateLimitIdempotencyKeyPrefix      = "rateLimit:idem:"
+	rateLimitIdempotencyContextKey     = "rate_limit_idempotency_key"
+	rateLimitIdempotencyBodyContextKey = "rate_limit_idempotency_body"
+
+	// Idempotency-Key 的最大长度，过长的值不参与判定
+	maxIdempotencyKeyLength = 255
+)
+
+// idempotencyDecision 第一次请求的限流判定
+type idempotencyDecision struct {
+	Allowed  bool   `json:"allowed"`
+	Status   int    `json:"status,omitempty"`
+	Code     string `json:"code,omitempty"`
+	Message  string `json:"message,omitempty"`
+	ResetAt  int64  `json:"reset_at,omitempty"` // 拒绝的 Retry-After 到期时间（Unix 秒）
+	BodyHash string `json:"body_hash"`          // 第一次请求的路径与请求体哈希
+}
+
+type idempotencyMemoryEntry struct {
+	decision idempotencyDecision
+	expireAt time.Time
+}
+
+var (
+	rateLimitIdempotencyMutex  sync.Mutex
+	rateLimitIdempotencyMemory = map[string]idempotencyMemoryEntry{}
+)
+
+// idempotencyStorageKey 返回令牌与 Idempotency-Key 组合的存储 key，未开启或请求未携带时返回空字符串
+func idempotencyStorageKey(c *gin.Context) string {
+	if setting.RateLimitIdempotencyWindowSeconds <= 0 {
+		return ""
+	}
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	if tokenId == 0 {
+		return ""
+	}
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return ""
+	}
+	return rateLimitIdempotencyKeyPrefix + strconv.Itoa(tokenId) + ":" + key
+}
+
+// loadIdempotencyDecision 读取第一次请求的判定，不存在或读取失败时返回 false
+func loadIdempotencyDecision(key string) (idempotencyDecision, bool) {
+	var decision idempotencyDecision
+	if common.RedisEnabled() {
+		value, err := common.RDB().Get(context.Background(), key).Result()
+		if err != nil {
+			return decision, false
+		}
+		if err := json.Unmarshal([]byte(value), &decision); err != nil {
+			return decision, false
+		}
+		return decision, true
+	}
+	rateLimitIdempotencyMutex.Lock()
+	defer rateLimitIdempotencyMutex.Unlock()
+	entry, ok := rateLimitIdempotencyMemory[key]
+	if !ok {
+		return decision, false
+	}
+	if time.Now().After(entry.expireAt) {
+		delete(rateLimitIdempotencyMemory, key)
+		return decision, false
+	}
+	return entry.decision, true
+}
+
+// saveIdempotencyDecision 保存判定结果，ttl 到期后相同的 Idempotency-Key 重新计入限流
+func saveIdempotencyDecision(key string, decision idempotencyDecision, ttl time.Duration) {
+	if ttl <= 0 {
+		return
+	}
+	if common.RedisEnabled() {
+		data, err := json.Marshal(decision)
+		if err != nil {
+			return
+		}
+		// 并发的重复请求只保留最先写入的判定
+		if err := common.RDB().SetNX(context.Background(), key, data, ttl).Err(); err != nil {
+			common.SysLog("failed to record idempotency decision: " + err.Error())
+		}
+		return
+	}
+	now := time.Now()
+	rateLimitIdempotencyMutex.Lock()
+	defer rateLimitIdempotencyMutex.Unlock()
+	// 记录较多时顺带清理已过期的记录，避免内存无限增长
+	if len(rateLimitIdempotencyMemory) >= 1024 {
+		for k, entry := range rateLimitIdempotencyMemory {
+			if now.After(entry.expireAt) {
+				delete(rateLimitIdempotencyMemory, k)
+			}
+		}
+	}
+	if entry, ok := rateLimitIdempotencyMemory[key]; ok && now.Before(entry.expireAt) {
+		return
+	}
+	rateLimitIdempotencyMemory[key] = idempotencyMemoryEntry{decision: decision, expireAt: now.Add(ttl)}
+}
+
+// replayIdempotencyDecision 请求携带的 Idempotency-Key 在窗口内已有判定时按该判定处理并返回 true；
+// 没有判定时记录 key，待本次请求的判定产生后保存
+func replayIdempotencyDecision(c *gin.Context) bool {
+	key := idempotencyStorageKey(c)
+	if key == "" {
+		return false
+	}
+	bodyHash := requestBodyHash(c, common.GetContextKeyInt(c, constant.ContextKeyTokenId))
+	decision, ok := loadIdempotencyDecision(key)
+	if !ok {
+		c.Set(rateLimitIdempotencyContextKey, key)
+		c.Set(rateLimitIdempotencyBodyContextKey, bodyHash)
+		return false
+	}
+	if bodyHash == "" || decision.BodyHash != bodyHash {
+		abortWithOpenAiMessage(c, http.StatusUnprocessableEntity, "Idempotency-Key 已用于内容不同的请求，请为新请求使用新的 Idempotency-Key", "idempotency_key_reused")
+		return true
+	}
+	if decision.Allowed {
+		wrapRateLimitHeaderWriter(c)
+		c.Next()
+		return true
+	}
+	setRetryAfter(c, max(decision.ResetAt-time.Now().Unix(), 1))
+	writeErrorMessage(c, decision.Status, decision.Message, decision.Code)
+	return true
+}
+
+// recordIdempotencyAllowed 请求通过全部限流检查后保存放行的判定
+func recordIdempotencyAllowed(c *gin.Context) {
+	key := c.GetString(rateLimitIdempotencyContextKey)
+	if key == "" {
+		return
+	}
+	saveIdempotencyDecision(key, idempotencyDecision{
+		Allowed:  true,
+		BodyHash: c.GetString(rateLimitIdempotencyBodyContextKey),
+	}, time.Duration(setting.RateLimitIdempotencyWindowSeconds)*time.Second)
+}
+
+// recordIdempotencyRejected 请求被限流时保存拒绝的判定，保存时长不超过 Retry-After，客户端按 Retry-After 等待后重试可以重新判定
+func recordIdempotencyRejected(c *gin.Context, statusCode int, code string, retryAfter int64, message string) {
+	key := c.GetString(rateLimitIdempotencyContextKey)
+	if key == "" {
+		return
+	}
+	ttl := int64(setting.RateLimitIdempotencyWindowSeconds)
+	if retryAfter > 0 && retryAfter < ttl {
+		ttl = retryAfter
+	}
+	saveIdempotencyDecision(key, idempotencyDecision{
+		Status:   statusCode,
+		Code:     code,
+		Message:  message,
+		ResetAt:  time.Now().Unix() + ttl,
+		BodyHash: c.GetString(rateLimitIdempotencyBodyContextKey),
+	}, time.Duration(ttl)*time.Second)
+}
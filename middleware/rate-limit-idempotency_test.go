@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func setupIdempotency(t *testing.T) {
+	t.Helper()
+	setting.RateLimitIdempotencyWindowSeconds = 60
+	t.Cleanup(func() {
+		setting.RateLimitIdempotencyWindowSeconds = 0
+		rateLimitIdempotencyMemory = map[string]idempotencyMemoryEntry{}
+	})
+}
+
+func TestIdempotencyKeyReplaysSameRequest(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	setupIdempotency(t)
+	headers := map[string]string{IdempotencyKeyHeader: "op-1"}
+
+	for i := 0; i < 3; i++ {
+		if w := serveModelRequest(1801, `{"model":"gpt-4o"}`, http.StatusOK, headers); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+	}
+	if got := tokenTotalCount(1801); got != 1 {
+		t.Fatalf("count = %d, want 1", got)
+	}
+}
+
+func TestIdempotencyKeyRejectsDifferentPayload(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	setupIdempotency(t)
+	headers := map[string]string{IdempotencyKeyHeader: "op-2"}
+
+	serveModelRequest(1802, `{"model":"gpt-4o"}`, http.StatusOK, headers)
+	w := serveModelRequest(1802, `{"model":"gpt-4o","messages":[]}`, http.StatusOK, headers)
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("reused key with different payload: status %d, want 422", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "idempotency_key_reused") {
+		t.Fatalf("body = %s", w.Body.String())
+	}
+	if got := tokenTotalCount(1802); got != 1 {
+		t.Fatalf("count = %d, want 1", got)
+	}
+}
@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// upstream429PenaltyApplies 请求失败且转发过程中有上游返回 429 时，按 Upstream429Penalty 额外计入 per-user 总请求数，
+// 重试到其它渠道后成功的请求不受影响
+func upstream429PenaltyApplies(c *gin.Context) bool {
+	return setting.Upstream429Penalty > 0 && common.GetContextKeyBool(c, constant.ContextKeyUpstreamRateLimited)
+}
+
+// chargeRedisUpstream429Penalty 从 per-user 总请求数令牌桶中额外扣除 Upstream429Penalty 次请求的令牌
+func chargeRedisUpstream429Penalty(ctx context.Context, key string, totalMaxCount int, duration int64) {
+	err := limiter.New(ctx, common.RDB()).Charge(ctx, key,
+		limiter.WithCapacity(int64(totalMaxCount)*duration),
+		limiter.WithRate(int64(totalMaxCount)),
+		limiter.WithRequested(int64(setting.Upstream429Penalty)*duration),
+	)
+	if err != nil {
+		common.SysLog("failed to charge upstream 429 penalty: " + err.Error())
+	}
+}
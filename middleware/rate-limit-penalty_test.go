@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupUpstream429Penalty(t *testing.T, totalCount int, penalty int) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	common.SetRedisEnabled(false)
+	constant.MaxRequestBodyMB = 8
+	oldEnabled, oldCount, oldSuccess, oldMinutes := setting.ModelRequestRateLimitEnabled, setting.ModelRequestRateLimitCount, setting.ModelRequestRateLimitSuccessCount, setting.ModelRequestRateLimitDurationMinutes
+	oldPenalty := setting.Upstream429Penalty
+	setting.ModelRequestRateLimitEnabled = true
+	setting.ModelRequestRateLimitCount = totalCount
+	setting.ModelRequestRateLimitSuccessCount = 0
+	setting.ModelRequestRateLimitDurationMinutes = 1
+	setting.Upstream429Penalty = penalty
+	t.Cleanup(func() {
+		setting.ModelRequestRateLimitEnabled, setting.ModelRequestRateLimitCount, setting.ModelRequestRateLimitSuccessCount, setting.ModelRequestRateLimitDurationMinutes = oldEnabled, oldCount, oldSuccess, oldMinutes
+		setting.Upstream429Penalty = oldPenalty
+	})
+}
+
+// serveUpstreamRateLimitedRequest 以用户 userId 发送一次请求，upstream429 为 true 时模拟转发过程中有上游返回 429，最终响应状态为 status
+func serveUpstreamRateLimitedRequest(userId int, upstream429 bool, status int) int {
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Set("id", userId)
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		c.Next()
+	}, ModelRequestRateLimit(), func(c *gin.Context) {
+		if upstream429 {
+			common.SetContextKey(c, constant.ContextKeyUpstreamRateLimited, true)
+		}
+		c.Status(status)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w.Code
+}
+
+func userTotalCount(userId int) int {
+	count, _ := inMemoryRateLimiter.Peek(ModelRequestRateLimitCountMark+strconv.Itoa(userId), 60)
+	return count
+}
+
+func TestUpstream429ChargesPenalty(t *testing.T) {
+	setupUpstream429Penalty(t, 5, 2)
+
+	if code := serveUpstreamRateLimitedRequest(1721, true, http.StatusTooManyRequests); code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want the upstream 429 passed through", code)
+	}
+	if got := userTotalCount(1721); got != 3 {
+		t.Fatalf("total count = %d, want 1 request plus a penalty of 2", got)
+	}
+	serveUpstreamRateLimitedRequest(1721, true, http.StatusTooManyRequests)
+	if got := userTotalCount(1721); got != 5 {
+		t.Fatalf("total count = %d, want capped at the limit of 5", got)
+	}
+	// 惩罚用完了剩余额度，下一次请求被本地限流拒绝
+	if code := serveUpstreamRateLimitedRequest(1721, false, http.StatusOK); code != http.StatusTooManyRequests {
+		t.Fatalf("status %d, want rejected after the penalty used up the limit", code)
+	}
+}
+
+func TestUpstream429PenaltySkippedWhenRetrySucceeds(t *testing.T) {
+	setupUpstream429Penalty(t, 5, 2)
+
+	// 上游 429 后重试到其它渠道成功
+	if code := serveUpstreamRateLimitedRequest(1722, true, http.StatusOK); code != http.StatusOK {
+		t.Fatalf("status %d", code)
+	}
+	if got := userTotalCount(1722); got != 1 {
+		t.Fatalf("total count = %d, want no penalty for a successful request", got)
+	}
+	// 其它原因失败的请求也不追加惩罚
+	serveUpstreamRateLimitedRequest(1722, false, http.StatusBadGateway)
+	if got := userTotalCount(1722); got != 2 {
+		t.Fatalf("total count = %d, want no penalty without an upstream 429", got)
+	}
+}
+
+func TestUpstream429PenaltyDisabled(t *testing.T) {
+	setupUpstream429Penalty(t, 5, 0)
+
+	serveUpstreamRateLimitedRequest(1723, true, http.StatusTooManyRequests)
+	if got := userTotalCount(1723); got != 1 {
+		t.Fatalf("total count = %d, want 1 when the penalty is disabled", got)
+	}
+}
@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// 按固定周期（TokenQuotaSchedule）计数的限流，周期内的计数在到达重置时间时清零。
+// Redis 中每个周期使用独立的计数 key 并在重置时间过期，内存中按周期起点记录计数
+type periodCounter struct {
+	start int64
+	reset int64
+	count int
+}
+
+var (
+	periodCounterMutex sync.Mutex
+	periodCounters     = map[string]*periodCounter{}
+)
+
+func periodCounterKey(key string, window setting.QuotaWindow) string {
+	return key + ":" + strconv.FormatInt(window.Start.Unix(), 10)
+}
+
+// 计数未达到 ARGV[1] 时计入一次并返回 1，否则返回 0；计数 key 在同一脚本中设置于重置时间 ARGV[2] 过期
+var reservePeriodCountScript = redis.NewScript(`
+local count = redis.call('INCR', KEYS[1])
+if redis.call('TTL', KEYS[1]) < 0 then
+	redis.call('EXPIREAT', KEYS[1], ARGV[2])
+end
+if count > tonumber(ARGV[1]) then
+	redis.call('DECR', KEYS[1])
+	return 0
+end
+return 1
+`)
+
+// 计数 key 仍存在且大于 0 时撤销一次，周期已结束、key 已过期时不做修改，避免留下没有过期时间的负数计数
+var releasePeriodCountScript = redis.NewScript(`
+local count = tonumber(redis.call('GET', KEYS[1]) or '0')
+if count > 0 then
+	redis.call('DECR', KEYS[1])
+end
+return 0
+`)
+
+// reservePeriodCount 周期内计数未达到 maxCount 时计入本次请求并返回 true，否则不计数并返回 false
+func reservePeriodCount(ctx context.Context, key string, maxCount int, window setting.QuotaWindow) (bool, error) {
+	if common.RedisEnabled() {
+		reserved, err := reservePeriodCountScript.Run(ctx, common.RDB(), []string{periodCounterKey(key, window)}, maxCount, window.Reset.Unix()).Int()
+		if err != nil {
+			return false, err
+		}
+		return reserved == 1, nil
+	}
+
+	periodCounterMutex.Lock()
+	defer periodCounterMutex.Unlock()
+	start := window.Start.Unix()
+	counter, ok := periodCounters[key]
+	if !ok || counter.start != start {
+		// 记录较多时顺带清理周期已结束的计数，避免内存无限增长。不同限流的周期长度不同，按各自的重置时间判断
+		if len(periodCounters) >= 1024 {
+			now := time.Now().Unix()
+			for k, c := range periodCounters {
+				if c.reset <= now {
+					delete(periodCounters, k)
+				}
+			}
+		}
+		counter = &periodCounter{start: start, reset: window.Reset.Unix()}
+		periodCounters[key] = counter
+	}
+	if counter.count >= maxCount {
+		return false, nil
+	}
+	counter.count++
+	return true, nil
+}
+
+// releasePeriodCount 撤销 reservePeriodCount 计入的一次请求，周期已结束时无需撤销
+func releasePeriodCount(ctx context.Context, key string, window setting.QuotaWindow) error {
+	if common.RedisEnabled() {
+		return releasePeriodCountScript.Run(ctx, common.RDB(), []string{periodCounterKey(key, window)}).Err()
+	}
+	periodCounterMutex.Lock()
+	defer periodCounterMutex.Unlock()
+	if counter, ok := periodCounters[key]; ok && counter.start == window.Start.Unix() && counter.count > 0 {
+		counter.count--
+	}
+	return nil
+}
+
+// peekPeriodCount 返回 key 在周期内的计数，不计入请求
+func peekPeriodCount(ctx context.Context, key string, window setting.QuotaWindow) (int, error) {
+	if common.RedisEnabled() {
+		count, err := common.RDB().Get(ctx, periodCounterKey(key, window)).Int()
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+		return count, err
+	}
+	periodCounterMutex.Lock()
+	defer periodCounterMutex.Unlock()
+	if counter, ok := periodCounters[key]; ok && counter.start == window.Start.Unix() {
+		return counter.count, nil
+	}
+	return 0, nil
+}
+
+// 从下一周期借用的次数计数，按扣除额度的周期（即借出时的下一周期）区分 key，
+// 内存中每个 key 只保留一个周期的计数，借出时不能覆盖本周期仍在扣除的借用次数
+func periodBorrowKey(key string, window setting.QuotaWindow) string {
+	return key + ":borrowed:" + strconv.FormatInt(window.Start.Unix(), 10)
+}
+
+// reservePeriodCountWithBorrow 在周期内计入一次请求，上一周期借用的次数从本周期的上限中扣除。
+// next 不为空且距离重置不足 DailyLimitGraceBeforeResetMinutes 时，达到上限后从下一周期借用，最多借 DailyLimitOverageAllowance 次。
+// 返回本次请求实际计入的 key 与周期，用于撤销
+func reservePeriodCountWithBorrow(ctx context.Context, key string, maxCount int, window setting.QuotaWindow, next *setting.QuotaWindow) (bool, string, setting.QuotaWindow, error) {
+	if next != nil {
+		borrowed, err := peekPeriodCount(ctx, periodBorrowKey(key, window), window)
+		if err != nil {
+			return false, key, window, err
+		}
+		maxCount = max(maxCount-borrowed, 0)
+	}
+	allowed, err := reservePeriodCount(ctx, key, maxCount, window)
+	if err != nil || allowed || next == nil {
+		return allowed, key, window, err
+	}
+	grace := time.Duration(setting.DailyLimitGraceBeforeResetMinutes) * time.Minute
+	if grace <= 0 || setting.DailyLimitOverageAllowance <= 0 || time.Until(window.Reset) > grace {
+		return false, key, window, nil
+	}
+	borrowKey := periodBorrowKey(key, *next)
+	allowed, err = reservePeriodCount(ctx, borrowKey, setting.DailyLimitOverageAllowance, *next)
+	return allowed, borrowKey, *next, err
+}
+
+// checkPeriodRateLimit 按固定周期检查总请求数与成功请求数限制。成功请求数在检查时预占，请求未成功时撤销。
+// next 为下一周期，不为空时允许在临近重置时从下一周期借用额度
+func checkPeriodRateLimit(c *gin.Context, totalKey, successKey string, totalMaxCount, successMaxCount int, window setting.QuotaWindow, next *setting.QuotaWindow) bool {
+	ctx := context.Background()
+	retryAfter := int64(time.Until(window.Reset).Seconds()) + 1
+
+	if totalMaxCount > 0 && !isTotalCountForgiven(c) {
+		allowed, reservedKey, reservedWindow, err := reservePeriodCountWithBorrow(ctx, totalKey, totalMaxCount, window, next)
+		if err != nil {
+			common.SysLog("检查周期总请求数限制失败: " + err.Error())
+			if !rateLimitFailOpen(err) {
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return false
+			}
+		} else if allowed {
+			trackRateLimitConsumed(c, rateLimitConsumption{key: totalKey, release: func(ctx context.Context) error {
+				return releasePeriodCount(ctx, reservedKey, reservedWindow)
+			}})
+		} else {
+			abortWithRateLimitMessage(c, rateLimitRejectTotal, retryAfter, "您已达到本周期总请求数限制（包括失败请求）")
+			return false
+		}
+	}
+
+	if successMaxCount > 0 {
+		allowed, reservedKey, reservedWindow, err := reservePeriodCountWithBorrow(ctx, successKey, successMaxCount, window, next)
+		if err != nil {
+			common.SysLog("检查周期成功请求数限制失败: " + err.Error())
+			if !rateLimitFailOpen(err) {
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return false
+			}
+			return true
+		}
+		if !allowed {
+			abortWithRateLimitMessage(c, rateLimitRejectSuccess, retryAfter, "您已达到本周期请求数限制")
+			return false
+		}
+		addSuccessReservation(c, successKey, func(ctx context.Context) error {
+			return releasePeriodCount(ctx, reservedKey, reservedWindow)
+		})
+	}
+	return true
+}
@@ -0,0 +1,229 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func TestReservePeriodCountResetsAtBoundary(t *testing.T) {
+	common.SetRedisEnabled(false)
+	ctx := context.Background()
+	schedule, err := setting.ParseQuotaSchedule("weekly@mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 2026-10-16 为周五，下一周期从 2026-10-19 周一开始
+	friday := schedule.Window(time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local))
+	monday := schedule.Window(time.Date(2026, 10, 19, 0, 0, 0, 0, time.Local))
+
+	for i := 0; i < 2; i++ {
+		if allowed, _ := reservePeriodCount(ctx, "period-test", 2, friday); !allowed {
+			t.Fatalf("request %d within the period limit rejected", i+1)
+		}
+	}
+	if allowed, _ := reservePeriodCount(ctx, "period-test", 2, friday); allowed {
+		t.Fatal("request over the period limit allowed")
+	}
+	if count, _ := peekPeriodCount(ctx, "period-test", friday); count != 2 {
+		t.Fatalf("period count = %d, want 2", count)
+	}
+
+	// 到达重置时间后进入新周期，计数清零
+	if count, _ := peekPeriodCount(ctx, "period-test", monday); count != 0 {
+		t.Fatalf("next period count = %d, want 0", count)
+	}
+	if allowed, _ := reservePeriodCount(ctx, "period-test", 2, monday); !allowed {
+		t.Fatal("first request of the next period rejected")
+	}
+
+	if err = releasePeriodCount(ctx, "period-test", monday); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := peekPeriodCount(ctx, "period-test", monday); count != 0 {
+		t.Fatalf("count after release = %d, want 0", count)
+	}
+}
+
+func TestTokenQuotaScheduleCountsPerPeriod(t *testing.T) {
+	setupMemoryRateLimit(t, 0)
+	setting.TokenDailyRateLimitEnabled = true
+	setting.TokenDailyRateLimitCount = 2
+	if err := setting.UpdateTokenQuotaSchedule("monthly@1"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		setting.TokenDailyRateLimitEnabled = false
+		setting.TokenDailyRateLimitCount = 0
+		_ = setting.UpdateTokenQuotaSchedule("")
+	})
+
+	for i := 0; i < 2; i++ {
+		if w := serveModelRequest(1621, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, w.Code)
+		}
+	}
+	if w := serveModelRequest(1621, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("3rd request in the period: status %d, want 429", w.Code)
+	}
+	// 计数记录在本周期的 key 中
+	window, _ := setting.GetTokenQuotaWindow(time.Now())
+	if count, _ := peekPeriodCount(context.Background(), "rateLimit:"+TokenDailyRateLimitCountMark+":1621", window); count != 2 {
+		t.Fatalf("period count = %d, want 2", count)
+	}
+}
+
+func setDailyLimitOverage(t *testing.T, graceMinutes int, allowance int) {
+	t.Helper()
+	oldGrace, oldAllowance := setting.DailyLimitGraceBeforeResetMinutes, setting.DailyLimitOverageAllowance
+	setting.DailyLimitGraceBeforeResetMinutes, setting.DailyLimitOverageAllowance = graceMinutes, allowance
+	t.Cleanup(func() {
+		setting.DailyLimitGraceBeforeResetMinutes, setting.DailyLimitOverageAllowance = oldGrace, oldAllowance
+	})
+}
+
+// periodWindows 返回距离重置还有 untilReset 的当前周期及其后的两个每日周期
+func periodWindows(untilReset time.Duration) (current, next, after setting.QuotaWindow) {
+	reset := time.Now().Add(untilReset).Truncate(time.Second)
+	current = setting.QuotaWindow{Start: reset.Add(-24 * time.Hour), Reset: reset}
+	next = setting.QuotaWindow{Start: reset, Reset: reset.Add(24 * time.Hour)}
+	after = setting.QuotaWindow{Start: next.Reset, Reset: next.Reset.Add(24 * time.Hour)}
+	return current, next, after
+}
+
+func TestPeriodBorrowNearResetDeductedAfterReset(t *testing.T) {
+	common.SetRedisEnabled(false)
+	setDailyLimitOverage(t, 10, 2)
+	ctx := context.Background()
+	current, next, after := periodWindows(5 * time.Minute)
+	const key = "period-borrow-1881"
+
+	for i := 0; i < 3; i++ {
+		if allowed, _, _, _ := reservePeriodCountWithBorrow(ctx, key, 3, current, &next); !allowed {
+			t.Fatalf("request %d within the limit rejected", i+1)
+		}
+	}
+	// 临近重置时达到上限，从下一周期借用最多 2 次
+	for i := 0; i < 2; i++ {
+		allowed, reservedKey, reservedWindow, err := reservePeriodCountWithBorrow(ctx, key, 3, current, &next)
+		if err != nil || !allowed {
+			t.Fatalf("borrow %d rejected: %v", i+1, err)
+		}
+		if reservedKey != periodBorrowKey(key, next) || !reservedWindow.Start.Equal(next.Start) {
+			t.Fatalf("borrow %d reserved %s in %v, want the next period's borrow key", i+1, reservedKey, reservedWindow.Start)
+		}
+	}
+	if allowed, _, _, _ := reservePeriodCountWithBorrow(ctx, key, 3, current, &next); allowed {
+		t.Fatal("request beyond the overage allowance allowed")
+	}
+	if count, _ := peekPeriodCount(ctx, key, current); count != 3 {
+		t.Fatalf("current period count = %d, want 3", count)
+	}
+
+	// 重置后借用的 2 次从新周期的上限中扣除
+	if allowed, _, _, _ := reservePeriodCountWithBorrow(ctx, key, 3, next, &after); !allowed {
+		t.Fatal("first request after reset rejected")
+	}
+	if allowed, _, _, _ := reservePeriodCountWithBorrow(ctx, key, 3, next, &after); allowed {
+		t.Fatal("request after reset allowed beyond the limit minus borrowed")
+	}
+	if count, _ := peekPeriodCount(ctx, key, next); count != 1 {
+		t.Fatalf("next period count = %d, want 1", count)
+	}
+}
+
+func TestPeriodBorrowOnlyNearReset(t *testing.T) {
+	common.SetRedisEnabled(false)
+	setDailyLimitOverage(t, 10, 2)
+	ctx := context.Background()
+
+	// 距离重置超过宽限时间时不借用
+	current, next, _ := periodWindows(time.Hour)
+	if allowed, _, _, _ := reservePeriodCountWithBorrow(ctx, "period-borrow-1882", 1, current, &next); !allowed {
+		t.Fatal("first request rejected")
+	}
+	if allowed, _, _, _ := reservePeriodCountWithBorrow(ctx, "period-borrow-1882", 1, current, &next); allowed {
+		t.Fatal("borrowed an hour before reset")
+	}
+
+	// 未开启时不借用；不传下一周期（滚动窗口计数）时不借用
+	current, next, _ = periodWindows(5 * time.Minute)
+	setting.DailyLimitOverageAllowance = 0
+	reservePeriodCountWithBorrow(ctx, "period-borrow-1883", 1, current, &next)
+	if allowed, _, _, _ := reservePeriodCountWithBorrow(ctx, "period-borrow-1883", 1, current, &next); allowed {
+		t.Fatal("borrowed with DailyLimitOverageAllowance = 0")
+	}
+	setting.DailyLimitOverageAllowance = 2
+	reservePeriodCountWithBorrow(ctx, "period-borrow-1884", 1, current, nil)
+	if allowed, _, _, _ := reservePeriodCountWithBorrow(ctx, "period-borrow-1884", 1, current, nil); allowed {
+		t.Fatal("borrowed without a next period")
+	}
+
+	// 撤销借用的请求时归还借用次数
+	reservePeriodCountWithBorrow(ctx, "period-borrow-1885", 1, current, &next)
+	allowed, reservedKey, reservedWindow, _ := reservePeriodCountWithBorrow(ctx, "period-borrow-1885", 1, current, &next)
+	if !allowed {
+		t.Fatal("borrow near reset rejected")
+	}
+	if err := releasePeriodCount(ctx, reservedKey, reservedWindow); err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := peekPeriodCount(ctx, periodBorrowKey("period-borrow-1885", next), next); count != 0 {
+		t.Fatalf("borrowed count after release = %d, want 0", count)
+	}
+}
+
+func TestReservePeriodCountRedisSetsExpiryAndGuardsRelease(t *testing.T) {
+	addr := os.Getenv("LIMITER_TEST_REDIS")
+	if addr == "" {
+		t.Skip("LIMITER_TEST_REDIS not set")
+	}
+	rdb := redis.NewClient(&redis.Options{Addr: addr})
+	t.Cleanup(func() { _ = rdb.Close() })
+	oldEnabled, oldRDB := common.RedisEnabled(), common.RDB()
+	common.SetRDB(rdb)
+	common.SetRedisEnabled(true)
+	t.Cleanup(func() {
+		common.SetRedisEnabled(oldEnabled)
+		common.SetRDB(oldRDB)
+	})
+
+	ctx := context.Background()
+	schedule, err := setting.ParseQuotaSchedule("weekly@mon")
+	if err != nil {
+		t.Fatal(err)
+	}
+	window := schedule.Window(time.Now())
+	key := fmt.Sprintf("rateLimit:test:period:%d", time.Now().UnixNano())
+	counterKey := periodCounterKey(key, window)
+	t.Cleanup(func() { rdb.Del(ctx, counterKey) })
+
+	for i := 0; i < 2; i++ {
+		if allowed, err := reservePeriodCount(ctx, key, 2, window); err != nil || !allowed {
+			t.Fatalf("request %d: allowed=%v err=%v", i+1, allowed, err)
+		}
+	}
+	if allowed, _ := reservePeriodCount(ctx, key, 2, window); allowed {
+		t.Fatal("request over the period limit allowed")
+	}
+	if ttl := rdb.TTL(ctx, counterKey).Val(); ttl <= 0 {
+		t.Fatalf("counter TTL = %v, want expiring at the period reset", ttl)
+	}
+
+	// 计数 key 已过期时撤销不会留下负数计数
+	rdb.Del(ctx, counterKey)
+	if err = releasePeriodCount(ctx, key, window); err != nil {
+		t.Fatal(err)
+	}
+	if n := rdb.Exists(ctx, counterKey).Val(); n != 0 {
+		t.Fatalf("release after expiry created the counter key")
+	}
+}
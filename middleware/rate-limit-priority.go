@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求优先级，通过 X-Request-Priority 请求头指定，未指定时为 normal
+const (
+	RequestPriorityLow    = "low"
+	RequestPriorityNormal = "normal"
+	RequestPriorityHigh   = "high"
+)
+
+func getRequestPriority(c *gin.Context) string {
+	switch strings.ToLower(strings.TrimSpace(c.GetHeader("X-Request-Priority"))) {
+	case RequestPriorityLow:
+		return RequestPriorityLow
+	case RequestPriorityHigh:
+		return RequestPriorityHigh
+	default:
+		return RequestPriorityNormal
+	}
+}
+
+// reservedForPriority 返回为高优先级请求预留的名额数，至少预留一个
+func reservedForPriority(maxCount int) int {
+	reserved := (maxCount*setting.RateLimitLowPriorityReservePercent + 99) / 100
+	if reserved < 1 {
+		reserved = 1
+	}
+	return reserved
+}
+
+// checkLowPriorityHeadroom 低优先级请求只能使用各限流维度中未预留的名额，
+// 任一维度的剩余名额已落入预留部分时拒绝，把余量留给高优先级请求。
+// 只读取当前状态，不消耗额度；实际扣减仍由后续的限流检查完成。
+func checkLowPriorityHeadroom(c *gin.Context) bool {
+	if setting.RateLimitLowPriorityReservePercent <= 0 || getRequestPriority(c) != RequestPriorityLow {
+		return true
+	}
+	var dimensions []rateLimitDimension
+	if tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId); tokenId != 0 {
+		dimensions = append(dimensions, tokenRateLimitDimensions(tokenId, common.GetContextKeyString(c, constant.ContextKeyTokenGroup))...)
+	}
+	dimensions = append(dimensions, userRateLimitDimensions(c.GetInt("id"), common.GetContextKeyString(c, constant.ContextKeyUserGroup))...)
+
+	ctx := context.Background()
+	for _, dimension := range dimensions {
+		// 优先级判断属于限流判定，始终查询主库
+		status, err := dimension.peek(ctx, common.RDB())
+		if err != nil {
+			logger.LogDebug(c, "peek rate limit for priority check failed: %s", err.Error())
+			continue
+		}
+		if status.Remaining <= reservedForPriority(dimension.maxCount) {
+			retryAfter := status.Reset
+			if retryAfter <= 0 {
+				retryAfter = 1
+			}
+			abortWithRateLimitMessage(c, "", retryAfter, "当前请求量接近限流上限，低优先级请求已被暂缓，请稍后重试")
+			return false
+		}
+	}
+	return true
+}
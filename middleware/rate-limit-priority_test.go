@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func TestLowPriorityThrottledNearLimit(t *testing.T) {
+	setupMemoryRateLimit(t, 4)
+	// 容量 4，为高优先级请求预留 2 个名额
+	setting.RateLimitLowPriorityReservePercent = 50
+	t.Cleanup(func() { setting.RateLimitLowPriorityReservePercent = 0 })
+	low := map[string]string{"X-Request-Priority": "low"}
+	high := map[string]string{"X-Request-Priority": "high"}
+
+	for i := 0; i < 2; i++ {
+		if w := serveModelRequest(1101, `{"model":"gpt-4o"}`, http.StatusOK, low); w.Code != http.StatusOK {
+			t.Fatalf("low priority request %d: status %d", i, w.Code)
+		}
+	}
+	w := serveModelRequest(1101, `{"model":"gpt-4o"}`, http.StatusOK, low)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("low priority request in reserved headroom: status %d, want 429", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("missing Retry-After")
+	}
+	if got := tokenTotalCount(1101); got != 2 {
+		t.Fatalf("rejected low priority request consumed quota: total count = %d, want 2", got)
+	}
+
+	// 预留的名额仍可供高优先级请求使用，用完后才被限流
+	for i := 0; i < 2; i++ {
+		if w := serveModelRequest(1101, `{"model":"gpt-4o"}`, http.StatusOK, high); w.Code != http.StatusOK {
+			t.Fatalf("high priority request %d: status %d", i, w.Code)
+		}
+	}
+	if w := serveModelRequest(1101, `{"model":"gpt-4o"}`, http.StatusOK, high); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("high priority request over limit: status %d, want 429", w.Code)
+	}
+}
+
+func TestPriorityIgnoredWithoutReserve(t *testing.T) {
+	setupMemoryRateLimit(t, 2)
+	low := map[string]string{"X-Request-Priority": "low"}
+	for i := 0; i < 2; i++ {
+		if w := serveModelRequest(1102, `{"model":"gpt-4o"}`, http.StatusOK, low); w.Code != http.StatusOK {
+			t.Fatalf("low priority request %d: status %d", i, w.Code)
+		}
+	}
+}
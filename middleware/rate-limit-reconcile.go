@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func init() {
+	common.RegisterRedisSwitchHook(func(rdb *redis.Client) {
+		seeded, err := SeedRedisFromMemoryLimiter(context.Background(), rdb)
+		if err != nil {
+			common.SysLog("failed to seed redis from memory rate limiter: " + err.Error())
+		}
+		common.SysLog(fmt.Sprintf("seeded %d rate limit keys from memory limiter into redis", seeded))
+	})
+}
+
+// memoryLimitTarget 描述内存限流 key 在 Redis 中对应的 key 及其限流参数
+type memoryLimitTarget struct {
+	redisKey string
+	bucket   bool // true 为总请求数令牌桶，false 为成功请求数列表
+	maxCount int
+	duration int64
+}
+
+// resolveMemoryLimitKey 将内存限流器的 key 映射为 Redis 中的 key，无法识别或无需迁移的 key 返回 false。
+// 成功请求数检查使用的 _check 临时 key 与全局 IP 限流的 key 只在短时间内有效，不做迁移
+func resolveMemoryLimitKey(key string) (memoryLimitTarget, bool) {
+	if strings.HasSuffix(key, "_check") {
+		return memoryLimitTarget{}, false
+	}
+	// 成功请求数标识是对应总请求数标识加 S，需先匹配
+	marks := []struct {
+		mark     string
+		bucket   bool
+		maxCount int
+		duration int64
+	}{
+		{ModelRequestRateLimitSuccessCountMark, false, setting.ModelRequestRateLimitSuccessCount, int64(setting.ModelRequestRateLimitDurationMinutes * 60)},
+		{ModelRequestRateLimitCountMark, true, setting.ModelRequestRateLimitCount, int64(setting.ModelRequestRateLimitDurationMinutes * 60)},
+		{TokenRateLimitSuccessCountMark, false, setting.TokenRateLimitSuccessCount, int64(setting.TokenRateLimitDurationMinutes * 60)},
+		{TokenRateLimitCountMark, true, setting.TokenRateLimitCount, int64(setting.TokenRateLimitDurationMinutes * 60)},
+		{TokenDailyRateLimitSuccessCountMark, false, setting.TokenDailyRateLimitSuccessCount, 86400},
+		{TokenDailyRateLimitCountMark, true, setting.TokenDailyRateLimitCount, 86400},
+		{UserDailyRateLimitSuccessCountMark, false, setting.UserDailyRateLimitSuccessCount, 86400},
+		{UserDailyRateLimitCountMark, true, setting.UserDailyRateLimitCount, 86400},
+		{OrgRateLimitSuccessCountMark, false, setting.OrgRateLimitSuccessCount, int64(setting.OrgRateLimitDurationMinutes * 60)},
+		{OrgRateLimitCountMark, true, setting.OrgRateLimitCount, int64(setting.OrgRateLimitDurationMinutes * 60)},
+	}
+	for _, m := range marks {
+		rest, ok := strings.CutPrefix(key, m.mark)
+		if !ok || rest == "" || rest[0] < '0' || rest[0] > '9' {
+			continue
+		}
+		target := memoryLimitTarget{
+			redisKey: fmt.Sprintf("rateLimit:%s:%s", m.mark, rest),
+			bucket:   m.bucket,
+			maxCount: m.maxCount,
+			duration: m.duration,
+		}
+		if m.mark == ModelRequestRateLimitCountMark {
+			// 用户总请求数令牌桶在 Redis 中不带标识
+			target.redisKey = "rateLimit:" + rest
+		}
+		if m.mark == TokenRateLimitCountMark && strings.Contains(rest, ":") {
+			target.maxCount = setting.TokenPerIPRateLimit
+		}
+		return target, target.duration > 0
+	}
+	return memoryLimitTarget{}, false
+}
+
+// replayTokenBucket 按内存中记录的请求时间依次重放令牌桶，得到与 Redis 令牌桶等价的剩余令牌数
+func replayTokenBucket(timestamps []int64, maxCount int, duration int64, now int64) float64 {
+	rate := float64(maxCount)
+	capacity := rate * float64(duration)
+	requested := float64(duration)
+	tokens := capacity
+	last := timestamps[0]
+	for _, t := range timestamps {
+		tokens = math.Min(capacity, tokens+float64(t-last)*rate)
+		last = t
+		if tokens >= requested {
+			tokens -= requested
+		}
+	}
+	return math.Min(capacity, tokens+float64(now-last)*rate)
+}
+
+// SeedRedisFromMemoryLimiter 将内存限流器中仍在时间窗口内的计数写入 Redis，用于运行时从内存切换到 Redis，
+// Redis 中已存在的 key 不会被覆盖。返回写入的 key 数量
+func SeedRedisFromMemoryLimiter(ctx context.Context, rdb *redis.Client) (int, error) {
+	now := time.Now().Unix()
+	seeded := 0
+	for key, timestamps := range inMemoryRateLimiter.Export() {
+		target, ok := resolveMemoryLimitKey(key)
+		if !ok {
+			continue
+		}
+		var live []int64
+		for _, t := range timestamps {
+			if now-t < target.duration {
+				live = append(live, t)
+			}
+		}
+		ok, err := seedRedisLimitKey(ctx, rdb, target, live, now)
+		if err != nil {
+			return seeded, err
+		}
+		if ok {
+			seeded++
+		}
+	}
+	return seeded, nil
+}
+
+// seedRedisLimitKey 按窗口内的请求时间（从旧到新）写入 Redis 中的限流 key，key 已存在或无需写入时返回 false
+func seedRedisLimitKey(ctx context.Context, rdb *redis.Client, target memoryLimitTarget, live []int64, now int64) (bool, error) {
+	if len(live) == 0 {
+		return false, nil
+	}
+	exists, err := rdb.Exists(ctx, target.redisKey).Result()
+	if err != nil {
+		return false, err
+	}
+	if exists > 0 {
+		return false, nil
+	}
+	if target.bucket {
+		if target.maxCount <= 0 {
+			return false, nil
+		}
+		tokens := replayTokenBucket(live, target.maxCount, target.duration, now)
+		capacity := float64(target.maxCount) * float64(target.duration)
+		if tokens >= capacity {
+			return false, nil
+		}
+		ttl := time.Duration(math.Ceil((capacity-tokens)/float64(target.maxCount))+1) * time.Second
+		pipe := rdb.TxPipeline()
+		pipe.HSet(ctx, target.redisKey, "tokens", tokens, "last_time", now)
+		pipe.Expire(ctx, target.redisKey, ttl)
+		if _, err = pipe.Exec(ctx); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	// Redis 列表头部为最新的记录，按从旧到新的顺序 LPUSH
+	values := make([]interface{}, 0, len(live))
+	for _, t := range live {
+		values = append(values, time.Unix(t, 0).Format(timeFormat))
+	}
+	pipe := rdb.TxPipeline()
+	pipe.LPush(ctx, target.redisKey, values...)
+	if target.maxCount > 0 {
+		pipe.LTrim(ctx, target.redisKey, 0, int64(target.maxCount-1))
+	}
+	pipe.Expire(ctx, target.redisKey, time.Duration(target.duration)*time.Second)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,302 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// fakeRedis 只实现测试用到的命令的 Redis 服务端
+type fakeRedis struct {
+	mu      sync.Mutex
+	hashes  map[string]map[string]string
+	lists   map[string][]string
+	expires map[string]bool // 设置过过期时间的 key
+
+	commands     map[string]int   // 各命令收到的次数
+	scanPageSize int              // 大于 0 时 SCAN 每次最多返回的 key 数，用于测试游标遍历
+	idle         map[string]int64 // OBJECT IDLETIME 返回的闲置秒数，未设置时为 0
+}
+
+func startFakeRedis(t *testing.T) (*fakeRedis, *redis.Client) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeRedis{hashes: map[string]map[string]string{}, lists: map[string][]string{}, expires: map[string]bool{}, commands: map[string]int{}, idle: map[string]int64{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	rdb := redis.NewClient(&redis.Options{Addr: ln.Addr().String()})
+	t.Cleanup(func() {
+		_ = rdb.Close()
+		_ = ln.Close()
+	})
+	return f, rdb
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	var queued [][]string
+	inTx := false
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return
+		}
+		switch strings.ToUpper(args[0]) {
+		case "MULTI":
+			inTx = true
+			queued = nil
+			io.WriteString(conn, "+OK\r\n")
+		case "EXEC":
+			reply := fmt.Sprintf("*%d\r\n", len(queued))
+			for _, cmd := range queued {
+				reply += f.exec(cmd)
+			}
+			inTx = false
+			io.WriteString(conn, reply)
+		default:
+			if inTx {
+				queued = append(queued, args)
+				io.WriteString(conn, "+QUEUED\r\n")
+				continue
+			}
+			io.WriteString(conn, f.exec(args))
+		}
+	}
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.commands[strings.ToUpper(args[0])]++
+	switch strings.ToUpper(args[0]) {
+	case "PING":
+		return "+PONG\r\n"
+	case "EXISTS":
+		_, isHash := f.hashes[args[1]]
+		_, isList := f.lists[args[1]]
+		if isHash || isList {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "HSET":
+		h := f.hashes[args[1]]
+		if h == nil {
+			h = map[string]string{}
+			f.hashes[args[1]] = h
+		}
+		for i := 2; i+1 < len(args); i += 2 {
+			h[args[i]] = args[i+1]
+		}
+		return fmt.Sprintf(":%d\r\n", (len(args)-2)/2)
+	case "LPUSH":
+		for _, v := range args[2:] {
+			f.lists[args[1]] = append([]string{v}, f.lists[args[1]]...)
+		}
+		return fmt.Sprintf(":%d\r\n", len(f.lists[args[1]]))
+	case "LTRIM":
+		stop, _ := strconv.Atoi(args[3])
+		if list := f.lists[args[1]]; stop+1 < len(list) {
+			f.lists[args[1]] = list[:stop+1]
+		}
+		return "+OK\r\n"
+	case "LLEN":
+		return fmt.Sprintf(":%d\r\n", len(f.lists[args[1]]))
+	case "LINDEX":
+		list := f.lists[args[1]]
+		index, _ := strconv.Atoi(args[2])
+		if index < 0 {
+			index += len(list)
+		}
+		if index < 0 || index >= len(list) {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(list[index]), list[index])
+	case "LRANGE":
+		list := f.lists[args[1]]
+		start, _ := strconv.Atoi(args[2])
+		stop, _ := strconv.Atoi(args[3])
+		// 只支持非负的 start，stop 为负数或越界时取到列表末尾
+		if stop < 0 || stop >= len(list) {
+			stop = len(list) - 1
+		}
+		reply := fmt.Sprintf("*%d\r\n", max(stop-start+1, 0))
+		for i := start; i <= stop; i++ {
+			reply += fmt.Sprintf("$%d\r\n%s\r\n", len(list[i]), list[i])
+		}
+		return reply
+	case "EXPIRE":
+		f.expires[args[1]] = true
+		return ":1\r\n"
+	case "TTL":
+		_, isHash := f.hashes[args[1]]
+		_, isList := f.lists[args[1]]
+		switch {
+		case !isHash && !isList:
+			return ":-2\r\n"
+		case f.expires[args[1]]:
+			return ":60\r\n"
+		}
+		return ":-1\r\n"
+	case "DEL":
+		removed := 0
+		for _, key := range args[1:] {
+			_, isHash := f.hashes[key]
+			_, isList := f.lists[key]
+			if isHash || isList {
+				removed++
+			}
+			delete(f.hashes, key)
+			delete(f.lists, key)
+			delete(f.expires, key)
+			delete(f.idle, key)
+		}
+		return fmt.Sprintf(":%d\r\n", removed)
+	case "OBJECT":
+		// 只支持 OBJECT IDLETIME
+		_, isHash := f.hashes[args[2]]
+		_, isList := f.lists[args[2]]
+		if strings.ToUpper(args[1]) != "IDLETIME" || (!isHash && !isList) {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", f.idle[args[2]])
+	case "SCAN":
+		// 按 key 排序后从游标位置开始返回，未设置 scanPageSize 时一次返回全部 key；只支持前缀匹配的 MATCH
+		cursor, _ := strconv.Atoi(args[1])
+		prefix := ""
+		for i := 2; i+1 < len(args); i += 2 {
+			if strings.ToUpper(args[i]) == "MATCH" {
+				prefix = strings.TrimSuffix(args[i+1], "*")
+			}
+		}
+		var keys []string
+		for key := range f.hashes {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		for key := range f.lists {
+			if strings.HasPrefix(key, prefix) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		keys = keys[min(cursor, len(keys)):]
+		next := 0
+		if f.scanPageSize > 0 && len(keys) > f.scanPageSize {
+			keys = keys[:f.scanPageSize]
+			next = cursor + f.scanPageSize
+		}
+		nextCursor := strconv.Itoa(next)
+		reply := fmt.Sprintf("*2\r\n$%d\r\n%s\r\n*%d\r\n", len(nextCursor), nextCursor, len(keys))
+		for _, key := range keys {
+			reply += fmt.Sprintf("$%d\r\n%s\r\n", len(key), key)
+		}
+		return reply
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		value, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(value, "\r\n")
+	}
+	return args, nil
+}
+
+func TestEnableRedisSeedsMemoryCounts(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	setting.TokenRateLimitSuccessCount = 5
+	t.Cleanup(func() {
+		setting.TokenRateLimitSuccessCount = 0
+		common.SetRedisEnabled(false)
+		common.SetRDB(nil)
+	})
+
+	for i := 0; i < 3; i++ {
+		if w := serveModelRequest(1431, fmt.Sprintf(`{"model":"gpt-4o","n":%d}`, i), http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+	}
+
+	f, rdb := startFakeRedis(t)
+	common.EnableRedis(rdb)
+	if !common.RedisEnabled() || common.RDB() != rdb {
+		t.Fatal("EnableRedis did not switch to redis")
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// 三次请求在同一秒内：令牌桶容量 10*60，每次消耗 60
+	bucket := f.hashes["rateLimit:"+TokenRateLimitCountMark+":1431"]
+	if bucket == nil {
+		t.Fatal("total count bucket not seeded")
+	}
+	if tokens, _ := strconv.ParseFloat(bucket["tokens"], 64); tokens != 420 {
+		t.Fatalf("seeded tokens = %v, want 420", bucket["tokens"])
+	}
+	if got := len(f.lists["rateLimit:"+TokenRateLimitSuccessCountMark+":1431"]); got != 3 {
+		t.Fatalf("seeded success records = %d, want 3", got)
+	}
+}
+
+func TestEnableRedisKeepsExistingKeys(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	t.Cleanup(func() {
+		common.SetRedisEnabled(false)
+		common.SetRDB(nil)
+	})
+	serveModelRequest(1432, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+
+	f, rdb := startFakeRedis(t)
+	key := "rateLimit:" + TokenRateLimitCountMark + ":1432"
+	if err := rdb.HSet(context.Background(), key, "tokens", 1, "last_time", 0).Err(); err != nil {
+		t.Fatal(err)
+	}
+	common.EnableRedis(rdb)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if got := f.hashes[key]["tokens"]; got != "1" {
+		t.Fatalf("existing key overwritten: tokens = %s", got)
+	}
+}
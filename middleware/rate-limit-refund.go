@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+const rateLimitConsumedContextKey = "rate_limit_consumed"
+
+// rateLimitConsumption 本次请求已消耗的一个总请求数名额，客户端在上游响应前取消或被后续的限流拒绝时退还
+type rateLimitConsumption struct {
+	key     string
+	opts    []limiter.Option                // Redis 令牌桶放行时的参数，内存模式为 nil
+	release func(ctx context.Context) error // 按固定周期计数时的撤销方式，不为空时优先使用
+}
+
+func trackRateLimitConsumed(c *gin.Context, consumption rateLimitConsumption) {
+	consumed, _ := c.Get(rateLimitConsumedContextKey)
+	list, _ := consumed.([]rateLimitConsumption)
+	c.Set(rateLimitConsumedContextKey, append(list, consumption))
+}
+
+// rateLimitConsumedCount 返回本次请求目前已消耗的总请求数名额个数，配合 refundRateLimitConsumedSince 使用
+func rateLimitConsumedCount(c *gin.Context) int {
+	consumed, _ := c.Get(rateLimitConsumedContextKey)
+	list, _ := consumed.([]rateLimitConsumption)
+	return len(list)
+}
+
+// refundRateLimitConsumedSince 退还第 from 个之后消耗的总请求数名额，并从记录中移除，避免取消时重复退还
+func refundRateLimitConsumedSince(c *gin.Context, from int) {
+	consumed, _ := c.Get(rateLimitConsumedContextKey)
+	list, _ := consumed.([]rateLimitConsumption)
+	if from >= len(list) {
+		return
+	}
+	for _, consumption := range list[from:] {
+		refundRateLimitConsumption(consumption)
+	}
+	c.Set(rateLimitConsumedContextKey, list[:from])
+}
+
+func refundRateLimitConsumption(consumption rateLimitConsumption) {
+	ctx := context.Background()
+	var err error
+	switch {
+	case consumption.release != nil:
+		err = consumption.release(ctx)
+	case consumption.opts == nil:
+		inMemoryRateLimiter.Refund(consumption.key)
+	default:
+		err = limiter.New(ctx, common.RDB()).Refund(ctx, consumption.key, consumption.opts...)
+	}
+	if err != nil {
+		common.SysLog("failed to refund rate limit consumption: " + err.Error())
+	}
+}
+
+// memoryReserve 内存版本的总请求数检查，放行时记录消耗以便取消后退还
+func memoryReserve(c *gin.Context, key string, maxCount int, duration int64) bool {
+	if isTotalCountForgiven(c) {
+		return true
+	}
+	if !inMemoryRateLimiter.Request(key, maxCount, duration) {
+		return false
+	}
+	trackRateLimitConsumed(c, rateLimitConsumption{key: key})
+	return true
+}
+
+// isCancelledBeforeUpstream 客户端是否在收到任何上游响应之前取消了请求
+func isCancelledBeforeUpstream(c *gin.Context) bool {
+	if !setting.RateLimitRefundOnCancelEnabled || c.Request.Context().Err() == nil {
+		return false
+	}
+	return !common.GetContextKeyBool(c, constant.ContextKeyUpstreamResponded)
+}
+
+// refundCancelledRequest 请求在上游响应前被客户端取消时，退还本次请求消耗的总请求数名额
+func refundCancelledRequest(c *gin.Context) {
+	if !isCancelledBeforeUpstream(c) {
+		return
+	}
+	refundRateLimitConsumedSince(c, 0)
+}
@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveCancelledRequest 发送一次在处理过程中被客户端取消的请求，responded 表示取消前是否已收到上游响应
+func serveCancelledRequest(tokenId int, responded bool) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyTokenId, tokenId)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "default")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		c.Next()
+	}, ModelRequestRateLimit(), func(c *gin.Context) {
+		if responded {
+			common.SetContextKey(c, constant.ContextKeyUpstreamResponded, true)
+		}
+		cancel()
+		c.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`)).WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+}
+
+func TestCancelledBeforeUpstreamRefunded(t *testing.T) {
+	setupMemoryRateLimit(t, 2)
+	setting.TokenRateLimitSuccessCount = 5
+	setting.RateLimitRefundOnCancelEnabled = true
+	t.Cleanup(func() {
+		setting.TokenRateLimitSuccessCount = 0
+		setting.RateLimitRefundOnCancelEnabled = false
+	})
+
+	serveCancelledRequest(1511, false)
+	if got := tokenTotalCount(1511); got != 0 {
+		t.Fatalf("cancelled request counted %d times, want the slot refunded", got)
+	}
+	if count, _ := inMemoryRateLimiter.Peek(TokenRateLimitSuccessCountMark+"1511", 60); count != 0 {
+		t.Fatalf("cancelled request recorded %d successes, want 0", count)
+	}
+
+	// 退还后仍可使用全部名额
+	for i := 0; i < 2; i++ {
+		if w := serveModelRequest(1511, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d after refund: status %d", i+1, w.Code)
+		}
+	}
+}
+
+func TestCancelledAfterUpstreamResponseCounted(t *testing.T) {
+	setupMemoryRateLimit(t, 2)
+	setting.RateLimitRefundOnCancelEnabled = true
+	t.Cleanup(func() { setting.RateLimitRefundOnCancelEnabled = false })
+
+	serveCancelledRequest(1512, true)
+	if got := tokenTotalCount(1512); got != 1 {
+		t.Fatalf("request cancelled after the upstream responded counted %d times, want 1", got)
+	}
+}
+
+func TestCancelledRequestCountedWhenRefundDisabled(t *testing.T) {
+	setupMemoryRateLimit(t, 2)
+	serveCancelledRequest(1513, false)
+	if got := tokenTotalCount(1513); got != 1 {
+		t.Fatalf("cancelled request counted %d times with refunds off, want 1", got)
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 限流状态查询（看板轮询、状态接口等）使用的只读 Redis 副本，限流判定与计数始终使用主库
+var (
+	rateLimitReplicaMutex  sync.Mutex
+	rateLimitReplicaAddr   string
+	rateLimitReplicaClient *redis.Client
+)
+
+// newRateLimitReplicaClient 根据地址创建副本客户端：redis:// 开头时按连接串解析，
+// 否则视为 host:port，其余连接参数（密码、DB 等）与主库相同
+func newRateLimitReplicaClient(addr string) (*redis.Client, error) {
+	var opt *redis.Options
+	if strings.HasPrefix(addr, "redis://") || strings.HasPrefix(addr, "rediss://") {
+		var err error
+		opt, err = redis.ParseURL(addr)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		opt = common.RDB().Options()
+		copied := *opt
+		opt = &copied
+		opt.Addr = addr
+	}
+	return redis.NewClient(opt), nil
+}
+
+// rateLimitReadClient 返回限流状态查询使用的 Redis 客户端，未配置 RateLimitReadReplicaAddr 或副本不可用时使用主库
+func rateLimitReadClient() *redis.Client {
+	addr := setting.RateLimitReadReplicaAddr
+	rateLimitReplicaMutex.Lock()
+	defer rateLimitReplicaMutex.Unlock()
+	if addr != rateLimitReplicaAddr {
+		if rateLimitReplicaClient != nil {
+			_ = rateLimitReplicaClient.Close()
+			rateLimitReplicaClient = nil
+		}
+		rateLimitReplicaAddr = addr
+		if addr != "" {
+			client, err := newRateLimitReplicaClient(addr)
+			if err != nil {
+				common.SysLog("failed to create rate limit read replica client, using primary: " + err.Error())
+			} else {
+				rateLimitReplicaClient = client
+			}
+		}
+	}
+	if rateLimitReplicaClient == nil {
+		return common.RDB()
+	}
+	return rateLimitReplicaClient
+}
@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func TestRateLimitStatusReadsFromReplica(t *testing.T) {
+	setupMemoryRateLimit(t, 0)
+	setting.TokenRateLimitSuccessCount = 5
+	primary, primaryClient := startFakeRedis(t)
+	replica, replicaClient := startFakeRedis(t)
+	oldRDB := common.RDB()
+	common.SetRDB(primaryClient)
+	common.SetRedisEnabled(true)
+	setting.RateLimitReadReplicaAddr = replicaClient.Options().Addr
+	t.Cleanup(func() {
+		setting.TokenRateLimitSuccessCount = 0
+		setting.RateLimitReadReplicaAddr = ""
+		rateLimitReadClient()
+		common.SetRDB(oldRDB)
+		common.SetRedisEnabled(false)
+	})
+
+	// 限流判定与计数写入主库
+	if w := serveModelRequest(1391, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	key := "rateLimit:" + TokenRateLimitSuccessCountMark + ":1391"
+	primary.mu.Lock()
+	primaryCount := len(primary.lists[key])
+	primary.mu.Unlock()
+	if primaryCount != 1 {
+		t.Fatalf("primary success list length = %d, want 1", primaryCount)
+	}
+
+	// 状态查询读取副本
+	now := time.Now().Format(timeFormat)
+	replica.mu.Lock()
+	replicaCount := len(replica.lists[key])
+	replica.lists[key] = []string{now, now, now}
+	replica.mu.Unlock()
+	if replicaCount != 0 {
+		t.Fatalf("enforcement wrote %d entries to the replica", replicaCount)
+	}
+	statuses, err := GetRateLimitStatuses(1391, "default", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Remaining != 2 {
+		t.Fatalf("statuses from replica = %+v, want remaining 2", statuses)
+	}
+
+	// 未配置副本时回退到主库
+	setting.RateLimitReadReplicaAddr = ""
+	if statuses, err = GetRateLimitStatuses(1391, "default", 0, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(statuses) != 1 || statuses[0].Remaining != 4 {
+		t.Fatalf("statuses from primary = %+v, want remaining 4", statuses)
+	}
+}
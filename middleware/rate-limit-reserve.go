@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 每日成功请求数限制在检查时即原子地预占名额，避免高并发下多个请求同时通过检查、
+// 在记录前一起放行导致超出限制；请求最终未成功时撤销预占
+const rateLimitSuccessReservationsContextKey = "rate_limit_success_reservations"
+
+type successReservation struct {
+	key     string // 成功请求数限制的 key，请求成功后据此跳过重复记录
+	release func(ctx context.Context) error
+}
+
+// addSuccessReservation 记录本次请求预占的成功请求名额及撤销方式
+func addSuccessReservation(c *gin.Context, key string, release func(ctx context.Context) error) {
+	reservations, _ := c.Get(rateLimitSuccessReservationsContextKey)
+	list, _ := reservations.([]successReservation)
+	c.Set(rateLimitSuccessReservationsContextKey, append(list, successReservation{key: key, release: release}))
+}
+
+// reserveRedisSuccess 原子地检查并预占一次成功请求名额，预占成功后由 recordRedisSuccess 的调用方跳过记录
+func reserveRedisSuccess(ctx context.Context, c *gin.Context, key string, maxCount int, duration int64) (bool, error) {
+	now := time.Now()
+	member := now.Format(timeFormat)
+	windowStart := now.Add(-time.Duration(duration) * time.Second).Format(timeFormat)
+	rl := limiter.New(ctx, common.RDB())
+	allowed, err := rl.SlidingWindowReserve(ctx, key, maxCount, member, windowStart, time.Duration(duration)*time.Second)
+	if err != nil || !allowed {
+		return allowed, err
+	}
+	addSuccessReservation(c, key, func(ctx context.Context) error {
+		return rl.SlidingWindowRelease(ctx, key, member)
+	})
+	return true, nil
+}
+
+// hasSuccessReservation 判断本次请求是否已在检查时预占了 key 的成功请求名额
+func hasSuccessReservation(c *gin.Context, key string) bool {
+	reservations, _ := c.Get(rateLimitSuccessReservationsContextKey)
+	list, _ := reservations.([]successReservation)
+	for _, reservation := range list {
+		if reservation.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseSuccessReservations 请求被后续的限流拒绝或最终未成功时，撤销已预占的成功请求名额
+func releaseSuccessReservations(c *gin.Context) {
+	reservations, ok := c.Get(rateLimitSuccessReservationsContextKey)
+	if !ok || isRateLimitSuccess(c) {
+		return
+	}
+	ctx := context.Background()
+	for _, reservation := range reservations.([]successReservation) {
+		if err := reservation.release(ctx); err != nil {
+			common.SysLog("failed to release rate limit success reservation: " + err.Error())
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reservationContext 返回预占了 keys 的请求上下文，以及各 key 被撤销的次数
+func reservationContext(status int, keys ...string) (*gin.Context, map[string]int) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	released := map[string]int{}
+	for _, key := range keys {
+		addSuccessReservation(c, key, func(ctx context.Context) error {
+			released[key]++
+			return nil
+		})
+	}
+	c.Status(status)
+	c.Writer.WriteHeaderNow()
+	return c, released
+}
+
+func TestHasSuccessReservation(t *testing.T) {
+	c, _ := reservationContext(http.StatusOK, "rateLimit:TDS:1", "rateLimit:UDS:1")
+	for key, want := range map[string]bool{"rateLimit:TDS:1": true, "rateLimit:UDS:1": true, "rateLimit:TDS:2": false} {
+		if got := hasSuccessReservation(c, key); got != want {
+			t.Errorf("hasSuccessReservation(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
+
+func TestReleaseSuccessReservations(t *testing.T) {
+	// 请求失败时撤销全部预占
+	c, released := reservationContext(http.StatusBadGateway, "rateLimit:TDS:1", "rateLimit:UDS:1")
+	releaseSuccessReservations(c)
+	if released["rateLimit:TDS:1"] != 1 || released["rateLimit:UDS:1"] != 1 {
+		t.Fatalf("failed request released %v, want each reservation once", released)
+	}
+
+	// 请求成功时预占即为成功记录，不撤销
+	c, released = reservationContext(http.StatusOK, "rateLimit:TDS:1")
+	releaseSuccessReservations(c)
+	if len(released) != 0 {
+		t.Fatalf("successful request released %v, want nothing", released)
+	}
+
+	// 没有预占时无事可做
+	c, _ = reservationContext(http.StatusBadGateway)
+	releaseSuccessReservations(c)
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func setRateLimitResetHeaderFormat(t *testing.T, format string) {
+	t.Helper()
+	old := setting.RateLimitResetHeaderFormat
+	setting.RateLimitResetHeaderFormat = format
+	t.Cleanup(func() { setting.RateLimitResetHeaderFormat = old })
+}
+
+func TestRateLimitResetHeaderValue(t *testing.T) {
+	now := int64(1_700_000_000)
+	status := RateLimitStatus{Window: 60, ResetAt: now + 42}
+
+	setRateLimitResetHeaderFormat(t, setting.RateLimitResetFormatUnix)
+	if got := rateLimitResetHeaderValue(status, now); got != now+42 {
+		t.Fatalf("unix reset = %d, want %d", got, now+42)
+	}
+
+	setting.RateLimitResetHeaderFormat = setting.RateLimitResetFormatDeltaSeconds
+	if got := rateLimitResetHeaderValue(status, now); got != 42 {
+		t.Fatalf("delta-seconds reset = %d, want 42", got)
+	}
+	// 时刻已过去时不返回负数
+	if got := rateLimitResetHeaderValue(RateLimitStatus{ResetAt: now - 5}, now); got != 0 {
+		t.Fatalf("past reset = %d, want 0", got)
+	}
+}
+
+func TestCheckRateLimitResetHeaderFormat(t *testing.T) {
+	for _, value := range []string{setting.RateLimitResetFormatUnix, setting.RateLimitResetFormatDeltaSeconds} {
+		if err := setting.CheckRateLimitResetHeaderFormat(value); err != nil {
+			t.Errorf("CheckRateLimitResetHeaderFormat(%q) = %v", value, err)
+		}
+	}
+	if err := setting.CheckRateLimitResetHeaderFormat("seconds"); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
+
+func TestRateLimitResetHeaderFormats(t *testing.T) {
+	setupMemoryRateLimit(t, 5)
+	setAlwaysSendRateLimitHeaders(t, true)
+
+	// 第一次请求即为最早一次计数，重置时刻为请求时间加上一分钟窗口
+	setRateLimitResetHeaderFormat(t, setting.RateLimitResetFormatUnix)
+	before := time.Now().Unix()
+	w := serveRelayResponse(1981, false)
+	after := time.Now().Unix()
+	resetAt, err := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil || resetAt < before+60 || resetAt > after+60 {
+		t.Fatalf("unix X-RateLimit-Reset = %q, want within [%d, %d]", w.Header().Get("X-RateLimit-Reset"), before+60, after+60)
+	}
+
+	// 后续请求不改变最早一次计数，两种格式指向同一时刻
+	setting.RateLimitResetHeaderFormat = setting.RateLimitResetFormatDeltaSeconds
+	now := time.Now().Unix()
+	w = serveRelayResponse(1981, false)
+	delta, err := strconv.ParseInt(w.Header().Get("X-RateLimit-Reset"), 10, 64)
+	if err != nil || delta < resetAt-time.Now().Unix() || delta > resetAt-now {
+		t.Fatalf("delta-seconds X-RateLimit-Reset = %q, want %d", w.Header().Get("X-RateLimit-Reset"), resetAt-now)
+	}
+	if got := w.Header().Get("X-RateLimit-Remaining"); got != "3" {
+		t.Fatalf("X-RateLimit-Remaining = %q, want 3", got)
+	}
+}
+
+func TestRateLimitResetAtMinuteAndDaily(t *testing.T) {
+	oldest := time.Now().Unix() - 30
+	minute := rateLimitDimension{name: "minute", maxCount: 10, duration: 60}
+	daily := rateLimitDimension{name: "daily", maxCount: 100, duration: 86400}
+
+	// 两个窗口都按最早一次计数加上窗口长度计算
+	if got := minute.resetAt(3, oldest, 0); got != oldest+60 {
+		t.Errorf("minute resetAt = %d, want %d", got, oldest+60)
+	}
+	if got := daily.resetAt(3, oldest, 0); got != oldest+86400 {
+		t.Errorf("daily resetAt = %d, want %d", got, oldest+86400)
+	}
+	// 没有计数时为当前时间
+	before := time.Now().Unix()
+	if got := daily.resetAt(0, 0, 0); got < before || got > time.Now().Unix() {
+		t.Errorf("unused resetAt = %d, want now", got)
+	}
+}
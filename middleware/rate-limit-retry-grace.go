@@ -0,0 +1,115 @@
+package middleware
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 请求失败后短时间内的重试不按两次独立请求计数：同一令牌在分钟级限流窗口内的前 RetryGraceCount 次重试不再计入总请求数，
+// 其他限流检查照常进行。只有窗口内相同的请求（令牌、路径与请求体一致）刚刚通过限流并失败过才视为重试，仅凭请求头不能获得宽限。
+// 与 RateLimitDedupWindowMs 不同，只有失败后的重试才会被宽限，且宽限次数有上限
+const (
+	RetryGraceCountMark = "RG"
+
+	rateLimitRetryKeyPrefix      = "rateLimit:retry:"
+	rateLimitRetryHashContextKey = "rate_limit_retry_hash"
+	rateLimitRetryArmedKey       = "rate_limit_retry_armed"
+)
+
+var (
+	rateLimitRetryMutex  sync.Mutex
+	rateLimitRetryMemory = map[string]time.Time{} // 失败请求的哈希 -> 过期时间
+)
+
+func retryGraceWindow(now time.Time) setting.QuotaWindow {
+	return setting.FixedWindow(now, time.Duration(setting.TokenRateLimitDurationMinutes)*time.Minute)
+}
+
+// isFailedAttempt 相同的请求是否在窗口内刚刚失败过
+func isFailedAttempt(hash string) bool {
+	if common.RedisEnabled() {
+		exists, err := common.RDB().Exists(context.Background(), rateLimitRetryKeyPrefix+hash).Result()
+		if err != nil {
+			common.SysLog("failed to check retried request: " + err.Error())
+			return false
+		}
+		return exists > 0
+	}
+	rateLimitRetryMutex.Lock()
+	defer rateLimitRetryMutex.Unlock()
+	expireAt, ok := rateLimitRetryMemory[hash]
+	if !ok {
+		return false
+	}
+	if time.Now().After(expireAt) {
+		delete(rateLimitRetryMemory, hash)
+		return false
+	}
+	return true
+}
+
+// forgiveRetry 请求是失败请求的重试且令牌在当前窗口内的宽限次数未用完时返回 true，调用方不再计入总请求数。
+// 不是重试的请求记录哈希，请求失败后其重试可以被识别
+func forgiveRetry(c *gin.Context) bool {
+	if setting.RetryGraceCount <= 0 {
+		return false
+	}
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	if tokenId == 0 {
+		return false
+	}
+	hash := requestBodyHash(c, tokenId)
+	if hash == "" || !isFailedAttempt(hash) {
+		c.Set(rateLimitRetryHashContextKey, hash)
+		return false
+	}
+	key := "rateLimit:" + RetryGraceCountMark + ":" + strconv.Itoa(tokenId)
+	allowed, err := reservePeriodCount(context.Background(), key, setting.RetryGraceCount, retryGraceWindow(time.Now()))
+	if err != nil {
+		common.SysLog("failed to check retry grace: " + err.Error())
+		return false
+	}
+	return allowed
+}
+
+// armRetryGrace 请求通过限流检查后调用，之后请求失败时才记录为可被宽限重试的失败请求；被限流拒绝的请求不记录
+func armRetryGrace(c *gin.Context) {
+	if c.GetString(rateLimitRetryHashContextKey) != "" {
+		c.Set(rateLimitRetryArmedKey, true)
+	}
+}
+
+// recordFailedAttempt 请求结束后调用，通过限流检查但最终失败的请求记录哈希，窗口内相同的请求视为重试
+func recordFailedAttempt(c *gin.Context) {
+	hash := c.GetString(rateLimitRetryHashContextKey)
+	if hash == "" || !c.GetBool(rateLimitRetryArmedKey) || isRateLimitSuccess(c) {
+		return
+	}
+	now := time.Now()
+	window := retryGraceWindow(now)
+	if common.RedisEnabled() {
+		if err := common.RDB().Set(context.Background(), rateLimitRetryKeyPrefix+hash, 1, time.Until(window.Reset)).Err(); err != nil {
+			common.SysLog("failed to record failed request for retry grace: " + err.Error())
+		}
+		return
+	}
+	rateLimitRetryMutex.Lock()
+	defer rateLimitRetryMutex.Unlock()
+	// 记录较多时顺带清理已过期的记录，避免内存无限增长
+	if len(rateLimitRetryMemory) >= 1024 {
+		for key, expireAt := range rateLimitRetryMemory {
+			if now.After(expireAt) {
+				delete(rateLimitRetryMemory, key)
+			}
+		}
+	}
+	rateLimitRetryMemory[hash] = window.Reset
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func setupRetryGrace(t *testing.T, count int) {
+	t.Helper()
+	setting.RetryGraceCount = count
+	t.Cleanup(func() {
+		setting.RetryGraceCount = 0
+		rateLimitRetryMemory = map[string]time.Time{}
+		periodCounters = map[string]*periodCounter{}
+	})
+}
+
+func TestRetryAfterFailureIsForgivenOnce(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	setupRetryGrace(t, 1)
+	body := `{"model":"gpt-4o","retry":true}`
+
+	serveModelRequest(1921, body, http.StatusBadGateway, nil)
+	if got := tokenTotalCount(1921); got != 1 {
+		t.Fatalf("first attempt count = %d, want 1", got)
+	}
+	serveModelRequest(1921, body, http.StatusBadGateway, nil)
+	if got := tokenTotalCount(1921); got != 1 {
+		t.Fatalf("quick retry count = %d, want 1 (forgiven)", got)
+	}
+	serveModelRequest(1921, body, http.StatusOK, nil)
+	if got := tokenTotalCount(1921); got != 2 {
+		t.Fatalf("third attempt count = %d, want 2", got)
+	}
+}
+
+func TestRetryHeaderAloneIsNotForgiven(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	setupRetryGrace(t, 3)
+	headers := map[string]string{"X-Stainless-Retry-Count": "1"}
+
+	serveModelRequest(1922, `{"model":"gpt-4o"}`, http.StatusOK, headers)
+	serveModelRequest(1922, `{"model":"gpt-4o","n":2}`, http.StatusOK, headers)
+	if got := tokenTotalCount(1922); got != 2 {
+		t.Fatalf("count = %d, want 2", got)
+	}
+}
+
+func TestForgivenRetryStillChecksSuccessLimit(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	setupRetryGrace(t, 3)
+	setting.TokenRateLimitSuccessCount = 1
+	t.Cleanup(func() { setting.TokenRateLimitSuccessCount = 0 })
+
+	serveModelRequest(1923, `{"model":"gpt-4o","a":1}`, http.StatusBadGateway, nil)
+	serveModelRequest(1923, `{"model":"gpt-4o","b":1}`, http.StatusOK, nil)
+	if w := serveModelRequest(1923, `{"model":"gpt-4o","a":1}`, http.StatusOK, nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("forgiven retry over success limit: status %d, want 429", w.Code)
+	}
+}
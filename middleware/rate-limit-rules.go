@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitRuleHeader 请求被组合限流规则拒绝时返回触发的规则名
+const RateLimitRuleHeader = "X-RateLimit-Rule"
+
+// reservedRateLimitRule 已计入本次请求的按请求数计数的规则，后续规则拒绝时撤销
+type reservedRateLimitRule struct {
+	key    string
+	window setting.QuotaWindow
+}
+
+// checkRateLimitRules 按顺序检查组合限流规则，全部满足时放行；第一条不满足的规则决定拒绝原因，
+// 此前已计入的请求数会被撤销，被拒绝的请求不消耗其他规则的额度
+func checkRateLimitRules(c *gin.Context) bool {
+	rules := setting.GetRateLimitRules()
+	if len(rules) == 0 {
+		return true
+	}
+	ctx := context.Background()
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	userId := common.GetContextKeyInt(c, constant.ContextKeyUserId)
+	group := common.GetContextKeyString(c, constant.ContextKeyTokenGroup)
+	ip := c.ClientIP()
+
+	reserved := make([]reservedRateLimitRule, 0, len(rules))
+	releaseReserved := func() {
+		for _, r := range reserved {
+			if err := releasePeriodCount(ctx, r.key, r.window); err != nil {
+				common.SysLog("撤销组合限流规则计数失败: " + err.Error())
+			}
+		}
+	}
+
+	for _, rule := range rules {
+		if !rule.AppliesToGroup(group) {
+			continue
+		}
+		subject := rule.Subject(tokenId, userId, ip)
+		if subject == "" {
+			continue
+		}
+		var (
+			allowed bool
+			window  setting.QuotaWindow
+			err     error
+		)
+		key := rule.CounterKey(subject)
+		switch rule.Metric {
+		case setting.RateLimitRuleMetricRequests:
+			if isTotalCountForgiven(c) {
+				continue
+			}
+			window = setting.FixedWindow(time.Now(), time.Duration(rule.WindowSeconds)*time.Second)
+			allowed, err = reservePeriodCount(ctx, key, rule.Limit, window)
+			if err == nil && allowed {
+				reserved = append(reserved, reservedRateLimitRule{key: key, window: window})
+			}
+		case setting.RateLimitRuleMetricTokens:
+			var used int
+			used, window, err = model.GetRateLimitRuleTokens(rule, subject)
+			allowed = used < rule.Limit
+		}
+		if err != nil {
+			common.SysLog(fmt.Sprintf("检查组合限流规则 %s 失败: %s", rule.Name, err.Error()))
+			if !rateLimitFailOpen(err) {
+				releaseReserved()
+				abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+				return false
+			}
+			continue
+		}
+		if !allowed {
+			releaseReserved()
+			c.Header(RateLimitRuleHeader, rule.Name)
+			retryAfter := int64(time.Until(window.Reset).Seconds()) + 1
+			abortWithRateLimitMessage(c, rateLimitRejectTotal, retryAfter, rateLimitRuleMessage(rule))
+			return false
+		}
+	}
+	return true
+}
+
+func rateLimitRuleMessage(rule setting.RateLimitRule) string {
+	unit := "次请求（包括失败请求）"
+	if rule.Metric == setting.RateLimitRuleMetricTokens {
+		unit = " tokens"
+	}
+	return fmt.Sprintf("已达到限流规则 %s 的限制：%s 维度在%d秒内最多 %d%s，请稍后再试", rule.Name, rule.Dimension, rule.WindowSeconds, rule.Limit, unit)
+}
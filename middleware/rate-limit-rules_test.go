@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func setRateLimitRules(t *testing.T, rules string) {
+	t.Helper()
+	old := setting.RateLimitRules2JSONString()
+	if err := setting.UpdateRateLimitRulesByJSONString(rules); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = setting.UpdateRateLimitRulesByJSONString(old) })
+}
+
+// ruleRequestCount 返回按请求数计数的规则在令牌 tokenId 上当前窗口内已计入的请求数
+func ruleRequestCount(t *testing.T, rule setting.RateLimitRule, tokenId string) int {
+	t.Helper()
+	window := setting.FixedWindow(time.Now(), time.Duration(rule.WindowSeconds)*time.Second)
+	count, err := peekPeriodCount(context.Background(), rule.CounterKey(tokenId), window)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestRateLimitRulesAllMustPass(t *testing.T) {
+	setupMemoryRateLimit(t, 0)
+	setRateLimitRules(t, `[
+		{"name":"rpd-184","dimension":"token","metric":"requests","window_seconds":86400,"limit":5},
+		{"name":"rph-184","dimension":"token","metric":"requests","window_seconds":3600,"limit":2},
+		{"name":"vip-184","dimension":"token","metric":"requests","window_seconds":3600,"limit":1,"groups":["vip"]}
+	]`)
+	rules := setting.GetRateLimitRules()
+
+	// vip-184 只对 vip 分组生效，不限制 default 分组
+	for i := 0; i < 2; i++ {
+		if w := serveModelRequest(1841, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+	}
+	w := serveModelRequest(1841, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	// 第一条不满足的规则决定拒绝原因
+	if got := w.Header().Get(RateLimitRuleHeader); got != "rph-184" {
+		t.Fatalf("%s = %q, want rph-184", RateLimitRuleHeader, got)
+	}
+	if code := rejectCode(t, w); code != "total_rate_limit_exceeded" {
+		t.Fatalf("reject code = %q, want total_rate_limit_exceeded", code)
+	}
+	if !strings.Contains(w.Body.String(), "rph-184") {
+		t.Fatalf("body %q does not name the failing rule", w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("missing Retry-After")
+	}
+	// 被拒绝的请求撤销已计入前面规则的请求数
+	if got := ruleRequestCount(t, rules[0], "1841"); got != 2 {
+		t.Fatalf("rpd-184 count = %d, want 2", got)
+	}
+	if got := ruleRequestCount(t, rules[1], "1841"); got != 2 {
+		t.Fatalf("rph-184 count = %d, want 2", got)
+	}
+
+	// 其他令牌不受影响
+	if w := serveModelRequest(1842, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("other token: status %d", w.Code)
+	}
+}
+
+func TestRateLimitRulesTokenMetric(t *testing.T) {
+	setupMemoryRateLimit(t, 0)
+	setRateLimitRules(t, `[
+		{"name":"rpd-1843","dimension":"token","metric":"requests","window_seconds":86400,"limit":100},
+		{"name":"tpd-1843","dimension":"token","metric":"tokens","window_seconds":86400,"limit":1000}
+	]`)
+	rules := setting.GetRateLimitRules()
+
+	if w := serveModelRequest(1843, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	// 请求完成后计入消耗的 token 数，用完后下一次请求被 tpd-1843 拒绝
+	model.IncreaseRateLimitRuleTokens(1843, 0, "", "default", 1000)
+	if rule, exceeded := model.ExceededRateLimitRuleTokens(1843, 0, "", "default"); !exceeded || rule.Name != "tpd-1843" {
+		t.Fatalf("exceeded rule = %q (%v), want tpd-1843", rule.Name, exceeded)
+	}
+	w := serveModelRequest(1843, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	if w.Code != http.StatusTooManyRequests || w.Header().Get(RateLimitRuleHeader) != "tpd-1843" {
+		t.Fatalf("status %d, rule %q, want 429 from tpd-1843", w.Code, w.Header().Get(RateLimitRuleHeader))
+	}
+	if got := ruleRequestCount(t, rules[0], "1843"); got != 1 {
+		t.Fatalf("rpd-1843 count = %d, want 1 after the rejected request is released", got)
+	}
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RateLimitSandboxHeader 请求带上该请求头时只在沙盒中计数，用于客户端调试退避逻辑，不消耗正式限流额度也不会转发到上游
+const RateLimitSandboxHeader = "X-RateLimit-Sandbox"
+
+const RateLimitSandboxMark = "SBRL"
+
+func isRateLimitSandboxRequest(c *gin.Context) bool {
+	if setting.RateLimitSandboxCount <= 0 {
+		return false
+	}
+	enabled, _ := strconv.ParseBool(c.GetHeader(RateLimitSandboxHeader))
+	return enabled
+}
+
+// serveRateLimitSandbox 在独立的沙盒令牌桶中按令牌计数，放行时直接返回沙盒响应，超限时按正式限流的格式返回 429
+func serveRateLimitSandbox(c *gin.Context) {
+	tokenId := common.GetContextKeyInt(c, constant.ContextKeyTokenId)
+	maxCount := setting.RateLimitSandboxCount
+	duration := int64(setting.RateLimitSandboxDurationSeconds)
+	rateLimitKey := strconv.Itoa(tokenId)
+	dimension := rateLimitDimension{
+		name:      "sandbox",
+		kind:      rateLimitKindBucket,
+		redisKey:  fmt.Sprintf("rateLimit:%s:%s", RateLimitSandboxMark, rateLimitKey),
+		memoryKey: RateLimitSandboxMark + rateLimitKey,
+		maxCount:  maxCount,
+		duration:  duration,
+	}
+	allowed := true
+	var retryAfter int64
+	if common.RedisEnabled() {
+		ok, wait, err := limiter.New(context.Background(), common.RDB()).Reserve(
+			context.Background(),
+			dimension.redisKey,
+			limiter.WithCapacity(int64(maxCount)*duration),
+			limiter.WithRate(int64(maxCount)),
+			limiter.WithRequested(duration),
+		)
+		if err != nil {
+			abortWithOpenAiMessage(c, http.StatusInternalServerError, "rate_limit_check_failed")
+			return
+		}
+		allowed, retryAfter = ok, retryAfterFromWait(wait, duration)
+	} else {
+		inMemoryRateLimiter.Init(time.Duration(duration) * time.Second)
+		allowed, retryAfter = inMemoryRateLimiter.Request(dimension.memoryKey, maxCount, duration), duration
+	}
+	if status, err := dimension.peek(context.Background(), common.RDB()); err == nil {
+		setRateLimitHeaders(c, []RateLimitStatus{status})
+	}
+	if !allowed {
+		abortWithRateLimitMessage(c, rateLimitRejectTotal, retryAfter, fmt.Sprintf("沙盒限流：%d秒内最多请求%d次", duration, maxCount))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":  "rate_limit.sandbox",
+		"sandbox": true,
+		"limit":   maxCount,
+		"window":  duration,
+	})
+	c.Abort()
+}
+
+// RateLimitSandbox 沙盒限流测试接口，每次调用都在沙盒中计数，不依赖请求头
+func RateLimitSandbox() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if setting.RateLimitSandboxCount <= 0 {
+			abortWithOpenAiMessage(c, http.StatusNotFound, "沙盒限流未启用")
+			return
+		}
+		serveRateLimitSandbox(c)
+	}
+}
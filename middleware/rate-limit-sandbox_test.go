@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func TestSandboxRequestsUseSandboxLimitOnly(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	setting.RateLimitSandboxCount = 2
+	t.Cleanup(func() { setting.RateLimitSandboxCount = 0 })
+	sandbox := map[string]string{RateLimitSandboxHeader: "true"}
+
+	// 沙盒请求直接返回沙盒响应，不会到达上游处理函数
+	for i := 0; i < 2; i++ {
+		w := serveModelRequest(1481, `{"model":"gpt-4o"}`, http.StatusAccepted, sandbox)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"sandbox":true`) {
+			t.Fatalf("sandbox request %d: status %d, body %s", i+1, w.Code, w.Body.String())
+		}
+	}
+	w := serveModelRequest(1481, `{"model":"gpt-4o"}`, http.StatusAccepted, sandbox)
+	if w.Code != http.StatusTooManyRequests || rejectCode(t, w) != "total_rate_limit_exceeded" {
+		t.Fatalf("sandbox over limit: status %d, body %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" || w.Header().Get("X-RateLimit-Limit") != "2" || w.Header().Get("X-RateLimit-Remaining") != "0" {
+		t.Fatalf("sandbox headers = %v", w.Header())
+	}
+
+	// 正式限流额度不受影响
+	if got := tokenTotalCount(1481); got != 0 {
+		t.Fatalf("sandbox requests consumed %d production requests", got)
+	}
+	if w = serveModelRequest(1481, `{"model":"gpt-4o"}`, http.StatusAccepted, nil); w.Code != http.StatusAccepted {
+		t.Fatalf("production request after sandbox limit: status %d", w.Code)
+	}
+	if got := tokenTotalCount(1481); got != 1 {
+		t.Fatalf("production count = %d, want 1", got)
+	}
+}
+
+func TestSandboxHeaderIgnoredWhenDisabled(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	w := serveModelRequest(1482, `{"model":"gpt-4o"}`, http.StatusAccepted, map[string]string{RateLimitSandboxHeader: "true"})
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want the request relayed when the sandbox is off", w.Code)
+	}
+	if got := tokenTotalCount(1482); got != 1 {
+		t.Fatalf("production count = %d, want 1", got)
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"strings"
+	"sync/atomic"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// evaluateShadowRateLimit 使用 setting.ShadowRateLimitAlgorithm 配置的影子算法对总请求数限制重新判定一次，
+// 影子算法使用独立的 key，判定结果只用于统计与实际判定不一致的次数，不影响本次请求。
+// 目前总请求数限制实际使用的是令牌桶算法。
+func evaluateShadowRateLimit(c *gin.Context, key string, maxCount int, duration int64, activeAllowed bool) {
+	algorithm := setting.ShadowRateLimitAlgorithm
+	if algorithm == "" || algorithm == setting.RateLimitAlgorithmTokenBucket || !common.RedisEnabled() {
+		return
+	}
+	ctx := c.Request.Context()
+	shadowKey := "rateLimit:shadow:" + strings.TrimPrefix(key, "rateLimit:")
+
+	var shadowAllowed bool
+	var err error
+	switch algorithm {
+	case setting.RateLimitAlgorithmSlidingWindow:
+		shadowAllowed, err = checkRedisRateLimit(ctx, common.RDB(), shadowKey, maxCount, duration)
+		if err == nil && shadowAllowed {
+			recordRedisRequest(ctx, common.RDB(), shadowKey, maxCount)
+		}
+	default:
+		return
+	}
+	if err != nil {
+		logger.LogDebug(ctx, "shadow rate limit check failed: %s", err.Error())
+		return
+	}
+
+	atomic.AddInt64(&rateLimitStats.shadowEvaluations, 1)
+	if shadowAllowed != activeAllowed {
+		atomic.AddInt64(&rateLimitStats.shadowDivergences, 1)
+		logger.LogDebug(ctx, "shadow rate limit divergence: key=%s, algorithm=%s, active_allowed=%t, shadow_allowed=%t", key, algorithm, activeAllowed, shadowAllowed)
+	}
+}
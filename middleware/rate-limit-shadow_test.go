@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestShadowRateLimitCountsDivergence(t *testing.T) {
+	f, rdb := startFakeRedis(t)
+	oldRedisEnabled, oldRDB, oldAlgorithm := common.RedisEnabled(), common.RDB(), setting.ShadowRateLimitAlgorithm
+	t.Cleanup(func() {
+		setting.ShadowRateLimitAlgorithm = oldAlgorithm
+		common.SetRedisEnabled(oldRedisEnabled)
+		common.SetRDB(oldRDB)
+	})
+	common.SetRedisEnabled(true)
+	common.SetRDB(rdb)
+	setting.ShadowRateLimitAlgorithm = setting.RateLimitAlgorithmSlidingWindow
+
+	evaluations := atomic.LoadInt64(&rateLimitStats.shadowEvaluations)
+	divergences := atomic.LoadInt64(&rateLimitStats.shadowDivergences)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	// 实际判定全部放行，影子滑动窗口只允许 2 次，第 3 次判定不一致
+	for i := 0; i < 3; i++ {
+		evaluateShadowRateLimit(c, "rateLimit:MRRL:1", 2, 60, true)
+	}
+	if c.IsAborted() {
+		t.Fatal("shadow evaluation aborted the request")
+	}
+
+	if got := atomic.LoadInt64(&rateLimitStats.shadowEvaluations) - evaluations; got != 3 {
+		t.Errorf("shadow evaluations = %d, want 3", got)
+	}
+	if got := atomic.LoadInt64(&rateLimitStats.shadowDivergences) - divergences; got != 1 {
+		t.Errorf("shadow divergences = %d, want 1", got)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if got := len(f.lists["rateLimit:shadow:MRRL:1"]); got != 2 {
+		t.Errorf("shadow list length = %d, want 2", got)
+	}
+	if _, ok := f.lists["rateLimit:MRRL:1"]; ok {
+		t.Error("shadow evaluation wrote to the active key")
+	}
+}
+
+func TestShadowRateLimitSkippedForTokenBucket(t *testing.T) {
+	f, rdb := startFakeRedis(t)
+	oldRedisEnabled, oldRDB, oldAlgorithm := common.RedisEnabled(), common.RDB(), setting.ShadowRateLimitAlgorithm
+	t.Cleanup(func() {
+		setting.ShadowRateLimitAlgorithm = oldAlgorithm
+		common.SetRedisEnabled(oldRedisEnabled)
+		common.SetRDB(oldRDB)
+	})
+	common.SetRedisEnabled(true)
+	common.SetRDB(rdb)
+	setting.ShadowRateLimitAlgorithm = setting.RateLimitAlgorithmTokenBucket
+
+	evaluations := atomic.LoadInt64(&rateLimitStats.shadowEvaluations)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	evaluateShadowRateLimit(c, "rateLimit:MRRL:1", 2, 60, false)
+
+	if got := atomic.LoadInt64(&rateLimitStats.shadowEvaluations) - evaluations; got != 0 {
+		t.Errorf("shadow evaluations = %d, want 0", got)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.lists) != 0 {
+		t.Errorf("token bucket shadow wrote lists: %v", f.lists)
+	}
+}
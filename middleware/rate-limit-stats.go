@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// RateLimitStats 限流相关的运行时计数
+type RateLimitStats struct {
+	shadowEvaluations int64
+	shadowDivergences int64
+	failOpenTotal     int64
+	lastFailOpenAt    int64 // 最近一次因 Redis 出错而放行的时间，Unix 秒
+	lastFailOpenLogAt int64 // 最近一次输出放行日志的时间，Unix 秒
+	failOpenLogged    int64 // 最近一次输出日志时的 failOpenTotal，用于统计期间未输出日志的次数
+	activeKeys        int64 // 最近一次清理后 Redis 中仍在使用的限流 key 数量
+	sweptKeys         int64 // 累计清理的闲置限流 key 数量
+	lastSweepAt       int64
+	missingGroupTotal int64 // 进入限流时上下文中缺少分组的请求数（RateLimitStrictGroup 开启时统计）
+	repairedLists     int64 // 因长度异常而被裁剪修复的限流列表数量
+	memoryFallbacks   int64 // Redis 响应超过 RateLimitRedisTimeoutMs 而改用内存限流的检查次数
+	evictedKeys       int64 // 限流 key 数量超过 RateLimitMaxKeys 时累计淘汰的闲置 key 数量
+}
+
+var rateLimitStats = &RateLimitStats{}
+
+// RateLimitStatsInfo 限流统计信息
+type RateLimitStatsInfo struct {
+	ShadowEvaluations int64 `json:"shadow_evaluations"`
+	ShadowDivergences int64 `json:"shadow_divergences"`
+	FailOpenTotal     int64 `json:"fail_open_total"`
+	LastFailOpenAt    int64 `json:"last_fail_open_at"`
+	ActiveKeys        int64 `json:"active_keys"`
+	SweptKeys         int64 `json:"swept_keys"`
+	LastSweepAt       int64 `json:"last_sweep_at"`
+	MissingGroupTotal int64 `json:"missing_group_total"`
+	RepairedLists     int64 `json:"repaired_lists"`
+	MemoryFallbacks   int64 `json:"memory_fallbacks"`
+	EvictedKeys       int64 `json:"evicted_keys"`
+}
+
+// GetRateLimitStats 获取限流统计信息
+func GetRateLimitStats() RateLimitStatsInfo {
+	info := RateLimitStatsInfo{
+		ShadowEvaluations: atomic.LoadInt64(&rateLimitStats.shadowEvaluations),
+		ShadowDivergences: atomic.LoadInt64(&rateLimitStats.shadowDivergences),
+		FailOpenTotal:     atomic.LoadInt64(&rateLimitStats.failOpenTotal),
+		LastFailOpenAt:    atomic.LoadInt64(&rateLimitStats.lastFailOpenAt),
+		ActiveKeys:        atomic.LoadInt64(&rateLimitStats.activeKeys),
+		SweptKeys:         atomic.LoadInt64(&rateLimitStats.sweptKeys),
+		LastSweepAt:       atomic.LoadInt64(&rateLimitStats.lastSweepAt),
+		MissingGroupTotal: atomic.LoadInt64(&rateLimitStats.missingGroupTotal),
+		RepairedLists:     atomic.LoadInt64(&rateLimitStats.repairedLists),
+		MemoryFallbacks:   atomic.LoadInt64(&rateLimitStats.memoryFallbacks),
+		EvictedKeys:       atomic.LoadInt64(&rateLimitStats.evictedKeys),
+	}
+	if !common.RedisEnabled() {
+		// 内存模式下过期的 key 由限流器自行清理，直接返回当前数量
+		info.ActiveKeys = int64(inMemoryRateLimiter.Len())
+	}
+	return info
+}
+
+// rateLimitFailOpen 限流检查访问 Redis 出错时，判断是否放行请求。
+// 开启 RateLimitFailOpenEnabled 时放行并记录一次，否则由调用方返回错误。
+func rateLimitFailOpen(err error) bool {
+	if !setting.RateLimitFailOpenEnabled {
+		return false
+	}
+	total := atomic.AddInt64(&rateLimitStats.failOpenTotal, 1)
+	now := time.Now().Unix()
+	atomic.StoreInt64(&rateLimitStats.lastFailOpenAt, now)
+	// Redis 故障期间每个请求都会放行，日志按间隔输出并附带期间放行的次数，避免刷屏
+	lastLog := atomic.LoadInt64(&rateLimitStats.lastFailOpenLogAt)
+	if now-lastLog >= rateLimitFailOpenLogInterval && atomic.CompareAndSwapInt64(&rateLimitStats.lastFailOpenLogAt, lastLog, now) {
+		bypassed := total - atomic.SwapInt64(&rateLimitStats.failOpenLogged, total)
+		common.SysLog(fmt.Sprintf("rate limit check failed, fail open (%d bypassed since last log, %d total): %s", bypassed, total, err.Error()))
+	}
+	return true
+}
+
+// 放行日志的最小输出间隔，单位秒
+const rateLimitFailOpenLogInterval = 60
+
+// GetRateLimitFailOpenTotal 返回因 Redis 出错而放行的累计次数
+func GetRateLimitFailOpenTotal() int64 {
+	return atomic.LoadInt64(&rateLimitStats.failOpenTotal)
+}
+
+// IsRateLimitFailOpenActive 最近 window 内是否有 Redis 错误被放行掩盖
+func IsRateLimitFailOpenActive(window time.Duration) bool {
+	last := atomic.LoadInt64(&rateLimitStats.lastFailOpenAt)
+	return last > 0 && time.Since(time.Unix(last, 0)) < window
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+func TestRateLimitFailOpenMarksDegraded(t *testing.T) {
+	lastFailOpenAt := atomic.LoadInt64(&rateLimitStats.lastFailOpenAt)
+	t.Cleanup(func() {
+		setting.RateLimitFailOpenEnabled = false
+		atomic.StoreInt64(&rateLimitStats.lastFailOpenAt, lastFailOpenAt)
+	})
+	atomic.StoreInt64(&rateLimitStats.lastFailOpenAt, 0)
+
+	setting.RateLimitFailOpenEnabled = false
+	if rateLimitFailOpen(errors.New("redis down")) {
+		t.Fatal("fail open disabled but request was let through")
+	}
+	if IsRateLimitFailOpenActive(time.Minute) {
+		t.Fatal("degraded without any fail open")
+	}
+
+	setting.RateLimitFailOpenEnabled = true
+	total := GetRateLimitFailOpenTotal()
+	if !rateLimitFailOpen(errors.New("redis down")) {
+		t.Fatal("fail open enabled but request was rejected")
+	}
+	if got := GetRateLimitFailOpenTotal() - total; got != 1 {
+		t.Fatalf("fail open total increased by %d, want 1", got)
+	}
+	if !IsRateLimitFailOpenActive(time.Minute) {
+		t.Fatal("recent fail open should mark the limiter degraded")
+	}
+
+	// 超过窗口后不再视为降级
+	atomic.StoreInt64(&rateLimitStats.lastFailOpenAt, time.Now().Add(-2*time.Minute).Unix())
+	if IsRateLimitFailOpenActive(time.Minute) {
+		t.Fatal("fail open outside the window should not mark the limiter degraded")
+	}
+}
+
+func TestRateLimitFailOpenLogThrottled(t *testing.T) {
+	var logs bytes.Buffer
+	oldWriter := gin.DefaultWriter
+	gin.DefaultWriter = &logs
+	lastLogAt := atomic.LoadInt64(&rateLimitStats.lastFailOpenLogAt)
+	setting.RateLimitFailOpenEnabled = true
+	t.Cleanup(func() {
+		gin.DefaultWriter = oldWriter
+		setting.RateLimitFailOpenEnabled = false
+		atomic.StoreInt64(&rateLimitStats.lastFailOpenLogAt, lastLogAt)
+	})
+	atomic.StoreInt64(&rateLimitStats.lastFailOpenLogAt, 0)
+
+	// 每次放行都计数，日志在间隔内只输出一次
+	total := GetRateLimitFailOpenTotal()
+	for i := 0; i < 5; i++ {
+		rateLimitFailOpen(errors.New("redis down"))
+	}
+	if got := GetRateLimitFailOpenTotal() - total; got != 5 {
+		t.Fatalf("fail open total increased by %d, want 5", got)
+	}
+	if got := strings.Count(logs.String(), "fail open"); got != 1 {
+		t.Fatalf("logged %d fail open lines within the interval, want 1:\n%s", got, logs.String())
+	}
+
+	// 间隔过后再次输出，并报告期间放行的次数
+	atomic.StoreInt64(&rateLimitStats.lastFailOpenLogAt, time.Now().Unix()-rateLimitFailOpenLogInterval)
+	logs.Reset()
+	rateLimitFailOpen(errors.New("redis down"))
+	if !strings.Contains(logs.String(), "(5 bypassed since last log") {
+		t.Fatalf("log after the interval = %q, want the 5 requests bypassed since the last log", logs.String())
+	}
+}
+
+func TestRedisErrorsCountedOncePerBypass(t *testing.T) {
+	setupMemoryRateLimit(t, 0)
+	setting.TokenRateLimitSuccessCount = 5
+	setting.RateLimitFailOpenEnabled = true
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	rdb := redis.NewClient(&redis.Options{Addr: addr, MaxRetries: -1})
+	oldRDB, oldWriter := common.RDB(), gin.DefaultWriter
+	common.SetRDB(rdb)
+	common.SetRedisEnabled(true)
+	gin.DefaultWriter = io.Discard
+	t.Cleanup(func() {
+		setting.TokenRateLimitSuccessCount = 0
+		setting.RateLimitFailOpenEnabled = false
+		common.SetRDB(oldRDB)
+		common.SetRedisEnabled(false)
+		gin.DefaultWriter = oldWriter
+		_ = rdb.Close()
+	})
+
+	total := GetRateLimitFailOpenTotal()
+	for i := 0; i < 3; i++ {
+		if w := serveModelRequest(1631, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d during the Redis outage: status %d, want it let through", i+1, w.Code)
+		}
+	}
+	if got := GetRateLimitFailOpenTotal() - total; got != 3 {
+		t.Fatalf("fail open total increased by %d for 3 bypassed requests, want 3", got)
+	}
+}
@@ -0,0 +1,456 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// RateLimitStatus 单个限流维度的当前状态
+type RateLimitStatus struct {
+	Name      string `json:"name"`
+	Limit     int    `json:"limit"`
+	Remaining int    `json:"remaining"`
+	Window    int64  `json:"window"`   // 时间窗口，单位秒
+	Reset     int64  `json:"reset"`    // 距离释放出一个名额还需的秒数，0 表示当前仍有余量
+	ResetAt   int64  `json:"reset_at"` // 最早一次计数的请求离开时间窗口的 Unix 时间戳，没有计数时为当前时间
+}
+
+// RateLimitBreakdown 单个限流维度的详细状态，用于排查请求被限流的原因
+type RateLimitBreakdown struct {
+	RateLimitStatus
+	Kind      string `json:"kind"`
+	Used      int    `json:"used"`
+	Exhausted bool   `json:"exhausted"`
+	OldestAt  int64  `json:"oldest_at"` // 窗口内最早一次计数请求的时间戳，令牌桶不记录单次请求时为 0
+}
+
+// 限流维度的计数方式
+const (
+	rateLimitKindList   = "list"   // Redis 列表记录时间戳（成功请求数）
+	rateLimitKindBucket = "bucket" // Redis 令牌桶（总请求数）
+)
+
+type rateLimitDimension struct {
+	name      string
+	kind      string
+	redisKey  string
+	memoryKey string
+	maxCount  int
+	duration  int64
+}
+
+// peek 查询单个维度的当前状态，只读，不会消耗额度。rdb 为 Redis 模式下查询使用的客户端
+func (d rateLimitDimension) peek(ctx context.Context, rdb *redis.Client) (RateLimitStatus, error) {
+	breakdown, err := d.inspect(ctx, rdb)
+	return breakdown.RateLimitStatus, err
+}
+
+// inspect 查询单个维度的详细状态，只读，不会消耗额度
+func (d rateLimitDimension) inspect(ctx context.Context, rdb *redis.Client) (RateLimitBreakdown, error) {
+	breakdown := RateLimitBreakdown{
+		RateLimitStatus: RateLimitStatus{
+			Name:   d.name,
+			Limit:  d.maxCount,
+			Window: d.duration,
+		},
+		Kind: d.kind,
+	}
+	var used int
+	var reset, oldest int64
+	if common.RedisEnabled() {
+		var err error
+		if d.kind == rateLimitKindBucket {
+			used, reset, err = peekRedisBucket(ctx, rdb, d.redisKey, d.maxCount, d.duration)
+		} else {
+			used, reset, oldest, err = peekRedisList(ctx, rdb, d.redisKey, d.maxCount, d.duration)
+		}
+		if err != nil {
+			return breakdown, err
+		}
+	} else {
+		used, oldest = inMemoryRateLimiter.Peek(d.memoryKey, d.duration)
+		if used >= d.maxCount && oldest > 0 {
+			reset = d.duration - (time.Now().Unix() - oldest)
+		}
+	}
+	breakdown.Used = used
+	breakdown.OldestAt = oldest
+	breakdown.Remaining = d.maxCount - used
+	if breakdown.Remaining < 0 {
+		breakdown.Remaining = 0
+	}
+	if breakdown.Remaining == 0 {
+		breakdown.Exhausted = true
+		breakdown.Reset = reset
+	}
+	breakdown.ResetAt = d.resetAt(used, oldest, breakdown.Reset)
+	return breakdown, nil
+}
+
+// resetAt 计算最早一次计数的请求离开时间窗口的时刻。记录了单次请求时间的维度按最早一次计数加上时间窗口；
+// 令牌桶不记录单次请求，额度用尽时取下一个名额释放的时刻，否则取一个名额的回填时长
+func (d rateLimitDimension) resetAt(used int, oldest int64, reset int64) int64 {
+	now := time.Now().Unix()
+	switch {
+	case used <= 0:
+		return now
+	case oldest > 0:
+		return oldest + d.duration
+	case reset > 0:
+		return now + reset
+	}
+	return now + int64(math.Ceil(float64(d.duration)/float64(d.maxCount)))
+}
+
+// peekRedisList 返回列表中仍在窗口内的计数、释放名额还需的秒数以及最早一次计数的时间戳
+func peekRedisList(ctx context.Context, rdb *redis.Client, key string, maxCount int, duration int64) (int, int64, int64, error) {
+	values, err := rdb.LRange(ctx, key, 0, int64(maxCount-1)).Result()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	now := time.Now()
+	used := 0
+	var oldest time.Time
+	for _, value := range values {
+		t, err := time.Parse(timeFormat, value)
+		if err != nil {
+			continue
+		}
+		if int64(now.Sub(t).Seconds()) < duration {
+			used++
+			oldest = t
+		}
+	}
+	var reset, oldestAt int64
+	if !oldest.IsZero() {
+		oldestAt = oldest.Unix()
+		if used >= maxCount {
+			reset = duration - int64(now.Sub(oldest).Seconds())
+		}
+	}
+	return used, reset, oldestAt, nil
+}
+
+func peekRedisBucket(ctx context.Context, rdb *redis.Client, key string, maxCount int, duration int64) (int, int64, error) {
+	tokens, wait, err := limiter.PeekWithClient(
+		ctx,
+		rdb,
+		key,
+		limiter.WithCapacity(int64(maxCount)*duration),
+		limiter.WithRate(int64(maxCount)),
+		limiter.WithRequested(duration),
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+	used := maxCount - int(tokens/duration)
+	return used, retryAfterFromWait(wait, duration), nil
+}
+
+// tokenRateLimitDimensions 返回某个 token 当前生效的分钟级与每日限流维度
+func tokenRateLimitDimensions(tokenId int, group string) []rateLimitDimension {
+	dimensions := make([]rateLimitDimension, 0, 4)
+	rateLimitKey := strconv.Itoa(tokenId)
+	if setting.TokenRateLimitEnabled {
+		totalMaxCount := setting.TokenRateLimitCount
+		successMaxCount := setting.TokenRateLimitSuccessCount
+		if groupTotalCount, groupSuccessCount, found := setting.GetTokenRateLimit(group); found {
+			totalMaxCount = groupTotalCount
+			successMaxCount = groupSuccessCount
+		}
+		duration := int64(setting.TokenRateLimitDurationMinutes * 60)
+		if totalMaxCount > 0 {
+			dimensions = append(dimensions, rateLimitDimension{
+				name:      "token_minute_total",
+				kind:      rateLimitKindBucket,
+				redisKey:  fmt.Sprintf("rateLimit:%s:%s", TokenRateLimitCountMark, rateLimitKey),
+				memoryKey: TokenRateLimitCountMark + rateLimitKey,
+				maxCount:  totalMaxCount,
+				duration:  duration,
+			})
+		}
+		if successMaxCount > 0 {
+			dimensions = append(dimensions, rateLimitDimension{
+				name:      "token_minute_success",
+				kind:      rateLimitKindList,
+				redisKey:  fmt.Sprintf("rateLimit:%s:%s", TokenRateLimitSuccessCountMark, rateLimitKey),
+				memoryKey: TokenRateLimitSuccessCountMark + rateLimitKey,
+				maxCount:  successMaxCount,
+				duration:  duration,
+			})
+		}
+	}
+	if setting.TokenDailyRateLimitEnabled {
+		totalMaxCount := setting.TokenDailyRateLimitCount
+		successMaxCount := setting.TokenDailyRateLimitSuccessCount
+		if groupTotalCount, groupSuccessCount, found := setting.GetTokenDailyRateLimit(group); found {
+			totalMaxCount = groupTotalCount
+			successMaxCount = groupSuccessCount
+		}
+		duration := int64(86400)
+		if totalMaxCount > 0 {
+			dimensions = append(dimensions, rateLimitDimension{
+				name:      "token_daily_total",
+				kind:      rateLimitKindBucket,
+				redisKey:  fmt.Sprintf("rateLimit:%s:%s", TokenDailyRateLimitCountMark, rateLimitKey),
+				memoryKey: TokenDailyRateLimitCountMark + rateLimitKey,
+				maxCount:  totalMaxCount,
+				duration:  duration,
+			})
+		}
+		if successMaxCount > 0 {
+			dimensions = append(dimensions, rateLimitDimension{
+				name:      "token_daily_success",
+				kind:      rateLimitKindList,
+				redisKey:  fmt.Sprintf("rateLimit:%s:%s", TokenDailyRateLimitSuccessCountMark, rateLimitKey),
+				memoryKey: TokenDailyRateLimitSuccessCountMark + rateLimitKey,
+				maxCount:  successMaxCount,
+				duration:  duration,
+			})
+		}
+	}
+	return dimensions
+}
+
+// userRateLimitDimensions 返回某个用户当前生效的 per-user 限流维度
+func userRateLimitDimensions(userId int, group string) []rateLimitDimension {
+	dimensions := userDailyRateLimitDimensions(userId, group)
+	if !setting.ModelRequestRateLimitEnabled {
+		return dimensions
+	}
+	totalMaxCount := setting.ModelRequestRateLimitCount
+	successMaxCount := setting.ModelRequestRateLimitSuccessCount
+	if groupTotalCount, groupSuccessCount, found := setting.GetGroupRateLimit(group); found {
+		totalMaxCount = groupTotalCount
+		successMaxCount = groupSuccessCount
+	}
+	rateLimitKey := strconv.Itoa(userId)
+	duration := int64(setting.ModelRequestRateLimitDurationMinutes * 60)
+	if totalMaxCount > 0 {
+		dimensions = append(dimensions, rateLimitDimension{
+			name:      "user_total",
+			kind:      rateLimitKindBucket,
+			redisKey:  fmt.Sprintf("rateLimit:%s", rateLimitKey),
+			memoryKey: ModelRequestRateLimitCountMark + rateLimitKey,
+			maxCount:  totalMaxCount,
+			duration:  duration,
+		})
+	}
+	if successMaxCount > 0 {
+		dimensions = append(dimensions, rateLimitDimension{
+			name:      "user_success",
+			kind:      rateLimitKindList,
+			redisKey:  fmt.Sprintf("rateLimit:%s:%s", ModelRequestRateLimitSuccessCountMark, rateLimitKey),
+			memoryKey: ModelRequestRateLimitSuccessCountMark + rateLimitKey,
+			maxCount:  successMaxCount,
+			duration:  duration,
+		})
+	}
+	return dimensions
+}
+
+// userDailyRateLimitDimensions 返回某个用户当前生效的 per-user 每日限流维度
+func userDailyRateLimitDimensions(userId int, group string) []rateLimitDimension {
+	dimensions := make([]rateLimitDimension, 0, 4)
+	if !setting.UserDailyRateLimitEnabled {
+		return dimensions
+	}
+	totalMaxCount, successMaxCount := getUserDailyRateLimit(group)
+	rateLimitKey := strconv.Itoa(userId)
+	duration := int64(86400)
+	if totalMaxCount > 0 {
+		dimensions = append(dimensions, rateLimitDimension{
+			name:      "user_daily_total",
+			kind:      rateLimitKindBucket,
+			redisKey:  fmt.Sprintf("rateLimit:%s:%s", UserDailyRateLimitCountMark, rateLimitKey),
+			memoryKey: UserDailyRateLimitCountMark + rateLimitKey,
+			maxCount:  totalMaxCount,
+			duration:  duration,
+		})
+	}
+	if successMaxCount > 0 {
+		dimensions = append(dimensions, rateLimitDimension{
+			name:      "user_daily_success",
+			kind:      rateLimitKindList,
+			redisKey:  fmt.Sprintf("rateLimit:%s:%s", UserDailyRateLimitSuccessCountMark, rateLimitKey),
+			memoryKey: UserDailyRateLimitSuccessCountMark + rateLimitKey,
+			maxCount:  successMaxCount,
+			duration:  duration,
+		})
+	}
+	return dimensions
+}
+
+// GetRateLimitStatuses 查询 token 及其所属用户当前所有生效限流维度的状态，不消耗任何额度
+func GetRateLimitStatuses(tokenId int, tokenGroup string, userId int, userGroup string) ([]RateLimitStatus, error) {
+	ctx := context.Background()
+	dimensions := tokenRateLimitDimensions(tokenId, tokenGroup)
+	dimensions = append(dimensions, userRateLimitDimensions(userId, userGroup)...)
+	var rdb *redis.Client
+	if common.RedisEnabled() {
+		rdb = rateLimitReadClient()
+	}
+	statuses := make([]RateLimitStatus, 0, len(dimensions))
+	for _, dimension := range dimensions {
+		status, err := dimension.peek(ctx, rdb)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// GetTokenRateLimitBreakdown 查询 token 及其所属用户当前所有生效限流维度的详细状态，不消耗任何额度
+func GetTokenRateLimitBreakdown(tokenId int, tokenGroup string, userId int, userGroup string) ([]RateLimitBreakdown, error) {
+	ctx := context.Background()
+	dimensions := tokenRateLimitDimensions(tokenId, tokenGroup)
+	dimensions = append(dimensions, userRateLimitDimensions(userId, userGroup)...)
+	var rdb *redis.Client
+	if common.RedisEnabled() {
+		rdb = rateLimitReadClient()
+	}
+	breakdowns := make([]RateLimitBreakdown, 0, len(dimensions))
+	for _, dimension := range dimensions {
+		breakdown, err := dimension.inspect(ctx, rdb)
+		if err != nil {
+			return nil, err
+		}
+		breakdowns = append(breakdowns, breakdown)
+	}
+	return breakdowns, nil
+}
+
+// RateLimitGroupPreview 分组令牌限流配置调整前的预览结果
+type RateLimitGroupPreview struct {
+	Checked  int   `json:"checked"`   // 检查的令牌数
+	Affected int   `json:"affected"`  // 当前计数已达到新上限、下一次请求会被限流的令牌数
+	TokenIds []int `json:"token_ids"` // 受影响的令牌 ID
+}
+
+// PreviewTokenGroupRateLimit 按当前配置查询分组内各令牌的计数，统计改用新上限后立即会被限流的令牌数量。
+// daily 为 true 时预览每日限流，否则预览分钟级限流；上限为 0 表示该维度不限制
+func PreviewTokenGroupRateLimit(tokenIds []int, group string, daily bool, totalMaxCount, successMaxCount int) (RateLimitGroupPreview, error) {
+	preview := RateLimitGroupPreview{TokenIds: []int{}}
+	proposed := map[string]int{
+		"token_minute_total":   totalMaxCount,
+		"token_minute_success": successMaxCount,
+	}
+	if daily {
+		proposed = map[string]int{
+			"token_daily_total":   totalMaxCount,
+			"token_daily_success": successMaxCount,
+		}
+	}
+	ctx := context.Background()
+	var rdb *redis.Client
+	if common.RedisEnabled() {
+		rdb = rateLimitReadClient()
+	}
+	for _, tokenId := range tokenIds {
+		preview.Checked++
+		// 计数需按当前生效的上限读取，令牌桶换用新容量计算会得到错误的已用量
+		for _, dimension := range tokenRateLimitDimensions(tokenId, group) {
+			limit := proposed[dimension.name]
+			if limit <= 0 {
+				continue
+			}
+			breakdown, err := dimension.inspect(ctx, rdb)
+			if err != nil {
+				return preview, err
+			}
+			if breakdown.Used >= limit {
+				preview.Affected++
+				preview.TokenIds = append(preview.TokenIds, tokenId)
+				break
+			}
+		}
+	}
+	return preview, nil
+}
+
+// SetRateLimitHeaders 按查询到的限流状态写入 X-RateLimit-* 响应头，开启 RateLimitPolicyHeadersEnabled 时同时写入
+// RateLimit-Policy / RateLimit 响应头。用于 GET /v1/rate_limits 等只查询不消耗额度的接口
+func SetRateLimitHeaders(c *gin.Context, statuses []RateLimitStatus) {
+	setRateLimitHeaders(c, statuses)
+	if setting.RateLimitPolicyHeadersEnabled {
+		setRateLimitPolicyHeaders(c, statuses)
+	}
+}
+
+// setRateLimitHeaders 以剩余额度最少的维度设置 X-RateLimit-* 响应头
+func setRateLimitHeaders(c *gin.Context, statuses []RateLimitStatus) {
+	if len(statuses) == 0 {
+		return
+	}
+	tightest := statuses[0]
+	for _, status := range statuses[1:] {
+		if status.Remaining < tightest.Remaining {
+			tightest = status
+		}
+	}
+	c.Header("X-RateLimit-Limit", strconv.Itoa(tightest.Limit))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(tightest.Remaining))
+	c.Header("X-RateLimit-Reset", strconv.FormatInt(rateLimitResetHeaderValue(tightest, time.Now().Unix()), 10))
+}
+
+// rateLimitResetHeaderValue 按 RateLimitResetHeaderFormat 返回 X-RateLimit-Reset 的值
+func rateLimitResetHeaderValue(status RateLimitStatus, now int64) int64 {
+	if setting.RateLimitResetHeaderFormat == setting.RateLimitResetFormatUnix {
+		return status.ResetAt
+	}
+	if delta := status.ResetAt - now; delta > 0 {
+		return delta
+	}
+	return 0
+}
+
+// setRateLimitPolicyHeaders 按 RateLimit 头部草案（draft-ietf-httpapi-ratelimit-headers）以多个策略的形式
+// 同时列出所有生效的限流维度，例如：
+//
+//	RateLimit-Policy: "token_minute_total";q=60;w=60, "token_daily_total";q=1000;w=86400
+//	RateLimit: "token_minute_total";r=59;t=0, "token_daily_total";r=990;t=0
+func setRateLimitPolicyHeaders(c *gin.Context, statuses []RateLimitStatus) {
+	if len(statuses) == 0 {
+		return
+	}
+	policies := make([]string, 0, len(statuses))
+	limits := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		policies = append(policies, fmt.Sprintf("%q;q=%d;w=%d", status.Name, status.Limit, status.Window))
+		limits = append(limits, fmt.Sprintf("%q;r=%d;t=%d", status.Name, status.Remaining, status.Reset))
+	}
+	c.Header("RateLimit-Policy", strings.Join(policies, ", "))
+	c.Header("RateLimit", strings.Join(limits, ", "))
+}
+
+// setRateLimitHeadersForRequest 请求通过限流检查后，查询调用方所有生效维度的状态并写入响应头
+func setRateLimitHeadersForRequest(c *gin.Context) {
+	if !setting.RateLimitPolicyHeadersEnabled {
+		return
+	}
+	statuses, err := GetRateLimitStatuses(
+		common.GetContextKeyInt(c, constant.ContextKeyTokenId),
+		common.GetContextKeyString(c, constant.ContextKeyTokenGroup),
+		c.GetInt("id"),
+		common.GetContextKeyString(c, constant.ContextKeyUserGroup),
+	)
+	if err != nil {
+		common.SysLog("failed to peek rate limit status: " + err.Error())
+		return
+	}
+	setRateLimitHeaders(c, statuses)
+	setRateLimitPolicyHeaders(c, statuses)
+}
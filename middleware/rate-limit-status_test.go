@@ -0,0 +1,182 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRateLimitStatusHeadersDoNotConsume(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	common.SetRedisEnabled(false)
+	setting.TokenRateLimitEnabled = true
+	setting.TokenRateLimitCount = 5
+	setting.TokenRateLimitSuccessCount = 0
+	t.Cleanup(func() {
+		setting.TokenRateLimitEnabled = false
+		setting.TokenRateLimitCount = 0
+	})
+	inMemoryRateLimiter.Init(time.Minute)
+	if !inMemoryRateLimiter.Request(TokenRateLimitCountMark+"105", 5, 60) {
+		t.Fatal("first request should be allowed")
+	}
+
+	for i := 0; i < 3; i++ {
+		statuses, err := GetRateLimitStatuses(105, "", 0, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		SetRateLimitHeaders(c, statuses)
+		if got := w.Header().Get("X-RateLimit-Limit"); got != "5" {
+			t.Fatalf("X-RateLimit-Limit = %q, want 5", got)
+		}
+		if got := w.Header().Get("X-RateLimit-Remaining"); got != "4" {
+			t.Fatalf("query %d: X-RateLimit-Remaining = %q, want 4", i, got)
+		}
+	}
+}
+
+func TestRateLimitPolicyHeadersListMinuteAndDaily(t *testing.T) {
+	setupMemoryRateLimit(t, 60)
+	setting.TokenDailyRateLimitEnabled = true
+	setting.TokenDailyRateLimitCount = 1000
+	setting.RateLimitPolicyHeadersEnabled = true
+	t.Cleanup(func() {
+		setting.TokenDailyRateLimitEnabled = false
+		setting.TokenDailyRateLimitCount = 0
+		setting.RateLimitPolicyHeadersEnabled = false
+	})
+
+	serveModelRequest(1261, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	w := serveModelRequest(1261, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	wantPolicy := `"token_minute_total";q=60;w=60, "token_daily_total";q=1000;w=86400`
+	if got := w.Header().Get("RateLimit-Policy"); got != wantPolicy {
+		t.Fatalf("RateLimit-Policy = %q, want %q", got, wantPolicy)
+	}
+	limits := strings.Split(w.Header().Get("RateLimit"), ", ")
+	if len(limits) != 2 || !strings.HasPrefix(limits[0], `"token_minute_total";r=58;`) || !strings.HasPrefix(limits[1], `"token_daily_total";r=998;`) {
+		t.Fatalf("RateLimit = %q, want minute r=58 and daily r=998", w.Header().Get("RateLimit"))
+	}
+}
+
+func TestRateLimitPolicyHeadersDisabled(t *testing.T) {
+	setupMemoryRateLimit(t, 60)
+	w := serveModelRequest(1262, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	if got := w.Header().Get("RateLimit-Policy"); got != "" {
+		t.Fatalf("RateLimit-Policy = %q, want none when disabled", got)
+	}
+}
+
+func TestTokenRateLimitBreakdown(t *testing.T) {
+	setupMemoryRateLimit(t, 2)
+	setting.TokenRateLimitSuccessCount = 5
+	setting.TokenDailyRateLimitEnabled = true
+	setting.TokenDailyRateLimitCount = 1000
+	t.Cleanup(func() {
+		setting.TokenRateLimitSuccessCount = 0
+		setting.TokenDailyRateLimitEnabled = false
+		setting.TokenDailyRateLimitCount = 0
+	})
+
+	before := time.Now().Unix()
+	serveModelRequest(1321, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	serveModelRequest(1321, `{"model":"gpt-4o"}`, http.StatusBadGateway, nil)
+	if w := serveModelRequest(1321, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("third request: status %d, want 429", w.Code)
+	}
+
+	breakdowns, err := GetTokenRateLimitBreakdown(1321, "default", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name      string
+		used      int
+		limit     int
+		exhausted bool
+	}{
+		{"token_minute_total", 2, 2, true},
+		{"token_minute_success", 1, 5, false},
+		{"token_daily_total", 2, 1000, false},
+	}
+	if len(breakdowns) != len(want) {
+		t.Fatalf("breakdown = %+v, want %d dimensions", breakdowns, len(want))
+	}
+	for i, w := range want {
+		b := breakdowns[i]
+		if b.Name != w.name || b.Used != w.used || b.Limit != w.limit || b.Exhausted != w.exhausted || b.Remaining != w.limit-w.used {
+			t.Errorf("dimension %d = %+v, want %+v", i, b, w)
+		}
+		if b.OldestAt < before || b.OldestAt > time.Now().Unix() {
+			t.Errorf("%s oldest_at = %d, want the first counted request", b.Name, b.OldestAt)
+		}
+	}
+	if breakdowns[0].Reset <= 0 || breakdowns[0].Reset > 60 {
+		t.Errorf("exhausted dimension reset = %d, want within the window", breakdowns[0].Reset)
+	}
+
+	// 查询不消耗额度
+	again, err := GetTokenRateLimitBreakdown(1321, "default", 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again[2].Used != 2 {
+		t.Fatalf("daily used after second query = %d, want 2", again[2].Used)
+	}
+}
+
+func TestPreviewTokenGroupRateLimit(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	for tokenId, count := range map[int]int{1451: 6, 1452: 2} {
+		for i := 0; i < count; i++ {
+			inMemoryRateLimiter.Request(TokenRateLimitCountMark+strconv.Itoa(tokenId), 10, 60)
+		}
+	}
+	tokenIds := []int{1451, 1452, 1453}
+
+	cases := []struct {
+		total    int
+		affected []int
+	}{
+		{5, []int{1451}},
+		{2, []int{1451, 1452}},
+		{7, []int{}},
+		{0, []int{}},
+	}
+	for _, tc := range cases {
+		preview, err := PreviewTokenGroupRateLimit(tokenIds, "default", false, tc.total, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if preview.Checked != 3 || preview.Affected != len(tc.affected) || !reflect.DeepEqual(preview.TokenIds, tc.affected) {
+			t.Errorf("proposed total %d: preview = %+v, want affected %v", tc.total, preview, tc.affected)
+		}
+	}
+
+	// 预览每日限流时不受分钟级计数影响
+	preview, err := PreviewTokenGroupRateLimit(tokenIds, "default", true, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Affected != 0 {
+		t.Fatalf("daily preview with daily limits disabled = %+v, want none affected", preview)
+	}
+	// 预览不消耗额度
+	if got := tokenTotalCount(1451); got != 6 {
+		t.Fatalf("token count after preview = %d, want 6", got)
+	}
+}
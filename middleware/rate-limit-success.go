@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// 成功请求数限制的漏桶使用独立的 key，避免与列表计数的数据类型冲突
+const leakySuccessKeySuffix = ":leaky"
+
+var memoryLeakyBucket = limiter.NewMemoryLeakyBucket()
+
+// successLimiterAlgorithm 返回本次请求成功请求数限制使用的算法，令牌单独配置的算法优先于全局的 SuccessLimiterAlgorithm
+func successLimiterAlgorithm(c *gin.Context) string {
+	if algorithm := common.GetContextKeyString(c, constant.ContextKeyTokenRateLimitAlgorithm); algorithm != "" {
+		return algorithm
+	}
+	return setting.SuccessLimiterAlgorithm
+}
+
+func useLeakySuccessLimiter(algorithm string, maxCount int) bool {
+	return maxCount > 0 && algorithm == setting.RateLimitAlgorithmLeakyBucket
+}
+
+// leakySuccessOptions 每次成功注入 duration 单位的水量，每秒漏出 maxCount 单位，
+// 即长期平均速率为 duration 内 maxCount 次，桶容量决定允许的突发次数。
+func leakySuccessOptions(maxCount int, duration int64) []limiter.Option {
+	burst := (int64(maxCount)*int64(setting.SuccessLimiterBurstPercent) + 99) / 100
+	if burst < 1 {
+		burst = 1
+	}
+	return []limiter.Option{
+		limiter.WithCapacity(burst * duration),
+		limiter.WithRate(int64(maxCount)),
+		limiter.WithRequested(duration),
+	}
+}
+
+// checkRedisSuccessLimit 按 algorithm 检查成功请求数限制
+// Redis 响应超时时改用同名 key 的内存计数检查
+func checkRedisSuccessLimit(ctx context.Context, rdb *redis.Client, algorithm string, key string, maxCount int, duration int64) (bool, error) {
+	ctx, cancel := withRedisTimeout(ctx)
+	defer cancel()
+	var allowed bool
+	var err error
+	if useLeakySuccessLimiter(algorithm, maxCount) {
+		allowed, _, err = limiter.New(ctx, rdb).LeakyCheck(ctx, key+leakySuccessKeySuffix, leakySuccessOptions(maxCount, duration)...)
+	} else {
+		allowed, err = checkRedisRateLimit(ctx, rdb, key, maxCount, duration)
+	}
+	if isRedisSlow(err) {
+		noteRedisFallback(key, err)
+		return checkMemorySuccessLimit(algorithm, key, maxCount, duration), nil
+	}
+	return allowed, err
+}
+
+// recordRedisSuccess 按 algorithm 记录一次成功请求，Redis 响应超时时记录到同名 key 的内存计数中
+func recordRedisSuccess(ctx context.Context, rdb *redis.Client, algorithm string, key string, maxCount int, duration int64) {
+	ctx, cancel := withRedisTimeout(ctx)
+	defer cancel()
+	var err error
+	if useLeakySuccessLimiter(algorithm, maxCount) {
+		err = limiter.New(ctx, rdb).LeakyAdd(ctx, key+leakySuccessKeySuffix, leakySuccessOptions(maxCount, duration)...)
+	} else {
+		err = recordRedisRequest(ctx, rdb, key, maxCount)
+	}
+	if isRedisSlow(err) {
+		noteRedisFallback(key, err)
+		recordMemorySuccess(algorithm, key, maxCount, duration)
+	}
+}
+
+// checkMemorySuccessLimit 内存版本的成功请求数限制检查，successKey 为记录成功请求使用的 key。
+// maxCount 为 0 表示不限制成功请求数；检查只读取已记录的成功请求，失败请求不占用名额
+func checkMemorySuccessLimit(algorithm string, successKey string, maxCount int, duration int64) bool {
+	if maxCount <= 0 {
+		return true
+	}
+	if useLeakySuccessLimiter(algorithm, maxCount) {
+		memoryLeakyBucket.Init(common.RateLimitKeyExpirationDuration)
+		allowed, _ := memoryLeakyBucket.Check(successKey, leakySuccessOptions(maxCount, duration)...)
+		return allowed
+	}
+	count, _ := inMemoryRateLimiter.Peek(successKey, duration)
+	return count < maxCount
+}
+
+// recordMemorySuccess 内存版本的成功请求记录
+func recordMemorySuccess(algorithm string, successKey string, maxCount int, duration int64) {
+	if maxCount <= 0 {
+		return
+	}
+	if useLeakySuccessLimiter(algorithm, maxCount) {
+		memoryLeakyBucket.Add(successKey, leakySuccessOptions(maxCount, duration)...)
+		return
+	}
+	inMemoryRateLimiter.Request(successKey, maxCount, duration)
+}
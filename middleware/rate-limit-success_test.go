@@ -0,0 +1,211 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// burstSuccesses 连续记录成功请求，返回被放行的次数
+func burstSuccesses(algorithm string, key string, maxCount int, duration int64, attempts int) int {
+	allowed := 0
+	for i := 0; i < attempts; i++ {
+		if !checkMemorySuccessLimit(algorithm, key, maxCount, duration) {
+			continue
+		}
+		recordMemorySuccess(algorithm, key, maxCount, duration)
+		allowed++
+	}
+	return allowed
+}
+
+func TestLeakySuccessLimiterSmoothsBursts(t *testing.T) {
+	common.SetRedisEnabled(false)
+	inMemoryRateLimiter.Init(time.Minute)
+	setting.SuccessLimiterBurstPercent = 20
+	t.Cleanup(func() { setting.SuccessLimiterBurstPercent = 10 })
+
+	// 列表计数允许在窗口开始时一次用完全部名额
+	if got := burstSuccesses(setting.RateLimitAlgorithmSlidingWindow, "rateLimit:test:success:window", 10, 60, 20); got != 10 {
+		t.Fatalf("sliding window burst allowed %d, want 10", got)
+	}
+	// 漏桶只允许 20% 的突发，其余按每 6 秒一次的平均速率放行
+	if got := burstSuccesses(setting.RateLimitAlgorithmLeakyBucket, "rateLimit:test:success:leaky", 10, 60, 20); got != 2 {
+		t.Fatalf("leaky bucket burst allowed %d, want 2", got)
+	}
+}
+
+func TestLeakySuccessOptions(t *testing.T) {
+	setting.SuccessLimiterBurstPercent = 0
+	t.Cleanup(func() { setting.SuccessLimiterBurstPercent = 10 })
+	// 突发量至少为 1 次
+	config := limiter.ResolveConfig(leakySuccessOptions(10, 60)...)
+	if config.Capacity != 60 || config.Rate != 10 || config.Requested != 60 {
+		t.Fatalf("config = %+v, want capacity 60 rate 10 requested 60", config)
+	}
+}
+
+// serveTokenAlgorithmRequest 以指定了成功请求数限制算法的令牌发送一次成功的请求，algorithm 为空时跟随全局设置
+func serveTokenAlgorithmRequest(tokenId int, algorithm string) int {
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyTokenId, tokenId)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "default")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		if algorithm != "" {
+			common.SetContextKey(c, constant.ContextKeyTokenRateLimitAlgorithm, algorithm)
+		}
+		c.Next()
+	}, ModelRequestRateLimit(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`)))
+	return w.Code
+}
+
+func TestPerTokenSuccessLimiterAlgorithm(t *testing.T) {
+	setupMemoryRateLimit(t, 0)
+	setting.TokenRateLimitSuccessCount = 10
+	setting.SuccessLimiterBurstPercent = 20
+	t.Cleanup(func() {
+		setting.TokenRateLimitSuccessCount = 0
+		setting.SuccessLimiterBurstPercent = 10
+	})
+
+	burst := func(tokenId int, algorithm string) int {
+		allowed := 0
+		for i := 0; i < 20; i++ {
+			if serveTokenAlgorithmRequest(tokenId, algorithm) == http.StatusOK {
+				allowed++
+			}
+		}
+		return allowed
+	}
+	// 全局为滑动窗口，令牌可以一次用完全部名额
+	if got := burst(1421, ""); got != 10 {
+		t.Fatalf("token following the global algorithm allowed %d, want 10", got)
+	}
+	// 指定漏桶的令牌只允许少量突发
+	if got := burst(1422, setting.RateLimitAlgorithmLeakyBucket); got != 2 {
+		t.Fatalf("leaky bucket token allowed %d, want 2", got)
+	}
+
+	// 全局改为漏桶后，指定滑动窗口的令牌仍按滑动窗口计数
+	setting.SuccessLimiterAlgorithm = setting.RateLimitAlgorithmLeakyBucket
+	t.Cleanup(func() { setting.SuccessLimiterAlgorithm = setting.RateLimitAlgorithmSlidingWindow })
+	if got := burst(1423, setting.RateLimitAlgorithmSlidingWindow); got != 10 {
+		t.Fatalf("sliding window token allowed %d, want 10", got)
+	}
+}
+
+// setTokenGroupLimits 为 default 分组配置 [总请求数, 成功请求数]
+func setTokenGroupLimits(t *testing.T, limits string) {
+	t.Helper()
+	if err := setting.CheckTokenRateLimitGroup(`{"default":` + limits + `}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := setting.UpdateTokenRateLimitGroupByJSONString(`{"default":` + limits + `}`); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = setting.UpdateTokenRateLimitGroupByJSONString("{}") })
+}
+
+// assertSuccessOnlyLimit 分组配置为 [0, 3]：失败请求不受限制，成功请求恰好允许 3 次
+func assertSuccessOnlyLimit(t *testing.T, tokenId int) {
+	t.Helper()
+	for i := 0; i < 10; i++ {
+		if w := serveModelRequest(tokenId, `{"model":"gpt-4o"}`, http.StatusInternalServerError, nil); w.Code != http.StatusInternalServerError {
+			t.Fatalf("failed request %d: status %d, want unlimited totals", i+1, w.Code)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		if w := serveModelRequest(tokenId, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("success %d: status %d", i+1, w.Code)
+		}
+	}
+	if w := serveModelRequest(tokenId, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("4th success: status %d, want 429", w.Code)
+	}
+}
+
+func TestSuccessOnlyGroupLimitMemory(t *testing.T) {
+	setupMemoryRateLimit(t, 0)
+	setTokenGroupLimits(t, "[0, 3]")
+	assertSuccessOnlyLimit(t, 1551)
+}
+
+func TestSuccessOnlyGroupLimitRedis(t *testing.T) {
+	setupMemoryRateLimit(t, 0)
+	setTokenGroupLimits(t, "[0, 3]")
+	f, rdb := startFakeRedis(t)
+	oldRDB := common.RDB()
+	common.SetRDB(rdb)
+	common.SetRedisEnabled(true)
+	t.Cleanup(func() {
+		common.SetRDB(oldRDB)
+		common.SetRedisEnabled(false)
+	})
+
+	assertSuccessOnlyLimit(t, 1552)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if got := len(f.lists["rateLimit:"+TokenRateLimitSuccessCountMark+":1552"]); got != 3 {
+		t.Fatalf("redis success list length = %d, want 3", got)
+	}
+}
+
+func TestTotalOnlyGroupLimitMemory(t *testing.T) {
+	setupMemoryRateLimit(t, 0)
+	setTokenGroupLimits(t, "[2, 0]")
+
+	for i := 0; i < 2; i++ {
+		if w := serveModelRequest(1553, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, w.Code)
+		}
+	}
+	if w := serveModelRequest(1553, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("3rd request: status %d, want 429 from the total limit", w.Code)
+	}
+	if count, _ := inMemoryRateLimiter.Peek(TokenRateLimitSuccessCountMark+"1553", 60); count != 0 {
+		t.Fatalf("recorded %d successes with an unlimited success count, want 0", count)
+	}
+}
+
+func TestZeroSuccessLimitIsUnlimited(t *testing.T) {
+	setupMemoryRateLimit(t, 0)
+	for _, algorithm := range []string{"", setting.RateLimitAlgorithmLeakyBucket} {
+		for i := 0; i < 5; i++ {
+			recordMemorySuccess(algorithm, "zero-success", 0, 60)
+			if !checkMemorySuccessLimit(algorithm, "zero-success", 0, 60) {
+				t.Fatalf("algorithm %q: success limit 0 rejected a request", algorithm)
+			}
+		}
+	}
+	if count, _ := inMemoryRateLimiter.Peek("zero-success", 60); count != 0 {
+		t.Fatalf("memory limiter recorded %d successes for limit 0", count)
+	}
+
+	// Redis 路径同样不检查也不记录
+	f, rdb := startFakeRedis(t)
+	recordRedisSuccess(context.Background(), rdb, "", "rateLimit:zero-success", 0, 60)
+	allowed, err := checkRedisSuccessLimit(context.Background(), rdb, "", "rateLimit:zero-success", 0, 60)
+	if err != nil || !allowed {
+		t.Fatalf("redis success limit 0: allowed=%v err=%v, want allowed", allowed, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.lists) != 0 {
+		t.Fatalf("redis lists %v, want nothing recorded", f.lists)
+	}
+}
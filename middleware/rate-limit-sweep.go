@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 清理时每次 SCAN 返回的 key 数量建议值
+const rateLimitSweepScanCount = 500
+
+// successListWindow 根据成功请求数列表 key 的标识返回其时间窗口（秒），非成功请求数列表返回 0
+func successListWindow(key string) int64 {
+	parts := strings.Split(key, ":")
+	if len(parts) != 3 {
+		return 0
+	}
+	switch parts[1] {
+	case ModelRequestRateLimitSuccessCountMark:
+		return int64(setting.ModelRequestRateLimitDurationMinutes * 60)
+	case TokenRateLimitSuccessCountMark:
+		return int64(setting.TokenRateLimitDurationMinutes * 60)
+	case OrgRateLimitSuccessCountMark:
+		return int64(setting.OrgRateLimitDurationMinutes * 60)
+	case PlaygroundRateLimitSuccessCountMark:
+		return int64(setting.PlaygroundRateLimitDurationMinutes * 60)
+	case TokenDailyRateLimitSuccessCountMark, UserDailyRateLimitSuccessCountMark:
+		return 86400
+	}
+	return 0
+}
+
+// sweepRateLimitKeys 遍历 Redis 中的限流 key：最近一次成功请求已滑出窗口的列表直接删除；
+// 没有过期时间的令牌桶（旧版本写入）补上过期时间，等待其自然过期。返回仍在使用的 key 数量与删除的数量。
+func sweepRateLimitKeys(ctx context.Context) (active int64, removed int64, err error) {
+	rdb := common.RDB()
+	now := time.Now()
+	var cursor uint64
+	for {
+		var keys []string
+		keys, cursor, err = rdb.Scan(ctx, cursor, "rateLimit:*", rateLimitSweepScanCount).Result()
+		if err != nil {
+			return active, removed, err
+		}
+		for _, key := range keys {
+			if window := successListWindow(key); window > 0 {
+				newest, err := rdb.LIndex(ctx, key, 0).Result()
+				if err == nil {
+					if t, err := time.Parse(timeFormat, newest); err == nil && int64(now.Sub(t).Seconds()) >= window {
+						if rdb.Del(ctx, key).Err() == nil {
+							removed++
+						}
+						continue
+					}
+				}
+			} else if ttl, err := rdb.TTL(ctx, key).Result(); err == nil && ttl == -1 {
+				// 令牌桶最长一天补满，补满后与不存在的 key 等价
+				rdb.Expire(ctx, key, 24*time.Hour)
+			}
+			active++
+		}
+		if cursor == 0 {
+			return active, removed, nil
+		}
+	}
+}
+
+// idleRateLimitKey 淘汰候选的限流 key 及其闲置时长
+type idleRateLimitKey struct {
+	key  string
+	idle time.Duration
+}
+
+// evictIdleRateLimitKeys 限流 key 数量超过上限时，按闲置时长（OBJECT IDLETIME）从久到近删除至多 excess 个 key。
+// 闲置不足 RateLimitEvictMinIdleSeconds 的 key 不会被删除，因此正在使用的限流计数不受影响，实际删除数量可能少于 excess
+func evictIdleRateLimitKeys(ctx context.Context, excess int64) (int64, error) {
+	if excess <= 0 {
+		return 0, nil
+	}
+	rdb := common.RDB()
+	minIdle := time.Duration(setting.RateLimitEvictMinIdleSeconds) * time.Second
+	var candidates []idleRateLimitKey
+	var cursor uint64
+	for {
+		keys, next, err := rdb.Scan(ctx, cursor, "rateLimit:*", rateLimitSweepScanCount).Result()
+		if err != nil {
+			return 0, err
+		}
+		if len(keys) > 0 {
+			pipe := rdb.Pipeline()
+			cmds := make([]*redis.DurationCmd, len(keys))
+			for i, key := range keys {
+				cmds[i] = pipe.ObjectIdleTime(ctx, key)
+			}
+			// 部分 key 可能已过期，单个命令出错不影响其它 key
+			_, _ = pipe.Exec(ctx)
+			for i, cmd := range cmds {
+				if idle, err := cmd.Result(); err == nil && idle >= minIdle {
+					candidates = append(candidates, idleRateLimitKey{key: keys[i], idle: idle})
+				}
+			}
+		}
+		cursor = next
+		if cursor == 0 {
+			break
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].idle > candidates[j].idle })
+	if int64(len(candidates)) > excess {
+		candidates = candidates[:excess]
+	}
+	var evicted int64
+	for start := 0; start < len(candidates); start += rateLimitSweepScanCount {
+		end := min(start+rateLimitSweepScanCount, len(candidates))
+		keys := make([]string, 0, end-start)
+		for _, candidate := range candidates[start:end] {
+			keys = append(keys, candidate.key)
+		}
+		removed, err := rdb.Del(ctx, keys...).Result()
+		if err != nil {
+			return evicted, err
+		}
+		evicted += removed
+	}
+	return evicted, nil
+}
+
+// StartRateLimitKeySweeper 定期清理 Redis 中闲置的限流 key，并记录仍在使用的 key 数量
+func StartRateLimitKeySweeper() {
+	for {
+		interval := setting.RateLimitKeySweepIntervalSeconds
+		if interval <= 0 {
+			time.Sleep(time.Minute)
+			continue
+		}
+		time.Sleep(time.Duration(interval) * time.Second)
+		if !common.RedisEnabled() {
+			continue
+		}
+		// 清理时读取列表会刷新 key 的闲置时长，因此按上一次清理统计的数量先淘汰，再清理
+		if maxKeys, last := int64(setting.RateLimitMaxKeys), atomic.LoadInt64(&rateLimitStats.activeKeys); maxKeys > 0 && last > maxKeys {
+			evicted, err := evictIdleRateLimitKeys(context.Background(), last-maxKeys)
+			if err != nil {
+				common.SysLog("failed to evict idle rate limit keys: " + err.Error())
+			}
+			atomic.AddInt64(&rateLimitStats.evictedKeys, evicted)
+			common.SysLog(fmt.Sprintf("rate limit keys exceed cap %d: %d idle keys evicted", maxKeys, evicted))
+		}
+		active, removed, err := sweepRateLimitKeys(context.Background())
+		if err != nil {
+			common.SysLog("failed to sweep rate limit keys: " + err.Error())
+			continue
+		}
+		atomic.StoreInt64(&rateLimitStats.activeKeys, active)
+		atomic.AddInt64(&rateLimitStats.sweptKeys, removed)
+		atomic.StoreInt64(&rateLimitStats.lastSweepAt, time.Now().Unix())
+		if removed > 0 {
+			common.SysLog(fmt.Sprintf("rate limit key sweep: %d active, %d idle keys removed", active, removed))
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func TestSweepRateLimitKeys(t *testing.T) {
+	f, rdb := startFakeRedis(t)
+	oldRDB := common.RDB()
+	t.Cleanup(func() { common.SetRDB(oldRDB) })
+	common.SetRDB(rdb)
+
+	now := time.Now()
+	window := time.Duration(setting.ModelRequestRateLimitDurationMinutes) * time.Minute
+	drained := "rateLimit:" + ModelRequestRateLimitSuccessCountMark + ":1"
+	active := "rateLimit:" + ModelRequestRateLimitSuccessCountMark + ":2"
+	bucket := "rateLimit:" + ModelRequestRateLimitCountMark + ":3"
+	f.mu.Lock()
+	// 最近一次成功请求已滑出窗口
+	f.lists[drained] = []string{now.Add(-window - time.Minute).Format(timeFormat), now.Add(-2 * window).Format(timeFormat)}
+	f.lists[active] = []string{now.Format(timeFormat), now.Add(-2 * window).Format(timeFormat)}
+	// 旧版本写入的没有过期时间的令牌桶
+	f.hashes[bucket] = map[string]string{"tokens": "60", "last_time": "1"}
+	f.hashes["other:key"] = map[string]string{"a": "1"}
+	f.mu.Unlock()
+
+	activeCount, removed, err := sweepRateLimitKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if activeCount != 2 || removed != 1 {
+		t.Fatalf("sweep = %d active, %d removed, want 2 active, 1 removed", activeCount, removed)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.lists[drained]; ok {
+		t.Error("drained success list was not removed")
+	}
+	if _, ok := f.lists[active]; !ok {
+		t.Error("active success list was removed")
+	}
+	if _, ok := f.hashes[bucket]; !ok || !f.expires[bucket] {
+		t.Error("token bucket without TTL should be kept with an expiry")
+	}
+	if _, ok := f.hashes["other:key"]; !ok || f.expires["other:key"] {
+		t.Error("non rate limit key was touched")
+	}
+}
+
+func TestSuccessListWindow(t *testing.T) {
+	cases := map[string]int64{
+		"rateLimit:" + ModelRequestRateLimitSuccessCountMark + ":1": int64(setting.ModelRequestRateLimitDurationMinutes * 60),
+		"rateLimit:" + TokenRateLimitSuccessCountMark + ":1":        int64(setting.TokenRateLimitDurationMinutes * 60),
+		"rateLimit:" + UserDailyRateLimitSuccessCountMark + ":1":    86400,
+		"rateLimit:" + ModelRequestRateLimitCountMark + ":1":        0,
+		"rateLimit:" + TokenRateLimitSuccessCountMark + ":1:extra":  0,
+	}
+	for key, want := range cases {
+		if got := successListWindow(key); got != want {
+			t.Errorf("successListWindow(%q) = %d, want %d", key, got, want)
+		}
+	}
+}
+
+func TestEvictIdleRateLimitKeysOverCap(t *testing.T) {
+	f, rdb := startFakeRedis(t)
+	oldRDB := common.RDB()
+	oldMinIdle := setting.RateLimitEvictMinIdleSeconds
+	t.Cleanup(func() {
+		common.SetRDB(oldRDB)
+		setting.RateLimitEvictMinIdleSeconds = oldMinIdle
+	})
+	common.SetRDB(rdb)
+	f.scanPageSize = 3
+	setting.RateLimitEvictMinIdleSeconds = 60
+
+	// key 持续增长到 10 个：4 个正在使用，6 个闲置时长不同
+	recent := []string{time.Now().Format(timeFormat)}
+	f.mu.Lock()
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("rateLimit:%s:182%d", ModelRequestRateLimitSuccessCountMark, i)
+		f.lists[key] = recent
+		if i >= 4 {
+			f.idle[key] = int64(i * 100)
+		}
+	}
+	f.mu.Unlock()
+
+	active, _, err := sweepRateLimitKeys(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	const maxKeys = 6
+	if active <= maxKeys {
+		t.Fatalf("active keys = %d, want more than the cap %d", active, maxKeys)
+	}
+	evicted, err := evictIdleRateLimitKeys(context.Background(), active-maxKeys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evicted != 4 {
+		t.Fatalf("evicted %d keys, want 4", evicted)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// 闲置最久的 4 个被淘汰，正在使用的 key 保留
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("rateLimit:%s:182%d", ModelRequestRateLimitSuccessCountMark, i)
+		_, kept := f.lists[key]
+		if wantKept := i < 6; kept != wantKept {
+			t.Errorf("key %s kept = %v, want %v", key, kept, wantKept)
+		}
+	}
+}
+
+func TestEvictIdleRateLimitKeysSparesActiveKeys(t *testing.T) {
+	f, rdb := startFakeRedis(t)
+	oldRDB := common.RDB()
+	oldMinIdle := setting.RateLimitEvictMinIdleSeconds
+	t.Cleanup(func() {
+		common.SetRDB(oldRDB)
+		setting.RateLimitEvictMinIdleSeconds = oldMinIdle
+	})
+	common.SetRDB(rdb)
+	setting.RateLimitEvictMinIdleSeconds = 60
+
+	idleKey := "rateLimit:" + ModelRequestRateLimitCountMark + ":1829"
+	activeKey := "rateLimit:" + ModelRequestRateLimitCountMark + ":1830"
+	f.mu.Lock()
+	f.hashes[idleKey] = map[string]string{"tokens": "1"}
+	f.hashes[activeKey] = map[string]string{"tokens": "1"}
+	f.hashes["other:key"] = map[string]string{"a": "1"}
+	f.idle[idleKey] = 3600
+	f.idle[activeKey] = 59
+	f.idle["other:key"] = 7200
+	f.mu.Unlock()
+
+	// 超出的数量多于可淘汰的闲置 key 时，只淘汰闲置足够久的限流 key
+	evicted, err := evictIdleRateLimitKeys(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evicted != 1 {
+		t.Fatalf("evicted %d keys, want 1", evicted)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.hashes[idleKey]; ok {
+		t.Error("idle key was not evicted")
+	}
+	if _, ok := f.hashes[activeKey]; !ok {
+		t.Error("key idle less than RateLimitEvictMinIdleSeconds was evicted")
+	}
+	if _, ok := f.hashes["other:key"]; !ok {
+		t.Error("non rate limit key was evicted")
+	}
+	if n, err := evictIdleRateLimitKeys(context.Background(), 0); n != 0 || err != nil {
+		t.Errorf("evict with no excess = %d, %v, want 0, nil", n, err)
+	}
+}
@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const rateLimitTracerName = "github.com/QuantumNous/new-api/middleware/ratelimit"
+
+// 开启 EnableTracing 但没有设置全局 TracerProvider 时只提示一次
+var tracerProviderMissingOnce sync.Once
+
+// rateLimitKeyHash 限流 key 中包含用户/令牌 ID，写入 span 前先做哈希
+func rateLimitKeyHash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// startRateLimitSpan 为一次 Redis 限流检查创建 span，父 span 取自请求上下文。
+// 返回的 context 不随请求取消，避免客户端断开导致 Redis 调用出错；未开启 EnableTracing 时返回不记录的 span。
+func startRateLimitSpan(c *gin.Context, limitType string, key string) (context.Context, trace.Span) {
+	if !setting.EnableTracing {
+		ctx := context.Background()
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	ctx, span := otel.Tracer(rateLimitTracerName).Start(c.Request.Context(), "ratelimit.check",
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(
+			attribute.String("ratelimit.limit_type", limitType),
+			attribute.String("ratelimit.key_hash", rateLimitKeyHash(key)),
+		),
+	)
+	if !span.IsRecording() {
+		tracerProviderMissingOnce.Do(func() {
+			common.SysLog("EnableTracing is on but no global OpenTelemetry TracerProvider is set, rate limit spans are not recorded")
+		})
+	}
+	return context.WithoutCancel(ctx), span
+}
+
+// endRateLimitSpan 记录本次检查的判定结果并结束 span
+func endRateLimitSpan(span trace.Span, limitType string, maxCount int, allowed bool, err error) {
+	if !span.IsRecording() {
+		return
+	}
+	decision := "allow"
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		decision = "error"
+	} else if !allowed {
+		decision = "deny"
+	}
+	span.AddEvent("ratelimit."+decision, trace.WithAttributes(
+		attribute.String("ratelimit.limit_type", limitType),
+		attribute.Int("ratelimit.count", maxCount),
+		attribute.Bool("ratelimit.allowed", allowed),
+	))
+	span.End()
+}
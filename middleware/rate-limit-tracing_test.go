@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/embedded"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// recordingProvider 在内存中记录创建的 span，用于断言限流判定写入的事件
+type recordingProvider struct {
+	embedded.TracerProvider
+	mu    sync.Mutex
+	spans []*recordingSpan
+}
+
+func (p *recordingProvider) Tracer(string, ...trace.TracerOption) trace.Tracer {
+	return &recordingTracer{provider: p}
+}
+
+type recordingTracer struct {
+	embedded.Tracer
+	provider *recordingProvider
+}
+
+func (t *recordingTracer) Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	config := trace.NewSpanStartConfig(opts...)
+	span := &recordingSpan{
+		name:   name,
+		parent: trace.SpanFromContext(ctx),
+		attrs:  config.Attributes(),
+	}
+	t.provider.mu.Lock()
+	t.provider.spans = append(t.provider.spans, span)
+	t.provider.mu.Unlock()
+	return trace.ContextWithSpan(ctx, span), span
+}
+
+type recordedEvent struct {
+	name  string
+	attrs []attribute.KeyValue
+}
+
+type recordingSpan struct {
+	noop.Span
+	name   string
+	parent trace.Span
+	attrs  []attribute.KeyValue
+	events []recordedEvent
+	status codes.Code
+	ended  bool
+}
+
+func (s *recordingSpan) IsRecording() bool { return !s.ended }
+
+func (s *recordingSpan) AddEvent(name string, opts ...trace.EventOption) {
+	config := trace.NewEventConfig(opts...)
+	s.events = append(s.events, recordedEvent{name: name, attrs: config.Attributes()})
+}
+
+func (s *recordingSpan) RecordError(err error, opts ...trace.EventOption) {
+	s.AddEvent("exception", opts...)
+}
+
+func (s *recordingSpan) SetStatus(code codes.Code, _ string) { s.status = code }
+
+func (s *recordingSpan) End(...trace.SpanEndOption) { s.ended = true }
+
+func attrValue(attrs []attribute.KeyValue, key string) (attribute.Value, bool) {
+	for _, kv := range attrs {
+		if string(kv.Key) == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func setupRecordingTracer(t *testing.T, enabled bool) *recordingProvider {
+	t.Helper()
+	provider := &recordingProvider{}
+	old := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	setting.EnableTracing = enabled
+	t.Cleanup(func() {
+		otel.SetTracerProvider(old)
+		setting.EnableTracing = false
+	})
+	return provider
+}
+
+func TestRateLimitSpanRecordsDecision(t *testing.T) {
+	provider := setupRecordingTracer(t, true)
+
+	parent := &recordingSpan{name: "request"}
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	c.Request = c.Request.WithContext(trace.ContextWithSpan(c.Request.Context(), parent))
+
+	_, span := startRateLimitSpan(c, "token_total", "rateLimit:MRRL:42")
+	endRateLimitSpan(span, "token_total", 10, false, nil)
+	_, span = startRateLimitSpan(c, "token_success", "rateLimit:MRRLS:42")
+	endRateLimitSpan(span, "token_success", 5, true, nil)
+	_, span = startRateLimitSpan(c, "user_total", "rateLimit:MRRL:user:42")
+	endRateLimitSpan(span, "user_total", 10, false, errors.New("redis down"))
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if len(provider.spans) != 3 {
+		t.Fatalf("recorded %d spans, want 3", len(provider.spans))
+	}
+	wantEvents := []string{"ratelimit.deny", "ratelimit.allow", "ratelimit.error"}
+	for i, span := range provider.spans {
+		if span.name != "ratelimit.check" || !span.ended {
+			t.Errorf("span %d: name %q ended %v", i, span.name, span.ended)
+		}
+		// span 挂在请求已有的 span 下
+		if span.parent != parent {
+			t.Errorf("span %d is not a child of the request span", i)
+		}
+		last := span.events[len(span.events)-1]
+		if last.name != wantEvents[i] {
+			t.Errorf("span %d event = %q, want %q", i, last.name, wantEvents[i])
+		}
+		if _, ok := attrValue(last.attrs, "ratelimit.count"); !ok {
+			t.Errorf("span %d event missing ratelimit.count", i)
+		}
+	}
+
+	deny := provider.spans[0]
+	if v, _ := attrValue(deny.attrs, "ratelimit.limit_type"); v.AsString() != "token_total" {
+		t.Errorf("limit_type = %q, want token_total", v.AsString())
+	}
+	// key 中包含令牌 ID，只记录哈希
+	if v, _ := attrValue(deny.attrs, "ratelimit.key_hash"); v.AsString() != rateLimitKeyHash("rateLimit:MRRL:42") || v.AsString() == "rateLimit:MRRL:42" {
+		t.Errorf("key_hash = %q", v.AsString())
+	}
+	if v, _ := attrValue(deny.events[0].attrs, "ratelimit.count"); v.AsInt64() != 10 {
+		t.Errorf("count = %d, want 10", v.AsInt64())
+	}
+	if provider.spans[2].status != codes.Error {
+		t.Errorf("error span status = %v, want Error", provider.spans[2].status)
+	}
+}
+
+func TestRateLimitSpanDisabled(t *testing.T) {
+	provider := setupRecordingTracer(t, false)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	_, span := startRateLimitSpan(c, "token_total", "rateLimit:MRRL:42")
+	endRateLimitSpan(span, "token_total", 10, false, nil)
+
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+	if len(provider.spans) != 0 {
+		t.Fatalf("recorded %d spans with tracing disabled", len(provider.spans))
+	}
+}
@@ -0,0 +1,157 @@
+package middleware
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// TokenUsageSnapshot 单个令牌当前的分钟级与每日请求计数，用于账单核对
+type TokenUsageSnapshot struct {
+	TokenId       int    `json:"token_id"`
+	UserId        int    `json:"user_id"`
+	TokenName     string `json:"token_name"`
+	Group         string `json:"group"`
+	MinuteTotal   int    `json:"minute_total"`
+	MinuteSuccess int    `json:"minute_success"`
+	DailyTotal    int    `json:"daily_total"`
+	DailySuccess  int    `json:"daily_success"`
+}
+
+// 令牌分钟级与每日计数使用的标识，按长度降序排列，便于内存模式下按前缀解析 key
+var tokenUsageMarks = []string{
+	TokenDailyRateLimitSuccessCountMark,
+	TokenDailyRateLimitCountMark,
+	TokenRateLimitSuccessCountMark,
+	TokenRateLimitCountMark,
+}
+
+// parseTokenUsageKey 从限流 key 中解析令牌 ID。Redis 的 key 形如 rateLimit:TRL:12，内存中的 key 形如 TRL12；
+// 按 IP、按客户细分的 key 不计入
+func parseTokenUsageKey(key string) (int, bool) {
+	if rest, ok := strings.CutPrefix(key, "rateLimit:"); ok {
+		mark, id, found := strings.Cut(rest, ":")
+		if !found || strings.Contains(id, ":") {
+			return 0, false
+		}
+		for _, m := range tokenUsageMarks {
+			if mark == m {
+				tokenId, err := strconv.Atoi(id)
+				return tokenId, err == nil && tokenId > 0
+			}
+		}
+		return 0, false
+	}
+	for _, m := range tokenUsageMarks {
+		if id, ok := strings.CutPrefix(key, m); ok {
+			tokenId, err := strconv.Atoi(id)
+			return tokenId, err == nil && tokenId > 0
+		}
+	}
+	return 0, false
+}
+
+// scanTokenUsageIds 找出当前存在计数的全部令牌 ID。Redis 模式下使用 SCAN 游标分批遍历，不会像 KEYS 一样阻塞 Redis
+func scanTokenUsageIds(ctx context.Context, rdb *redis.Client) ([]int, error) {
+	seen := make(map[int]struct{})
+	if rdb == nil {
+		for key := range inMemoryRateLimiter.Export() {
+			if tokenId, ok := parseTokenUsageKey(key); ok {
+				seen[tokenId] = struct{}{}
+			}
+		}
+	} else {
+		var cursor uint64
+		for {
+			keys, next, err := rdb.Scan(ctx, cursor, "rateLimit:T*", rateLimitSweepScanCount).Result()
+			if err != nil {
+				return nil, err
+			}
+			for _, key := range keys {
+				if tokenId, ok := parseTokenUsageKey(key); ok {
+					seen[tokenId] = struct{}{}
+				}
+			}
+			cursor = next
+			if cursor == 0 {
+				break
+			}
+		}
+	}
+	ids := make([]int, 0, len(seen))
+	for tokenId := range seen {
+		ids = append(ids, tokenId)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+// ExportTokenUsage 导出所有存在计数的令牌当前的分钟级与每日请求计数，只读，不消耗任何额度。
+// 计数按令牌所属分组当前生效的上限读取，已删除的令牌不导出
+func ExportTokenUsage(ctx context.Context) ([]TokenUsageSnapshot, error) {
+	var rdb *redis.Client
+	if common.RedisEnabled() {
+		rdb = rateLimitReadClient()
+	}
+	ids, err := scanTokenUsageIds(ctx, rdb)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := model.GetTokensBasicByIds(ids)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Id < tokens[j].Id })
+	snapshots := make([]TokenUsageSnapshot, 0, len(tokens))
+	for _, token := range tokens {
+		snapshot := TokenUsageSnapshot{
+			TokenId:   token.Id,
+			UserId:    token.UserId,
+			TokenName: token.Name,
+			Group:     token.Group,
+		}
+		for _, dimension := range tokenRateLimitDimensions(token.Id, token.Group) {
+			breakdown, err := dimension.inspect(ctx, rdb)
+			if err != nil {
+				return nil, err
+			}
+			switch dimension.name {
+			case "token_minute_total":
+				snapshot.MinuteTotal = breakdown.Used
+			case "token_minute_success":
+				snapshot.MinuteSuccess = breakdown.Used
+			case "token_daily_total":
+				snapshot.DailyTotal = breakdown.Used
+			case "token_daily_success":
+				snapshot.DailySuccess = breakdown.Used
+			}
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+// TokenUsageCSVHeader 与 TokenUsageSnapshot.CSVRecord 对应的表头
+var TokenUsageCSVHeader = []string{"token_id", "user_id", "token_name", "group", "minute_total", "minute_success", "daily_total", "daily_success", "captured_at"}
+
+// CSVRecord 将快照转为一行 CSV 记录
+func (s TokenUsageSnapshot) CSVRecord(capturedAt time.Time) []string {
+	return []string{
+		strconv.Itoa(s.TokenId),
+		strconv.Itoa(s.UserId),
+		s.TokenName,
+		s.Group,
+		strconv.Itoa(s.MinuteTotal),
+		strconv.Itoa(s.MinuteSuccess),
+		strconv.Itoa(s.DailyTotal),
+		strconv.Itoa(s.DailySuccess),
+		capturedAt.Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,194 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupTokenUsageDB 使用内存 SQLite 保存给定的令牌
+// initModelColumns 以内存 SQLite 执行一次 model.InitDB，初始化 model 中按数据库类型引用的列名（如 group、key）
+func initModelColumns(t *testing.T) {
+	t.Helper()
+	t.Setenv("SQL_DSN", "")
+	oldDB, oldPath, oldMaster, oldSQLite := model.DB, common.SQLitePath, common.IsMasterNode, common.UsingSQLite
+	common.SQLitePath = "file:init_model_columns?mode=memory"
+	common.IsMasterNode = false
+	err := model.InitDB()
+	if err == nil {
+		if sqlDB, dbErr := model.DB.DB(); dbErr == nil {
+			_ = sqlDB.Close()
+		}
+	}
+	model.DB, common.SQLitePath, common.IsMasterNode, common.UsingSQLite = oldDB, oldPath, oldMaster, oldSQLite
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setupTokenUsageDB(t *testing.T, tokens ...*model.Token) {
+	t.Helper()
+	initModelColumns(t)
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", name)), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err = db.AutoMigrate(&model.Token{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, token := range tokens {
+		if err = db.Create(token).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldDB := model.DB
+	model.DB = db
+	t.Cleanup(func() {
+		model.DB = oldDB
+		_ = sqlDB.Close()
+	})
+}
+
+func setupTokenUsageLimits(t *testing.T, minuteTotal, minuteSuccess, dailyTotal, dailySuccess int) {
+	t.Helper()
+	setting.TokenRateLimitEnabled = true
+	setting.TokenRateLimitCount = minuteTotal
+	setting.TokenRateLimitSuccessCount = minuteSuccess
+	setting.TokenDailyRateLimitEnabled = true
+	setting.TokenDailyRateLimitCount = dailyTotal
+	setting.TokenDailyRateLimitSuccessCount = dailySuccess
+	t.Cleanup(func() {
+		setting.TokenRateLimitEnabled = false
+		setting.TokenRateLimitCount = 0
+		setting.TokenRateLimitSuccessCount = 0
+		setting.TokenDailyRateLimitEnabled = false
+		setting.TokenDailyRateLimitCount = 0
+		setting.TokenDailyRateLimitSuccessCount = 0
+	})
+}
+
+func TestParseTokenUsageKey(t *testing.T) {
+	valid := map[string]int{
+		"rateLimit:TRL:12":  12,
+		"rateLimit:TRLS:12": 12,
+		"rateLimit:TDRL:7":  7,
+		"rateLimit:TDRLS:7": 7,
+		"TRL12":             12,
+		"TRLS12":            12,
+		"TDRL7":             7,
+		"TDRLS7":            7,
+	}
+	for key, want := range valid {
+		if got, ok := parseTokenUsageKey(key); !ok || got != want {
+			t.Errorf("parseTokenUsageKey(%q) = %d, %v, want %d", key, got, ok, want)
+		}
+	}
+	// 按 IP、按客户细分的 key 以及其它维度的 key 不计入
+	for _, key := range []string{"rateLimit:TRL:12:ip:10.0.0.1", "rateLimit:MRRL:12", "rateLimit:TRL:abc", "rateLimit:TRL:0", "MRRL12", "TRLx"} {
+		if got, ok := parseTokenUsageKey(key); ok {
+			t.Errorf("parseTokenUsageKey(%q) = %d, want rejected", key, got)
+		}
+	}
+}
+
+func TestExportTokenUsageMemory(t *testing.T) {
+	common.SetRedisEnabled(false)
+	inMemoryRateLimiter.Init(time.Minute)
+	setupTokenUsageLimits(t, 60, 30, 1000, 500)
+	setupTokenUsageDB(t,
+		&model.Token{Id: 1731, UserId: 7, Name: "billing", Group: "default", Key: "sk-usage-1731"},
+		&model.Token{Id: 1732, UserId: 8, Name: "batch", Group: "default", Key: "sk-usage-1732"},
+	)
+	inMemoryRateLimiter.Charge(TokenRateLimitCountMark+"1731", 3, 60)
+	inMemoryRateLimiter.Charge(TokenRateLimitSuccessCountMark+"1731", 2, 30)
+	inMemoryRateLimiter.Charge(TokenDailyRateLimitCountMark+"1731", 9, 1000)
+	inMemoryRateLimiter.Charge(TokenDailyRateLimitSuccessCountMark+"1731", 8, 500)
+	inMemoryRateLimiter.Charge(TokenDailyRateLimitCountMark+"1732", 4, 1000)
+	// 已删除的令牌不导出
+	inMemoryRateLimiter.Charge(TokenRateLimitCountMark+"1733", 1, 60)
+
+	snapshots, err := ExportTokenUsage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []TokenUsageSnapshot
+	for _, snapshot := range snapshots {
+		if snapshot.TokenId >= 1731 && snapshot.TokenId <= 1733 {
+			got = append(got, snapshot)
+		}
+	}
+	want := []TokenUsageSnapshot{
+		{TokenId: 1731, UserId: 7, TokenName: "billing", Group: "default", MinuteTotal: 3, MinuteSuccess: 2, DailyTotal: 9, DailySuccess: 8},
+		{TokenId: 1732, UserId: 8, TokenName: "batch", Group: "default", DailyTotal: 4},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("snapshots = %+v, want %+v", got, want)
+	}
+
+	capturedAt := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	record := want[0].CSVRecord(capturedAt)
+	if len(record) != len(TokenUsageCSVHeader) {
+		t.Fatalf("CSV record has %d columns, header has %d", len(record), len(TokenUsageCSVHeader))
+	}
+	if wantRecord := []string{"1731", "7", "billing", "default", "3", "2", "9", "8", "2026-10-16T12:00:00Z"}; !reflect.DeepEqual(record, wantRecord) {
+		t.Fatalf("CSV record = %v, want %v", record, wantRecord)
+	}
+}
+
+func TestExportTokenUsageRedisScan(t *testing.T) {
+	f, rdb := startFakeRedis(t)
+	oldRedis, oldRDB := common.RedisEnabled(), common.RDB()
+	common.SetRedisEnabled(true)
+	common.SetRDB(rdb)
+	t.Cleanup(func() {
+		common.SetRedisEnabled(oldRedis)
+		common.SetRDB(oldRDB)
+	})
+	// fakeRedis 不支持令牌桶脚本，只导出成功请求数
+	setupTokenUsageLimits(t, 0, 30, 0, 500)
+	setupTokenUsageDB(t,
+		&model.Token{Id: 1734, UserId: 7, Name: "billing", Group: "default", Key: "sk-usage-1734"},
+		&model.Token{Id: 1735, UserId: 8, Name: "batch", Group: "default", Key: "sk-usage-1735"},
+	)
+	seedRateLimitList(f, "rateLimit:TRLS:1734", 2, 3)
+	seedRateLimitList(f, "rateLimit:TDRLS:1734", 6, 0)
+	seedRateLimitList(f, "rateLimit:TDRLS:1735", 1, 0)
+	seedRateLimitList(f, "rateLimit:TRLS:1735:ip:10.0.0.1", 5, 0)
+	seedRateLimitList(f, "rateLimit:MRRL:1736", 5, 0)
+	seedRateLimitList(f, "rateLimit:TRLS:1737", 1, 0)
+	f.scanPageSize = 2
+
+	snapshots, err := ExportTokenUsage(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []TokenUsageSnapshot{
+		{TokenId: 1734, UserId: 7, TokenName: "billing", Group: "default", MinuteSuccess: 2, DailySuccess: 6},
+		{TokenId: 1735, UserId: 8, TokenName: "batch", Group: "default", DailySuccess: 1},
+	}
+	if !reflect.DeepEqual(snapshots, want) {
+		t.Fatalf("snapshots = %+v, want %+v", snapshots, want)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// 分页遍历全部 key，不使用会阻塞 Redis 的 KEYS
+	if f.commands["SCAN"] < 2 || f.commands["KEYS"] != 0 {
+		t.Fatalf("SCAN called %d times, KEYS %d times, want cursor iteration without KEYS", f.commands["SCAN"], f.commands["KEYS"])
+	}
+}
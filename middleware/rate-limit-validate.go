@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requiresJSONModel 请求的模型是否需要从 JSON 请求体中解析，与 Distribute 中的判断保持一致：
+// Gemini 路径的模型在 URL 中，音频、图片编辑与视频接口使用表单或有默认模型
+func requiresJSONModel(c *gin.Context) bool {
+	path := c.Request.URL.Path
+	if strings.HasPrefix(path, "/v1beta/models/") || strings.HasPrefix(path, "/v1/models/") ||
+		strings.HasPrefix(path, "/v1/audio/") || strings.HasPrefix(path, "/v1/images/edits") ||
+		strings.Contains(path, "/v1/video") || strings.Contains(path, "/mj/") || strings.Contains(path, "/suno/") {
+		return false
+	}
+	return !strings.Contains(c.Request.Header.Get("Content-Type"), "multipart/form-data")
+}
+
+// checkRequestParseable 开启 RejectUnparseableRequests 时，在计入限流之前拒绝请求体为空或无法解析的请求，
+// 避免这类请求因取不到模型而回退到令牌级限制，以很低的成本占用资源
+func checkRequestParseable(c *gin.Context) bool {
+	if !setting.RejectUnparseableRequests || !requiresJSONModel(c) {
+		return true
+	}
+	req, err := getModelFromRequest(c)
+	if err != nil {
+		abortWithOpenAiMessage(c, http.StatusBadRequest, err.Error(), "invalid_request_body")
+		return false
+	}
+	if req.Model == "" && !hasDefaultModel(c.Request.URL.Path) {
+		abortWithOpenAiMessage(c, http.StatusBadRequest, "无效的请求, 无法解析模型", "invalid_request_body")
+		return false
+	}
+	return true
+}
+
+// hasDefaultModel 未指定模型时 Distribute 会使用默认模型的接口
+func hasDefaultModel(path string) bool {
+	return strings.HasPrefix(path, "/v1/moderations") || strings.HasPrefix(path, "/v1/images/generations") ||
+		strings.HasPrefix(path, "/v1/engines/") || strings.HasPrefix(path, "/v1/realtime")
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func TestRejectUnparseableRequests(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	setting.RejectUnparseableRequests = true
+	t.Cleanup(func() { setting.RejectUnparseableRequests = false })
+
+	for _, body := range []string{`{"model":`, `not json`, `{"messages":[]}`} {
+		w := serveModelRequest(1501, body, http.StatusOK, nil)
+		if w.Code != http.StatusBadRequest || rejectCode(t, w) != "invalid_request_body" {
+			t.Fatalf("body %q: status %d, body %s", body, w.Code, w.Body.String())
+		}
+	}
+	// 被拒绝的请求不计入限流
+	if got := tokenTotalCount(1501); got != 0 {
+		t.Fatalf("rejected requests counted %d times, want 0", got)
+	}
+
+	if w := serveModelRequest(1501, `{"model":"gpt-4o","messages":[]}`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("valid body: status %d, body %s", w.Code, w.Body.String())
+	}
+	if got := tokenTotalCount(1501); got != 1 {
+		t.Fatalf("valid request counted %d times, want 1", got)
+	}
+}
+
+func TestUnparseableRequestsAllowedByDefault(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	if w := serveModelRequest(1502, `{"model":`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("malformed body with the check off: status %d", w.Code)
+	}
+}
+
+func TestUnparseableCheckSkipsMultipart(t *testing.T) {
+	setupMemoryRateLimit(t, 10)
+	setting.RejectUnparseableRequests = true
+	t.Cleanup(func() { setting.RejectUnparseableRequests = false })
+	w := serveModelRequest(1503, "--boundary--", http.StatusOK, map[string]string{"Content-Type": "multipart/form-data; boundary=boundary"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("multipart body: status %d, body %s", w.Code, w.Body.String())
+	}
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// WarmDailyRateLimits 启动时按最近 24 小时的消费日志与错误日志恢复令牌与用户的每日限流计数，
+// 使每日限制在重启后依然有效。已存在的计数不会被覆盖；按固定周期计数的令牌每日限制不做恢复。返回恢复的 key 数量
+func WarmDailyRateLimits(ctx context.Context) (int, error) {
+	warmToken := setting.TokenDailyRateLimitEnabled
+	if _, ok := setting.GetTokenQuotaWindow(time.Now()); ok {
+		warmToken = false
+	}
+	warmUser := setting.UserDailyRateLimitEnabled
+	if !warmToken && !warmUser {
+		return 0, nil
+	}
+	now := time.Now().Unix()
+	stamps, err := model.GetRequestLogStampsSince(now - 86400)
+	if err != nil {
+		return 0, err
+	}
+
+	// 按内存限流器的 key 汇总请求时间，再映射到 Redis 中的 key
+	timestamps := make(map[string][]int64)
+	add := func(totalMark, successMark string, id int, stamp model.RequestLogStamp) {
+		key := strconv.Itoa(id)
+		timestamps[totalMark+key] = append(timestamps[totalMark+key], stamp.CreatedAt)
+		if stamp.Type == model.LogTypeConsume {
+			timestamps[successMark+key] = append(timestamps[successMark+key], stamp.CreatedAt)
+		}
+	}
+	for _, stamp := range stamps {
+		if warmToken && stamp.TokenId > 0 {
+			add(TokenDailyRateLimitCountMark, TokenDailyRateLimitSuccessCountMark, stamp.TokenId, stamp)
+		}
+		if warmUser && stamp.UserId > 0 {
+			add(UserDailyRateLimitCountMark, UserDailyRateLimitSuccessCountMark, stamp.UserId, stamp)
+		}
+	}
+
+	if !common.RedisEnabled() {
+		inMemoryRateLimiter.Init(24 * time.Hour)
+	}
+	warmed := 0
+	for key, live := range timestamps {
+		target, ok := resolveMemoryLimitKey(key)
+		if !ok {
+			continue
+		}
+		if !common.RedisEnabled() {
+			if inMemoryRateLimiter.Import(key, live, target.maxCount) {
+				warmed++
+			}
+			continue
+		}
+		seeded, err := seedRedisLimitKey(ctx, common.RDB(), target, live, now)
+		if err != nil {
+			return warmed, err
+		}
+		if seeded {
+			warmed++
+		}
+	}
+	return warmed, nil
+}
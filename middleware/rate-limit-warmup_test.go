@@ -0,0 +1,141 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+func setupRequestLogDB(t *testing.T, logs ...*model.Log) {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", name)), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err = db.AutoMigrate(&model.Log{}); err != nil {
+		t.Fatal(err)
+	}
+	for _, log := range logs {
+		if err = db.Create(log).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldDB := model.LOG_DB
+	model.LOG_DB = db
+	t.Cleanup(func() {
+		model.LOG_DB = oldDB
+		_ = sqlDB.Close()
+	})
+}
+
+// requestLogs 令牌 id（用户 id 相同）在最近 24 小时内 consume 次成功、failed 次失败，另有一条超过 24 小时的旧记录
+func requestLogs(id int, consume int, failed int) []*model.Log {
+	now := time.Now().Unix()
+	logs := []*model.Log{{UserId: id, TokenId: id, Type: model.LogTypeConsume, CreatedAt: now - 90000}}
+	for i := 0; i < consume; i++ {
+		logs = append(logs, &model.Log{UserId: id, TokenId: id, Type: model.LogTypeConsume, CreatedAt: now - int64(3600*(i+1))})
+	}
+	for i := 0; i < failed; i++ {
+		logs = append(logs, &model.Log{UserId: id, TokenId: id, Type: model.LogTypeError, CreatedAt: now - int64(60*(i+1))})
+	}
+	return logs
+}
+
+func TestWarmDailyRateLimitsMemory(t *testing.T) {
+	setupMemoryRateLimit(t, 100)
+	setupTokenUsageLimits(t, 100, 0, 5, 0)
+	setupRequestLogDB(t, requestLogs(1851, 3, 1)...)
+
+	// 模拟重启：令牌 1851 在本进程中没有计数，只能从日志恢复
+	warmed, err := WarmDailyRateLimits(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warmed != 2 {
+		t.Fatalf("warmed %d keys, want the daily total and success keys", warmed)
+	}
+	// 已恢复 4 次请求（超过 24 小时的不计入），每日上限 5 只剩 1 次
+	if w := serveModelRequest(1851, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+		t.Fatalf("request after warmup: status %d", w.Code)
+	}
+	w := serveModelRequest(1851, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over the restored daily limit: status %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+
+	// 已有计数时不覆盖
+	if warmed, err = WarmDailyRateLimits(context.Background()); err != nil || warmed != 0 {
+		t.Fatalf("second warmup = %d, %v, want 0 keys", warmed, err)
+	}
+}
+
+func TestWarmDailyRateLimitsRedis(t *testing.T) {
+	setupTokenUsageLimits(t, 100, 0, 10, 0)
+	oldUserEnabled, oldUserCount := setting.UserDailyRateLimitEnabled, setting.UserDailyRateLimitCount
+	setting.UserDailyRateLimitEnabled, setting.UserDailyRateLimitCount = true, 20
+	f, rdb := startFakeRedis(t)
+	oldRDB, oldEnabled := common.RDB(), common.RedisEnabled()
+	common.SetRDB(rdb)
+	common.SetRedisEnabled(true)
+	t.Cleanup(func() {
+		setting.UserDailyRateLimitEnabled, setting.UserDailyRateLimitCount = oldUserEnabled, oldUserCount
+		common.SetRDB(oldRDB)
+		common.SetRedisEnabled(oldEnabled)
+	})
+	setupRequestLogDB(t, requestLogs(1852, 2, 1)...)
+
+	warmed, err := WarmDailyRateLimits(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if warmed != 4 {
+		t.Fatalf("warmed %d keys, want token and user daily total and success keys", warmed)
+	}
+
+	f.mu.Lock()
+	for _, c := range []struct {
+		mark  string
+		limit int
+	}{{TokenDailyRateLimitCountMark, 10}, {UserDailyRateLimitCountMark, 20}} {
+		key := fmt.Sprintf("rateLimit:%s:1852", c.mark)
+		bucket, ok := f.hashes[key]
+		if !ok || !f.expires[key] {
+			t.Errorf("%s not seeded with an expiry", key)
+			continue
+		}
+		// 令牌桶按恢复的 3 次请求扣减
+		capacity := float64(c.limit * 86400)
+		tokens, err := strconv.ParseFloat(bucket["tokens"], 64)
+		if err != nil || tokens >= capacity || tokens < capacity-3*86400 {
+			t.Errorf("%s tokens = %s, want about 3 requests consumed from %v", key, bucket["tokens"], capacity)
+		}
+	}
+	for _, mark := range []string{TokenDailyRateLimitSuccessCountMark, UserDailyRateLimitSuccessCountMark} {
+		key := fmt.Sprintf("rateLimit:%s:1852", mark)
+		if got := len(f.lists[key]); got != 2 {
+			t.Errorf("%s has %d entries, want the 2 successful requests", key, got)
+		}
+	}
+	f.mu.Unlock()
+
+	if warmed, err = WarmDailyRateLimits(context.Background()); err != nil || warmed != 0 {
+		t.Fatalf("second warmup = %d, %v, want existing keys kept", warmed, err)
+	}
+}
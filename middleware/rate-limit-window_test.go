@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/common/limiter"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// 固定窗口在边界前后可以连续放行接近两倍的限制，滚动窗口在任意连续时间段内都不超过限制
+func TestWindowModeBurstAtBoundary(t *testing.T) {
+	common.SetRedisEnabled(false)
+	ctx := context.Background()
+	const limit = 5
+	beforeBoundary := time.Date(2026, 10, 16, 12, 0, 59, 0, time.Local)
+	afterBoundary := beforeBoundary.Add(time.Second)
+
+	fixedAllowed := 0
+	for _, now := range []time.Time{beforeBoundary, afterBoundary} {
+		window := setting.FixedWindow(now, time.Minute)
+		for i := 0; i < limit+1; i++ {
+			if allowed, _ := reservePeriodCount(ctx, "window-mode-test", limit, window); allowed {
+				fixedAllowed++
+			}
+		}
+	}
+	if fixedAllowed != 2*limit {
+		t.Fatalf("fixed window allowed %d requests across the boundary, want %d", fixedAllowed, 2*limit)
+	}
+
+	var events []limiter.SimulationEvent
+	for _, now := range []time.Time{beforeBoundary, afterBoundary} {
+		for i := 0; i < limit+1; i++ {
+			events = append(events, limiter.SimulationEvent{Time: now.UnixMilli()})
+		}
+	}
+	rollingAllowed := 0
+	for _, result := range limiter.SimulateRateLimit(limiter.SimulationConfig{DurationSeconds: 60, TotalMaxCount: limit}, events) {
+		if result.Allowed {
+			rollingAllowed++
+		}
+	}
+	if rollingAllowed != limit {
+		t.Fatalf("rolling window allowed %d requests across the boundary, want %d", rollingAllowed, limit)
+	}
+}
+
+func TestTokenRateLimitFixedWindowMode(t *testing.T) {
+	setupMemoryRateLimit(t, 3)
+	oldMode := setting.TokenRateLimitWindowMode
+	setting.TokenRateLimitWindowMode = setting.RateLimitWindowFixed
+	t.Cleanup(func() { setting.TokenRateLimitWindowMode = oldMode })
+	// 避免请求跨过整分钟边界
+	if now := time.Now(); now.Second() >= 58 {
+		time.Sleep(time.Until(now.Truncate(time.Minute).Add(time.Minute)))
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := serveModelRequest(1791, `{"model":"gpt-4o"}`, http.StatusOK, nil); w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i+1, w.Code)
+		}
+	}
+	w := serveModelRequest(1791, `{"model":"gpt-4o"}`, http.StatusOK, nil)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("4th request in the window: status %d, want 429", w.Code)
+	}
+	// 等待时间不超过到下一个整分钟的时间
+	if retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retryAfter < 1 || retryAfter > 61 {
+		t.Fatalf("Retry-After = %q, want the time left until the next minute", w.Header().Get("Retry-After"))
+	}
+	// 计数记录在当前整分钟窗口的 key 中，而不是滚动窗口使用的限流器
+	window := setting.FixedWindow(time.Now(), time.Minute)
+	if count, _ := peekPeriodCount(context.Background(), "rateLimit:"+TokenRateLimitCountMark+":1791", window); count != 3 {
+		t.Fatalf("fixed window count = %d, want 3", count)
+	}
+	if got := tokenTotalCount(1791); got != 0 {
+		t.Fatalf("rolling counter = %d, want untouched in fixed mode", got)
+	}
+}
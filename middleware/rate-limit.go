@@ -18,9 +18,9 @@ var defNext = func(c *gin.Context) {
 	c.Next()
 }
 
-func redisRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string) {
+func redisRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string, reject string) {
 	ctx := context.Background()
-	rdb := common.RDB
+	rdb := common.RDB()
 	key := "rateLimit:" + mark + c.ClientIP()
 	listLength, err := rdb.LLen(ctx, key).Result()
 	if err != nil {
@@ -32,6 +32,7 @@ func redisRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark st
 	if listLength < int64(maxRequestNum) {
 		rdb.LPush(ctx, key, time.Now().Format(timeFormat))
 		rdb.Expire(ctx, key, common.RateLimitKeyExpirationDuration)
+		setBackpressureHeader(c, mark, float64(listLength+1)/float64(maxRequestNum))
 	} else {
 		oldTimeStr, _ := rdb.LIndex(ctx, key, -1).Result()
 		oldTime, err := time.Parse(timeFormat, oldTimeStr)
@@ -51,67 +52,79 @@ func redisRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark st
 		}
 		// time.Since will return negative number!
 		// See: https://stackoverflow.com/questions/50970900/why-is-time-since-returning-negative-durations-on-windows
-		if int64(nowTime.Sub(oldTime).Seconds()) < duration {
+		if elapsed := int64(nowTime.Sub(oldTime).Seconds()); elapsed < duration {
 			rdb.Expire(ctx, key, common.RateLimitKeyExpirationDuration)
-			c.Status(http.StatusTooManyRequests)
-			c.Abort()
+			abortIPRateLimit(c, reject, duration-elapsed)
 			return
 		} else {
 			rdb.LPush(ctx, key, time.Now().Format(timeFormat))
 			rdb.LTrim(ctx, key, 0, int64(maxRequestNum-1))
 			rdb.Expire(ctx, key, common.RateLimitKeyExpirationDuration)
+			// 列表已满时，最近 maxRequestNum 次请求跨越 elapsed 秒，使用率即实际速率与限制速率之比
+			setBackpressureHeader(c, mark, float64(duration)/float64(max(elapsed, 1)))
 		}
 	}
 }
 
-func memoryRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string) {
+func memoryRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string, reject string) {
 	key := mark + c.ClientIP()
 	if !inMemoryRateLimiter.Request(key, maxRequestNum, duration) {
-		c.Status(http.StatusTooManyRequests)
-		c.Abort()
+		abortIPRateLimit(c, reject, duration)
+		return
+	}
+	count, _ := inMemoryRateLimiter.Peek(key, duration)
+	setBackpressureHeader(c, mark, float64(count)/float64(maxRequestNum))
+}
+
+// abortIPRateLimit 按 IP 限流拒绝请求，全局限流返回 global_rate_limit_exceeded 错误码，其他限流只返回状态码
+func abortIPRateLimit(c *gin.Context, reject string, retryAfter int64) {
+	if reject == "" {
+		abortWithRateLimitStatus(c, "", retryAfter)
 		return
 	}
+	abortWithRateLimitMessage(c, reject, retryAfter, "服务当前请求过多，已达到全局请求频率限制（并非您的额度已用完），请稍后再试")
 }
 
-func rateLimitFactory(maxRequestNum int, duration int64, mark string) func(c *gin.Context) {
-	if common.RedisEnabled {
+// rateLimitFactory 按 IP 限流，reject 为空时拒绝请求只返回状态码
+func rateLimitFactory(maxRequestNum int, duration int64, mark string, reject string) func(c *gin.Context) {
+	if common.RedisEnabled() {
 		return func(c *gin.Context) {
-			redisRateLimiter(c, maxRequestNum, duration, mark)
+			redisRateLimiter(c, maxRequestNum, duration, mark, reject)
 		}
 	} else {
 		// It's safe to call multi times.
 		inMemoryRateLimiter.Init(common.RateLimitKeyExpirationDuration)
 		return func(c *gin.Context) {
-			memoryRateLimiter(c, maxRequestNum, duration, mark)
+			memoryRateLimiter(c, maxRequestNum, duration, mark, reject)
 		}
 	}
 }
 
 func GlobalWebRateLimit() func(c *gin.Context) {
 	if common.GlobalWebRateLimitEnable {
-		return rateLimitFactory(common.GlobalWebRateLimitNum, common.GlobalWebRateLimitDuration, "GW")
+		return rateLimitFactory(common.GlobalWebRateLimitNum, common.GlobalWebRateLimitDuration, "GW", rateLimitRejectGlobal)
 	}
 	return defNext
 }
 
 func GlobalAPIRateLimit() func(c *gin.Context) {
 	if common.GlobalApiRateLimitEnable {
-		return rateLimitFactory(common.GlobalApiRateLimitNum, common.GlobalApiRateLimitDuration, "GA")
+		return rateLimitFactory(common.GlobalApiRateLimitNum, common.GlobalApiRateLimitDuration, globalAPIRateLimitMark, rateLimitRejectGlobal)
 	}
 	return defNext
 }
 
 func CriticalRateLimit() func(c *gin.Context) {
 	if common.CriticalRateLimitEnable {
-		return rateLimitFactory(common.CriticalRateLimitNum, common.CriticalRateLimitDuration, "CT")
+		return rateLimitFactory(common.CriticalRateLimitNum, common.CriticalRateLimitDuration, "CT", "")
 	}
 	return defNext
 }
 
 func DownloadRateLimit() func(c *gin.Context) {
-	return rateLimitFactory(common.DownloadRateLimitNum, common.DownloadRateLimitDuration, "DW")
+	return rateLimitFactory(common.DownloadRateLimitNum, common.DownloadRateLimitDuration, "DW", "")
 }
 
 func UploadRateLimit() func(c *gin.Context) {
-	return rateLimitFactory(common.UploadRateLimitNum, common.UploadRateLimitDuration, "UP")
+	return rateLimitFactory(common.UploadRateLimitNum, common.UploadRateLimitDuration, "UP", "")
 }
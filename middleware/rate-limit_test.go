@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGlobalAPIRateLimitReturnsGlobalCode(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	common.SetRedisEnabled(false)
+	enable, num, duration := common.GlobalApiRateLimitEnable, common.GlobalApiRateLimitNum, common.GlobalApiRateLimitDuration
+	common.GlobalApiRateLimitEnable, common.GlobalApiRateLimitNum, common.GlobalApiRateLimitDuration = true, 1, 60
+	t.Cleanup(func() {
+		common.GlobalApiRateLimitEnable, common.GlobalApiRateLimitNum, common.GlobalApiRateLimitDuration = enable, num, duration
+	})
+
+	r := gin.New()
+	r.GET("/api/status", GlobalAPIRateLimit(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/status", nil))
+		return w
+	}
+
+	if w := serve(); w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	w := serve()
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "global_rate_limit_exceeded") {
+		t.Fatalf("body = %q, want global_rate_limit_exceeded", w.Body.String())
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("missing Retry-After")
+	}
+}
+
+func TestBackpressureDelayMs(t *testing.T) {
+	threshold, maxDelay := setting.RateLimitBackpressureThresholdPercent, setting.RateLimitBackpressureMaxDelayMs
+	t.Cleanup(func() {
+		setting.RateLimitBackpressureThresholdPercent, setting.RateLimitBackpressureMaxDelayMs = threshold, maxDelay
+	})
+	setting.RateLimitBackpressureThresholdPercent, setting.RateLimitBackpressureMaxDelayMs = 60, 1000
+
+	cases := []struct {
+		utilization float64
+		want        int
+	}{
+		{0, 0},
+		{0.59, 0},
+		{0.6, 1},
+		{0.8, 500},
+		{1, 1000},
+		{1.5, 1000},
+	}
+	for _, tc := range cases {
+		if got := backpressureDelayMs(tc.utilization); got != tc.want {
+			t.Errorf("backpressureDelayMs(%v) = %d, want %d", tc.utilization, got, tc.want)
+		}
+	}
+
+	setting.RateLimitBackpressureThresholdPercent = 0
+	if got := backpressureDelayMs(1); got != 0 {
+		t.Fatalf("backpressureDelayMs with threshold 0 = %d, want 0", got)
+	}
+}
+
+func TestGlobalAPIRateLimitBackpressureHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	common.SetRedisEnabled(false)
+	enable, num, duration := common.GlobalApiRateLimitEnable, common.GlobalApiRateLimitNum, common.GlobalApiRateLimitDuration
+	threshold, maxDelay := setting.RateLimitBackpressureThresholdPercent, setting.RateLimitBackpressureMaxDelayMs
+	common.GlobalApiRateLimitEnable, common.GlobalApiRateLimitNum, common.GlobalApiRateLimitDuration = true, 10, 60
+	setting.RateLimitBackpressureThresholdPercent, setting.RateLimitBackpressureMaxDelayMs = 50, 1000
+	t.Cleanup(func() {
+		common.GlobalApiRateLimitEnable, common.GlobalApiRateLimitNum, common.GlobalApiRateLimitDuration = enable, num, duration
+		setting.RateLimitBackpressureThresholdPercent, setting.RateLimitBackpressureMaxDelayMs = threshold, maxDelay
+	})
+
+	r := gin.New()
+	r.GET("/api/status", GlobalAPIRateLimit(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	// 使用独立的客户端 IP，避免与其它全局限流测试共用计数
+	for i := 1; i <= 10; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+		req.RemoteAddr = "198.51.100.156:1234"
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, w.Code)
+		}
+		got := w.Header().Get(backpressureHeader)
+		switch {
+		case i < 5 && got != "":
+			t.Fatalf("request %d at %d%% utilization: header %q, want none", i, i*10, got)
+		case i == 5 && got != "1":
+			t.Fatalf("request %d at the threshold: header %q, want 1", i, got)
+		case i == 10 && got != "1000":
+			t.Fatalf("request %d at full utilization: header %q, want 1000", i, got)
+		}
+	}
+}
+
+func TestBackpressureHeaderOnlyForGlobalAPILimit(t *testing.T) {
+	threshold := setting.RateLimitBackpressureThresholdPercent
+	setting.RateLimitBackpressureThresholdPercent = 50
+	t.Cleanup(func() { setting.RateLimitBackpressureThresholdPercent = threshold })
+
+	for mark, want := range map[string]bool{globalAPIRateLimitMark: true, "GW": false, "CT": false} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		setBackpressureHeader(c, mark, 1)
+		if got := w.Header().Get(backpressureHeader) != ""; got != want {
+			t.Errorf("mark %s: header set = %v, want %v", mark, got, want)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+
+	"github.com/gin-gonic/gin"
+)
+
+func serveRequestIdRequest(tokenId int) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/v1/chat/completions", RequestId(), func(c *gin.Context) {
+		common.SetContextKey(c, constant.ContextKeyTokenId, tokenId)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "default")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, "default")
+		c.Next()
+	}, ModelRequestRateLimit(), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimitRejectionCarriesRequestId(t *testing.T) {
+	setupMemoryRateLimit(t, 1)
+	var logs bytes.Buffer
+	oldWriter := gin.DefaultErrorWriter
+	gin.DefaultErrorWriter = &logs
+	t.Cleanup(func() { gin.DefaultErrorWriter = oldWriter })
+
+	w := serveRequestIdRequest(1781)
+	if w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	firstId := w.Header().Get(common.RequestIdHeader)
+	if firstId == "" || firstId != w.Header().Get(common.RequestIdKey) {
+		t.Fatalf("X-Request-Id = %q, want the same non-empty ID as %s", firstId, common.RequestIdKey)
+	}
+
+	w = serveRequestIdRequest(1781)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("second request: status %d, want 429", w.Code)
+	}
+	id := w.Header().Get(common.RequestIdHeader)
+	if id == "" || id == firstId {
+		t.Fatalf("X-Request-Id on the 429 = %q, want a new ID", id)
+	}
+	if body := w.Body.String(); !strings.Contains(body, "request id: "+id) {
+		t.Fatalf("body %s does not carry the request ID %s", body, id)
+	}
+	// 拒绝日志中的请求 ID 与响应头一致，只输出一行
+	var rejections []string
+	for _, line := range strings.Split(logs.String(), "\n") {
+		if strings.Contains(line, "rate limit rejected") {
+			rejections = append(rejections, line)
+		}
+	}
+	if len(rejections) != 1 {
+		t.Fatalf("rejection log lines = %q, want exactly 1", rejections)
+	}
+	for _, want := range []string{"request_id=" + id, "token=1781", "kind=total", "status=429"} {
+		if !strings.Contains(rejections[0], want) {
+			t.Fatalf("rejection log %q missing %q", rejections[0], want)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// User daily rate limit constants
+const (
+	UserDailyRateLimitCountMark        = "UDRL"
+	UserDailyRateLimitSuccessCountMark = "UDRLS"
+)
+
+// getUserDailyRateLimit 获取用户当前生效的每日限制，使用 user group（不是 token group）
+func getUserDailyRateLimit(group string) (totalMaxCount, successMaxCount int) {
+	totalMaxCount = setting.UserDailyRateLimitCount
+	successMaxCount = setting.UserDailyRateLimitSuccessCount
+	if groupTotalCount, groupSuccessCount, found := setting.GetUserDailyRateLimit(group); found {
+		totalMaxCount = groupTotalCount
+		successMaxCount = groupSuccessCount
+	}
+	return totalMaxCount, successMaxCount
+}
+
+// checkUserDailyRateLimit 检查 per-user 每日限流，同一用户的所有令牌共享每日额度
+func checkUserDailyRateLimit(c *gin.Context) bool {
+	if !setting.UserDailyRateLimitEnabled {
+		return true
+	}
+
+	userId := c.GetInt("id")
+	if userId == 0 {
+		return true
+	}
+
+	totalMaxCount, successMaxCount := getUserDailyRateLimit(common.GetContextKeyString(c, constant.ContextKeyUserGroup))
+
+	// 如果两个限制都为0，表示不限制
+	if totalMaxCount == 0 && successMaxCount == 0 {
+		return true
+	}
+
+	rateLimitKey := strconv.Itoa(userId)
+	duration := int64(86400) // 24小时 = 86400秒
+
+	if common.RedisEnabled() {
+		return checkDailyRateLimitRedis(c, UserDailyRateLimitCountMark, UserDailyRateLimitSuccessCountMark, rateLimitKey, totalMaxCount, successMaxCount, duration)
+	}
+	return checkDailyRateLimitMemory(c, UserDailyRateLimitCountMark, UserDailyRateLimitSuccessCountMark, rateLimitKey, totalMaxCount, successMaxCount, duration)
+}
+
+// recordUserDailySuccess 记录 per-user 每日成功请求
+func recordUserDailySuccess(c *gin.Context) {
+	if !setting.UserDailyRateLimitEnabled {
+		return
+	}
+
+	userId := c.GetInt("id")
+	if userId == 0 {
+		return
+	}
+
+	_, successMaxCount := getUserDailyRateLimit(common.GetContextKeyString(c, constant.ContextKeyUserGroup))
+	if successMaxCount == 0 {
+		return
+	}
+
+	rateLimitKey := strconv.Itoa(userId)
+	duration := int64(86400)
+
+	if common.RedisEnabled() {
+		successKey := fmt.Sprintf("rateLimit:%s:%s", UserDailyRateLimitSuccessCountMark, rateLimitKey)
+		if hasSuccessReservation(c, successKey) {
+			return
+		}
+		recordRedisSuccess(context.Background(), common.RDB(), successLimiterAlgorithm(c), successKey, successMaxCount, duration)
+	} else {
+		recordMemorySuccess(successLimiterAlgorithm(c), UserDailyRateLimitSuccessCountMark+rateLimitKey, successMaxCount, duration)
+	}
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setupUserDailyRateLimit 使用内存限流，只开启按用户的每日限制
+func setupUserDailyRateLimit(t *testing.T, totalCount, successCount int) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	common.SetRedisEnabled(false)
+	constant.MaxRequestBodyMB = 8
+	inMemoryRateLimiter.Init(time.Minute)
+	setting.UserDailyRateLimitEnabled = true
+	setting.UserDailyRateLimitCount = totalCount
+	setting.UserDailyRateLimitSuccessCount = successCount
+	t.Cleanup(func() {
+		setting.UserDailyRateLimitEnabled = false
+		setting.UserDailyRateLimitCount = 0
+		setting.UserDailyRateLimitSuccessCount = 0
+		_ = setting.UpdateUserDailyRateLimitGroupByJSONString("{}")
+	})
+}
+
+// serveUserModelRequest 以用户 userId 的令牌 tokenId 发送一次请求
+func serveUserModelRequest(userId, tokenId int, userGroup string, status int) *httptest.ResponseRecorder {
+	r := gin.New()
+	r.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Set("id", userId)
+		common.SetContextKey(c, constant.ContextKeyTokenId, tokenId)
+		common.SetContextKey(c, constant.ContextKeyTokenGroup, "")
+		common.SetContextKey(c, constant.ContextKeyUserGroup, userGroup)
+		c.Next()
+	}, ModelRequestRateLimit(), func(c *gin.Context) {
+		c.Status(status)
+	})
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestUserDailyLimitSharedAcrossTokens(t *testing.T) {
+	setupUserDailyRateLimit(t, 3, 0)
+
+	for i, tokenId := range []int{12001, 12002, 12003} {
+		if w := serveUserModelRequest(1201, tokenId, "default", http.StatusOK); w.Code != http.StatusOK {
+			t.Fatalf("request %d with token %d: status %d", i, tokenId, w.Code)
+		}
+	}
+	// 换一个新令牌也不能绕过用户的每日限制
+	if w := serveUserModelRequest(1201, 12004, "default", http.StatusOK); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request with a new token: status %d, want 429", w.Code)
+	}
+	if w := serveUserModelRequest(1202, 12005, "default", http.StatusOK); w.Code != http.StatusOK {
+		t.Fatalf("other user: status %d", w.Code)
+	}
+}
+
+func TestUserDailySuccessLimitSharedAcrossTokens(t *testing.T) {
+	setupUserDailyRateLimit(t, 0, 2)
+
+	serveUserModelRequest(1203, 12031, "default", http.StatusOK)
+	// 失败请求不计入成功请求数
+	serveUserModelRequest(1203, 12032, "default", http.StatusInternalServerError)
+	if w := serveUserModelRequest(1203, 12032, "default", http.StatusOK); w.Code != http.StatusOK {
+		t.Fatalf("second success: status %d", w.Code)
+	}
+	if w := serveUserModelRequest(1203, 12033, "default", http.StatusOK); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request after success limit: status %d, want 429", w.Code)
+	}
+}
+
+func TestUserDailyLimitGroupOverride(t *testing.T) {
+	setupUserDailyRateLimit(t, 1, 0)
+	if err := setting.UpdateUserDailyRateLimitGroupByJSONString(`{"vip":[3,0]}`); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := serveUserModelRequest(1204, 12040+i, "vip", http.StatusOK); w.Code != http.StatusOK {
+			t.Fatalf("vip request %d: status %d", i, w.Code)
+		}
+	}
+	if w := serveUserModelRequest(1204, 12049, "vip", http.StatusOK); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("vip request over group limit: status %d, want 429", w.Code)
+	}
+	serveUserModelRequest(1205, 12050, "default", http.StatusOK)
+	if w := serveUserModelRequest(1205, 12051, "default", http.StatusOK); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("default group request over limit: status %d, want 429", w.Code)
+	}
+}
+
+func TestUserDailyRejectionDoesNotConsumeTokenDaily(t *testing.T) {
+	setupUserDailyRateLimit(t, 1, 0)
+	setting.TokenDailyRateLimitEnabled = true
+	setting.TokenDailyRateLimitCount = 2
+	t.Cleanup(func() {
+		setting.TokenDailyRateLimitEnabled = false
+		setting.TokenDailyRateLimitCount = 0
+	})
+
+	if w := serveUserModelRequest(1206, 12061, "default", http.StatusOK); w.Code != http.StatusOK {
+		t.Fatalf("first request: status %d", w.Code)
+	}
+	if w := serveUserModelRequest(1206, 12061, "default", http.StatusOK); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over user limit: status %d, want 429", w.Code)
+	}
+	// 被用户每日限制拒绝的请求不占用令牌的每日总请求数
+	setting.UserDailyRateLimitCount = 10
+	if w := serveUserModelRequest(1206, 12061, "default", http.StatusOK); w.Code != http.StatusOK {
+		t.Fatalf("second allowed request on token: status %d", w.Code)
+	}
+	if w := serveUserModelRequest(1206, 12061, "default", http.StatusOK); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("request over token limit: status %d, want 429", w.Code)
+	}
+}
@@ -1,10 +1,16 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/service"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/types"
 	"github.com/gin-gonic/gin"
 )
 
@@ -13,16 +19,108 @@ func abortWithOpenAiMessage(c *gin.Context, statusCode int, message string, code
 	if len(code) > 0 {
 		codeStr = code[0]
 	}
-	userId := c.GetInt("id")
-	c.JSON(statusCode, gin.H{
+	writeOpenAiMessage(c, statusCode, message, codeStr)
+	logger.LogError(c.Request.Context(), fmt.Sprintf("user %d | %s", c.GetInt("id"), message))
+}
+
+// writeOpenAiMessage 以 OpenAI 格式返回错误信息并中止请求，不记录日志
+func writeOpenAiMessage(c *gin.Context, statusCode int, message string, codeStr string) {
+	body := gin.H{
 		"error": gin.H{
 			"message": common.MessageWithRequestId(message, c.GetString(common.RequestIdKey)),
 			"type":    "new_api_error",
 			"code":    codeStr,
 		},
-	})
+	}
+	if channel := service.ErrorChannelForCaller(c); channel != nil {
+		body["channel"] = channel
+	}
+	c.JSON(statusCode, body)
+	c.Abort()
+}
+
+// setRetryAfter 设置 Retry-After 响应头，单位为秒
+func setRetryAfter(c *gin.Context, retryAfter int64) {
+	if retryAfter > 0 {
+		c.Header("Retry-After", strconv.FormatInt(retryAfter, 10))
+	}
+}
+
+// 限流拒绝的类型，总请求数（包括失败请求）与成功请求数可分别配置状态码和 Retry-After。
+// global 为所有用户共享的全局限制，错误码为 global_rate_limit_exceeded，客户端据此区分是服务整体繁忙而不是自己的额度已用完
+const (
+	rateLimitRejectTotal   = "total"
+	rateLimitRejectSuccess = "success"
+	rateLimitRejectGlobal  = "global"
+)
+
+func rateLimitRejectStatusCode(reject string) int {
+	switch reject {
+	case rateLimitRejectTotal:
+		if setting.RateLimitTotalRejectStatusCode != 0 {
+			return setting.RateLimitTotalRejectStatusCode
+		}
+	case rateLimitRejectSuccess:
+		if setting.RateLimitSuccessRejectStatusCode != 0 {
+			return setting.RateLimitSuccessRejectStatusCode
+		}
+	}
+	return setting.RateLimitRejectStatusCode
+}
+
+// rateLimitRejectRetryAfter 总请求数包括失败请求，短时间内重试没有意义，可配置更长的最小 Retry-After
+func rateLimitRejectRetryAfter(reject string, retryAfter int64) int64 {
+	if reject == rateLimitRejectTotal && retryAfter < int64(setting.RateLimitTotalMinRetryAfterSeconds) {
+		return int64(setting.RateLimitTotalMinRetryAfterSeconds)
+	}
+	return retryAfter
+}
+
+func rateLimitRejectCode(reject string) string {
+	if reject == "" {
+		return ""
+	}
+	return reject + "_rate_limit_exceeded"
+}
+
+// logRateLimitRejection 记录一次限流拒绝，日志中带有与响应头 X-Request-Id 相同的请求 ID，便于按用户反馈的 ID 排查
+func logRateLimitRejection(c *gin.Context, reject string, statusCode int, retryAfter int64, message string) {
+	if reject == "" {
+		reject = "global"
+	}
+	logger.LogWarn(c.Request.Context(), fmt.Sprintf("rate limit rejected | request_id=%s | user=%d | token=%d | ip=%s | path=%s | kind=%s | status=%d | retry_after=%d | %s",
+		c.GetString(common.RequestIdKey), c.GetInt("id"), common.GetContextKeyInt(c, constant.ContextKeyTokenId), c.ClientIP(), c.Request.URL.Path, reject, statusCode, retryAfter, message))
+}
+
+// abortWithRateLimitStatus 以配置的限流状态码中止请求（不带响应体）
+func abortWithRateLimitStatus(c *gin.Context, reject string, retryAfter int64) {
+	retryAfter = rateLimitRejectRetryAfter(reject, retryAfter)
+	statusCode := rateLimitRejectStatusCode(reject)
+	setRetryAfter(c, retryAfter)
+	c.Status(statusCode)
+	c.Abort()
+	logRateLimitRejection(c, reject, statusCode, retryAfter, "")
+}
+
+// abortWithRateLimitMessage 以配置的限流状态码及 ErrorFormat 格式返回错误信息
+func abortWithRateLimitMessage(c *gin.Context, reject string, retryAfter int64, message string) {
+	retryAfter = rateLimitRejectRetryAfter(reject, retryAfter)
+	statusCode := rateLimitRejectStatusCode(reject)
+	setRetryAfter(c, retryAfter)
+	writeErrorMessage(c, statusCode, message, rateLimitRejectCode(reject))
+	logRateLimitRejection(c, reject, statusCode, retryAfter, message)
+	recordIdempotencyRejected(c, statusCode, rateLimitRejectCode(reject), retryAfter, message)
+}
+
+// writeErrorMessage 按 ErrorFormat 返回错误信息并中止请求，默认为 OpenAI 格式，不记录日志
+func writeErrorMessage(c *gin.Context, statusCode int, message string, code string) {
+	if setting.ErrorFormat == setting.ErrorFormatOpenAI {
+		writeOpenAiMessage(c, statusCode, message, code)
+		return
+	}
+	message = common.MessageWithRequestId(message, c.GetString(common.RequestIdKey))
+	service.WriteErrorResponse(c, types.NewErrorWithStatusCode(errors.New(message), types.ErrorCode(code), statusCode), types.RelayFormatOpenAI)
 	c.Abort()
-	logger.LogError(c.Request.Context(), fmt.Sprintf("user %d | %s", userId, message))
 }
 
 func abortWithMidjourneyMessage(c *gin.Context, statusCode int, code int, description string) {
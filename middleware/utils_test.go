@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAbortWithOpenAiMessageExposesChannelToAdmins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	setting.ExposeChannelInError = true
+	t.Cleanup(func() { setting.ExposeChannelInError = false })
+
+	for role, wantChannel := range map[int]bool{common.RoleAdminUser: true, common.RoleCommonUser: false} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		common.SetContextKey(c, constant.ContextKeyUserRole, role)
+		common.SetContextKey(c, constant.ContextKeyChannelId, 7)
+		common.SetContextKey(c, constant.ContextKeyChannelTag, "eastus")
+		abortWithOpenAiMessage(c, http.StatusBadGateway, "upstream failed")
+
+		var body map[string]any
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("invalid body %q: %v", w.Body.String(), err)
+		}
+		channel, ok := body["channel"].(map[string]any)
+		if ok != wantChannel {
+			t.Fatalf("role %d: channel present = %v, want %v (body %s)", role, ok, wantChannel, w.Body.String())
+		}
+		if wantChannel && channel["label"] != "eastus" {
+			t.Fatalf("channel label = %v, want eastus", channel["label"])
+		}
+	}
+}
@@ -231,6 +231,31 @@ func (channel *Channel) SetOtherInfo(otherInfo map[string]interface{}) {
 	channel.OtherInfo = string(otherInfoBytes)
 }
 
+func (channel *Channel) setStatusDetail(detail *types.ChannelStatusDetail) {
+	info := channel.GetOtherInfo()
+	info["status_reason"] = detail.Reason
+	info["status_time"] = detail.Timestamp
+	info["status_detail"] = detail
+	channel.SetOtherInfo(info)
+}
+
+// GetStatusDetail 获取最近一次状态变更的结构化原因，没有记录时返回 nil
+func (channel *Channel) GetStatusDetail() *types.ChannelStatusDetail {
+	raw, ok := channel.GetOtherInfo()["status_detail"]
+	if !ok || raw == nil {
+		return nil
+	}
+	data, err := common.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var detail types.ChannelStatusDetail
+	if err = common.Unmarshal(data, &detail); err != nil {
+		return nil
+	}
+	return &detail
+}
+
 func (channel *Channel) GetTag() string {
 	if channel.Tag == nil {
 		return ""
@@ -249,6 +274,15 @@ func (channel *Channel) GetAutoBan() bool {
 	return *channel.AutoBan == 1
 }
 
+// ShouldAutoDisable 渠道出错时是否允许自动禁用。优先级：渠道其它设置中的 auto_disable_enabled（无论全局开关如何）>
+// 全局 AutomaticDisableChannelEnabled 且渠道开启了 auto_ban
+func (channel *Channel) ShouldAutoDisable() bool {
+	if enabled := channel.GetOtherSettings().AutoDisableEnabled; enabled != nil {
+		return *enabled
+	}
+	return common.AutomaticDisableChannelEnabled && channel.GetAutoBan()
+}
+
 func (channel *Channel) Save() error {
 	return DB.Save(channel).Error
 }
@@ -403,7 +437,13 @@ func BatchDeleteChannels(ids []int) error {
 			return err
 		}
 	}
-	return tx.Commit().Error
+	if err := tx.Commit().Error; err != nil {
+		return err
+	}
+	for _, id := range ids {
+		notifyChannelStreams(id, common.ChannelStatusManuallyDisabled)
+	}
+	return nil
 }
 
 func (channel *Channel) GetPriority() int64 {
@@ -500,6 +540,8 @@ func (channel *Channel) Update() error {
 		return err
 	}
 	DB.Model(channel).First(channel, "id = ?", channel.Id)
+	// 编辑后渠道不再启用时中断其上进行中的流式响应
+	notifyChannelStreams(channel.Id, channel.Status)
 	err = channel.UpdateAbilities(nil)
 	return err
 }
@@ -530,6 +572,7 @@ func (channel *Channel) Delete() error {
 	if err != nil {
 		return err
 	}
+	notifyChannelStreams(channel.Id, common.ChannelStatusManuallyDisabled)
 	err = channel.DeleteAbilities()
 	return err
 }
@@ -612,14 +655,31 @@ func handlerMultiKeyUpdate(channel *Channel, usingKey string, status int, reason
 	}
 }
 
-func UpdateChannelStatus(channelId int, usingKey string, status int, reason string) bool {
+// ErrChannelNotFound 要修改状态的渠道不存在，重试没有意义
+var ErrChannelNotFound = errors.New("channel not found")
+
+// UpdateChannelStatus 自动修改渠道（或多 Key 渠道中某个 Key）的状态，返回状态是否发生了变化。
+// 渠道不存在时返回 ErrChannelNotFound，其余错误（如数据库暂时不可用）可由调用方重试。
+func UpdateChannelStatus(channelId int, usingKey string, status int, reason string) (bool, error) {
+	return UpdateChannelStatusWithDetail(channelId, usingKey, status, types.NewChannelStatusDetail(reason, nil))
+}
+
+// UpdateChannelStatusWithDetail 与 UpdateChannelStatus 相同，但会把结构化的状态原因保存到渠道中
+func UpdateChannelStatusWithDetail(channelId int, usingKey string, status int, detail *types.ChannelStatusDetail) (bool, error) {
+	reason := detail.Reason
+	// 保存失败时恢复缓存中的状态，使调用方重试时不会因缓存已是目标状态而跳过
+	restoreCache := func() {}
 	if common.MemoryCacheEnabled {
 		channelStatusLock.Lock()
 		defer channelStatusLock.Unlock()
 
 		channelCache, _ := CacheGetChannel(channelId)
 		if channelCache == nil {
-			return false
+			return false, ErrChannelNotFound
+		}
+		// 暂停的渠道只能手动恢复，自动禁用/启用不改变其状态
+		if channelCache.Status == common.ChannelStatusPaused {
+			return false, nil
 		}
 		if channelCache.ChannelInfo.IsMultiKey {
 			// Use per-channel lock to prevent concurrent map read/write with GetNextEnabledKey
@@ -633,27 +693,41 @@ func UpdateChannelStatus(channelId int, usingKey string, status int, reason stri
 		} else {
 			// 如果缓存渠道存在，且状态已是目标状态，直接返回
 			if channelCache.Status == status {
-				return false
+				return false, nil
 			}
+			beforeStatus := channelCache.Status
 			CacheUpdateChannelStatus(channelId, status)
+			restoreCache = func() {
+				CacheUpdateChannelStatus(channelId, beforeStatus)
+			}
 		}
 	}
 
 	shouldUpdateAbilities := false
 	defer func() {
 		if shouldUpdateAbilities {
+			recordChannelTransition(channelId, status)
+			RefreshActiveChannelCount()
+			notifyChannelStreams(channelId, status)
 			err := UpdateAbilityStatus(channelId, status == common.ChannelStatusEnabled)
 			if err != nil {
 				common.SysLog(fmt.Sprintf("failed to update ability status: channel_id=%d, error=%v", channelId, err))
 			}
+			if status == common.ChannelStatusEnabled {
+				onChannelEnabled(channelId, detail.Timestamp)
+			}
 		}
 	}()
 	channel, err := GetChannelById(channelId, true)
 	if err != nil {
-		return false
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, ErrChannelNotFound
+		}
+		restoreCache()
+		return false, err
 	} else {
-		if channel.Status == status {
-			return false
+		if channel.Status == status || channel.Status == common.ChannelStatusPaused {
+			return false, nil
 		}
 
 		if channel.ChannelInfo.IsMultiKey {
@@ -667,20 +741,63 @@ func UpdateChannelStatus(channelId int, usingKey string, status int, reason stri
 				shouldUpdateAbilities = true
 			}
 		} else {
-			info := channel.GetOtherInfo()
-			info["status_reason"] = reason
-			info["status_time"] = common.GetTimestamp()
-			channel.SetOtherInfo(info)
+			channel.setStatusDetail(detail)
 			channel.Status = status
 			shouldUpdateAbilities = true
 		}
 		err = channel.SaveWithoutKey()
 		if err != nil {
-			common.SysLog(fmt.Sprintf("failed to update channel status: channel_id=%d, status=%d, error=%v", channel.Id, status, err))
-			return false
+			shouldUpdateAbilities = false
+			restoreCache()
+			return false, err
 		}
 	}
-	return true
+	return true, nil
+}
+
+// onChannelEnabled 渠道被启用（手动或自动）后重置与启用相关的运行时状态
+func onChannelEnabled(channelId int, enabledAt int64) {
+	markChannelEnabled(channelId, enabledAt)
+	resetChannelOutcomes(channelId)
+	markChannelGrace(channelId)
+}
+
+// SetChannelStatus 直接设置整个渠道的状态（不区分多 Key），返回状态是否发生了变化
+func SetChannelStatus(channelId int, status int, reason string) (bool, error) {
+	return SetChannelStatusWithDetail(channelId, status, types.NewChannelStatusDetail(reason, nil))
+}
+
+// SetChannelStatusWithDetail 与 SetChannelStatus 相同，但会把结构化的状态原因保存到渠道中
+func SetChannelStatusWithDetail(channelId int, status int, detail *types.ChannelStatusDetail) (bool, error) {
+	channel, err := GetChannelById(channelId, true)
+	if err != nil {
+		return false, err
+	}
+	if channel.Status == status {
+		return false, nil
+	}
+	channel.setStatusDetail(detail)
+	channel.Status = status
+	if status == common.ChannelStatusEnabled && channel.ChannelInfo.IsMultiKey {
+		// 启用整个渠道时同时清空各密钥的禁用状态
+		channel.ChannelInfo.MultiKeyStatusList = make(map[int]int)
+		channel.ChannelInfo.MultiKeyDisabledTime = make(map[int]int64)
+		channel.ChannelInfo.MultiKeyDisabledReason = make(map[int]string)
+	}
+	if err = channel.SaveWithoutKey(); err != nil {
+		return false, err
+	}
+	recordChannelTransition(channelId, status)
+	RefreshActiveChannelCount()
+	notifyChannelStreams(channelId, status)
+	if err = UpdateAbilityStatus(channelId, status == common.ChannelStatusEnabled); err != nil {
+		return true, err
+	}
+	CacheUpdateChannelStatus(channelId, status)
+	if status == common.ChannelStatusEnabled {
+		onChannelEnabled(channelId, detail.Timestamp)
+	}
+	return true, nil
 }
 
 func EnableChannelByTag(tag string) error {
@@ -697,6 +814,12 @@ func DisableChannelByTag(tag string) error {
 	if err != nil {
 		return err
 	}
+	var ids []int
+	if err = DB.Model(&Channel{}).Where("tag = ?", tag).Pluck("id", &ids).Error; err == nil {
+		for _, id := range ids {
+			notifyChannelStreams(id, common.ChannelStatusManuallyDisabled)
+		}
+	}
 	err = UpdateAbilityStatusByTag(tag, false)
 	return err
 }
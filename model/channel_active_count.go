@@ -0,0 +1,33 @@
+package model
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 已启用渠道数，供按渠道数缩放的限流使用。定期从数据库重新统计，渠道状态变化后也会立即刷新
+var activeChannelCount atomic.Int64
+
+// GetActiveChannelCount 返回最近一次统计的已启用渠道数
+func GetActiveChannelCount() int {
+	return int(activeChannelCount.Load())
+}
+
+// RefreshActiveChannelCount 从数据库重新统计已启用渠道数，统计失败时保留上一次的结果
+func RefreshActiveChannelCount() {
+	var count int64
+	if err := DB.Model(&Channel{}).Where("status = ?", common.ChannelStatusEnabled).Count(&count).Error; err != nil {
+		common.SysLog("failed to count active channels: " + err.Error())
+		return
+	}
+	activeChannelCount.Store(count)
+}
+
+func SyncActiveChannelCount(frequency int) {
+	for {
+		RefreshActiveChannelCount()
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 上游通过响应头告知的限流重置时间，在此之前选择渠道时跳过该渠道
+var (
+	channelBackoffMutex sync.RWMutex
+	channelBackoffUntil = map[int]int64{} // 渠道 ID -> 退避结束的时间戳（秒）
+)
+
+// SetChannelBackoffUntil 记录渠道退避到 until 为止，已有更晚的退避时间时保留较晚的
+func SetChannelBackoffUntil(channelId int, until int64) {
+	channelBackoffMutex.Lock()
+	defer channelBackoffMutex.Unlock()
+	if until > channelBackoffUntil[channelId] {
+		channelBackoffUntil[channelId] = until
+	}
+}
+
+// GetChannelBackoffUntil 返回渠道的退避结束时间，不在退避中时返回 0
+func GetChannelBackoffUntil(channelId int) int64 {
+	now := common.GetTimestamp()
+	channelBackoffMutex.RLock()
+	until, ok := channelBackoffUntil[channelId]
+	channelBackoffMutex.RUnlock()
+	if !ok {
+		return 0
+	}
+	if until <= now {
+		channelBackoffMutex.Lock()
+		if channelBackoffUntil[channelId] <= now {
+			delete(channelBackoffUntil, channelId)
+		}
+		channelBackoffMutex.Unlock()
+		return 0
+	}
+	return until
+}
+
+// IsChannelBackingOff 渠道是否仍处于上游指定的限流退避期内
+func IsChannelBackingOff(channelId int) bool {
+	return GetChannelBackoffUntil(channelId) > 0
+}
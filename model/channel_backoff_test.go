@@ -0,0 +1,79 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// setTestChannelCache 用给定的渠道替换内存中的渠道缓存，渠道都属于 default 分组并提供 gpt-4o
+func setTestChannelCache(t *testing.T, channels ...*Channel) {
+	t.Helper()
+	channelSyncLock.Lock()
+	oldGroups, oldChannels, oldEnabled := group2model2channels, channelsIDM, common.MemoryCacheEnabled
+	group2model2channels = map[string]map[string][]int{"default": {"gpt-4o": {}}}
+	channelsIDM = map[int]*Channel{}
+	for _, channel := range channels {
+		channelsIDM[channel.Id] = channel
+		group2model2channels["default"]["gpt-4o"] = append(group2model2channels["default"]["gpt-4o"], channel.Id)
+	}
+	channelSyncLock.Unlock()
+	common.MemoryCacheEnabled = true
+	t.Cleanup(func() {
+		channelSyncLock.Lock()
+		group2model2channels, channelsIDM = oldGroups, oldChannels
+		channelSyncLock.Unlock()
+		common.MemoryCacheEnabled = oldEnabled
+	})
+}
+
+func newTestChannel(id int, weight uint) *Channel {
+	return &Channel{Id: id, Name: "test", Status: common.ChannelStatusEnabled, Weight: &weight}
+}
+
+// selectedChannelIds 多次选择渠道，返回被选中过的渠道 ID
+func selectedChannelIds(t *testing.T, times int) map[int]bool {
+	t.Helper()
+	selected := map[int]bool{}
+	for i := 0; i < times; i++ {
+		channel, err := GetRandomSatisfiedChannel("default", "gpt-4o", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		selected[channel.Id] = true
+	}
+	return selected
+}
+
+func TestChannelBackoffUntil(t *testing.T) {
+	now := common.GetTimestamp()
+	SetChannelBackoffUntil(1491, now+60)
+	SetChannelBackoffUntil(1491, now+30)
+	if got := GetChannelBackoffUntil(1491); got != now+60 {
+		t.Fatalf("backoff until = %d, want the later time %d", got, now+60)
+	}
+	if !IsChannelBackingOff(1491) {
+		t.Fatal("channel should be backing off")
+	}
+
+	// 退避时间已过时不再退避
+	SetChannelBackoffUntil(1492, now-1)
+	if IsChannelBackingOff(1492) {
+		t.Fatal("channel whose backoff has passed should not be backing off")
+	}
+}
+
+func TestSelectionSkipsBackingOffChannel(t *testing.T) {
+	setTestChannelCache(t, newTestChannel(1493, 100), newTestChannel(1494, 100))
+	SetChannelBackoffUntil(1493, common.GetTimestamp()+60)
+
+	if selected := selectedChannelIds(t, 50); selected[1493] || !selected[1494] {
+		t.Fatalf("selected channels = %v, want only #1494 while #1493 backs off", selected)
+	}
+
+	// 同一优先级的渠道都在退避时仍从中选择
+	SetChannelBackoffUntil(1494, common.GetTimestamp()+60)
+	if selected := selectedChannelIds(t, 50); len(selected) == 0 {
+		t.Fatal("no channel selected when all channels back off")
+	}
+}
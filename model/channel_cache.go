@@ -82,6 +82,11 @@ func InitChannelCache() {
 	}
 	channelsIDM = newChannelId2channel
 	channelSyncLock.Unlock()
+	// 其他节点禁用的渠道在同步时才能得知，此时中断本节点上该渠道进行中的流式响应
+	for _, channel := range channels {
+		notifyChannelStreams(channel.Id, channel.Status)
+	}
+	loadChannelWarmup(channels)
 	common.SysLog("channels synced from database")
 }
 
@@ -93,6 +98,12 @@ func SyncChannelCache(frequency int) {
 	}
 }
 
+// IsChannelTemporarilyUnavailable 渠道是否暂时不应被选择：并发已满、上游限流退避中或短时间内连续出错被隔离。
+// 常规选择、会话亲和与备用渠道链使用同一判断
+func IsChannelTemporarilyUnavailable(channelId int) bool {
+	return IsChannelAtConcurrencyCap(channelId) || IsChannelBackingOff(channelId) || IsChannelQuarantined(channelId)
+}
+
 func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel, error) {
 	// if memory cache is disabled, get channel directly from database
 	if !common.MemoryCacheEnabled {
@@ -144,9 +155,15 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 	// get the priority for the given retry number
 	var sumWeight = 0
 	var targetChannels []*Channel
+	var busyChannels []*Channel
 	for _, channelId := range channels {
 		if channel, ok := channelsIDM[channelId]; ok {
 			if channel.GetPriority() == targetPriority {
+				// 跳过并发已满、上游限流退避中以及短时间内连续出错被隔离的渠道
+				if IsChannelTemporarilyUnavailable(channel.Id) {
+					busyChannels = append(busyChannels, channel)
+					continue
+				}
 				sumWeight += channel.GetWeight()
 				targetChannels = append(targetChannels, channel)
 			}
@@ -154,6 +171,13 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 			return nil, fmt.Errorf("数据库一致性错误，渠道# %d 不存在，请联系管理员修复", channelId)
 		}
 	}
+	// 该优先级的渠道都不可用时仍从中选择，由占用并发名额或上游返回错误后进入重试
+	if len(targetChannels) == 0 && len(busyChannels) > 0 {
+		targetChannels = busyChannels
+		for _, channel := range busyChannels {
+			sumWeight += channel.GetWeight()
+		}
+	}
 
 	if len(targetChannels) == 0 {
 		return nil, errors.New(fmt.Sprintf("no channel found, group: %s, model: %s, priority: %d", group, model, targetPriority))
@@ -173,15 +197,26 @@ func GetRandomSatisfiedChannel(group string, model string, retry int) (*Channel,
 		smoothingFactor = 100
 	}
 
-	// Calculate the total weight of all channels up to endIdx
-	totalWeight := sumWeight * smoothingFactor
+	// Calculate the effective weight of each channel, newly enabled channels are scaled down while warming up
+	// and channels with recent failures are scaled down by SoftDisableWeightFactor,
+	// channels reporting low remaining provider quota are scaled down by ChannelProviderThrottlePercent
+	now := common.GetTimestamp()
+	totalWeight := 0
+	weights := make([]int, len(targetChannels))
+	for i, channel := range targetChannels {
+		weights[i] = applyChannelProviderThrottle(channel.Id, applyChannelSoftDisable(channel.Id, applyChannelWarmup(channel.Id, channel.GetWeight()*smoothingFactor+smoothingAdjustment, now)))
+		totalWeight += weights[i]
+	}
+	if totalWeight <= 0 {
+		return targetChannels[rand.Intn(len(targetChannels))], nil
+	}
 
 	// Generate a random value in the range [0, totalWeight)
 	randomWeight := rand.Intn(totalWeight)
 
 	// Find a channel based on its weight
-	for _, channel := range targetChannels {
-		randomWeight -= channel.GetWeight()*smoothingFactor + smoothingAdjustment
+	for i, channel := range targetChannels {
+		randomWeight -= weights[i]
 		if randomWeight < 0 {
 			return channel, nil
 		}
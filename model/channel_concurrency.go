@@ -0,0 +1,161 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 渠道当前正在处理的请求按租约记录，每个请求占用一个租约，释放时只删除自己的租约，
+// 不受请求过程中并发上限被修改或清空的影响
+var (
+	channelInflightMutex  sync.Mutex
+	channelInflightMemory = map[int]map[uint64]struct{}{}
+	channelLeaseSeq       uint64
+)
+
+// Redis 中租约的有效期，请求处理期间按 channelLeaseRenewInterval 续期；进程异常退出后未释放的租约在有效期后自动失效
+const (
+	channelLeaseTTL           = time.Minute
+	channelLeaseRenewInterval = channelLeaseTTL / 3
+)
+
+func channelInflightKey(channelId int) string {
+	return fmt.Sprintf("channel:inflight:%d", channelId)
+}
+
+// 清理过期租约后检查当前租约数，未达到上限时加入新的租约
+var acquireChannelLeaseScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local limit = tonumber(ARGV[2])
+local expireAt = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now)
+if redis.call('ZCARD', key) >= limit then
+    return 0
+end
+redis.call('ZADD', key, expireAt, ARGV[5])
+redis.call('PEXPIRE', key, ttl)
+return 1
+`)
+
+func nextChannelLeaseId() uint64 {
+	channelInflightMutex.Lock()
+	defer channelInflightMutex.Unlock()
+	channelLeaseSeq++
+	return channelLeaseSeq
+}
+
+// GetChannelInflight 获取渠道当前正在处理的请求数
+func GetChannelInflight(channelId int) int {
+	if common.RedisEnabled() {
+		now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		count, err := common.RDB().ZCount(context.Background(), channelInflightKey(channelId), "("+now, "+inf").Result()
+		if err != nil {
+			return 0
+		}
+		return int(count)
+	}
+	channelInflightMutex.Lock()
+	defer channelInflightMutex.Unlock()
+	return len(channelInflightMemory[channelId])
+}
+
+// IsChannelAtConcurrencyCap 渠道正在处理的请求数是否已达到并发上限
+func IsChannelAtConcurrencyCap(channelId int) bool {
+	limit := setting.GetChannelMaxConcurrency(channelId)
+	if limit <= 0 {
+		return false
+	}
+	return GetChannelInflight(channelId) >= limit
+}
+
+// AcquireChannelConcurrency 占用渠道的一个并发名额，已达上限时返回 false。
+// 返回 true 时调用方必须在请求结束后调用返回的 release，release 只释放本次占用的名额，可重复调用
+func AcquireChannelConcurrency(channelId int) (func(), bool) {
+	limit := setting.GetChannelMaxConcurrency(channelId)
+	if limit <= 0 {
+		return func() {}, true
+	}
+	if common.RedisEnabled() {
+		return acquireRedisChannelLease(channelId, limit)
+	}
+	leaseId := nextChannelLeaseId()
+	channelInflightMutex.Lock()
+	defer channelInflightMutex.Unlock()
+	leases, ok := channelInflightMemory[channelId]
+	if !ok {
+		leases = map[uint64]struct{}{}
+		channelInflightMemory[channelId] = leases
+	}
+	if len(leases) >= limit {
+		return nil, false
+	}
+	leases[leaseId] = struct{}{}
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			channelInflightMutex.Lock()
+			if leases, ok := channelInflightMemory[channelId]; ok {
+				delete(leases, leaseId)
+				if len(leases) == 0 {
+					delete(channelInflightMemory, channelId)
+				}
+			}
+			channelInflightMutex.Unlock()
+			wakeChannelQueueHead(channelId)
+		})
+	}, true
+}
+
+func acquireRedisChannelLease(channelId int, limit int) (func(), bool) {
+	ctx := context.Background()
+	key := channelInflightKey(channelId)
+	member := common.GetUUID()
+	now := time.Now()
+	acquired, err := acquireChannelLeaseScript.Run(ctx, common.RDB(), []string{key},
+		now.UnixMilli(), limit, now.Add(channelLeaseTTL).UnixMilli(), channelLeaseTTL.Milliseconds(), member).Int()
+	if err != nil {
+		// 计数不可用时不阻断请求
+		common.SysLog(fmt.Sprintf("failed to acquire concurrency of channel #%d: %s", channelId, err.Error()))
+		return func() {}, true
+	}
+	if acquired == 0 {
+		return nil, false
+	}
+
+	// 请求处理期间定期续期，长时间的流式响应不会因租约过期而被少计
+	stop := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(channelLeaseRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				expireAt := time.Now().Add(channelLeaseTTL).UnixMilli()
+				common.RDB().ZAddXX(ctx, key, &redis.Z{Score: float64(expireAt), Member: member})
+				common.RDB().PExpire(ctx, key, channelLeaseTTL)
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			if err := common.RDB().ZRem(ctx, key, member).Err(); err != nil {
+				common.SysLog(fmt.Sprintf("failed to release concurrency of channel #%d: %s", channelId, err.Error()))
+			}
+			wakeChannelQueueHead(channelId)
+		})
+	}, true
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func setChannelMaxConcurrency(t *testing.T, jsonStr string) {
+	t.Helper()
+	if err := setting.UpdateChannelMaxConcurrencyByJSONString(jsonStr); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestChannelConcurrencyCap(t *testing.T) {
+	common.SetRedisEnabled(false)
+	setChannelMaxConcurrency(t, `{"1141":2}`)
+	t.Cleanup(func() { setChannelMaxConcurrency(t, `{}`) })
+
+	release1, ok := AcquireChannelConcurrency(1141)
+	if !ok {
+		t.Fatal("first acquire should succeed")
+	}
+	release2, ok := AcquireChannelConcurrency(1141)
+	if !ok {
+		t.Fatal("second acquire should succeed")
+	}
+	if _, ok := AcquireChannelConcurrency(1141); ok {
+		t.Fatal("third acquire should be rejected at cap 2")
+	}
+	if !IsChannelAtConcurrencyCap(1141) {
+		t.Fatal("channel should be at cap")
+	}
+	release1()
+	release1() // 重复调用不会多释放
+	if got := GetChannelInflight(1141); got != 1 {
+		t.Fatalf("inflight = %d, want 1", got)
+	}
+	release2()
+	if got := GetChannelInflight(1141); got != 0 {
+		t.Fatalf("inflight = %d, want 0", got)
+	}
+}
+
+func TestChannelConcurrencyReleaseAfterCapCleared(t *testing.T) {
+	common.SetRedisEnabled(false)
+	setChannelMaxConcurrency(t, `{"1142":1}`)
+	t.Cleanup(func() { setChannelMaxConcurrency(t, `{}`) })
+
+	release, ok := AcquireChannelConcurrency(1142)
+	if !ok {
+		t.Fatal("acquire should succeed")
+	}
+	// 请求处理期间管理员清空了上限，请求结束时仍需释放占用的名额
+	setChannelMaxConcurrency(t, `{}`)
+	release()
+	if got := GetChannelInflight(1142); got != 0 {
+		t.Fatalf("inflight after release = %d, want 0", got)
+	}
+	setChannelMaxConcurrency(t, `{"1142":1}`)
+	if IsChannelAtConcurrencyCap(1142) {
+		t.Fatal("re-enabled cap should not see a leaked slot")
+	}
+	if release, ok := AcquireChannelConcurrency(1142); !ok {
+		t.Fatal("acquire after re-enabling cap should succeed")
+	} else {
+		release()
+	}
+}
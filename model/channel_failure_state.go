@@ -0,0 +1,61 @@
+package model
+
+// ChannelFailureState 渠道在本节点上用于自动禁用判断的失败统计与冷却状态
+type ChannelFailureState struct {
+	SuccessCount  int     `json:"success_count"`   // 统计窗口内成功次数
+	FailureCount  int     `json:"failure_count"`   // 统计窗口内失败次数
+	ErrorRate     float64 `json:"error_rate"`      // 统计窗口内失败率，没有样本时为 0
+	SoftDisabled  bool    `json:"soft_disabled"`   // 是否因失败过多被降低选择权重
+	GraceFailures int     `json:"grace_failures"`  // 重新启用后宽限期内已失败的次数
+	InGrace       bool    `json:"in_grace"`        // 是否处于重新启用后的宽限期
+	Transitions   int     `json:"transitions"`     // 统计窗口内启用与自动禁用之间的切换次数
+	FlapHoldUntil int64   `json:"flap_hold_until"` // 频繁切换导致的冷却结束时间，0 表示不在冷却中
+	BackoffUntil  int64   `json:"backoff_until"`   // 上游限流退避结束时间，0 表示不在退避中
+	Quarantined   bool    `json:"quarantined"`     // 是否因短时间内连续出错暂时不参与选择
+}
+
+// GetChannelFailureState 返回渠道当前的失败统计与冷却状态
+func GetChannelFailureState(channelId int) ChannelFailureState {
+	success, failure := channelOutcomeCounts(channelId)
+	state := ChannelFailureState{
+		SuccessCount:  success,
+		FailureCount:  failure,
+		SoftDisabled:  IsChannelSoftDisabled(channelId),
+		Transitions:   CountChannelTransitions(channelId),
+		FlapHoldUntil: GetChannelFlapHold(channelId),
+		BackoffUntil:  GetChannelBackoffUntil(channelId),
+		Quarantined:   IsChannelQuarantined(channelId),
+	}
+	if total := success + failure; total > 0 {
+		state.ErrorRate = float64(failure) / float64(total)
+	}
+	channelGraceMutex.Lock()
+	state.GraceFailures, state.InGrace = channelPostEnableFailures[channelId]
+	channelGraceMutex.Unlock()
+	return state
+}
+
+// ResetChannelFailureState 清空渠道的失败统计、状态切换历史以及冷却、退避和隔离，渠道的启用状态不变
+func ResetChannelFailureState(channelId int) {
+	ResetChannelFailures(channelId)
+
+	channelFlapMutex.Lock()
+	delete(channelTransitions, channelId)
+	delete(channelFlapHoldUntil, channelId)
+	channelFlapMutex.Unlock()
+}
+
+// ResetChannelFailures 清空渠道的失败统计以及退避和隔离，保留状态切换历史与频繁切换的冷却
+func ResetChannelFailures(channelId int) {
+	resetChannelOutcomes(channelId)
+
+	channelGraceMutex.Lock()
+	delete(channelPostEnableFailures, channelId)
+	channelGraceMutex.Unlock()
+
+	channelBackoffMutex.Lock()
+	delete(channelBackoffUntil, channelId)
+	channelBackoffMutex.Unlock()
+
+	resetChannelQuarantine(channelId)
+}
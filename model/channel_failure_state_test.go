@@ -0,0 +1,56 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func TestChannelFailureStateAndReset(t *testing.T) {
+	common.SetRedisEnabled(false)
+	oldGrace := setting.ChannelPostEnableGraceFailures
+	setting.ChannelPostEnableGraceFailures = 5
+	t.Cleanup(func() {
+		setting.ChannelPostEnableGraceFailures = oldGrace
+		ResetChannelFailureState(1701)
+	})
+
+	markChannelGrace(1701)
+	RecordChannelOutcome(1701, true)
+	for i := 0; i < 3; i++ {
+		RecordChannelOutcome(1701, false)
+	}
+	recordChannelTransition(1701, common.ChannelStatusAutoDisabled)
+	recordChannelTransition(1701, common.ChannelStatusEnabled)
+	holdUntil := common.GetTimestamp() + 600
+	backoffUntil := common.GetTimestamp() + 60
+	SetChannelFlapHold(1701, holdUntil)
+	SetChannelBackoffUntil(1701, backoffUntil)
+
+	state := GetChannelFailureState(1701)
+	want := ChannelFailureState{
+		SuccessCount:  1,
+		FailureCount:  3,
+		ErrorRate:     0.75,
+		GraceFailures: 3,
+		InGrace:       true,
+		Transitions:   2,
+		FlapHoldUntil: holdUntil,
+		BackoffUntil:  backoffUntil,
+	}
+	if state != want {
+		t.Fatalf("state = %+v, want %+v", state, want)
+	}
+	if other := GetChannelFailureState(1702); other != (ChannelFailureState{}) {
+		t.Fatalf("untouched channel state = %+v, want zero", other)
+	}
+
+	ResetChannelFailureState(1701)
+	if state = GetChannelFailureState(1701); state != (ChannelFailureState{}) {
+		t.Fatalf("state after reset = %+v, want zero", state)
+	}
+	if IsChannelBackingOff(1701) {
+		t.Fatal("channel still backing off after reset")
+	}
+}
@@ -0,0 +1,71 @@
+package model
+
+import (
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// 渠道在启用与自动禁用之间切换的历史，切换过于频繁说明渠道在反复「恢复-失败」，需要延长冷却
+var (
+	channelFlapMutex     sync.Mutex
+	channelTransitions   = map[int][]int64{} // 渠道 ID -> 统计窗口内各次状态切换的时间戳
+	channelFlapHoldUntil = map[int]int64{}   // 渠道 ID -> 冷却结束的时间戳
+)
+
+// recordChannelTransition 记录渠道在启用与自动禁用之间的一次切换
+func recordChannelTransition(channelId int, status int) {
+	if status != common.ChannelStatusEnabled && status != common.ChannelStatusAutoDisabled {
+		return
+	}
+	now := common.GetTimestamp()
+	channelFlapMutex.Lock()
+	defer channelFlapMutex.Unlock()
+	channelTransitions[channelId] = append(pruneChannelTransitions(channelTransitions[channelId], now), now)
+}
+
+func pruneChannelTransitions(transitions []int64, now int64) []int64 {
+	since := now - int64(setting.ChannelFlapWindowSeconds)
+	i := 0
+	for i < len(transitions) && transitions[i] <= since {
+		i++
+	}
+	return transitions[i:]
+}
+
+// CountChannelTransitions 返回渠道在 ChannelFlapWindowSeconds 内的状态切换次数
+func CountChannelTransitions(channelId int) int {
+	now := common.GetTimestamp()
+	channelFlapMutex.Lock()
+	defer channelFlapMutex.Unlock()
+	transitions := pruneChannelTransitions(channelTransitions[channelId], now)
+	if len(transitions) == 0 {
+		delete(channelTransitions, channelId)
+		return 0
+	}
+	channelTransitions[channelId] = transitions
+	return len(transitions)
+}
+
+// SetChannelFlapHold 渠道频繁切换状态时设置延长冷却，冷却结束前不能再次启用
+func SetChannelFlapHold(channelId int, until int64) {
+	channelFlapMutex.Lock()
+	defer channelFlapMutex.Unlock()
+	channelFlapHoldUntil[channelId] = until
+}
+
+// GetChannelFlapHold 返回渠道冷却结束的时间戳，不在冷却中时返回 0
+func GetChannelFlapHold(channelId int) int64 {
+	channelFlapMutex.Lock()
+	defer channelFlapMutex.Unlock()
+	until, ok := channelFlapHoldUntil[channelId]
+	if !ok {
+		return 0
+	}
+	if until <= common.GetTimestamp() {
+		delete(channelFlapHoldUntil, channelId)
+		return 0
+	}
+	return until
+}
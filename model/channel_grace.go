@@ -0,0 +1,52 @@
+package model
+
+import (
+	"sync"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// 渠道重新启用后累计的失败次数，次数不超过 ChannelPostEnableGraceFailures 时不会再次自动禁用渠道，
+// 避免刚恢复的渠道因偶发错误立即被禁用而反复切换状态
+var (
+	channelGraceMutex         sync.Mutex
+	channelPostEnableFailures = map[int]int{}
+)
+
+// markChannelGrace 渠道被启用后开始新的宽限期
+func markChannelGrace(channelId int) {
+	channelGraceMutex.Lock()
+	defer channelGraceMutex.Unlock()
+	if setting.ChannelPostEnableGraceFailures <= 0 {
+		delete(channelPostEnableFailures, channelId)
+		return
+	}
+	channelPostEnableFailures[channelId] = 0
+}
+
+// countChannelGraceFailure 记录宽限期内的一次失败，超出宽限次数后宽限期结束
+func countChannelGraceFailure(channelId int) {
+	channelGraceMutex.Lock()
+	defer channelGraceMutex.Unlock()
+	failures, ok := channelPostEnableFailures[channelId]
+	if !ok {
+		return
+	}
+	failures++
+	if failures > setting.ChannelPostEnableGraceFailures {
+		delete(channelPostEnableFailures, channelId)
+		return
+	}
+	channelPostEnableFailures[channelId] = failures
+}
+
+// GetChannelGraceFailures 渠道处于重新启用后的宽限期且最近的失败在宽限次数内时返回 true 及已失败的次数
+func GetChannelGraceFailures(channelId int) (int, bool) {
+	channelGraceMutex.Lock()
+	defer channelGraceMutex.Unlock()
+	failures, ok := channelPostEnableFailures[channelId]
+	if !ok || failures == 0 {
+		return 0, false
+	}
+	return failures, true
+}
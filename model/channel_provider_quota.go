@@ -0,0 +1,78 @@
+package model
+
+import (
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// 上游最近一次通过响应头报告的剩余请求额度，剩余比例低于 ChannelProviderThrottlePercent 时按比例降低选择权重
+type channelProviderQuota struct {
+	remaining int64
+	limit     int64
+	expireAt  int64 // 上游报告的重置时间，之后额度恢复，记录失效
+}
+
+var (
+	channelProviderQuotaMutex sync.RWMutex
+	channelProviderQuotas     = map[int]channelProviderQuota{}
+)
+
+// SetChannelProviderQuota 记录渠道最近一次报告的剩余请求额度及总额度，记录在 expireAt 后失效
+func SetChannelProviderQuota(channelId int, remaining int64, limit int64, expireAt int64) {
+	channelProviderQuotaMutex.Lock()
+	defer channelProviderQuotaMutex.Unlock()
+	channelProviderQuotas[channelId] = channelProviderQuota{remaining: remaining, limit: limit, expireAt: expireAt}
+}
+
+// GetChannelProviderQuota 返回渠道最近一次报告且仍有效的剩余请求额度与总额度
+func GetChannelProviderQuota(channelId int) (remaining int64, limit int64, ok bool) {
+	now := common.GetTimestamp()
+	channelProviderQuotaMutex.RLock()
+	quota, ok := channelProviderQuotas[channelId]
+	channelProviderQuotaMutex.RUnlock()
+	if !ok {
+		return 0, 0, false
+	}
+	if quota.expireAt <= now {
+		channelProviderQuotaMutex.Lock()
+		if q, ok := channelProviderQuotas[channelId]; ok && q.expireAt <= now {
+			delete(channelProviderQuotas, channelId)
+		}
+		channelProviderQuotaMutex.Unlock()
+		return 0, 0, false
+	}
+	return quota.remaining, quota.limit, true
+}
+
+// channelProviderQuotaShare 剩余额度占总额度的比例低于阈值时返回 剩余比例 / 阈值，否则返回 1
+func channelProviderQuotaShare(channelId int) float64 {
+	threshold := float64(setting.ChannelProviderThrottlePercent) / 100
+	if threshold <= 0 {
+		return 1
+	}
+	remaining, limit, ok := GetChannelProviderQuota(channelId)
+	if !ok || limit <= 0 {
+		return 1
+	}
+	ratio := float64(remaining) / float64(limit)
+	if ratio >= threshold {
+		return 1
+	}
+	return ratio / threshold
+}
+
+// applyChannelProviderThrottle 按上游报告的剩余额度降低渠道的选择权重，权重大于 0 时至少保留 1；
+// 额度用尽（剩余为 0）由退避逻辑处理
+func applyChannelProviderThrottle(channelId int, weight int) int {
+	share := channelProviderQuotaShare(channelId)
+	if share >= 1 || weight <= 0 {
+		return weight
+	}
+	scaled := int(float64(weight) * share)
+	if scaled < 1 {
+		scaled = 1
+	}
+	return scaled
+}
@@ -0,0 +1,94 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func setProviderThrottle(t *testing.T, percent int) {
+	t.Helper()
+	old := setting.ChannelProviderThrottlePercent
+	setting.ChannelProviderThrottlePercent = percent
+	t.Cleanup(func() { setting.ChannelProviderThrottlePercent = old })
+}
+
+func clearChannelProviderQuota(t *testing.T, channelId int) {
+	t.Helper()
+	t.Cleanup(func() {
+		channelProviderQuotaMutex.Lock()
+		delete(channelProviderQuotas, channelId)
+		channelProviderQuotaMutex.Unlock()
+	})
+}
+
+func TestApplyChannelProviderThrottle(t *testing.T) {
+	setProviderThrottle(t, 20)
+	clearChannelProviderQuota(t, 1811)
+	now := common.GetTimestamp()
+
+	if got := applyChannelProviderThrottle(1811, 100); got != 100 {
+		t.Fatalf("weight without reported quota = %d, want 100", got)
+	}
+	// 剩余额度逐步减少：高于阈值不降低，低于阈值按 剩余比例/阈值 降低
+	cases := []struct {
+		remaining int64
+		want      int
+	}{
+		{800, 100},
+		{200, 100},
+		{100, 50},
+		{20, 10},
+		{1, 1},
+	}
+	for _, tc := range cases {
+		SetChannelProviderQuota(1811, tc.remaining, 1000, now+60)
+		if got := applyChannelProviderThrottle(1811, 100); got != tc.want {
+			t.Errorf("remaining %d/1000: weight = %d, want %d", tc.remaining, got, tc.want)
+		}
+	}
+	if got := applyChannelProviderThrottle(1811, 0); got != 0 {
+		t.Errorf("zero weight throttled to %d, want 0", got)
+	}
+
+	// 阈值为 0 时不降低
+	setting.ChannelProviderThrottlePercent = 0
+	if got := applyChannelProviderThrottle(1811, 100); got != 100 {
+		t.Fatalf("weight with throttle disabled = %d, want 100", got)
+	}
+	setting.ChannelProviderThrottlePercent = 20
+
+	// 记录过了上游的重置时间后失效
+	SetChannelProviderQuota(1811, 1, 1000, now-1)
+	if _, _, ok := GetChannelProviderQuota(1811); ok {
+		t.Fatal("expired provider quota still reported")
+	}
+	if got := applyChannelProviderThrottle(1811, 100); got != 100 {
+		t.Fatalf("weight after quota reset = %d, want 100", got)
+	}
+}
+
+func TestLowProviderQuotaChannelSelectedLessOften(t *testing.T) {
+	setProviderThrottle(t, 20)
+	setTestChannelCache(t, newTestChannel(1812, 100), newTestChannel(1813, 100))
+	clearChannelProviderQuota(t, 1812)
+	SetChannelProviderQuota(1812, 10, 1000, common.GetTimestamp()+60)
+
+	counts := map[int]int{}
+	const times = 2000
+	for i := 0; i < times; i++ {
+		channel, err := GetRandomSatisfiedChannel("default", "gpt-4o", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[channel.Id]++
+	}
+	// 剩余 1% 时权重约降到 1/20，渠道仍会被少量选中
+	if counts[1812] == 0 || counts[1812] > times/5 {
+		t.Fatalf("low-quota channel selected %d of %d times, want a small but non-zero share", counts[1812], times)
+	}
+	if counts[1813] < times*3/4 {
+		t.Fatalf("healthy channel selected %d of %d times, want most of the traffic", counts[1813], times)
+	}
+}
@@ -0,0 +1,107 @@
+package model
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// 渠道短时间内连续出错时暂时不参与选择（隔离），不修改渠道状态：
+// 每次失败后计数的有效期重置为 ChannelQuarantineSeconds，计数达到 ChannelQuarantineErrorThreshold 时进入隔离，
+// ChannelQuarantineSeconds 内没有新的失败时计数过期，隔离自动解除。启用 Redis 时计数保存在 Redis 中，所有节点共享
+const channelQuarantineKeyPrefix = "channelQuarantine:"
+
+// Redis 模式下本节点缓存隔离状态的时长，避免每次选择渠道都访问 Redis
+const channelQuarantineCacheTTL = time.Second
+
+type channelQuarantineEntry struct {
+	count    int
+	expireAt time.Time // 内存模式为计数过期时间，Redis 模式为本地缓存过期时间
+}
+
+var (
+	channelQuarantineMutex sync.Mutex
+	channelQuarantine      = map[int]channelQuarantineEntry{}
+)
+
+func channelQuarantineTTL() time.Duration {
+	return time.Duration(setting.ChannelQuarantineSeconds) * time.Second
+}
+
+// RecordChannelQuarantineError 记录渠道的一次失败，未开启隔离时不记录
+func RecordChannelQuarantineError(channelId int) {
+	if setting.ChannelQuarantineSeconds <= 0 {
+		return
+	}
+	ttl := channelQuarantineTTL()
+	if common.RedisEnabled() {
+		ctx := context.Background()
+		key := channelQuarantineKeyPrefix + strconv.Itoa(channelId)
+		pipe := common.RDB().TxPipeline()
+		incr := pipe.Incr(ctx, key)
+		pipe.Expire(ctx, key, ttl)
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.SysLog("failed to record channel quarantine error: " + err.Error())
+			return
+		}
+		channelQuarantineMutex.Lock()
+		channelQuarantine[channelId] = channelQuarantineEntry{count: int(incr.Val()), expireAt: time.Now().Add(channelQuarantineCacheTTL)}
+		channelQuarantineMutex.Unlock()
+		return
+	}
+	now := time.Now()
+	channelQuarantineMutex.Lock()
+	defer channelQuarantineMutex.Unlock()
+	entry := channelQuarantine[channelId]
+	if now.After(entry.expireAt) {
+		entry.count = 0
+	}
+	channelQuarantine[channelId] = channelQuarantineEntry{count: entry.count + 1, expireAt: now.Add(ttl)}
+}
+
+// channelQuarantineCount 返回渠道当前有效的失败计数
+func channelQuarantineCount(channelId int) int {
+	now := time.Now()
+	channelQuarantineMutex.Lock()
+	entry, ok := channelQuarantine[channelId]
+	channelQuarantineMutex.Unlock()
+	if ok && now.Before(entry.expireAt) {
+		return entry.count
+	}
+	if !common.RedisEnabled() {
+		return 0
+	}
+	count, err := common.RDB().Get(context.Background(), channelQuarantineKeyPrefix+strconv.Itoa(channelId)).Int()
+	if err != nil {
+		// key 不存在（未隔离）或读取失败时均视为未隔离
+		count = 0
+	}
+	channelQuarantineMutex.Lock()
+	channelQuarantine[channelId] = channelQuarantineEntry{count: count, expireAt: now.Add(channelQuarantineCacheTTL)}
+	channelQuarantineMutex.Unlock()
+	return count
+}
+
+// IsChannelQuarantined 渠道是否因短时间内连续出错处于隔离中
+func IsChannelQuarantined(channelId int) bool {
+	if setting.ChannelQuarantineSeconds <= 0 || setting.ChannelQuarantineErrorThreshold <= 0 {
+		return false
+	}
+	return channelQuarantineCount(channelId) >= setting.ChannelQuarantineErrorThreshold
+}
+
+// resetChannelQuarantine 清除渠道的失败计数，隔离立即解除
+func resetChannelQuarantine(channelId int) {
+	channelQuarantineMutex.Lock()
+	delete(channelQuarantine, channelId)
+	channelQuarantineMutex.Unlock()
+	if common.RedisEnabled() {
+		if err := common.RDB().Del(context.Background(), channelQuarantineKeyPrefix+strconv.Itoa(channelId)).Err(); err != nil {
+			common.SysLog("failed to reset channel quarantine: " + err.Error())
+		}
+	}
+}
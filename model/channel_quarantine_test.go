@@ -0,0 +1,99 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func setChannelQuarantine(t *testing.T, seconds int, threshold int) {
+	t.Helper()
+	oldSeconds, oldThreshold, oldRedis := setting.ChannelQuarantineSeconds, setting.ChannelQuarantineErrorThreshold, common.RedisEnabled()
+	setting.ChannelQuarantineSeconds, setting.ChannelQuarantineErrorThreshold = seconds, threshold
+	common.SetRedisEnabled(false)
+	t.Cleanup(func() {
+		setting.ChannelQuarantineSeconds, setting.ChannelQuarantineErrorThreshold = oldSeconds, oldThreshold
+		common.SetRedisEnabled(oldRedis)
+	})
+}
+
+// expireChannelQuarantine 模拟 ChannelQuarantineSeconds 内没有新的失败
+func expireChannelQuarantine(channelId int) {
+	channelQuarantineMutex.Lock()
+	defer channelQuarantineMutex.Unlock()
+	if entry, ok := channelQuarantine[channelId]; ok {
+		entry.expireAt = time.Now().Add(-time.Millisecond)
+		channelQuarantine[channelId] = entry
+	}
+}
+
+func TestChannelQuarantineEntryAndLift(t *testing.T) {
+	setChannelQuarantine(t, 60, 3)
+	t.Cleanup(func() { resetChannelQuarantine(1941) })
+
+	RecordChannelQuarantineError(1941)
+	RecordChannelQuarantineError(1941)
+	if IsChannelQuarantined(1941) {
+		t.Fatal("channel quarantined below the error threshold")
+	}
+	RecordChannelQuarantineError(1941)
+	if !IsChannelQuarantined(1941) {
+		t.Fatal("channel not quarantined at the error threshold")
+	}
+
+	// 没有新的失败时计数过期，隔离自动解除，之后重新计数
+	expireChannelQuarantine(1941)
+	if IsChannelQuarantined(1941) {
+		t.Fatal("quarantine not lifted after the quiet period")
+	}
+	RecordChannelQuarantineError(1941)
+	if got := channelQuarantineCount(1941); got != 1 {
+		t.Fatalf("error count after lift = %d, want 1", got)
+	}
+
+	// 清除计数时立即解除
+	RecordChannelQuarantineError(1941)
+	RecordChannelQuarantineError(1941)
+	if !IsChannelQuarantined(1941) {
+		t.Fatal("channel not quarantined again")
+	}
+	resetChannelQuarantine(1941)
+	if IsChannelQuarantined(1941) {
+		t.Fatal("quarantine not lifted after reset")
+	}
+}
+
+func TestChannelQuarantineDisabled(t *testing.T) {
+	setChannelQuarantine(t, 0, 1)
+	RecordChannelQuarantineError(1942)
+	if IsChannelQuarantined(1942) || channelQuarantineCount(1942) != 0 {
+		t.Fatal("error recorded with ChannelQuarantineSeconds = 0")
+	}
+
+	setting.ChannelQuarantineSeconds = 60
+	t.Cleanup(func() { resetChannelQuarantine(1942) })
+	RecordChannelQuarantineError(1942)
+	setting.ChannelQuarantineErrorThreshold = 0
+	if IsChannelQuarantined(1942) {
+		t.Fatal("channel quarantined with ChannelQuarantineErrorThreshold = 0")
+	}
+}
+
+func TestQuarantinedChannelSkippedUntilLifted(t *testing.T) {
+	setChannelQuarantine(t, 60, 2)
+	setTestChannelCache(t, newTestChannel(1943, 100), newTestChannel(1944, 100))
+	t.Cleanup(func() { resetChannelQuarantine(1943) })
+
+	RecordChannelQuarantineError(1943)
+	RecordChannelQuarantineError(1943)
+	if selected := selectedChannelIds(t, 200); selected[1943] || !selected[1944] {
+		t.Fatalf("selected %v, want only the healthy channel while quarantined", selected)
+	}
+
+	expireChannelQuarantine(1943)
+	if selected := selectedChannelIds(t, 200); !selected[1943] {
+		t.Fatalf("selected %v, want the channel back after the quarantine lifts", selected)
+	}
+}
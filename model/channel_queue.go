@@ -0,0 +1,131 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+var (
+	ErrChannelConcurrencyExceeded = errors.New("channel concurrency exceeded")
+	ErrChannelQueueFull           = errors.New("channel queue is full")
+	ErrChannelQueueTimeout        = errors.New("channel queue wait timeout")
+)
+
+// 多节点共享 Redis 计数时，其他节点释放的名额无法通知到本节点，排在队首的请求按该间隔重试
+const channelQueuePollInterval = 50 * time.Millisecond
+
+type channelWaiter struct {
+	wake chan struct{}
+}
+
+// 渠道达到并发上限时按先后顺序排队的请求，只有队首的请求会尝试占用名额
+var (
+	channelQueueMutex sync.Mutex
+	channelQueues     = map[int][]*channelWaiter{}
+)
+
+// GetChannelQueueLength 获取渠道当前排队等待的请求数
+func GetChannelQueueLength(channelId int) int {
+	channelQueueMutex.Lock()
+	defer channelQueueMutex.Unlock()
+	return len(channelQueues[channelId])
+}
+
+func enqueueChannelWaiter(channelId int) (*channelWaiter, error) {
+	channelQueueMutex.Lock()
+	defer channelQueueMutex.Unlock()
+	if len(channelQueues[channelId]) >= setting.ChannelQueueDepth {
+		return nil, ErrChannelQueueFull
+	}
+	waiter := &channelWaiter{wake: make(chan struct{}, 1)}
+	channelQueues[channelId] = append(channelQueues[channelId], waiter)
+	return waiter, nil
+}
+
+func isChannelQueueHead(channelId int, waiter *channelWaiter) bool {
+	channelQueueMutex.Lock()
+	defer channelQueueMutex.Unlock()
+	queue := channelQueues[channelId]
+	return len(queue) > 0 && queue[0] == waiter
+}
+
+// removeChannelWaiter 将请求移出队列，队首变化时唤醒新的队首
+func removeChannelWaiter(channelId int, waiter *channelWaiter) {
+	channelQueueMutex.Lock()
+	defer channelQueueMutex.Unlock()
+	queue := channelQueues[channelId]
+	for i, w := range queue {
+		if w != waiter {
+			continue
+		}
+		queue = append(queue[:i], queue[i+1:]...)
+		if len(queue) == 0 {
+			delete(channelQueues, channelId)
+			return
+		}
+		channelQueues[channelId] = queue
+		if i == 0 {
+			wakeChannelWaiter(queue[0])
+		}
+		return
+	}
+}
+
+func wakeChannelWaiter(waiter *channelWaiter) {
+	select {
+	case waiter.wake <- struct{}{}:
+	default:
+	}
+}
+
+// wakeChannelQueueHead 渠道释放名额后唤醒排在队首的请求
+func wakeChannelQueueHead(channelId int) {
+	channelQueueMutex.Lock()
+	defer channelQueueMutex.Unlock()
+	if queue := channelQueues[channelId]; len(queue) > 0 {
+		wakeChannelWaiter(queue[0])
+	}
+}
+
+// WaitChannelConcurrency 占用渠道的一个并发名额，已达上限且开启了排队时按先后顺序等待，
+// 直到有名额释放、超过 ChannelQueueMaxWaitMs 或 ctx 结束。返回 nil 错误时调用方必须调用返回的 release。
+func WaitChannelConcurrency(ctx context.Context, channelId int) (func(), error) {
+	// 已有请求在排队时新请求直接排到队尾，避免插队
+	if setting.ChannelQueueDepth <= 0 || GetChannelQueueLength(channelId) == 0 {
+		if release, ok := AcquireChannelConcurrency(channelId); ok {
+			return release, nil
+		}
+	}
+	if setting.ChannelQueueDepth <= 0 {
+		return nil, ErrChannelConcurrencyExceeded
+	}
+	waiter, err := enqueueChannelWaiter(channelId)
+	if err != nil {
+		return nil, err
+	}
+	defer removeChannelWaiter(channelId, waiter)
+
+	timer := time.NewTimer(time.Duration(setting.ChannelQueueMaxWaitMs) * time.Millisecond)
+	defer timer.Stop()
+	ticker := time.NewTicker(channelQueuePollInterval)
+	defer ticker.Stop()
+	for {
+		if isChannelQueueHead(channelId, waiter) {
+			if release, ok := AcquireChannelConcurrency(channelId); ok {
+				return release, nil
+			}
+		}
+		select {
+		case <-waiter.wake:
+		case <-ticker.C:
+		case <-timer.C:
+			return nil, ErrChannelQueueTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// setupChannelQueue 渠道 channelId 并发上限为 1，开启深度为 depth、最长等待 maxWaitMs 的排队
+func setupChannelQueue(t *testing.T, channelId int, depth int, maxWaitMs int) {
+	t.Helper()
+	common.SetRedisEnabled(false)
+	setChannelMaxConcurrency(t, fmt.Sprintf(`{"%d":1}`, channelId))
+	oldDepth, oldWait := setting.ChannelQueueDepth, setting.ChannelQueueMaxWaitMs
+	setting.ChannelQueueDepth = depth
+	setting.ChannelQueueMaxWaitMs = maxWaitMs
+	t.Cleanup(func() {
+		setting.ChannelQueueDepth, setting.ChannelQueueMaxWaitMs = oldDepth, oldWait
+		setChannelMaxConcurrency(t, `{}`)
+	})
+}
+
+type queueResult struct {
+	release func()
+	err     error
+}
+
+// waitInQueue 在后台等待名额，直到请求进入队列后返回
+func waitInQueue(t *testing.T, channelId int, queued int) chan queueResult {
+	t.Helper()
+	result := make(chan queueResult, 1)
+	go func() {
+		release, err := WaitChannelConcurrency(context.Background(), channelId)
+		result <- queueResult{release, err}
+	}()
+	deadline := time.Now().Add(time.Second)
+	for GetChannelQueueLength(channelId) < queued {
+		if time.Now().After(deadline) {
+			t.Fatalf("queue length = %d, want %d", GetChannelQueueLength(channelId), queued)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return result
+}
+
+func receiveQueueResult(t *testing.T, result chan queueResult) queueResult {
+	t.Helper()
+	select {
+	case r := <-result:
+		return r
+	case <-time.After(time.Second):
+		t.Fatal("queued request was not released")
+	}
+	return queueResult{}
+}
+
+func TestChannelQueueDisabledRejectsAtCap(t *testing.T) {
+	setupChannelQueue(t, 1401, 0, 1000)
+	release, err := WaitChannelConcurrency(context.Background(), 1401)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if _, err = WaitChannelConcurrency(context.Background(), 1401); !errors.Is(err, ErrChannelConcurrencyExceeded) {
+		t.Fatalf("error = %v, want ErrChannelConcurrencyExceeded", err)
+	}
+}
+
+func TestChannelQueueReleasesInOrder(t *testing.T) {
+	setupChannelQueue(t, 1402, 2, 5000)
+	release, err := WaitChannelConcurrency(context.Background(), 1402)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := waitInQueue(t, 1402, 1)
+	second := waitInQueue(t, 1402, 2)
+
+	// 名额释放后队首的请求先获得名额
+	release()
+	r := receiveQueueResult(t, first)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	select {
+	case <-second:
+		t.Fatal("second request acquired a slot before the first released it")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if got := GetChannelQueueLength(1402); got != 1 {
+		t.Fatalf("queue length = %d, want 1", got)
+	}
+
+	r.release()
+	r = receiveQueueResult(t, second)
+	if r.err != nil {
+		t.Fatal(r.err)
+	}
+	r.release()
+	if got := GetChannelQueueLength(1402); got != 0 {
+		t.Fatalf("queue length = %d, want 0", got)
+	}
+}
+
+func TestChannelQueueFull(t *testing.T) {
+	setupChannelQueue(t, 1403, 1, 5000)
+	release, err := WaitChannelConcurrency(context.Background(), 1403)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queued := waitInQueue(t, 1403, 1)
+	if _, err = WaitChannelConcurrency(context.Background(), 1403); !errors.Is(err, ErrChannelQueueFull) {
+		t.Fatalf("error = %v, want ErrChannelQueueFull", err)
+	}
+	release()
+	receiveQueueResult(t, queued).release()
+}
+
+func TestChannelQueueTimeout(t *testing.T) {
+	setupChannelQueue(t, 1404, 1, 50)
+	release, err := WaitChannelConcurrency(context.Background(), 1404)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	start := time.Now()
+	if _, err = WaitChannelConcurrency(context.Background(), 1404); !errors.Is(err, ErrChannelQueueTimeout) {
+		t.Fatalf("error = %v, want ErrChannelQueueTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("rejected after %v, want to wait ChannelQueueMaxWaitMs", elapsed)
+	}
+	if got := GetChannelQueueLength(1404); got != 0 {
+		t.Fatalf("queue length after timeout = %d, want 0", got)
+	}
+}
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 正在进行中的流式响应按渠道登记，渠道被禁用时通知这些响应，由其在流中写入错误后结束，
+// 而不是让客户端遇到连接被直接断开。本节点禁用的渠道立即通知，其他节点禁用的渠道在下一次渠道缓存同步时通知
+var (
+	channelStreamWatchMutex sync.Mutex
+	channelStreamWatchers   = map[int]map[chan struct{}]struct{}{}
+)
+
+// WatchChannelDisable 登记一个使用该渠道的流式响应，渠道被禁用时返回的 channel 会被关闭。
+// 响应结束后必须调用返回的函数取消登记
+func WatchChannelDisable(channelId int) (<-chan struct{}, func()) {
+	ch := make(chan struct{})
+	channelStreamWatchMutex.Lock()
+	watchers, ok := channelStreamWatchers[channelId]
+	if !ok {
+		watchers = map[chan struct{}]struct{}{}
+		channelStreamWatchers[channelId] = watchers
+	}
+	watchers[ch] = struct{}{}
+	channelStreamWatchMutex.Unlock()
+
+	return ch, func() {
+		channelStreamWatchMutex.Lock()
+		defer channelStreamWatchMutex.Unlock()
+		if watchers, ok := channelStreamWatchers[channelId]; ok {
+			delete(watchers, ch)
+			if len(watchers) == 0 {
+				delete(channelStreamWatchers, channelId)
+			}
+		}
+	}
+}
+
+// notifyChannelStreams 渠道状态变为非启用时通知该渠道上所有已登记的流式响应
+func notifyChannelStreams(channelId int, status int) {
+	if status == common.ChannelStatusEnabled {
+		return
+	}
+	channelStreamWatchMutex.Lock()
+	watchers := channelStreamWatchers[channelId]
+	delete(channelStreamWatchers, channelId)
+	channelStreamWatchMutex.Unlock()
+	for ch := range watchers {
+		close(ch)
+	}
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// 成功率统计窗口划分的槽数，每个槽覆盖 ChannelSuccessRateWindowSeconds / channelOutcomeSlots 秒
+const channelOutcomeSlots = 60
+
+type channelOutcomeSlot struct {
+	index   int64 // 槽对应的时间段编号，与当前编号不一致说明已过期
+	success int
+	failure int
+}
+
+type channelOutcomeWindow struct {
+	slots [channelOutcomeSlots]channelOutcomeSlot
+}
+
+// 各渠道最近一段时间内请求成功与失败的次数，仅统计本节点
+var (
+	channelOutcomeMutex sync.Mutex
+	channelOutcomes     = map[int]*channelOutcomeWindow{}
+)
+
+func channelOutcomeSlotWidth() int64 {
+	width := int64(setting.ChannelSuccessRateWindowSeconds) / channelOutcomeSlots
+	if width < 1 {
+		width = 1
+	}
+	return width
+}
+
+// add 在 index 对应的槽中记录一次结果
+func (w *channelOutcomeWindow) add(index int64, success bool) {
+	slot := &w.slots[index%channelOutcomeSlots]
+	if slot.index != index {
+		*slot = channelOutcomeSlot{index: index}
+	}
+	if success {
+		slot.success++
+	} else {
+		slot.failure++
+	}
+}
+
+// counts 返回截至 index 的统计窗口内成功与失败的次数
+func (w *channelOutcomeWindow) counts(index int64) (success int, failure int) {
+	for _, slot := range w.slots {
+		if slot.index > index-channelOutcomeSlots && slot.index <= index {
+			success += slot.success
+			failure += slot.failure
+		}
+	}
+	return success, failure
+}
+
+// RecordChannelOutcome 记录一次转发到渠道的结果
+func RecordChannelOutcome(channelId int, success bool) {
+	index := time.Now().Unix() / channelOutcomeSlotWidth()
+	channelOutcomeMutex.Lock()
+	defer channelOutcomeMutex.Unlock()
+	window, ok := channelOutcomes[channelId]
+	if !ok {
+		window = &channelOutcomeWindow{}
+		channelOutcomes[channelId] = window
+	}
+	window.add(index, success)
+	if !success {
+		countChannelGraceFailure(channelId)
+	}
+}
+
+// channelOutcomeCounts 返回渠道在统计窗口内成功与失败的次数
+func channelOutcomeCounts(channelId int) (success int, failure int) {
+	index := time.Now().Unix() / channelOutcomeSlotWidth()
+	channelOutcomeMutex.Lock()
+	defer channelOutcomeMutex.Unlock()
+	window, ok := channelOutcomes[channelId]
+	if !ok {
+		return 0, 0
+	}
+	return window.counts(index)
+}
+
+// GetChannelSuccessRate 返回渠道在统计窗口内的成功率及样本数，没有样本时成功率为 1
+func GetChannelSuccessRate(channelId int) (float64, int) {
+	success, failure := channelOutcomeCounts(channelId)
+	total := success + failure
+	if total == 0 {
+		return 1, 0
+	}
+	return float64(success) / float64(total), total
+}
+
+// IsChannelSoftDisabled 渠道在统计窗口内的失败次数达到 SoftDisableMinFailures 时视为软禁用，
+// 仍可被选中但选择权重按 SoftDisableWeightFactor 降低
+func IsChannelSoftDisabled(channelId int) bool {
+	if setting.SoftDisableWeightFactor <= 0 || setting.SoftDisableWeightFactor >= 1 || setting.SoftDisableMinFailures <= 0 {
+		return false
+	}
+	_, failure := channelOutcomeCounts(channelId)
+	return failure >= setting.SoftDisableMinFailures
+}
+
+// applyChannelSoftDisable 按 SoftDisableWeightFactor 降低软禁用渠道的选择权重，权重大于 0 时至少保留 1
+func applyChannelSoftDisable(channelId int, weight int) int {
+	if weight <= 0 || !IsChannelSoftDisabled(channelId) {
+		return weight
+	}
+	scaled := int(float64(weight) * setting.SoftDisableWeightFactor)
+	if scaled < 1 {
+		scaled = 1
+	}
+	return scaled
+}
+
+// resetChannelOutcomes 渠道重新启用后清空此前的统计，避免禁用前的失败立即再次触发禁用
+func resetChannelOutcomes(channelId int) {
+	channelOutcomeMutex.Lock()
+	defer channelOutcomeMutex.Unlock()
+	delete(channelOutcomes, channelId)
+}
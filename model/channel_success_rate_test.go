@@ -0,0 +1,72 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func setSoftDisable(t *testing.T, factor float64, minFailures int) {
+	t.Helper()
+	oldFactor, oldMinFailures := setting.SoftDisableWeightFactor, setting.SoftDisableMinFailures
+	setting.SoftDisableWeightFactor, setting.SoftDisableMinFailures = factor, minFailures
+	t.Cleanup(func() {
+		setting.SoftDisableWeightFactor, setting.SoftDisableMinFailures = oldFactor, oldMinFailures
+	})
+}
+
+func recordFailures(t *testing.T, channelId int, n int) {
+	t.Helper()
+	t.Cleanup(func() { resetChannelOutcomes(channelId) })
+	for i := 0; i < n; i++ {
+		RecordChannelOutcome(channelId, false)
+	}
+}
+
+func TestApplyChannelSoftDisable(t *testing.T) {
+	setSoftDisable(t, 0.1, 3)
+
+	recordFailures(t, 1611, 2)
+	if IsChannelSoftDisabled(1611) || applyChannelSoftDisable(1611, 100) != 100 {
+		t.Fatal("channel soft-disabled below SoftDisableMinFailures")
+	}
+	RecordChannelOutcome(1611, false)
+	if !IsChannelSoftDisabled(1611) {
+		t.Fatal("channel not soft-disabled at SoftDisableMinFailures")
+	}
+	cases := map[int]int{100: 10, 5: 1, 0: 0}
+	for weight, want := range cases {
+		if got := applyChannelSoftDisable(1611, weight); got != want {
+			t.Errorf("applyChannelSoftDisable(weight %d) = %d, want %d", weight, got, want)
+		}
+	}
+
+	// 系数为 0 时不启用软禁用
+	setting.SoftDisableWeightFactor = 0
+	if IsChannelSoftDisabled(1611) || applyChannelSoftDisable(1611, 100) != 100 {
+		t.Fatal("channel soft-disabled with SoftDisableWeightFactor = 0")
+	}
+}
+
+func TestSoftDisabledChannelSelectedLessOften(t *testing.T) {
+	setSoftDisable(t, 0.1, 3)
+	setTestChannelCache(t, newTestChannel(1612, 100), newTestChannel(1613, 100))
+	recordFailures(t, 1612, 3)
+
+	counts := map[int]int{}
+	const times = 2000
+	for i := 0; i < times; i++ {
+		channel, err := GetRandomSatisfiedChannel("default", "gpt-4o", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[channel.Id]++
+	}
+	// 权重 10:100，软禁用渠道约占 9% 的流量，仍会被选中
+	if counts[1612] == 0 || counts[1612] > times/5 {
+		t.Fatalf("soft-disabled channel selected %d of %d times, want a small but non-zero share", counts[1612], times)
+	}
+	if counts[1613] < times*3/4 {
+		t.Fatalf("healthy channel selected %d of %d times, want most of the traffic", counts[1613], times)
+	}
+}
@@ -0,0 +1,47 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+)
+
+func TestChannelShouldAutoDisable(t *testing.T) {
+	old := common.AutomaticDisableChannelEnabled
+	t.Cleanup(func() { common.AutomaticDisableChannelEnabled = old })
+
+	on, off := true, false
+	cases := []struct {
+		global   bool
+		autoBan  int
+		override *bool
+		want     bool
+	}{
+		// 未单独设置时沿用全局开关与 auto_ban
+		{true, 1, nil, true},
+		{true, 0, nil, false},
+		{false, 1, nil, false},
+		{false, 0, nil, false},
+		// 渠道设置覆盖全局开关与 auto_ban
+		{true, 1, &off, false},
+		{true, 0, &off, false},
+		{false, 1, &on, true},
+		{false, 0, &on, true},
+		{true, 0, &on, true},
+		{false, 1, &off, false},
+	}
+	for _, tc := range cases {
+		common.AutomaticDisableChannelEnabled = tc.global
+		autoBan := tc.autoBan
+		channel := &Channel{Id: 1661, AutoBan: &autoBan}
+		channel.SetOtherSettings(dto.ChannelOtherSettings{AutoDisableEnabled: tc.override})
+		if got := channel.ShouldAutoDisable(); got != tc.want {
+			override := "unset"
+			if tc.override != nil {
+				override = map[bool]string{true: "on", false: "off"}[*tc.override]
+			}
+			t.Errorf("global=%v auto_ban=%d override=%s: ShouldAutoDisable() = %v, want %v", tc.global, tc.autoBan, override, got, tc.want)
+		}
+	}
+}
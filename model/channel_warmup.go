@@ -0,0 +1,85 @@
+package model
+
+import (
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// 预热开始时渠道获得的最小流量比例
+const channelWarmupMinShare = 0.05
+
+// 渠道最近一次被启用的时间（手动或自动启用），用于在 ChannelWarmupSeconds 内逐步放量
+var (
+	channelWarmupMutex sync.RWMutex
+	channelEnabledAt   = map[int]int64{}
+)
+
+// markChannelEnabled 记录渠道被启用的时间
+func markChannelEnabled(channelId int, enabledAt int64) {
+	channelWarmupMutex.Lock()
+	defer channelWarmupMutex.Unlock()
+	channelEnabledAt[channelId] = enabledAt
+}
+
+// loadChannelWarmup 从渠道的状态变更时间恢复启用时间，使其他节点启用的渠道在本节点同样预热
+func loadChannelWarmup(channels []*Channel) {
+	if setting.ChannelWarmupSeconds <= 0 {
+		return
+	}
+	since := common.GetTimestamp() - int64(setting.ChannelWarmupSeconds)
+	channelWarmupMutex.Lock()
+	defer channelWarmupMutex.Unlock()
+	for _, channel := range channels {
+		if channel.Status != common.ChannelStatusEnabled || channel.OtherInfo == "" {
+			continue
+		}
+		statusTime, ok := channel.GetOtherInfo()["status_time"].(float64)
+		if !ok || int64(statusTime) <= since {
+			continue
+		}
+		if int64(statusTime) > channelEnabledAt[channel.Id] {
+			channelEnabledAt[channel.Id] = int64(statusTime)
+		}
+	}
+}
+
+// channelWarmupShare 返回渠道当前应获得的流量比例，预热结束后为 1
+func channelWarmupShare(channelId int, now int64) float64 {
+	warmup := int64(setting.ChannelWarmupSeconds)
+	if warmup <= 0 {
+		return 1
+	}
+	channelWarmupMutex.RLock()
+	enabledAt, ok := channelEnabledAt[channelId]
+	channelWarmupMutex.RUnlock()
+	if !ok {
+		return 1
+	}
+	elapsed := now - enabledAt
+	if elapsed >= warmup {
+		channelWarmupMutex.Lock()
+		delete(channelEnabledAt, channelId)
+		channelWarmupMutex.Unlock()
+		return 1
+	}
+	share := float64(elapsed) / float64(warmup)
+	if share < channelWarmupMinShare {
+		share = channelWarmupMinShare
+	}
+	return share
+}
+
+// applyChannelWarmup 按预热进度缩放渠道的选择权重，权重大于 0 时至少保留 1
+func applyChannelWarmup(channelId int, weight int, now int64) int {
+	share := channelWarmupShare(channelId, now)
+	if share >= 1 || weight <= 0 {
+		return weight
+	}
+	scaled := int(float64(weight) * share)
+	if scaled < 1 {
+		scaled = 1
+	}
+	return scaled
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func TestChannelWarmupShareIncreases(t *testing.T) {
+	setting.ChannelWarmupSeconds = 100
+	t.Cleanup(func() { setting.ChannelWarmupSeconds = 0 })
+	markChannelEnabled(1191, 1000)
+
+	cases := []struct {
+		now   int64
+		share float64
+	}{
+		{1000, channelWarmupMinShare},
+		{1002, channelWarmupMinShare},
+		{1025, 0.25},
+		{1050, 0.5},
+		{1090, 0.9},
+	}
+	last := 0.0
+	for _, tc := range cases {
+		share := channelWarmupShare(1191, tc.now)
+		if share != tc.share {
+			t.Errorf("share at +%ds = %v, want %v", tc.now-1000, share, tc.share)
+		}
+		if share < last {
+			t.Errorf("share decreased at +%ds: %v < %v", tc.now-1000, share, last)
+		}
+		last = share
+	}
+	if got := applyChannelWarmup(1191, 200, 1050); got != 100 {
+		t.Errorf("weight at half warm-up = %d, want 100", got)
+	}
+	if got := applyChannelWarmup(1191, 1, 1000); got != 1 {
+		t.Errorf("weight at warm-up start = %d, want at least 1", got)
+	}
+
+	// 预热结束后恢复全部流量，并不再记录
+	if share := channelWarmupShare(1191, 1100); share != 1 {
+		t.Errorf("share after warm-up = %v, want 1", share)
+	}
+	if share := channelWarmupShare(1191, 1000); share != 1 {
+		t.Errorf("share after warm-up finished = %v, want 1", share)
+	}
+}
+
+func TestChannelWarmupDisabled(t *testing.T) {
+	setting.ChannelWarmupSeconds = 0
+	markChannelEnabled(1192, common.GetTimestamp())
+	if got := applyChannelWarmup(1192, 50, common.GetTimestamp()); got != 50 {
+		t.Fatalf("weight = %d, want 50 when warm-up is disabled", got)
+	}
+}
+
+func TestLoadChannelWarmupFromStatusTime(t *testing.T) {
+	setting.ChannelWarmupSeconds = 100
+	t.Cleanup(func() { setting.ChannelWarmupSeconds = 0 })
+	now := common.GetTimestamp()
+
+	recent := &Channel{Id: 1193, Status: common.ChannelStatusEnabled}
+	recent.SetOtherInfo(map[string]interface{}{"status_time": now - 50})
+	old := &Channel{Id: 1194, Status: common.ChannelStatusEnabled}
+	old.SetOtherInfo(map[string]interface{}{"status_time": now - 500})
+	disabled := &Channel{Id: 1195, Status: common.ChannelStatusAutoDisabled}
+	disabled.SetOtherInfo(map[string]interface{}{"status_time": now - 10})
+	loadChannelWarmup([]*Channel{recent, old, disabled})
+
+	// 其他节点刚启用的渠道在本节点同样预热
+	if share := channelWarmupShare(1193, now); share < 0.45 || share > 0.55 {
+		t.Errorf("recently enabled share = %v, want about 0.5", share)
+	}
+	if share := channelWarmupShare(1194, now); share != 1 {
+		t.Errorf("long enabled share = %v, want 1", share)
+	}
+	if share := channelWarmupShare(1195, now); share != 1 {
+		t.Errorf("disabled channel share = %v, want 1", share)
+	}
+}
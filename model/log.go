@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/types"
 
@@ -154,6 +155,11 @@ type RecordConsumeLogParams struct {
 }
 
 func RecordConsumeLog(c *gin.Context, userId int, params RecordConsumeLogParams) {
+	// 无论是否记录日志，都需要累计令牌当日及当前账单周期的消耗
+	IncreaseTokenDailyQuotaUsed(params.TokenId, params.Quota)
+	IncreaseTokenPeriodQuotaUsed(params.TokenId, common.GetContextKeyInt(c, constant.ContextKeyTokenBillingAnchorDay), params.Quota)
+	IncreaseRateLimitRuleTokens(params.TokenId, userId, c.ClientIP(), common.GetContextKeyString(c, constant.ContextKeyTokenGroup),
+		params.PromptTokens+params.CompletionTokens-common.GetContextKeyInt(c, constant.ContextKeyRateLimitStreamTokens))
 	if !common.LogConsumeEnabled {
 		return
 	}
@@ -387,6 +393,22 @@ func SumUsedToken(logType int, startTimestamp int64, endTimestamp int64, modelNa
 	return token
 }
 
+// RequestLogStamp 一次请求的日志记录时间，用于重启后恢复每日限流计数
+type RequestLogStamp struct {
+	UserId    int
+	TokenId   int
+	Type      int
+	CreatedAt int64
+}
+
+// GetRequestLogStampsSince 返回 since 之后的消费日志与错误日志的时间（按时间从旧到新），只读取必要的列
+func GetRequestLogStampsSince(since int64) (stamps []RequestLogStamp, err error) {
+	err = LOG_DB.Model(&Log{}).Select("user_id, token_id, type, created_at").
+		Where("created_at >= ? and type in ?", since, []int{LogTypeConsume, LogTypeError}).
+		Order("created_at asc").Find(&stamps).Error
+	return stamps, err
+}
+
 func DeleteOldLog(ctx context.Context, targetTimestamp int64, limit int) (int64, error) {
 	var total int64 = 0
 
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 各模型在所有渠道上最近一段时间内请求成功与失败的次数，统计窗口与渠道成功率相同，仅统计本节点
+var (
+	modelOutcomeMutex sync.Mutex
+	modelOutcomes     = map[string]*channelOutcomeWindow{}
+)
+
+// ModelSuccessRate 模型在统计窗口内的成功率
+type ModelSuccessRate struct {
+	Model   string  `json:"model"`
+	Rate    float64 `json:"rate"`
+	Samples int     `json:"samples"`
+}
+
+// RecordModelOutcome 记录一次模型请求在渠道上的结果
+func RecordModelOutcome(modelName string, success bool) {
+	if modelName == "" {
+		return
+	}
+	index := time.Now().Unix() / channelOutcomeSlotWidth()
+	modelOutcomeMutex.Lock()
+	defer modelOutcomeMutex.Unlock()
+	window, ok := modelOutcomes[modelName]
+	if !ok {
+		window = &channelOutcomeWindow{}
+		modelOutcomes[modelName] = window
+	}
+	window.add(index, success)
+}
+
+// GetModelSuccessRate 返回模型在统计窗口内的成功率及样本数，没有样本时成功率为 1
+func GetModelSuccessRate(modelName string) (float64, int) {
+	index := time.Now().Unix() / channelOutcomeSlotWidth()
+	modelOutcomeMutex.Lock()
+	window, ok := modelOutcomes[modelName]
+	var success, failure int
+	if ok {
+		success, failure = window.counts(index)
+	}
+	modelOutcomeMutex.Unlock()
+	total := success + failure
+	if total == 0 {
+		return 1, 0
+	}
+	return float64(success) / float64(total), total
+}
+
+// GetModelSuccessRates 返回统计窗口内有样本的全部模型的成功率，按模型名排序
+func GetModelSuccessRates() []ModelSuccessRate {
+	index := time.Now().Unix() / channelOutcomeSlotWidth()
+	modelOutcomeMutex.Lock()
+	rates := make([]ModelSuccessRate, 0, len(modelOutcomes))
+	for modelName, window := range modelOutcomes {
+		success, failure := window.counts(index)
+		total := success + failure
+		if total == 0 {
+			// 窗口内已没有样本的模型不再保留
+			delete(modelOutcomes, modelName)
+			continue
+		}
+		rates = append(rates, ModelSuccessRate{Model: modelName, Rate: float64(success) / float64(total), Samples: total})
+	}
+	modelOutcomeMutex.Unlock()
+	sort.Slice(rates, func(i, j int) bool { return rates[i].Model < rates[j].Model })
+	return rates
+}
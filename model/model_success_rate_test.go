@@ -0,0 +1,50 @@
+package model
+
+import "testing"
+
+func recordModelOutcomes(modelName string, success, failure int) {
+	for i := 0; i < success; i++ {
+		RecordModelOutcome(modelName, true)
+	}
+	for i := 0; i < failure; i++ {
+		RecordModelOutcome(modelName, false)
+	}
+}
+
+func TestModelSuccessRate(t *testing.T) {
+	t.Cleanup(func() {
+		modelOutcomeMutex.Lock()
+		delete(modelOutcomes, "test-176-a")
+		delete(modelOutcomes, "test-176-b")
+		modelOutcomeMutex.Unlock()
+	})
+	// 同一模型在不同渠道上的结果合并统计
+	recordModelOutcomes("test-176-a", 6, 2)
+	recordModelOutcomes("test-176-a", 2, 0)
+	recordModelOutcomes("test-176-b", 1, 3)
+	RecordModelOutcome("", false)
+
+	if rate, samples := GetModelSuccessRate("test-176-a"); rate != 0.8 || samples != 10 {
+		t.Fatalf("model a = %v over %d samples, want 0.8 over 10", rate, samples)
+	}
+	if rate, samples := GetModelSuccessRate("test-176-b"); rate != 0.25 || samples != 4 {
+		t.Fatalf("model b = %v over %d samples, want 0.25 over 4", rate, samples)
+	}
+	if rate, samples := GetModelSuccessRate("test-176-none"); rate != 1 || samples != 0 {
+		t.Fatalf("model without samples = %v over %d samples, want 1 over 0", rate, samples)
+	}
+
+	var got []ModelSuccessRate
+	for _, rate := range GetModelSuccessRates() {
+		if rate.Model == "" {
+			t.Fatal("empty model name recorded")
+		}
+		if rate.Model == "test-176-a" || rate.Model == "test-176-b" {
+			got = append(got, rate)
+		}
+	}
+	want := []ModelSuccessRate{{Model: "test-176-a", Rate: 0.8, Samples: 10}, {Model: "test-176-b", Rate: 0.25, Samples: 4}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("rates = %+v, want %+v", got, want)
+	}
+}
@@ -11,6 +11,8 @@ import (
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/QuantumNous/new-api/setting/system_setting"
+
+	"gorm.io/gorm"
 )
 
 type Option struct {
@@ -115,11 +117,113 @@ func InitOptionMap() {
 	common.OptionMap["TokenRateLimitDurationMinutes"] = strconv.Itoa(setting.TokenRateLimitDurationMinutes)
 	common.OptionMap["TokenRateLimitCount"] = strconv.Itoa(setting.TokenRateLimitCount)
 	common.OptionMap["TokenRateLimitSuccessCount"] = strconv.Itoa(setting.TokenRateLimitSuccessCount)
+	common.OptionMap["TokenPerIPRateLimit"] = strconv.Itoa(setting.TokenPerIPRateLimit)
+	common.OptionMap["TokenBurstRateLimitCount"] = strconv.Itoa(setting.TokenBurstRateLimitCount)
+	common.OptionMap["TokenBurstDurationSeconds"] = strconv.Itoa(setting.TokenBurstDurationSeconds)
+	common.OptionMap["TokenSustainedRateLimitCount"] = strconv.Itoa(setting.TokenSustainedRateLimitCount)
+	common.OptionMap["PerCustomerRateLimit"] = strconv.Itoa(setting.PerCustomerRateLimit)
+	common.OptionMap["PerCustomerMaxCustomers"] = strconv.Itoa(setting.PerCustomerMaxCustomers)
+	common.OptionMap["Upstream429Penalty"] = strconv.Itoa(setting.Upstream429Penalty)
+	common.OptionMap["RateLimitRedisTimeoutMs"] = strconv.Itoa(setting.RateLimitRedisTimeoutMs)
+	common.OptionMap["RetryGraceCount"] = strconv.Itoa(setting.RetryGraceCount)
+	common.OptionMap["RateLimitWarmupEnabled"] = strconv.FormatBool(setting.RateLimitWarmupEnabled)
+	common.OptionMap["DailyLimitGraceBeforeResetMinutes"] = strconv.Itoa(setting.DailyLimitGraceBeforeResetMinutes)
+	common.OptionMap["DailyLimitOverageAllowance"] = strconv.Itoa(setting.DailyLimitOverageAllowance)
+	common.OptionMap["OrgRateLimitEnabled"] = strconv.FormatBool(setting.OrgRateLimitEnabled)
+	common.OptionMap["OrgRateLimitDurationMinutes"] = strconv.Itoa(setting.OrgRateLimitDurationMinutes)
+	common.OptionMap["OrgRateLimitCount"] = strconv.Itoa(setting.OrgRateLimitCount)
+	common.OptionMap["OrgRateLimitSuccessCount"] = strconv.Itoa(setting.OrgRateLimitSuccessCount)
+	common.OptionMap["PlaygroundRateLimitEnabled"] = strconv.FormatBool(setting.PlaygroundRateLimitEnabled)
+	common.OptionMap["PlaygroundRateLimitDurationMinutes"] = strconv.Itoa(setting.PlaygroundRateLimitDurationMinutes)
+	common.OptionMap["PlaygroundRateLimitCount"] = strconv.Itoa(setting.PlaygroundRateLimitCount)
+	common.OptionMap["PlaygroundRateLimitSuccessCount"] = strconv.Itoa(setting.PlaygroundRateLimitSuccessCount)
 	common.OptionMap["TokenRateLimitGroup"] = setting.TokenRateLimitGroup2JSONString()
 	common.OptionMap["TokenDailyRateLimitEnabled"] = strconv.FormatBool(setting.TokenDailyRateLimitEnabled)
 	common.OptionMap["TokenDailyRateLimitCount"] = strconv.Itoa(setting.TokenDailyRateLimitCount)
 	common.OptionMap["TokenDailyRateLimitSuccessCount"] = strconv.Itoa(setting.TokenDailyRateLimitSuccessCount)
 	common.OptionMap["TokenDailyRateLimitGroup"] = setting.TokenDailyRateLimitGroup2JSONString()
+	common.OptionMap["RateLimitTotalRejectStatusCode"] = strconv.Itoa(setting.RateLimitTotalRejectStatusCode)
+	common.OptionMap["RateLimitSuccessRejectStatusCode"] = strconv.Itoa(setting.RateLimitSuccessRejectStatusCode)
+	common.OptionMap["RateLimitTotalMinRetryAfterSeconds"] = strconv.Itoa(setting.RateLimitTotalMinRetryAfterSeconds)
+	common.OptionMap["RateLimitStrictGroup"] = setting.RateLimitStrictGroup
+	common.OptionMap["ErrorFormat"] = setting.ErrorFormat
+	common.OptionMap["RateLimitReadReplicaAddr"] = setting.RateLimitReadReplicaAddr
+	common.OptionMap["RateLimitCountMethods"] = setting.RateLimitCountMethodsToString()
+	common.OptionMap["RateLimitGroupCaseInsensitive"] = strconv.FormatBool(setting.RateLimitGroupCaseInsensitive)
+	common.OptionMap["RateLimitSandboxCount"] = strconv.Itoa(setting.RateLimitSandboxCount)
+	common.OptionMap["RateLimitSandboxDurationSeconds"] = strconv.Itoa(setting.RateLimitSandboxDurationSeconds)
+	common.OptionMap["ChannelProviderBackoffEnabled"] = strconv.FormatBool(setting.ChannelProviderBackoffEnabled)
+	common.OptionMap["ChannelProviderThrottlePercent"] = strconv.Itoa(setting.ChannelProviderThrottlePercent)
+	common.OptionMap["ChannelAffinityEnabled"] = strconv.FormatBool(setting.ChannelAffinityEnabled)
+	common.OptionMap["ChannelAffinityTTLSeconds"] = strconv.Itoa(setting.ChannelAffinityTTLSeconds)
+	common.OptionMap["ChannelProviderBackoffMaxSeconds"] = strconv.Itoa(setting.ChannelProviderBackoffMaxSeconds)
+	common.OptionMap["RejectUnparseableRequests"] = strconv.FormatBool(setting.RejectUnparseableRequests)
+	common.OptionMap["RateLimitRefundOnCancelEnabled"] = strconv.FormatBool(setting.RateLimitRefundOnCancelEnabled)
+	common.OptionMap["TokenQuotaSchedule"] = setting.TokenQuotaSchedule
+	common.OptionMap["ModelGlobalRateLimit"] = setting.ModelGlobalRateLimit2JSONString()
+	common.OptionMap["ModelDailyCap"] = setting.ModelDailyCap2JSONString()
+	common.OptionMap["ModelFairShareEnabled"] = strconv.FormatBool(setting.ModelFairShareEnabled)
+	common.OptionMap["RateLimitRules"] = setting.RateLimitRules2JSONString()
+	common.OptionMap["RateLimitStreamAccountingTokens"] = strconv.Itoa(setting.RateLimitStreamAccountingTokens)
+	common.OptionMap["TokenRateLimitWindowMode"] = setting.TokenRateLimitWindowMode
+	common.OptionMap["AlwaysSendRateLimitHeaders"] = strconv.FormatBool(setting.AlwaysSendRateLimitHeaders)
+	common.OptionMap["RateLimitResetHeaderFormat"] = setting.RateLimitResetHeaderFormat
+	common.OptionMap["SuccessLimiterAlgorithm"] = setting.SuccessLimiterAlgorithm
+	common.OptionMap["SuccessLimiterBurstPercent"] = strconv.Itoa(setting.SuccessLimiterBurstPercent)
+	common.OptionMap["ExemptAdminFromRateLimit"] = strconv.FormatBool(setting.ExemptAdminFromRateLimit)
+	common.OptionMap["ExposeChannelInError"] = strconv.FormatBool(setting.ExposeChannelInError)
+	common.OptionMap["EmergencyDisableAllRelays"] = strconv.FormatBool(setting.EmergencyDisableAllRelays)
+	common.OptionMap["EmergencyDisableMessage"] = setting.EmergencyDisableMessage
+	common.OptionMap["RateLimitFailOpenEnabled"] = strconv.FormatBool(setting.RateLimitFailOpenEnabled)
+	common.OptionMap["EnableTracing"] = strconv.FormatBool(setting.EnableTracing)
+	common.OptionMap["RateLimitDedupWindowMs"] = strconv.Itoa(setting.RateLimitDedupWindowMs)
+	common.OptionMap["RateLimitDedupMaxForgiven"] = strconv.Itoa(setting.RateLimitDedupMaxForgiven)
+	common.OptionMap["RateLimitIdempotencyWindowSeconds"] = strconv.Itoa(setting.RateLimitIdempotencyWindowSeconds)
+	common.OptionMap["RateLimitKeySweepIntervalSeconds"] = strconv.Itoa(setting.RateLimitKeySweepIntervalSeconds)
+	common.OptionMap["RateLimitMaxKeys"] = strconv.Itoa(setting.RateLimitMaxKeys)
+	common.OptionMap["RateLimitEvictMinIdleSeconds"] = strconv.Itoa(setting.RateLimitEvictMinIdleSeconds)
+	common.OptionMap["RateLimitPolicyHeadersEnabled"] = strconv.FormatBool(setting.RateLimitPolicyHeadersEnabled)
+	common.OptionMap["RateLimitLowPriorityReservePercent"] = strconv.Itoa(setting.RateLimitLowPriorityReservePercent)
+	common.OptionMap["RateLimitBackpressureThresholdPercent"] = strconv.Itoa(setting.RateLimitBackpressureThresholdPercent)
+	common.OptionMap["RateLimitBackpressureMaxDelayMs"] = strconv.Itoa(setting.RateLimitBackpressureMaxDelayMs)
+	common.OptionMap["ChannelWarmupSeconds"] = strconv.Itoa(setting.ChannelWarmupSeconds)
+	common.OptionMap["ChannelMaxConcurrency"] = setting.ChannelMaxConcurrency2JSONString()
+	common.OptionMap["ChannelQueueDepth"] = strconv.Itoa(setting.ChannelQueueDepth)
+	common.OptionMap["ChannelQueueMaxWaitMs"] = strconv.Itoa(setting.ChannelQueueMaxWaitMs)
+	common.OptionMap["ChannelFallbackChains"] = setting.ChannelFallbackChains2JSONString()
+	common.OptionMap["ModelAliasGroups"] = setting.ModelAliasGroups2JSONString()
+	common.OptionMap["ModelRequestTimeout"] = setting.ModelRequestTimeout2JSONString()
+	common.OptionMap["ModelMaxTokensCap"] = setting.ModelMaxTokensCap2JSONString()
+	common.OptionMap["ModelMaxTokensCapHeaderEnabled"] = strconv.FormatBool(setting.ModelMaxTokensCapHeaderEnabled)
+	common.OptionMap["GroupForbiddenParams"] = setting.GroupForbiddenParams2JSONString()
+	common.OptionMap["ModelTimeoutDisableThreshold"] = strconv.Itoa(setting.ModelTimeoutDisableThreshold)
+	common.OptionMap["ChannelMinSuccessRate"] = strconv.FormatFloat(setting.ChannelMinSuccessRate, 'f', -1, 64)
+	common.OptionMap["ChannelSuccessRateWindowSeconds"] = strconv.Itoa(setting.ChannelSuccessRateWindowSeconds)
+	common.OptionMap["ChannelSuccessRateMinSamples"] = strconv.Itoa(setting.ChannelSuccessRateMinSamples)
+	common.OptionMap["ModelMinSuccessRate"] = strconv.FormatFloat(setting.ModelMinSuccessRate, 'f', -1, 64)
+	common.OptionMap["ChannelPostEnableGraceFailures"] = strconv.Itoa(setting.ChannelPostEnableGraceFailures)
+	common.OptionMap["ChannelRetryAttributionEnabled"] = strconv.FormatBool(setting.ChannelRetryAttributionEnabled)
+	common.OptionMap["ChannelFlapThreshold"] = strconv.Itoa(setting.ChannelFlapThreshold)
+	common.OptionMap["ChannelFlapWindowSeconds"] = strconv.Itoa(setting.ChannelFlapWindowSeconds)
+	common.OptionMap["ChannelFlapHoldSeconds"] = strconv.Itoa(setting.ChannelFlapHoldSeconds)
+	common.OptionMap["NotifyOnMidStreamChannelDisable"] = strconv.FormatBool(setting.NotifyOnMidStreamChannelDisable)
+	common.OptionMap["ChannelDisableOnEmptyAfterN"] = strconv.Itoa(setting.ChannelDisableOnEmptyAfterN)
+	common.OptionMap["SoftDisableWeightFactor"] = strconv.FormatFloat(setting.SoftDisableWeightFactor, 'f', -1, 64)
+	common.OptionMap["SoftDisableMinFailures"] = strconv.Itoa(setting.SoftDisableMinFailures)
+	common.OptionMap["RateLimitChannelScalingBaseline"] = strconv.Itoa(setting.RateLimitChannelScalingBaseline)
+	common.OptionMap["RateLimitChannelScalingMaxPercent"] = strconv.Itoa(setting.RateLimitChannelScalingMaxPercent)
+	common.OptionMap["ChannelQuarantineSeconds"] = strconv.Itoa(setting.ChannelQuarantineSeconds)
+	common.OptionMap["ChannelQuarantineErrorThreshold"] = strconv.Itoa(setting.ChannelQuarantineErrorThreshold)
+	common.OptionMap["UserDailyRateLimitEnabled"] = strconv.FormatBool(setting.UserDailyRateLimitEnabled)
+	common.OptionMap["UserDailyRateLimitCount"] = strconv.Itoa(setting.UserDailyRateLimitCount)
+	common.OptionMap["UserDailyRateLimitSuccessCount"] = strconv.Itoa(setting.UserDailyRateLimitSuccessCount)
+	common.OptionMap["UserDailyRateLimitGroup"] = setting.UserDailyRateLimitGroup2JSONString()
+	common.OptionMap["TokenDailyQuotaCredits"] = strconv.Itoa(setting.TokenDailyQuotaCredits)
+	common.OptionMap["TokenBillingPeriodQuotaCredits"] = strconv.Itoa(setting.TokenBillingPeriodQuotaCredits)
+	common.OptionMap["RateLimitRejectStatusCode"] = strconv.Itoa(setting.RateLimitRejectStatusCode)
+	common.OptionMap["RateLimitBlockMaxMs"] = strconv.Itoa(setting.RateLimitBlockMaxMs)
+	common.OptionMap["ShadowRateLimitAlgorithm"] = setting.ShadowRateLimitAlgorithm
+	common.OptionMap["RateLimitSuccessExcludeBodyErrors"] = strconv.FormatBool(setting.RateLimitSuccessExcludeBodyErrors)
 	common.OptionMap["ModelRatio"] = ratio_setting.ModelRatio2JSONString()
 	common.OptionMap["ModelPrice"] = ratio_setting.ModelPrice2JSONString()
 	common.OptionMap["CacheRatio"] = ratio_setting.CacheRatio2JSONString()
@@ -152,6 +256,8 @@ func InitOptionMap() {
 	common.OptionMap["SensitiveWords"] = setting.SensitiveWordsToString()
 	common.OptionMap["StreamCacheQueueLength"] = strconv.Itoa(setting.StreamCacheQueueLength)
 	common.OptionMap["AutomaticDisableKeywords"] = operation_setting.AutomaticDisableKeywordsToString()
+	common.OptionMap["ChannelDisableErrorCodes"] = setting.ChannelDisableErrorCodesToString()
+	common.OptionMap["ChannelDisableRegexByType"] = setting.ChannelDisableRegexByType2JSONString()
 	common.OptionMap["ExposeRatioEnabled"] = strconv.FormatBool(ratio_setting.IsExposeRatioEnabled())
 
 	// 自动添加所有注册的模型配置
@@ -198,6 +304,33 @@ func UpdateOption(key string, value string) error {
 	return updateOptionMap(key, value)
 }
 
+// UpdateOptions 在一个事务中保存多个配置项，全部写入成功后再更新内存中的配置，写入失败时不修改任何配置。
+// 调用方需事先校验各配置项的值
+func UpdateOptions(options map[string]string) error {
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		for key, value := range options {
+			option := Option{Key: key}
+			if err := tx.FirstOrCreate(&option, Option{Key: key}).Error; err != nil {
+				return err
+			}
+			option.Value = value
+			if err := tx.Save(&option).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for key, value := range options {
+		if err = updateOptionMap(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func updateOptionMap(key string, value string) (err error) {
 	common.OptionMapRWMutex.Lock()
 	defer common.OptionMapRWMutex.Unlock()
@@ -295,6 +428,32 @@ func updateOptionMap(key string, value string) (err error) {
 			setting.ModelRequestRateLimitEnabled = boolValue
 		case "TokenRateLimitEnabled":
 			setting.TokenRateLimitEnabled = boolValue
+		case "RateLimitFailOpenEnabled":
+			setting.RateLimitFailOpenEnabled = boolValue
+		case "RateLimitPolicyHeadersEnabled":
+			setting.RateLimitPolicyHeadersEnabled = boolValue
+		case "NotifyOnMidStreamChannelDisable":
+			setting.NotifyOnMidStreamChannelDisable = boolValue
+		case "RateLimitWarmupEnabled":
+			setting.RateLimitWarmupEnabled = boolValue
+		case "ModelFairShareEnabled":
+			setting.ModelFairShareEnabled = boolValue
+		case "ModelMaxTokensCapHeaderEnabled":
+			setting.ModelMaxTokensCapHeaderEnabled = boolValue
+		case "RateLimitRefundOnCancelEnabled":
+			setting.RateLimitRefundOnCancelEnabled = boolValue
+		case "ChannelProviderBackoffEnabled":
+			setting.ChannelProviderBackoffEnabled = boolValue
+		case "ChannelAffinityEnabled":
+			setting.ChannelAffinityEnabled = boolValue
+		case "ChannelRetryAttributionEnabled":
+			setting.ChannelRetryAttributionEnabled = boolValue
+		case "OrgRateLimitEnabled":
+			setting.OrgRateLimitEnabled = boolValue
+		case "PlaygroundRateLimitEnabled":
+			setting.PlaygroundRateLimitEnabled = boolValue
+		case "UserDailyRateLimitEnabled":
+			setting.UserDailyRateLimitEnabled = boolValue
 		case "TokenDailyRateLimitEnabled":
 			setting.TokenDailyRateLimitEnabled = boolValue
 		case "StopOnSensitiveEnabled":
@@ -423,14 +582,230 @@ func updateOptionMap(key string, value string) (err error) {
 		setting.TokenRateLimitCount, _ = strconv.Atoi(value)
 	case "TokenRateLimitSuccessCount":
 		setting.TokenRateLimitSuccessCount, _ = strconv.Atoi(value)
+	case "TokenPerIPRateLimit":
+		setting.TokenPerIPRateLimit, _ = strconv.Atoi(value)
+	case "TokenBurstRateLimitCount":
+		setting.TokenBurstRateLimitCount, _ = strconv.Atoi(value)
+	case "TokenBurstDurationSeconds":
+		setting.TokenBurstDurationSeconds, _ = strconv.Atoi(value)
+	case "TokenSustainedRateLimitCount":
+		setting.TokenSustainedRateLimitCount, _ = strconv.Atoi(value)
+	case "PerCustomerRateLimit":
+		setting.PerCustomerRateLimit, _ = strconv.Atoi(value)
+	case "PerCustomerMaxCustomers":
+		setting.PerCustomerMaxCustomers, _ = strconv.Atoi(value)
+	case "Upstream429Penalty":
+		setting.Upstream429Penalty, _ = strconv.Atoi(value)
+	case "RateLimitRedisTimeoutMs":
+		setting.RateLimitRedisTimeoutMs, _ = strconv.Atoi(value)
+	case "RetryGraceCount":
+		setting.RetryGraceCount, _ = strconv.Atoi(value)
+	case "DailyLimitGraceBeforeResetMinutes":
+		setting.DailyLimitGraceBeforeResetMinutes, _ = strconv.Atoi(value)
+	case "DailyLimitOverageAllowance":
+		setting.DailyLimitOverageAllowance, _ = strconv.Atoi(value)
+	case "OrgRateLimitDurationMinutes":
+		setting.OrgRateLimitDurationMinutes, _ = strconv.Atoi(value)
+	case "PlaygroundRateLimitDurationMinutes":
+		setting.PlaygroundRateLimitDurationMinutes, _ = strconv.Atoi(value)
+	case "PlaygroundRateLimitCount":
+		setting.PlaygroundRateLimitCount, _ = strconv.Atoi(value)
+	case "PlaygroundRateLimitSuccessCount":
+		setting.PlaygroundRateLimitSuccessCount, _ = strconv.Atoi(value)
+	case "OrgRateLimitCount":
+		setting.OrgRateLimitCount, _ = strconv.Atoi(value)
+	case "OrgRateLimitSuccessCount":
+		setting.OrgRateLimitSuccessCount, _ = strconv.Atoi(value)
 	case "TokenRateLimitGroup":
 		err = setting.UpdateTokenRateLimitGroupByJSONString(value)
 	case "TokenDailyRateLimitCount":
 		setting.TokenDailyRateLimitCount, _ = strconv.Atoi(value)
 	case "TokenDailyRateLimitSuccessCount":
 		setting.TokenDailyRateLimitSuccessCount, _ = strconv.Atoi(value)
+	case "RateLimitLowPriorityReservePercent":
+		setting.RateLimitLowPriorityReservePercent, _ = strconv.Atoi(value)
+	case "RateLimitBackpressureThresholdPercent":
+		setting.RateLimitBackpressureThresholdPercent, _ = strconv.Atoi(value)
+	case "RateLimitBackpressureMaxDelayMs":
+		setting.RateLimitBackpressureMaxDelayMs, _ = strconv.Atoi(value)
+	case "ChannelAffinityTTLSeconds":
+		setting.ChannelAffinityTTLSeconds, _ = strconv.Atoi(value)
+	case "ChannelProviderThrottlePercent":
+		if err = setting.CheckChannelProviderThrottlePercent(value); err == nil {
+			setting.ChannelProviderThrottlePercent, _ = strconv.Atoi(value)
+		}
+	case "ChannelWarmupSeconds":
+		setting.ChannelWarmupSeconds, _ = strconv.Atoi(value)
+	case "ChannelProviderBackoffMaxSeconds":
+		setting.ChannelProviderBackoffMaxSeconds, _ = strconv.Atoi(value)
+	case "ChannelMaxConcurrency":
+		err = setting.UpdateChannelMaxConcurrencyByJSONString(value)
+	case "ChannelFallbackChains":
+		err = setting.UpdateChannelFallbackChainsByJSONString(value)
+	case "ModelAliasGroups":
+		err = setting.UpdateModelAliasGroupsByJSONString(value)
+	case "ModelRequestTimeout":
+		err = setting.UpdateModelRequestTimeoutByJSONString(value)
+	case "ModelMaxTokensCap":
+		err = setting.UpdateModelMaxTokensCapByJSONString(value)
+	case "GroupForbiddenParams":
+		err = setting.UpdateGroupForbiddenParamsByJSONString(value)
+	case "ModelTimeoutDisableThreshold":
+		setting.ModelTimeoutDisableThreshold, _ = strconv.Atoi(value)
+	case "ChannelMinSuccessRate":
+		if err = setting.CheckChannelMinSuccessRate(value); err == nil {
+			setting.ChannelMinSuccessRate, _ = strconv.ParseFloat(value, 64)
+		}
+	case "ModelMinSuccessRate":
+		if err = setting.CheckModelMinSuccessRate(value); err == nil {
+			setting.ModelMinSuccessRate, _ = strconv.ParseFloat(value, 64)
+		}
+	case "ChannelSuccessRateWindowSeconds":
+		setting.ChannelSuccessRateWindowSeconds, _ = strconv.Atoi(value)
+	case "ChannelSuccessRateMinSamples":
+		setting.ChannelSuccessRateMinSamples, _ = strconv.Atoi(value)
+	case "ChannelPostEnableGraceFailures":
+		setting.ChannelPostEnableGraceFailures, _ = strconv.Atoi(value)
+	case "ChannelFlapThreshold":
+		setting.ChannelFlapThreshold, _ = strconv.Atoi(value)
+	case "ChannelFlapWindowSeconds":
+		setting.ChannelFlapWindowSeconds, _ = strconv.Atoi(value)
+	case "ChannelFlapHoldSeconds":
+		setting.ChannelFlapHoldSeconds, _ = strconv.Atoi(value)
+	case "ChannelDisableOnEmptyAfterN":
+		setting.ChannelDisableOnEmptyAfterN, _ = strconv.Atoi(value)
+	case "SoftDisableWeightFactor":
+		if err = setting.CheckSoftDisableWeightFactor(value); err == nil {
+			setting.SoftDisableWeightFactor, _ = strconv.ParseFloat(value, 64)
+		}
+	case "SoftDisableMinFailures":
+		setting.SoftDisableMinFailures, _ = strconv.Atoi(value)
+	case "RateLimitChannelScalingBaseline":
+		setting.RateLimitChannelScalingBaseline, _ = strconv.Atoi(value)
+	case "RateLimitChannelScalingMaxPercent":
+		setting.RateLimitChannelScalingMaxPercent, _ = strconv.Atoi(value)
+	case "ChannelQuarantineSeconds":
+		setting.ChannelQuarantineSeconds, _ = strconv.Atoi(value)
+	case "ChannelQuarantineErrorThreshold":
+		setting.ChannelQuarantineErrorThreshold, _ = strconv.Atoi(value)
+	case "UserDailyRateLimitCount":
+		setting.UserDailyRateLimitCount, _ = strconv.Atoi(value)
+	case "UserDailyRateLimitSuccessCount":
+		setting.UserDailyRateLimitSuccessCount, _ = strconv.Atoi(value)
+	case "UserDailyRateLimitGroup":
+		err = setting.UpdateUserDailyRateLimitGroupByJSONString(value)
+	case "TokenDailyQuotaCredits":
+		setting.TokenDailyQuotaCredits, _ = strconv.Atoi(value)
+	case "TokenBillingPeriodQuotaCredits":
+		setting.TokenBillingPeriodQuotaCredits, _ = strconv.Atoi(value)
 	case "TokenDailyRateLimitGroup":
 		err = setting.UpdateTokenDailyRateLimitGroupByJSONString(value)
+	case "RateLimitRejectStatusCode":
+		if err = setting.CheckRateLimitRejectStatusCode(value); err == nil {
+			setting.RateLimitRejectStatusCode, _ = strconv.Atoi(value)
+		}
+	case "RateLimitTotalRejectStatusCode":
+		if err = setting.CheckRateLimitKindRejectStatusCode(value); err == nil {
+			setting.RateLimitTotalRejectStatusCode, _ = strconv.Atoi(value)
+		}
+	case "RateLimitSuccessRejectStatusCode":
+		if err = setting.CheckRateLimitKindRejectStatusCode(value); err == nil {
+			setting.RateLimitSuccessRejectStatusCode, _ = strconv.Atoi(value)
+		}
+	case "RateLimitTotalMinRetryAfterSeconds":
+		setting.RateLimitTotalMinRetryAfterSeconds, _ = strconv.Atoi(value)
+	case "RateLimitBlockMaxMs":
+		if err = setting.CheckRateLimitBlockMaxMs(value); err == nil {
+			setting.RateLimitBlockMaxMs, _ = strconv.Atoi(value)
+		}
+	case "RateLimitSuccessExcludeBodyErrors":
+		setting.RateLimitSuccessExcludeBodyErrors = value == "true"
+	case "RateLimitStrictGroup":
+		if err = setting.CheckRateLimitStrictGroup(value); err == nil {
+			setting.RateLimitStrictGroup = value
+		}
+	case "ErrorFormat":
+		if err = setting.CheckErrorFormat(value); err == nil {
+			setting.ErrorFormat = value
+		}
+	case "RateLimitReadReplicaAddr":
+		setting.RateLimitReadReplicaAddr = strings.TrimSpace(value)
+	case "ChannelQueueDepth":
+		setting.ChannelQueueDepth, _ = strconv.Atoi(value)
+	case "ChannelQueueMaxWaitMs":
+		setting.ChannelQueueMaxWaitMs, _ = strconv.Atoi(value)
+	case "RateLimitCountMethods":
+		setting.RateLimitCountMethodsFromString(value)
+	case "TokenQuotaSchedule":
+		err = setting.UpdateTokenQuotaSchedule(value)
+	case "ModelGlobalRateLimit":
+		err = setting.UpdateModelGlobalRateLimitByJSONString(value)
+	case "ModelDailyCap":
+		err = setting.UpdateModelDailyCapByJSONString(value)
+	case "RateLimitRules":
+		err = setting.UpdateRateLimitRulesByJSONString(value)
+	case "RateLimitStreamAccountingTokens":
+		setting.RateLimitStreamAccountingTokens, _ = strconv.Atoi(value)
+	case "TokenRateLimitWindowMode":
+		if err = setting.CheckRateLimitWindowMode(value); err == nil {
+			setting.TokenRateLimitWindowMode = value
+		}
+	case "AlwaysSendRateLimitHeaders":
+		setting.AlwaysSendRateLimitHeaders = value == "true"
+	case "RateLimitResetHeaderFormat":
+		if err = setting.CheckRateLimitResetHeaderFormat(value); err == nil {
+			setting.RateLimitResetHeaderFormat = value
+		}
+	case "SuccessLimiterAlgorithm":
+		if err = setting.CheckSuccessLimiterAlgorithm(value); err == nil {
+			setting.SuccessLimiterAlgorithm = value
+		}
+	case "SuccessLimiterBurstPercent":
+		setting.SuccessLimiterBurstPercent, _ = strconv.Atoi(value)
+	case "ExemptAdminFromRateLimit":
+		setting.ExemptAdminFromRateLimit = value == "true"
+	case "ExposeChannelInError":
+		setting.ExposeChannelInError = value == "true"
+	case "EmergencyDisableAllRelays":
+		enabled := value == "true"
+		if enabled != setting.EmergencyDisableAllRelays {
+			if enabled {
+				common.SysError("EMERGENCY: all relay traffic is now disabled for non-admin users")
+			} else {
+				common.SysError("EMERGENCY: relay traffic has been re-enabled")
+			}
+		}
+		setting.EmergencyDisableAllRelays = enabled
+	case "EmergencyDisableMessage":
+		setting.EmergencyDisableMessage = value
+	case "RateLimitGroupCaseInsensitive":
+		setting.RateLimitGroupCaseInsensitive = value == "true"
+	case "RejectUnparseableRequests":
+		setting.RejectUnparseableRequests = value == "true"
+	case "EnableTracing":
+		setting.EnableTracing = value == "true"
+	case "RateLimitDedupWindowMs":
+		setting.RateLimitDedupWindowMs, _ = strconv.Atoi(value)
+	case "RateLimitDedupMaxForgiven":
+		setting.RateLimitDedupMaxForgiven, _ = strconv.Atoi(value)
+	case "RateLimitIdempotencyWindowSeconds":
+		setting.RateLimitIdempotencyWindowSeconds, _ = strconv.Atoi(value)
+	case "RateLimitSandboxCount":
+		setting.RateLimitSandboxCount, _ = strconv.Atoi(value)
+	case "RateLimitSandboxDurationSeconds":
+		if err = setting.CheckRateLimitSandboxDurationSeconds(value); err == nil {
+			setting.RateLimitSandboxDurationSeconds, _ = strconv.Atoi(value)
+		}
+	case "RateLimitKeySweepIntervalSeconds":
+		setting.RateLimitKeySweepIntervalSeconds, _ = strconv.Atoi(value)
+	case "RateLimitMaxKeys":
+		setting.RateLimitMaxKeys, _ = strconv.Atoi(value)
+	case "RateLimitEvictMinIdleSeconds":
+		setting.RateLimitEvictMinIdleSeconds, _ = strconv.Atoi(value)
+	case "ShadowRateLimitAlgorithm":
+		if err = setting.CheckShadowRateLimitAlgorithm(value); err == nil {
+			setting.ShadowRateLimitAlgorithm = value
+		}
 	case "RetryTimes":
 		common.RetryTimes, _ = strconv.Atoi(value)
 	case "DataExportInterval":
@@ -471,6 +846,10 @@ func updateOptionMap(key string, value string) (err error) {
 		setting.SensitiveWordsFromString(value)
 	case "AutomaticDisableKeywords":
 		operation_setting.AutomaticDisableKeywordsFromString(value)
+	case "ChannelDisableErrorCodes":
+		setting.ChannelDisableErrorCodesFromString(value)
+	case "ChannelDisableRegexByType":
+		err = setting.UpdateChannelDisableRegexByTypeByJSONString(value)
 	case "StreamCacheQueueLength":
 		setting.StreamCacheQueueLength, _ = strconv.Atoi(value)
 	case "PayMethods":
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"github.com/QuantumNous/new-api/common"
+)
+
+// RateLimitAudit 限流配置变更的审计记录：谁在什么时候把哪一项从什么值改为什么值
+type RateLimitAudit struct {
+	Id        int    `json:"id"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint;index"`
+	ActorId   int    `json:"actor_id" gorm:"index"`
+	ActorName string `json:"actor_name" gorm:"default:''"`
+	Source    string `json:"source" gorm:"type:varchar(32);default:''"` // option：单项修改，import：导入配置
+	OptionKey string `json:"option_key" gorm:"type:varchar(128);index"`
+	OldValue  string `json:"old_value" gorm:"type:text"`
+	NewValue  string `json:"new_value" gorm:"type:text"`
+}
+
+// 限流配置审计记录的来源
+const (
+	RateLimitAuditSourceOption = "option"
+	RateLimitAuditSourceImport = "import"
+)
+
+// RecordRateLimitAudit 写入一条限流配置审计记录
+func RecordRateLimitAudit(audit *RateLimitAudit) error {
+	if audit.CreatedAt == 0 {
+		audit.CreatedAt = common.GetTimestamp()
+	}
+	return DB.Create(audit).Error
+}
+
+// GetRateLimitAudits 按时间倒序分页查询限流配置审计记录，optionKey 为空时查询全部配置项
+func GetRateLimitAudits(optionKey string, startIdx int, num int) (audits []*RateLimitAudit, total int64, err error) {
+	tx := DB.Model(&RateLimitAudit{})
+	if optionKey != "" {
+		tx = tx.Where("option_key = ?", optionKey)
+	}
+	if err = tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	err = tx.Order("id desc").Limit(num).Offset(startIdx).Find(&audits).Error
+	return audits, total, err
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 组合限流规则中按 token 数计数的规则在当前窗口内已消耗的 token 数，请求完成后累加
+type rateLimitRuleTokenEntry struct {
+	start int64
+	reset int64
+	used  int
+}
+
+var (
+	rateLimitRuleTokenMutex  sync.Mutex
+	rateLimitRuleTokenMemory = map[string]*rateLimitRuleTokenEntry{}
+)
+
+func rateLimitRuleWindow(rule setting.RateLimitRule, now time.Time) setting.QuotaWindow {
+	return setting.FixedWindow(now, time.Duration(rule.WindowSeconds)*time.Second)
+}
+
+func rateLimitRuleTokenKey(key string, window setting.QuotaWindow) string {
+	return key + ":" + strconv.FormatInt(window.Start.Unix(), 10)
+}
+
+// IncreaseRateLimitRuleTokens 将本次请求消耗的 token 数累加到所有生效的按 token 数计数的组合限流规则中
+func IncreaseRateLimitRuleTokens(tokenId int, userId int, ip string, group string, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	now := time.Now()
+	for _, rule := range setting.GetRateLimitRules() {
+		if rule.Metric != setting.RateLimitRuleMetricTokens || !rule.AppliesToGroup(group) {
+			continue
+		}
+		subject := rule.Subject(tokenId, userId, ip)
+		if subject == "" {
+			continue
+		}
+		window := rateLimitRuleWindow(rule, now)
+		key := rule.CounterKey(subject)
+		if common.RedisEnabled() {
+			ctx := context.Background()
+			counterKey := rateLimitRuleTokenKey(key, window)
+			pipe := common.RDB().TxPipeline()
+			pipe.IncrBy(ctx, counterKey, int64(tokens))
+			pipe.ExpireAt(ctx, counterKey, window.Reset)
+			if _, err := pipe.Exec(ctx); err != nil {
+				common.SysLog(fmt.Sprintf("failed to increase tokens of rate limit rule %s: %s", rule.Name, err.Error()))
+			}
+			continue
+		}
+		rateLimitRuleTokenMutex.Lock()
+		entry, ok := rateLimitRuleTokenMemory[key]
+		if !ok || entry.start != window.Start.Unix() {
+			// 记录较多时顺带清理窗口已结束的计数，避免内存无限增长
+			if len(rateLimitRuleTokenMemory) >= 1024 {
+				for k, e := range rateLimitRuleTokenMemory {
+					if e.reset <= now.Unix() {
+						delete(rateLimitRuleTokenMemory, k)
+					}
+				}
+			}
+			entry = &rateLimitRuleTokenEntry{start: window.Start.Unix(), reset: window.Reset.Unix()}
+			rateLimitRuleTokenMemory[key] = entry
+		}
+		entry.used += tokens
+		rateLimitRuleTokenMutex.Unlock()
+	}
+}
+
+// GetRateLimitRuleTokens 返回规则在 subject 上当前窗口内已消耗的 token 数及窗口
+func GetRateLimitRuleTokens(rule setting.RateLimitRule, subject string) (int, setting.QuotaWindow, error) {
+	window := rateLimitRuleWindow(rule, time.Now())
+	key := rule.CounterKey(subject)
+	if common.RedisEnabled() {
+		used, err := common.RDB().Get(context.Background(), rateLimitRuleTokenKey(key, window)).Int()
+		if errors.Is(err, redis.Nil) {
+			return 0, window, nil
+		}
+		if err != nil {
+			return 0, window, err
+		}
+		return used, window, nil
+	}
+	rateLimitRuleTokenMutex.Lock()
+	defer rateLimitRuleTokenMutex.Unlock()
+	entry, ok := rateLimitRuleTokenMemory[key]
+	if !ok || entry.start != window.Start.Unix() {
+		return 0, window, nil
+	}
+	return entry.used, window, nil
+}
+
+// ExceededRateLimitRuleTokens 返回第一条已用完 token 额度的按 token 数计数的组合限流规则
+func ExceededRateLimitRuleTokens(tokenId int, userId int, ip string, group string) (setting.RateLimitRule, bool) {
+	for _, rule := range setting.GetRateLimitRules() {
+		if rule.Metric != setting.RateLimitRuleMetricTokens || !rule.AppliesToGroup(group) {
+			continue
+		}
+		subject := rule.Subject(tokenId, userId, ip)
+		if subject == "" {
+			continue
+		}
+		used, _, err := GetRateLimitRuleTokens(rule, subject)
+		if err != nil {
+			common.SysLog(fmt.Sprintf("failed to get tokens of rate limit rule %s: %s", rule.Name, err.Error()))
+			continue
+		}
+		if used >= rule.Limit {
+			return rule, true
+		}
+	}
+	return setting.RateLimitRule{}, false
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/bytedance/gopkg/util/gopool"
@@ -26,7 +27,11 @@ type Token struct {
 	AllowIps           *string        `json:"allow_ips" gorm:"default:''"`
 	UsedQuota          int            `json:"used_quota" gorm:"default:0"` // used quota
 	Group              string         `json:"group" gorm:"default:''"`
-	CrossGroupRetry    bool           `json:"cross_group_retry" gorm:"default:false"` // 跨分组重试，仅auto分组有效
+	CrossGroupRetry    bool           `json:"cross_group_retry" gorm:"default:false"`                  // 跨分组重试，仅auto分组有效
+	OrgId              int            `json:"org_id" gorm:"default:0;index"`                           // 所属组织/团队，同一组织的令牌共享组织级限流，由管理员设置
+	RateLimitAlgorithm string         `json:"rate_limit_algorithm" gorm:"type:varchar(32);default:''"` // 成功请求数限制使用的算法，为空时使用全局设置，由管理员设置
+	CustomerIds        string         `json:"customer_ids" gorm:"type:text"`                           // 允许通过 X-Customer-Id 传入的终端客户 ID，逗号或换行分隔，为空时不限制，由管理员设置
+	BillingAnchorDay   int            `json:"billing_anchor_day" gorm:"default:0"`                     // 账单日（1~31），按额度的账单周期限制从每月该日开始，0 表示取创建当天的日期，由管理员设置
 	DeletedAt          gorm.DeletedAt `gorm:"index"`
 }
 
@@ -88,7 +93,7 @@ func ValidateUserToken(key string) (token *Token, err error) {
 			return token, errors.New("该令牌状态不可用")
 		}
 		if token.ExpiredTime != -1 && token.ExpiredTime < common.GetTimestamp() {
-			if !common.RedisEnabled {
+			if !common.RedisEnabled() {
 				token.Status = common.TokenStatusExpired
 				err := token.SelectUpdate()
 				if err != nil {
@@ -98,7 +103,7 @@ func ValidateUserToken(key string) (token *Token, err error) {
 			return token, errors.New("该令牌已过期")
 		}
 		if !token.UnlimitedQuota && token.RemainQuota <= 0 {
-			if !common.RedisEnabled {
+			if !common.RedisEnabled() {
 				// in this case, we can make sure the token is exhausted
 				token.Status = common.TokenStatusExhausted
 				err := token.SelectUpdate()
@@ -153,7 +158,7 @@ func GetTokenByKey(key string, fromDB bool) (token *Token, err error) {
 			})
 		}
 	}()
-	if !fromDB && common.RedisEnabled {
+	if !fromDB && common.RedisEnabled() {
 		// Try Redis first
 		token, err := cacheGetTokenByKey(key)
 		if err == nil {
@@ -172,6 +177,96 @@ func (token *Token) Insert() error {
 	return err
 }
 
+// UpdateOrgId 修改令牌所属的组织/团队，0 表示不属于任何组织
+func (token *Token) UpdateOrgId(orgId int) (err error) {
+	defer func() {
+		if shouldUpdateRedis(true, err) {
+			gopool.Go(func() {
+				err := cacheSetToken(*token)
+				if err != nil {
+					common.SysLog("failed to update token cache: " + err.Error())
+				}
+			})
+		}
+	}()
+	token.OrgId = orgId
+	err = DB.Model(token).Select("org_id").Updates(token).Error
+	return err
+}
+
+// UpdateRateLimitAlgorithm 修改令牌使用的限流算法，空字符串表示跟随全局设置
+func (token *Token) UpdateRateLimitAlgorithm(algorithm string) (err error) {
+	defer func() {
+		if shouldUpdateRedis(true, err) {
+			gopool.Go(func() {
+				err := cacheSetToken(*token)
+				if err != nil {
+					common.SysLog("failed to update token cache: " + err.Error())
+				}
+			})
+		}
+	}()
+	token.RateLimitAlgorithm = algorithm
+	err = DB.Model(token).Select("rate_limit_algorithm").Updates(token).Error
+	return err
+}
+
+// ParseTokenCustomerIds 解析令牌允许的终端客户 ID 列表，未配置时返回空
+func ParseTokenCustomerIds(customerIds string) map[string]struct{} {
+	allowed := make(map[string]struct{})
+	for _, id := range strings.FieldsFunc(customerIds, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r' || r == ' '
+	}) {
+		allowed[id] = struct{}{}
+	}
+	return allowed
+}
+
+// UpdateCustomerIds 修改令牌允许的终端客户 ID 列表，空字符串表示不限制
+func (token *Token) UpdateCustomerIds(customerIds string) (err error) {
+	defer func() {
+		if shouldUpdateRedis(true, err) {
+			gopool.Go(func() {
+				err := cacheSetToken(*token)
+				if err != nil {
+					common.SysLog("failed to update token cache: " + err.Error())
+				}
+			})
+		}
+	}()
+	token.CustomerIds = customerIds
+	err = DB.Model(token).Select("customer_ids").Updates(token).Error
+	return err
+}
+
+// GetBillingAnchorDay 返回令牌的账单日，未设置时取令牌创建当天的日期
+func (token *Token) GetBillingAnchorDay() int {
+	if token.BillingAnchorDay > 0 {
+		return token.BillingAnchorDay
+	}
+	if token.CreatedTime > 0 {
+		return time.Unix(token.CreatedTime, 0).Day()
+	}
+	return 1
+}
+
+// UpdateBillingAnchorDay 修改令牌的账单日，0 表示取创建当天的日期
+func (token *Token) UpdateBillingAnchorDay(day int) (err error) {
+	defer func() {
+		if shouldUpdateRedis(true, err) {
+			gopool.Go(func() {
+				err := cacheSetToken(*token)
+				if err != nil {
+					common.SysLog("failed to update token cache: " + err.Error())
+				}
+			})
+		}
+	}()
+	token.BillingAnchorDay = day
+	err = DB.Model(token).Select("billing_anchor_day").Updates(token).Error
+	return err
+}
+
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (token *Token) Update() (err error) {
 	defer func() {
@@ -266,7 +361,7 @@ func IncreaseTokenQuota(id int, key string, quota int) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
-	if common.RedisEnabled {
+	if common.RedisEnabled() {
 		gopool.Go(func() {
 			err := cacheIncrTokenQuota(key, int64(quota))
 			if err != nil {
@@ -296,7 +391,7 @@ func DecreaseTokenQuota(id int, key string, quota int) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
 	}
-	if common.RedisEnabled {
+	if common.RedisEnabled() {
 		gopool.Go(func() {
 			err := cacheDecrTokenQuota(key, int64(quota))
 			if err != nil {
@@ -322,6 +417,32 @@ func decreaseTokenQuota(id int, quota int) (err error) {
 	return err
 }
 
+// GetEnabledTokenIdsByGroup 返回指定分组下所有启用中的令牌 ID
+func GetEnabledTokenIdsByGroup(group string) ([]int, error) {
+	var ids []int
+	err := DB.Model(&Token{}).Where(commonGroupCol+" = ? and status = ?", group, common.TokenStatusEnabled).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// GetTokensBasicByIds 批量查询令牌的 ID、所属用户、名称与分组，不包含密钥
+func GetTokensBasicByIds(ids []int) ([]*Token, error) {
+	const batchSize = 500
+	tokens := make([]*Token, 0, len(ids))
+	for start := 0; start < len(ids); start += batchSize {
+		end := start + batchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		var batch []*Token
+		err := DB.Select("id", "user_id", "name", commonGroupCol).Where("id IN ?", ids[start:end]).Find(&batch).Error
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, batch...)
+	}
+	return tokens, nil
+}
+
 // CountUserTokens returns total number of tokens for the given user, used for pagination
 func CountUserTokens(userId int) (int64, error) {
 	var total int64
@@ -352,7 +473,7 @@ func BatchDeleteTokens(ids []int, userId int) (int, error) {
 		return 0, err
 	}
 
-	if common.RedisEnabled {
+	if common.RedisEnabled() {
 		gopool.Go(func() {
 			for _, t := range tokens {
 				_ = cacheDeleteToken(t.Key)
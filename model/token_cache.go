@@ -52,7 +52,7 @@ func cacheSetTokenField(key string, field string, value string) error {
 // CacheGetTokenByKey 从缓存中获取 token，如果缓存中不存在，则从数据库中获取
 func cacheGetTokenByKey(key string) (*Token, error) {
 	hmacKey := common.GenerateHMAC(key)
-	if !common.RedisEnabled {
+	if !common.RedisEnabled() {
 		return nil, fmt.Errorf("redis is not enabled")
 	}
 	var token Token
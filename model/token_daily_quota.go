@@ -0,0 +1,85 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 令牌每日已消耗额度（按自然日统计），用于按额度的每日限制
+const tokenDailyQuotaKeyPrefix = "rateLimit:TDQ"
+
+type tokenDailyQuotaEntry struct {
+	day  string
+	used int
+}
+
+var (
+	tokenDailyQuotaMutex  sync.Mutex
+	tokenDailyQuotaMemory = map[int]*tokenDailyQuotaEntry{}
+)
+
+func tokenDailyQuotaDay() string {
+	return time.Now().Format("20060102")
+}
+
+func tokenDailyQuotaKey(tokenId int, day string) string {
+	return fmt.Sprintf("%s:%d:%s", tokenDailyQuotaKeyPrefix, tokenId, day)
+}
+
+// IncreaseTokenDailyQuotaUsed 累加令牌当日已消耗的额度，未开启按额度的每日限制时不做任何事
+func IncreaseTokenDailyQuotaUsed(tokenId int, quota int) {
+	if setting.TokenDailyQuotaCredits <= 0 || tokenId <= 0 || quota <= 0 {
+		return
+	}
+	day := tokenDailyQuotaDay()
+	if common.RedisEnabled() {
+		ctx := context.Background()
+		key := tokenDailyQuotaKey(tokenId, day)
+		pipe := common.RDB().TxPipeline()
+		pipe.IncrBy(ctx, key, int64(quota))
+		// 保留两天，避免跨日时刚写入的计数立即过期
+		pipe.Expire(ctx, key, 48*time.Hour)
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.SysLog(fmt.Sprintf("failed to increase daily quota used of token %d: %s", tokenId, err.Error()))
+		}
+		return
+	}
+	tokenDailyQuotaMutex.Lock()
+	defer tokenDailyQuotaMutex.Unlock()
+	entry, ok := tokenDailyQuotaMemory[tokenId]
+	if !ok || entry.day != day {
+		entry = &tokenDailyQuotaEntry{day: day}
+		tokenDailyQuotaMemory[tokenId] = entry
+	}
+	entry.used += quota
+}
+
+// GetTokenDailyQuotaUsed 获取令牌当日已消耗的额度
+func GetTokenDailyQuotaUsed(tokenId int) (int, error) {
+	day := tokenDailyQuotaDay()
+	if common.RedisEnabled() {
+		used, err := common.RDB().Get(context.Background(), tokenDailyQuotaKey(tokenId, day)).Int()
+		if errors.Is(err, redis.Nil) {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		return used, nil
+	}
+	tokenDailyQuotaMutex.Lock()
+	defer tokenDailyQuotaMutex.Unlock()
+	entry, ok := tokenDailyQuotaMemory[tokenId]
+	if !ok || entry.day != day {
+		return 0, nil
+	}
+	return entry.used, nil
+}
@@ -0,0 +1,52 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func TestTokenDailyQuotaAccumulates(t *testing.T) {
+	common.SetRedisEnabled(false)
+	setting.TokenDailyQuotaCredits = 1000
+	t.Cleanup(func() { setting.TokenDailyQuotaCredits = 0 })
+
+	IncreaseTokenDailyQuotaUsed(1081, 300)
+	IncreaseTokenDailyQuotaUsed(1081, 500)
+	IncreaseTokenDailyQuotaUsed(1081, 0)
+	IncreaseTokenDailyQuotaUsed(1082, 100)
+	if used, err := GetTokenDailyQuotaUsed(1081); err != nil || used != 800 {
+		t.Fatalf("used = %d, %v, want 800", used, err)
+	}
+	if used, _ := GetTokenDailyQuotaUsed(1082); used != 100 {
+		t.Fatalf("other token used = %d, want 100", used)
+	}
+}
+
+func TestTokenDailyQuotaResetsNextDay(t *testing.T) {
+	common.SetRedisEnabled(false)
+	setting.TokenDailyQuotaCredits = 1000
+	t.Cleanup(func() { setting.TokenDailyQuotaCredits = 0 })
+
+	tokenDailyQuotaMutex.Lock()
+	tokenDailyQuotaMemory[1083] = &tokenDailyQuotaEntry{day: "19700101", used: 900}
+	tokenDailyQuotaMutex.Unlock()
+	if used, _ := GetTokenDailyQuotaUsed(1083); used != 0 {
+		t.Fatalf("used from previous day = %d, want 0", used)
+	}
+	IncreaseTokenDailyQuotaUsed(1083, 50)
+	if used, _ := GetTokenDailyQuotaUsed(1083); used != 50 {
+		t.Fatalf("used = %d, want 50", used)
+	}
+}
+
+func TestTokenDailyQuotaDisabledDoesNotCount(t *testing.T) {
+	common.SetRedisEnabled(false)
+	setting.TokenDailyQuotaCredits = 0
+
+	IncreaseTokenDailyQuotaUsed(1084, 300)
+	if used, _ := GetTokenDailyQuotaUsed(1084); used != 0 {
+		t.Fatalf("used = %d, want 0 when daily quota is disabled", used)
+	}
+}
@@ -0,0 +1,83 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 令牌在当前账单周期内已消耗的额度，用于按额度的账单周期限制
+const tokenPeriodQuotaKeyPrefix = "rateLimit:TBQ"
+
+type tokenPeriodQuotaEntry struct {
+	start string
+	used  int
+}
+
+var (
+	tokenPeriodQuotaMutex  sync.Mutex
+	tokenPeriodQuotaMemory = map[int]*tokenPeriodQuotaEntry{}
+)
+
+func tokenPeriodQuotaKey(tokenId int, start string) string {
+	return fmt.Sprintf("%s:%d:%s", tokenPeriodQuotaKeyPrefix, tokenId, start)
+}
+
+// IncreaseTokenPeriodQuotaUsed 累加令牌当前账单周期已消耗的额度，未开启按额度的账单周期限制时不做任何事
+func IncreaseTokenPeriodQuotaUsed(tokenId int, anchorDay int, quota int) {
+	if setting.TokenBillingPeriodQuotaCredits <= 0 || tokenId <= 0 || quota <= 0 {
+		return
+	}
+	window := setting.BillingPeriodWindow(anchorDay, time.Now())
+	start := window.Start.Format("20060102")
+	if common.RedisEnabled() {
+		ctx := context.Background()
+		key := tokenPeriodQuotaKey(tokenId, start)
+		pipe := common.RDB().TxPipeline()
+		pipe.IncrBy(ctx, key, int64(quota))
+		// 周期结束后多保留一天，避免跨周期时刚写入的计数立即过期
+		pipe.ExpireAt(ctx, key, window.Reset.Add(24*time.Hour))
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.SysLog(fmt.Sprintf("failed to increase billing period quota used of token %d: %s", tokenId, err.Error()))
+		}
+		return
+	}
+	tokenPeriodQuotaMutex.Lock()
+	defer tokenPeriodQuotaMutex.Unlock()
+	entry, ok := tokenPeriodQuotaMemory[tokenId]
+	if !ok || entry.start != start {
+		entry = &tokenPeriodQuotaEntry{start: start}
+		tokenPeriodQuotaMemory[tokenId] = entry
+	}
+	entry.used += quota
+}
+
+// GetTokenPeriodQuotaUsed 获取令牌当前账单周期已消耗的额度及周期的起止时间
+func GetTokenPeriodQuotaUsed(tokenId int, anchorDay int) (int, setting.QuotaWindow, error) {
+	window := setting.BillingPeriodWindow(anchorDay, time.Now())
+	start := window.Start.Format("20060102")
+	if common.RedisEnabled() {
+		used, err := common.RDB().Get(context.Background(), tokenPeriodQuotaKey(tokenId, start)).Int()
+		if errors.Is(err, redis.Nil) {
+			return 0, window, nil
+		}
+		if err != nil {
+			return 0, window, err
+		}
+		return used, window, nil
+	}
+	tokenPeriodQuotaMutex.Lock()
+	defer tokenPeriodQuotaMutex.Unlock()
+	entry, ok := tokenPeriodQuotaMemory[tokenId]
+	if !ok || entry.start != start {
+		return 0, window, nil
+	}
+	return entry.used, window, nil
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func TestTokenPeriodQuotaAccumulates(t *testing.T) {
+	common.SetRedisEnabled(false)
+	setting.TokenBillingPeriodQuotaCredits = 1000
+	t.Cleanup(func() { setting.TokenBillingPeriodQuotaCredits = 0 })
+
+	IncreaseTokenPeriodQuotaUsed(1691, 15, 300)
+	IncreaseTokenPeriodQuotaUsed(1691, 15, 500)
+	IncreaseTokenPeriodQuotaUsed(1691, 15, 0)
+	IncreaseTokenPeriodQuotaUsed(1692, 15, 100)
+	used, window, err := GetTokenPeriodQuotaUsed(1691, 15)
+	if err != nil || used != 800 {
+		t.Fatalf("used = %d, %v, want 800", used, err)
+	}
+	if want := setting.BillingPeriodWindow(15, time.Now()); !window.Start.Equal(want.Start) || !window.Reset.Equal(want.Reset) {
+		t.Fatalf("window = %+v, want %+v", window, want)
+	}
+	if used, _, _ := GetTokenPeriodQuotaUsed(1692, 15); used != 100 {
+		t.Fatalf("other token used = %d, want 100", used)
+	}
+}
+
+func TestTokenPeriodQuotaResetsNextPeriod(t *testing.T) {
+	common.SetRedisEnabled(false)
+	setting.TokenBillingPeriodQuotaCredits = 1000
+	t.Cleanup(func() { setting.TokenBillingPeriodQuotaCredits = 0 })
+
+	tokenPeriodQuotaMutex.Lock()
+	tokenPeriodQuotaMemory[1693] = &tokenPeriodQuotaEntry{start: "19700101", used: 900}
+	tokenPeriodQuotaMutex.Unlock()
+	if used, _, _ := GetTokenPeriodQuotaUsed(1693, 1); used != 0 {
+		t.Fatalf("used from previous period = %d, want 0", used)
+	}
+	IncreaseTokenPeriodQuotaUsed(1693, 1, 50)
+	if used, _, _ := GetTokenPeriodQuotaUsed(1693, 1); used != 50 {
+		t.Fatalf("used = %d, want 50", used)
+	}
+}
+
+func TestTokenPeriodQuotaDisabledDoesNotCount(t *testing.T) {
+	common.SetRedisEnabled(false)
+	setting.TokenBillingPeriodQuotaCredits = 0
+
+	IncreaseTokenPeriodQuotaUsed(1694, 1, 300)
+	if used, _, _ := GetTokenPeriodQuotaUsed(1694, 1); used != 0 {
+		t.Fatalf("used = %d, want 0 when billing period quota is disabled", used)
+	}
+}
+
+func TestTokenGetBillingAnchorDay(t *testing.T) {
+	created := time.Date(2026, 1, 31, 12, 0, 0, 0, time.Local).Unix()
+	cases := []struct {
+		token Token
+		want  int
+	}{
+		{Token{BillingAnchorDay: 15, CreatedTime: created}, 15},
+		// 未设置账单日时取创建当天的日期
+		{Token{CreatedTime: created}, 31},
+		{Token{}, 1},
+	}
+	for _, tc := range cases {
+		if got := tc.token.GetBillingAnchorDay(); got != tc.want {
+			t.Errorf("GetBillingAnchorDay(%+v) = %d, want %d", tc.token, got, tc.want)
+		}
+	}
+}
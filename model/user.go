@@ -58,6 +58,7 @@ func (user *User) ToBaseUser() *UserBase {
 		Username: user.Username,
 		Setting:  user.Setting,
 		Email:    user.Email,
+		Role:     user.Role,
 	}
 	return cache
 }
@@ -678,7 +679,7 @@ func GetUserQuota(id int, fromDB bool) (quota int, err error) {
 			})
 		}
 	}()
-	if !fromDB && common.RedisEnabled {
+	if !fromDB && common.RedisEnabled() {
 		quota, err := getUserQuotaCache(id)
 		if err == nil {
 			return quota, nil
@@ -716,7 +717,7 @@ func GetUserGroup(id int, fromDB bool) (group string, err error) {
 			})
 		}
 	}()
-	if !fromDB && common.RedisEnabled {
+	if !fromDB && common.RedisEnabled() {
 		group, err := getUserGroupCache(id)
 		if err == nil {
 			return group, nil
@@ -745,7 +746,7 @@ func GetUserSetting(id int, fromDB bool) (settingMap dto.UserSetting, err error)
 			})
 		}
 	}()
-	if !fromDB && common.RedisEnabled {
+	if !fromDB && common.RedisEnabled() {
 		setting, err := getUserSettingCache(id)
 		if err == nil {
 			return setting, nil
@@ -891,7 +892,7 @@ func GetUsernameById(id int, fromDB bool) (username string, err error) {
 			})
 		}
 	}()
-	if !fromDB && common.RedisEnabled {
+	if !fromDB && common.RedisEnabled() {
 		username, err := getUserNameCache(id)
 		if err == nil {
 			return username, nil
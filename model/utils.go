@@ -108,5 +108,5 @@ func RecordExist(err error) (bool, error) {
 }
 
 func shouldUpdateRedis(fromDB bool, err error) bool {
-	return common.RedisEnabled && fromDB && err == nil
+	return common.RedisEnabled() && fromDB && err == nil
 }
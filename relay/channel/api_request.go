@@ -11,6 +11,7 @@ import (
 	"time"
 
 	common2 "github.com/QuantumNous/new-api/common"
+	constant2 "github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/relay/common"
 	"github.com/QuantumNous/new-api/relay/constant"
@@ -253,6 +254,9 @@ func DoRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	return doRequest(c, req, info)
 }
 func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http.Response, error) {
+	if info.UpstreamCtx != nil {
+		req = req.WithContext(info.UpstreamCtx)
+	}
 	var client *http.Client
 	var err error
 	if info.ChannelSetting.Proxy != "" {
@@ -292,6 +296,11 @@ func doRequest(c *gin.Context, req *http.Request, info *common.RelayInfo) (*http
 	if resp == nil {
 		return nil, errors.New("resp is nil")
 	}
+	common2.SetContextKey(c, constant2.ContextKeyUpstreamResponded, true)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		common2.SetContextKey(c, constant2.ContextKeyUpstreamRateLimited, true)
+	}
+	service.ObserveProviderRateLimit(info.ChannelId, resp)
 
 	_ = req.Body.Close()
 	_ = c.Request.Body.Close()
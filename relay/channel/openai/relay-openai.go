@@ -228,6 +228,12 @@ func OpenaiHandler(c *gin.Context, info *relaycommon.RelayInfo, resp *http.Respo
 	if oaiError := simpleResponse.GetOpenAIError(); oaiError != nil && oaiError.Type != "" {
 		return nil, types.WithOpenAIError(*oaiError, resp.StatusCode)
 	}
+	for _, choice := range simpleResponse.Choices {
+		if choice.FinishReason == "content_filter" {
+			common.SetContextKey(c, constant.ContextKeyResponseBodyError, true)
+			break
+		}
+	}
 
 	forceFormat := false
 	if info.ChannelSetting.ForceFormat {
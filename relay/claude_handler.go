@@ -161,6 +161,7 @@ func ClaudeHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 		return newAPIError
 	}
 
+	markEmptyResponse(c, usage.(*dto.Usage))
 	service.PostClaudeConsumeQuota(c, info, usage.(*dto.Usage))
 	return nil
 }
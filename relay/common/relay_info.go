@@ -1,6 +1,7 @@
 package common
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -116,6 +117,9 @@ type RelayInfo struct {
 	FinalPreConsumedQuota  int  // 最终预消耗的配额
 	IsClaudeBetaQuery      bool // /v1/messages?beta=true
 
+	// 非空时发往上游的请求使用该 context，用于按模型配置的请求超时
+	UpstreamCtx context.Context
+
 	PriceData types.PriceData
 
 	Request dto.Request
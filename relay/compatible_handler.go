@@ -184,11 +184,17 @@ func TextHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *types
 	if usage.(*dto.Usage).CompletionTokenDetails.AudioTokens > 0 || usage.(*dto.Usage).PromptTokensDetails.AudioTokens > 0 {
 		service.PostAudioConsumeQuota(c, info, usage.(*dto.Usage), "")
 	} else {
+		markEmptyResponse(c, usage.(*dto.Usage))
 		postConsumeQuota(c, info, usage.(*dto.Usage), "")
 	}
 	return nil
 }
 
+// markEmptyResponse 记录上游是否返回了空回复（没有任何输出 token），供按连续空回复次数禁用渠道
+func markEmptyResponse(c *gin.Context, usage *dto.Usage) {
+	common.SetContextKey(c, constant.ContextKeyEmptyResponse, usage == nil || usage.CompletionTokens == 0)
+}
+
 func postConsumeQuota(ctx *gin.Context, relayInfo *relaycommon.RelayInfo, usage *dto.Usage, extraContent string) {
 	if usage == nil {
 		usage = &dto.Usage{
@@ -193,6 +193,7 @@ func GeminiHelper(c *gin.Context, info *relaycommon.RelayInfo) (newAPIError *typ
 		return openaiErr
 	}
 
+	markEmptyResponse(c, usage.(*dto.Usage))
 	postConsumeQuota(c, info, usage.(*dto.Usage), "")
 	return nil
 }
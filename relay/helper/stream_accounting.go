@@ -0,0 +1,74 @@
+package helper
+
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamTokenAccountant 在流式响应过程中分批将输出计入按 token 数计数的组合限流规则，
+// 很长的流在用完额度时即被中断，而不是等到结束后才计入
+type streamTokenAccountant struct {
+	c        *gin.Context
+	info     *relaycommon.RelayInfo
+	group    string
+	ip       string
+	interval int
+	pending  int
+	counted  int
+}
+
+// newStreamTokenAccountant 未开启流式计数或没有按 token 数计数的规则时返回 nil
+func newStreamTokenAccountant(c *gin.Context, info *relaycommon.RelayInfo) *streamTokenAccountant {
+	if setting.RateLimitStreamAccountingTokens <= 0 || !setting.HasTokenRateLimitRules() {
+		return nil
+	}
+	return &streamTokenAccountant{
+		c:        c,
+		info:     info,
+		group:    common.GetContextKeyString(c, constant.ContextKeyTokenGroup),
+		ip:       c.ClientIP(),
+		interval: setting.RateLimitStreamAccountingTokens,
+	}
+}
+
+// add 记录输出了一个数据块（约一个 token），累计达到计数间隔时计入限流规则，额度已用完时返回触发的规则
+func (a *streamTokenAccountant) add() (setting.RateLimitRule, bool) {
+	a.pending++
+	if a.pending < a.interval {
+		return setting.RateLimitRule{}, false
+	}
+	tokens := a.pending
+	if a.counted == 0 {
+		// 第一次计入时一并计入输入部分
+		tokens += a.info.GetEstimatePromptTokens()
+	}
+	a.pending = 0
+	model.IncreaseRateLimitRuleTokens(a.info.TokenId, a.info.UserId, a.ip, a.group, tokens)
+	a.counted += tokens
+	common.SetContextKey(a.c, constant.ContextKeyRateLimitStreamTokens, a.counted)
+	return model.ExceededRateLimitRuleTokens(a.info.TokenId, a.info.UserId, a.ip, a.group)
+}
+
+// writeStreamRateLimitError 在流中写入限流错误，客户端据此得知响应被截断的原因
+func writeStreamRateLimitError(c *gin.Context, info *relaycommon.RelayInfo, rule setting.RateLimitRule) {
+	message := fmt.Sprintf("已达到限流规则 %s 的 token 数限制，响应已中断", rule.Name)
+	if info.RelayFormat == types.RelayFormatClaude {
+		_ = ClaudeData(c, dto.ClaudeResponse{
+			Type:  "error",
+			Error: &types.ClaudeError{Type: "rate_limit_error", Message: message},
+		})
+		return
+	}
+	_ = ObjectData(c, gin.H{
+		"error": types.OpenAIError{Message: message, Type: "rate_limit_error", Code: "rate_limit_exceeded"},
+	})
+}
@@ -0,0 +1,105 @@
+package helper
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setupStreamAccounting(t *testing.T, interval int, rules string) {
+	t.Helper()
+	oldInterval, oldRules, oldRedis := setting.RateLimitStreamAccountingTokens, setting.RateLimitRules2JSONString(), common.RedisEnabled()
+	setting.RateLimitStreamAccountingTokens = interval
+	common.SetRedisEnabled(false)
+	if err := setting.UpdateRateLimitRulesByJSONString(rules); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		setting.RateLimitStreamAccountingTokens = oldInterval
+		common.SetRedisEnabled(oldRedis)
+		_ = setting.UpdateRateLimitRulesByJSONString(oldRules)
+	})
+}
+
+// serveLongStream 以令牌 tokenId 转发一个包含 chunks 个数据块的流式响应，返回写给客户端的响应体
+func serveLongStream(t *testing.T, tokenId int, promptTokens int, chunks int) (*gin.Context, string) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	constant.StreamingTimeout = 30
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	common.SetContextKey(c, constant.ContextKeyTokenGroup, "default")
+	info := &relaycommon.RelayInfo{TokenId: tokenId, DisablePing: true, ChannelMeta: &relaycommon.ChannelMeta{}}
+	info.SetEstimatePromptTokens(promptTokens)
+
+	var upstream strings.Builder
+	for i := 1; i <= chunks; i++ {
+		fmt.Fprintf(&upstream, "data: {\"n\":%d}\n\n", i)
+	}
+	upstream.WriteString("data: [DONE]\n\n")
+	StreamScannerHandler(c, &http.Response{Body: io.NopCloser(strings.NewReader(upstream.String()))}, info, func(data string) bool {
+		return StringData(c, data) == nil
+	})
+	return c, w.Body.String()
+}
+
+func TestLongStreamCutOffAtTokenLimit(t *testing.T) {
+	setupStreamAccounting(t, 2, `[{"name":"tph-1911","dimension":"token","metric":"tokens","window_seconds":3600,"limit":10}]`)
+	rule := setting.GetRateLimitRules()[0]
+
+	// 每 2 个数据块计入一次，第一次计入时带上 3 个输入 token：5, 7, 9, 11，第 8 个数据块后超出
+	c, body := serveLongStream(t, 1911, 3, 20)
+	if !strings.Contains(body, `{"n":8}`) {
+		t.Fatalf("stream cut off before the limit: %s", body)
+	}
+	if strings.Contains(body, `{"n":9}`) {
+		t.Fatalf("stream continued past the limit: %s", body)
+	}
+	if !strings.Contains(body, "rate_limit_error") || !strings.Contains(body, "tph-1911") {
+		t.Fatalf("missing rate limit error frame: %s", body)
+	}
+	if strings.Index(body, "rate_limit_error") < strings.Index(body, `{"n":8}`) {
+		t.Fatalf("error frame out of order: %s", body)
+	}
+	used, _, err := model.GetRateLimitRuleTokens(rule, "1911")
+	if err != nil || used != 11 {
+		t.Fatalf("rule tokens = %d (%v), want 11", used, err)
+	}
+	if got := common.GetContextKeyInt(c, constant.ContextKeyRateLimitStreamTokens); got != 11 {
+		t.Fatalf("stream tokens in context = %d, want 11", got)
+	}
+
+	// 请求结束记账时扣除流式过程中已计入的部分，不重复计入
+	oldLog := common.LogConsumeEnabled
+	common.LogConsumeEnabled = false
+	t.Cleanup(func() { common.LogConsumeEnabled = oldLog })
+	model.RecordConsumeLog(c, 0, model.RecordConsumeLogParams{TokenId: 1911, PromptTokens: 3, CompletionTokens: 10})
+	if used, _, _ = model.GetRateLimitRuleTokens(rule, "1911"); used != 13 {
+		t.Fatalf("rule tokens after consume log = %d, want 13", used)
+	}
+}
+
+func TestStreamAccountingDisabled(t *testing.T) {
+	setupStreamAccounting(t, 0, `[{"name":"tph-1912","dimension":"token","metric":"tokens","window_seconds":3600,"limit":10}]`)
+
+	_, body := serveLongStream(t, 1912, 3, 20)
+	if !strings.Contains(body, `{"n":20}`) || strings.Contains(body, "rate_limit_error") {
+		t.Fatalf("stream cut off with accounting disabled: %s", body)
+	}
+	used, _, _ := model.GetRateLimitRuleTokens(setting.GetRateLimitRules()[0], "1912")
+	if used != 0 {
+		t.Fatalf("rule tokens = %d, want 0 until the request is recorded", used)
+	}
+}
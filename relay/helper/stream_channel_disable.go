@@ -0,0 +1,25 @@
+package helper
+
+import (
+	"github.com/QuantumNous/new-api/dto"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+const streamChannelDisabledMessage = "当前渠道已不可用，响应已中断，请重试"
+
+// writeStreamChannelDisabledError 渠道在响应过程中被禁用时在流中写入错误，客户端据此得知响应被截断的原因并重试
+func writeStreamChannelDisabledError(c *gin.Context, info *relaycommon.RelayInfo) {
+	if info.RelayFormat == types.RelayFormatClaude {
+		_ = ClaudeData(c, dto.ClaudeResponse{
+			Type:  "error",
+			Error: &types.ClaudeError{Type: "overloaded_error", Message: streamChannelDisabledMessage},
+		})
+		return
+	}
+	_ = ObjectData(c, gin.H{
+		"error": types.OpenAIError{Message: streamChannelDisabledMessage, Type: "server_error", Code: "channel_unavailable"},
+	})
+}
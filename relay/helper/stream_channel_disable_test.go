@@ -0,0 +1,125 @@
+package helper
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// signalRecorder 记录写入的响应，写入的内容包含 marker 时通知 seen
+type signalRecorder struct {
+	*httptest.ResponseRecorder
+	mu     sync.Mutex
+	body   bytes.Buffer
+	marker string
+	seen   chan struct{}
+	once   sync.Once
+}
+
+func (r *signalRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.body.Write(p)
+	if strings.Contains(r.body.String(), r.marker) {
+		r.once.Do(func() { close(r.seen) })
+	}
+	return r.ResponseRecorder.Write(p)
+}
+
+func (r *signalRecorder) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.body.String()
+}
+
+func setupChannelDB(t *testing.T) *model.Channel {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.AutoMigrate(&model.Channel{}, &model.Ability{}); err != nil {
+		t.Fatal(err)
+	}
+	oldDB := model.DB
+	model.DB = db
+	t.Cleanup(func() { model.DB = oldDB })
+	channel := &model.Channel{Name: "stream", Key: "sk-test", Status: common.ChannelStatusEnabled, Models: "gpt-4o", Group: "default"}
+	if err = db.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	return channel
+}
+
+func TestStreamInterruptedWhenChannelDisabled(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	constant.StreamingTimeout = 30
+	setting.NotifyOnMidStreamChannelDisable = true
+	t.Cleanup(func() { setting.NotifyOnMidStreamChannelDisable = false })
+	channel := setupChannelDB(t)
+
+	w := &signalRecorder{ResponseRecorder: httptest.NewRecorder(), marker: "channel_unavailable", seen: make(chan struct{})}
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	info := &relaycommon.RelayInfo{DisablePing: true, ChannelMeta: &relaycommon.ChannelMeta{ChannelId: channel.Id}}
+
+	pr, pw := io.Pipe()
+	received := make(chan string, 4)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		StreamScannerHandler(c, &http.Response{Body: pr}, info, func(data string) bool {
+			received <- data
+			return StringData(c, data) == nil
+		})
+	}()
+
+	if _, err := io.WriteString(pw, "data: {\"n\":1}\n\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-received; got != `{"n":1}` {
+		t.Fatalf("first event = %q", got)
+	}
+
+	// 管理员在流式响应过程中禁用渠道
+	channel.Status = common.ChannelStatusManuallyDisabled
+	if err := channel.Update(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-w.seen:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no error frame after the channel was disabled")
+	}
+
+	// 渠道禁用之后上游的数据不再写给客户端
+	_, _ = io.WriteString(pw, "data: {\"n\":2}\n\n")
+	_ = pw.Close()
+	<-finished
+
+	body := w.String()
+	if !strings.Contains(body, `{"n":1}`) {
+		t.Fatalf("first event missing: %s", body)
+	}
+	if strings.Contains(body, `{"n":2}`) {
+		t.Fatalf("event written after the error frame: %s", body)
+	}
+	if strings.Index(body, "channel_unavailable") < strings.Index(body, `{"n":1}`) {
+		t.Fatalf("error frame out of order: %s", body)
+	}
+}
@@ -8,12 +8,15 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting"
 	"github.com/QuantumNous/new-api/setting/operation_setting"
 
 	"github.com/bytedance/gopkg/util/gopool"
@@ -113,6 +116,17 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 
 	ctx = context.WithValue(ctx, "stop_chan", stopChan)
 
+	accountant := newStreamTokenAccountant(c, info)
+
+	// 渠道在响应过程中被禁用时写入错误后结束，interrupted 置位后不再写入后续数据
+	var channelDisabled <-chan struct{}
+	var interrupted atomic.Bool
+	if setting.NotifyOnMidStreamChannelDisable && info.ChannelId > 0 {
+		var unwatch func()
+		channelDisabled, unwatch = model.WatchChannelDisable(info.ChannelId)
+		defer unwatch()
+	}
+
 	// Handle ping data sending with improved error handling
 	if pingEnabled && pingTicker != nil {
 		wg.Add(1)
@@ -141,6 +155,11 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 					go func() {
 						writeMutex.Lock()
 						defer writeMutex.Unlock()
+						// 已写入渠道禁用的错误后不再发送 ping
+						if interrupted.Load() {
+							done <- nil
+							return
+						}
 						done <- PingData(c)
 					}()
 
@@ -225,6 +244,10 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 				go func() {
 					writeMutex.Lock()
 					defer writeMutex.Unlock()
+					if interrupted.Load() {
+						done <- false
+						return
+					}
 					done <- dataHandler(data)
 				}()
 
@@ -233,6 +256,15 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 					if !success {
 						return
 					}
+					if accountant != nil {
+						if rule, exceeded := accountant.add(); exceeded {
+							logger.LogWarn(c, fmt.Sprintf("stream interrupted by rate limit rule %s", rule.Name))
+							writeMutex.Lock()
+							writeStreamRateLimitError(c, info, rule)
+							writeMutex.Unlock()
+							return
+						}
+					}
 				case <-time.After(10 * time.Second):
 					logger.LogError(c, "data handler timeout")
 					return
@@ -268,5 +300,11 @@ func StreamScannerHandler(c *gin.Context, resp *http.Response, info *relaycommon
 	case <-c.Request.Context().Done():
 		// 客户端断开连接
 		logger.LogInfo(c, "client disconnected")
+	case <-channelDisabled:
+		logger.LogWarn(c, fmt.Sprintf("channel #%d disabled, stream interrupted", info.ChannelId))
+		writeMutex.Lock()
+		interrupted.Store(true)
+		writeStreamChannelDisabledError(c, info)
+		writeMutex.Unlock()
 	}
 }
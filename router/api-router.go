@@ -120,8 +120,24 @@ func SetApiRouter(router *gin.Engine) {
 			optionRoute.GET("/", controller.GetOptions)
 			optionRoute.PUT("/", controller.UpdateOption)
 			optionRoute.POST("/rest_model_ratio", controller.ResetModelRatio)
+			optionRoute.POST("/rate_limit/simulate", controller.SimulateRateLimit)
+			optionRoute.GET("/rate_limit/export", controller.ExportRateLimitConfig)
+			optionRoute.POST("/rate_limit/import", controller.ImportRateLimitConfig)
+			optionRoute.GET("/rate_limit/audit", controller.GetRateLimitAudits)
+			optionRoute.PUT("/rate_limit/group", controller.UpdateGroupRateLimit)
 			optionRoute.POST("/migrate_console_setting", controller.MigrateConsoleSetting) // 用于迁移检测的旧键，下个版本会删除
 		}
+		rateLimitRoute := apiRouter.Group("/rate_limit")
+		rateLimitRoute.Use(middleware.AdminAuth())
+		{
+			rateLimitRoute.GET("/token/:id", controller.GetTokenRateLimitBreakdown)
+			rateLimitRoute.GET("/usage/export", controller.ExportTokenUsage)
+			rateLimitRoute.PUT("/token/:id/org", controller.UpdateTokenOrg)
+			rateLimitRoute.PUT("/token/:id/algorithm", controller.UpdateTokenRateLimitAlgorithm)
+			rateLimitRoute.PUT("/token/:id/customers", controller.UpdateTokenCustomerIds)
+			rateLimitRoute.PUT("/token/:id/billing_anchor", controller.UpdateTokenBillingAnchor)
+			rateLimitRoute.POST("/group/preview", controller.PreviewGroupRateLimit)
+		}
 		ratioSyncRoute := apiRouter.Group("/ratio_sync")
 		ratioSyncRoute.Use(middleware.RootAuth())
 		{
@@ -148,6 +164,12 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.POST("/tag/enabled", controller.EnableTagChannels)
 			channelRoute.PUT("/tag", controller.EditTagChannels)
 			channelRoute.DELETE("/:id", controller.DeleteChannel)
+			channelRoute.POST("/:id/enable", controller.EnableChannel)
+			channelRoute.POST("/:id/pause", controller.PauseChannel)
+			channelRoute.POST("/:id/resume", controller.ResumeChannel)
+			channelRoute.GET("/:id/failures", controller.GetChannelFailureCounters)
+			channelRoute.DELETE("/:id/failures", controller.ResetChannelFailureCounters)
+			channelRoute.GET("/model_health", controller.GetModelHealth)
 			channelRoute.POST("/batch", controller.DeleteChannelBatch)
 			channelRoute.POST("/fix", controller.FixChannelsAbilities)
 			channelRoute.GET("/fetch_models/:id", controller.FetchUpstreamModels)
@@ -1,21 +1,47 @@
 package service
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
 	"github.com/QuantumNous/new-api/types"
 )
 
-func ShouldDisableChannel(channelId int, err *types.NewAPIError) bool {
-	if !common.AutomaticDisableChannelEnabled {
+// ShouldDisableChannel 判断错误是否应禁用渠道，渠道是否允许自动禁用由调用方通过 ChannelError.AutoBan 判断
+func ShouldDisableChannel(channelType int, err *types.NewAPIError) bool {
+	if err == nil {
 		return false
 	}
-	if err == nil {
+	// 渠道配置不支持该模型时请求不会发往上游，与渠道本身是否可用无关
+	if err.GetErrorCode() == types.ErrorCodeChannelModelNotSupported {
 		return false
 	}
+	// 优先按上游错误体中的结构化错误码判断
+	for _, code := range err.UpstreamErrorCodes() {
+		if setting.IsChannelDisableErrorCode(code) {
+			return true
+		}
+	}
+	// 再按渠道类型配置的正则匹配上游错误信息
+	if setting.MatchChannelDisableRegex(channelType, err.Error()) {
+		return true
+	}
+	// 按模型配置的请求超时，连续超时达到阈值才禁用，触发禁用后计数清零，渠道重新启用后重新计数
+	var timeoutErr *types.ModelTimeoutError
+	if errors.As(err, &timeoutErr) {
+		if setting.ModelTimeoutDisableThreshold <= 0 || timeoutErr.Consecutive < setting.ModelTimeoutDisableThreshold {
+			return false
+		}
+		ResetModelTimeoutCount(timeoutErr.ChannelId, timeoutErr.ModelName)
+		return true
+	}
 	errMsg := strings.ToLower(err.Error())
 	if strings.Contains(errMsg, "no candidates returned") || strings.Contains(errMsg, "deadline exceeded") || strings.Contains(errMsg, "timeout") || strings.Contains(errMsg, "connect") || strings.Contains(errMsg, "do request failed") || strings.Contains(errMsg, "provider returned error") || strings.Contains(errMsg, "internal server error") || strings.Contains(errMsg, "no response received") {
 		return false
@@ -37,11 +63,223 @@ func ShouldDisableChannel(channelId int, err *types.NewAPIError) bool {
 	return false
 }
 
-func DisableChannel(channelError types.ChannelError, reason string) {
-	success := model.UpdateChannelStatus(channelError.ChannelId, channelError.UsingKey, common.ChannelStatusAutoDisabled, reason)
-	if success {
+// IsChannelFailure 判断错误是否应计入渠道的失败次数：请求参数等客户端错误以及未发往上游的本地错误不计入
+func IsChannelFailure(err *types.NewAPIError) bool {
+	switch err.GetErrorCode() {
+	case types.ErrorCodeChannelConcurrencyExceeded, types.ErrorCodeChannelModelNotSupported:
+		return false
+	}
+	switch err.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return err.StatusCode >= 500 || err.StatusCode < 400
+}
+
+// ShouldDisableChannelBySuccessRate 渠道在统计窗口内的成功率低于 ChannelMinSuccessRate 时返回 true 及禁用原因
+func ShouldDisableChannelBySuccessRate(channelId int) (bool, string) {
+	if setting.ChannelMinSuccessRate <= 0 {
+		return false, ""
+	}
+	rate, samples := model.GetChannelSuccessRate(channelId)
+	if samples < setting.ChannelSuccessRateMinSamples || rate >= setting.ChannelMinSuccessRate {
+		return false, ""
+	}
+	return true, fmt.Sprintf("success rate %.2f%% over last %d requests is below %.2f%%", rate*100, samples, setting.ChannelMinSuccessRate*100)
+}
+
+// 自动禁用渠道时，数据库暂时不可用等错误的重试次数及间隔（按次数递增）
+const (
+	channelStatusUpdateAttempts      = 3
+	channelStatusUpdateRetryInterval = 200 * time.Millisecond
+)
+
+// DisableChannel 自动禁用渠道，apiErr 为导致禁用的错误（可为空），会以结构化的形式保存到渠道状态原因中
+func DisableChannel(channelError types.ChannelError, apiErr *types.NewAPIError, reason string) {
+	if failures, ok := model.GetChannelGraceFailures(channelError.ChannelId); ok {
+		common.SysLog(fmt.Sprintf("channel #%d (%s) failure %d/%d after re-enable tolerated, reason: %s", channelError.ChannelId, channelError.ChannelName, failures, setting.ChannelPostEnableGraceFailures, reason))
+		return
+	}
+	detail := types.NewChannelStatusDetail(reason, apiErr)
+	var changed bool
+	var err error
+	for attempt := 1; attempt <= channelStatusUpdateAttempts; attempt++ {
+		changed, err = model.UpdateChannelStatusWithDetail(channelError.ChannelId, channelError.UsingKey, common.ChannelStatusAutoDisabled, detail)
+		if err == nil || errors.Is(err, model.ErrChannelNotFound) {
+			break
+		}
+		if attempt < channelStatusUpdateAttempts {
+			common.SysLog(fmt.Sprintf("failed to disable channel #%d (%s), retrying (%d/%d): %s", channelError.ChannelId, channelError.ChannelName, attempt, channelStatusUpdateAttempts, err.Error()))
+			time.Sleep(time.Duration(attempt) * channelStatusUpdateRetryInterval)
+		}
+	}
+	switch {
+	case errors.Is(err, model.ErrChannelNotFound):
+		common.SysLog(fmt.Sprintf("channel #%d (%s) not found, skip disabling", channelError.ChannelId, channelError.ChannelName))
+	case err != nil:
+		common.SysLog(fmt.Sprintf("failed to disable channel #%d (%s) after %d attempts: %s", channelError.ChannelId, channelError.ChannelName, channelStatusUpdateAttempts, err.Error()))
+	case changed:
 		common.SysLog(fmt.Sprintf("channel #%d (%s) disabled, reason: %s", channelError.ChannelId, channelError.ChannelName, reason))
-	} else {
-		common.SysLog(fmt.Sprintf("failed to disable channel #%d (%s)", channelError.ChannelId, channelError.ChannelName))
+		checkChannelFlapping(channelError)
+	default:
+		common.SysLog(fmt.Sprintf("channel #%d (%s) status unchanged, already disabled or paused", channelError.ChannelId, channelError.ChannelName))
+	}
+}
+
+// checkChannelFlapping 渠道在统计窗口内的启用/禁用切换次数达到 ChannelFlapThreshold 时，
+// 延长冷却 ChannelFlapHoldSeconds 并通知管理员
+func checkChannelFlapping(channelError types.ChannelError) {
+	if setting.ChannelFlapThreshold <= 0 {
+		return
+	}
+	transitions := model.CountChannelTransitions(channelError.ChannelId)
+	if transitions < setting.ChannelFlapThreshold {
+		return
+	}
+	until := common.GetTimestamp() + int64(setting.ChannelFlapHoldSeconds)
+	model.SetChannelFlapHold(channelError.ChannelId, until)
+	common.SysLog(fmt.Sprintf("channel #%d (%s) flapping: %d status changes within %ds, held disabled until %s", channelError.ChannelId, channelError.ChannelName, transitions, setting.ChannelFlapWindowSeconds, time.Unix(until, 0).Format(time.RFC3339)))
+	subject := fmt.Sprintf("通道「%s」（#%d）频繁启用/禁用", channelError.ChannelName, channelError.ChannelId)
+	content := fmt.Sprintf("通道「%s」（#%d）在 %d 秒内状态切换了 %d 次，已延长冷却，%s 前不能再次启用", channelError.ChannelName, channelError.ChannelId, setting.ChannelFlapWindowSeconds, transitions, time.Unix(until, 0).Format("2006-01-02 15:04:05"))
+	NotifyRootUser(dto.NotifyTypeChannelUpdate, subject, content)
+}
+
+// EnableChannelById 手动恢复单个已被禁用的渠道，同时清除自动禁用相关的状态，
+// 包括各项失败计数、退避与隔离，避免渠道启用后因之前累计的失败立即再次被禁用
+func EnableChannelById(channelId int) error {
+	channel, err := model.GetChannelById(channelId, false)
+	if err != nil {
+		return fmt.Errorf("渠道 #%d 不存在", channelId)
+	}
+	if channel.Status == common.ChannelStatusEnabled {
+		return fmt.Errorf("渠道 #%d 未被禁用", channelId)
+	}
+	if until := model.GetChannelFlapHold(channelId); until > 0 {
+		return fmt.Errorf("渠道 #%d 频繁启用/禁用，冷却中，%s 前不能启用", channelId, time.Unix(until, 0).Format("2006-01-02 15:04:05"))
+	}
+	// 先清空失败计数再启用，启用时记录的状态切换与重新启用后的容错次数不受影响
+	resetChannelFailuresOnEnable(channelId)
+	if _, err = model.SetChannelStatus(channelId, common.ChannelStatusEnabled, ""); err != nil {
+		return err
+	}
+	common.SysLog(fmt.Sprintf("channel #%d (%s) enabled manually", channel.Id, channel.Name))
+	return nil
+}
+
+// PauseChannelById 手动暂停已启用的渠道：暂停期间渠道不参与选择，也不会被自动禁用；
+// resumeAt 大于 0 时到达该时间后自动恢复
+func PauseChannelById(channelId int, reason string, resumeAt int64) error {
+	channel, err := model.GetChannelById(channelId, false)
+	if err != nil {
+		return fmt.Errorf("渠道 #%d 不存在", channelId)
+	}
+	if channel.Status != common.ChannelStatusEnabled {
+		return fmt.Errorf("渠道 #%d 未处于启用状态，无法暂停", channelId)
+	}
+	if resumeAt > 0 && resumeAt <= common.GetTimestamp() {
+		return fmt.Errorf("自动恢复时间必须晚于当前时间")
+	}
+	detail := types.NewChannelStatusDetail(reason, nil)
+	detail.ResumeAt = resumeAt
+	if _, err = model.SetChannelStatusWithDetail(channelId, common.ChannelStatusPaused, detail); err != nil {
+		return err
+	}
+	common.SysLog(fmt.Sprintf("channel #%d (%s) paused, reason: %s", channel.Id, channel.Name, reason))
+	return nil
+}
+
+// ResumeChannelById 恢复被暂停的渠道
+func ResumeChannelById(channelId int) error {
+	channel, err := model.GetChannelById(channelId, false)
+	if err != nil {
+		return fmt.Errorf("渠道 #%d 不存在", channelId)
+	}
+	if channel.Status != common.ChannelStatusPaused {
+		return fmt.Errorf("渠道 #%d 未被暂停", channelId)
+	}
+	if _, err = model.SetChannelStatus(channelId, common.ChannelStatusEnabled, ""); err != nil {
+		return err
+	}
+	common.SysLog(fmt.Sprintf("channel #%d (%s) resumed", channel.Id, channel.Name))
+	return nil
+}
+
+// AutomaticallyResumePausedChannels 定期恢复已到达自动恢复时间的暂停渠道
+func AutomaticallyResumePausedChannels() {
+	for {
+		time.Sleep(time.Minute)
+		if resumeDuePausedChannels(common.GetTimestamp()) {
+			model.InitChannelCache()
+		}
+	}
+}
+
+// resumeDuePausedChannels 恢复自动恢复时间不晚于 now 的暂停渠道，返回是否有渠道被恢复
+func resumeDuePausedChannels(now int64) bool {
+	var channels []*model.Channel
+	if err := model.DB.Where("status = ?", common.ChannelStatusPaused).Omit("key").Find(&channels).Error; err != nil {
+		common.SysLog("failed to query paused channels: " + err.Error())
+		return false
+	}
+	resumed := false
+	for _, channel := range channels {
+		detail := channel.GetStatusDetail()
+		if detail == nil || detail.ResumeAt <= 0 || detail.ResumeAt > now {
+			continue
+		}
+		if err := ResumeChannelById(channel.Id); err != nil {
+			common.SysLog(fmt.Sprintf("failed to resume channel #%d: %s", channel.Id, err.Error()))
+			continue
+		}
+		resumed = true
+	}
+	return resumed
+}
+
+// ChannelTagStatusResult 按标签批量修改渠道状态的结果
+type ChannelTagStatusResult struct {
+	Updated []int          `json:"updated"`
+	Skipped []int          `json:"skipped"`
+	Failed  map[int]string `json:"failed"`
+}
+
+// DisableChannelsByTag 逐个手动禁用带有指定标签的渠道，单个渠道失败不会中断其余渠道
+func DisableChannelsByTag(tag string, reason string) (*ChannelTagStatusResult, error) {
+	return setChannelsStatusByTag(tag, common.ChannelStatusManuallyDisabled, reason)
+}
+
+// EnableChannelsByTag 逐个启用带有指定标签的渠道，单个渠道失败不会中断其余渠道
+func EnableChannelsByTag(tag string) (*ChannelTagStatusResult, error) {
+	return setChannelsStatusByTag(tag, common.ChannelStatusEnabled, "")
+}
+
+func setChannelsStatusByTag(tag string, status int, reason string) (*ChannelTagStatusResult, error) {
+	channels, err := model.GetChannelsByTag(tag, true, false)
+	if err != nil {
+		return nil, err
+	}
+	result := &ChannelTagStatusResult{
+		Updated: make([]int, 0),
+		Skipped: make([]int, 0),
+		Failed:  make(map[int]string),
+	}
+	for _, channel := range channels {
+		changed, err := model.SetChannelStatus(channel.Id, status, reason)
+		if err != nil {
+			result.Failed[channel.Id] = err.Error()
+			common.SysLog(fmt.Sprintf("failed to update channel #%d (%s) status by tag %s: %v", channel.Id, channel.Name, tag, err))
+			continue
+		}
+		if !changed {
+			result.Skipped = append(result.Skipped, channel.Id)
+			continue
+		}
+		result.Updated = append(result.Updated, channel.Id)
+		if status == common.ChannelStatusEnabled {
+			common.SysLog(fmt.Sprintf("channel #%d (%s) enabled by tag %s", channel.Id, channel.Name, tag))
+		} else {
+			common.SysLog(fmt.Sprintf("channel #%d (%s) disabled by tag %s, reason: %s", channel.Id, channel.Name, tag, reason))
+		}
 	}
+	return result, nil
 }
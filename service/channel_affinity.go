@@ -0,0 +1,155 @@
+package service
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// SessionIdHeader 客户端传入会话 ID 的请求头，开启 ChannelAffinityEnabled 后同一会话优先使用同一渠道
+const SessionIdHeader = "X-Session-Id"
+
+// 会话 ID 最大长度，过长的会话 ID 不参与粘滞
+const maxSessionIdLength = 128
+
+type channelAffinityEntry struct {
+	channelId int
+	group     string
+	expireAt  time.Time
+}
+
+// 未启用 Redis 时在内存中保存会话与渠道的绑定关系，最多保存 channelAffinityMaxEntries 个
+var (
+	channelAffinityMutex      sync.Mutex
+	channelAffinityMap        = map[string]channelAffinityEntry{}
+	channelAffinityMaxEntries = 10000
+)
+
+// channelAffinityKey 返回会话绑定关系的 key，按用户与模型区分，不同用户使用相同的会话 ID 互不影响。
+// 未开启会话粘滞或请求未携带会话 ID 时返回空字符串
+func channelAffinityKey(param *RetryParam) string {
+	if !setting.ChannelAffinityEnabled || param.Ctx == nil {
+		return ""
+	}
+	sessionId := strings.TrimSpace(param.Ctx.GetHeader(SessionIdHeader))
+	if sessionId == "" || len(sessionId) > maxSessionIdLength {
+		return ""
+	}
+	userId := common.GetContextKeyInt(param.Ctx, constant.ContextKeyUserId)
+	return fmt.Sprintf("channelAffinity:%d:%s:%s", userId, param.ModelName, sessionId)
+}
+
+func channelAffinityTTL() time.Duration {
+	ttl := setting.ChannelAffinityTTLSeconds
+	if ttl <= 0 {
+		ttl = 3600
+	}
+	return time.Duration(ttl) * time.Second
+}
+
+// getAffinityChannel 返回会话上一次使用且当前仍可用的渠道，渠道已禁用、已不在该分组下提供该模型、
+// 处于退避、隔离或达到并发上限时返回 nil，由调用方按正常规则重新选择
+func getAffinityChannel(param *RetryParam) (*model.Channel, string) {
+	key := channelAffinityKey(param)
+	if key == "" {
+		return nil, ""
+	}
+	channelId, group, ok := loadChannelAffinity(key)
+	if !ok {
+		return nil, ""
+	}
+	if param.TokenGroup != "auto" && group != param.TokenGroup {
+		return nil, ""
+	}
+	if param.TokenGroup == "auto" && !slices.Contains(GetUserAutoGroup(common.GetContextKeyString(param.Ctx, constant.ContextKeyUserGroup)), group) {
+		return nil, ""
+	}
+	channel, err := model.CacheGetChannel(channelId)
+	if err != nil || !isChannelServing(channel, group, param.ModelName) || model.IsChannelTemporarilyUnavailable(channelId) {
+		logger.LogDebug(param.Ctx, "Session affinity channel #%d is unavailable, selecting a new channel", channelId)
+		return nil, ""
+	}
+	if param.TokenGroup == "auto" {
+		common.SetContextKey(param.Ctx, constant.ContextKeyAutoGroup, group)
+	}
+	logger.LogDebug(param.Ctx, "Session affinity selected channel #%d", channelId)
+	return channel, group
+}
+
+// saveChannelAffinity 记录会话本次使用的渠道，重试切换渠道后会话随之绑定到新渠道
+func saveChannelAffinity(param *RetryParam, channel *model.Channel, group string) {
+	key := channelAffinityKey(param)
+	if key == "" || channel == nil {
+		return
+	}
+	ttl := channelAffinityTTL()
+	if common.RedisEnabled() {
+		if err := common.RedisSet(key, fmt.Sprintf("%d:%s", channel.Id, group), ttl); err != nil {
+			common.SysLog("failed to save channel affinity: " + err.Error())
+		}
+		return
+	}
+	now := time.Now()
+	channelAffinityMutex.Lock()
+	defer channelAffinityMutex.Unlock()
+	// 顺带清理过期的绑定关系，避免会话 ID 无限增长
+	if _, ok := channelAffinityMap[key]; !ok && len(channelAffinityMap) >= channelAffinityMaxEntries {
+		for k, entry := range channelAffinityMap {
+			if now.After(entry.expireAt) {
+				delete(channelAffinityMap, k)
+			}
+		}
+		// 没有过期的绑定关系时淘汰最早过期（即最早写入）的一个
+		for len(channelAffinityMap) >= channelAffinityMaxEntries {
+			evictOldestChannelAffinity()
+		}
+	}
+	channelAffinityMap[key] = channelAffinityEntry{channelId: channel.Id, group: group, expireAt: now.Add(ttl)}
+}
+
+// evictOldestChannelAffinity 删除最早过期的绑定关系，调用方需持有锁
+func evictOldestChannelAffinity() {
+	var oldestKey string
+	var oldest time.Time
+	for k, entry := range channelAffinityMap {
+		if oldestKey == "" || entry.expireAt.Before(oldest) {
+			oldestKey, oldest = k, entry.expireAt
+		}
+	}
+	delete(channelAffinityMap, oldestKey)
+}
+
+func loadChannelAffinity(key string) (int, string, bool) {
+	if common.RedisEnabled() {
+		value, err := common.RedisGet(key)
+		if err != nil {
+			return 0, "", false
+		}
+		id, group, found := strings.Cut(value, ":")
+		channelId, err := strconv.Atoi(id)
+		if !found || err != nil {
+			return 0, "", false
+		}
+		return channelId, group, true
+	}
+	channelAffinityMutex.Lock()
+	defer channelAffinityMutex.Unlock()
+	entry, ok := channelAffinityMap[key]
+	if !ok {
+		return 0, "", false
+	}
+	if time.Now().After(entry.expireAt) {
+		delete(channelAffinityMap, key)
+		return 0, "", false
+	}
+	return entry.channelId, entry.group, true
+}
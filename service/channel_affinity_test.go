@@ -0,0 +1,58 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+
+	"github.com/gin-gonic/gin"
+)
+
+func affinityParam(sessionId string) *RetryParam {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	c.Request.Header.Set(SessionIdHeader, sessionId)
+	return &RetryParam{Ctx: c, TokenGroup: "default", ModelName: "gpt-4o"}
+}
+
+func TestChannelAffinityMapIsCapped(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	common.SetRedisEnabled(false)
+	setting.ChannelAffinityEnabled = true
+	channelAffinityMaxEntries = 3
+	t.Cleanup(func() {
+		setting.ChannelAffinityEnabled = false
+		channelAffinityMaxEntries = 10000
+		channelAffinityMap = map[string]channelAffinityEntry{}
+	})
+
+	for i := 1; i <= 5; i++ {
+		saveChannelAffinity(affinityParam("session-"+strconv.Itoa(i)), &model.Channel{Id: i}, "default")
+		// 保证过期时间有先后
+		time.Sleep(time.Millisecond)
+	}
+	if got := len(channelAffinityMap); got != 3 {
+		t.Fatalf("map size = %d, want 3", got)
+	}
+	for i := 1; i <= 5; i++ {
+		_, _, ok := loadChannelAffinity(channelAffinityKey(affinityParam("session-" + strconv.Itoa(i))))
+		if want := i > 2; ok != want {
+			t.Errorf("session-%d bound = %v, want %v", i, ok, want)
+		}
+	}
+
+	// 已有的会话更新绑定时不淘汰其他会话
+	saveChannelAffinity(affinityParam("session-3"), &model.Channel{Id: 9}, "default")
+	if got := len(channelAffinityMap); got != 3 {
+		t.Fatalf("map size after rebinding = %d, want 3", got)
+	}
+	if id, _, _ := loadChannelAffinity(channelAffinityKey(affinityParam("session-3"))); id != 9 {
+		t.Fatalf("session-3 bound to %d, want 9", id)
+	}
+}
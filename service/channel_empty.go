@@ -0,0 +1,60 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+// 各渠道连续返回空回复的次数，收到非空回复后清零
+var (
+	channelEmptyCountMutex sync.Mutex
+	channelEmptyCount      = map[int]int{}
+)
+
+// IsEmptyResponseError 判断错误是否表示上游没有返回任何内容，如 Gemini 的 "no candidates returned"
+func IsEmptyResponseError(err *types.NewAPIError) bool {
+	if err == nil {
+		return false
+	}
+	return err.GetErrorCode() == types.ErrorCodeEmptyResponse || strings.Contains(strings.ToLower(err.Error()), "no candidates returned")
+}
+
+// ShouldDisableChannelOnEmpty 记录渠道本次是否返回空回复，连续空回复达到 ChannelDisableOnEmptyAfterN 次时返回 true 及禁用原因，
+// 触发禁用后计数清零，渠道重新启用后重新计数
+func ShouldDisableChannelOnEmpty(channelId int, empty bool) (bool, string) {
+	threshold := setting.ChannelDisableOnEmptyAfterN
+	if threshold <= 0 {
+		return false, ""
+	}
+	channelEmptyCountMutex.Lock()
+	defer channelEmptyCountMutex.Unlock()
+	if !empty {
+		delete(channelEmptyCount, channelId)
+		return false, ""
+	}
+	channelEmptyCount[channelId]++
+	consecutive := channelEmptyCount[channelId]
+	if consecutive < threshold {
+		return false, ""
+	}
+	delete(channelEmptyCount, channelId)
+	return true, fmt.Sprintf("%d consecutive empty responses", consecutive)
+}
+
+// GetChannelEmptyCount 返回渠道当前连续空回复的次数
+func GetChannelEmptyCount(channelId int) int {
+	channelEmptyCountMutex.Lock()
+	defer channelEmptyCountMutex.Unlock()
+	return channelEmptyCount[channelId]
+}
+
+// ResetChannelEmptyCount 清零渠道连续空回复的次数
+func ResetChannelEmptyCount(channelId int) {
+	channelEmptyCountMutex.Lock()
+	defer channelEmptyCountMutex.Unlock()
+	delete(channelEmptyCount, channelId)
+}
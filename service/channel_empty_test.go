@@ -0,0 +1,91 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+func setChannelDisableOnEmpty(t *testing.T, n int) {
+	t.Helper()
+	old := setting.ChannelDisableOnEmptyAfterN
+	setting.ChannelDisableOnEmptyAfterN = n
+	t.Cleanup(func() { setting.ChannelDisableOnEmptyAfterN = old })
+}
+
+func TestIsEmptyResponseError(t *testing.T) {
+	cases := []struct {
+		err  *types.NewAPIError
+		want bool
+	}{
+		{nil, false},
+		{types.NewError(errors.New("empty"), types.ErrorCodeEmptyResponse), true},
+		{types.NewErrorWithStatusCode(errors.New("No candidates returned"), types.ErrorCodeBadResponseBody, http.StatusInternalServerError), true},
+		{types.NewErrorWithStatusCode(errors.New("bad gateway"), types.ErrorCodeBadResponseStatusCode, http.StatusBadGateway), false},
+	}
+	for _, tc := range cases {
+		if got := IsEmptyResponseError(tc.err); got != tc.want {
+			t.Errorf("IsEmptyResponseError(%v) = %v, want %v", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestShouldDisableChannelOnEmpty(t *testing.T) {
+	setChannelDisableOnEmpty(t, 3)
+	t.Cleanup(func() { ResetChannelEmptyCount(1571) })
+
+	// 非空回复清零计数，只有连续的空回复才会触发禁用
+	for _, empty := range []bool{true, true, false, true, true} {
+		if disable, _ := ShouldDisableChannelOnEmpty(1571, empty); disable {
+			t.Fatalf("disabled before %d consecutive empty responses", setting.ChannelDisableOnEmptyAfterN)
+		}
+	}
+	if got := GetChannelEmptyCount(1571); got != 2 {
+		t.Fatalf("empty count = %d, want 2", got)
+	}
+	disable, reason := ShouldDisableChannelOnEmpty(1571, true)
+	if !disable || reason != "3 consecutive empty responses" {
+		t.Fatalf("third consecutive empty: disable=%v reason=%q", disable, reason)
+	}
+	if got := GetChannelEmptyCount(1571); got != 0 {
+		t.Fatalf("empty count after disabling = %d, want 0", got)
+	}
+}
+
+func TestShouldDisableChannelOnEmptyOff(t *testing.T) {
+	setChannelDisableOnEmpty(t, 0)
+	for i := 0; i < 10; i++ {
+		if disable, _ := ShouldDisableChannelOnEmpty(1572, true); disable {
+			t.Fatal("disabled with ChannelDisableOnEmptyAfterN = 0")
+		}
+	}
+	if got := GetChannelEmptyCount(1572); got != 0 {
+		t.Fatalf("empty count = %d, want nothing tracked", got)
+	}
+}
+
+func TestSustainedEmptyResponsesDisableChannel(t *testing.T) {
+	setupTestDB(t)
+	setChannelDisableOnEmpty(t, 3)
+	channel := createTestChannelWithId(t, 1573, "empty")
+	t.Cleanup(func() { ResetChannelEmptyCount(channel.Id) })
+	channelError := *types.NewChannelError(channel.Id, channel.Type, channel.Name, false, "", true)
+
+	for i := 1; i <= 3; i++ {
+		disable, reason := ShouldDisableChannelOnEmpty(channel.Id, true)
+		if disable {
+			DisableChannel(channelError, nil, reason)
+		}
+		want := common.ChannelStatusEnabled
+		if i == 3 {
+			want = common.ChannelStatusAutoDisabled
+		}
+		if got := channelStatus(t, channel.Id); got != want {
+			t.Fatalf("after %d empty responses: status %d, want %d", i, got, want)
+		}
+	}
+}
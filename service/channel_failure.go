@@ -0,0 +1,34 @@
+package service
+
+import "github.com/QuantumNous/new-api/model"
+
+// ChannelFailureCounters 渠道在本节点上的全部失败计数，供管理员排查自动禁用的原因
+type ChannelFailureCounters struct {
+	model.ChannelFailureState
+	ConsecutiveEmpty    int            `json:"consecutive_empty"`    // 连续空回复次数
+	ConsecutiveTimeouts map[string]int `json:"consecutive_timeouts"` // 各模型连续超时次数
+}
+
+// GetChannelFailureCounters 汇总渠道当前的失败计数与冷却状态
+func GetChannelFailureCounters(channelId int) ChannelFailureCounters {
+	return ChannelFailureCounters{
+		ChannelFailureState: model.GetChannelFailureState(channelId),
+		ConsecutiveEmpty:    GetChannelEmptyCount(channelId),
+		ConsecutiveTimeouts: GetModelTimeoutCounts(channelId),
+	}
+}
+
+// ResetChannelFailureCounters 清空渠道的全部失败计数与冷却状态
+func ResetChannelFailureCounters(channelId int) {
+	model.ResetChannelFailureState(channelId)
+	ResetChannelEmptyCount(channelId)
+	ResetModelTimeoutCounts(channelId)
+}
+
+// resetChannelFailuresOnEnable 手动启用渠道前清空失败计数、退避与隔离；
+// 保留状态切换历史，手动启用后再次被禁用时仍计入频繁切换的判断
+func resetChannelFailuresOnEnable(channelId int) {
+	model.ResetChannelFailures(channelId)
+	ResetChannelEmptyCount(channelId)
+	ResetModelTimeoutCounts(channelId)
+}
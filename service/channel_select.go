@@ -1,22 +1,28 @@
 package service
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
+	"slices"
+	"strconv"
 
 	"github.com/QuantumNous/new-api/common"
 	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
 	"github.com/gin-gonic/gin"
 )
 
 type RetryParam struct {
-	Ctx          *gin.Context
-	TokenGroup   string
-	ModelName    string
-	Retry        *int
-	resetNextTry bool
+	Ctx             *gin.Context
+	TokenGroup      string
+	ModelName       string
+	Retry           *int
+	FailedChannelId int // 上一次请求失败的渠道，用于查找 ChannelFallbackChains 中配置的备用渠道
+	resetNextTry    bool
 }
 
 func (p *RetryParam) GetRetry() int {
@@ -86,6 +92,20 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 	selectGroup := param.TokenGroup
 	userGroup := common.GetContextKeyString(param.Ctx, constant.ContextKeyUserGroup)
 
+	if param.FailedChannelId > 0 {
+		if channel, selectGroup = getFallbackChannel(param); channel != nil {
+			saveChannelAffinity(param, channel, selectGroup)
+			return channel, selectGroup, nil
+		}
+		selectGroup = param.TokenGroup
+	} else if param.GetRetry() == 0 {
+		if channel, selectGroup = getAffinityChannel(param); channel != nil {
+			saveChannelAffinity(param, channel, selectGroup)
+			return channel, selectGroup, nil
+		}
+		selectGroup = param.TokenGroup
+	}
+
 	if param.TokenGroup == "auto" {
 		if len(setting.GetAutoGroups()) == 0 {
 			return nil, selectGroup, errors.New("auto groups is not enabled")
@@ -158,5 +178,81 @@ func CacheGetRandomSatisfiedChannel(param *RetryParam) (*model.Channel, string,
 			return nil, param.TokenGroup, err
 		}
 	}
+	saveChannelAffinity(param, channel, selectGroup)
 	return channel, selectGroup, nil
 }
+
+// getFallbackChannel 按 ChannelFallbackChains 中失败渠道配置的顺序，返回第一个可用且未在本次请求中使用过的备用渠道，
+// 与常规选择一样跳过并发已满、退避中和被隔离的渠道
+func getFallbackChannel(param *RetryParam) (*model.Channel, string) {
+	chain := setting.GetChannelFallbackChain(param.FailedChannelId)
+	if len(chain) == 0 {
+		return nil, ""
+	}
+	group := param.TokenGroup
+	if group == "auto" {
+		group = common.GetContextKeyString(param.Ctx, constant.ContextKeyAutoGroup)
+		if group == "" {
+			return nil, ""
+		}
+	}
+	used := make(map[string]bool)
+	for _, channelId := range param.Ctx.GetStringSlice("use_channel") {
+		used[channelId] = true
+	}
+	for _, channelId := range chain {
+		if used[strconv.Itoa(channelId)] {
+			continue
+		}
+		channel, err := model.CacheGetChannel(channelId)
+		if err != nil || !isChannelServing(channel, group, param.ModelName) || model.IsChannelTemporarilyUnavailable(channelId) {
+			continue
+		}
+		logger.LogDebug(param.Ctx, "Fallback from channel #%d to channel #%d", param.FailedChannelId, channelId)
+		return channel, group
+	}
+	return nil, ""
+}
+
+// isChannelServing 判断渠道是否已启用且在指定分组下提供该模型
+func isChannelServing(channel *model.Channel, group string, modelName string) bool {
+	if channel.Status != common.ChannelStatusEnabled {
+		return false
+	}
+	if !slices.Contains(channel.GetGroups(), group) {
+		return false
+	}
+	models := channel.GetModels()
+	return slices.Contains(models, modelName) || slices.Contains(models, ratio_setting.FormatMatchingModelName(modelName))
+}
+
+// ValidateChannelModelSupport 在转发前校验渠道能否提供该模型：渠道声明了该模型，且模型重定向配置有效、不存在循环。
+// 校验失败说明是渠道配置问题，不应转发到上游后再由上游错误触发自动禁用。
+func ValidateChannelModelSupport(channel *model.Channel, modelName string) error {
+	models := channel.GetModels()
+	if !slices.Contains(models, modelName) && !slices.Contains(models, ratio_setting.FormatMatchingModelName(modelName)) {
+		return fmt.Errorf("渠道 #%d 不支持模型 %s", channel.Id, modelName)
+	}
+	modelMapping := channel.GetModelMapping()
+	if modelMapping == "" || modelMapping == "{}" {
+		return nil
+	}
+	modelMap := make(map[string]string)
+	if err := json.Unmarshal([]byte(modelMapping), &modelMap); err != nil {
+		return fmt.Errorf("渠道 #%d 的模型重定向配置无效: %s", channel.Id, err.Error())
+	}
+	// 与 ModelMappedHelper 相同的链式重定向，映射到自身视为结束
+	currentModel := modelName
+	visitedModels := map[string]bool{currentModel: true}
+	for {
+		mappedModel, exists := modelMap[currentModel]
+		if !exists || mappedModel == "" || mappedModel == currentModel {
+			return nil
+		}
+		if visitedModels[mappedModel] {
+			return fmt.Errorf("渠道 #%d 的模型 %s 重定向存在循环", channel.Id, modelName)
+		}
+		visitedModels[mappedModel] = true
+		currentModel = mappedModel
+	}
+}
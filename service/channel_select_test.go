@@ -0,0 +1,166 @@
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+func setFallbackChains(t *testing.T, chains map[int][]int) {
+	t.Helper()
+	data, err := common.Marshal(chains)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = setting.UpdateChannelFallbackChainsByJSONString(string(data)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = setting.UpdateChannelFallbackChainsByJSONString("{}") })
+}
+
+func fallbackRetryParam(failedChannelId int, used ...int) *RetryParam {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	var useChannel []string
+	for _, id := range used {
+		useChannel = append(useChannel, strconv.Itoa(id))
+	}
+	c.Set("use_channel", useChannel)
+	retry := 1
+	return &RetryParam{Ctx: c, TokenGroup: "default", ModelName: "gpt-4o", Retry: &retry, FailedChannelId: failedChannelId}
+}
+
+func TestFailedChannelRoutesToFallback(t *testing.T) {
+	setupTestDB(t)
+	primary := createTestChannel(t, "primary", "")
+	disabled := createTestChannel(t, "disabled", "")
+	if err := model.DB.Model(disabled).Update("status", common.ChannelStatusAutoDisabled).Error; err != nil {
+		t.Fatal(err)
+	}
+	otherModel := createTestChannel(t, "other-model", "")
+	if err := model.DB.Model(otherModel).Update("models", "claude-3").Error; err != nil {
+		t.Fatal(err)
+	}
+	backup1 := createTestChannel(t, "backup-1", "")
+	backup2 := createTestChannel(t, "backup-2", "")
+	setFallbackChains(t, map[int][]int{primary.Id: {disabled.Id, otherModel.Id, backup1.Id, backup2.Id}})
+
+	// 跳过已禁用和不提供该模型的渠道，按配置顺序选择第一个可用的备用渠道
+	channel, group, err := CacheGetRandomSatisfiedChannel(fallbackRetryParam(primary.Id, primary.Id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if channel == nil || channel.Id != backup1.Id || group != "default" {
+		t.Fatalf("fallback = %v in %q, want channel #%d", channel, group, backup1.Id)
+	}
+
+	// 本次请求已经用过的备用渠道不再选择
+	channel, _ = getFallbackChannel(fallbackRetryParam(primary.Id, primary.Id, backup1.Id))
+	if channel == nil || channel.Id != backup2.Id {
+		t.Fatalf("fallback = %v, want channel #%d", channel, backup2.Id)
+	}
+	// 备用渠道都不可用时交给常规的渠道选择
+	if channel, _ = getFallbackChannel(fallbackRetryParam(primary.Id, primary.Id, backup1.Id, backup2.Id)); channel != nil {
+		t.Fatalf("fallback = #%d, want none when the chain is exhausted", channel.Id)
+	}
+	if channel, _ = getFallbackChannel(fallbackRetryParam(backup1.Id, primary.Id)); channel != nil {
+		t.Fatalf("fallback = #%d for a channel without a chain, want none", channel.Id)
+	}
+}
+
+func TestFallbackSkipsBackoffAndQuarantine(t *testing.T) {
+	setupTestDB(t)
+	oldSeconds, oldThreshold := setting.ChannelQuarantineSeconds, setting.ChannelQuarantineErrorThreshold
+	setting.ChannelQuarantineSeconds, setting.ChannelQuarantineErrorThreshold = 60, 1
+	primary := createTestChannelWithId(t, 1221, "primary")
+	backingOff := createTestChannelWithId(t, 1222, "backing-off")
+	quarantined := createTestChannelWithId(t, 1223, "quarantined")
+	backup := createTestChannelWithId(t, 1224, "backup")
+	t.Cleanup(func() {
+		setting.ChannelQuarantineSeconds, setting.ChannelQuarantineErrorThreshold = oldSeconds, oldThreshold
+		model.ResetChannelFailureState(backingOff.Id)
+		model.ResetChannelFailureState(quarantined.Id)
+	})
+	setFallbackChains(t, map[int][]int{primary.Id: {backingOff.Id, quarantined.Id, backup.Id}})
+
+	model.SetChannelBackoffUntil(backingOff.Id, common.GetTimestamp()+600)
+	model.RecordChannelQuarantineError(quarantined.Id)
+	if !model.IsChannelQuarantined(quarantined.Id) {
+		t.Fatal("channel not quarantined")
+	}
+
+	channel, _ := getFallbackChannel(fallbackRetryParam(primary.Id, primary.Id))
+	if channel == nil || channel.Id != backup.Id {
+		t.Fatalf("fallback = %v, want channel #%d skipping backoff and quarantine", channel, backup.Id)
+	}
+}
+
+func TestValidateChannelModelSupport(t *testing.T) {
+	mapping := func(s string) *string { return &s }
+	cases := []struct {
+		name    string
+		channel *model.Channel
+		model   string
+		wantErr bool
+	}{
+		{"declared model", &model.Channel{Id: 1, Models: "gpt-4o,gpt-4o-mini"}, "gpt-4o", false},
+		{"undeclared model", &model.Channel{Id: 1, Models: "gpt-4o"}, "claude-3-opus", true},
+		{"mapped model", &model.Channel{Id: 1, Models: "gpt-4o", ModelMapping: mapping(`{"gpt-4o":"gpt-4o-2024-08-06"}`)}, "gpt-4o", false},
+		{"chained mapping", &model.Channel{Id: 1, Models: "a", ModelMapping: mapping(`{"a":"b","b":"c"}`)}, "a", false},
+		{"self mapping", &model.Channel{Id: 1, Models: "a", ModelMapping: mapping(`{"a":"a"}`)}, "a", false},
+		{"mapping loop", &model.Channel{Id: 1, Models: "a", ModelMapping: mapping(`{"a":"b","b":"a"}`)}, "a", true},
+		{"invalid mapping", &model.Channel{Id: 1, Models: "a", ModelMapping: mapping(`{"a":`)}, "a", true},
+	}
+	for _, tc := range cases {
+		if err := ValidateChannelModelSupport(tc.channel, tc.model); (err != nil) != tc.wantErr {
+			t.Errorf("%s: err = %v, want error %v", tc.name, err, tc.wantErr)
+		}
+	}
+}
+
+func TestModelNotSupportedDoesNotDisableChannel(t *testing.T) {
+	channel := &model.Channel{Id: 1, Models: "gpt-4o"}
+	err := ValidateChannelModelSupport(channel, "claude-3-opus")
+	if err == nil {
+		t.Fatal("unsupported model was accepted")
+	}
+	// 与 getChannel 中相同的方式包装校验错误
+	apiErr := types.NewErrorWithStatusCode(err, types.ErrorCodeChannelModelNotSupported, http.StatusBadRequest)
+	if ShouldDisableChannel(channel.Type, apiErr) {
+		t.Fatal("unsupported model disabled the channel")
+	}
+	if IsChannelFailure(apiErr) {
+		t.Fatal("unsupported model counted as a channel failure")
+	}
+}
+
+func TestPausedChannelExcludedFromSelection(t *testing.T) {
+	setupTestDB(t)
+	primary := createTestChannel(t, "primary", "")
+	paused := createTestChannel(t, "paused", "")
+	backup := createTestChannel(t, "backup", "")
+	if err := PauseChannelById(paused.Id, "maintenance", 0); err != nil {
+		t.Fatal(err)
+	}
+	setFallbackChains(t, map[int][]int{primary.Id: {paused.Id, backup.Id}})
+
+	channel, _ := getFallbackChannel(fallbackRetryParam(primary.Id, primary.Id))
+	if channel == nil || channel.Id != backup.Id {
+		t.Fatalf("fallback = %v, want channel #%d", channel, backup.Id)
+	}
+	var enabled int64
+	if err := model.DB.Model(&model.Ability{}).Where("channel_id = ? AND enabled = ?", paused.Id, true).Count(&enabled).Error; err != nil {
+		t.Fatal(err)
+	}
+	if enabled != 0 {
+		t.Fatalf("paused channel has %d enabled abilities, want 0", enabled)
+	}
+}
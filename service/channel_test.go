@@ -0,0 +1,675 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+// setupTestDB 使用内存 SQLite 作为数据库，测试结束后恢复
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	name := strings.NewReplacer("/", "_", " ", "_").Replace(t.Name())
+	db, err := gorm.Open(sqlite.Open(fmt.Sprintf("file:%s?mode=memory&cache=shared", name)), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	if err = db.AutoMigrate(&model.Channel{}, &model.Ability{}); err != nil {
+		t.Fatal(err)
+	}
+	oldDB, oldRedis, oldMemoryCache := model.DB, common.RedisEnabled(), common.MemoryCacheEnabled
+	model.DB = db
+	common.SetRedisEnabled(false)
+	common.MemoryCacheEnabled = false
+	t.Cleanup(func() {
+		model.DB, common.MemoryCacheEnabled = oldDB, oldMemoryCache
+		common.SetRedisEnabled(oldRedis)
+		_ = sqlDB.Close()
+	})
+}
+
+// createTestChannel 创建一个启用状态的渠道
+func createTestChannel(t *testing.T, name string, tag string) *model.Channel {
+	t.Helper()
+	channel := &model.Channel{Name: name, Key: "sk-" + name, Status: common.ChannelStatusEnabled, Models: "gpt-4o", Group: "default"}
+	if tag != "" {
+		channel.Tag = &tag
+	}
+	if err := model.DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.AddAbilities(nil); err != nil {
+		t.Fatal(err)
+	}
+	return channel
+}
+
+func channelStatus(t *testing.T, id int) int {
+	t.Helper()
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return channel.Status
+}
+
+func TestToggleChannelsByTag(t *testing.T) {
+	setupTestDB(t)
+	east1 := createTestChannel(t, "east-1", "azure-eastus")
+	east2 := createTestChannel(t, "east-2", "azure-eastus")
+	west := createTestChannel(t, "west", "azure-westus")
+
+	result, err := DisableChannelsByTag("azure-eastus", "regional outage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Updated) != 2 || len(result.Skipped) != 0 || len(result.Failed) != 0 {
+		t.Fatalf("disable result = %+v", result)
+	}
+	for _, id := range []int{east1.Id, east2.Id} {
+		if got := channelStatus(t, id); got != common.ChannelStatusManuallyDisabled {
+			t.Fatalf("channel #%d status = %d, want manually disabled", id, got)
+		}
+	}
+	if got := channelStatus(t, west.Id); got != common.ChannelStatusEnabled {
+		t.Fatalf("untagged channel status = %d, want enabled", got)
+	}
+
+	// 已是目标状态的渠道计入 skipped
+	if err = EnableChannelById(east2.Id); err != nil {
+		t.Fatal(err)
+	}
+	result, err = EnableChannelsByTag("azure-eastus")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Updated) != 1 || result.Updated[0] != east1.Id || len(result.Skipped) != 1 || result.Skipped[0] != east2.Id {
+		t.Fatalf("enable result = %+v", result)
+	}
+	if got := channelStatus(t, east1.Id); got != common.ChannelStatusEnabled {
+		t.Fatalf("channel #%d status = %d, want enabled", east1.Id, got)
+	}
+}
+
+func TestDisableChannelStoresStatusDetail(t *testing.T) {
+	setupTestDB(t)
+	channel := createTestChannel(t, "detail", "")
+
+	apiErr := types.WithOpenAIError(types.OpenAIError{Message: "invalid api key", Type: "invalid_request_error", Code: "invalid_api_key"}, http.StatusUnauthorized)
+	before := common.GetTimestamp()
+	DisableChannel(*types.NewChannelError(channel.Id, channel.Type, channel.Name, false, "", true), apiErr, "invalid api key")
+
+	stored, err := model.GetChannelById(channel.Id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != common.ChannelStatusAutoDisabled {
+		t.Fatalf("status = %d, want auto disabled", stored.Status)
+	}
+	detail := stored.GetStatusDetail()
+	if detail == nil {
+		t.Fatalf("missing status detail in other info: %s", stored.OtherInfo)
+	}
+	if detail.Reason != "invalid api key" || detail.Code != "invalid_api_key" || detail.StatusCode != http.StatusUnauthorized || detail.ErrorType != types.ErrorTypeOpenAIError {
+		t.Fatalf("status detail = %+v", detail)
+	}
+	if detail.Timestamp < before {
+		t.Fatalf("timestamp = %d, want >= %d", detail.Timestamp, before)
+	}
+	// 旧的纯文本字段仍然保留，兼容已有的展示
+	if info := stored.GetOtherInfo(); info["status_reason"] != "invalid api key" {
+		t.Fatalf("status_reason = %v", info["status_reason"])
+	}
+}
+
+func TestDisableChannelWithoutErrorStoresReasonOnly(t *testing.T) {
+	setupTestDB(t)
+	channel := createTestChannel(t, "balance", "")
+
+	DisableChannel(*types.NewChannelError(channel.Id, channel.Type, channel.Name, false, "", true), nil, "余额不足")
+
+	stored, err := model.GetChannelById(channel.Id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	detail := stored.GetStatusDetail()
+	if detail == nil || detail.Reason != "余额不足" || detail.Code != "" || detail.StatusCode != 0 || detail.ErrorType != "" {
+		t.Fatalf("status detail = %+v", detail)
+	}
+}
+
+func TestEnableChannelByIdClearsKeyStatus(t *testing.T) {
+	setupTestDB(t)
+	channel := &model.Channel{Name: "multi", Key: "sk-a\nsk-b", Status: common.ChannelStatusAutoDisabled, Models: "gpt-4o", Group: "default"}
+	channel.ChannelInfo = model.ChannelInfo{
+		IsMultiKey:             true,
+		MultiKeySize:           2,
+		MultiKeyStatusList:     map[int]int{0: common.ChannelStatusAutoDisabled, 1: common.ChannelStatusAutoDisabled},
+		MultiKeyDisabledReason: map[int]string{0: "invalid api key", 1: "invalid api key"},
+		MultiKeyDisabledTime:   map[int]int64{0: 1, 1: 1},
+	}
+	if err := model.DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.AddAbilities(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EnableChannelById(channel.Id); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := model.GetChannelById(channel.Id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Status != common.ChannelStatusEnabled {
+		t.Fatalf("status = %d, want enabled", stored.Status)
+	}
+	if len(stored.ChannelInfo.MultiKeyStatusList) != 0 || len(stored.ChannelInfo.MultiKeyDisabledReason) != 0 || len(stored.ChannelInfo.MultiKeyDisabledTime) != 0 {
+		t.Fatalf("key status not cleared: %+v", stored.ChannelInfo)
+	}
+
+	// 之前的密钥禁用状态已清空，单个密钥再次出错只禁用该密钥，不会立即禁用整个渠道
+	DisableChannel(*types.NewChannelError(channel.Id, channel.Type, channel.Name, true, "sk-a", true), nil, "invalid api key")
+	if got := channelStatus(t, channel.Id); got != common.ChannelStatusEnabled {
+		t.Fatalf("status after one key failed = %d, want enabled", got)
+	}
+}
+
+func TestEnableChannelByIdValidates(t *testing.T) {
+	setupTestDB(t)
+	channel := createTestChannel(t, "enabled", "")
+	if err := EnableChannelById(channel.Id); err == nil {
+		t.Fatal("enabling an enabled channel should fail")
+	}
+	if err := EnableChannelById(channel.Id + 100); err == nil {
+		t.Fatal("enabling a missing channel should fail")
+	}
+}
+
+func TestEnableChannelByIdAfterTimeoutDisable(t *testing.T) {
+	setupTestDB(t)
+	channel := createTestChannel(t, "timeout", "")
+	oldThreshold := setting.ModelTimeoutDisableThreshold
+	setting.ModelTimeoutDisableThreshold = 2
+	t.Cleanup(func() {
+		setting.ModelTimeoutDisableThreshold = oldThreshold
+		ResetChannelFailureCounters(channel.Id)
+	})
+
+	NewModelTimeoutError(channel.Id, "o1", 30)
+	apiErr := NewModelTimeoutError(channel.Id, "o1", 30)
+	if !ShouldDisableChannel(channel.Type, apiErr) {
+		t.Fatal("consecutive timeouts reaching the threshold should disable the channel")
+	}
+	DisableChannel(*types.NewChannelError(channel.Id, channel.Type, channel.Name, false, "", true), apiErr, "timeout")
+	model.SetChannelBackoffUntil(channel.Id, common.GetTimestamp()+600)
+	if got := channelStatus(t, channel.Id); got != common.ChannelStatusAutoDisabled {
+		t.Fatalf("status = %d, want auto disabled", got)
+	}
+
+	if err := EnableChannelById(channel.Id); err != nil {
+		t.Fatal(err)
+	}
+	if counts := GetModelTimeoutCounts(channel.Id); len(counts) != 0 {
+		t.Fatalf("timeout counts after enable = %v, want empty", counts)
+	}
+	if until := model.GetChannelBackoffUntil(channel.Id); until != 0 {
+		t.Fatalf("backoff after enable = %d, want cleared", until)
+	}
+	// 启用后重新计数，一次超时不会再次禁用渠道
+	if ShouldDisableChannel(channel.Type, NewModelTimeoutError(channel.Id, "o1", 30)) {
+		t.Fatal("a single timeout after manual enable disabled the channel again")
+	}
+}
+
+// upstreamErrorFromBody 按中继解析上游错误响应的方式构建错误
+func upstreamErrorFromBody(status int, body string) *types.NewAPIError {
+	resp := &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body))}
+	return RelayErrorHandler(context.Background(), resp, false)
+}
+
+func TestShouldDisableChannelByUpstreamCode(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		body   string
+		want   bool
+	}{
+		{"billing code", http.StatusBadRequest, `{"error":{"message":"You exceeded your current quota","type":"invalid_request_error","code":"billing_hard_limit_reached"}}`, true},
+		{"code case insensitive", http.StatusBadRequest, `{"error":{"message":"Incorrect API key","type":"invalid_request_error","code":"Invalid_API_Key"}}`, true},
+		{"claude type", http.StatusBadRequest, `{"type":"error","error":{"type":"account_deactivated","message":"This account has been deactivated"}}`, true},
+		{"other code", http.StatusBadRequest, `{"error":{"message":"context too long","type":"invalid_request_error","code":"context_length_exceeded"}}`, false},
+		{"no code", http.StatusBadRequest, `{"error":{"message":"billing_hard_limit_reached mentioned in text only","type":"invalid_request_error"}}`, false},
+	}
+	for _, tc := range cases {
+		if got := ShouldDisableChannel(1, upstreamErrorFromBody(tc.status, tc.body)); got != tc.want {
+			t.Errorf("%s: ShouldDisableChannel = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestShouldDisableChannelByConfiguredCode(t *testing.T) {
+	old := setting.ChannelDisableErrorCodesToString()
+	t.Cleanup(func() { setting.ChannelDisableErrorCodesFromString(old) })
+	setting.ChannelDisableErrorCodesFromString("organization_suspended\n")
+
+	suspended := upstreamErrorFromBody(http.StatusBadRequest, `{"error":{"message":"suspended","type":"invalid_request_error","code":"organization_suspended"}}`)
+	if !ShouldDisableChannel(1, suspended) {
+		t.Fatal("configured code should disable the channel")
+	}
+	billing := upstreamErrorFromBody(http.StatusBadRequest, `{"error":{"message":"over quota","type":"invalid_request_error","code":"billing_hard_limit_reached"}}`)
+	if ShouldDisableChannel(1, billing) {
+		t.Fatal("code removed from the configuration should not disable the channel")
+	}
+}
+
+func TestShouldDisableChannelByTypeRegex(t *testing.T) {
+	old := setting.ChannelDisableRegexByType2JSONString()
+	t.Cleanup(func() { _ = setting.UpdateChannelDisableRegexByTypeByJSONString(old) })
+	if err := setting.UpdateChannelDisableRegexByTypeByJSONString(`{"14": ["(?i)organization .* has been disabled"]}`); err != nil {
+		t.Fatal(err)
+	}
+
+	disabled := upstreamErrorFromBody(http.StatusBadRequest, `{"type":"error","error":{"type":"invalid_request_error","message":"This Organization org-123 has been disabled."}}`)
+	if !ShouldDisableChannel(constant.ChannelTypeAnthropic, disabled) {
+		t.Fatal("error matching the channel type regex should disable the channel")
+	}
+	// 正则只对配置的渠道类型生效
+	if ShouldDisableChannel(constant.ChannelTypeOpenAI, disabled) {
+		t.Fatal("regex of another channel type should not disable the channel")
+	}
+	other := upstreamErrorFromBody(http.StatusBadRequest, `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long"}}`)
+	if ShouldDisableChannel(constant.ChannelTypeAnthropic, other) {
+		t.Fatal("error not matching the regex should not disable the channel")
+	}
+}
+
+// setupChannelSuccessRate 开启按成功率禁用：成功率低于 90% 且样本数至少 10 时禁用
+func setupChannelSuccessRate(t *testing.T) {
+	t.Helper()
+	oldAuto, oldRate, oldSamples := common.AutomaticDisableChannelEnabled, setting.ChannelMinSuccessRate, setting.ChannelSuccessRateMinSamples
+	common.AutomaticDisableChannelEnabled = true
+	setting.ChannelMinSuccessRate = 0.9
+	setting.ChannelSuccessRateMinSamples = 10
+	t.Cleanup(func() {
+		common.AutomaticDisableChannelEnabled, setting.ChannelMinSuccessRate, setting.ChannelSuccessRateMinSamples = oldAuto, oldRate, oldSamples
+	})
+}
+
+// recordOutcomes 为渠道记录指定次数的成功与失败
+func recordOutcomes(channelId int, success int, failure int) {
+	for i := 0; i < success; i++ {
+		model.RecordChannelOutcome(channelId, true)
+	}
+	for i := 0; i < failure; i++ {
+		model.RecordChannelOutcome(channelId, false)
+	}
+}
+
+func TestShouldDisableChannelBySuccessRate(t *testing.T) {
+	setupChannelSuccessRate(t)
+
+	// 成功率 70%，低于阈值
+	recordOutcomes(1281, 14, 6)
+	disable, reason := ShouldDisableChannelBySuccessRate(1281)
+	if !disable {
+		t.Fatal("channel below the success-rate threshold should be disabled")
+	}
+	if !strings.Contains(reason, "70.00%") || !strings.Contains(reason, "90.00%") {
+		t.Errorf("reason = %q, want it to mention the rate and the threshold", reason)
+	}
+
+	// 成功率 95%，高于阈值
+	recordOutcomes(1282, 19, 1)
+	if disable, _ := ShouldDisableChannelBySuccessRate(1282); disable {
+		t.Fatal("channel above the success-rate threshold should stay enabled")
+	}
+
+	// 样本数不足时不判断
+	recordOutcomes(1283, 2, 7)
+	if disable, _ := ShouldDisableChannelBySuccessRate(1283); disable {
+		t.Fatal("channel with too few samples should stay enabled")
+	}
+}
+
+func TestShouldDisableChannelBySuccessRateDisabled(t *testing.T) {
+	setupChannelSuccessRate(t)
+	recordOutcomes(1284, 0, 20)
+
+	setting.ChannelMinSuccessRate = 0
+	if disable, _ := ShouldDisableChannelBySuccessRate(1284); disable {
+		t.Fatal("success-rate check should be off when ChannelMinSuccessRate is 0")
+	}
+}
+
+func TestSuccessRateResetWhenChannelEnabled(t *testing.T) {
+	setupTestDB(t)
+	setupChannelSuccessRate(t)
+	channel := createTestChannel(t, "flaky", "")
+
+	recordOutcomes(channel.Id, 0, 20)
+	if disable, _ := ShouldDisableChannelBySuccessRate(channel.Id); !disable {
+		t.Fatal("channel failing every request should be disabled")
+	}
+	if _, err := model.SetChannelStatus(channel.Id, common.ChannelStatusAutoDisabled, "success rate"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := model.SetChannelStatus(channel.Id, common.ChannelStatusEnabled, ""); err != nil {
+		t.Fatal(err)
+	}
+	// 重新启用后此前的失败不再计入
+	if rate, samples := model.GetChannelSuccessRate(channel.Id); rate != 1 || samples != 0 {
+		t.Fatalf("success rate after re-enable = %v over %d samples, want 1 over 0", rate, samples)
+	}
+}
+
+func TestIsChannelFailure(t *testing.T) {
+	cases := []struct {
+		name string
+		err  *types.NewAPIError
+		want bool
+	}{
+		{"upstream 500", types.NewErrorWithStatusCode(errors.New("boom"), types.ErrorCodeBadResponseStatusCode, http.StatusInternalServerError), true},
+		{"upstream 401", types.NewErrorWithStatusCode(errors.New("bad key"), types.ErrorCodeBadResponseStatusCode, http.StatusUnauthorized), true},
+		{"upstream 429", types.NewErrorWithStatusCode(errors.New("slow down"), types.ErrorCodeBadResponseStatusCode, http.StatusTooManyRequests), true},
+		{"client 400", types.NewErrorWithStatusCode(errors.New("bad request"), types.ErrorCodeBadResponseStatusCode, http.StatusBadRequest), false},
+		{"model not supported", types.NewErrorWithStatusCode(errors.New("unsupported"), types.ErrorCodeChannelModelNotSupported, http.StatusInternalServerError), false},
+	}
+	for _, tc := range cases {
+		if got := IsChannelFailure(tc.err); got != tc.want {
+			t.Errorf("%s: IsChannelFailure = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestPauseAndResumeChannel(t *testing.T) {
+	setupTestDB(t)
+	channel := createTestChannel(t, "maintenance", "")
+	resumeAt := common.GetTimestamp() + 3600
+
+	if err := PauseChannelById(channel.Id, "scheduled maintenance", resumeAt); err != nil {
+		t.Fatal(err)
+	}
+	paused, err := model.GetChannelById(channel.Id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paused.Status != common.ChannelStatusPaused {
+		t.Fatalf("status = %d, want paused", paused.Status)
+	}
+	detail := paused.GetStatusDetail()
+	if detail == nil || detail.Reason != "scheduled maintenance" || detail.ResumeAt != resumeAt {
+		t.Fatalf("status detail = %+v", detail)
+	}
+	if err = PauseChannelById(channel.Id, "again", 0); err == nil {
+		t.Fatal("pausing a paused channel should fail")
+	}
+
+	// 自动禁用不会覆盖暂停状态
+	DisableChannel(*types.NewChannelError(channel.Id, channel.Type, channel.Name, false, "", true), nil, "upstream error")
+	if got := channelStatus(t, channel.Id); got != common.ChannelStatusPaused {
+		t.Fatalf("status after auto-disable = %d, want paused", got)
+	}
+
+	if err = ResumeChannelById(channel.Id); err != nil {
+		t.Fatal(err)
+	}
+	if got := channelStatus(t, channel.Id); got != common.ChannelStatusEnabled {
+		t.Fatalf("status after resume = %d, want enabled", got)
+	}
+	if err = ResumeChannelById(channel.Id); err == nil {
+		t.Fatal("resuming an enabled channel should fail")
+	}
+}
+
+func TestPauseChannelValidatesResumeTime(t *testing.T) {
+	setupTestDB(t)
+	channel := createTestChannel(t, "maintenance", "")
+	if err := PauseChannelById(channel.Id, "maintenance", common.GetTimestamp()-1); err == nil {
+		t.Fatal("resume time in the past should be rejected")
+	}
+	if got := channelStatus(t, channel.Id); got != common.ChannelStatusEnabled {
+		t.Fatalf("status = %d, want enabled", got)
+	}
+}
+
+func TestResumeDuePausedChannels(t *testing.T) {
+	setupTestDB(t)
+	due := createTestChannel(t, "due", "")
+	later := createTestChannel(t, "later", "")
+	manual := createTestChannel(t, "manual", "")
+	now := common.GetTimestamp()
+	for _, tc := range []struct {
+		id       int
+		resumeAt int64
+	}{{due.Id, now + 60}, {later.Id, now + 3600}, {manual.Id, 0}} {
+		if err := PauseChannelById(tc.id, "maintenance", tc.resumeAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !resumeDuePausedChannels(now + 120) {
+		t.Fatal("channel past its resume time should be resumed")
+	}
+	if got := channelStatus(t, due.Id); got != common.ChannelStatusEnabled {
+		t.Fatalf("due channel status = %d, want enabled", got)
+	}
+	for _, id := range []int{later.Id, manual.Id} {
+		if got := channelStatus(t, id); got != common.ChannelStatusPaused {
+			t.Fatalf("channel #%d status = %d, want paused", id, got)
+		}
+	}
+	if resumeDuePausedChannels(now + 120) {
+		t.Fatal("no channel should be resumed twice")
+	}
+}
+
+func TestFailuresAfterReEnableWithinGrace(t *testing.T) {
+	setupTestDB(t)
+	old := setting.ChannelPostEnableGraceFailures
+	setting.ChannelPostEnableGraceFailures = 2
+	t.Cleanup(func() { setting.ChannelPostEnableGraceFailures = old })
+	channel := createTestChannel(t, "recovering", "")
+	channelError := *types.NewChannelError(channel.Id, channel.Type, channel.Name, false, "", true)
+
+	if _, err := model.SetChannelStatus(channel.Id, common.ChannelStatusAutoDisabled, "upstream error"); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableChannelById(channel.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	// 宽限次数内的失败只记录日志
+	for i := 1; i <= 2; i++ {
+		model.RecordChannelOutcome(channel.Id, false)
+		DisableChannel(channelError, nil, "upstream error")
+		if got := channelStatus(t, channel.Id); got != common.ChannelStatusEnabled {
+			t.Fatalf("status after failure %d = %d, want enabled within grace", i, got)
+		}
+	}
+	// 超出宽限次数后再次禁用
+	model.RecordChannelOutcome(channel.Id, false)
+	DisableChannel(channelError, nil, "upstream error")
+	if got := channelStatus(t, channel.Id); got != common.ChannelStatusAutoDisabled {
+		t.Fatalf("status after grace = %d, want auto disabled", got)
+	}
+}
+
+func TestFailureAfterReEnableWithoutGrace(t *testing.T) {
+	setupTestDB(t)
+	channel := createTestChannel(t, "recovering", "")
+	if _, err := model.SetChannelStatus(channel.Id, common.ChannelStatusAutoDisabled, "upstream error"); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnableChannelById(channel.Id); err != nil {
+		t.Fatal(err)
+	}
+	model.RecordChannelOutcome(channel.Id, false)
+	DisableChannel(*types.NewChannelError(channel.Id, channel.Type, channel.Name, false, "", true), nil, "upstream error")
+	if got := channelStatus(t, channel.Id); got != common.ChannelStatusAutoDisabled {
+		t.Fatalf("status = %d, want auto disabled when grace is off", got)
+	}
+}
+
+// failChannelQueries 使接下来的 n 次渠道查询返回错误，模拟数据库暂时不可用
+func failChannelQueries(t *testing.T, n int) {
+	t.Helper()
+	err := model.DB.Callback().Query().Before("gorm:query").Register("test:fail_channel_query", func(db *gorm.DB) {
+		if n > 0 && db.Statement.Table == "channels" {
+			n--
+			_ = db.AddError(errors.New("database is locked"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDisableChannelRetriesTransientError(t *testing.T) {
+	setupTestDB(t)
+	channel := createTestChannel(t, "transient", "")
+	failChannelQueries(t, 1)
+
+	DisableChannel(*types.NewChannelError(channel.Id, channel.Type, channel.Name, false, "", true), nil, "upstream error")
+	if got := channelStatus(t, channel.Id); got != common.ChannelStatusAutoDisabled {
+		t.Fatalf("status after retry = %d, want auto disabled", got)
+	}
+}
+
+func TestDisableChannelPermanentFailure(t *testing.T) {
+	setupTestDB(t)
+	channel := createTestChannel(t, "broken", "")
+	// 直接调用一次，DisableChannel 再重试 channelStatusUpdateAttempts 次
+	failChannelQueries(t, 1+channelStatusUpdateAttempts)
+
+	if _, err := model.UpdateChannelStatusWithDetail(channel.Id, "", common.ChannelStatusAutoDisabled, types.NewChannelStatusDetail("x", nil)); err == nil || errors.Is(err, model.ErrChannelNotFound) {
+		t.Fatalf("error = %v, want a transient database error", err)
+	}
+	// 每次重试都失败后放弃，渠道状态不变
+	start := time.Now()
+	DisableChannel(*types.NewChannelError(channel.Id, channel.Type, channel.Name, false, "", true), nil, "upstream error")
+	if elapsed := time.Since(start); elapsed < channelStatusUpdateRetryInterval {
+		t.Fatalf("DisableChannel returned after %v, want it to retry", elapsed)
+	}
+	if got := channelStatus(t, channel.Id); got != common.ChannelStatusEnabled {
+		t.Fatalf("status = %d, want enabled", got)
+	}
+}
+
+func TestDisableChannelNotFound(t *testing.T) {
+	setupTestDB(t)
+	_, err := model.UpdateChannelStatusWithDetail(404, "", common.ChannelStatusAutoDisabled, types.NewChannelStatusDetail("x", nil))
+	if !errors.Is(err, model.ErrChannelNotFound) {
+		t.Fatalf("error = %v, want ErrChannelNotFound", err)
+	}
+	// 渠道不存在时不重试
+	start := time.Now()
+	DisableChannel(*types.NewChannelError(404, 1, "missing", false, "", true), nil, "upstream error")
+	if elapsed := time.Since(start); elapsed >= channelStatusUpdateRetryInterval {
+		t.Fatalf("DisableChannel took %v for a missing channel, want no retry", elapsed)
+	}
+}
+
+// createTestChannelWithId 以指定 ID 创建渠道。部分状态按渠道 ID 保存在进程内，使用独立的 ID 避免受其它测试影响
+func createTestChannelWithId(t *testing.T, id int, name string) *model.Channel {
+	t.Helper()
+	channel := &model.Channel{Id: id, Name: name, Key: "sk-" + name, Status: common.ChannelStatusEnabled, Models: "gpt-4o", Group: "default"}
+	if err := model.DB.Create(channel).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := channel.AddAbilities(nil); err != nil {
+		t.Fatal(err)
+	}
+	return channel
+}
+
+func TestFlappingChannelHeldDisabled(t *testing.T) {
+	setupTestDB(t)
+	oldThreshold, oldWindow, oldHold := setting.ChannelFlapThreshold, setting.ChannelFlapWindowSeconds, setting.ChannelFlapHoldSeconds
+	setting.ChannelFlapThreshold, setting.ChannelFlapWindowSeconds, setting.ChannelFlapHoldSeconds = 3, 3600, 1800
+	t.Cleanup(func() {
+		setting.ChannelFlapThreshold, setting.ChannelFlapWindowSeconds, setting.ChannelFlapHoldSeconds = oldThreshold, oldWindow, oldHold
+	})
+	channel := createTestChannelWithId(t, 1541, "flappy")
+	channelError := *types.NewChannelError(channel.Id, channel.Type, channel.Name, false, "", true)
+
+	// 禁用、启用、再禁用：第三次切换达到阈值，进入延长冷却
+	DisableChannel(channelError, nil, "upstream error")
+	if model.GetChannelFlapHold(channel.Id) != 0 {
+		t.Fatal("channel held after its first disable")
+	}
+	if err := EnableChannelById(channel.Id); err != nil {
+		t.Fatalf("first re-enable: %v", err)
+	}
+	DisableChannel(channelError, nil, "upstream error")
+	until := model.GetChannelFlapHold(channel.Id)
+	if until == 0 {
+		t.Fatal("flapping channel not held")
+	}
+	if want := common.GetTimestamp() + int64(setting.ChannelFlapHoldSeconds); until < want-5 || until > want {
+		t.Fatalf("hold until %d, want about %d", until, want)
+	}
+
+	if err := EnableChannelById(channel.Id); err == nil {
+		t.Fatal("re-enable during the flap hold succeeded")
+	}
+	if got := channelStatus(t, channel.Id); got != common.ChannelStatusAutoDisabled {
+		t.Fatalf("status = %d, want still auto disabled", got)
+	}
+
+	// 冷却结束后可以再次启用
+	model.SetChannelFlapHold(channel.Id, common.GetTimestamp()-1)
+	if err := EnableChannelById(channel.Id); err != nil {
+		t.Fatalf("re-enable after the hold: %v", err)
+	}
+}
+
+func TestFlapDetectionDisabled(t *testing.T) {
+	setupTestDB(t)
+	channel := createTestChannelWithId(t, 1542, "steady")
+	channelError := *types.NewChannelError(channel.Id, channel.Type, channel.Name, false, "", true)
+
+	for i := 0; i < 3; i++ {
+		DisableChannel(channelError, nil, "upstream error")
+		if err := EnableChannelById(channel.Id); err != nil {
+			t.Fatalf("re-enable %d with flap detection off: %v", i+1, err)
+		}
+	}
+	if model.GetChannelFlapHold(channel.Id) != 0 {
+		t.Fatal("channel held with ChannelFlapThreshold = 0")
+	}
+}
+
+func TestShouldDisableChannelIgnoresGlobalSwitch(t *testing.T) {
+	old := common.AutomaticDisableChannelEnabled
+	t.Cleanup(func() { common.AutomaticDisableChannelEnabled = old })
+
+	// 是否允许自动禁用由渠道的 ShouldAutoDisable 决定，这里只判断错误本身
+	body := `{"error":{"message":"Incorrect API key","type":"invalid_request_error","code":"invalid_api_key"}}`
+	for _, global := range []bool{true, false} {
+		common.AutomaticDisableChannelEnabled = global
+		if !ShouldDisableChannel(1, upstreamErrorFromBody(http.StatusUnauthorized, body)) {
+			t.Errorf("global switch %v: invalid key error not treated as disabling", global)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+)
+
+// CostEstimator 在转发前估算请求将消耗的额度（未乘分组倍率），用于额度预检查及按额度的限制。
+// 默认使用模型价格/倍率表估算，需要自定义计价时可通过 SetCostEstimator 替换。
+type CostEstimator interface {
+	Estimate(model string, requestBody []byte) (int, error)
+}
+
+var (
+	costEstimatorMutex sync.RWMutex
+	costEstimator      CostEstimator = DefaultCostEstimator{}
+)
+
+// SetCostEstimator 替换全局使用的额度估算器，传入 nil 时恢复默认实现
+func SetCostEstimator(estimator CostEstimator) {
+	costEstimatorMutex.Lock()
+	defer costEstimatorMutex.Unlock()
+	if estimator == nil {
+		estimator = DefaultCostEstimator{}
+	}
+	costEstimator = estimator
+}
+
+// GetCostEstimator 获取当前使用的额度估算器
+func GetCostEstimator() CostEstimator {
+	costEstimatorMutex.RLock()
+	defer costEstimatorMutex.RUnlock()
+	return costEstimator
+}
+
+// costEstimateRequest 估算时只关心请求中的文本内容与最大输出长度
+type costEstimateRequest struct {
+	MaxTokens           int   `json:"max_tokens"`
+	MaxCompletionTokens int   `json:"max_completion_tokens"`
+	MaxOutputTokens     int   `json:"max_output_tokens"`
+	System              any   `json:"system"`
+	Messages            []any `json:"messages"`
+	Prompt              any   `json:"prompt"`
+	Input               any   `json:"input"`
+}
+
+// DefaultCostEstimator 按模型价格表估算：按次计费的模型直接使用价格，按量计费的模型与预扣费一致，
+// 使用 max(输入 token 数, PreConsumedQuota) 加上最大输出 token 数乘以模型倍率
+type DefaultCostEstimator struct{}
+
+func (DefaultCostEstimator) Estimate(model string, requestBody []byte) (int, error) {
+	if price, usePrice := ratio_setting.GetModelPrice(model, false); usePrice {
+		return int(price * common.QuotaPerUnit), nil
+	}
+	modelRatio, success, matchName := ratio_setting.GetModelRatio(model)
+	if !success {
+		return 0, fmt.Errorf("model %s ratio or price not set", matchName)
+	}
+	var request costEstimateRequest
+	if len(requestBody) > 0 {
+		if err := common.Unmarshal(requestBody, &request); err != nil {
+			return 0, err
+		}
+	}
+	var text strings.Builder
+	collectEstimateText(&text, request.System)
+	collectEstimateText(&text, request.Messages)
+	collectEstimateText(&text, request.Prompt)
+	collectEstimateText(&text, request.Input)
+	tokens := common.Max(CountTextToken(text.String(), model), common.PreConsumedQuota)
+	tokens += common.Max(request.MaxTokens, common.Max(request.MaxCompletionTokens, request.MaxOutputTokens))
+	return int(float64(tokens) * modelRatio), nil
+}
+
+// collectEstimateText 收集请求中的文本内容，图片、音频等非文本内容不参与估算
+func collectEstimateText(text *strings.Builder, v any) {
+	switch value := v.(type) {
+	case string:
+		text.WriteString(value)
+	case []any:
+		for _, item := range value {
+			collectEstimateText(text, item)
+		}
+	case map[string]any:
+		collectEstimateText(text, value["text"])
+		collectEstimateText(text, value["content"])
+	}
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/setting/ratio_setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stubCostEstimator 返回固定的估算结果，并记录收到的模型与请求体
+type stubCostEstimator struct {
+	quota int
+	err   error
+	model string
+	body  string
+}
+
+func (s *stubCostEstimator) Estimate(model string, requestBody []byte) (int, error) {
+	s.model, s.body = model, string(requestBody)
+	return s.quota, s.err
+}
+
+func useCostEstimator(t *testing.T, estimator CostEstimator) {
+	t.Helper()
+	SetCostEstimator(estimator)
+	t.Cleanup(func() { SetCostEstimator(nil) })
+}
+
+// precheckContext 构建带有请求体的上下文
+func precheckContext(t *testing.T, body string) *gin.Context {
+	t.Helper()
+	old := constant.MaxRequestBodyMB
+	constant.MaxRequestBodyMB = 8
+	t.Cleanup(func() { constant.MaxRequestBodyMB = old })
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+	return c
+}
+
+func TestQuotaPrecheckUsesCostEstimator(t *testing.T) {
+	common.SetRedisEnabled(false)
+	setting.TokenDailyQuotaCredits = 1000
+	t.Cleanup(func() { setting.TokenDailyQuotaCredits = 0 })
+	model.IncreaseTokenDailyQuotaUsed(1341, 600)
+	stub := &stubCostEstimator{quota: 200}
+	useCostEstimator(t, stub)
+
+	info := &relaycommon.RelayInfo{TokenId: 1341, OriginModelName: "gpt-4o"}
+	info.PriceData.GroupRatioInfo.GroupRatio = 1
+	body := `{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`
+	if apiErr := QuotaPrecheck(precheckContext(t, body), info); apiErr != nil {
+		t.Fatalf("estimate within the remaining quota rejected: %v", apiErr)
+	}
+	if stub.model != "gpt-4o" || stub.body != body {
+		t.Fatalf("estimator got model %q body %q", stub.model, stub.body)
+	}
+
+	// 估算结果乘以分组倍率后超出剩余额度
+	info.PriceData.GroupRatioInfo.GroupRatio = 3
+	apiErr := QuotaPrecheck(precheckContext(t, body), info)
+	if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeTokenDailyQuotaExceeded {
+		t.Fatalf("over-estimate error = %v, want %s", apiErr, types.ErrorCodeTokenDailyQuotaExceeded)
+	}
+
+	// 估算失败时不阻断请求
+	stub.err = errors.New("pricing unavailable")
+	if apiErr = QuotaPrecheck(precheckContext(t, body), info); apiErr != nil {
+		t.Fatalf("estimator failure blocked the request: %v", apiErr)
+	}
+}
+
+func TestQuotaPrecheckSkippedWithoutDailyQuota(t *testing.T) {
+	stub := &stubCostEstimator{quota: 1 << 30}
+	useCostEstimator(t, stub)
+	info := &relaycommon.RelayInfo{TokenId: 1342, OriginModelName: "gpt-4o"}
+	if apiErr := QuotaPrecheck(precheckContext(t, `{}`), info); apiErr != nil {
+		t.Fatalf("precheck without a daily quota rejected: %v", apiErr)
+	}
+	if stub.model != "" {
+		t.Fatal("estimator should not be called when no quota limit is set")
+	}
+}
+
+func TestSetCostEstimatorNilRestoresDefault(t *testing.T) {
+	SetCostEstimator(&stubCostEstimator{})
+	SetCostEstimator(nil)
+	if _, ok := GetCostEstimator().(DefaultCostEstimator); !ok {
+		t.Fatalf("estimator = %T, want DefaultCostEstimator", GetCostEstimator())
+	}
+}
+
+func TestDefaultCostEstimator(t *testing.T) {
+	oldPrice, oldRatio := ratio_setting.ModelPrice2JSONString(), ratio_setting.ModelRatio2JSONString()
+	t.Cleanup(func() {
+		_ = ratio_setting.UpdateModelPriceByJSONString(oldPrice)
+		_ = ratio_setting.UpdateModelRatioByJSONString(oldRatio)
+	})
+	if err := ratio_setting.UpdateModelPriceByJSONString(`{"flat-model":0.01}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := ratio_setting.UpdateModelRatioByJSONString(`{"ratio-model":2}`); err != nil {
+		t.Fatal(err)
+	}
+	estimator := DefaultCostEstimator{}
+
+	quota, err := estimator.Estimate("flat-model", nil)
+	if err != nil || quota != int(0.01*common.QuotaPerUnit) {
+		t.Fatalf("flat-price estimate = %d, %v", quota, err)
+	}
+	// 输入较短时按 PreConsumedQuota 计，加上最大输出 token 数后乘以模型倍率
+	quota, err = estimator.Estimate("ratio-model", []byte(`{"messages":[{"role":"user","content":"hi"}],"max_tokens":100}`))
+	if err != nil || quota != (common.PreConsumedQuota+100)*2 {
+		t.Fatalf("ratio estimate = %d, %v, want %d", quota, err, (common.PreConsumedQuota+100)*2)
+	}
+	if _, err = estimator.Estimate("unpriced-model", nil); err == nil {
+		t.Fatal("model without price or ratio should fail to estimate")
+	}
+}
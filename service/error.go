@@ -10,11 +10,64 @@ import (
 	"strings"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/dto"
 	"github.com/QuantumNous/new-api/logger"
+	"github.com/QuantumNous/new-api/setting"
 	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
 )
 
+// WriteErrorResponse 按 ErrorFormat 输出错误响应，默认（openai）时使用接口原生的格式
+func WriteErrorResponse(c *gin.Context, err *types.NewAPIError, relayFormat types.RelayFormat) {
+	channel := ErrorChannelForCaller(c)
+	switch setting.ErrorFormat {
+	case setting.ErrorFormatProblemJSON:
+		problem := err.ToProblemDetails()
+		problem.Channel = channel
+		body, marshalErr := common.Marshal(problem)
+		if marshalErr != nil {
+			common.SysLog("failed to marshal problem details: " + marshalErr.Error())
+		}
+		c.Data(err.StatusCode, "application/problem+json", body)
+		return
+	case setting.ErrorFormatAnthropic:
+		relayFormat = types.RelayFormatClaude
+	}
+	var body gin.H
+	if relayFormat == types.RelayFormatClaude {
+		body = gin.H{
+			"type":  "error",
+			"error": err.ToClaudeError(),
+		}
+	} else {
+		body = gin.H{
+			"error": err.ToOpenAIError(),
+		}
+	}
+	if channel != nil {
+		body["channel"] = channel
+	}
+	c.JSON(err.StatusCode, body)
+}
+
+// ErrorChannelForCaller 开启 ExposeChannelInError 且调用方为管理员时返回处理该请求的渠道信息，否则返回 nil
+func ErrorChannelForCaller(c *gin.Context) *types.ErrorChannel {
+	if !setting.ExposeChannelInError || common.GetContextKeyInt(c, constant.ContextKeyUserRole) < common.RoleAdminUser {
+		return nil
+	}
+	channelId := common.GetContextKeyInt(c, constant.ContextKeyChannelId)
+	if channelId == 0 {
+		return nil
+	}
+	label := common.GetContextKeyString(c, constant.ContextKeyChannelTag)
+	if label == "" {
+		label = common.GetContextKeyString(c, constant.ContextKeyChannelName)
+	}
+	return &types.ErrorChannel{Id: channelId, Label: label}
+}
+
 func MidjourneyErrorWrapper(code int, desc string) *dto.MidjourneyResponse {
 	return &dto.MidjourneyResponse{
 		Code:        code,
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/types"
+
+	"github.com/gin-gonic/gin"
+)
+
+// failedRelayContext 返回由渠道 #7 处理、以 role 身份调用的请求上下文
+func failedRelayContext(role int, tag string) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	common.SetContextKey(c, constant.ContextKeyUserRole, role)
+	common.SetContextKey(c, constant.ContextKeyChannelId, 7)
+	common.SetContextKey(c, constant.ContextKeyChannelName, "azure-east-1")
+	common.SetContextKey(c, constant.ContextKeyChannelTag, tag)
+	return c, w
+}
+
+func setExposeChannelInError(t *testing.T, enabled bool) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	old := setting.ExposeChannelInError
+	setting.ExposeChannelInError = enabled
+	t.Cleanup(func() { setting.ExposeChannelInError = old })
+}
+
+func TestErrorChannelForCaller(t *testing.T) {
+	setExposeChannelInError(t, true)
+
+	c, _ := failedRelayContext(common.RoleAdminUser, "eastus")
+	if got := ErrorChannelForCaller(c); got == nil || got.Id != 7 || got.Label != "eastus" {
+		t.Fatalf("admin caller got %+v, want channel 7 labelled eastus", got)
+	}
+	c, _ = failedRelayContext(common.RoleRootUser, "")
+	if got := ErrorChannelForCaller(c); got == nil || got.Label != "azure-east-1" {
+		t.Fatalf("untagged channel got %+v, want the channel name as label", got)
+	}
+	c, _ = failedRelayContext(common.RoleCommonUser, "eastus")
+	if got := ErrorChannelForCaller(c); got != nil {
+		t.Fatalf("common user got %+v, want nil", got)
+	}
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	common.SetContextKey(c, constant.ContextKeyUserRole, common.RoleAdminUser)
+	if got := ErrorChannelForCaller(c); got != nil {
+		t.Fatalf("request without a channel got %+v, want nil", got)
+	}
+
+	setting.ExposeChannelInError = false
+	c, _ = failedRelayContext(common.RoleAdminUser, "eastus")
+	if got := ErrorChannelForCaller(c); got != nil {
+		t.Fatalf("disabled setting got %+v, want nil", got)
+	}
+}
+
+func TestWriteErrorResponseExposesChannelToAdmins(t *testing.T) {
+	setExposeChannelInError(t, true)
+	oldFormat := setting.ErrorFormat
+	t.Cleanup(func() { setting.ErrorFormat = oldFormat })
+
+	upstreamErr := types.NewErrorWithStatusCode(errors.New("bad gateway"), types.ErrorCodeBadResponseStatusCode, http.StatusBadGateway)
+	cases := []struct {
+		format      string
+		relayFormat types.RelayFormat
+	}{
+		{setting.ErrorFormatOpenAI, types.RelayFormatOpenAI},
+		{setting.ErrorFormatOpenAI, types.RelayFormatClaude},
+		{setting.ErrorFormatProblemJSON, types.RelayFormatOpenAI},
+	}
+	for _, tc := range cases {
+		setting.ErrorFormat = tc.format
+		for role, wantChannel := range map[int]bool{common.RoleAdminUser: true, common.RoleCommonUser: false} {
+			c, w := failedRelayContext(role, "eastus")
+			WriteErrorResponse(c, upstreamErr, tc.relayFormat)
+			var body struct {
+				Channel *types.ErrorChannel `json:"channel"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("%s/%v: invalid body %q: %v", tc.format, tc.relayFormat, w.Body.String(), err)
+			}
+			if got := body.Channel != nil; got != wantChannel {
+				t.Fatalf("%s/%v role %d: channel present = %v, want %v (body %s)", tc.format, tc.relayFormat, role, got, wantChannel, w.Body.String())
+			}
+			if wantChannel && (body.Channel.Id != 7 || body.Channel.Label != "eastus") {
+				t.Fatalf("%s/%v: channel = %+v, want 7 eastus", tc.format, tc.relayFormat, body.Channel)
+			}
+		}
+	}
+}
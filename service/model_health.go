@@ -0,0 +1,72 @@
+package service
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/dto"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/bytedance/gopkg/util/gopool"
+)
+
+// 各模型最近一次成功率告警的时间，同一模型在一个统计窗口内只告警一次
+var (
+	modelAlertMutex sync.Mutex
+	modelAlertAt    = map[string]int64{}
+)
+
+// ModelHealth 模型整体成功率及告警状态
+type ModelHealth struct {
+	model.ModelSuccessRate
+	Degraded    bool  `json:"degraded"`
+	LastAlertAt int64 `json:"last_alert_at"`
+}
+
+// isModelDegraded 模型在统计窗口内的样本数达到 ChannelSuccessRateMinSamples 且成功率低于 ModelMinSuccessRate
+func isModelDegraded(rate float64, samples int) bool {
+	return setting.ModelMinSuccessRate > 0 && samples >= setting.ChannelSuccessRateMinSamples && rate < setting.ModelMinSuccessRate
+}
+
+// CheckModelSuccessRate 模型在所有渠道上的整体成功率低于 ModelMinSuccessRate 时记录日志并通知管理员。
+// 渠道层面的自动禁用只能发现单个渠道的问题，所有渠道同时失败通常说明模型本身或上游服务异常
+func CheckModelSuccessRate(modelName string) bool {
+	rate, samples := model.GetModelSuccessRate(modelName)
+	if !isModelDegraded(rate, samples) {
+		return false
+	}
+	now := time.Now().Unix()
+	modelAlertMutex.Lock()
+	if now-modelAlertAt[modelName] < int64(setting.ChannelSuccessRateWindowSeconds) {
+		modelAlertMutex.Unlock()
+		return true
+	}
+	modelAlertAt[modelName] = now
+	modelAlertMutex.Unlock()
+
+	common.SysError(fmt.Sprintf("model %s degraded: success rate %.2f%% over last %d requests is below %.2f%%", modelName, rate*100, samples, setting.ModelMinSuccessRate*100))
+	subject := fmt.Sprintf("模型「%s」成功率过低", modelName)
+	content := fmt.Sprintf("模型「%s」在最近 %d 秒内所有渠道的整体成功率为 %.2f%%（%d 次请求），低于告警阈值 %.2f%%", modelName, setting.ChannelSuccessRateWindowSeconds, rate*100, samples, setting.ModelMinSuccessRate*100)
+	gopool.Go(func() {
+		NotifyRootUser(dto.NotifyTypeModelDegraded, subject, content)
+	})
+	return true
+}
+
+// GetModelHealth 返回统计窗口内有样本的各模型的整体成功率及是否低于告警阈值
+func GetModelHealth() []ModelHealth {
+	rates := model.GetModelSuccessRates()
+	health := make([]ModelHealth, 0, len(rates))
+	modelAlertMutex.Lock()
+	defer modelAlertMutex.Unlock()
+	for _, rate := range rates {
+		health = append(health, ModelHealth{
+			ModelSuccessRate: rate,
+			Degraded:         isModelDegraded(rate.Rate, rate.Samples),
+			LastAlertAt:      modelAlertAt[rate.Model],
+		})
+	}
+	return health
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func recordModelOutcomes(modelName string, success, failure int) {
+	for i := 0; i < success; i++ {
+		model.RecordModelOutcome(modelName, true)
+	}
+	for i := 0; i < failure; i++ {
+		model.RecordModelOutcome(modelName, false)
+	}
+}
+
+func setModelMinSuccessRate(t *testing.T, rate float64, minSamples int) {
+	t.Helper()
+	oldRate, oldMinSamples := setting.ModelMinSuccessRate, setting.ChannelSuccessRateMinSamples
+	setting.ModelMinSuccessRate, setting.ChannelSuccessRateMinSamples = rate, minSamples
+	t.Cleanup(func() {
+		setting.ModelMinSuccessRate, setting.ChannelSuccessRateMinSamples = oldRate, oldMinSamples
+	})
+}
+
+func TestCheckModelSuccessRate(t *testing.T) {
+	setupTestDB(t)
+	setModelMinSuccessRate(t, 0.8, 10)
+
+	recordModelOutcomes("test-176-healthy", 9, 1)
+	if CheckModelSuccessRate("test-176-healthy") {
+		t.Fatal("model at 90% success reported degraded")
+	}
+	// 样本数不足时不告警
+	recordModelOutcomes("test-176-few", 0, 5)
+	if CheckModelSuccessRate("test-176-few") {
+		t.Fatal("model below the minimum samples reported degraded")
+	}
+
+	recordModelOutcomes("test-176-degraded", 6, 4)
+	if !CheckModelSuccessRate("test-176-degraded") {
+		t.Fatal("model at 60% success not reported degraded")
+	}
+	modelAlertMutex.Lock()
+	alertAt := modelAlertAt["test-176-degraded"]
+	modelAlertMutex.Unlock()
+	if alertAt == 0 {
+		t.Fatal("alert time not recorded")
+	}
+	// 同一统计窗口内只告警一次，但仍然视为降级
+	if !CheckModelSuccessRate("test-176-degraded") {
+		t.Fatal("degraded model no longer reported after the first alert")
+	}
+	modelAlertMutex.Lock()
+	repeatAt := modelAlertAt["test-176-degraded"]
+	modelAlertMutex.Unlock()
+	if repeatAt != alertAt {
+		t.Fatal("alert repeated within the window")
+	}
+
+	health := map[string]ModelHealth{}
+	for _, h := range GetModelHealth() {
+		health[h.Model] = h
+	}
+	if h := health["test-176-degraded"]; !h.Degraded || h.LastAlertAt != alertAt || h.Samples != 10 {
+		t.Fatalf("degraded model health = %+v", h)
+	}
+	if h := health["test-176-healthy"]; h.Degraded || h.LastAlertAt != 0 {
+		t.Fatalf("healthy model health = %+v", h)
+	}
+}
+
+func TestModelSuccessRateAlertDisabled(t *testing.T) {
+	setModelMinSuccessRate(t, 0, 1)
+
+	recordModelOutcomes("test-176-off", 0, 10)
+	if CheckModelSuccessRate("test-176-off") {
+		t.Fatal("model reported degraded with ModelMinSuccessRate 0")
+	}
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/types"
+)
+
+// 各渠道各模型连续超时的次数，请求成功后清零
+var (
+	modelTimeoutCountMutex sync.Mutex
+	modelTimeoutCount      = map[string]int{}
+)
+
+func modelTimeoutCountKey(channelId int, modelName string) string {
+	return fmt.Sprintf("%d:%s", channelId, modelName)
+}
+
+// NewModelTimeoutError 记录一次渠道上的模型请求超时，并返回带有 ErrorCodeChannelModelRequestTimeout 的错误
+func NewModelTimeoutError(channelId int, modelName string, timeout int) *types.NewAPIError {
+	key := modelTimeoutCountKey(channelId, modelName)
+	modelTimeoutCountMutex.Lock()
+	modelTimeoutCount[key]++
+	consecutive := modelTimeoutCount[key]
+	modelTimeoutCountMutex.Unlock()
+
+	return types.NewErrorWithStatusCode(&types.ModelTimeoutError{
+		ChannelId:   channelId,
+		ModelName:   modelName,
+		Timeout:     timeout,
+		Consecutive: consecutive,
+	}, types.ErrorCodeChannelModelRequestTimeout, http.StatusGatewayTimeout)
+}
+
+// ResetModelTimeoutCount 渠道上的模型请求成功后清零连续超时次数
+func ResetModelTimeoutCount(channelId int, modelName string) {
+	modelTimeoutCountMutex.Lock()
+	defer modelTimeoutCountMutex.Unlock()
+	delete(modelTimeoutCount, modelTimeoutCountKey(channelId, modelName))
+}
+
+// GetModelTimeoutCounts 返回渠道上各模型当前连续超时的次数
+func GetModelTimeoutCounts(channelId int) map[string]int {
+	prefix := fmt.Sprintf("%d:", channelId)
+	counts := make(map[string]int)
+	modelTimeoutCountMutex.Lock()
+	defer modelTimeoutCountMutex.Unlock()
+	for key, count := range modelTimeoutCount {
+		if modelName, ok := strings.CutPrefix(key, prefix); ok {
+			counts[modelName] = count
+		}
+	}
+	return counts
+}
+
+// ResetModelTimeoutCounts 清零渠道上所有模型的连续超时次数
+func ResetModelTimeoutCounts(channelId int) {
+	prefix := fmt.Sprintf("%d:", channelId)
+	modelTimeoutCountMutex.Lock()
+	defer modelTimeoutCountMutex.Unlock()
+	for key := range modelTimeoutCount {
+		if strings.HasPrefix(key, prefix) {
+			delete(modelTimeoutCount, key)
+		}
+	}
+}
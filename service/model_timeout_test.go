@@ -0,0 +1,72 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+func TestModelTimeoutErrorTagging(t *testing.T) {
+	t.Cleanup(func() { ResetModelTimeoutCounts(1231) })
+
+	apiErr := NewModelTimeoutError(1231, "o1", 30)
+	if apiErr.GetErrorCode() != types.ErrorCodeChannelModelRequestTimeout || apiErr.StatusCode != http.StatusGatewayTimeout {
+		t.Fatalf("error = %s %d, want %s 504", apiErr.GetErrorCode(), apiErr.StatusCode, types.ErrorCodeChannelModelRequestTimeout)
+	}
+	var timeoutErr *types.ModelTimeoutError
+	if !errors.As(apiErr, &timeoutErr) {
+		t.Fatalf("error %v does not wrap ModelTimeoutError", apiErr)
+	}
+	if timeoutErr.ChannelId != 1231 || timeoutErr.ModelName != "o1" || timeoutErr.Timeout != 30 || timeoutErr.Consecutive != 1 {
+		t.Fatalf("timeout error = %+v", timeoutErr)
+	}
+
+	NewModelTimeoutError(1231, "o1", 30)
+	NewModelTimeoutError(1231, "gpt-4o", 30)
+	counts := GetModelTimeoutCounts(1231)
+	if counts["o1"] != 2 || counts["gpt-4o"] != 1 {
+		t.Fatalf("timeout counts = %v, want o1:2 gpt-4o:1", counts)
+	}
+	// 请求成功后清零该模型的连续超时次数
+	ResetModelTimeoutCount(1231, "o1")
+	if counts := GetModelTimeoutCounts(1231); counts["o1"] != 0 || counts["gpt-4o"] != 1 {
+		t.Fatalf("timeout counts after reset = %v", counts)
+	}
+}
+
+func TestShouldDisableChannelOnPersistentTimeouts(t *testing.T) {
+	t.Cleanup(func() {
+		ResetModelTimeoutCounts(1232)
+		setting.ModelTimeoutDisableThreshold = 0
+	})
+
+	// 未配置阈值时超时不会禁用渠道
+	if ShouldDisableChannel(1, NewModelTimeoutError(1232, "o1", 30)) {
+		t.Fatal("timeout disabled the channel without a threshold")
+	}
+	ResetModelTimeoutCount(1232, "o1")
+
+	setting.ModelTimeoutDisableThreshold = 3
+	for i := 1; i < 3; i++ {
+		if ShouldDisableChannel(1, NewModelTimeoutError(1232, "o1", 30)) {
+			t.Fatalf("timeout %d disabled the channel before the threshold", i)
+		}
+	}
+	// 其他模型的超时单独计数
+	if ShouldDisableChannel(1, NewModelTimeoutError(1232, "gpt-4o", 30)) {
+		t.Fatal("timeout of another model counted toward the threshold")
+	}
+	if !ShouldDisableChannel(1, NewModelTimeoutError(1232, "o1", 30)) {
+		t.Fatal("consecutive timeouts reaching the threshold should disable the channel")
+	}
+	// 触发禁用后计数清零，渠道重新启用后重新计数
+	if counts := GetModelTimeoutCounts(1232); counts["o1"] != 0 || counts["gpt-4o"] != 1 {
+		t.Fatalf("timeout counts after disable = %v, want o1 cleared", counts)
+	}
+	if ShouldDisableChannel(1, NewModelTimeoutError(1232, "o1", 30)) {
+		t.Fatal("first timeout after the disable counted toward the old streak")
+	}
+}
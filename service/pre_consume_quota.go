@@ -5,9 +5,11 @@ import (
 	"net/http"
 
 	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/constant"
 	"github.com/QuantumNous/new-api/logger"
 	"github.com/QuantumNous/new-api/model"
 	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting"
 	"github.com/QuantumNous/new-api/types"
 
 	"github.com/bytedance/gopkg/util/gopool"
@@ -31,6 +33,9 @@ func ReturnPreConsumedQuota(c *gin.Context, relayInfo *relaycommon.RelayInfo) {
 // PreConsumeQuota checks if the user has enough quota to pre-consume.
 // It returns the pre-consumed quota if successful, or an error if not.
 func PreConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
+	if apiErr := QuotaPrecheck(c, relayInfo); apiErr != nil {
+		return apiErr
+	}
 	userQuota, err := model.GetUserQuota(relayInfo.UserId, false)
 	if err != nil {
 		return types.NewError(err, types.ErrorCodeQueryDataError, types.ErrOptionWithSkipRetry())
@@ -77,3 +82,68 @@ func PreConsumeQuota(c *gin.Context, preConsumedQuota int, relayInfo *relaycommo
 	relayInfo.FinalPreConsumedQuota = preConsumedQuota
 	return nil
 }
+
+// QuotaPrecheck 转发前用 CostEstimator 估算本次请求的消耗（乘以分组倍率），提前拦截会超出按额度限制的请求。
+// 估算失败时不阻断请求，仅按已消耗的额度判断。
+func QuotaPrecheck(c *gin.Context, relayInfo *relaycommon.RelayInfo) *types.NewAPIError {
+	if (setting.TokenDailyQuotaCredits <= 0 && setting.TokenBillingPeriodQuotaCredits <= 0) || relayInfo.TokenId <= 0 {
+		return nil
+	}
+	estimatedQuota := 0
+	body, err := common.GetRequestBody(c)
+	if err == nil {
+		estimatedQuota, err = GetCostEstimator().Estimate(relayInfo.OriginModelName, body)
+	}
+	if err != nil {
+		logger.LogWarn(c, fmt.Sprintf("failed to estimate request cost: %s", err.Error()))
+		estimatedQuota = 0
+	}
+	estimatedQuota = int(float64(estimatedQuota) * relayInfo.PriceData.GroupRatioInfo.GroupRatio)
+	if apiErr := checkTokenDailyQuota(relayInfo, estimatedQuota); apiErr != nil {
+		return apiErr
+	}
+	return checkTokenPeriodQuota(relayInfo, common.GetContextKeyInt(c, constant.ContextKeyTokenBillingAnchorDay), estimatedQuota)
+}
+
+// checkTokenDailyQuota 检查令牌当日消耗是否超过每日额度上限。
+// 实际消耗在请求结束后才能确定，这里用预扣费额度估算，提前拦截明显会超限的请求。
+func checkTokenDailyQuota(relayInfo *relaycommon.RelayInfo, estimatedQuota int) *types.NewAPIError {
+	limit := setting.TokenDailyQuotaCredits
+	if limit <= 0 || relayInfo.TokenId <= 0 {
+		return nil
+	}
+	used, err := model.GetTokenDailyQuotaUsed(relayInfo.TokenId)
+	if err != nil {
+		// 统计不可用时不阻断请求
+		common.SysLog(fmt.Sprintf("failed to get daily quota used of token %d: %s", relayInfo.TokenId, err.Error()))
+		return nil
+	}
+	if used >= limit {
+		return types.NewErrorWithStatusCode(fmt.Errorf("令牌今日额度已用完, 每日额度: %s, 已使用: %s", logger.FormatQuota(limit), logger.FormatQuota(used)), types.ErrorCodeTokenDailyQuotaExceeded, setting.RateLimitRejectStatusCode, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	if estimatedQuota > 0 && used+estimatedQuota > limit {
+		return types.NewErrorWithStatusCode(fmt.Errorf("令牌今日剩余额度不足, 剩余额度: %s, 本次预估消耗: %s", logger.FormatQuota(limit-used), logger.FormatQuota(estimatedQuota)), types.ErrorCodeTokenDailyQuotaExceeded, setting.RateLimitRejectStatusCode, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	return nil
+}
+
+// checkTokenPeriodQuota 检查令牌在当前账单周期的消耗是否超过周期额度上限，判断方式同 checkTokenDailyQuota
+func checkTokenPeriodQuota(relayInfo *relaycommon.RelayInfo, anchorDay int, estimatedQuota int) *types.NewAPIError {
+	limit := setting.TokenBillingPeriodQuotaCredits
+	if limit <= 0 || relayInfo.TokenId <= 0 {
+		return nil
+	}
+	used, window, err := model.GetTokenPeriodQuotaUsed(relayInfo.TokenId, anchorDay)
+	if err != nil {
+		common.SysLog(fmt.Sprintf("failed to get billing period quota used of token %d: %s", relayInfo.TokenId, err.Error()))
+		return nil
+	}
+	reset := window.Reset.Format("2006-01-02 15:04")
+	if used >= limit {
+		return types.NewErrorWithStatusCode(fmt.Errorf("令牌本账单周期额度已用完, 周期额度: %s, 已使用: %s, 将于 %s 重置", logger.FormatQuota(limit), logger.FormatQuota(used), reset), types.ErrorCodeTokenPeriodQuotaExceeded, setting.RateLimitRejectStatusCode, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	if estimatedQuota > 0 && used+estimatedQuota > limit {
+		return types.NewErrorWithStatusCode(fmt.Errorf("令牌本账单周期剩余额度不足, 剩余额度: %s, 本次预估消耗: %s, 将于 %s 重置", logger.FormatQuota(limit-used), logger.FormatQuota(estimatedQuota), reset), types.ErrorCodeTokenPeriodQuotaExceeded, setting.RateLimitRejectStatusCode, types.ErrOptionWithSkipRetry(), types.ErrOptionWithNoRecordErrorLog())
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	relaycommon "github.com/QuantumNous/new-api/relay/common"
+	"github.com/QuantumNous/new-api/setting"
+	"github.com/QuantumNous/new-api/types"
+)
+
+func TestCheckTokenDailyQuota(t *testing.T) {
+	common.SetRedisEnabled(false)
+	setting.TokenDailyQuotaCredits = 1000
+	t.Cleanup(func() { setting.TokenDailyQuotaCredits = 0 })
+	info := &relaycommon.RelayInfo{TokenId: 1085}
+
+	model.IncreaseTokenDailyQuotaUsed(1085, 600)
+	if apiErr := checkTokenDailyQuota(info, 300); apiErr != nil {
+		t.Fatalf("request within quota rejected: %v", apiErr)
+	}
+	// 预估消耗会超过剩余额度，提前拦截
+	apiErr := checkTokenDailyQuota(info, 500)
+	if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeTokenDailyQuotaExceeded {
+		t.Fatalf("over-estimate error = %v, want %s", apiErr, types.ErrorCodeTokenDailyQuotaExceeded)
+	}
+	if apiErr.StatusCode != setting.RateLimitRejectStatusCode {
+		t.Fatalf("status = %d, want %d", apiErr.StatusCode, setting.RateLimitRejectStatusCode)
+	}
+
+	// 实际消耗累计到上限后，即使预估为 0 也拒绝
+	model.IncreaseTokenDailyQuotaUsed(1085, 400)
+	if apiErr := checkTokenDailyQuota(info, 0); apiErr == nil {
+		t.Fatal("request after reaching the daily quota was allowed")
+	}
+	if apiErr := checkTokenDailyQuota(&relaycommon.RelayInfo{TokenId: 1086}, 300); apiErr != nil {
+		t.Fatalf("other token rejected: %v", apiErr)
+	}
+}
+
+func TestCheckTokenPeriodQuota(t *testing.T) {
+	common.SetRedisEnabled(false)
+	setting.TokenBillingPeriodQuotaCredits = 1000
+	t.Cleanup(func() { setting.TokenBillingPeriodQuotaCredits = 0 })
+	info := &relaycommon.RelayInfo{TokenId: 1695}
+
+	model.IncreaseTokenPeriodQuotaUsed(1695, 31, 600)
+	if apiErr := checkTokenPeriodQuota(info, 31, 300); apiErr != nil {
+		t.Fatalf("request within quota rejected: %v", apiErr)
+	}
+	apiErr := checkTokenPeriodQuota(info, 31, 500)
+	if apiErr == nil || apiErr.GetErrorCode() != types.ErrorCodeTokenPeriodQuotaExceeded {
+		t.Fatalf("over-estimate error = %v, want %s", apiErr, types.ErrorCodeTokenPeriodQuotaExceeded)
+	}
+	if apiErr.StatusCode != setting.RateLimitRejectStatusCode {
+		t.Fatalf("status = %d, want %d", apiErr.StatusCode, setting.RateLimitRejectStatusCode)
+	}
+
+	model.IncreaseTokenPeriodQuotaUsed(1695, 31, 400)
+	if apiErr := checkTokenPeriodQuota(info, 31, 0); apiErr == nil {
+		t.Fatal("request after reaching the billing period quota was allowed")
+	}
+	if apiErr := checkTokenPeriodQuota(&relaycommon.RelayInfo{TokenId: 1696}, 31, 300); apiErr != nil {
+		t.Fatalf("other token rejected: %v", apiErr)
+	}
+}
@@ -0,0 +1,133 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/QuantumNous/new-api/common"
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+// providerRateLimitResets 各上游的「剩余额度 / 重置时间」响应头，剩余额度为 0 时按重置时间退避
+var providerRateLimitResets = [][2]string{
+	{"x-ratelimit-remaining-requests", "x-ratelimit-reset-requests"},                 // OpenAI，重置时间为时长，如 "6m0s"
+	{"x-ratelimit-remaining-tokens", "x-ratelimit-reset-tokens"},                     // OpenAI
+	{"anthropic-ratelimit-requests-remaining", "anthropic-ratelimit-requests-reset"}, // Anthropic，重置时间为 RFC 3339
+	{"anthropic-ratelimit-tokens-remaining", "anthropic-ratelimit-tokens-reset"},     // Anthropic
+	{"anthropic-ratelimit-input-tokens-remaining", "anthropic-ratelimit-input-tokens-reset"},
+	{"anthropic-ratelimit-output-tokens-remaining", "anthropic-ratelimit-output-tokens-reset"},
+	{"x-ratelimit-remaining", "x-ratelimit-reset"}, // 通用，重置时间为秒数或时间戳
+}
+
+// parseProviderResetTime 解析上游返回的重置时间，支持时长（"1s"、"6m0s"）、秒数、Unix 时间戳、RFC 3339 与 HTTP 日期
+func parseProviderResetTime(value string, now time.Time) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, false
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if seconds <= 0 {
+			return time.Time{}, false
+		}
+		// 足够大的数值视为 Unix 时间戳
+		if seconds > 1e9 {
+			return time.Unix(int64(seconds), 0), true
+		}
+		return now.Add(time.Duration(seconds * float64(time.Second))), true
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(d), d > 0
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// providerBackoffUntil 根据上游响应计算渠道应退避到的时间：额度已用尽的维度取最晚的重置时间，
+// 429 响应没有可用的重置头时参考 Retry-After
+func providerBackoffUntil(resp *http.Response, now time.Time) (time.Time, bool) {
+	var until time.Time
+	for _, pair := range providerRateLimitResets {
+		remaining := strings.TrimSpace(resp.Header.Get(pair[0]))
+		if remaining != "0" {
+			continue
+		}
+		if t, ok := parseProviderResetTime(resp.Header.Get(pair[1]), now); ok && t.After(until) {
+			until = t
+		}
+	}
+	if until.IsZero() && resp.StatusCode == http.StatusTooManyRequests {
+		if t, ok := parseProviderResetTime(resp.Header.Get("x-ratelimit-reset"), now); ok {
+			until = t
+		} else if t, ok := parseProviderResetTime(resp.Header.Get("Retry-After"), now); ok {
+			until = t
+		}
+	}
+	if !until.After(now) {
+		return time.Time{}, false
+	}
+	// 防止异常的响应头让渠道长时间不可用
+	if maxBackoff := now.Add(time.Duration(setting.ChannelProviderBackoffMaxSeconds) * time.Second); until.After(maxBackoff) {
+		until = maxBackoff
+	}
+	return until, true
+}
+
+// 各上游的「剩余请求数 / 请求数上限 / 重置时间」响应头
+var providerRequestQuotaHeaders = [][3]string{
+	{"x-ratelimit-remaining-requests", "x-ratelimit-limit-requests", "x-ratelimit-reset-requests"},                         // OpenAI
+	{"anthropic-ratelimit-requests-remaining", "anthropic-ratelimit-requests-limit", "anthropic-ratelimit-requests-reset"}, // Anthropic
+	{"x-ratelimit-remaining", "x-ratelimit-limit", "x-ratelimit-reset"},                                                    // 通用
+}
+
+// 上游没有返回重置时间时，剩余额度记录的有效时长
+const providerQuotaDefaultTTL = time.Minute
+
+// observeProviderRequestQuota 记录上游报告的剩余请求额度，供选择渠道时在额度用尽前降低权重
+func observeProviderRequestQuota(channelId int, resp *http.Response, now time.Time) {
+	for _, headers := range providerRequestQuotaHeaders {
+		remaining, err := strconv.ParseInt(strings.TrimSpace(resp.Header.Get(headers[0])), 10, 64)
+		if err != nil || remaining < 0 {
+			continue
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(resp.Header.Get(headers[1])), 10, 64)
+		if err != nil || limit <= 0 {
+			continue
+		}
+		expireAt := now.Add(providerQuotaDefaultTTL)
+		if t, ok := parseProviderResetTime(resp.Header.Get(headers[2]), now); ok && t.After(now) {
+			expireAt = t
+		}
+		model.SetChannelProviderQuota(channelId, remaining, limit, expireAt.Unix())
+		return
+	}
+}
+
+// ObserveProviderRateLimit 读取上游响应中的限流头：记录剩余请求额度，额度已用尽时让渠道退避到上游指定的重置时间
+func ObserveProviderRateLimit(channelId int, resp *http.Response) {
+	if channelId == 0 || resp == nil {
+		return
+	}
+	if setting.ChannelProviderThrottlePercent > 0 {
+		observeProviderRequestQuota(channelId, resp, time.Now())
+	}
+	if !setting.ChannelProviderBackoffEnabled {
+		return
+	}
+	until, ok := providerBackoffUntil(resp, time.Now())
+	if !ok {
+		return
+	}
+	model.SetChannelBackoffUntil(channelId, until.Unix())
+	if common.DebugEnabled {
+		common.SysLog(fmt.Sprintf("channel #%d backing off until %s as indicated by provider rate limit headers", channelId, until.Format(time.RFC3339)))
+	}
+}
@@ -0,0 +1,112 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/QuantumNous/new-api/model"
+	"github.com/QuantumNous/new-api/setting"
+)
+
+func providerResponse(status int, headers map[string]string) *http.Response {
+	resp := &http.Response{StatusCode: status, Header: http.Header{}}
+	for k, v := range headers {
+		resp.Header.Set(k, v)
+	}
+	return resp
+}
+
+func TestProviderBackoffUntil(t *testing.T) {
+	old := setting.ChannelProviderBackoffMaxSeconds
+	setting.ChannelProviderBackoffMaxSeconds = 600
+	t.Cleanup(func() { setting.ChannelProviderBackoffMaxSeconds = old })
+	now := time.Unix(1700000000, 0)
+
+	cases := []struct {
+		name    string
+		status  int
+		headers map[string]string
+		want    time.Duration // 0 表示不退避
+	}{
+		{"openai requests exhausted", http.StatusOK, map[string]string{"x-ratelimit-remaining-requests": "0", "x-ratelimit-reset-requests": "6m0s"}, 6 * time.Minute},
+		{"openai latest exhausted reset", http.StatusOK, map[string]string{
+			"x-ratelimit-remaining-requests": "0", "x-ratelimit-reset-requests": "1s",
+			"x-ratelimit-remaining-tokens": "0", "x-ratelimit-reset-tokens": "20s",
+		}, 20 * time.Second},
+		{"anthropic rfc3339", http.StatusOK, map[string]string{"anthropic-ratelimit-requests-remaining": "0", "anthropic-ratelimit-requests-reset": now.Add(90 * time.Second).Format(time.RFC3339)}, 90 * time.Second},
+		{"generic unix timestamp", http.StatusOK, map[string]string{"x-ratelimit-remaining": "0", "x-ratelimit-reset": "1700000045"}, 45 * time.Second},
+		{"429 retry-after", http.StatusTooManyRequests, map[string]string{"Retry-After": "30"}, 30 * time.Second},
+		{"capped at max", http.StatusOK, map[string]string{"x-ratelimit-remaining-requests": "0", "x-ratelimit-reset-requests": "2h"}, 10 * time.Minute},
+		{"remaining quota", http.StatusOK, map[string]string{"x-ratelimit-remaining-requests": "5", "x-ratelimit-reset-requests": "6m0s"}, 0},
+		{"reset in the past", http.StatusOK, map[string]string{"x-ratelimit-remaining": "0", "x-ratelimit-reset": "1699999000"}, 0},
+		{"no headers", http.StatusOK, nil, 0},
+	}
+	for _, tc := range cases {
+		until, ok := providerBackoffUntil(providerResponse(tc.status, tc.headers), now)
+		if tc.want == 0 {
+			if ok {
+				t.Errorf("%s: backoff until %v, want none", tc.name, until)
+			}
+			continue
+		}
+		if !ok || !until.Equal(now.Add(tc.want)) {
+			t.Errorf("%s: backoff until %v (%v), want %v", tc.name, until, ok, now.Add(tc.want))
+		}
+	}
+}
+
+func TestObserveProviderRateLimitBacksOffChannel(t *testing.T) {
+	resp := providerResponse(http.StatusTooManyRequests, map[string]string{"x-ratelimit-remaining-requests": "0", "x-ratelimit-reset-requests": "30s"})
+
+	ObserveProviderRateLimit(1495, resp)
+	if model.IsChannelBackingOff(1495) {
+		t.Fatal("channel backed off with provider backoff disabled")
+	}
+
+	setting.ChannelProviderBackoffEnabled = true
+	t.Cleanup(func() { setting.ChannelProviderBackoffEnabled = false })
+	before := time.Now().Unix()
+	ObserveProviderRateLimit(1495, resp)
+	until := model.GetChannelBackoffUntil(1495)
+	if until < before+29 || until > time.Now().Unix()+30 {
+		t.Fatalf("backoff until = %d, want about 30s from now", until)
+	}
+}
+
+func TestObserveProviderRateLimitRecordsRemainingQuota(t *testing.T) {
+	resp := providerResponse(http.StatusOK, map[string]string{"x-ratelimit-remaining-requests": "900", "x-ratelimit-limit-requests": "1000", "x-ratelimit-reset-requests": "30s"})
+	ObserveProviderRateLimit(1814, resp)
+	if _, _, ok := model.GetChannelProviderQuota(1814); ok {
+		t.Fatal("provider quota recorded with throttle disabled")
+	}
+
+	old := setting.ChannelProviderThrottlePercent
+	setting.ChannelProviderThrottlePercent = 20
+	t.Cleanup(func() {
+		setting.ChannelProviderThrottlePercent = old
+		model.SetChannelProviderQuota(1814, 0, 0, 0)
+	})
+	// 上游报告的剩余额度逐步减少，最后一次的记录生效
+	for _, remaining := range []string{"900", "300", "50"} {
+		ObserveProviderRateLimit(1814, providerResponse(http.StatusOK, map[string]string{
+			"x-ratelimit-remaining-requests": remaining, "x-ratelimit-limit-requests": "1000", "x-ratelimit-reset-requests": "30s",
+		}))
+	}
+	remaining, limit, ok := model.GetChannelProviderQuota(1814)
+	if !ok || remaining != 50 || limit != 1000 {
+		t.Fatalf("provider quota = %d/%d (%v), want 50/1000", remaining, limit, ok)
+	}
+
+	// Anthropic 的响应头同样记录；缺少总额度时忽略
+	ObserveProviderRateLimit(1814, providerResponse(http.StatusOK, map[string]string{
+		"anthropic-ratelimit-requests-remaining": "5", "anthropic-ratelimit-requests-limit": "50",
+	}))
+	if remaining, limit, _ = model.GetChannelProviderQuota(1814); remaining != 5 || limit != 50 {
+		t.Fatalf("anthropic provider quota = %d/%d, want 5/50", remaining, limit)
+	}
+	ObserveProviderRateLimit(1814, providerResponse(http.StatusOK, map[string]string{"x-ratelimit-remaining-requests": "1"}))
+	if remaining, _, _ = model.GetChannelProviderQuota(1814); remaining != 5 {
+		t.Fatalf("provider quota without limit header overwrote remaining to %d", remaining)
+	}
+}
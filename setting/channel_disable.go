@@ -0,0 +1,205 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 上游错误中的 code/type 命中以下取值时自动禁用渠道（不区分大小写）
+var ChannelDisableErrorCodes = []string{
+	"billing_hard_limit_reached",
+	"billing_not_active",
+	"account_deactivated",
+	"invalid_api_key",
+}
+
+func ChannelDisableErrorCodesToString() string {
+	return strings.Join(ChannelDisableErrorCodes, "\n")
+}
+
+func ChannelDisableErrorCodesFromString(s string) {
+	ChannelDisableErrorCodes = []string{}
+	for _, code := range strings.Split(s, "\n") {
+		code = strings.ToLower(strings.TrimSpace(code))
+		if code != "" {
+			ChannelDisableErrorCodes = append(ChannelDisableErrorCodes, code)
+		}
+	}
+}
+
+// IsChannelDisableErrorCode 判断上游错误码是否配置为需要禁用渠道
+func IsChannelDisableErrorCode(code string) bool {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return false
+	}
+	for _, c := range ChannelDisableErrorCodes {
+		if strings.ToLower(c) == code {
+			return true
+		}
+	}
+	return false
+}
+
+// 按渠道类型配置的正则表达式，上游错误信息匹配任意一条时自动禁用渠道，例如：
+//
+//	{"14": ["(?i)organization .* has been disabled"], "1": ["(?i)your account .* is not active"]}
+//
+// 键为渠道类型（constant.ChannelType*），载入时编译，无效的正则会被跳过并记录日志
+var ChannelDisableRegexByType = map[string][]string{}
+var channelDisableRegexCompiled = map[int][]*regexp.Regexp{}
+var ChannelDisableRegexByTypeMutex sync.RWMutex
+
+func ChannelDisableRegexByType2JSONString() string {
+	ChannelDisableRegexByTypeMutex.RLock()
+	defer ChannelDisableRegexByTypeMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(ChannelDisableRegexByType)
+	if err != nil {
+		common.SysLog("error marshalling channel disable regex: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateChannelDisableRegexByTypeByJSONString(jsonStr string) error {
+	patterns := make(map[string][]string)
+	if err := json.Unmarshal([]byte(jsonStr), &patterns); err != nil {
+		return err
+	}
+	compiled := make(map[int][]*regexp.Regexp, len(patterns))
+	for key, list := range patterns {
+		channelType, err := strconv.Atoi(key)
+		if err != nil {
+			common.SysLog(fmt.Sprintf("invalid channel type %q in channel disable regex, skipped", key))
+			continue
+		}
+		for _, pattern := range list {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				common.SysLog(fmt.Sprintf("invalid channel disable regex %q for channel type %d, skipped: %s", pattern, channelType, err.Error()))
+				continue
+			}
+			compiled[channelType] = append(compiled[channelType], re)
+		}
+	}
+
+	ChannelDisableRegexByTypeMutex.Lock()
+	defer ChannelDisableRegexByTypeMutex.Unlock()
+
+	ChannelDisableRegexByType = patterns
+	channelDisableRegexCompiled = compiled
+	return nil
+}
+
+func CheckChannelDisableRegexByType(jsonStr string) error {
+	patterns := make(map[string][]string)
+	if err := json.Unmarshal([]byte(jsonStr), &patterns); err != nil {
+		return err
+	}
+	for key, list := range patterns {
+		if _, err := strconv.Atoi(key); err != nil {
+			return fmt.Errorf("invalid channel type: %s", key)
+		}
+		for _, pattern := range list {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("invalid regex %q for channel type %s: %w", pattern, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// MatchChannelDisableRegex 判断上游错误信息是否匹配该渠道类型配置的禁用正则
+func MatchChannelDisableRegex(channelType int, message string) bool {
+	if message == "" {
+		return false
+	}
+	ChannelDisableRegexByTypeMutex.RLock()
+	defer ChannelDisableRegexByTypeMutex.RUnlock()
+
+	for _, re := range channelDisableRegexCompiled[channelType] {
+		if re.MatchString(message) {
+			return true
+		}
+	}
+	return false
+}
+
+// 渠道在统计窗口内的成功率低于该值时自动禁用，取值 0~1（0表示不按成功率禁用）
+var ChannelMinSuccessRate = 0.0
+
+// 渠道成功率的统计窗口，单位秒
+var ChannelSuccessRateWindowSeconds = 300
+
+// 统计窗口内的请求数达到该值后才按成功率判断是否禁用
+var ChannelSuccessRateMinSamples = 20
+
+// 模型在所有渠道上的整体成功率低于该值时告警，取值 0~1（0表示不告警）。统计窗口与最少样本数同渠道成功率
+var ModelMinSuccessRate = 0.0
+
+// 渠道重新启用后，前 N 次失败只记录日志而不再次自动禁用渠道（0表示不启用宽限）
+var ChannelPostEnableGraceFailures = 0
+
+// 同一请求重试多个渠道时，相同的错误（错误码与状态码一致）只计入最先失败的渠道，避免同一问题连带禁用其它渠道
+var ChannelRetryAttributionEnabled = false
+
+// 渠道在 ChannelFlapWindowSeconds 内启用/自动禁用切换的次数达到该值时视为频繁切换，
+// 自动禁用后延长冷却 ChannelFlapHoldSeconds，期间不能再次启用（0表示不检测）
+var ChannelFlapThreshold = 0
+var ChannelFlapWindowSeconds = 3600
+var ChannelFlapHoldSeconds = 1800
+
+// 渠道每次失败后 ChannelQuarantineSeconds 秒内的失败次数达到 ChannelQuarantineErrorThreshold 时暂时不参与选择（隔离），
+// ChannelQuarantineSeconds 秒内没有新的失败时自动解除，不修改渠道状态（0表示不隔离）
+var ChannelQuarantineSeconds = 0
+var ChannelQuarantineErrorThreshold = 5
+
+// 渠道被禁用时，在该渠道上进行中的流式响应中写入一条说明渠道已不可用、建议重试的错误后结束，而不是直接断开
+var NotifyOnMidStreamChannelDisable = false
+
+// 渠道连续返回空回复（成功响应但没有任何输出）达到该次数时自动禁用（0表示不按空回复禁用）
+var ChannelDisableOnEmptyAfterN = 0
+
+// 渠道在成功率统计窗口内失败次数达到 SoftDisableMinFailures 时，选择权重乘以该系数（软禁用），
+// 仍保留少量流量而不是直接禁用，取值 0~1（0表示不启用软禁用）
+var SoftDisableWeightFactor = 0.0
+var SoftDisableMinFailures = 3
+
+func CheckSoftDisableWeightFactor(value string) error {
+	factor, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	if factor < 0 || factor > 1 {
+		return fmt.Errorf("soft disable weight factor must be between 0 and 1, got %v", factor)
+	}
+	return nil
+}
+
+func CheckChannelMinSuccessRate(value string) error {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("channel min success rate must be between 0 and 1, got %v", rate)
+	}
+	return nil
+}
+
+func CheckModelMinSuccessRate(value string) error {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return err
+	}
+	if rate < 0 || rate > 1 {
+		return fmt.Errorf("model min success rate must be between 0 and 1, got %v", rate)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package setting
+
+import "testing"
+
+func TestCheckChannelMinSuccessRate(t *testing.T) {
+	for _, value := range []string{"0", "0.9", "1"} {
+		if err := CheckChannelMinSuccessRate(value); err != nil {
+			t.Errorf("CheckChannelMinSuccessRate(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"-0.1", "1.5", "abc", ""} {
+		if err := CheckChannelMinSuccessRate(value); err == nil {
+			t.Errorf("CheckChannelMinSuccessRate(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestCheckSoftDisableWeightFactor(t *testing.T) {
+	for _, value := range []string{"0", "0.1", "1"} {
+		if err := CheckSoftDisableWeightFactor(value); err != nil {
+			t.Errorf("CheckSoftDisableWeightFactor(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"-0.1", "1.5", "abc"} {
+		if err := CheckSoftDisableWeightFactor(value); err == nil {
+			t.Errorf("CheckSoftDisableWeightFactor(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestCheckModelMinSuccessRate(t *testing.T) {
+	for _, value := range []string{"0", "0.95", "1"} {
+		if err := CheckModelMinSuccessRate(value); err != nil {
+			t.Errorf("CheckModelMinSuccessRate(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"-0.1", "1.5", "abc", ""} {
+		if err := CheckModelMinSuccessRate(value); err == nil {
+			t.Errorf("CheckModelMinSuccessRate(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestCheckChannelDisableRegexByType(t *testing.T) {
+	for _, value := range []string{`{}`, `{"14": ["(?i)organization .* has been disabled"], "1": ["account .* not active", "^deactivated$"]}`} {
+		if err := CheckChannelDisableRegexByType(value); err != nil {
+			t.Errorf("CheckChannelDisableRegexByType(%s) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{`{`, `{"openai": ["x"]}`, `{"1": ["("]}`, `{"1": "x"}`} {
+		if err := CheckChannelDisableRegexByType(value); err == nil {
+			t.Errorf("CheckChannelDisableRegexByType(%s) = nil, want error", value)
+		}
+	}
+}
+
+func TestMatchChannelDisableRegex(t *testing.T) {
+	old := ChannelDisableRegexByType2JSONString()
+	t.Cleanup(func() { _ = UpdateChannelDisableRegexByTypeByJSONString(old) })
+	// 无效的正则与渠道类型在载入时跳过，其余正则照常生效
+	if err := UpdateChannelDisableRegexByTypeByJSONString(`{"14": ["(", "(?i)organization .* has been disabled"], "openai": ["x"]}`); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		channelType int
+		message     string
+		want        bool
+	}{
+		{14, "This organization org-1 has been disabled.", true},
+		{14, "ORGANIZATION org-1 HAS BEEN DISABLED", true},
+		{14, "prompt is too long", false},
+		{14, "", false},
+		{1, "This organization org-1 has been disabled.", false},
+	}
+	for _, tc := range cases {
+		if got := MatchChannelDisableRegex(tc.channelType, tc.message); got != tc.want {
+			t.Errorf("MatchChannelDisableRegex(%d, %q) = %v, want %v", tc.channelType, tc.message, got, tc.want)
+		}
+	}
+}
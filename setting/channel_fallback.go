@@ -0,0 +1,63 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 按渠道 ID 配置的备用渠道链：渠道请求失败后，重试时先按顺序尝试这些渠道，再进行常规的渠道选择
+var ChannelFallbackChains = map[int][]int{}
+var ChannelFallbackChainsMutex sync.RWMutex
+
+func ChannelFallbackChains2JSONString() string {
+	ChannelFallbackChainsMutex.RLock()
+	defer ChannelFallbackChainsMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(ChannelFallbackChains)
+	if err != nil {
+		common.SysLog("error marshalling channel fallback chains: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateChannelFallbackChainsByJSONString(jsonStr string) error {
+	ChannelFallbackChainsMutex.Lock()
+	defer ChannelFallbackChainsMutex.Unlock()
+
+	ChannelFallbackChains = make(map[int][]int)
+	return json.Unmarshal([]byte(jsonStr), &ChannelFallbackChains)
+}
+
+// GetChannelFallbackChain 获取渠道的备用渠道链，未配置时返回 nil
+func GetChannelFallbackChain(channelId int) []int {
+	ChannelFallbackChainsMutex.RLock()
+	defer ChannelFallbackChainsMutex.RUnlock()
+
+	chain, ok := ChannelFallbackChains[channelId]
+	if !ok {
+		return nil
+	}
+	return append([]int(nil), chain...)
+}
+
+func CheckChannelFallbackChains(jsonStr string) error {
+	checkChannelFallbackChains := make(map[int][]int)
+	err := json.Unmarshal([]byte(jsonStr), &checkChannelFallbackChains)
+	if err != nil {
+		return err
+	}
+	for channelId, chain := range checkChannelFallbackChains {
+		for _, fallbackId := range chain {
+			if fallbackId <= 0 {
+				return fmt.Errorf("channel %d has invalid fallback channel id: %d", channelId, fallbackId)
+			}
+			if fallbackId == channelId {
+				return fmt.Errorf("channel %d cannot fall back to itself", channelId)
+			}
+		}
+	}
+	return nil
+}
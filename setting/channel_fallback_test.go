@@ -0,0 +1,16 @@
+package setting
+
+import "testing"
+
+func TestCheckChannelFallbackChains(t *testing.T) {
+	for _, value := range []string{`{}`, `{"1":[2,3]}`, `{"1":[2],"2":[1]}`} {
+		if err := CheckChannelFallbackChains(value); err != nil {
+			t.Errorf("CheckChannelFallbackChains(%s) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{`{"1":[1]}`, `{"1":[0]}`, `{"a":[1]}`, `[1,2]`} {
+		if err := CheckChannelFallbackChains(value); err == nil {
+			t.Errorf("CheckChannelFallbackChains(%s) = nil, want error", value)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 渠道被启用（手动或自动）后逐步放量的预热时长，单位秒（0表示不预热）
+var ChannelWarmupSeconds = 0
+
+// 上游响应头表明限流额度已用尽时，在上游指定的重置时间之前不再选择该渠道
+var ChannelProviderBackoffEnabled = false
+
+// 按上游重置时间退避的最长时长，单位秒，防止异常的响应头让渠道长时间不可用
+var ChannelProviderBackoffMaxSeconds = 300
+
+// 上游响应头报告的剩余请求额度低于总额度的该百分比时，按剩余比例降低渠道的选择权重，
+// 在额度用尽之前把流量逐步分到其它渠道（0表示不降低）
+var ChannelProviderThrottlePercent = 0
+
+func CheckChannelProviderThrottlePercent(value string) error {
+	percent, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid channel provider throttle percent: %s", value)
+	}
+	if percent < 0 || percent > 100 {
+		return fmt.Errorf("channel provider throttle percent must be between 0 and 100, got %d", percent)
+	}
+	return nil
+}
+
+// 会话粘滞：请求携带 X-Session-Id 时，同一会话优先选择上一次使用的渠道，渠道不可用时按正常规则重新选择
+var ChannelAffinityEnabled = false
+
+// 会话与渠道绑定关系的保留时长，单位秒，每次命中后重新计时
+var ChannelAffinityTTLSeconds = 3600
+
+// 按渠道 ID 配置的最大并发请求数（未配置或为0表示不限制）
+var ChannelMaxConcurrency = map[int]int{}
+var ChannelMaxConcurrencyMutex sync.RWMutex
+
+func ChannelMaxConcurrency2JSONString() string {
+	ChannelMaxConcurrencyMutex.RLock()
+	defer ChannelMaxConcurrencyMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(ChannelMaxConcurrency)
+	if err != nil {
+		common.SysLog("error marshalling channel max concurrency: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateChannelMaxConcurrencyByJSONString(jsonStr string) error {
+	ChannelMaxConcurrencyMutex.Lock()
+	defer ChannelMaxConcurrencyMutex.Unlock()
+
+	ChannelMaxConcurrency = make(map[int]int)
+	return json.Unmarshal([]byte(jsonStr), &ChannelMaxConcurrency)
+}
+
+// GetChannelMaxConcurrency 获取渠道的最大并发数，0 表示不限制
+func GetChannelMaxConcurrency(channelId int) int {
+	ChannelMaxConcurrencyMutex.RLock()
+	defer ChannelMaxConcurrencyMutex.RUnlock()
+
+	return ChannelMaxConcurrency[channelId]
+}
+
+func CheckChannelMaxConcurrency(jsonStr string) error {
+	checkChannelMaxConcurrency := make(map[int]int)
+	err := json.Unmarshal([]byte(jsonStr), &checkChannelMaxConcurrency)
+	if err != nil {
+		return err
+	}
+	for channelId, limit := range checkChannelMaxConcurrency {
+		if limit < 0 {
+			return fmt.Errorf("channel %d has negative max concurrency: %d", channelId, limit)
+		}
+	}
+	return nil
+}
+
+// 渠道达到并发上限时，每个渠道最多排队等待的请求数（0表示不排队，直接返回错误）
+var ChannelQueueDepth = 0
+
+// 排队等待渠道并发名额的最长时间，单位毫秒，超时后返回错误
+var ChannelQueueMaxWaitMs = 5000
@@ -0,0 +1,16 @@
+package setting
+
+import "testing"
+
+func TestCheckChannelProviderThrottlePercent(t *testing.T) {
+	for _, value := range []string{"0", "20", "100"} {
+		if err := CheckChannelProviderThrottlePercent(value); err != nil {
+			t.Errorf("CheckChannelProviderThrottlePercent(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"-1", "101", "abc", ""} {
+		if err := CheckChannelProviderThrottlePercent(value); err == nil {
+			t.Errorf("CheckChannelProviderThrottlePercent(%q) = nil, want error", value)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+package setting
+
+// 紧急关闭全部转发：用于滥用或故障处置，开启后非管理员的模型请求在任何限流之前直接返回 503
+var EmergencyDisableAllRelays = false
+
+// 紧急关闭期间返回给调用方的提示信息
+var EmergencyDisableMessage = "服务因紧急维护暂时不可用，请稍后再试"
@@ -0,0 +1,24 @@
+package setting
+
+import "fmt"
+
+// 错误响应的格式
+const (
+	ErrorFormatOpenAI      = "openai"       // 默认，按接口原生格式返回（Claude 接口返回 Anthropic 格式）
+	ErrorFormatAnthropic   = "anthropic"    // 所有接口统一返回 Anthropic 格式
+	ErrorFormatProblemJSON = "problem+json" // RFC 7807 application/problem+json
+)
+
+// 限流拒绝及转发失败时返回的错误格式
+var ErrorFormat = ErrorFormatOpenAI
+
+// 管理员调用失败时在错误响应中附带处理该请求的渠道及其标签，便于排查；普通用户的响应中始终不包含
+var ExposeChannelInError = false
+
+func CheckErrorFormat(value string) error {
+	switch value {
+	case ErrorFormatOpenAI, ErrorFormatAnthropic, ErrorFormatProblemJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown error format: %s", value)
+}
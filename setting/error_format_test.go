@@ -0,0 +1,16 @@
+package setting
+
+import "testing"
+
+func TestCheckErrorFormat(t *testing.T) {
+	for _, value := range []string{ErrorFormatOpenAI, ErrorFormatAnthropic, ErrorFormatProblemJSON} {
+		if err := CheckErrorFormat(value); err != nil {
+			t.Errorf("CheckErrorFormat(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"", "json", "problem"} {
+		if err := CheckErrorFormat(value); err == nil {
+			t.Errorf("CheckErrorFormat(%q) = nil, want error", value)
+		}
+	}
+}
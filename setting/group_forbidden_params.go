@@ -0,0 +1,58 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 按分组禁止使用的请求参数，如 {"default": ["logprobs", "n"]}，参数名支持 gjson 路径（如 "stream_options.include_usage"），
+// 请求体中出现这些参数且值不为 null 或 false 时直接返回 400（未配置表示不限制）
+var GroupForbiddenParams = map[string][]string{}
+var GroupForbiddenParamsMutex sync.RWMutex
+
+func GroupForbiddenParams2JSONString() string {
+	GroupForbiddenParamsMutex.RLock()
+	defer GroupForbiddenParamsMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(GroupForbiddenParams)
+	if err != nil {
+		common.SysLog("error marshalling group forbidden params: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupForbiddenParamsByJSONString(jsonStr string) error {
+	GroupForbiddenParamsMutex.Lock()
+	defer GroupForbiddenParamsMutex.Unlock()
+
+	GroupForbiddenParams = make(map[string][]string)
+	return json.Unmarshal([]byte(jsonStr), &GroupForbiddenParams)
+}
+
+// GetGroupForbiddenParams 获取分组禁止使用的请求参数
+func GetGroupForbiddenParams(group string) []string {
+	GroupForbiddenParamsMutex.RLock()
+	defer GroupForbiddenParamsMutex.RUnlock()
+
+	return GroupForbiddenParams[group]
+}
+
+func CheckGroupForbiddenParams(jsonStr string) error {
+	checkGroupForbiddenParams := make(map[string][]string)
+	err := json.Unmarshal([]byte(jsonStr), &checkGroupForbiddenParams)
+	if err != nil {
+		return err
+	}
+	for group, params := range checkGroupForbiddenParams {
+		for _, param := range params {
+			if strings.TrimSpace(param) == "" {
+				return fmt.Errorf("group %s has an empty forbidden param", group)
+			}
+		}
+	}
+	return nil
+}
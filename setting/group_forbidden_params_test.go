@@ -0,0 +1,14 @@
+package setting
+
+import "testing"
+
+func TestCheckGroupForbiddenParams(t *testing.T) {
+	if err := CheckGroupForbiddenParams(`{"default":["logprobs","n"],"vip":[]}`); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+	for _, value := range []string{`{"default":[" "]}`, `{"default":"logprobs"}`, `[`} {
+		if err := CheckGroupForbiddenParams(value); err == nil {
+			t.Errorf("CheckGroupForbiddenParams(%s) = nil, want error", value)
+		}
+	}
+}
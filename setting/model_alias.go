@@ -0,0 +1,85 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 模型别名分组：标准模型名 -> 别名列表。按模型统计的限流会先把别名归一为标准模型名，
+// 避免客户端通过切换别名绕过限制，例如 {"gpt-4": ["gpt-4-0613", "gpt-4-0314"]}
+var ModelAliasGroups = map[string][]string{}
+var ModelAliasGroupsMutex sync.RWMutex
+
+// modelAliasIndex 别名 -> 标准模型名，随 ModelAliasGroups 一起更新
+var modelAliasIndex = map[string]string{}
+
+func ModelAliasGroups2JSONString() string {
+	ModelAliasGroupsMutex.RLock()
+	defer ModelAliasGroupsMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(ModelAliasGroups)
+	if err != nil {
+		common.SysLog("error marshalling model alias groups: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelAliasGroupsByJSONString(jsonStr string) error {
+	ModelAliasGroupsMutex.Lock()
+	defer ModelAliasGroupsMutex.Unlock()
+
+	groups := make(map[string][]string)
+	if err := json.Unmarshal([]byte(jsonStr), &groups); err != nil {
+		return err
+	}
+	index := make(map[string]string)
+	for canonical, aliases := range groups {
+		for _, alias := range aliases {
+			index[alias] = canonical
+		}
+	}
+	ModelAliasGroups = groups
+	modelAliasIndex = index
+	return nil
+}
+
+// CanonicalModelName 返回模型所属别名分组的标准模型名，未配置别名时原样返回
+func CanonicalModelName(model string) string {
+	ModelAliasGroupsMutex.RLock()
+	defer ModelAliasGroupsMutex.RUnlock()
+
+	if canonical, ok := modelAliasIndex[model]; ok {
+		return canonical
+	}
+	return model
+}
+
+func CheckModelAliasGroups(jsonStr string) error {
+	checkModelAliasGroups := make(map[string][]string)
+	err := json.Unmarshal([]byte(jsonStr), &checkModelAliasGroups)
+	if err != nil {
+		return err
+	}
+	owner := make(map[string]string)
+	for canonical, aliases := range checkModelAliasGroups {
+		if canonical == "" {
+			return fmt.Errorf("model alias group has empty model name")
+		}
+		for _, alias := range aliases {
+			if alias == "" {
+				return fmt.Errorf("model %s has empty alias", canonical)
+			}
+			if _, ok := checkModelAliasGroups[alias]; ok && alias != canonical {
+				return fmt.Errorf("alias %s of model %s is itself a model alias group", alias, canonical)
+			}
+			if prev, ok := owner[alias]; ok && prev != canonical {
+				return fmt.Errorf("alias %s belongs to both %s and %s", alias, prev, canonical)
+			}
+			owner[alias] = canonical
+		}
+	}
+	return nil
+}
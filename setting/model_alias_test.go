@@ -0,0 +1,53 @@
+package setting
+
+import "testing"
+
+func TestCanonicalModelName(t *testing.T) {
+	t.Cleanup(func() { _ = UpdateModelAliasGroupsByJSONString("{}") })
+	if err := UpdateModelAliasGroupsByJSONString(`{"gpt-4":["gpt-4-0613","gpt-4-0314"]}`); err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]string{
+		"gpt-4-0613": "gpt-4",
+		"gpt-4-0314": "gpt-4",
+		"gpt-4":      "gpt-4",
+		"gpt-4o":     "gpt-4o",
+	}
+	for model, want := range cases {
+		if got := CanonicalModelName(model); got != want {
+			t.Errorf("CanonicalModelName(%q) = %q, want %q", model, got, want)
+		}
+	}
+
+	// 更新配置后旧的别名不再生效
+	if err := UpdateModelAliasGroupsByJSONString(`{"gpt-4":["gpt-4-0613"]}`); err != nil {
+		t.Fatal(err)
+	}
+	if got := CanonicalModelName("gpt-4-0314"); got != "gpt-4-0314" {
+		t.Fatalf("removed alias resolved to %q", got)
+	}
+}
+
+func TestCheckModelAliasGroups(t *testing.T) {
+	valid := []string{
+		`{}`,
+		`{"gpt-4":["gpt-4-0613","gpt-4-0314"],"claude-3":["claude-3-opus"]}`,
+	}
+	for _, value := range valid {
+		if err := CheckModelAliasGroups(value); err != nil {
+			t.Errorf("CheckModelAliasGroups(%s) = %v, want nil", value, err)
+		}
+	}
+	invalid := []string{
+		`not json`,
+		`{"":["gpt-4-0613"]}`,
+		`{"gpt-4":[""]}`,
+		`{"gpt-4":["gpt-4-0613"],"gpt-4-0613":["gpt-4-old"]}`,
+		`{"gpt-4":["shared"],"gpt-4o":["shared"]}`,
+	}
+	for _, value := range invalid {
+		if err := CheckModelAliasGroups(value); err == nil {
+			t.Errorf("CheckModelAliasGroups(%s) = nil, want error", value)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 按模型配置的最大输出 token 数上限，客户端请求的 max_tokens 超过上限或未指定时按上限转发（未配置或为0表示不限制）
+var ModelMaxTokensCap = map[string]int{}
+var ModelMaxTokensCapMutex sync.RWMutex
+
+// 对 max_tokens 做了截断或补充时，在响应头 X-NewAPI-Max-Tokens-Capped 中返回实际使用的上限
+var ModelMaxTokensCapHeaderEnabled = false
+
+func ModelMaxTokensCap2JSONString() string {
+	ModelMaxTokensCapMutex.RLock()
+	defer ModelMaxTokensCapMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(ModelMaxTokensCap)
+	if err != nil {
+		common.SysLog("error marshalling model max tokens cap: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelMaxTokensCapByJSONString(jsonStr string) error {
+	ModelMaxTokensCapMutex.Lock()
+	defer ModelMaxTokensCapMutex.Unlock()
+
+	ModelMaxTokensCap = make(map[string]int)
+	return json.Unmarshal([]byte(jsonStr), &ModelMaxTokensCap)
+}
+
+// GetModelMaxTokensCap 获取模型的最大输出 token 数上限，未单独配置时使用别名组规范名称的配置，0 表示不限制
+func GetModelMaxTokensCap(modelName string) int {
+	ModelMaxTokensCapMutex.RLock()
+	defer ModelMaxTokensCapMutex.RUnlock()
+
+	if limit, ok := ModelMaxTokensCap[modelName]; ok {
+		return limit
+	}
+	return ModelMaxTokensCap[CanonicalModelName(modelName)]
+}
+
+func CheckModelMaxTokensCap(jsonStr string) error {
+	checkModelMaxTokensCap := make(map[string]int)
+	err := json.Unmarshal([]byte(jsonStr), &checkModelMaxTokensCap)
+	if err != nil {
+		return err
+	}
+	for modelName, limit := range checkModelMaxTokensCap {
+		if limit < 0 {
+			return fmt.Errorf("model %s has negative max tokens cap: %d", modelName, limit)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,16 @@
+package setting
+
+import "testing"
+
+func TestCheckModelMaxTokensCap(t *testing.T) {
+	for _, value := range []string{`{}`, `{"gpt-4o":4096}`, `{"gpt-4o":0}`} {
+		if err := CheckModelMaxTokensCap(value); err != nil {
+			t.Errorf("CheckModelMaxTokensCap(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{`{"gpt-4o":-1}`, `{"gpt-4o":"4096"}`, `[]`} {
+		if err := CheckModelMaxTokensCap(value); err == nil {
+			t.Errorf("CheckModelMaxTokensCap(%q) = nil, want error", value)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 按模型配置的全局总请求数限制：所有用户在 ModelRequestRateLimitDurationMinutes 分钟内对该模型的请求总数上限，
+// 别名按 ModelAliasGroups 归一为标准模型名后共享同一限制（未配置或为0表示不限制）
+var ModelGlobalRateLimit = map[string]int{}
+var ModelGlobalRateLimitMutex sync.RWMutex
+
+// 公平分配：开启后模型的全局限制在窗口内的活跃用户之间平均分配，每个用户最多使用其中一份，
+// 避免单个用户占满稀缺模型的容量。仅对配置了 ModelGlobalRateLimit 的模型生效
+var ModelFairShareEnabled = false
+
+func ModelGlobalRateLimit2JSONString() string {
+	ModelGlobalRateLimitMutex.RLock()
+	defer ModelGlobalRateLimitMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(ModelGlobalRateLimit)
+	if err != nil {
+		common.SysLog("error marshalling model global rate limit: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelGlobalRateLimitByJSONString(jsonStr string) error {
+	ModelGlobalRateLimitMutex.Lock()
+	defer ModelGlobalRateLimitMutex.Unlock()
+
+	ModelGlobalRateLimit = make(map[string]int)
+	return json.Unmarshal([]byte(jsonStr), &ModelGlobalRateLimit)
+}
+
+// GetModelGlobalRateLimit 获取标准模型名的全局总请求数限制，0 表示不限制
+func GetModelGlobalRateLimit(canonicalModel string) int {
+	ModelGlobalRateLimitMutex.RLock()
+	defer ModelGlobalRateLimitMutex.RUnlock()
+
+	return ModelGlobalRateLimit[canonicalModel]
+}
+
+func CheckModelGlobalRateLimit(jsonStr string) error {
+	checkModelGlobalRateLimit := make(map[string]int)
+	err := json.Unmarshal([]byte(jsonStr), &checkModelGlobalRateLimit)
+	if err != nil {
+		return err
+	}
+	for modelName, limit := range checkModelGlobalRateLimit {
+		if limit < 0 {
+			return fmt.Errorf("model %s has negative global rate limit: %d", modelName, limit)
+		}
+	}
+	return nil
+}
+
+// 按模型配置的每个令牌每日请求数上限（包括失败请求），与令牌的每日限流相互独立，
+// 别名按 ModelAliasGroups 归一为标准模型名后共享同一上限（未配置或为0表示不限制）
+var ModelDailyCap = map[string]int{}
+var ModelDailyCapMutex sync.RWMutex
+
+func ModelDailyCap2JSONString() string {
+	ModelDailyCapMutex.RLock()
+	defer ModelDailyCapMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(ModelDailyCap)
+	if err != nil {
+		common.SysLog("error marshalling model daily cap: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelDailyCapByJSONString(jsonStr string) error {
+	ModelDailyCapMutex.Lock()
+	defer ModelDailyCapMutex.Unlock()
+
+	ModelDailyCap = make(map[string]int)
+	return json.Unmarshal([]byte(jsonStr), &ModelDailyCap)
+}
+
+// GetModelDailyCap 获取标准模型名的每个令牌每日请求数上限，0 表示不限制
+func GetModelDailyCap(canonicalModel string) int {
+	ModelDailyCapMutex.RLock()
+	defer ModelDailyCapMutex.RUnlock()
+
+	return ModelDailyCap[canonicalModel]
+}
+
+func CheckModelDailyCap(jsonStr string) error {
+	checkModelDailyCap := make(map[string]int)
+	err := json.Unmarshal([]byte(jsonStr), &checkModelDailyCap)
+	if err != nil {
+		return err
+	}
+	for modelName, limit := range checkModelDailyCap {
+		if limit < 0 {
+			return fmt.Errorf("model %s has negative daily cap: %d", modelName, limit)
+		}
+	}
+	return nil
+}
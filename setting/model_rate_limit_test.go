@@ -0,0 +1,29 @@
+package setting
+
+import "testing"
+
+func TestCheckModelGlobalRateLimit(t *testing.T) {
+	for _, value := range []string{`{}`, `{"gpt-4o":100}`, `{"gpt-4o":0,"o1":5}`} {
+		if err := CheckModelGlobalRateLimit(value); err != nil {
+			t.Errorf("CheckModelGlobalRateLimit(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{`{"gpt-4o":-1}`, `{"gpt-4o":"10"}`, `[1]`, ``} {
+		if err := CheckModelGlobalRateLimit(value); err == nil {
+			t.Errorf("CheckModelGlobalRateLimit(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestCheckModelDailyCap(t *testing.T) {
+	for _, value := range []string{`{}`, `{"o1":10}`, `{"o1":0,"o3":5}`} {
+		if err := CheckModelDailyCap(value); err != nil {
+			t.Errorf("CheckModelDailyCap(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{`{"o1":-1}`, `{"o1":"10"}`, `[1]`, ``} {
+		if err := CheckModelDailyCap(value); err == nil {
+			t.Errorf("CheckModelDailyCap(%q) = nil, want error", value)
+		}
+	}
+}
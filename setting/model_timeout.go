@@ -0,0 +1,57 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 按模型配置的上游请求超时，单位秒（未配置或为0表示不限制）
+var ModelRequestTimeout = map[string]int{}
+var ModelRequestTimeoutMutex sync.RWMutex
+
+// 同一渠道同一模型连续超时达到该次数后自动禁用渠道（0表示超时不会触发自动禁用）
+var ModelTimeoutDisableThreshold = 0
+
+func ModelRequestTimeout2JSONString() string {
+	ModelRequestTimeoutMutex.RLock()
+	defer ModelRequestTimeoutMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(ModelRequestTimeout)
+	if err != nil {
+		common.SysLog("error marshalling model request timeout: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelRequestTimeoutByJSONString(jsonStr string) error {
+	ModelRequestTimeoutMutex.Lock()
+	defer ModelRequestTimeoutMutex.Unlock()
+
+	ModelRequestTimeout = make(map[string]int)
+	return json.Unmarshal([]byte(jsonStr), &ModelRequestTimeout)
+}
+
+// GetModelRequestTimeout 获取模型的请求超时秒数，0 表示不限制
+func GetModelRequestTimeout(modelName string) int {
+	ModelRequestTimeoutMutex.RLock()
+	defer ModelRequestTimeoutMutex.RUnlock()
+
+	return ModelRequestTimeout[modelName]
+}
+
+func CheckModelRequestTimeout(jsonStr string) error {
+	checkModelRequestTimeout := make(map[string]int)
+	err := json.Unmarshal([]byte(jsonStr), &checkModelRequestTimeout)
+	if err != nil {
+		return err
+	}
+	for modelName, timeout := range checkModelRequestTimeout {
+		if timeout < 0 {
+			return fmt.Errorf("model %s has negative request timeout: %d", modelName, timeout)
+		}
+	}
+	return nil
+}
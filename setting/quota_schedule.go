@@ -0,0 +1,216 @@
+package setting
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 令牌每日限流的重置周期，为空时按滚动的 24 小时窗口计算；设置后改为按固定周期计数，到达重置时间时清零。
+// 支持 daily / weekly / monthly（及 @daily 等别名），可用 @ 指定重置时刻，例如：
+//
+//	daily@08:00          每天 08:00 重置
+//	weekly@mon           每周一 00:00 重置
+//	weekly@fri 18:30     每周五 18:30 重置
+//	monthly@15           每月 15 日 00:00 重置（当月没有该日期时在月末重置）
+//
+// 也支持以下形式的 cron 表达式（分 时 日 月 周）："30 8 * * *"、"0 0 * * 1"、"0 0 1 * *"。时间均为服务器本地时间
+var TokenQuotaSchedule = ""
+
+var (
+	tokenQuotaScheduleMutex  sync.RWMutex
+	tokenQuotaScheduleParsed *QuotaSchedule
+)
+
+// 周期类型
+const (
+	QuotaPeriodDaily   = "daily"
+	QuotaPeriodWeekly  = "weekly"
+	QuotaPeriodMonthly = "monthly"
+)
+
+// QuotaSchedule 解析后的重置周期
+type QuotaSchedule struct {
+	Period  string
+	Hour    int
+	Minute  int
+	Weekday time.Weekday // 仅 weekly 使用
+	Day     int          // 仅 monthly 使用，1~31
+}
+
+// QuotaWindow 当前所在的计数周期，[Start, Reset)
+type QuotaWindow struct {
+	Start time.Time
+	Reset time.Time
+}
+
+var quotaWeekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseQuotaSchedule 解析重置周期表达式
+func ParseQuotaSchedule(expr string) (*QuotaSchedule, error) {
+	expr = strings.ToLower(strings.TrimSpace(expr))
+	if fields := strings.Fields(expr); len(fields) == 5 {
+		return parseQuotaCron(fields)
+	}
+	period, at, _ := strings.Cut(strings.TrimPrefix(expr, "@"), "@")
+	schedule := &QuotaSchedule{Period: period, Day: 1, Weekday: time.Monday}
+	at = strings.TrimSpace(at)
+	var clock string
+	switch period {
+	case QuotaPeriodDaily:
+		clock = at
+	case QuotaPeriodWeekly:
+		day, rest, _ := strings.Cut(at, " ")
+		if day != "" {
+			weekday, ok := quotaWeekdays[day]
+			if !ok {
+				return nil, fmt.Errorf("invalid weekday in quota schedule: %s", day)
+			}
+			schedule.Weekday = weekday
+		}
+		clock = strings.TrimSpace(rest)
+	case QuotaPeriodMonthly:
+		day, rest, _ := strings.Cut(at, " ")
+		if day != "" {
+			d, err := strconv.Atoi(day)
+			if err != nil || d < 1 || d > 31 {
+				return nil, fmt.Errorf("invalid day of month in quota schedule: %s", day)
+			}
+			schedule.Day = d
+		}
+		clock = strings.TrimSpace(rest)
+	default:
+		return nil, fmt.Errorf("unknown quota schedule: %s", expr)
+	}
+	if clock != "" {
+		t, err := time.Parse("15:04", clock)
+		if err != nil {
+			return nil, fmt.Errorf("invalid time in quota schedule: %s", clock)
+		}
+		schedule.Hour, schedule.Minute = t.Hour(), t.Minute()
+	}
+	return schedule, nil
+}
+
+// parseQuotaCron 解析 "分 时 日 月 周" 形式的 cron 表达式，日与周最多指定一个，月必须为 *
+func parseQuotaCron(fields []string) (*QuotaSchedule, error) {
+	minute, err := strconv.Atoi(fields[0])
+	if err != nil || minute < 0 || minute > 59 {
+		return nil, fmt.Errorf("invalid minute in quota schedule: %s", fields[0])
+	}
+	hour, err := strconv.Atoi(fields[1])
+	if err != nil || hour < 0 || hour > 23 {
+		return nil, fmt.Errorf("invalid hour in quota schedule: %s", fields[1])
+	}
+	if fields[3] != "*" {
+		return nil, fmt.Errorf("month field in quota schedule must be *, got %s", fields[3])
+	}
+	schedule := &QuotaSchedule{Period: QuotaPeriodDaily, Hour: hour, Minute: minute, Day: 1, Weekday: time.Monday}
+	switch {
+	case fields[2] != "*" && fields[4] != "*":
+		return nil, fmt.Errorf("quota schedule cannot specify both day of month and day of week")
+	case fields[2] != "*":
+		day, err := strconv.Atoi(fields[2])
+		if err != nil || day < 1 || day > 31 {
+			return nil, fmt.Errorf("invalid day of month in quota schedule: %s", fields[2])
+		}
+		schedule.Period, schedule.Day = QuotaPeriodMonthly, day
+	case fields[4] != "*":
+		weekday, err := strconv.Atoi(fields[4])
+		if err != nil || weekday < 0 || weekday > 7 {
+			return nil, fmt.Errorf("invalid day of week in quota schedule: %s", fields[4])
+		}
+		schedule.Period, schedule.Weekday = QuotaPeriodWeekly, time.Weekday(weekday%7)
+	}
+	return schedule, nil
+}
+
+// boundaryInMonth 返回指定年月中的重置时刻，当月没有配置的日期时取月末
+func (s *QuotaSchedule) boundaryInMonth(year int, month time.Month, loc *time.Location) time.Time {
+	day := s.Day
+	if last := time.Date(year, month+1, 0, 0, 0, 0, 0, loc).Day(); day > last {
+		day = last
+	}
+	return time.Date(year, month, day, s.Hour, s.Minute, 0, 0, loc)
+}
+
+// Window 返回 now 所在的计数周期
+func (s *QuotaSchedule) Window(now time.Time) QuotaWindow {
+	loc := now.Location()
+	switch s.Period {
+	case QuotaPeriodWeekly:
+		start := time.Date(now.Year(), now.Month(), now.Day(), s.Hour, s.Minute, 0, 0, loc)
+		start = start.AddDate(0, 0, -((int(now.Weekday()) - int(s.Weekday) + 7) % 7))
+		if start.After(now) {
+			start = start.AddDate(0, 0, -7)
+		}
+		return QuotaWindow{Start: start, Reset: start.AddDate(0, 0, 7)}
+	case QuotaPeriodMonthly:
+		start := s.boundaryInMonth(now.Year(), now.Month(), loc)
+		if start.After(now) {
+			start = s.boundaryInMonth(now.Year(), now.Month()-1, loc)
+		}
+		return QuotaWindow{Start: start, Reset: s.boundaryInMonth(start.Year(), start.Month()+1, loc)}
+	default:
+		start := time.Date(now.Year(), now.Month(), now.Day(), s.Hour, s.Minute, 0, 0, loc)
+		if start.After(now) {
+			start = start.AddDate(0, 0, -1)
+		}
+		return QuotaWindow{Start: start, Reset: start.AddDate(0, 0, 1)}
+	}
+}
+
+func CheckTokenQuotaSchedule(value string) error {
+	if strings.TrimSpace(value) == "" {
+		return nil
+	}
+	_, err := ParseQuotaSchedule(value)
+	return err
+}
+
+// UpdateTokenQuotaSchedule 更新令牌每日限流的重置周期，为空时恢复滚动窗口
+func UpdateTokenQuotaSchedule(value string) error {
+	var schedule *QuotaSchedule
+	if strings.TrimSpace(value) != "" {
+		parsed, err := ParseQuotaSchedule(value)
+		if err != nil {
+			return err
+		}
+		schedule = parsed
+	}
+	tokenQuotaScheduleMutex.Lock()
+	defer tokenQuotaScheduleMutex.Unlock()
+	TokenQuotaSchedule = value
+	tokenQuotaScheduleParsed = schedule
+	return nil
+}
+
+// GetTokenQuotaWindow 返回令牌每日限流在 now 所在的计数周期，未配置重置周期时返回 false
+func GetTokenQuotaWindow(now time.Time) (QuotaWindow, bool) {
+	tokenQuotaScheduleMutex.RLock()
+	defer tokenQuotaScheduleMutex.RUnlock()
+	if tokenQuotaScheduleParsed == nil {
+		return QuotaWindow{}, false
+	}
+	return tokenQuotaScheduleParsed.Window(now), true
+}
+
+// BillingPeriodWindow 返回以每月 anchorDay 日 00:00 为起点的账单周期，当月没有该日期时（如 2 月的 31 日）在月末开始新周期
+func BillingPeriodWindow(anchorDay int, now time.Time) QuotaWindow {
+	if anchorDay < 1 || anchorDay > 31 {
+		anchorDay = 1
+	}
+	schedule := QuotaSchedule{Period: QuotaPeriodMonthly, Day: anchorDay}
+	return schedule.Window(now)
+}
+
+// FixedWindow 返回按时钟对齐、长度为 duration 的固定窗口，例如 duration 为 1 分钟时窗口从整分钟开始
+func FixedWindow(now time.Time, duration time.Duration) QuotaWindow {
+	start := now.Truncate(duration)
+	return QuotaWindow{Start: start, Reset: start.Add(duration)}
+}
@@ -0,0 +1,144 @@
+package setting
+
+import (
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day, hour, minute int) time.Time {
+	return time.Date(year, month, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestParseQuotaSchedule(t *testing.T) {
+	cases := map[string]QuotaSchedule{
+		"daily":            {Period: QuotaPeriodDaily, Day: 1, Weekday: time.Monday},
+		"@daily@08:00":     {Period: QuotaPeriodDaily, Hour: 8, Day: 1, Weekday: time.Monday},
+		"weekly@mon":       {Period: QuotaPeriodWeekly, Day: 1, Weekday: time.Monday},
+		"Weekly@Fri 18:30": {Period: QuotaPeriodWeekly, Hour: 18, Minute: 30, Day: 1, Weekday: time.Friday},
+		"monthly@15":       {Period: QuotaPeriodMonthly, Day: 15, Weekday: time.Monday},
+		"30 8 * * *":       {Period: QuotaPeriodDaily, Hour: 8, Minute: 30, Day: 1, Weekday: time.Monday},
+		"0 0 * * 7":        {Period: QuotaPeriodWeekly, Day: 1, Weekday: time.Sunday},
+		"0 6 1 * *":        {Period: QuotaPeriodMonthly, Hour: 6, Day: 1, Weekday: time.Monday},
+	}
+	for expr, want := range cases {
+		got, err := ParseQuotaSchedule(expr)
+		if err != nil {
+			t.Errorf("ParseQuotaSchedule(%q) error: %v", expr, err)
+			continue
+		}
+		if *got != want {
+			t.Errorf("ParseQuotaSchedule(%q) = %+v, want %+v", expr, *got, want)
+		}
+	}
+	for _, expr := range []string{"hourly", "weekly@funday", "monthly@32", "daily@25:00", "0 0 1 * 1", "0 0 * 1 *", "60 0 * * *"} {
+		if _, err := ParseQuotaSchedule(expr); err == nil {
+			t.Errorf("ParseQuotaSchedule(%q) = nil error, want error", expr)
+		}
+	}
+}
+
+func TestQuotaScheduleWindow(t *testing.T) {
+	cases := []struct {
+		expr  string
+		now   time.Time
+		start time.Time
+		reset time.Time
+	}{
+		// 2026-10-16 为周五
+		{"weekly@mon", date(2026, 10, 16, 12, 0), date(2026, 10, 12, 0, 0), date(2026, 10, 19, 0, 0)},
+		{"weekly@mon", date(2026, 10, 19, 0, 0), date(2026, 10, 19, 0, 0), date(2026, 10, 26, 0, 0)},
+		{"weekly@fri 18:30", date(2026, 10, 16, 18, 29), date(2026, 10, 9, 18, 30), date(2026, 10, 16, 18, 30)},
+		{"weekly@fri 18:30", date(2026, 10, 16, 18, 30), date(2026, 10, 16, 18, 30), date(2026, 10, 23, 18, 30)},
+		{"monthly@15", date(2026, 10, 16, 0, 0), date(2026, 10, 15, 0, 0), date(2026, 11, 15, 0, 0)},
+		{"monthly@15", date(2026, 10, 14, 23, 59), date(2026, 9, 15, 0, 0), date(2026, 10, 15, 0, 0)},
+		{"monthly@1", date(2026, 12, 31, 12, 0), date(2026, 12, 1, 0, 0), date(2027, 1, 1, 0, 0)},
+		// 当月没有 31 日时在月末重置
+		{"monthly@31", date(2027, 2, 28, 12, 0), date(2027, 2, 28, 0, 0), date(2027, 3, 31, 0, 0)},
+		{"monthly@31", date(2027, 2, 27, 12, 0), date(2027, 1, 31, 0, 0), date(2027, 2, 28, 0, 0)},
+		{"daily@08:00", date(2026, 10, 16, 7, 59), date(2026, 10, 15, 8, 0), date(2026, 10, 16, 8, 0)},
+	}
+	for _, tc := range cases {
+		schedule, err := ParseQuotaSchedule(tc.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		window := schedule.Window(tc.now)
+		if !window.Start.Equal(tc.start) || !window.Reset.Equal(tc.reset) {
+			t.Errorf("%s at %v: window [%v, %v), want [%v, %v)", tc.expr, tc.now, window.Start, window.Reset, tc.start, tc.reset)
+		}
+	}
+}
+
+func TestUpdateTokenQuotaSchedule(t *testing.T) {
+	t.Cleanup(func() { _ = UpdateTokenQuotaSchedule("") })
+
+	if err := UpdateTokenQuotaSchedule("weekly@mon"); err != nil {
+		t.Fatal(err)
+	}
+	window, ok := GetTokenQuotaWindow(date(2026, 10, 16, 12, 0))
+	if !ok || !window.Reset.Equal(date(2026, 10, 19, 0, 0)) {
+		t.Fatalf("window = %+v (%v), want reset on Monday", window, ok)
+	}
+	if err := UpdateTokenQuotaSchedule("fortnightly"); err == nil {
+		t.Fatal("invalid schedule accepted")
+	}
+	if TokenQuotaSchedule != "weekly@mon" {
+		t.Fatalf("invalid schedule replaced the current one: %q", TokenQuotaSchedule)
+	}
+	if err := UpdateTokenQuotaSchedule(""); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := GetTokenQuotaWindow(time.Now()); ok {
+		t.Fatal("empty schedule still returns a window")
+	}
+}
+
+func TestBillingPeriodWindow(t *testing.T) {
+	cases := []struct {
+		anchor int
+		now    time.Time
+		start  time.Time
+		reset  time.Time
+	}{
+		{15, date(2026, 10, 16, 12, 0), date(2026, 10, 15, 0, 0), date(2026, 11, 15, 0, 0)},
+		{15, date(2026, 10, 15, 0, 0), date(2026, 10, 15, 0, 0), date(2026, 11, 15, 0, 0)},
+		{15, date(2026, 10, 14, 23, 59), date(2026, 9, 15, 0, 0), date(2026, 10, 15, 0, 0)},
+		// 跨年
+		{20, date(2027, 1, 5, 0, 0), date(2026, 12, 20, 0, 0), date(2027, 1, 20, 0, 0)},
+		// 2 月没有 31 日，在月末开始新周期
+		{31, date(2027, 2, 27, 12, 0), date(2027, 1, 31, 0, 0), date(2027, 2, 28, 0, 0)},
+		{31, date(2027, 2, 28, 0, 0), date(2027, 2, 28, 0, 0), date(2027, 3, 31, 0, 0)},
+		{31, date(2026, 4, 30, 8, 0), date(2026, 4, 30, 0, 0), date(2026, 5, 31, 0, 0)},
+		// 闰年 2 月有 29 日
+		{30, date(2028, 2, 29, 12, 0), date(2028, 2, 29, 0, 0), date(2028, 3, 30, 0, 0)},
+		{29, date(2027, 3, 1, 0, 0), date(2027, 2, 28, 0, 0), date(2027, 3, 29, 0, 0)},
+		// 非法账单日按 1 日处理
+		{0, date(2026, 10, 16, 12, 0), date(2026, 10, 1, 0, 0), date(2026, 11, 1, 0, 0)},
+		{32, date(2026, 10, 16, 12, 0), date(2026, 10, 1, 0, 0), date(2026, 11, 1, 0, 0)},
+	}
+	for _, tc := range cases {
+		window := BillingPeriodWindow(tc.anchor, tc.now)
+		if !window.Start.Equal(tc.start) || !window.Reset.Equal(tc.reset) {
+			t.Errorf("anchor %d at %v: window [%v, %v), want [%v, %v)", tc.anchor, tc.now, window.Start, window.Reset, tc.start, tc.reset)
+		}
+	}
+}
+
+func TestFixedWindow(t *testing.T) {
+	cases := []struct {
+		now      time.Time
+		duration time.Duration
+		start    time.Time
+		reset    time.Time
+	}{
+		{date(2026, 10, 16, 12, 0).Add(59 * time.Second), time.Minute, date(2026, 10, 16, 12, 0), date(2026, 10, 16, 12, 1)},
+		{date(2026, 10, 16, 12, 1), time.Minute, date(2026, 10, 16, 12, 1), date(2026, 10, 16, 12, 2)},
+		{date(2026, 10, 16, 12, 7), 5 * time.Minute, date(2026, 10, 16, 12, 5), date(2026, 10, 16, 12, 10)},
+	}
+	for _, tc := range cases {
+		window := FixedWindow(tc.now, tc.duration)
+		if !window.Start.Equal(tc.start) || !window.Reset.Equal(tc.reset) {
+			t.Errorf("FixedWindow(%v, %v) = [%v, %v), want [%v, %v)", tc.now, tc.duration, window.Start, window.Reset, tc.start, tc.reset)
+		}
+	}
+}
@@ -4,6 +4,9 @@ import (
 	"encoding/json"
 	"fmt"
 	"math"
+	"net/http"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/QuantumNous/new-api/common"
@@ -17,21 +20,350 @@ var ModelRequestRateLimitSuccessCount = 1000
 var ModelRequestRateLimitGroup = map[string][2]int{}
 var ModelRequestRateLimitMutex sync.RWMutex
 
+// 按已启用渠道数缩放 per-user 总请求数限制：实际限制 = 配置的限制 * 已启用渠道数 / RateLimitChannelScalingBaseline，
+// 最多放大到配置的 RateLimitChannelScalingMaxPercent%（默认 100，即只收紧不放宽），最少为 1（0表示不缩放）
+var RateLimitChannelScalingBaseline = 0
+var RateLimitChannelScalingMaxPercent = 100
+
+// 客户端在收到上游响应之前取消请求时，退还本次请求消耗的总请求数名额，且不计为成功请求
+var RateLimitRefundOnCancelEnabled = false
+
+// 请求体为空或无法解析（取不到模型）时在限流之前直接返回 400
+var RejectUnparseableRequests = false
+
+// 沙盒限流：带 X-RateLimit-Sandbox 请求头的请求在独立的令牌桶中计数（0表示不启用沙盒）
+var RateLimitSandboxCount = 0
+var RateLimitSandboxDurationSeconds = 60
+
+// 分组限流配置按分组名查找时是否忽略大小写，避免 "VIP" 与 "vip" 不匹配而回退到全局限制
+var RateLimitGroupCaseInsensitive = false
+
+// 操练场（网页登录会话）的 per-user 限流，与 API 令牌的限流相互独立
+var PlaygroundRateLimitEnabled = false
+var PlaygroundRateLimitDurationMinutes = 1
+var PlaygroundRateLimitCount = 20
+var PlaygroundRateLimitSuccessCount = 10
+
 // Per-key minute rate limit settings (按密钥的分钟级限流)
 var TokenRateLimitEnabled = false
 var TokenRateLimitDurationMinutes = 1
 var TokenRateLimitCount = 0
 var TokenRateLimitSuccessCount = 0
+var TokenPerIPRateLimit = 0 // 同一密钥下单个客户端 IP 在时间窗口内的总请求数限制（0表示不限制）
+
+// 按密钥的两段式限流：开始请求后的 TokenBurstDurationSeconds 秒内最快按每分钟 TokenBurstRateLimitCount 次请求，
+// 突发额度用完后降为每分钟 TokenSustainedRateLimitCount 次，闲置后逐渐恢复突发额度（任一为0表示不启用）
+var TokenBurstRateLimitCount = 0
+var TokenBurstDurationSeconds = 10
+var TokenSustainedRateLimitCount = 0
+
+// 同一密钥下按请求头 X-Customer-Id 区分的单个终端客户在时间窗口内的总请求数限制（0表示不限制，此时忽略该请求头）
+var PerCustomerRateLimit = 0
+
+// 同一密钥在时间窗口内最多出现的不同终端客户数，超过后拒绝新的客户 ID，避免限流 key 无限增长（0表示不限制）
+var PerCustomerMaxCustomers = 100
+
+// 限流检查访问 Redis 的超时时间，超时后该次检查改用本节点的内存限流（尽力而为），而不是直接放行或报错（0表示不设超时）
+var RateLimitRedisTimeoutMs = 0
+
+// 启动时按最近 24 小时的消费日志与错误日志恢复每日限流计数，避免重启后接近每日上限的用户重新获得完整额度。
+// 成功请求按消费日志计数（需开启 LogConsumeEnabled），失败请求按错误日志计数（需开启 ErrorLogEnabled）
+var RateLimitWarmupEnabled = false
+
+// 同一令牌在分钟级限流窗口内，请求失败后的前 N 次重试不再计入限流（0表示不宽限）
+var RetryGraceCount = 0
+
+// 请求被上游以 429 拒绝且最终失败时，在 per-user 总请求数限制中额外计入的次数（0表示不额外计入）
+var Upstream429Penalty = 0
 var TokenRateLimitGroup = map[string][2]int{}
 var TokenRateLimitMutex sync.RWMutex
 
 // Per-key daily rate limit settings (按密钥的每日限流)
 var TokenDailyRateLimitEnabled = false
-var TokenDailyRateLimitCount = 0          // 每日总请求数限制（0表示不限制）
-var TokenDailyRateLimitSuccessCount = 0   // 每日成功请求数限制（0表示不限制）
+var TokenDailyRateLimitCount = 0                   // 每日总请求数限制（0表示不限制）
+var TokenDailyRateLimitSuccessCount = 0            // 每日成功请求数限制（0表示不限制）
 var TokenDailyRateLimitGroup = map[string][2]int{} // 按分组的每日限制 [总请求数, 成功请求数]
 var TokenDailyRateLimitMutex sync.RWMutex
 
+// 按固定周期（TokenQuotaSchedule）计数的每日限制在距离重置不足 DailyLimitGraceBeforeResetMinutes 分钟时达到上限，
+// 允许再超出 DailyLimitOverageAllowance 次，超出的次数从下一周期的额度中扣除（任一为0表示不允许超出）
+var DailyLimitGraceBeforeResetMinutes = 0
+var DailyLimitOverageAllowance = 0
+
+// Per-user daily rate limit settings (按用户的每日限流，同一用户的所有密钥共享)
+var UserDailyRateLimitEnabled = false
+var UserDailyRateLimitCount = 0                   // 每日总请求数限制（0表示不限制）
+var UserDailyRateLimitSuccessCount = 0            // 每日成功请求数限制（0表示不限制）
+var UserDailyRateLimitGroup = map[string][2]int{} // 按用户分组的每日限制 [总请求数, 成功请求数]
+var UserDailyRateLimitMutex sync.RWMutex
+
+// Per-org rate limit settings (按组织/团队的限流，同一组织下所有用户的令牌共享)
+var OrgRateLimitEnabled = false
+var OrgRateLimitDurationMinutes = 1
+var OrgRateLimitCount = 0        // 时间窗口内总请求数限制（0表示不限制）
+var OrgRateLimitSuccessCount = 0 // 时间窗口内成功请求数限制（0表示不限制）
+
+var TokenDailyQuotaCredits = 0 // 每个令牌每日可消耗的额度上限（0表示不限制）
+
+// 每个令牌在一个账单周期内可消耗的额度上限（0表示不限制）。账单周期按令牌的账单日逐月计算，
+// 未设置账单日时取令牌创建当天的日期
+var TokenBillingPeriodQuotaCredits = 0
+
+// 限流拒绝时返回的 HTTP 状态码（默认 429，部分网关/客户端对 429 处理不佳时可改为 503 等）
+var RateLimitRejectStatusCode = http.StatusTooManyRequests
+
+// 总请求数（包括失败请求）与成功请求数被限流时分别返回的状态码，0 表示使用 RateLimitRejectStatusCode
+var RateLimitTotalRejectStatusCode = 0
+var RateLimitSuccessRejectStatusCode = 0
+
+// 总请求数被限流时 Retry-After 的最小秒数（0表示按令牌桶实际等待时长）
+var RateLimitTotalMinRetryAfterSeconds = 0
+
+// 总请求数被限流时最多阻塞等待的毫秒数，0 表示不等待直接拒绝
+var RateLimitBlockMaxMs = 0
+
+// 上游返回 2xx 但响应体中包含错误时，不计入成功请求数
+var RateLimitSuccessExcludeBodyErrors = false
+
+// 管理员令牌发起的请求不受模型请求限流限制
+var ExemptAdminFromRateLimit = true
+
+// 计入模型请求限流的 HTTP 方法，其余方法（如 OPTIONS、HEAD、GET）不消耗限流额度
+var RateLimitCountMethods = []string{http.MethodPost}
+
+func RateLimitCountMethodsToString() string {
+	return strings.Join(RateLimitCountMethods, ",")
+}
+
+func RateLimitCountMethodsFromString(s string) {
+	RateLimitCountMethods = []string{}
+	for _, method := range strings.Split(s, ",") {
+		method = strings.ToUpper(strings.TrimSpace(method))
+		if method != "" {
+			RateLimitCountMethods = append(RateLimitCountMethods, method)
+		}
+	}
+}
+
+// IsRateLimitCountMethod 判断该 HTTP 方法的请求是否计入限流
+func IsRateLimitCountMethod(method string) bool {
+	for _, m := range RateLimitCountMethods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// 限流检查访问 Redis 出错时放行请求（默认返回错误）
+var RateLimitFailOpenEnabled = false
+
+// 同一令牌在该时间窗口内重复发送完全相同的请求时只计入一次限流，单位毫秒（0表示不去重）
+var RateLimitDedupWindowMs = 0
+
+// 去重窗口内同一请求最多宽限的重复次数，超过后重复请求照常计入总请求数
+var RateLimitDedupMaxForgiven = 3
+
+// 同一令牌在该时间内使用相同 Idempotency-Key 重试时不再重复计入限流，直接沿用第一次的判定结果，单位秒（0表示不启用）
+var RateLimitIdempotencyWindowSeconds = 0
+
+// 请求通过限流检查后，以 RateLimit-Policy / RateLimit 响应头列出所有生效的限流维度及剩余额度
+var RateLimitPolicyHeadersEnabled = false
+
+// 通过限流的请求在响应（包括成功响应）中也返回 X-RateLimit-Limit / Remaining / Reset，数值在响应头发出时查询
+var AlwaysSendRateLimitHeaders = false
+
+// X-RateLimit-Reset 响应头的格式，数值均按最早一次计数的请求离开时间窗口的时刻计算
+const (
+	RateLimitResetFormatDeltaSeconds = "delta-seconds" // 距离该时刻的秒数
+	RateLimitResetFormatUnix         = "unix"          // 该时刻的 Unix 时间戳
+)
+
+var RateLimitResetHeaderFormat = RateLimitResetFormatDeltaSeconds
+
+func CheckRateLimitResetHeaderFormat(value string) error {
+	switch value {
+	case RateLimitResetFormatDeltaSeconds, RateLimitResetFormatUnix:
+		return nil
+	}
+	return fmt.Errorf("unknown rate limit reset header format: %s", value)
+}
+
+// 清理 Redis 中闲置限流 key 的间隔，单位秒（0表示不清理）
+var RateLimitKeySweepIntervalSeconds = 600
+
+// Redis 中限流 key 数量的上限，清理后仍超过上限时按闲置时长从久到近淘汰多出的 key（0表示不限制）。
+// 闲置不足 RateLimitEvictMinIdleSeconds 的 key 视为正在使用，不会被淘汰
+var RateLimitMaxKeys = 0
+var RateLimitEvictMinIdleSeconds = 60
+
+// 限流状态查询使用的只读 Redis 副本地址（host:port 或 redis:// 连接串），为空时使用主库；限流判定始终使用主库
+var RateLimitReadReplicaAddr = ""
+
+// 为限流检查中的 Redis 调用创建 OpenTelemetry span。本项目不创建 TracerProvider，也不包含导出器：
+// 需由嵌入本项目的程序通过 otel.SetTracerProvider 设置全局 TracerProvider（例如接入 OTLP 导出器），
+// 否则 span 不会被记录，开启后首次检查时会在日志中提示
+var EnableTracing = false
+
+// 为高优先级请求预留的名额百分比，低优先级请求（X-Request-Priority: low）在剩余名额不超过该比例时被拒绝（0表示不区分优先级）
+var RateLimitLowPriorityReservePercent = 0
+
+// 全局 API 限流桶的使用率达到该百分比时，通过 X-NewAPI-Backpressure 响应头建议客户端放慢请求（0表示不发送）
+var RateLimitBackpressureThresholdPercent = 0
+
+// 使用率达到 100% 时建议客户端等待的毫秒数，阈值与 100% 之间按使用率线性插值
+var RateLimitBackpressureMaxDelayMs = 1000
+
+// 限流算法
+const (
+	RateLimitAlgorithmTokenBucket   = "token_bucket"
+	RateLimitAlgorithmSlidingWindow = "sliding_window"
+	RateLimitAlgorithmLeakyBucket   = "leaky_bucket"
+)
+
+// 成功请求数限制使用的算法：sliding_window（默认，窗口内允许全部突发）或 leaky_bucket（按固定速率平滑）
+var SuccessLimiterAlgorithm = RateLimitAlgorithmSlidingWindow
+
+// 漏桶算法允许的突发量，占成功请求数限制的百分比（至少为1次）
+var SuccessLimiterBurstPercent = 10
+
+func CheckSuccessLimiterAlgorithm(value string) error {
+	switch value {
+	case RateLimitAlgorithmSlidingWindow, RateLimitAlgorithmLeakyBucket:
+		return nil
+	}
+	return fmt.Errorf("unknown success limiter algorithm: %s", value)
+}
+
+// 影子限流算法，设置后会对总请求数限制用该算法额外判定一次并统计差异，不影响实际判定
+var ShadowRateLimitAlgorithm = ""
+
+func CheckShadowRateLimitAlgorithm(value string) error {
+	switch value {
+	case "", RateLimitAlgorithmTokenBucket, RateLimitAlgorithmSlidingWindow:
+		return nil
+	}
+	return fmt.Errorf("unknown rate limit algorithm: %s", value)
+}
+
+// 请求进入限流时上下文中缺少分组的处理方式
+const (
+	RateLimitStrictGroupOff    = ""       // 不检查，缺少分组时使用全局默认限制
+	RateLimitStrictGroupLog    = "log"    // 记录日志并计数，仍使用全局默认限制
+	RateLimitStrictGroupReject = "reject" // 记录日志并计数，同时拒绝请求
+)
+
+// 用于发现鉴权流程未设置分组导致分组限流配置被静默忽略的问题
+var RateLimitStrictGroup = RateLimitStrictGroupOff
+
+func CheckRateLimitStrictGroup(value string) error {
+	switch value {
+	case RateLimitStrictGroupOff, RateLimitStrictGroupLog, RateLimitStrictGroupReject:
+		return nil
+	}
+	return fmt.Errorf("unknown rate limit strict group mode: %s", value)
+}
+
+// 密钥分钟级限流的窗口模式
+const (
+	RateLimitWindowRolling = "rolling" // 滚动窗口（令牌桶/滑动窗口），任意连续时间段内都不超过限制
+	RateLimitWindowFixed   = "fixed"   // 固定窗口，按时钟对齐（如整分钟）计数，到达窗口边界时一次性清零
+)
+
+// 密钥分钟级限流（TokenRateLimit*）使用的窗口模式。固定窗口实现简单、便于用户理解，
+// 但在窗口边界前后可以连续发出接近两倍限制的请求
+var TokenRateLimitWindowMode = RateLimitWindowRolling
+
+func CheckRateLimitWindowMode(value string) error {
+	switch value {
+	case RateLimitWindowRolling, RateLimitWindowFixed:
+		return nil
+	}
+	return fmt.Errorf("unknown rate limit window mode: %s", value)
+}
+
+func CheckRateLimitRejectStatusCode(value string) error {
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid rate limit reject status code: %s", value)
+	}
+	return checkRejectStatusCode(code)
+}
+
+// CheckRateLimitKindRejectStatusCode 校验总请求数/成功请求数单独配置的状态码，允许为 0
+func CheckRateLimitKindRejectStatusCode(value string) error {
+	code, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid rate limit reject status code: %s", value)
+	}
+	if code == 0 {
+		return nil
+	}
+	return checkRejectStatusCode(code)
+}
+
+func checkRejectStatusCode(code int) error {
+	if code < 400 || code > 599 {
+		return fmt.Errorf("rate limit reject status code must be 4xx or 5xx, got %d", code)
+	}
+	return nil
+}
+
+func CheckRateLimitLowPriorityReservePercent(value string) error {
+	percent, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid low priority reserve percent: %s", value)
+	}
+	if percent < 0 || percent >= 100 {
+		return fmt.Errorf("low priority reserve percent must be between 0 and 99, got %d", percent)
+	}
+	return nil
+}
+
+func CheckRateLimitBackpressureThresholdPercent(value string) error {
+	percent, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid backpressure threshold percent: %s", value)
+	}
+	if percent < 0 || percent >= 100 {
+		return fmt.Errorf("backpressure threshold percent must be between 0 and 99, got %d", percent)
+	}
+	return nil
+}
+
+func CheckRateLimitBackpressureMaxDelayMs(value string) error {
+	delay, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid backpressure max delay: %s", value)
+	}
+	if delay <= 0 {
+		return fmt.Errorf("backpressure max delay must be positive, got %d", delay)
+	}
+	return nil
+}
+
+func CheckRateLimitBlockMaxMs(value string) error {
+	ms, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid rate limit block max ms: %s", value)
+	}
+	if ms < 0 {
+		return fmt.Errorf("rate limit block max ms must not be negative, got %d", ms)
+	}
+	return nil
+}
+
+func CheckRateLimitSandboxDurationSeconds(value string) error {
+	seconds, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid sandbox duration: %s", value)
+	}
+	if seconds <= 0 {
+		return fmt.Errorf("sandbox duration must be positive, got %d", seconds)
+	}
+	return nil
+}
+
 func ModelRequestRateLimitGroup2JSONString() string {
 	ModelRequestRateLimitMutex.RLock()
 	defer ModelRequestRateLimitMutex.RUnlock()
@@ -51,6 +383,37 @@ func UpdateModelRequestRateLimitGroupByJSONString(jsonStr string) error {
 	return json.Unmarshal([]byte(jsonStr), &ModelRequestRateLimitGroup)
 }
 
+// lookupRateLimitGroup 查找分组的限流配置，优先精确匹配；开启 RateLimitGroupCaseInsensitive 时再忽略大小写匹配
+func lookupRateLimitGroup(groups map[string][2]int, group string) ([2]int, bool) {
+	if limits, found := groups[group]; found {
+		return limits, true
+	}
+	if RateLimitGroupCaseInsensitive {
+		for name, limits := range groups {
+			if strings.EqualFold(name, group) {
+				return limits, true
+			}
+		}
+	}
+	return [2]int{}, false
+}
+
+// ScaleRateLimitByChannels 按已启用渠道数缩放总请求数限制，未开启缩放或限制为 0（不限制）时原样返回
+func ScaleRateLimitByChannels(limit, activeChannels int) int {
+	if RateLimitChannelScalingBaseline <= 0 || limit <= 0 {
+		return limit
+	}
+	percent := activeChannels * 100 / RateLimitChannelScalingBaseline
+	if RateLimitChannelScalingMaxPercent > 0 && percent > RateLimitChannelScalingMaxPercent {
+		percent = RateLimitChannelScalingMaxPercent
+	}
+	scaled := int(math.Floor(float64(limit) * float64(percent) / 100))
+	if scaled < 1 {
+		return 1
+	}
+	return scaled
+}
+
 func GetGroupRateLimit(group string) (totalCount, successCount int, found bool) {
 	ModelRequestRateLimitMutex.RLock()
 	defer ModelRequestRateLimitMutex.RUnlock()
@@ -59,7 +422,7 @@ func GetGroupRateLimit(group string) (totalCount, successCount int, found bool)
 		return 0, 0, false
 	}
 
-	limits, found := ModelRequestRateLimitGroup[group]
+	limits, found := lookupRateLimitGroup(ModelRequestRateLimitGroup, group)
 	if !found {
 		return 0, 0, false
 	}
@@ -72,8 +435,9 @@ func CheckModelRequestRateLimitGroup(jsonStr string) error {
 	if err != nil {
 		return err
 	}
+	// [总请求数, 成功请求数]，两者各自为 0 时表示不限制该项，例如 [0, 100] 只限制成功请求数
 	for group, limits := range checkModelRequestRateLimitGroup {
-		if limits[0] < 0 || limits[1] < 1 {
+		if limits[0] < 0 || limits[1] < 0 {
 			return fmt.Errorf("group %s has negative rate limit values: [%d, %d]", group, limits[0], limits[1])
 		}
 		if limits[0] > math.MaxInt32 || limits[1] > math.MaxInt32 {
@@ -112,7 +476,7 @@ func GetTokenRateLimit(group string) (totalCount, successCount int, found bool)
 		return 0, 0, false
 	}
 
-	limits, found := TokenRateLimitGroup[group]
+	limits, found := lookupRateLimitGroup(TokenRateLimitGroup, group)
 	if !found {
 		return 0, 0, false
 	}
@@ -165,7 +529,7 @@ func GetTokenDailyRateLimit(group string) (totalCount, successCount int, found b
 		return 0, 0, false
 	}
 
-	limits, found := TokenDailyRateLimitGroup[group]
+	limits, found := lookupRateLimitGroup(TokenDailyRateLimitGroup, group)
 	if !found {
 		return 0, 0, false
 	}
@@ -189,3 +553,43 @@ func CheckTokenDailyRateLimitGroup(jsonStr string) error {
 
 	return nil
 }
+
+// User daily rate limit functions
+func UserDailyRateLimitGroup2JSONString() string {
+	UserDailyRateLimitMutex.RLock()
+	defer UserDailyRateLimitMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(UserDailyRateLimitGroup)
+	if err != nil {
+		common.SysLog("error marshalling user daily rate limit group: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateUserDailyRateLimitGroupByJSONString(jsonStr string) error {
+	UserDailyRateLimitMutex.Lock()
+	defer UserDailyRateLimitMutex.Unlock()
+
+	UserDailyRateLimitGroup = make(map[string][2]int)
+	return json.Unmarshal([]byte(jsonStr), &UserDailyRateLimitGroup)
+}
+
+func GetUserDailyRateLimit(group string) (totalCount, successCount int, found bool) {
+	UserDailyRateLimitMutex.RLock()
+	defer UserDailyRateLimitMutex.RUnlock()
+
+	if UserDailyRateLimitGroup == nil {
+		return 0, 0, false
+	}
+
+	limits, found := lookupRateLimitGroup(UserDailyRateLimitGroup, group)
+	if !found {
+		return 0, 0, false
+	}
+	return limits[0], limits[1], true
+}
+
+func CheckUserDailyRateLimitGroup(jsonStr string) error {
+	// 与 per-key 每日限制的取值范围相同
+	return CheckTokenDailyRateLimitGroup(jsonStr)
+}
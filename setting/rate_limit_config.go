@@ -0,0 +1,146 @@
+package setting
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// RateLimitConfigVersion 限流配置导出文档的版本，导入时拒绝更高版本的文档
+const RateLimitConfigVersion = 1
+
+type rateLimitOptionKind int
+
+const (
+	rateLimitOptionInt rateLimitOptionKind = iota
+	rateLimitOptionBool
+	rateLimitOptionString
+)
+
+// rateLimitOption 可导出/导入的限流配置项，check 为空时按类型校验
+type rateLimitOption struct {
+	kind  rateLimitOptionKind
+	check func(string) error
+}
+
+// rateLimitOptions 参与导出/导入的限流配置项。只读副本地址等与实例相关的配置不在其中
+var rateLimitOptions = map[string]rateLimitOption{
+	"ModelRequestRateLimitEnabled":          {kind: rateLimitOptionBool},
+	"ModelRequestRateLimitDurationMinutes":  {kind: rateLimitOptionInt},
+	"ModelRequestRateLimitCount":            {kind: rateLimitOptionInt},
+	"ModelRequestRateLimitSuccessCount":     {kind: rateLimitOptionInt},
+	"ModelRequestRateLimitGroup":            {kind: rateLimitOptionString, check: CheckModelRequestRateLimitGroup},
+	"RateLimitChannelScalingBaseline":       {kind: rateLimitOptionInt},
+	"RateLimitChannelScalingMaxPercent":     {kind: rateLimitOptionInt},
+	"TokenRateLimitEnabled":                 {kind: rateLimitOptionBool},
+	"TokenRateLimitDurationMinutes":         {kind: rateLimitOptionInt},
+	"TokenRateLimitCount":                   {kind: rateLimitOptionInt},
+	"TokenRateLimitSuccessCount":            {kind: rateLimitOptionInt},
+	"TokenRateLimitWindowMode":              {kind: rateLimitOptionString, check: CheckRateLimitWindowMode},
+	"TokenPerIPRateLimit":                   {kind: rateLimitOptionInt},
+	"TokenBurstRateLimitCount":              {kind: rateLimitOptionInt},
+	"TokenBurstDurationSeconds":             {kind: rateLimitOptionInt},
+	"TokenSustainedRateLimitCount":          {kind: rateLimitOptionInt},
+	"PerCustomerRateLimit":                  {kind: rateLimitOptionInt},
+	"PerCustomerMaxCustomers":               {kind: rateLimitOptionInt},
+	"Upstream429Penalty":                    {kind: rateLimitOptionInt},
+	"RateLimitRedisTimeoutMs":               {kind: rateLimitOptionInt},
+	"RetryGraceCount":                       {kind: rateLimitOptionInt},
+	"RateLimitWarmupEnabled":                {kind: rateLimitOptionBool},
+	"DailyLimitGraceBeforeResetMinutes":     {kind: rateLimitOptionInt},
+	"DailyLimitOverageAllowance":            {kind: rateLimitOptionInt},
+	"ModelGlobalRateLimit":                  {kind: rateLimitOptionString, check: CheckModelGlobalRateLimit},
+	"ModelDailyCap":                         {kind: rateLimitOptionString, check: CheckModelDailyCap},
+	"ModelAliasGroups":                      {kind: rateLimitOptionString, check: CheckModelAliasGroups},
+	"ModelFairShareEnabled":                 {kind: rateLimitOptionBool},
+	"RateLimitRules":                        {kind: rateLimitOptionString, check: CheckRateLimitRules},
+	"RateLimitStreamAccountingTokens":       {kind: rateLimitOptionInt},
+	"TokenRateLimitGroup":                   {kind: rateLimitOptionString, check: CheckTokenRateLimitGroup},
+	"TokenDailyRateLimitEnabled":            {kind: rateLimitOptionBool},
+	"TokenDailyRateLimitCount":              {kind: rateLimitOptionInt},
+	"TokenDailyRateLimitSuccessCount":       {kind: rateLimitOptionInt},
+	"TokenDailyRateLimitGroup":              {kind: rateLimitOptionString, check: CheckTokenDailyRateLimitGroup},
+	"UserDailyRateLimitEnabled":             {kind: rateLimitOptionBool},
+	"UserDailyRateLimitCount":               {kind: rateLimitOptionInt},
+	"UserDailyRateLimitSuccessCount":        {kind: rateLimitOptionInt},
+	"UserDailyRateLimitGroup":               {kind: rateLimitOptionString, check: CheckUserDailyRateLimitGroup},
+	"OrgRateLimitEnabled":                   {kind: rateLimitOptionBool},
+	"OrgRateLimitDurationMinutes":           {kind: rateLimitOptionInt},
+	"OrgRateLimitCount":                     {kind: rateLimitOptionInt},
+	"OrgRateLimitSuccessCount":              {kind: rateLimitOptionInt},
+	"TokenDailyQuotaCredits":                {kind: rateLimitOptionInt},
+	"TokenBillingPeriodQuotaCredits":        {kind: rateLimitOptionInt},
+	"RateLimitRejectStatusCode":             {kind: rateLimitOptionInt, check: CheckRateLimitRejectStatusCode},
+	"RateLimitTotalRejectStatusCode":        {kind: rateLimitOptionInt, check: CheckRateLimitKindRejectStatusCode},
+	"RateLimitSuccessRejectStatusCode":      {kind: rateLimitOptionInt, check: CheckRateLimitKindRejectStatusCode},
+	"RateLimitTotalMinRetryAfterSeconds":    {kind: rateLimitOptionInt},
+	"RateLimitBlockMaxMs":                   {kind: rateLimitOptionInt, check: CheckRateLimitBlockMaxMs},
+	"RateLimitSuccessExcludeBodyErrors":     {kind: rateLimitOptionBool},
+	"ExemptAdminFromRateLimit":              {kind: rateLimitOptionBool},
+	"RateLimitCountMethods":                 {kind: rateLimitOptionString},
+	"RateLimitFailOpenEnabled":              {kind: rateLimitOptionBool},
+	"RateLimitDedupWindowMs":                {kind: rateLimitOptionInt},
+	"RateLimitDedupMaxForgiven":             {kind: rateLimitOptionInt},
+	"RateLimitIdempotencyWindowSeconds":     {kind: rateLimitOptionInt},
+	"RateLimitPolicyHeadersEnabled":         {kind: rateLimitOptionBool},
+	"AlwaysSendRateLimitHeaders":            {kind: rateLimitOptionBool},
+	"RateLimitResetHeaderFormat":            {kind: rateLimitOptionString, check: CheckRateLimitResetHeaderFormat},
+	"RateLimitKeySweepIntervalSeconds":      {kind: rateLimitOptionInt},
+	"RateLimitMaxKeys":                      {kind: rateLimitOptionInt},
+	"RateLimitEvictMinIdleSeconds":          {kind: rateLimitOptionInt},
+	"RateLimitLowPriorityReservePercent":    {kind: rateLimitOptionInt, check: CheckRateLimitLowPriorityReservePercent},
+	"SuccessLimiterAlgorithm":               {kind: rateLimitOptionString, check: CheckSuccessLimiterAlgorithm},
+	"SuccessLimiterBurstPercent":            {kind: rateLimitOptionInt},
+	"ShadowRateLimitAlgorithm":              {kind: rateLimitOptionString, check: CheckShadowRateLimitAlgorithm},
+	"RateLimitStrictGroup":                  {kind: rateLimitOptionString, check: CheckRateLimitStrictGroup},
+	"RateLimitGroupCaseInsensitive":         {kind: rateLimitOptionBool},
+	"RejectUnparseableRequests":             {kind: rateLimitOptionBool},
+	"RateLimitRefundOnCancelEnabled":        {kind: rateLimitOptionBool},
+	"RateLimitSandboxCount":                 {kind: rateLimitOptionInt},
+	"RateLimitSandboxDurationSeconds":       {kind: rateLimitOptionInt, check: CheckRateLimitSandboxDurationSeconds},
+	"RateLimitBackpressureThresholdPercent": {kind: rateLimitOptionInt, check: CheckRateLimitBackpressureThresholdPercent},
+	"RateLimitBackpressureMaxDelayMs":       {kind: rateLimitOptionInt, check: CheckRateLimitBackpressureMaxDelayMs},
+	"TokenQuotaSchedule":                    {kind: rateLimitOptionString, check: CheckTokenQuotaSchedule},
+	"PlaygroundRateLimitEnabled":            {kind: rateLimitOptionBool},
+	"PlaygroundRateLimitDurationMinutes":    {kind: rateLimitOptionInt},
+	"PlaygroundRateLimitCount":              {kind: rateLimitOptionInt},
+	"PlaygroundRateLimitSuccessCount":       {kind: rateLimitOptionInt},
+}
+
+// RateLimitConfigKeys 返回参与导出/导入的全部限流配置项
+func RateLimitConfigKeys() []string {
+	keys := make([]string, 0, len(rateLimitOptions))
+	for key := range rateLimitOptions {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// IsRateLimitConfigOption 判断配置项是否属于限流配置
+func IsRateLimitConfigOption(key string) bool {
+	_, ok := rateLimitOptions[key]
+	return ok
+}
+
+// CheckRateLimitConfigOption 校验导入的单个限流配置项，非限流配置项返回错误
+func CheckRateLimitConfigOption(key string, value string) error {
+	option, ok := rateLimitOptions[key]
+	if !ok {
+		return fmt.Errorf("unknown rate limit option: %s", key)
+	}
+	switch option.kind {
+	case rateLimitOptionInt:
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("option %s must be an integer, got %q", key, value)
+		}
+	case rateLimitOptionBool:
+		if value != "true" && value != "false" {
+			return fmt.Errorf("option %s must be true or false, got %q", key, value)
+		}
+	}
+	if option.check != nil {
+		if err := option.check(value); err != nil {
+			return fmt.Errorf("option %s: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package setting
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
+// 组合限流规则的计数对象
+const (
+	RateLimitRuleDimensionToken = "token" // 按令牌
+	RateLimitRuleDimensionUser  = "user"  // 按用户，同一用户的所有令牌共享
+	RateLimitRuleDimensionIP    = "ip"    // 按客户端 IP
+)
+
+// 组合限流规则的计数指标
+const (
+	RateLimitRuleMetricRequests = "requests" // 请求数（包括失败请求）
+	RateLimitRuleMetricTokens   = "tokens"   // 消耗的 token 数（输入 + 输出），请求完成后计入
+)
+
+// RateLimitRule 一条组合限流规则：dimension 维度在 window_seconds 秒的固定窗口内 metric 不超过 limit。
+// groups 不为空时只对这些令牌分组生效
+type RateLimitRule struct {
+	Name          string   `json:"name"`
+	Dimension     string   `json:"dimension"`
+	Metric        string   `json:"metric"`
+	WindowSeconds int64    `json:"window_seconds"`
+	Limit         int      `json:"limit"`
+	Groups        []string `json:"groups,omitempty"`
+}
+
+// AppliesToGroup 规则是否对该令牌分组生效
+func (r RateLimitRule) AppliesToGroup(group string) bool {
+	return len(r.Groups) == 0 || slices.Contains(r.Groups, group)
+}
+
+// Subject 返回规则在本次请求中的计数对象，缺少对应信息（如未使用令牌）时返回空字符串，规则不生效
+func (r RateLimitRule) Subject(tokenId int, userId int, ip string) string {
+	switch r.Dimension {
+	case RateLimitRuleDimensionToken:
+		if tokenId > 0 {
+			return strconv.Itoa(tokenId)
+		}
+	case RateLimitRuleDimensionUser:
+		if userId > 0 {
+			return strconv.Itoa(userId)
+		}
+	case RateLimitRuleDimensionIP:
+		return ip
+	}
+	return ""
+}
+
+// CounterKey 返回规则计数使用的 key（不含窗口起点）
+func (r RateLimitRule) CounterKey(subject string) string {
+	return fmt.Sprintf("rateLimit:RLR:%s:%s:%s", r.Name, r.Dimension, subject)
+}
+
+// 组合限流规则，按顺序检查，所有规则都满足时才放行，第一条不满足的规则决定拒绝原因，例如：
+//
+//	[{"name":"rpm","dimension":"token","metric":"requests","window_seconds":60,"limit":100},
+//	 {"name":"rpd","dimension":"token","metric":"requests","window_seconds":86400,"limit":2000},
+//	 {"name":"tpd","dimension":"token","metric":"tokens","window_seconds":86400,"limit":50000}]
+//
+// 与 TokenRateLimit* 等固定的限流维度相互独立，同时生效
+var RateLimitRules = []RateLimitRule{}
+var RateLimitRulesMutex sync.RWMutex
+
+// 流式响应每输出约 N 个 token（按数据块估算）就计入按 token 数计数的组合限流规则并检查一次，
+// 超出时在流中返回错误并结束响应（0表示只在请求结束后计入）
+var RateLimitStreamAccountingTokens = 0
+
+var rateLimitRuleNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+func RateLimitRules2JSONString() string {
+	RateLimitRulesMutex.RLock()
+	defer RateLimitRulesMutex.RUnlock()
+
+	jsonBytes, err := json.Marshal(RateLimitRules)
+	if err != nil {
+		common.SysLog("error marshalling rate limit rules: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateRateLimitRulesByJSONString(jsonStr string) error {
+	rules, err := parseRateLimitRules(jsonStr)
+	if err != nil {
+		return err
+	}
+	RateLimitRulesMutex.Lock()
+	defer RateLimitRulesMutex.Unlock()
+
+	RateLimitRules = rules
+	return nil
+}
+
+// GetRateLimitRules 返回当前的组合限流规则
+func GetRateLimitRules() []RateLimitRule {
+	RateLimitRulesMutex.RLock()
+	defer RateLimitRulesMutex.RUnlock()
+
+	return append([]RateLimitRule(nil), RateLimitRules...)
+}
+
+func CheckRateLimitRules(jsonStr string) error {
+	_, err := parseRateLimitRules(jsonStr)
+	return err
+}
+
+func parseRateLimitRules(jsonStr string) ([]RateLimitRule, error) {
+	rules := make([]RateLimitRule, 0)
+	if err := json.Unmarshal([]byte(jsonStr), &rules); err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool, len(rules))
+	for i, rule := range rules {
+		if !rateLimitRuleNamePattern.MatchString(rule.Name) {
+			return nil, fmt.Errorf("rate limit rule %d has invalid name: %q", i, rule.Name)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate rate limit rule name: %s", rule.Name)
+		}
+		names[rule.Name] = true
+		switch rule.Dimension {
+		case RateLimitRuleDimensionToken, RateLimitRuleDimensionUser, RateLimitRuleDimensionIP:
+		default:
+			return nil, fmt.Errorf("rate limit rule %s has unknown dimension: %s", rule.Name, rule.Dimension)
+		}
+		switch rule.Metric {
+		case RateLimitRuleMetricRequests, RateLimitRuleMetricTokens:
+		default:
+			return nil, fmt.Errorf("rate limit rule %s has unknown metric: %s", rule.Name, rule.Metric)
+		}
+		if rule.WindowSeconds <= 0 {
+			return nil, fmt.Errorf("rate limit rule %s must have a positive window", rule.Name)
+		}
+		if rule.Limit <= 0 {
+			return nil, fmt.Errorf("rate limit rule %s must have a positive limit", rule.Name)
+		}
+	}
+	return rules, nil
+}
+
+// HasTokenRateLimitRules 是否配置了按 token 数计数的组合限流规则
+func HasTokenRateLimitRules() bool {
+	RateLimitRulesMutex.RLock()
+	defer RateLimitRulesMutex.RUnlock()
+
+	for _, rule := range RateLimitRules {
+		if rule.Metric == RateLimitRuleMetricTokens {
+			return true
+		}
+	}
+	return false
+}
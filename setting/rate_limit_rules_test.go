@@ -0,0 +1,62 @@
+package setting
+
+import "testing"
+
+func TestCheckRateLimitRules(t *testing.T) {
+	valid := []string{
+		`[]`,
+		`[{"name":"rpm","dimension":"token","metric":"requests","window_seconds":60,"limit":100},
+		  {"name":"rpd","dimension":"user","metric":"requests","window_seconds":86400,"limit":2000},
+		  {"name":"tpd","dimension":"ip","metric":"tokens","window_seconds":86400,"limit":50000,"groups":["vip"]}]`,
+	}
+	for _, value := range valid {
+		if err := CheckRateLimitRules(value); err != nil {
+			t.Errorf("CheckRateLimitRules(%s) = %v, want nil", value, err)
+		}
+	}
+	invalid := []string{
+		`{`,
+		`[{"name":"","dimension":"token","metric":"requests","window_seconds":60,"limit":1}]`,
+		`[{"name":"bad name","dimension":"token","metric":"requests","window_seconds":60,"limit":1}]`,
+		`[{"name":"a","dimension":"token","metric":"requests","window_seconds":60,"limit":1},{"name":"a","dimension":"user","metric":"requests","window_seconds":60,"limit":1}]`,
+		`[{"name":"a","dimension":"model","metric":"requests","window_seconds":60,"limit":1}]`,
+		`[{"name":"a","dimension":"token","metric":"quota","window_seconds":60,"limit":1}]`,
+		`[{"name":"a","dimension":"token","metric":"requests","window_seconds":0,"limit":1}]`,
+		`[{"name":"a","dimension":"token","metric":"requests","window_seconds":60,"limit":0}]`,
+	}
+	for _, value := range invalid {
+		if err := CheckRateLimitRules(value); err == nil {
+			t.Errorf("CheckRateLimitRules(%s) = nil, want error", value)
+		}
+	}
+}
+
+func TestRateLimitRuleSubject(t *testing.T) {
+	cases := []struct {
+		dimension string
+		tokenId   int
+		userId    int
+		ip        string
+		want      string
+	}{
+		{RateLimitRuleDimensionToken, 1, 2, "10.0.0.1", "1"},
+		{RateLimitRuleDimensionToken, 0, 2, "10.0.0.1", ""},
+		{RateLimitRuleDimensionUser, 1, 2, "10.0.0.1", "2"},
+		{RateLimitRuleDimensionUser, 1, 0, "10.0.0.1", ""},
+		{RateLimitRuleDimensionIP, 1, 2, "10.0.0.1", "10.0.0.1"},
+	}
+	for _, tc := range cases {
+		rule := RateLimitRule{Name: "r", Dimension: tc.dimension}
+		if got := rule.Subject(tc.tokenId, tc.userId, tc.ip); got != tc.want {
+			t.Errorf("%s subject = %q, want %q", tc.dimension, got, tc.want)
+		}
+	}
+
+	rule := RateLimitRule{Groups: []string{"vip"}}
+	if !rule.AppliesToGroup("vip") || rule.AppliesToGroup("default") {
+		t.Error("rule with groups should only apply to the listed groups")
+	}
+	if !(RateLimitRule{}).AppliesToGroup("default") {
+		t.Error("rule without groups should apply to every group")
+	}
+}
@@ -0,0 +1,247 @@
+package setting
+
+import "testing"
+
+func TestCheckRateLimitBlockMaxMs(t *testing.T) {
+	for _, value := range []string{"0", "500", "10000"} {
+		if err := CheckRateLimitBlockMaxMs(value); err != nil {
+			t.Errorf("CheckRateLimitBlockMaxMs(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"-1", "abc", ""} {
+		if err := CheckRateLimitBlockMaxMs(value); err == nil {
+			t.Errorf("CheckRateLimitBlockMaxMs(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestCheckRateLimitRejectStatusCode(t *testing.T) {
+	for _, value := range []string{"429", "503", "400", "599"} {
+		if err := CheckRateLimitRejectStatusCode(value); err != nil {
+			t.Errorf("CheckRateLimitRejectStatusCode(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"200", "302", "600", "0", "abc"} {
+		if err := CheckRateLimitRejectStatusCode(value); err == nil {
+			t.Errorf("CheckRateLimitRejectStatusCode(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestCheckShadowRateLimitAlgorithm(t *testing.T) {
+	for _, value := range []string{"", RateLimitAlgorithmTokenBucket, RateLimitAlgorithmSlidingWindow} {
+		if err := CheckShadowRateLimitAlgorithm(value); err != nil {
+			t.Errorf("CheckShadowRateLimitAlgorithm(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"fixed_window", "Sliding_Window", "gcra"} {
+		if err := CheckShadowRateLimitAlgorithm(value); err == nil {
+			t.Errorf("CheckShadowRateLimitAlgorithm(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestCheckRateLimitLowPriorityReservePercent(t *testing.T) {
+	for _, value := range []string{"0", "20", "99"} {
+		if err := CheckRateLimitLowPriorityReservePercent(value); err != nil {
+			t.Errorf("CheckRateLimitLowPriorityReservePercent(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"-1", "100", "abc"} {
+		if err := CheckRateLimitLowPriorityReservePercent(value); err == nil {
+			t.Errorf("CheckRateLimitLowPriorityReservePercent(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestCheckRateLimitKindRejectStatusCode(t *testing.T) {
+	for _, value := range []string{"0", "429", "503"} {
+		if err := CheckRateLimitKindRejectStatusCode(value); err != nil {
+			t.Errorf("CheckRateLimitKindRejectStatusCode(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"200", "600", "abc"} {
+		if err := CheckRateLimitKindRejectStatusCode(value); err == nil {
+			t.Errorf("CheckRateLimitKindRejectStatusCode(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestCheckSuccessLimiterAlgorithm(t *testing.T) {
+	for _, value := range []string{RateLimitAlgorithmSlidingWindow, RateLimitAlgorithmLeakyBucket} {
+		if err := CheckSuccessLimiterAlgorithm(value); err != nil {
+			t.Errorf("CheckSuccessLimiterAlgorithm(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"", RateLimitAlgorithmTokenBucket, "fixed_window"} {
+		if err := CheckSuccessLimiterAlgorithm(value); err == nil {
+			t.Errorf("CheckSuccessLimiterAlgorithm(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestCheckRateLimitStrictGroup(t *testing.T) {
+	for _, value := range []string{RateLimitStrictGroupOff, RateLimitStrictGroupLog, RateLimitStrictGroupReject} {
+		if err := CheckRateLimitStrictGroup(value); err != nil {
+			t.Errorf("CheckRateLimitStrictGroup(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"strict", "LOG"} {
+		if err := CheckRateLimitStrictGroup(value); err == nil {
+			t.Errorf("CheckRateLimitStrictGroup(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestRateLimitCountMethodsFromString(t *testing.T) {
+	t.Cleanup(func() { RateLimitCountMethodsFromString("POST") })
+	RateLimitCountMethodsFromString(" post ,Get,, ")
+	if got := RateLimitCountMethodsToString(); got != "POST,GET" {
+		t.Fatalf("RateLimitCountMethods = %q, want POST,GET", got)
+	}
+	for method, want := range map[string]bool{"POST": true, "GET": true, "OPTIONS": false, "HEAD": false} {
+		if got := IsRateLimitCountMethod(method); got != want {
+			t.Errorf("IsRateLimitCountMethod(%q) = %v, want %v", method, got, want)
+		}
+	}
+}
+
+func TestRateLimitGroupCaseInsensitive(t *testing.T) {
+	t.Cleanup(func() {
+		RateLimitGroupCaseInsensitive = false
+		_ = UpdateTokenRateLimitGroupByJSONString("{}")
+		_ = UpdateUserDailyRateLimitGroupByJSONString("{}")
+	})
+	if err := UpdateTokenRateLimitGroupByJSONString(`{"VIP":[100,50],"vip-Trial":[10,5]}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := UpdateUserDailyRateLimitGroupByJSONString(`{"Enterprise":[5000,4000]}`); err != nil {
+		t.Fatal(err)
+	}
+
+	// 默认精确匹配，大小写不同时回退到全局限制
+	if _, _, found := GetTokenRateLimit("vip"); found {
+		t.Fatal("mixed-case group matched with case-insensitive matching off")
+	}
+	if total, _, found := GetTokenRateLimit("VIP"); !found || total != 100 {
+		t.Fatalf("exact group: total = %d, found = %v", total, found)
+	}
+
+	RateLimitGroupCaseInsensitive = true
+	cases := []struct {
+		group   string
+		total   int
+		success int
+	}{
+		{"vip", 100, 50},
+		{"Vip", 100, 50},
+		{"VIP-TRIAL", 10, 5},
+	}
+	for _, tc := range cases {
+		total, success, found := GetTokenRateLimit(tc.group)
+		if !found || total != tc.total || success != tc.success {
+			t.Errorf("GetTokenRateLimit(%q) = %d, %d, %v, want %d, %d", tc.group, total, success, found, tc.total, tc.success)
+		}
+	}
+	if total, _, found := GetUserDailyRateLimit("ENTERPRISE"); !found || total != 5000 {
+		t.Fatalf("GetUserDailyRateLimit(ENTERPRISE) = %d, %v", total, found)
+	}
+	if _, _, found := GetTokenRateLimit("default"); found {
+		t.Fatal("unconfigured group should not match")
+	}
+}
+
+func TestCheckRateLimitSandboxDurationSeconds(t *testing.T) {
+	for _, value := range []string{"1", "60"} {
+		if err := CheckRateLimitSandboxDurationSeconds(value); err != nil {
+			t.Errorf("CheckRateLimitSandboxDurationSeconds(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"0", "-5", "abc"} {
+		if err := CheckRateLimitSandboxDurationSeconds(value); err == nil {
+			t.Errorf("CheckRateLimitSandboxDurationSeconds(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestCheckModelRequestRateLimitGroup(t *testing.T) {
+	for _, value := range []string{`{"default":[0,100]}`, `{"default":[100,0]}`, `{"default":[0,0]}`, `{"vip":[60,30]}`} {
+		if err := CheckModelRequestRateLimitGroup(value); err != nil {
+			t.Errorf("CheckModelRequestRateLimitGroup(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{`{"default":[-1,100]}`, `{"default":[100,-1]}`, `{"default":[3000000000,0]}`, `[0,100]`} {
+		if err := CheckModelRequestRateLimitGroup(value); err == nil {
+			t.Errorf("CheckModelRequestRateLimitGroup(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestCheckRateLimitBackpressure(t *testing.T) {
+	for _, value := range []string{"0", "50", "99"} {
+		if err := CheckRateLimitBackpressureThresholdPercent(value); err != nil {
+			t.Errorf("CheckRateLimitBackpressureThresholdPercent(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"-1", "100", "abc"} {
+		if err := CheckRateLimitBackpressureThresholdPercent(value); err == nil {
+			t.Errorf("CheckRateLimitBackpressureThresholdPercent(%q) = nil, want error", value)
+		}
+	}
+	for _, value := range []string{"1", "1000"} {
+		if err := CheckRateLimitBackpressureMaxDelayMs(value); err != nil {
+			t.Errorf("CheckRateLimitBackpressureMaxDelayMs(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"0", "-5", "abc"} {
+		if err := CheckRateLimitBackpressureMaxDelayMs(value); err == nil {
+			t.Errorf("CheckRateLimitBackpressureMaxDelayMs(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestCheckRateLimitWindowMode(t *testing.T) {
+	for _, value := range []string{RateLimitWindowRolling, RateLimitWindowFixed} {
+		if err := CheckRateLimitWindowMode(value); err != nil {
+			t.Errorf("CheckRateLimitWindowMode(%q) = %v, want nil", value, err)
+		}
+	}
+	for _, value := range []string{"sliding", "Fixed", ""} {
+		if err := CheckRateLimitWindowMode(value); err == nil {
+			t.Errorf("CheckRateLimitWindowMode(%q) = nil, want error", value)
+		}
+	}
+}
+
+func TestScaleRateLimitByChannels(t *testing.T) {
+	oldBaseline, oldMax := RateLimitChannelScalingBaseline, RateLimitChannelScalingMaxPercent
+	t.Cleanup(func() { RateLimitChannelScalingBaseline, RateLimitChannelScalingMaxPercent = oldBaseline, oldMax })
+
+	RateLimitChannelScalingBaseline = 0
+	if got := ScaleRateLimitByChannels(100, 1); got != 100 {
+		t.Fatalf("scaling disabled: got %d, want 100", got)
+	}
+
+	RateLimitChannelScalingBaseline, RateLimitChannelScalingMaxPercent = 4, 100
+	cases := []struct {
+		limit, active, want int
+	}{
+		{100, 4, 100},
+		{100, 3, 75},
+		{100, 1, 25},
+		{6, 1, 1},
+		{100, 0, 1},
+		{100, 8, 100}, // 默认只收紧不放宽
+		{0, 1, 0},     // 不限制时不缩放
+	}
+	for _, tc := range cases {
+		if got := ScaleRateLimitByChannels(tc.limit, tc.active); got != tc.want {
+			t.Errorf("ScaleRateLimitByChannels(%d, %d) = %d, want %d", tc.limit, tc.active, got, tc.want)
+		}
+	}
+
+	RateLimitChannelScalingMaxPercent = 150
+	if got := ScaleRateLimitByChannels(100, 8); got != 150 {
+		t.Fatalf("loosened limit = %d, want capped at 150", got)
+	}
+}
@@ -1,5 +1,11 @@
 package types
 
+import (
+	"fmt"
+
+	"github.com/QuantumNous/new-api/common"
+)
+
 type ChannelError struct {
 	ChannelId   int    `json:"channel_id"`
 	ChannelType int    `json:"channel_type"`
@@ -19,3 +25,39 @@ func NewChannelError(channelId int, channelType int, channelName string, isMulti
 		UsingKey:    usingKey,
 	}
 }
+
+// ChannelStatusDetail 渠道状态变更的结构化原因，序列化后保存在渠道 other_info 的 status_detail 中，便于前端展示与筛选
+type ChannelStatusDetail struct {
+	Reason     string    `json:"reason"`
+	Code       ErrorCode `json:"code,omitempty"`
+	StatusCode int       `json:"status_code,omitempty"`
+	ErrorType  ErrorType `json:"error_type,omitempty"`
+	Timestamp  int64     `json:"timestamp"`
+	ResumeAt   int64     `json:"resume_at,omitempty"` // 暂停的渠道自动恢复的时间，0 表示不自动恢复
+}
+
+// NewChannelStatusDetail 根据原因及导致状态变更的错误（可为空）构建结构化原因
+func NewChannelStatusDetail(reason string, err *NewAPIError) *ChannelStatusDetail {
+	detail := &ChannelStatusDetail{
+		Reason:    reason,
+		Timestamp: common.GetTimestamp(),
+	}
+	if err != nil {
+		detail.Code = err.GetErrorCode()
+		detail.StatusCode = err.StatusCode
+		detail.ErrorType = err.GetErrorType()
+	}
+	return detail
+}
+
+// ModelTimeoutError 上游请求超过 ModelRequestTimeout 中为该模型配置的超时时间
+type ModelTimeoutError struct {
+	ChannelId   int
+	ModelName   string
+	Timeout     int // 超时时间，单位秒
+	Consecutive int // 该渠道该模型连续超时的次数（含本次）
+}
+
+func (e *ModelTimeoutError) Error() string {
+	return fmt.Sprintf("model %s request timeout after %d seconds on channel #%d", e.ModelName, e.Timeout, e.ChannelId)
+}
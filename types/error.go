@@ -56,6 +56,9 @@ const (
 	ErrorCodeChannelAwsClientError        ErrorCode = "channel:aws_client_error"
 	ErrorCodeChannelInvalidKey            ErrorCode = "channel:invalid_key"
 	ErrorCodeChannelResponseTimeExceeded  ErrorCode = "channel:response_time_exceeded"
+	ErrorCodeChannelConcurrencyExceeded   ErrorCode = "channel:concurrency_exceeded"
+	ErrorCodeChannelModelRequestTimeout   ErrorCode = "channel:model_request_timeout"
+	ErrorCodeChannelModelNotSupported     ErrorCode = "channel:model_not_supported"
 
 	// client request error
 	ErrorCodeReadRequestBodyFailed ErrorCode = "read_request_body_failed"
@@ -82,6 +85,8 @@ const (
 	// quota error
 	ErrorCodeInsufficientUserQuota      ErrorCode = "insufficient_user_quota"
 	ErrorCodePreConsumeTokenQuotaFailed ErrorCode = "pre_consume_token_quota_failed"
+	ErrorCodeTokenDailyQuotaExceeded    ErrorCode = "token_daily_quota_exceeded"
+	ErrorCodeTokenPeriodQuotaExceeded   ErrorCode = "token_period_quota_exceeded"
 )
 
 type NewAPIError struct {
@@ -145,6 +150,30 @@ func (e *NewAPIError) SetMessage(message string) {
 	e.Err = errors.New(message)
 }
 
+// UpstreamErrorCodes 返回上游错误体中携带的结构化错误码（OpenAI 格式的 code 与 type，Claude 格式的 type）
+func (e *NewAPIError) UpstreamErrorCodes() []string {
+	if e == nil {
+		return nil
+	}
+	codes := make([]string, 0, 2)
+	switch relayError := e.RelayError.(type) {
+	case OpenAIError:
+		if relayError.Code != nil {
+			if code := fmt.Sprintf("%v", relayError.Code); code != "" {
+				codes = append(codes, code)
+			}
+		}
+		if relayError.Type != "" {
+			codes = append(codes, relayError.Type)
+		}
+	case ClaudeError:
+		if relayError.Type != "" {
+			codes = append(codes, relayError.Type)
+		}
+	}
+	return codes
+}
+
 func (e *NewAPIError) ToOpenAIError() OpenAIError {
 	var result OpenAIError
 	switch e.errorType {
@@ -207,6 +236,42 @@ func (e *NewAPIError) ToClaudeError() ClaudeError {
 	return result
 }
 
+// ProblemDetails RFC 7807 application/problem+json 格式的错误
+type ProblemDetails struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	Code   string `json:"code,omitempty"`
+	// 扩展成员，仅在开启 ExposeChannelInError 且调用方为管理员时返回
+	Channel *ErrorChannel `json:"channel,omitempty"`
+}
+
+// ErrorChannel 错误响应中附带的渠道信息，Label 为渠道标签，未设置标签时为渠道名称
+type ErrorChannel struct {
+	Id    int    `json:"id"`
+	Label string `json:"label"`
+}
+
+func (e *NewAPIError) ToProblemDetails() ProblemDetails {
+	openAIError := e.ToOpenAIError()
+	code := fmt.Sprintf("%v", openAIError.Code)
+	if openAIError.Code == nil {
+		code = ""
+	}
+	result := ProblemDetails{
+		Type:   "about:blank",
+		Title:  http.StatusText(e.StatusCode),
+		Status: e.StatusCode,
+		Detail: openAIError.Message,
+		Code:   code,
+	}
+	if code != "" {
+		result.Type = "urn:new-api:error:" + code
+	}
+	return result
+}
+
 type NewAPIErrorOptions func(*NewAPIError)
 
 func NewError(err error, errorCode ErrorCode, ops ...NewAPIErrorOptions) *NewAPIError {